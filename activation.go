@@ -0,0 +1,187 @@
+package compositor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// activationTimeout is how long an activation token can be used for
+const activationTimeout = 30 * time.Second
+
+// maxActivationTokens caps the tokens waiting to be used; the oldest go
+// first
+const maxActivationTokens = 64
+
+type activationToken struct {
+	appID   string
+	pid     int
+	expires time.Time
+}
+
+// ActivationTokens hands out xdg-activation style tokens and matches the
+// windows that use them. The wayland package has no xdg_activation_v1
+// global, so a token is used by the window its launch maps: one from a
+// process descended from the launched one, or with the token's app id.
+// Tokens are handed to apps in XDG_ACTIVATION_TOKEN and DESKTOP_STARTUP_ID.
+type ActivationTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*activationToken
+	order  []string
+	// parentOf returns a process's parent, 0 when unknown
+	parentOf func(pid int) int
+}
+
+// NewActivationTokens creates an empty set of tokens
+func NewActivationTokens() *ActivationTokens {
+	return &ActivationTokens{tokens: make(map[string]*activationToken), parentOf: processParent}
+}
+
+// Issue creates a token for a window with appID, or any app when it is
+// empty, until activationTimeout after now
+func (a *ActivationTokens) Issue(appID string, now time.Time) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("create activation token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(now)
+	if len(a.order) >= maxActivationTokens {
+		delete(a.tokens, a.order[0])
+		a.order = a.order[1:]
+	}
+	a.tokens[token] = &activationToken{appID: appID, expires: now.Add(activationTimeout)}
+	a.order = append(a.order, token)
+	return token, nil
+}
+
+// Bind ties a token to the process it was launched with, so windows of
+// that process or its children use it
+func (a *ActivationTokens) Bind(token string, pid int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t, ok := a.tokens[token]; ok {
+		t.pid = pid
+	}
+}
+
+// Activate finds and uses up the token of a newly mapped window from
+// process pid with appID, reporting whether there was one
+func (a *ActivationTokens) Activate(pid int, appID string, now time.Time) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(now)
+	if len(a.order) == 0 {
+		return "", false
+	}
+
+	ancestors := make(map[int]bool)
+	for p, depth := pid, 0; p > 1 && depth < 64 && !ancestors[p]; p, depth = a.parentOf(p), depth+1 {
+		ancestors[p] = true
+	}
+	for _, token := range a.order {
+		t := a.tokens[token]
+		if (t.pid != 0 && ancestors[t.pid]) || (t.appID != "" && t.appID == appID) {
+			a.remove(token)
+			return token, true
+		}
+	}
+	return "", false
+}
+
+func (a *ActivationTokens) expire(now time.Time) {
+	for len(a.order) > 0 && now.After(a.tokens[a.order[0]].expires) {
+		delete(a.tokens, a.order[0])
+		a.order = a.order[1:]
+	}
+}
+
+func (a *ActivationTokens) remove(token string) {
+	delete(a.tokens, token)
+	for i, t := range a.order {
+		if t == token {
+			a.order = append(a.order[:i], a.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// activationEnv is the environment that hands token to a launched app
+func activationEnv(token string) []string {
+	return []string{"XDG_ACTIVATION_TOKEN=" + token, "DESKTOP_STARTUP_ID=" + token}
+}
+
+// proxiedPIDs are the processes on the other end of connections served
+// through a proxy, such as -wl-debug's, keyed by the end of the proxy's
+// socket pair the compositor serves, whose own peer is the compositor
+var proxiedPIDs sync.Map
+
+// clientPID returns the process on the other end of a client's socket, 0
+// when it cannot be found
+func clientPID(c *wayland.Client) int {
+	if c == nil {
+		return 0
+	}
+	return connPID(c.UnixConnection)
+}
+
+// connPID returns the process on the other end of conn, the one behind
+// the proxy for a connection a proxy serves, 0 when it cannot be found
+func connPID(conn *net.UnixConn) int {
+	if conn == nil {
+		return 0
+	}
+	if pid, ok := proxiedPIDs.Load(conn); ok {
+		return pid.(int)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var pid int
+	raw.Control(func(fd uintptr) {
+		if cred, err := syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED); err == nil {
+			pid = int(cred.Pid)
+		}
+	})
+	return pid
+}
+
+// proxyPeer records that served, the end of a proxy the compositor
+// serves, stands for conn, the connection the proxy reads, so its peer is
+// taken to be conn's. Call it before either is closed; the returned
+// function forgets served once the proxy is done.
+func proxyPeer(served, conn *net.UnixConn) (forget func()) {
+	if pid := connPID(conn); pid != 0 {
+		proxiedPIDs.Store(served, pid)
+	}
+	return func() { proxiedPIDs.Delete(served) }
+}
+
+// processParent returns a process's parent from /proc, 0 when unknown
+func processParent(pid int) int {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0
+	}
+	// The command name is in parentheses and may hold anything, so the
+	// fields are counted from the last ')': state, then the parent
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
@@ -0,0 +1,119 @@
+package compositor
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestActivationTokens(t *testing.T) {
+	now := time.Now()
+	a := NewActivationTokens()
+	// 30 was launched by the compositor and started 31, which started 32
+	a.parentOf = func(pid int) int {
+		return map[int]int{32: 31, 31: 30, 30: 1}[pid]
+	}
+
+	launched, _ := a.Issue("", now)
+	a.Bind(launched, 30)
+	a.Issue("foot", now)
+
+	if _, ok := a.Activate(99, "firefox", now); ok {
+		t.Error("an unrelated window used a token")
+	}
+	if token, ok := a.Activate(32, "", now); !ok || token != launched {
+		t.Errorf("grandchild of the launch: token %q, %v; want %q", token, ok, launched)
+	}
+	if _, ok := a.Activate(32, "", now); ok {
+		t.Error("a token was used twice")
+	}
+	if _, ok := a.Activate(99, "foot", now.Add(activationTimeout+time.Second)); ok {
+		t.Error("an expired token was used")
+	}
+
+	byApp, _ := a.Issue("foot", now)
+	if token, ok := a.Activate(99, "foot", now); !ok || token != byApp {
+		t.Errorf("window by app id: token %q, %v; want %q", token, ok, byApp)
+	}
+}
+
+func TestProcessParent(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc")
+	}
+	if got, want := processParent(os.Getpid()), os.Getppid(); got != want {
+		t.Errorf("processParent = %d, want %d", got, want)
+	}
+}
+
+// dialEnv, set to a socket path, makes the test binary run by
+// TestActivationWhileTracing a client that connects to it and waits for
+// its stdin to close
+const dialEnv = "COMPOSITOR_TEST_DIAL"
+
+func TestActivationWhileTracing(t *testing.T) {
+	if path := os.Getenv(dialEnv); path != "" {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, os.Stdin)
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), "wayland-test")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// Another process connects, as a launched app does
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivationWhileTracing$")
+	cmd.Env = append(os.Environ(), dialEnv+"="+path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+	conn, err := listener.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer, err := NewWireTracer(false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	traced, err := tracer.Wrap(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served, err := NewScreencopy().Wrap(traced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer served.Close()
+	client := wayland.MakeClient(served)
+	if pid := clientPID(client); pid != cmd.Process.Pid {
+		t.Fatalf("Expected the traced client's pid %d, got %d", cmd.Process.Pid, pid)
+	}
+
+	now := time.Now()
+	a := NewActivationTokens()
+	launched, _ := a.Issue("", now)
+	a.Bind(launched, cmd.Process.Pid)
+	if token, ok := a.Activate(clientPID(client), "", now); !ok || token != launched {
+		t.Errorf("Traced launch: token %q, %v; want %q", token, ok, launched)
+	}
+}
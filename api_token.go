@@ -0,0 +1,80 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// apiTokenFileName is the file in $XDG_RUNTIME_DIR the compositor keeps
+// the control API token it generated in, where pupctl looks for it
+const apiTokenFileName = "wayland-compositor-api-token"
+
+// defaultAPITokenFile is the token file the compositor and pupctl share
+// when neither is told another, or "" without $XDG_RUNTIME_DIR
+func defaultAPITokenFile() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, apiTokenFileName)
+}
+
+// loadAPIToken returns the token control API requests must carry: token
+// when it is set, or else the one kept in file. A missing file is created
+// with a new random token that only the user can read. With neither the
+// token is random and kept nowhere, and generated reports so.
+func loadAPIToken(token, file string) (loaded string, generated bool, err error) {
+	if token != "" {
+		return token, false, nil
+	}
+	if file == "" {
+		token, err := newAPIToken()
+		return token, true, err
+	}
+	data, err := os.ReadFile(file)
+	if err == nil {
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", false, fmt.Errorf("%s is empty", file)
+		}
+		return token, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", false, err
+	}
+	if token, err = newAPIToken(); err != nil {
+		return "", false, err
+	}
+	if err := os.WriteFile(file, []byte(token+"\n"), 0o600); err != nil {
+		return "", false, err
+	}
+	return token, false, nil
+}
+
+// newAPIToken is 32 random bytes in hex
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// bearerTokenMatches reports whether r carries "Authorization: Bearer
+// <token>". Nothing matches an empty token.
+func bearerTokenMatches(r *http.Request, token string) bool {
+	scheme, got, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) == 1
+}
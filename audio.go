@@ -0,0 +1,232 @@
+package compositor
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"math/cmplx"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// audioFFTSize is the samples per spectrum, about 21ms at 48kHz
+	audioFFTSize = 1024
+	// The spectrum is split into audioBandCount log-spaced bands between
+	// audioMinFreq and audioMaxFreq
+	audioBandCount = 8
+	audioMinFreq   = 40.0
+	audioMaxFreq   = 16000.0
+	// audioFloorDB is the level shown as 0; full scale is 1
+	audioFloorDB = -60.0
+	// audioBassFreq is the top of the bands averaged into Bass
+	audioBassFreq = 200.0
+
+	audioMaxBackoff = 30 * time.Second
+)
+
+// AudioSpectrum is what the model reacts to, each value from 0 (silence,
+// or below audioFloorDB) to 1 (full scale)
+type AudioSpectrum struct {
+	Bands []float32 `json:"bands"`
+	Bass  float32   `json:"bass"`
+	Level float32   `json:"level"`
+}
+
+// AudioAnalyzer turns captured samples into a spectrum. Write is called
+// from the capture goroutine, Update and Spectrum from the render loop.
+type AudioAnalyzer struct {
+	SampleRate int
+	// Decay is how long a band takes to fall about 63% of the way after
+	// a peak; rises are followed at once so beats stay sharp
+	Decay time.Duration
+
+	mu      sync.Mutex
+	samples []float32 // ring of the latest audioFFTSize samples
+	next    int
+
+	spectrum AudioSpectrum
+	updated  time.Time
+}
+
+// NewAudioAnalyzer creates an analyzer for mono samples at sampleRate
+func NewAudioAnalyzer(sampleRate int) *AudioAnalyzer {
+	return &AudioAnalyzer{
+		SampleRate: sampleRate,
+		Decay:      150 * time.Millisecond,
+		samples:    make([]float32, audioFFTSize),
+		spectrum:   AudioSpectrum{Bands: make([]float32, audioBandCount)},
+	}
+}
+
+// Write adds samples from -1 to 1
+func (a *AudioAnalyzer) Write(samples []float32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range samples {
+		a.samples[a.next] = s
+		a.next = (a.next + 1) % len(a.samples)
+	}
+}
+
+// Update analyzes the latest samples and returns the smoothed spectrum
+func (a *AudioAnalyzer) Update(now time.Time) AudioSpectrum {
+	window := make([]float32, 0, audioFFTSize)
+	a.mu.Lock()
+	window = append(window, a.samples[a.next:]...)
+	window = append(window, a.samples[:a.next]...)
+	a.mu.Unlock()
+
+	frame := analyzeAudio(window, a.SampleRate)
+	k := float32(0)
+	if !a.updated.IsZero() && a.Decay > 0 {
+		k = float32(math.Exp(-float64(now.Sub(a.updated)) / float64(a.Decay)))
+	}
+	decay := func(current, target float32) float32 {
+		if target >= current {
+			return target
+		}
+		return target + (current-target)*k
+	}
+	for i := range a.spectrum.Bands {
+		a.spectrum.Bands[i] = decay(a.spectrum.Bands[i], frame.Bands[i])
+	}
+	a.spectrum.Bass = decay(a.spectrum.Bass, frame.Bass)
+	a.spectrum.Level = decay(a.spectrum.Level, frame.Level)
+	a.updated = now
+	return a.Spectrum()
+}
+
+// Spectrum returns the spectrum from the last Update
+func (a *AudioAnalyzer) Spectrum() AudioSpectrum {
+	s := a.spectrum
+	s.Bands = slices.Clone(s.Bands)
+	return s
+}
+
+// analyzeAudio computes the band levels of a Hann-windowed FFT of samples,
+// whose length must be a power of two
+func analyzeAudio(samples []float32, sampleRate int) AudioSpectrum {
+	n := len(samples)
+	x := make([]complex128, n)
+	var power float64
+	for i, s := range samples {
+		hann := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n))
+		x[i] = complex(float64(s)*hann, 0)
+		power += float64(s) * float64(s)
+	}
+	fft(x)
+
+	spectrum := AudioSpectrum{Bands: make([]float32, audioBandCount)}
+	binHz := float64(sampleRate) / float64(n)
+	top := min(audioMaxFreq, float64(sampleRate)/2)
+	bassBands := 0
+	for b := range spectrum.Bands {
+		lo := audioMinFreq * math.Pow(top/audioMinFreq, float64(b)/audioBandCount)
+		hi := audioMinFreq * math.Pow(top/audioMinFreq, float64(b+1)/audioBandCount)
+		// A sine at full scale peaks at n/4 once Hann-windowed
+		var peak float64
+		for bin := max(1, int(lo/binHz)); bin <= min(n/2, int(hi/binHz)); bin++ {
+			peak = max(peak, cmplx.Abs(x[bin])/(float64(n)/4))
+		}
+		spectrum.Bands[b] = decibelLevel(peak)
+		if hi <= audioBassFreq || b == 0 {
+			spectrum.Bass += spectrum.Bands[b]
+			bassBands++
+		}
+	}
+	spectrum.Bass /= float32(bassBands)
+	// The peak of a sine with this RMS
+	spectrum.Level = decibelLevel(math.Sqrt(2 * power / float64(n)))
+	return spectrum
+}
+
+// decibelLevel maps an amplitude, 1 being full scale, onto 0-1 over
+// audioFloorDB to 0dB
+func decibelLevel(amplitude float64) float32 {
+	if amplitude <= 0 {
+		return 0
+	}
+	db := 20 * math.Log10(amplitude)
+	return float32(min(1, max(0, 1-db/audioFloorDB)))
+}
+
+// fft is an in-place iterative radix-2 FFT; len(x) must be a power of two
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := range size / 2 {
+				even, odd := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = even+odd, even-odd
+				w *= step
+			}
+		}
+	}
+}
+
+// captureAudio runs command, which should write raw signed 16-bit
+// little-endian mono PCM to stdout, feeding it to a until the process
+// exits, and restarts it with backoff
+func captureAudio(command string, a *AudioAnalyzer) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := runAudioCapture(command, a)
+		if time.Since(start) > audioMaxBackoff {
+			backoff = time.Second
+		}
+		log.Printf("Audio capture %q stopped: %v; restarting in %v", command, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, audioMaxBackoff)
+	}
+}
+
+// runAudioCapture runs the capture command once
+func runAudioCapture(command string, a *AudioAnalyzer) error {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err = readPCM(stdout, a)
+	cmd.Process.Kill()
+	cmd.Wait()
+	return err
+}
+
+// readPCM feeds signed 16-bit little-endian samples from r to a, about
+// 5ms at a time at 48kHz
+func readPCM(r io.Reader, a *AudioAnalyzer) error {
+	buf := make([]byte, 512)
+	samples := make([]float32, len(buf)/2)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		for i := range samples {
+			samples[i] = float32(int16(binary.LittleEndian.Uint16(buf[i*2:]))) / 32768
+		}
+		a.Write(samples)
+	}
+}
@@ -0,0 +1,91 @@
+package compositor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// sine returns audioFFTSize samples of a sine at freq and amplitude
+func sine(freq, amplitude float64, sampleRate int) []float32 {
+	samples := make([]float32, audioFFTSize)
+	for i := range samples {
+		samples[i] = float32(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate)))
+	}
+	return samples
+}
+
+func TestAnalyzeAudioFindsTheBand(t *testing.T) {
+	spectrum := analyzeAudio(sine(100, 1, 48000), 48000)
+	if len(spectrum.Bands) != audioBandCount {
+		t.Fatalf("got %d bands, want %d", len(spectrum.Bands), audioBandCount)
+	}
+	// 100Hz falls in the second band, 85-179Hz
+	if spectrum.Bands[1] < 0.9 {
+		t.Errorf("band 1 = %v, want near full scale", spectrum.Bands[1])
+	}
+	for _, b := range []int{4, 5, 6, 7} {
+		if spectrum.Bands[b] > 0.3 {
+			t.Errorf("band %d = %v for a 100Hz sine, want little", b, spectrum.Bands[b])
+		}
+	}
+	if spectrum.Bass < 0.5 {
+		t.Errorf("Bass = %v, want most of full scale", spectrum.Bass)
+	}
+	if spectrum.Level < 0.95 {
+		t.Errorf("Level = %v, want full scale", spectrum.Level)
+	}
+
+	treble := analyzeAudio(sine(5000, 0.1, 48000), 48000)
+	if treble.Bass > 0.2 {
+		t.Errorf("Bass = %v for a 5kHz sine, want little", treble.Bass)
+	}
+	// -20dB is two thirds of the way up from the -60dB floor
+	if math.Abs(float64(treble.Level)-2.0/3) > 0.02 {
+		t.Errorf("Level = %v at -20dB, want 0.67", treble.Level)
+	}
+
+	if silence := analyzeAudio(make([]float32, audioFFTSize), 48000); silence.Level != 0 || silence.Bass != 0 {
+		t.Errorf("silence = %+v, want zero", silence)
+	}
+}
+
+func TestAudioAnalyzerDecays(t *testing.T) {
+	a := NewAudioAnalyzer(48000)
+	now := time.Unix(0, 0)
+	a.Write(sine(100, 1, 48000))
+	loud := a.Update(now).Level
+
+	a.Write(make([]float32, audioFFTSize))
+	now = now.Add(a.Decay)
+	got := a.Update(now).Level
+	if want := loud * float32(math.Exp(-1)); math.Abs(float64(got-want)) > 0.01 {
+		t.Errorf("Level one Decay after silence = %v, want %v", got, want)
+	}
+
+	a.Write(sine(100, 1, 48000))
+	if got := a.Update(now.Add(time.Millisecond)).Level; got < loud-0.01 {
+		t.Errorf("Level = %v after the sound came back, want an immediate rise to %v", got, loud)
+	}
+}
+
+func TestReadPCM(t *testing.T) {
+	var pcm bytes.Buffer
+	for i := range 256 {
+		v := int16(0)
+		if i == 255 {
+			v = -16384
+		}
+		binary.Write(&pcm, binary.LittleEndian, v)
+	}
+	a := NewAudioAnalyzer(48000)
+	if err := readPCM(&pcm, a); err != io.EOF {
+		t.Fatalf("readPCM = %v, want io.EOF", err)
+	}
+	if got := a.samples[(a.next+len(a.samples)-1)%len(a.samples)]; got != -0.5 {
+		t.Errorf("last sample = %v, want -0.5", got)
+	}
+}
@@ -0,0 +1,162 @@
+package compositor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BacklightStep is a scheduled backlight level, from a time of day until
+// the next step
+type BacklightStep struct {
+	// At is the time of day, as the time since midnight
+	At    time.Duration
+	Level float64
+}
+
+// BacklightSchedule is the -backlight-schedule flag, sorted by time of day
+type BacklightSchedule []BacklightStep
+
+// parseBacklightLevel parses a level from 0, off, to 1, full brightness
+func parseBacklightLevel(value string) (float64, error) {
+	level, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid level %q", value)
+	}
+	if err := checkBacklightLevel(level); err != nil {
+		return 0, err
+	}
+	return level, nil
+}
+
+func checkBacklightLevel(level float64) error {
+	if level < 0 || level > 1 {
+		return fmt.Errorf("level %v is not between 0 and 1", level)
+	}
+	return nil
+}
+
+// parseBacklightSchedule parses time=level pairs, e.g. "07:00=1,22:30=0.4"
+func parseBacklightSchedule(value string) (BacklightSchedule, error) {
+	var schedule BacklightSchedule
+	if value == "" {
+		return schedule, nil
+	}
+	seen := map[time.Duration]bool{}
+	for _, pair := range strings.Split(value, ",") {
+		clock, levelText, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not time=level", pair)
+		}
+		t, err := time.Parse("15:04", clock)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q, want HH:MM", clock)
+		}
+		at := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if seen[at] {
+			return nil, fmt.Errorf("%s is listed twice", clock)
+		}
+		seen[at] = true
+		level, err := parseBacklightLevel(levelText)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", clock, err)
+		}
+		schedule = append(schedule, BacklightStep{At: at, Level: level})
+	}
+	sort.Slice(schedule, func(i, j int) bool { return schedule[i].At < schedule[j].At })
+	return schedule, nil
+}
+
+// At returns the level scheduled at now and when its step began, in now's
+// time zone. Before the first step of the day the last one of the day
+// before holds.
+func (s BacklightSchedule) At(now time.Time) (level float64, since time.Time) {
+	if len(s) == 0 {
+		return 1, time.Time{}
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	elapsed := now.Sub(midnight)
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].At <= elapsed {
+			return s[i].Level, midnight.Add(s[i].At)
+		}
+	}
+	last := s[len(s)-1]
+	return last.Level, midnight.AddDate(0, 0, -1).Add(last.At)
+}
+
+// BacklightInfo describes the backlight for the control API
+type BacklightInfo struct {
+	Level float64 `json:"level"`
+	// Manual is set while a level set through the API overrides the
+	// schedule
+	Manual bool `json:"manual"`
+	// Backend is how the level is shown: there is no KMS backend with
+	// a panel to drive, so the preview window is dimmed
+	Backend string `json:"backend"`
+}
+
+// Backlight is the brightness of the preview window, standing in for a
+// panel's backlight. A level set through the API holds until the next
+// scheduled step begins.
+type Backlight struct {
+	mu       sync.Mutex
+	schedule BacklightSchedule
+	level    float64
+	setAt    time.Time
+}
+
+// NewBacklight starts at level, or at the scheduled level when there is a
+// schedule
+func NewBacklight(level float64, schedule BacklightSchedule) *Backlight {
+	return &Backlight{schedule: schedule, level: level}
+}
+
+// Set changes the level until the next scheduled step
+func (b *Backlight) Set(level float64, now time.Time) error {
+	if err := checkBacklightLevel(level); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.level, b.setAt = level, now
+	return nil
+}
+
+// SetSchedule replaces the schedule, dropping a level set by hand
+func (b *Backlight) SetSchedule(schedule BacklightSchedule) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.schedule = schedule
+	b.setAt = time.Time{}
+}
+
+func (b *Backlight) current(now time.Time) (level float64, manual bool) {
+	if len(b.schedule) == 0 {
+		return b.level, false
+	}
+	scheduled, since := b.schedule.At(now)
+	if !b.setAt.IsZero() && !b.setAt.Before(since) {
+		return b.level, true
+	}
+	return scheduled, false
+}
+
+// Level returns the level to show at now
+func (b *Backlight) Level(now time.Time) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	level, _ := b.current(now)
+	return level
+}
+
+// Info describes the backlight at now
+func (b *Backlight) Info(now time.Time) BacklightInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	level, manual := b.current(now)
+	return BacklightInfo{Level: level, Manual: manual, Backend: "dim"}
+}
@@ -0,0 +1,73 @@
+package compositor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBacklightSchedule(t *testing.T) {
+	schedule, err := parseBacklightSchedule("22:30=0.4, 07:00=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BacklightSchedule{{7 * time.Hour, 1}, {22*time.Hour + 30*time.Minute, 0.4}}
+	if len(schedule) != len(want) || schedule[0] != want[0] || schedule[1] != want[1] {
+		t.Errorf("schedule = %v, want %v", schedule, want)
+	}
+	for _, bad := range []string{"07:00", "7am=1", "07:00=2", "07:00=1,07:00=0.5"} {
+		if _, err := parseBacklightSchedule(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestBacklightScheduleAt(t *testing.T) {
+	schedule := BacklightSchedule{{7 * time.Hour, 1}, {22 * time.Hour, 0.4}}
+	day := func(d, h int) time.Time { return time.Date(2024, 5, d, h, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		now   time.Time
+		level float64
+		since time.Time
+	}{
+		{day(10, 12), 1, day(10, 7)},
+		{day(10, 23), 0.4, day(10, 22)},
+		// Before the first step the evening's level still holds
+		{day(11, 3), 0.4, day(10, 22)},
+	}
+	for _, tt := range tests {
+		level, since := schedule.At(tt.now)
+		if level != tt.level || !since.Equal(tt.since) {
+			t.Errorf("At(%v) = %v, %v, want %v, %v", tt.now, level, since, tt.level, tt.since)
+		}
+	}
+}
+
+func TestBacklightOverrideLastsUntilNextStep(t *testing.T) {
+	schedule := BacklightSchedule{{7 * time.Hour, 1}, {22 * time.Hour, 0.4}}
+	at := func(h int) time.Time { return time.Date(2024, 5, 10, h, 0, 0, 0, time.UTC) }
+	b := NewBacklight(1, schedule)
+	if err := b.Set(0.7, at(12)); err != nil {
+		t.Fatal(err)
+	}
+	if info := b.Info(at(13)); info.Level != 0.7 || !info.Manual {
+		t.Errorf("after Set: %+v", info)
+	}
+	if info := b.Info(at(22)); info.Level != 0.4 || info.Manual {
+		t.Errorf("at the next step: %+v", info)
+	}
+	if err := b.Set(1.5, at(23)); err == nil {
+		t.Error("level above 1 accepted")
+	}
+}
+
+func TestBacklightWithoutSchedule(t *testing.T) {
+	b := NewBacklight(0.8, nil)
+	now := time.Now()
+	if level := b.Level(now); level != 0.8 {
+		t.Errorf("level = %v, want 0.8", level)
+	}
+	b.Set(0.3, now)
+	if info := b.Info(now.Add(24 * time.Hour)); info.Level != 0.3 || info.Manual {
+		t.Errorf("info = %+v", info)
+	}
+}
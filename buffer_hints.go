@@ -0,0 +1,75 @@
+package compositor
+
+import (
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// BufferHints tells clients which buffer scale and transform to render at
+// through wl_surface.preferred_buffer_scale/transform. Hidden surfaces are
+// asked for the smallest scale so they stop spending time on detail nobody
+// can see. Events are only sent when the preferred value changes.
+type BufferHints struct {
+	// Scale is the preferred buffer scale for visible surfaces
+	Scale int32
+	// HiddenScale is the preferred buffer scale for occluded surfaces
+	HiddenScale int32
+
+	sent map[surfaceKey]int32
+}
+
+// NewBufferHints creates hints that ask visible surfaces for scale and
+// hidden ones for scale 1
+func NewBufferHints(scale int32) *BufferHints {
+	if scale < 1 {
+		scale = 1
+	}
+	return &BufferHints{
+		Scale:       scale,
+		HiddenScale: 1,
+		sent:        make(map[surfaceKey]int32),
+	}
+}
+
+// Update sends hints for this frame's visible and hidden surfaces
+func (h *BufferHints) Update(visible, hidden []PlacedSurface) {
+	seen := make(map[surfaceKey]bool, len(visible)+len(hidden))
+	for _, p := range visible {
+		seen[h.send(p, h.Scale)] = true
+	}
+	for _, p := range hidden {
+		seen[h.send(p, h.HiddenScale)] = true
+	}
+	for key := range h.sent {
+		if !seen[key] {
+			delete(h.sent, key)
+		}
+	}
+}
+
+func (h *BufferHints) send(p PlacedSurface, scale int32) surfaceKey {
+	key := surfaceKey{client: p.Client, id: p.SurfaceID}
+	if last, ok := h.sent[key]; ok && last == scale {
+		return key
+	}
+	version := surfaceVersion(p.Client)
+	if _, ok := h.sent[key]; !ok {
+		protocols.WlSurface_preferred_buffer_transform(p.Client, version, p.SurfaceID,
+			protocols.WlOutputTransform_enum_normal)
+	}
+	protocols.WlSurface_preferred_buffer_scale(p.Client, version, p.SurfaceID, scale)
+	h.sent[key] = scale
+	return key
+}
+
+// surfaceVersion returns the wl_surface version a client uses, which is
+// the version it bound wl_compositor at
+func surfaceVersion(c *wayland.Client) uint32 {
+	var version uint32
+	for _, v := range protocols.GetGlobalWlCompositorBinds(c) {
+		if uint32(v) > version {
+			version = uint32(v)
+		}
+	}
+	return version
+}
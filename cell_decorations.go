@@ -0,0 +1,112 @@
+package compositor
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// cellColors are the colors of the grid cells' borders and labels, taken
+// in turn
+var cellColors = []color.RGBA{
+	{R: 0x3d, G: 0x84, B: 0xd6, A: 0xff},
+	{R: 0xd6, G: 0x6b, B: 0x3d, A: 0xff},
+	{R: 0x4c, G: 0xa8, B: 0x5a, A: 0xff},
+	{R: 0xb0, G: 0x4c, B: 0xc2, A: 0xff},
+	{R: 0xd1, G: 0xa9, B: 0x2c, A: 0xff},
+	{R: 0x3d, G: 0xb5, B: 0xb0, A: 0xff},
+}
+
+var cellLabelText = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+
+// CellDecorations draws the labels and borders of the layout's cells as
+// surfaces of their own, for the render loop to put under the windows
+type CellDecorations struct {
+	surface *wayland.WlSurface
+	// labels are the label bars drawn, kept while their cell keeps its
+	// label, color and width
+	labels map[cellLabel]*wayland.Texture
+	// swatches are a pixel of each color, stretched into the borders
+	swatches map[color.RGBA]*wayland.Texture
+}
+
+type cellLabel struct {
+	text  string
+	color color.RGBA
+	width int
+}
+
+// NewCellDecorations creates an empty set of decorations
+func NewCellDecorations() *CellDecorations {
+	return &CellDecorations{
+		surface:  &wayland.WlSurface{},
+		labels:   make(map[cellLabel]*wayland.Texture),
+		swatches: make(map[color.RGBA]*wayland.Texture),
+	}
+}
+
+// Place returns each cell's label bar across its top and the border
+// down its sides and along its bottom
+func (d *CellDecorations) Place(cells []LayoutCell) []PlacedSurface {
+	var placed []PlacedSurface
+	labels := make(map[cellLabel]*wayland.Texture, len(cells))
+	add := func(texture *wayland.Texture, rect image.Rectangle) {
+		p := PlacedSurface{
+			SurfaceID: protocols.ObjectID[protocols.WlSurface](len(placed) + 1),
+			Surface:   d.surface,
+			Texture:   texture,
+			X:         rect.Min.X,
+			Y:         rect.Min.Y,
+			Source:    image.Rect(0, 0, int(texture.Width), int(texture.Height)),
+			Size:      rect.Size(),
+		}
+		p.Root = p.SurfaceID
+		placed = append(placed, p)
+	}
+	for _, cell := range cells {
+		c := cellColors[cell.Index%len(cellColors)]
+		b := cell.Bounds
+		key := cellLabel{text: cell.Label, color: c, width: b.Dx()}
+		label, ok := d.labels[key]
+		if !ok {
+			img := renderCellLabel(key)
+			label = &wayland.Texture{Stride: uint32(img.Stride), Width: uint32(img.Rect.Dx()), Height: uint32(img.Rect.Dy()), Data: img.Pix}
+		}
+		labels[key] = label
+		add(label, image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+cellLabelHeight))
+
+		swatch := d.swatch(c)
+		top := b.Min.Y + cellLabelHeight
+		add(swatch, image.Rect(b.Min.X, top, b.Min.X+cellBorder, b.Max.Y))
+		add(swatch, image.Rect(b.Max.X-cellBorder, top, b.Max.X, b.Max.Y))
+		add(swatch, image.Rect(b.Min.X+cellBorder, b.Max.Y-cellBorder, b.Max.X-cellBorder, b.Max.Y))
+	}
+	// Forget the labels of cells that are gone
+	d.labels = labels
+	return placed
+}
+
+// swatch returns a pixel of c
+func (d *CellDecorations) swatch(c color.RGBA) *wayland.Texture {
+	if t, ok := d.swatches[c]; ok {
+		return t
+	}
+	t := &wayland.Texture{Stride: 4, Width: 1, Height: 1, Data: []byte{c.R, c.G, c.B, c.A}}
+	d.swatches[c] = t
+	return t
+}
+
+// renderCellLabel draws a label bar, the text cut short to fit
+func renderCellLabel(label cellLabel) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, label.width, cellLabelHeight))
+	fillRect(img, img.Rect, label.color)
+	pad := cellLabelScale * 2
+	text := []rune(label.text)
+	for len(text) > 0 && textSize(string(text), cellLabelScale).X > label.width-2*pad {
+		text = text[:len(text)-1]
+	}
+	drawText(img, image.Pt(pad, cellLabelScale), string(text), cellLabelScale, cellLabelText)
+	return img
+}
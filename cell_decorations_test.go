@@ -0,0 +1,41 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCellDecorations(t *testing.T) {
+	d := NewCellDecorations()
+	cells := []LayoutCell{{Bounds: image.Rect(600, 0, 1200, 400), Label: "A very long window title that cannot fit", Index: 1}}
+	placed := d.Place(cells)
+	if len(placed) != 4 {
+		t.Fatalf("Expected a label and three border strips, got %d surfaces", len(placed))
+	}
+	label := placed[0]
+	if label.X != 600 || label.Y != 0 || label.Size != image.Pt(600, cellLabelHeight) {
+		t.Errorf("Expected the label across the top of the cell, got %+v", label)
+	}
+	want := []image.Rectangle{
+		image.Rect(600, cellLabelHeight, 600+cellBorder, 400),
+		image.Rect(1200-cellBorder, cellLabelHeight, 1200, 400),
+		image.Rect(600+cellBorder, 400-cellBorder, 1200-cellBorder, 400),
+	}
+	for i, p := range placed[1:] {
+		if got := image.Rect(p.X, p.Y, p.X+p.Size.X, p.Y+p.Size.Y); got != want[i] {
+			t.Errorf("Border %d at %v, want %v", i, got, want[i])
+		}
+		c := cellColors[1]
+		if string(p.Texture.Data) != string([]byte{c.R, c.G, c.B, c.A}) {
+			t.Errorf("Border %d is %v, want the second cell color", i, p.Texture.Data)
+		}
+	}
+
+	if again := d.Place(cells); again[0].Texture != label.Texture {
+		t.Error("Drew an unchanged label again")
+	}
+	cells[0].Label = "Other"
+	if again := d.Place(cells); again[0].Texture == label.Texture {
+		t.Error("Kept the label after it changed")
+	}
+}
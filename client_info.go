@@ -0,0 +1,55 @@
+package compositor
+
+import (
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// clientAppID returns the xdg_toplevel app_id of the first toplevel that set
+// one. The second return value is false when the client is busy dispatching
+// requests, so callers never block the render loop on a client's lock.
+func clientAppID(c *wayland.Client) (string, bool) {
+	if !c.Access.TryLock() {
+		return "", false
+	}
+	defer c.Access.Unlock()
+
+	for toplevelID, alive := range c.TopLevelSurfaces() {
+		if !alive {
+			continue
+		}
+		toplevel := wayland.GetXdgToplevelObject(c, toplevelID)
+		if toplevel != nil && toplevel.AppID != "" {
+			return toplevel.AppID, true
+		}
+	}
+	return "", true
+}
+
+// configureToplevel asks a toplevel to resize to width x height. Windows
+// filling the desktop are configured fullscreen and maximized, which is
+// how the wayland package maps them.
+func configureToplevel(c *wayland.Client, id protocols.ObjectID[protocols.XdgToplevel], width, height int, fullscreen bool) error {
+	surfaceID := c.GetSurfaceIDFromRole(protocols.AnyObjectID(id))
+	if surfaceID == nil {
+		return notFound("toplevel %d has no surface", id)
+	}
+	surface := wayland.GetWlSurfaceObject(c, *surfaceID)
+	if surface == nil || surface.XdgSurfaceState == nil {
+		return notFound("toplevel %d has no xdg_surface", id)
+	}
+	xdgSurface := wayland.GetXdgSurfaceObject(c, *surface.XdgSurfaceState)
+	if xdgSurface == nil {
+		return notFound("toplevel %d has no xdg_surface", id)
+	}
+
+	var states []protocols.XdgToplevelState_enum
+	if fullscreen {
+		states = append(states, protocols.XdgToplevelState_enum_maximized, protocols.XdgToplevelState_enum_fullscreen)
+	}
+	protocols.XdgToplevel_configure(c, id, int32(width), int32(height), wayland.ToBytes(states))
+	serial := xdgSurface.LatestSerial
+	xdgSurface.LatestSerial++
+	protocols.XdgSurface_configure(c, *surface.XdgSurfaceState, serial)
+	return nil
+}
@@ -0,0 +1,167 @@
+package compositor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// acceptRetryDelay is how long accepting clients pauses after running out
+// of file descriptors or memory
+const acceptRetryDelay = 100 * time.Millisecond
+
+// acceptClients accepts Wayland clients onto listener.OnConnection until
+// the listener is closed, then closes it. Unlike the listener's own loop
+// it rides out running short of file descriptors or memory, which would
+// otherwise leave the compositor running but taking no new clients.
+func acceptClients(listener *wayland.SocketListener) error {
+	defer listener.Close()
+	for {
+		conn, err := listener.Listener.AcceptUnix()
+		switch {
+		case err == nil:
+			listener.OnConnection <- conn
+		case errors.Is(err, net.ErrClosed):
+			return nil
+		case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE), errors.Is(err, syscall.ENOBUFS),
+			errors.Is(err, syscall.ENOMEM), errors.Is(err, syscall.ECONNABORTED):
+			log.Printf("Accepting a client failed, retrying: %v", err)
+			time.Sleep(acceptRetryDelay)
+		default:
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+	}
+}
+
+// closeListener removes the Wayland socket and closes the listener. The
+// listener's file descriptor is in blocking mode, so closing it waits for
+// the accept loop, which only returns on the next connection; that wait
+// happens off the render loop so shutting down does not hang.
+func closeListener(listener *wayland.SocketListener) {
+	if err := os.Remove(listener.SocketPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove %s: %v", listener.SocketPath, err)
+	}
+	go listener.Close()
+}
+
+// serveClient runs a Wayland client's main loop. A request that panics,
+// whether it was malformed or ran into a bug, only costs its own client:
+// the client is sent a wl_display.error and disconnected, and the
+// compositor and the other clients carry on. Faults reading the client's
+// shared memory, such as from a pool file it truncated, panic too rather
+// than kill the process.
+func serveClient(c *wayland.Client) {
+	debug.SetPanicOnFault(true)
+	pid := clientPID(c)
+	// The main loop closes the socket as it unwinds, so the error goes out
+	// on a duplicate of it
+	errorFD := dupSocket(c.UnixConnection)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Disconnecting client (pid %d), its request panicked: %v\n%s", pid, r, debug.Stack())
+			if errorFD >= 0 {
+				syscall.Write(errorFD, protocolError(protocols.WlDisplayError_enum_implementation, fmt.Sprintf("compositor error handling a request: %v", r)))
+			}
+		}
+		if errorFD >= 0 {
+			syscall.Close(errorFD)
+		}
+	}()
+	c.MainLoop()
+}
+
+// runClient serves a client that was added to clients, handing each frame
+// callback it asks for to queue, until it disconnects; then it marks it
+// disconnected in clients, once no callback of its is being queued
+func runClient(clients *ClientList, c *wayland.Client, queue func(protocols.ObjectID[protocols.WlCallback])) {
+	done := make(chan struct{})
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for {
+			select {
+			case callbackID := <-c.FrameDrawRequests:
+				queue(callbackID)
+			case <-done:
+				return
+			}
+		}
+	}()
+	serveClient(c)
+	close(done)
+	<-forwarded
+	clients.Disconnected(c)
+}
+
+// dupSocket duplicates a connection's file descriptor, returning -1 if it
+// cannot
+func dupSocket(conn *net.UnixConn) int {
+	fd := -1
+	if conn == nil {
+		return fd
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return fd
+	}
+	raw.Control(func(s uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if d, err := syscall.Dup(int(s)); err == nil {
+			syscall.CloseOnExec(d)
+			fd = d
+		}
+	})
+	return fd
+}
+
+// protocolError is a wl_display.error event about the display, the last
+// thing a client hears before it is disconnected
+func protocolError(code protocols.WlDisplayError_enum, message string) []byte {
+	return wlMessage(wlDisplayID, 0, wlArgs{}.uint32(wlDisplayID).uint32(uint32(code)).string(message))
+}
+
+// rejectClient turns away a client over the -max-clients limit
+func rejectClient(conn *net.UnixConn, limit int) {
+	log.Printf("Rejecting a client, already serving the most allowed (%d)", limit)
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write(protocolError(protocols.WlDisplayError_enum_implementation, fmt.Sprintf("too many clients, at most %d are allowed", limit)))
+	conn.Close()
+}
+
+// releaseClient unmaps a disconnected client's shared memory pools and
+// closes their files and any file descriptors it sent that were never
+// used, which nothing else frees once it is gone. Its surfaces' textures
+// are copies and go with the client.
+func releaseClient(c *wayland.Client) {
+	c.Access.Lock()
+	defer c.Access.Unlock()
+	for _, object := range c.Objects {
+		pool, ok := object.(*protocols.WlShmPool)
+		if !ok {
+			continue
+		}
+		state, ok := pool.Delegate.(*wayland.WlShmPool)
+		if !ok {
+			continue
+		}
+		for id, m := range state.MemMaps {
+			m.Unmap()
+			syscall.Close(int(m.FileDescriptor))
+			delete(state.MemMaps, id)
+		}
+		state.MapState = wayland.MapStateDestroyed
+	}
+	for _, fd := range c.UnclaimedFDs {
+		syscall.Close(int(fd))
+	}
+	c.UnclaimedFDs = nil
+}
@@ -0,0 +1,92 @@
+package compositor
+
+import (
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// unixPair returns the two ends of a connected Unix socket
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+	a, b, err := socketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestServeClientPanic(t *testing.T) {
+	server, client := unixPair(t)
+	c := wayland.MakeClient(server)
+	done := make(chan struct{})
+	go func() {
+		serveClient(c)
+		close(done)
+	}()
+
+	// wl_display.sync without its callback argument
+	conn := &wlConn{conn: client}
+	if _, err := client.Write(wlMessage(wlDisplayID, 0, nil)); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.next()
+	if err == nil || !strings.Contains(err.Error(), "code 3") {
+		t.Fatalf("Expected an implementation error, got %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveClient did not return")
+	}
+	if c.Status != wayland.ClientStatus_Disconnected {
+		t.Error("Expected the client to be disconnected")
+	}
+	if _, err := conn.next(); err == nil {
+		t.Error("Expected the connection to be closed after the error")
+	}
+}
+
+func TestRejectClient(t *testing.T) {
+	server, client := unixPair(t)
+	rejectClient(server, 2)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := (&wlConn{conn: client}).next()
+	if err == nil || !strings.Contains(err.Error(), "too many clients") {
+		t.Fatalf("Expected a too many clients error, got %v", err)
+	}
+}
+
+func TestReleaseClient(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	c.UnclaimedFDs = append(c.UnclaimedFDs, protocols.FileDescriptor(fd))
+	releaseClient(c)
+	if len(c.UnclaimedFDs) != 0 {
+		t.Error("Expected the unclaimed file descriptors to be dropped")
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != syscall.EBADF {
+		t.Errorf("Expected the file descriptor closed, got %v", err)
+	}
+}
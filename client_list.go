@@ -0,0 +1,99 @@
+package compositor
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// ClientList is the set of connected Wayland clients. Readers take a
+// snapshot without locking, so input, the control API and new connections
+// never wait on a frame being composited. Changes copy the list and swap
+// it in; a snapshot is never modified and stays valid after them.
+//
+// A client's main loop sets its Status as it returns, without the client's
+// lock, so the list goes by Disconnected instead and Status is never read.
+type ClientList struct {
+	// mu orders writers; readers only load list
+	mu   sync.Mutex
+	list atomic.Pointer[[]*wayland.Client]
+	// added holds the clients added since the last TakeAdded, under mu
+	added []*wayland.Client
+	// gone holds the clients disconnected since the last Prune, under mu
+	gone map[*wayland.Client]bool
+}
+
+// NewClientList creates an empty client list
+func NewClientList() *ClientList {
+	l := &ClientList{gone: make(map[*wayland.Client]bool)}
+	l.list.Store(&[]*wayland.Client{})
+	return l
+}
+
+// Snapshot returns the clients as they are now. Do not modify it.
+func (l *ClientList) Snapshot() []*wayland.Client {
+	return *l.list.Load()
+}
+
+// Len returns how many clients there are
+func (l *ClientList) Len() int {
+	return len(l.Snapshot())
+}
+
+// Add appends a newly connected client
+func (l *ClientList) Add(c *wayland.Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	next := append(slices.Clip(l.Snapshot()), c)
+	l.list.Store(&next)
+	l.added = append(l.added, c)
+}
+
+// TakeAdded returns the clients added since it was last called, oldest
+// first, whether or not they are still connected
+func (l *ClientList) TakeAdded() []*wayland.Client {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	added := l.added
+	l.added = nil
+	return added
+}
+
+// Disconnected marks a client whose main loop has returned, for the next
+// Prune to drop
+func (l *ClientList) Disconnected(c *wayland.Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gone[c] = true
+}
+
+// Prune drops the clients that are no longer connected and returns them
+func (l *ClientList) Prune() []*wayland.Client {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := l.Snapshot()
+	var connected, gone []*wayland.Client
+	for _, c := range current {
+		if !l.gone[c] {
+			connected = append(connected, c)
+		} else {
+			gone = append(gone, c)
+		}
+	}
+	clear(l.gone)
+	if len(gone) > 0 {
+		l.list.Store(&connected)
+	}
+	return gone
+}
+
+// Clear empties the list and returns the clients it held
+func (l *ClientList) Clear() []*wayland.Client {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := l.Snapshot()
+	l.list.Store(&[]*wayland.Client{})
+	return current
+}
@@ -0,0 +1,69 @@
+package compositor
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestClientListSnapshotsDoNotChange(t *testing.T) {
+	l := NewClientList()
+	a := &wayland.Client{Status: wayland.ClientStatus_Connected}
+	b := &wayland.Client{Status: wayland.ClientStatus_Connected}
+	l.Add(a)
+	l.Add(b)
+
+	snapshot := l.Snapshot()
+	l.Disconnected(b)
+	gone := l.Prune()
+	l.Add(&wayland.Client{Status: wayland.ClientStatus_Connected})
+
+	if len(gone) != 1 || gone[0] != b {
+		t.Errorf("pruned %v, want the disconnected client", gone)
+	}
+	if len(snapshot) != 2 || snapshot[0] != a || snapshot[1] != b {
+		t.Errorf("a snapshot changed after the list did: %v", snapshot)
+	}
+	if l.Len() != 2 || l.Snapshot()[0] != a {
+		t.Errorf("list has %d clients, want a and the new one", l.Len())
+	}
+	if cleared := l.Clear(); len(cleared) != 2 || l.Len() != 0 {
+		t.Errorf("clear returned %d clients and left %d", len(cleared), l.Len())
+	}
+}
+
+func TestClientListAddsWhileRead(t *testing.T) {
+	l := NewClientList()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				l.Add(&wayland.Client{Status: wayland.ClientStatus_Connected})
+				for _, c := range l.Snapshot() {
+					_ = c.Status
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if l.Len() != 800 {
+		t.Errorf("%d clients after 800 adds", l.Len())
+	}
+}
+
+func TestClientListTakeAdded(t *testing.T) {
+	l := NewClientList()
+	a := &wayland.Client{Status: wayland.ClientStatus_Connected}
+	b := &wayland.Client{Status: wayland.ClientStatus_Disconnected}
+	l.Add(a)
+	l.Add(b)
+	if added := l.TakeAdded(); len(added) != 2 || added[0] != a || added[1] != b {
+		t.Errorf("added %v, want both clients in order", added)
+	}
+	if added := l.TakeAdded(); len(added) != 0 {
+		t.Errorf("clients reported added twice: %v", added)
+	}
+}
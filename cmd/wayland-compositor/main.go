@@ -0,0 +1,38 @@
+// Command wayland-compositor runs the compositor, see package compositor.
+// Run as pupctl, or with "ctl" as its first argument, it is the control
+// API client.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	compositor "github.com/mmulet/pupapppupps"
+)
+
+func init() {
+	// This is needed to arrange that main() runs on the main thread.
+	// OpenGL and SDL2 require this.
+	runtime.LockOSThread()
+}
+
+func main() {
+	// pupctl, or "ctl" as the first argument, is the control API client
+	if filepath.Base(os.Args[0]) == "pupctl" {
+		os.Exit(compositor.RunCtl(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(compositor.RunCtl(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	if err := compositor.New(os.Args[1:]).Run(); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,117 @@
+// Package compositor is a Wayland compositor that shows its desktop on a
+// 3D model, in an SDL preview window and to viewers over HTTP. The
+// wayland-compositor command in cmd/wayland-compositor is a thin wrapper
+// around it; other programs can embed it the same way:
+//
+//	runtime.LockOSThread()
+//	c := compositor.New([]string{"-model", "builtin:crt"})
+//	c.AddSink(myStream)
+//	if err := c.Run(); err != nil {
+//		log.Fatal(err)
+//	}
+package compositor
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+// TextureSource is a picture the model's screen and the streams can show,
+// see render.TextureSource
+type TextureSource = render.TextureSource
+
+// OutputSink is somewhere the composited desktop goes, see
+// stream.OutputSink
+type OutputSink = stream.OutputSink
+
+// Renderer draws the composited desktop somewhere of its own, such as a
+// texture in the embedding program's scene. Render is called on the
+// render loop once a frame, after the desktop is composited, and must not
+// block.
+type Renderer interface {
+	Render(desktop TextureSource, now time.Time)
+}
+
+// Broadcaster streams the composited desktop to remote viewers and passes
+// their input on. The HTTP server and each session stream through one;
+// package stream has the WebSocket one the built-in viewer talks to.
+type Broadcaster interface {
+	OutputSink
+	// HandleWebSocket takes a viewer's connection
+	HandleWebSocket(w http.ResponseWriter, r *http.Request)
+	// HandleInput handles an input message as if a viewer had sent it
+	HandleInput(message []byte)
+	// FrameWanted reports whether a viewer is waiting for a frame
+	FrameWanted() bool
+	// Resync sends every viewer a whole frame next
+	Resync()
+	ClientCount() int
+	// Viewers lists the connected viewers, oldest first
+	Viewers() []stream.ViewerInfo
+	CloseAll()
+	SetPlayoutDelay(delay time.Duration)
+	SetKeyboardHandler(handler stream.KeyboardEventHandler)
+	SetTextHandler(handler stream.TextInputHandler)
+	SetPointerHandler(handler stream.PointerEventHandler)
+}
+
+// Compositor runs the compositor with the settings of its command line
+// flags, see Run
+type Compositor struct {
+	// Args are the command line flags, without the program name
+	Args []string
+
+	renderers []Renderer
+	sinks     []OutputSink
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// New creates a compositor that runs with the command line flags args
+func New(args []string) *Compositor {
+	return &Compositor{Args: args, stop: make(chan struct{})}
+}
+
+// AddRenderer has renderer draw every frame too. Call it before Run.
+func (c *Compositor) AddRenderer(renderer Renderer) {
+	c.renderers = append(c.renderers, renderer)
+}
+
+// AddSink has sink take every frame alongside the built-in viewers and
+// the -output sinks. Call it before Run, which closes it when it returns.
+func (c *Compositor) AddSink(sink OutputSink) {
+	c.sinks = append(c.sinks, sink)
+}
+
+// AddFrameHook has hook get composited frames as options say, for
+// feeding them to analysis such as inference without going through the
+// viewers' stream. Call it before Run.
+func (c *Compositor) AddFrameHook(hook FrameHook, options FrameHookOptions) {
+	c.sinks = append(c.sinks, newFrameHookSink(hook, options))
+}
+
+// Stop shuts a running compositor down as SIGTERM would; Run returns once
+// it has. It is safe to call from any goroutine, more than once.
+func (c *Compositor) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// Args implements the HasDisplayName interface required by MakeSocketListener.
+type Args struct {
+	DisplayName string
+}
+
+func (a *Args) WaylandDisplayName() string {
+	return a.DisplayName // empty string auto-generates a name
+}
+
+// RunCtl runs pupctl, the control API client, with args and returns its
+// exit status
+func RunCtl(args []string, stdout, stderr io.Writer) int {
+	return runCtl(args, stdout, stderr)
+}
@@ -0,0 +1,81 @@
+package compositor
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// loadConfigFile reads a -config file: a JSON object keyed by flag name,
+// e.g. {"fps": 30, "max-fps": "mpv=30", "idle-timeout": "10m"}. Values are
+// returned in the string form flag.Set expects; objects and arrays, for
+// flags that take JSON such as -expressions, as their JSON text.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	config := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			config[name] = v
+		case float64:
+			config[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			config[name] = strconv.FormatBool(v)
+		case map[string]any, []any:
+			text, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q: %w", path, name, err)
+			}
+			config[name] = string(text)
+		default:
+			return nil, fmt.Errorf("%s: %q must be a string, number, boolean, object or array", path, name)
+		}
+	}
+	return config, nil
+}
+
+// applyConfig sets the flags named in config, skipping the ones given on
+// the command line, which always win. It returns the names of the flags
+// whose value changed, sorted.
+func applyConfig(fs *flag.FlagSet, config map[string]string, explicit map[string]bool) ([]string, error) {
+	var changed []string
+	for name, value := range config {
+		f := fs.Lookup(name)
+		if f == nil {
+			return nil, fmt.Errorf("unknown setting %q", name)
+		}
+		if explicit[name] || name == "config" {
+			continue
+		}
+		before := f.Value.String()
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("setting %q: %w", name, err)
+		}
+		if f.Value.String() != before {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// explicitFlags returns the names of the flags given on the command line.
+// Call it before applyConfig, which marks the flags it sets as given too.
+func explicitFlags(fs *flag.FlagSet) map[string]bool {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	return explicit
+}
@@ -0,0 +1,56 @@
+package compositor
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"fps": 30, "idle-timeout": "5m", "gpu-composite": true, "model": "b.glb"}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fps := fs.Int("fps", 60, "")
+	idle := fs.Duration("idle-timeout", 0, "")
+	gpu := fs.Bool("gpu-composite", false, "")
+	model := fs.String("model", "", "")
+	if err := fs.Parse([]string{"-model", "a.glb"}); err != nil {
+		t.Fatal(err)
+	}
+
+	explicit := explicitFlags(fs)
+	config, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := applyConfig(fs, config, explicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *fps != 30 || *idle != 5*time.Minute || !*gpu {
+		t.Errorf("Expected config values applied, got fps=%d idle=%v gpu=%v", *fps, *idle, *gpu)
+	}
+	if *model != "a.glb" {
+		t.Errorf("Command line flags should win over the config, got %q", *model)
+	}
+	if want := []string{"fps", "gpu-composite", "idle-timeout"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("Expected changed %v, got %v", want, changed)
+	}
+
+	// Applying the same file again changes nothing
+	changed, err = applyConfig(fs, config, explicit)
+	if err != nil || len(changed) != 0 {
+		t.Errorf("Expected no changes on reload, got %v (%v)", changed, err)
+	}
+
+	if _, err := applyConfig(fs, map[string]string{"nope": "1"}, nil); err == nil {
+		t.Error("Expected an error for an unknown setting")
+	}
+}
@@ -0,0 +1,179 @@
+package compositor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+	"github.com/skip2/go-qrcode"
+)
+
+// The connect code is drawn connectCodeModule desktop pixels a module, or
+// fewer to fit a third of the desktop's height, with the URL written
+// under it at connectCodeScale; /connect.png is connectCodePNGModule
+// pixels a module
+const (
+	connectCodeModule    = 8
+	connectCodeScale     = 2
+	connectCodePNGModule = 8
+)
+
+var (
+	connectCodeDark  = color.RGBA{A: 0xff}
+	connectCodeLight = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+// ConnectCode is a QR code of the viewer's URL, shown over the desktop for
+// a while so phones and tablets can join by pointing a camera at the
+// screen, and served as a PNG
+type ConnectCode struct {
+	URL string
+
+	mu      sync.Mutex
+	modules [][]bool
+	until   time.Time
+	surface *wayland.WlSurface
+	// placed is the code as last drawn, kept while the desktop is the
+	// same height
+	placed       *wayland.Texture
+	placedHeight int
+	png          []byte
+}
+
+// NewConnectCode encodes url
+func NewConnectCode(url string) (*ConnectCode, error) {
+	code, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %q as a QR code: %w", url, err)
+	}
+	c := &ConnectCode{URL: url, modules: code.Bitmap(), surface: &wayland.WlSurface{}}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.render(connectCodePNGModule, false)); err != nil {
+		return nil, err
+	}
+	c.png = buf.Bytes()
+	return c, nil
+}
+
+// Show draws the code over the desktop from now for duration
+func (c *ConnectCode) Show(duration time.Duration, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.until = now.Add(duration)
+}
+
+// Hide takes the code off the desktop
+func (c *ConnectCode) Hide() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.until = time.Time{}
+}
+
+// Showing reports whether the code is over the desktop at now
+func (c *ConnectCode) Showing(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return now.Before(c.until)
+}
+
+// PNG returns the code alone as a PNG
+func (c *ConnectCode) PNG() []byte {
+	return c.png
+}
+
+// Place returns the code and its URL as a surface in the middle of
+// bounds, reporting false when it is not showing at now
+func (c *ConnectCode) Place(bounds image.Rectangle, now time.Time) (PlacedSurface, bool) {
+	if c == nil {
+		return PlacedSurface{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !now.Before(c.until) || len(c.modules) == 0 {
+		return PlacedSurface{}, false
+	}
+	if c.placed == nil || c.placedHeight != bounds.Dy() {
+		module := max(1, min(connectCodeModule, bounds.Dy()/3/len(c.modules)))
+		img := c.render(module, true)
+		c.placed = &wayland.Texture{Stride: uint32(img.Stride), Width: uint32(img.Rect.Dx()), Height: uint32(img.Rect.Dy()), Data: img.Pix}
+		c.placedHeight = bounds.Dy()
+	}
+	size := image.Pt(int(c.placed.Width), int(c.placed.Height))
+	rect := anchorRect("center", size, bounds, 0)
+	p := PlacedSurface{
+		SurfaceID: 1,
+		Surface:   c.surface,
+		Texture:   c.placed,
+		X:         rect.Min.X,
+		Y:         rect.Min.Y,
+		Source:    image.Rect(0, 0, size.X, size.Y),
+		Size:      size,
+	}
+	p.Root = protocols.ObjectID[protocols.WlSurface](p.SurfaceID)
+	return p, true
+}
+
+// render draws the modules, quiet zone included, module pixels each, and
+// the URL under them when captioned
+func (c *ConnectCode) render(module int, captioned bool) *image.RGBA {
+	side := len(c.modules) * module
+	width, height := side, side
+	var caption image.Point
+	if captioned {
+		caption = textSize(c.URL, connectCodeScale)
+		width = max(width, caption.X+2*module)
+		height += caption.Y + module
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, img.Rect, connectCodeLight)
+	left := (width - side) / 2
+	for y, row := range c.modules {
+		for x, dark := range row {
+			if dark {
+				fillRect(img, image.Rect(left+x*module, y*module, left+(x+1)*module, (y+1)*module), connectCodeDark)
+			}
+		}
+	}
+	if captioned {
+		drawText(img, image.Pt((width-caption.X)/2, side), c.URL, connectCodeScale, connectCodeDark)
+	}
+	return img
+}
+
+// connectURL is the viewer's URL for an -http address, on host when the
+// address listens on every interface
+func connectURL(addr string, host net.IP) string {
+	addrHost, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr + "/"
+	}
+	if ip := net.ParseIP(addrHost); addrHost == "" || (ip != nil && ip.IsUnspecified()) {
+		addrHost = "localhost"
+		if host != nil {
+			addrHost = host.String()
+		}
+	}
+	return "http://" + net.JoinHostPort(addrHost, port) + "/"
+}
+
+// lanAddress is this machine's first IPv4 address other than loopback,
+// the one a phone on the same network reaches it at, or nil
+func lanAddress() net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP
+		}
+	}
+	return nil
+}
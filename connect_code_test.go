@@ -0,0 +1,93 @@
+package compositor
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectURL(t *testing.T) {
+	lan := net.ParseIP("192.168.1.20")
+	for _, tt := range []struct {
+		addr string
+		host net.IP
+		want string
+	}{
+		{":8080", lan, "http://192.168.1.20:8080/"},
+		{"0.0.0.0:8080", lan, "http://192.168.1.20:8080/"},
+		{":8080", nil, "http://localhost:8080/"},
+		{"10.0.0.5:9000", lan, "http://10.0.0.5:9000/"},
+		{"[::]:8080", lan, "http://192.168.1.20:8080/"},
+	} {
+		if got := connectURL(tt.addr, tt.host); got != tt.want {
+			t.Errorf("connectURL(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestConnectCodePNG(t *testing.T) {
+	c, err := NewConnectCode("http://192.168.1.20:8080/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(c.PNG()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := len(c.modules) * connectCodePNGModule
+	if img.Bounds() != image.Rect(0, 0, side, side) {
+		t.Errorf("Expected a %dx%d code, got %v", side, side, img.Bounds())
+	}
+	// The quiet zone is light and the finder pattern inside it dark
+	quiet := 4 * connectCodePNGModule
+	if r, _, _, _ := img.At(0, 0).RGBA(); r != 0xffff {
+		t.Error("Expected a light quiet zone")
+	}
+	if r, _, _, _ := img.At(quiet, quiet).RGBA(); r != 0 {
+		t.Error("Expected the finder pattern's corner dark")
+	}
+}
+
+func TestConnectCodePlace(t *testing.T) {
+	c, err := NewConnectCode("http://192.168.1.20:8080/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	bounds := image.Rect(0, 0, 1200, 800)
+	if _, ok := c.Place(bounds, now); ok {
+		t.Fatal("Expected the code hidden until shown")
+	}
+
+	c.Show(10*time.Second, now)
+	p, ok := c.Place(bounds, now.Add(time.Second))
+	if !ok {
+		t.Fatal("Expected the code shown")
+	}
+	if p.Size.Y > bounds.Dy()/3+20 || int(p.Texture.Height) != p.Size.Y {
+		t.Errorf("Expected a code a third of the desktop high, got %v", p.Size)
+	}
+	if center := image.Pt(p.X+p.Size.X/2, p.Y+p.Size.Y/2); center.X != 600 {
+		t.Errorf("Expected the code in the middle of the desktop, got %v", center)
+	}
+	// Smaller desktops get smaller modules
+	if small, _ := c.Place(image.Rect(0, 0, 320, 240), now); small.Size.X >= p.Size.X {
+		t.Errorf("Expected a smaller code on a smaller desktop, got %v", small.Size)
+	}
+
+	if _, ok := c.Place(bounds, now.Add(10*time.Second)); ok {
+		t.Error("Expected the code gone once its time was up")
+	}
+	c.Show(time.Minute, now)
+	c.Hide()
+	if c.Showing(now) {
+		t.Error("Expected the code hidden")
+	}
+	var none *ConnectCode
+	if _, ok := none.Place(bounds, now); ok {
+		t.Error("Expected no code without one")
+	}
+}
@@ -0,0 +1,437 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+const (
+	// controlBodyLimit bounds the size of a control request body
+	controlBodyLimit = 64 << 10
+	// controlTimeout bounds how long a request waits for the render loop
+	controlTimeout = 5 * time.Second
+	// maxDesktopSize bounds each side of the desktop resolution
+	maxDesktopSize = 8192
+)
+
+// ControlAPI serves the /api/v1 control surface. Handlers run on the render
+// loop between frames, since they touch state it owns (clients, the
+// desktop, the GL renderer); the HTTP goroutine waits for their result and
+// writes it as JSON.
+type ControlAPI struct {
+	// Wake, when set, is called when a request is queued, to bring the
+	// render loop's next frame forward
+	Wake func()
+	// Decorations, when set, reports each toplevel's decoration mode
+	Decorations *Decorations
+
+	pending  chan func()
+	upgrader websocket.Upgrader
+
+	mu           sync.Mutex
+	clientIDs    map[*wayland.Client]int
+	nextClientID int
+}
+
+// ClientInfo describes a connected Wayland client
+type ClientInfo struct {
+	ID        int            `json:"id"`
+	Toplevels []ToplevelInfo `json:"toplevels"`
+}
+
+// ToplevelInfo describes one xdg_toplevel window
+type ToplevelInfo struct {
+	ID        uint32 `json:"id"`
+	SurfaceID uint32 `json:"surface_id"`
+	AppID     string `json:"app_id,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	// Decoration is the xdg-decoration mode it was configured with
+	Decoration DecorationMode `json:"decoration,omitempty"`
+}
+
+// AnimationInfo describes the model's current animation
+type AnimationInfo struct {
+	Name      string               `json:"name"`
+	Loop      bool                 `json:"loop"`
+	Paused    bool                 `json:"paused"`
+	Time      float32              `json:"time"`
+	Duration  float32              `json:"duration"`
+	Progress  float32              `json:"progress"`
+	Layers    []AnimationLayerInfo `json:"layers"`
+	Available []string             `json:"available"`
+}
+
+// ModelInfo describes the model on display
+type ModelInfo struct {
+	Path     string `json:"path"`
+	Meshes   int    `json:"meshes"`
+	Watching bool   `json:"watching"`
+}
+
+// ConfigReloadResult lists the settings a config reload changed
+type ConfigReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// ExpressionResult reports an applied expression preset and the parts of
+// it the model does not have
+type ExpressionResult struct {
+	Name    string   `json:"name"`
+	Missing []string `json:"missing"`
+}
+
+// NewControlAPI creates a control API with nothing queued
+func NewControlAPI() *ControlAPI {
+	return &ControlAPI{
+		pending:   make(chan func(), 64),
+		clientIDs: make(map[*wayland.Client]int),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+	}
+}
+
+// Handle registers an endpoint, e.g. "POST /api/v1/rotation". The body is
+// read before the handler is queued, so a slow client cannot hold up the
+// render loop; the handler's result is answered as JSON.
+func (a *ControlAPI) Handle(server *HTTPServer, pattern string, handler func(r *http.Request) (any, error)) {
+	server.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, controlBodyLimit))
+		if err != nil {
+			http.Error(w, "failed to read request", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		value, err := a.Call(r, func() (any, error) {
+			return handler(r)
+		})
+		writeControlResult(w, value, err)
+	})
+}
+
+// Call runs handler on the render loop and waits for its result, for
+// endpoints that do part of their work on the HTTP goroutine first
+func (a *ControlAPI) Call(r *http.Request, handler func() (any, error)) (any, error) {
+	type result struct {
+		value any
+		err   error
+	}
+	done := make(chan result, 1)
+	select {
+	case a.pending <- func() {
+		value, err := handler()
+		done <- result{value, err}
+	}:
+		a.wake()
+	default:
+		return nil, &controlError{http.StatusServiceUnavailable, "too many pending control requests"}
+	}
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-time.After(controlTimeout):
+		return nil, &controlError{http.StatusGatewayTimeout, "timed out waiting for the render loop"}
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+}
+
+// writeControlResult answers with value as JSON, or with err and the
+// status it carries
+func writeControlResult(w http.ResponseWriter, value any, err error) {
+	if err != nil {
+		status := http.StatusInternalServerError
+		var ce *controlError
+		if errors.As(err, &ce) {
+			status = ce.status
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if value == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Control API encode error: %v", err)
+	}
+}
+
+// Queue runs a function on the render loop at its next RunPending, for
+// requests that don't come over HTTP. It reports false when the queue is
+// full.
+func (a *ControlAPI) Queue(run func()) bool {
+	select {
+	case a.pending <- run:
+		a.wake()
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *ControlAPI) wake() {
+	if a.Wake != nil {
+		a.Wake()
+	}
+}
+
+// RunPending runs the queued requests. Call it from the render loop.
+func (a *ControlAPI) RunPending() {
+	for {
+		select {
+		case run := <-a.pending:
+			run()
+		default:
+			return
+		}
+	}
+}
+
+// decodeBody decodes a JSON request body into v. Bodies of any other
+// content type are refused, so a browser cannot be made to send one from
+// a form on another site.
+func decodeBody(r *http.Request, v any) error {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return &controlError{http.StatusUnsupportedMediaType, "the body must be application/json"}
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequest("invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// ClientID returns the API id of a client, assigning the next free one on
+// first use
+func (a *ControlAPI) ClientID(c *wayland.Client) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id, ok := a.clientIDs[c]
+	if !ok {
+		a.nextClientID++
+		id = a.nextClientID
+		a.clientIDs[c] = id
+	}
+	return id
+}
+
+// ListClients describes clients and their toplevels, and forgets the ids
+// of clients that are gone
+func (a *ControlAPI) ListClients(clients []*wayland.Client) []ClientInfo {
+	infos := make([]ClientInfo, 0, len(clients))
+	present := make(map[*wayland.Client]bool, len(clients))
+	for _, c := range clients {
+		present[c] = true
+		info := ClientInfo{ID: a.ClientID(c), Toplevels: []ToplevelInfo{}}
+		for toplevelID, alive := range c.TopLevelSurfaces() {
+			if alive {
+				toplevel := describeToplevel(c, toplevelID)
+				toplevel.Decoration = a.Decorations.Mode(c, toplevelID)
+				info.Toplevels = append(info.Toplevels, toplevel)
+			}
+		}
+		sort.Slice(info.Toplevels, func(i, j int) bool { return info.Toplevels[i].ID < info.Toplevels[j].ID })
+		infos = append(infos, info)
+	}
+
+	a.mu.Lock()
+	for c := range a.clientIDs {
+		if !present[c] {
+			delete(a.clientIDs, c)
+		}
+	}
+	a.mu.Unlock()
+	return infos
+}
+
+// Toplevel finds the toplevel named by the {client} and {toplevel} path
+// values of r
+func (a *ControlAPI) Toplevel(r *http.Request, clients []*wayland.Client) (*wayland.Client, protocols.ObjectID[protocols.XdgToplevel], error) {
+	clientID, err := strconv.Atoi(r.PathValue("client"))
+	if err != nil {
+		return nil, 0, badRequest("invalid client id %q", r.PathValue("client"))
+	}
+	toplevelID, err := strconv.ParseUint(r.PathValue("toplevel"), 10, 32)
+	if err != nil {
+		return nil, 0, badRequest("invalid toplevel id %q", r.PathValue("toplevel"))
+	}
+	return a.FindToplevel(clientID, uint32(toplevelID), clients)
+}
+
+// FindToplevel finds a toplevel by its client's API id and its own
+func (a *ControlAPI) FindToplevel(clientID int, toplevelID uint32, clients []*wayland.Client) (*wayland.Client, protocols.ObjectID[protocols.XdgToplevel], error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, c := range clients {
+		if a.clientIDs[c] != clientID {
+			continue
+		}
+		id := protocols.ObjectID[protocols.XdgToplevel](toplevelID)
+		if !c.TopLevelSurfaces()[id] {
+			return nil, 0, notFound("client %d has no toplevel %d", clientID, toplevelID)
+		}
+		return c, id, nil
+	}
+	return nil, 0, notFound("no client %d", clientID)
+}
+
+// describeToplevel reads a toplevel's app_id, title and size
+func describeToplevel(c *wayland.Client, id protocols.ObjectID[protocols.XdgToplevel]) ToplevelInfo {
+	info := ToplevelInfo{ID: uint32(id)}
+	if toplevel := wayland.GetXdgToplevelObject(c, id); toplevel != nil {
+		info.AppID = toplevel.AppID
+		if toplevel.Title != nil {
+			info.Title = *toplevel.Title
+		}
+	}
+	if surfaceID := c.GetSurfaceIDFromRole(protocols.AnyObjectID(id)); surfaceID != nil {
+		info.SurfaceID = uint32(*surfaceID)
+		if surface := wayland.GetWlSurfaceObject(c, *surfaceID); surface != nil && surface.Texture != nil {
+			info.Width, info.Height = int(surface.Texture.Width), int(surface.Texture.Height)
+		}
+	}
+	return info
+}
+
+// controlEvent is one message on the /api/v1/events stream
+type controlEvent struct {
+	Type    string `json:"type"`
+	Client  int    `json:"client,omitempty"`
+	Surface uint32 `json:"surface,omitempty"`
+	AppID   string `json:"app_id,omitempty"`
+	Title   string `json:"title,omitempty"`
+	// PreviousAppID and PreviousTitle are what a changed toplevel had
+	PreviousAppID string             `json:"previous_app_id,omitempty"`
+	PreviousTitle string             `json:"previous_title,omitempty"`
+	Frame         uint64             `json:"frame,omitempty"`
+	Viewers       int                `json:"viewers,omitempty"`
+	Reason        string             `json:"reason,omitempty"`
+	Action        string             `json:"action,omitempty"`
+	Animation     *AnimationPlayback `json:"animation,omitempty"`
+	Time          string             `json:"time"`
+	Dropped       int                `json:"dropped,omitempty"`
+}
+
+// ServeEvents streams compositor events to a WebSocket as JSON messages.
+// Frame events are left out; there is one per frame. Surface commits, up
+// to one per surface per frame, are only sent with ?commits=true.
+func (a *ControlAPI) ServeEvents(events *Events) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := a.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Control API WebSocket upgrade error: %v", err)
+			return
+		}
+		defer conn.Close()
+		// The server's write timeout would otherwise end the stream
+		conn.NetConn().SetDeadline(time.Time{})
+
+		// Handlers must not block the render loop, so a viewer that
+		// falls behind loses events and is told how many
+		queue := make(chan controlEvent, 64)
+		dropped := 0
+		var droppedMu sync.Mutex
+		send := func(e controlEvent) {
+			e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+			select {
+			case queue <- e:
+			default:
+				droppedMu.Lock()
+				dropped++
+				droppedMu.Unlock()
+			}
+		}
+		unsubscribe := []func(){
+			events.OnClientConnected(func(e ClientConnectedEvent) {
+				send(controlEvent{Type: "client_connected", Client: a.ClientID(e.Client)})
+			}),
+			events.OnToplevelMapped(func(e ToplevelMappedEvent) {
+				send(controlEvent{Type: "toplevel_mapped", Client: a.ClientID(e.Client), Surface: uint32(e.SurfaceID), AppID: e.AppID, Title: e.Title})
+			}),
+			events.OnToplevelUnmapped(func(e ToplevelUnmappedEvent) {
+				send(controlEvent{Type: "toplevel_unmapped", Client: a.ClientID(e.Client), Surface: uint32(e.SurfaceID), AppID: e.AppID, Title: e.Title})
+			}),
+			events.OnToplevelChanged(func(e ToplevelChangedEvent) {
+				send(controlEvent{
+					Type:          "toplevel_changed",
+					Client:        a.ClientID(e.Client),
+					Surface:       uint32(e.SurfaceID),
+					AppID:         e.AppID,
+					Title:         e.Title,
+					PreviousAppID: e.PreviousAppID,
+					PreviousTitle: e.PreviousTitle,
+				})
+			}),
+			events.OnClientDisconnected(func(e ClientDisconnectedEvent) {
+				send(controlEvent{Type: "client_disconnected", Client: a.ClientID(e.Client)})
+			}),
+			events.OnViewerJoined(func(e ViewerJoinedEvent) {
+				send(controlEvent{Type: "viewer_joined", Viewers: e.Viewers})
+			}),
+			events.OnPreviewRecovered(func(e PreviewRecoveredEvent) {
+				send(controlEvent{Type: "preview_recovered", Reason: e.Reason})
+			}),
+			events.OnAnimationPlayback(func(e AnimationPlaybackEvent) {
+				send(controlEvent{Type: "animation_playback", Action: e.Action, Animation: &e.Playback})
+			}),
+		}
+		if commits, _ := strconv.ParseBool(r.URL.Query().Get("commits")); commits {
+			unsubscribe = append(unsubscribe, events.OnSurfaceCommitted(func(e SurfaceCommittedEvent) {
+				send(controlEvent{Type: "surface_committed", Client: a.ClientID(e.Client), Surface: uint32(e.SurfaceID), Frame: e.Frame})
+			}))
+		}
+		defer func() {
+			for _, off := range unsubscribe {
+				off()
+			}
+		}()
+
+		// Reading notices the viewer going away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case e := <-queue:
+				droppedMu.Lock()
+				e.Dropped, dropped = dropped, 0
+				droppedMu.Unlock()
+				if err := conn.WriteJSON(e); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestControlAPIRunsHandlersOnRunPending(t *testing.T) {
+	server := NewHTTPServer("", t.TempDir())
+	api := NewControlAPI()
+	speed := 0.01
+	api.Handle(server, "POST /api/v1/rotation", func(r *http.Request) (any, error) {
+		var req struct {
+			Speed float64 `json:"speed"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		speed = req.Speed
+		return map[string]float64{"speed": speed}, nil
+	})
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest("POST", "/api/v1/rotation", strings.NewReader(`{"speed": 0.5}`))
+		req.Header.Set("Content-Type", "application/json")
+		server.mux.ServeHTTP(rec, req)
+	}()
+
+	// The handler waits for the render loop
+	deadline := time.Now().Add(time.Second)
+	for len(api.pending) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the request to be queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if speed != 0.01 {
+		t.Fatal("Handler ran before RunPending")
+	}
+	api.RunPending()
+	<-done
+
+	if rec.Code != http.StatusOK || speed != 0.5 {
+		t.Fatalf("Expected 200 and speed 0.5, got %d and %v", rec.Code, speed)
+	}
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"speed":0.5}` {
+		t.Errorf("Unexpected body %s", body)
+	}
+}
+
+func TestControlAPIErrorStatus(t *testing.T) {
+	server := NewHTTPServer("", t.TempDir())
+	api := NewControlAPI()
+	api.Handle(server, "POST /api/v1/clients/{client}/toplevels/{toplevel}/close", func(r *http.Request) (any, error) {
+		_, _, err := api.Toplevel(r, nil)
+		return nil, err
+	})
+
+	for path, want := range map[string]int{
+		"/api/v1/clients/x/toplevels/1/close": http.StatusBadRequest,
+		"/api/v1/clients/1/toplevels/1/close": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.mux.ServeHTTP(rec, httptest.NewRequest("POST", path, nil))
+		}()
+		for len(api.pending) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		api.RunPending()
+		<-done
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, rec.Code)
+		}
+	}
+}
+
+func TestControlAPIRefusesOtherBodies(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/rotation", strings.NewReader("speed=0.5"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var v struct{}
+	var ce *controlError
+	if err := decodeBody(req, &v); !errors.As(err, &ce) || ce.status != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for a form body, got %v", err)
+	}
+	req = httptest.NewRequest("POST", "/api/v1/rotation", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if err := decodeBody(req, &v); err != nil {
+		t.Errorf("Expected JSON with a charset to decode, got %v", err)
+	}
+}
+
+func TestControlAPIRequiresToken(t *testing.T) {
+	server := NewHTTPServer("", t.TempDir())
+	server.HandleFunc("GET /api/v1/clients", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	})
+	get := func(path, authorization string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		server.server.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Without a token set the API answers no one
+	if code := get("/api/v1/clients", "Bearer "); code != http.StatusUnauthorized {
+		t.Errorf("No token set: expected 401, got %d", code)
+	}
+	server.SetAPIToken("s3cret")
+	for authorization, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Basic s3cret":  http.StatusUnauthorized,
+		"Bearer s3cret": http.StatusOK,
+		"bearer s3cret": http.StatusOK,
+	} {
+		if code := get("/api/v1/clients", authorization); code != want {
+			t.Errorf("%q: expected %d, got %d", authorization, want, code)
+		}
+	}
+	// Unknown API paths are refused before they are looked up, and the
+	// rest of the server needs no token
+	if code := get("/api/v2/anything", ""); code != http.StatusUnauthorized {
+		t.Errorf("Unknown API path: expected 401, got %d", code)
+	}
+	if code := get("/health", ""); code != http.StatusOK {
+		t.Errorf("Health check: expected 200, got %d", code)
+	}
+}
+
+func TestLoadAPIToken(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	token, generated, err := loadAPIToken("", file)
+	if err != nil || generated || len(token) != 64 {
+		t.Fatalf("Expected a new token in the file, got %q, %v, %v", token, generated, err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the token file private, got %v, %v", info.Mode(), err)
+	}
+	if again, _, _ := loadAPIToken("", file); again != token {
+		t.Errorf("Expected the kept token %q, got %q", token, again)
+	}
+	if given, _, _ := loadAPIToken("given", file); given != "given" {
+		t.Errorf("Expected -api-token to win, got %q", given)
+	}
+	if random, generated, err := loadAPIToken("", ""); err != nil || !generated || random == "" {
+		t.Errorf("Expected a generated token, got %q, %v, %v", random, generated, err)
+	}
+}
+
+func TestControlAPIClientIDs(t *testing.T) {
+	api := NewControlAPI()
+	a, b := &wayland.Client{}, &wayland.Client{}
+	if api.ClientID(a) != 1 || api.ClientID(b) != 2 || api.ClientID(a) != 1 {
+		t.Fatal("Expected stable sequential client ids")
+	}
+
+	// Listing forgets clients that are gone
+	api.ListClients(nil)
+	if api.ClientID(b) != 3 {
+		t.Error("Expected a gone client to get a new id")
+	}
+}
@@ -0,0 +1,24 @@
+package compositor
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// controlError is a request error with the HTTP status to answer with
+type controlError struct {
+	status  int
+	message string
+}
+
+func (e *controlError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...any) error {
+	return &controlError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...any) error {
+	return &controlError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,521 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+const ctlUsage = `Usage: pupctl [-addr URL] [-token token] [-json] <command> [arguments]
+
+Controls a running compositor through its /api/v1 control API. Also run
+as "wayland-compositor ctl ...".
+
+Commands:
+  windows                           List clients and their windows
+  focus <client> <toplevel>         Raise a window
+  close <client> <toplevel>         Ask a window to close
+  move <client> <toplevel> <x> <y>  Move a window in the floating layout
+  layout [mode | labels on|off]     Show or set the window layout, or label the grid cells
+  workspace [n]                     Show or switch the workspace
+  pointer-lock [on|off]             Show, lock or release the pointer
+  stats-overlay [on|off]            Show, draw or hide the stats overlay
+  model [file|builtin:name]         Show the model, or upload a model file and show it
+  screenshot [file]                 Save the desktop as a PNG (default screenshot.png, - for stdout)
+  animate [-once] <name>            Play a model animation
+  stop                              Stop the model animation
+  seek [name] <seconds|percent%>    Move an animation to a time, e.g. 1.5 or 50%
+  expression <name>                 Show an expression preset
+  particles <name>                  Fire a particle emitter
+  screen-shader <name> <on|off>     Switch a screen shader on or off
+  backlight [level]                 Show or set the backlight, 0 to 1
+  output-power [on|off]             Show, turn on or turn off the output
+  notify [-anchor a] [-duration d] <text>
+                                    Flash a banner over the desktop, e.g. -anchor bottom -duration 10s
+  launch [-session name] <command>  Run a shell command as a client
+  viewers                           List stream viewers
+  kick <viewer>                     Disconnect a stream viewer
+  preferences <viewer> [json]       Show or replace a stream viewer's preferences
+  control [grant <viewer> | revoke] Show, give or take away exclusive input control
+  sessions                          List extra sessions
+  reload                            Re-read the compositor's -config
+  get <path>                        GET an API path, e.g. /api/v1/audio
+  post <path> [json]                POST to an API path
+
+Flags:
+`
+
+// ctlClient sends control API requests for pupctl
+type ctlClient struct {
+	base   string
+	http   *http.Client
+	stdout io.Writer
+	json   bool
+	// token is the control API's bearer token, if one was found
+	token string
+}
+
+// runCtl runs pupctl with args and returns the exit code
+func runCtl(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("pupctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	defaultAddr := os.Getenv("PUPCTL_ADDR")
+	if defaultAddr == "" {
+		defaultAddr = "http://localhost:8080"
+	}
+	addr := flags.String("addr", defaultAddr, "Compositor HTTP address, or unix:<path> for its Unix socket, also read from $PUPCTL_ADDR")
+	token := flags.String("token", os.Getenv("PUPCTL_TOKEN"), "Control API bearer token, also read from $PUPCTL_TOKEN (default: the one in -token-file)")
+	tokenFile := flags.String("token-file", defaultAPITokenFile(), "File holding the control API bearer token, the compositor's -api-token-file")
+	asJSON := flags.Bool("json", false, "Print responses as JSON instead of tables")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, ctlUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	c := &ctlClient{base: ctlBaseURL(*addr), http: &http.Client{Timeout: 30 * time.Second}, stdout: stdout, json: *asJSON, token: *token}
+	if path, ok := strings.CutPrefix(*addr, "unix:"); ok {
+		c.http.Transport = &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		}}
+	}
+	if c.token == "" && *tokenFile != "" {
+		if data, err := os.ReadFile(*tokenFile); err == nil {
+			c.token = strings.TrimSpace(string(data))
+		}
+	}
+	if err := c.run(flags.Arg(0), flags.Args()[1:]); err != nil {
+		fmt.Fprintf(stderr, "pupctl: %v\n", err)
+		if _, ok := err.(ctlUsageError); ok {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// ctlUsageError is a command used with the wrong arguments
+type ctlUsageError string
+
+func (e ctlUsageError) Error() string {
+	return string(e)
+}
+
+// ctlBaseURL accepts "host:port" and ":port" as well as URLs. Requests to
+// a Unix socket go to localhost, whose connections are dialled to it.
+func ctlBaseURL(addr string) string {
+	if strings.HasPrefix(addr, "unix:") {
+		return "http://localhost"
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimRight(addr, "/")
+}
+
+func (c *ctlClient) run(command string, args []string) error {
+	want := func(n int, usage string) error {
+		if len(args) != n {
+			return ctlUsageError("usage: pupctl " + command + " " + usage)
+		}
+		return nil
+	}
+	toplevelPath := func(action string) string {
+		return "/api/v1/clients/" + args[0] + "/toplevels/" + args[1] + "/" + action
+	}
+
+	switch command {
+	case "windows":
+		if err := want(0, ""); err != nil {
+			return err
+		}
+		var clients []ClientInfo
+		return c.list("/api/v1/clients", &clients, func(w io.Writer) {
+			fmt.Fprintln(w, "CLIENT\tTOPLEVEL\tAPP ID\tTITLE\tSIZE")
+			for _, client := range clients {
+				for _, t := range client.Toplevels {
+					fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%dx%d\n", client.ID, t.ID, t.AppID, t.Title, t.Width, t.Height)
+				}
+			}
+		})
+	case "focus", "close":
+		if err := want(2, "<client> <toplevel>"); err != nil {
+			return err
+		}
+		return c.print(http.MethodPost, toplevelPath(command), nil)
+	case "move":
+		if err := want(4, "<client> <toplevel> <x> <y>"); err != nil {
+			return err
+		}
+		x, errX := strconv.Atoi(args[2])
+		y, errY := strconv.Atoi(args[3])
+		if errX != nil || errY != nil {
+			return ctlUsageError("x and y must be whole numbers")
+		}
+		return c.print(http.MethodPost, toplevelPath("move"), map[string]int{"x": x, "y": y})
+	case "layout":
+		switch {
+		case len(args) == 0:
+			return c.print(http.MethodGet, "/api/v1/layout", nil)
+		case len(args) == 1:
+			return c.print(http.MethodPost, "/api/v1/layout", map[string]string{"mode": args[0]})
+		case len(args) == 2 && args[0] == "labels" && (args[1] == "on" || args[1] == "off"):
+			return c.print(http.MethodPost, "/api/v1/layout", map[string]bool{"labels": args[1] == "on"})
+		}
+		return ctlUsageError("usage: pupctl layout [mode | labels on|off]")
+	case "workspace":
+		if len(args) == 0 {
+			return c.print(http.MethodGet, "/api/v1/workspaces", nil)
+		}
+		if err := want(1, "[n]"); err != nil {
+			return err
+		}
+		return c.print(http.MethodPost, "/api/v1/workspaces/"+args[0], nil)
+	case "pointer-lock":
+		if len(args) == 0 {
+			return c.print(http.MethodGet, "/api/v1/pointer-lock", nil)
+		}
+		if err := want(1, "[on|off]"); err != nil {
+			return err
+		}
+		if args[0] != "on" && args[0] != "off" {
+			return ctlUsageError("usage: pupctl pointer-lock [on|off]")
+		}
+		return c.print(http.MethodPost, "/api/v1/pointer-lock", map[string]bool{"locked": args[0] == "on"})
+	case "stats-overlay":
+		if len(args) == 0 {
+			return c.print(http.MethodGet, "/api/v1/stats-overlay", nil)
+		}
+		if err := want(1, "[on|off]"); err != nil {
+			return err
+		}
+		if args[0] != "on" && args[0] != "off" {
+			return ctlUsageError("usage: pupctl stats-overlay [on|off]")
+		}
+		return c.print(http.MethodPost, "/api/v1/stats-overlay", map[string]bool{"enabled": args[0] == "on"})
+	case "model":
+		if len(args) == 0 {
+			return c.print(http.MethodGet, "/api/v1/model", nil)
+		}
+		if err := want(1, "[file|builtin:name]"); err != nil {
+			return err
+		}
+		if strings.HasPrefix(args[0], render.BuiltinModelPrefix) {
+			return c.print(http.MethodPost, "/api/v1/model", map[string]string{"path": args[0]})
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		format := strings.TrimPrefix(filepath.Ext(args[0]), ".")
+		return c.show(c.send(http.MethodPost, "/api/v1/model?format="+url.QueryEscape(format), bytes.NewReader(data), "application/octet-stream"))
+	case "screenshot":
+		if len(args) > 1 {
+			return ctlUsageError("usage: pupctl screenshot [file]")
+		}
+		file := "screenshot.png"
+		if len(args) == 1 {
+			file = args[0]
+		}
+		return c.screenshot(file)
+	case "animate":
+		loop := true
+		if len(args) > 0 && args[0] == "-once" {
+			loop, args = false, args[1:]
+		}
+		if err := want(1, "[-once] <name>"); err != nil {
+			return err
+		}
+		return c.print(http.MethodPost, "/api/v1/animation", map[string]any{"name": args[0], "loop": loop})
+	case "stop":
+		if err := want(0, ""); err != nil {
+			return err
+		}
+		return c.print(http.MethodPost, "/api/v1/animation", map[string]any{})
+	case "seek":
+		if len(args) == 0 || len(args) > 2 {
+			return ctlUsageError("usage: pupctl seek [name] <seconds|percent%>")
+		}
+		body := map[string]any{}
+		if len(args) == 2 {
+			body["name"], args = args[0], args[1:]
+		}
+		at := args[0]
+		key := "time"
+		if strings.HasSuffix(at, "%") {
+			key, at = "progress", strings.TrimSuffix(at, "%")
+		}
+		value, err := strconv.ParseFloat(at, 64)
+		if err != nil {
+			return ctlUsageError("seek takes seconds or a percentage, e.g. 1.5 or 50%")
+		}
+		if key == "progress" {
+			value /= 100
+		}
+		body[key] = value
+		return c.print(http.MethodPost, "/api/v1/animation/seek", body)
+	case "expression", "particles":
+		if err := want(1, "<name>"); err != nil {
+			return err
+		}
+		plural := map[string]string{"expression": "expressions", "particles": "particles"}[command]
+		return c.print(http.MethodPost, "/api/v1/"+plural+"/"+args[0], nil)
+	case "screen-shader":
+		if err := want(2, "<name> <on|off>"); err != nil {
+			return err
+		}
+		if args[1] != "on" && args[1] != "off" {
+			return ctlUsageError("usage: pupctl screen-shader <name> <on|off>")
+		}
+		return c.print(http.MethodPost, "/api/v1/screen-shaders/"+args[0], map[string]bool{"enabled": args[1] == "on"})
+	case "backlight":
+		if len(args) == 0 {
+			return c.print(http.MethodGet, "/api/v1/backlight", nil)
+		}
+		if err := want(1, "[level]"); err != nil {
+			return err
+		}
+		level, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return ctlUsageError("level must be a number from 0 to 1")
+		}
+		return c.print(http.MethodPost, "/api/v1/backlight", map[string]float64{"level": level})
+	case "output-power":
+		if len(args) == 0 {
+			return c.print(http.MethodGet, "/api/v1/output/power", nil)
+		}
+		if err := want(1, "[on|off]"); err != nil {
+			return err
+		}
+		if args[0] != "on" && args[0] != "off" {
+			return ctlUsageError("usage: pupctl output-power [on|off]")
+		}
+		return c.print(http.MethodPost, "/api/v1/output/power", map[string]string{"mode": args[0]})
+	case "notify":
+		req := map[string]any{}
+		for len(args) > 1 && (args[0] == "-anchor" || args[0] == "-duration") {
+			if args[0] == "-anchor" {
+				req["anchor"] = args[1]
+			} else {
+				duration, err := time.ParseDuration(args[1])
+				if err != nil {
+					return ctlUsageError("duration must be like 10s or 1m")
+				}
+				req["duration_ms"] = duration.Milliseconds()
+			}
+			args = args[2:]
+		}
+		if len(args) == 0 {
+			return ctlUsageError("usage: pupctl notify [-anchor a] [-duration d] <text>")
+		}
+		req["text"] = strings.Join(args, " ")
+		return c.print(http.MethodPost, "/api/v1/notifications", req)
+	case "launch":
+		req := map[string]string{}
+		if len(args) > 1 && args[0] == "-session" {
+			req["session"], args = args[1], args[2:]
+		}
+		if len(args) == 0 {
+			return ctlUsageError("usage: pupctl launch [-session name] <command>")
+		}
+		req["command"] = strings.Join(args, " ")
+		return c.print(http.MethodPost, "/api/v1/launch", req)
+	case "viewers":
+		if err := want(0, ""); err != nil {
+			return err
+		}
+		var viewers []stream.ViewerInfo
+		return c.list("/api/v1/viewers", &viewers, func(w io.Writer) {
+			fmt.Fprintln(w, "ID\tADDRESS\tROLE\tCONNECTED")
+			for _, v := range viewers {
+				role := v.Role
+				if v.Control {
+					role += " (in control)"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", v.ID, v.Address, role, v.Connected.Format(time.RFC3339))
+			}
+		})
+	case "kick":
+		if err := want(1, "<viewer>"); err != nil {
+			return err
+		}
+		return c.print(http.MethodDelete, "/api/v1/viewers/"+args[0], nil)
+	case "control":
+		switch {
+		case len(args) == 0:
+			return c.print(http.MethodGet, "/api/v1/control", nil)
+		case len(args) == 2 && args[0] == "grant":
+			id, err := strconv.Atoi(args[1])
+			if err != nil {
+				return ctlUsageError("viewer must be a whole number")
+			}
+			return c.print(http.MethodPost, "/api/v1/control", map[string]int{"viewer": id})
+		case len(args) == 1 && args[0] == "revoke":
+			return c.print(http.MethodDelete, "/api/v1/control", nil)
+		}
+		return ctlUsageError("usage: pupctl control [grant <viewer> | revoke]")
+	case "preferences":
+		if len(args) == 1 {
+			return c.print(http.MethodGet, "/api/v1/viewers/"+args[0]+"/preferences", nil)
+		}
+		if err := want(2, "<viewer> [json]"); err != nil {
+			return err
+		}
+		return c.print(http.MethodPost, "/api/v1/viewers/"+args[0]+"/preferences", json.RawMessage(args[1]))
+	case "sessions":
+		if err := want(0, ""); err != nil {
+			return err
+		}
+		var sessions []SessionInfo
+		return c.list("/api/v1/sessions", &sessions, func(w io.Writer) {
+			fmt.Fprintln(w, "NAME\tDISPLAY\tSTREAM\tCLIENTS\tVIEWERS")
+			for _, s := range sessions {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", s.Name, s.Display, s.Stream, s.Clients, s.Viewers)
+			}
+		})
+	case "reload":
+		if err := want(0, ""); err != nil {
+			return err
+		}
+		return c.print(http.MethodPost, "/api/v1/config/reload", nil)
+	case "get":
+		if err := want(1, "<path>"); err != nil {
+			return err
+		}
+		return c.print(http.MethodGet, args[0], nil)
+	case "post":
+		if len(args) != 1 && len(args) != 2 {
+			return ctlUsageError("usage: pupctl post <path> [json]")
+		}
+		var body any
+		if len(args) == 2 {
+			body = json.RawMessage(args[1])
+		}
+		return c.print(http.MethodPost, args[0], body)
+	default:
+		return ctlUsageError(fmt.Sprintf("unknown command %q, see pupctl -h", command))
+	}
+}
+
+// do sends a request with body as JSON and returns the response body,
+// failing on non-2xx answers with the server's message
+func (c *ctlClient) do(method, path string, body any) ([]byte, error) {
+	if body == nil {
+		return c.send(method, path, nil, "")
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(method, path, bytes.NewReader(data), "application/json")
+}
+
+// send is do with a body of any content type
+func (c *ctlClient) send(method, path string, body io.Reader, contentType string) ([]byte, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// print sends a request and prints the JSON answer, if any, indented
+func (c *ctlClient) print(method, path string, body any) error {
+	return c.show(c.do(method, path, body))
+}
+
+// show prints a JSON answer, if any, indented
+func (c *ctlClient) show(data []byte, err error) error {
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return err
+	}
+	var out bytes.Buffer
+	if json.Indent(&out, data, "", "  ") != nil {
+		_, err = c.stdout.Write(data)
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(c.stdout)
+	return err
+}
+
+// list gets path into v and prints it as a table, or as JSON with -json
+func (c *ctlClient) list(path string, v any, table func(io.Writer)) error {
+	if c.json {
+		return c.print(http.MethodGet, path, nil)
+	}
+	data, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// screenshot saves the next desktop frame to file, or writes it to stdout
+// for "-"
+func (c *ctlClient) screenshot(file string) error {
+	data, err := c.do(http.MethodGet, "/screenshot.png", nil)
+	if err != nil {
+		return err
+	}
+	if file == "-" {
+		_, err = c.stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		return fmt.Errorf("save screenshot: %w", err)
+	}
+	return nil
+}
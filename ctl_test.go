@@ -0,0 +1,193 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ctlServer records the requests pupctl makes and answers from responses,
+// keyed by method and path
+func ctlServer(t *testing.T, responses map[string]string) (*httptest.Server, *[]string) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		key := r.Method + " " + r.URL.Path
+		requests = append(requests, strings.TrimSpace(key+" "+string(body)))
+		response, ok := responses[key]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if response == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestCtlCommands(t *testing.T) {
+	server, requests := ctlServer(t, map[string]string{
+		"POST /api/v1/clients/1/toplevels/2/focus": "",
+		"POST /api/v1/clients/1/toplevels/2/move":  "",
+		"POST /api/v1/animation":                   `{"name":"Wag"}`,
+		"POST /api/v1/animation/seek":              `{"name":"Wag"}`,
+		"POST /api/v1/launch":                      `{"pid":42}`,
+		"DELETE /api/v1/viewers/3":                 "",
+		"POST /api/v1/viewers/3/preferences":       `{"quality":"low"}`,
+		"POST /api/v1/control":                     `{"mode":"exclusive","holder":3,"requests":[]}`,
+		"POST /api/v1/pointer-lock":                `{"locked":true}`,
+		"POST /api/v1/stats-overlay":               `{"enabled":false}`,
+		"POST /api/v1/layout":                      `{"labels":true,"mode":"auto"}`,
+		"POST /api/v1/screen-shaders/crt":          `[{"name":"crt","file":"crt.glsl","enabled":false}]`,
+		"POST /api/v1/backlight":                   `{"level":0.5,"manual":false,"backend":"dim"}`,
+		"POST /api/v1/notifications":               `{"id":1,"text":"Lunch is ready","anchor":"bottom"}`,
+		"POST /api/v1/output/power":                `{"mode":"off","since":"2026-01-01T00:00:00Z"}`,
+	})
+	tests := []struct {
+		args    []string
+		request string
+	}{
+		{[]string{"focus", "1", "2"}, "POST /api/v1/clients/1/toplevels/2/focus"},
+		{[]string{"move", "1", "2", "10", "20"}, `POST /api/v1/clients/1/toplevels/2/move {"x":10,"y":20}`},
+		{[]string{"animate", "-once", "Wag"}, `POST /api/v1/animation {"loop":false,"name":"Wag"}`},
+		{[]string{"seek", "1.5"}, `POST /api/v1/animation/seek {"time":1.5}`},
+		{[]string{"seek", "Wag", "50%"}, `POST /api/v1/animation/seek {"name":"Wag","progress":0.5}`},
+		{[]string{"launch", "-session", "kiosk", "foot", "-e", "htop"}, `POST /api/v1/launch {"command":"foot -e htop","session":"kiosk"}`},
+		{[]string{"kick", "3"}, "DELETE /api/v1/viewers/3"},
+		{[]string{"preferences", "3", `{"quality":"low"}`}, `POST /api/v1/viewers/3/preferences {"quality":"low"}`},
+		{[]string{"control", "grant", "3"}, `POST /api/v1/control {"viewer":3}`},
+		{[]string{"layout", "labels", "on"}, `POST /api/v1/layout {"labels":true}`},
+		{[]string{"pointer-lock", "on"}, `POST /api/v1/pointer-lock {"locked":true}`},
+		{[]string{"stats-overlay", "off"}, `POST /api/v1/stats-overlay {"enabled":false}`},
+		{[]string{"screen-shader", "crt", "off"}, `POST /api/v1/screen-shaders/crt {"enabled":false}`},
+		{[]string{"backlight", "0.5"}, `POST /api/v1/backlight {"level":0.5}`},
+		{[]string{"output-power", "off"}, `POST /api/v1/output/power {"mode":"off"}`},
+		{[]string{"notify", "-anchor", "bottom", "-duration", "10s", "Lunch", "is", "ready"}, `POST /api/v1/notifications {"anchor":"bottom","duration_ms":10000,"text":"Lunch is ready"}`},
+	}
+	for _, tt := range tests {
+		*requests = nil
+		var stdout, stderr bytes.Buffer
+		if code := runCtl(append([]string{"-addr", server.URL}, tt.args...), &stdout, &stderr); code != 0 {
+			t.Errorf("%v: exit %d, %s", tt.args, code, stderr.String())
+			continue
+		}
+		if len(*requests) != 1 || (*requests)[0] != tt.request {
+			t.Errorf("%v: requests %q, want %q", tt.args, *requests, tt.request)
+		}
+	}
+}
+
+func TestCtlTablesAndErrors(t *testing.T) {
+	server, _ := ctlServer(t, map[string]string{
+		"GET /api/v1/clients": `[{"id":1,"toplevels":[{"id":2,"surface_id":5,"app_id":"foot","title":"~","width":640,"height":480}]}]`,
+		"GET /screenshot.png": "PNG",
+	})
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runCtl(append([]string{"-addr", server.URL}, args...), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	if code, out, _ := run("windows"); code != 0 || !strings.Contains(out, "APP ID") || !strings.Contains(out, "foot") || !strings.Contains(out, "640x480") {
+		t.Errorf("windows: exit %d, %q", code, out)
+	}
+	if code, out, _ := run("-json", "windows"); code != 0 || !strings.Contains(out, `"app_id": "foot"`) {
+		t.Errorf("windows -json: exit %d, %q", code, out)
+	}
+	file := filepath.Join(t.TempDir(), "shot.png")
+	if code, _, stderr := run("screenshot", file); code != 0 {
+		t.Errorf("screenshot: exit %d, %s", code, stderr)
+	} else if data, _ := os.ReadFile(file); string(data) != "PNG" {
+		t.Errorf("screenshot saved %q", data)
+	}
+	if code, _, stderr := run("focus", "9", "9"); code != 1 || !strings.Contains(stderr, "404") {
+		t.Errorf("focus of a missing window: exit %d, %q", code, stderr)
+	}
+	if code, _, _ := run("focus", "1"); code != 2 {
+		t.Errorf("focus with one argument: exit %d, want 2", code)
+	}
+	if code, _, _ := run("dance"); code != 2 {
+		t.Errorf("unknown command: exit %d, want 2", code)
+	}
+}
+
+func TestCtlBaseURL(t *testing.T) {
+	for addr, want := range map[string]string{
+		":8080":                "http://localhost:8080",
+		"pup.local:9000":       "http://pup.local:9000",
+		"https://pup.example/": "https://pup.example",
+	} {
+		if got := ctlBaseURL(addr); got != want {
+			t.Errorf("ctlBaseURL(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestCtlModel(t *testing.T) {
+	server, requests := ctlServer(t, map[string]string{
+		"POST /api/v1/model": `{"path":"x","meshes":1}`,
+	})
+	file := filepath.Join(t.TempDir(), "tri.obj")
+	os.WriteFile(file, []byte("v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"), 0o644)
+
+	for arg, want := range map[string]string{
+		file:          "POST /api/v1/model v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3",
+		"builtin:crt": `POST /api/v1/model {"path":"builtin:crt"}`,
+	} {
+		*requests = nil
+		var stdout, stderr bytes.Buffer
+		code := runCtl([]string{"-addr", server.URL, "model", arg}, &stdout, &stderr)
+		if code != 0 || len(*requests) != 1 || (*requests)[0] != want {
+			t.Errorf("%s: exit %d, requests %q, want %q", arg, code, *requests, want)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runCtl([]string{"-addr", server.URL, "model", "missing.glb"}, &stdout, &stderr); code != 1 {
+		t.Errorf("missing file: exit %d, want 1", code)
+	}
+}
+
+func TestCtlToken(t *testing.T) {
+	var got []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		w.Write([]byte("[]"))
+	})
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	file := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(file, []byte("from-file\n"), 0o600)
+	t.Setenv("PUPCTL_TOKEN", "")
+
+	var stdout, stderr bytes.Buffer
+	runCtl([]string{"-addr", server.URL, "-token-file", file, "get", "/api/v1/clients"}, &stdout, &stderr)
+	runCtl([]string{"-addr", server.URL, "-token-file", file, "-token", "given", "get", "/api/v1/clients"}, &stdout, &stderr)
+
+	// The compositor's Unix socket
+	listener, err := listenHTTP("unix:" + filepath.Join(t.TempDir(), "http.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(listener, handler)
+	t.Cleanup(func() { listener.Close() })
+	if code := runCtl([]string{"-addr", "unix:" + listener.Addr().String(), "-token", "unix", "get", "/api/v1/clients"}, &stdout, &stderr); code != 0 {
+		t.Errorf("Unix socket: exit %d: %s", code, stderr.String())
+	}
+
+	want := []string{"Bearer from-file", "Bearer given", "Bearer unix"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Authorization headers %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,243 @@
+package compositor
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// DecorationMode is who draws a toplevel's title bar and borders
+type DecorationMode string
+
+const (
+	// DecorationServer leaves them to the compositor, which draws the
+	// -cell-labels cells and nothing else
+	DecorationServer DecorationMode = "server"
+	// DecorationClient has the app draw its own
+	DecorationClient DecorationMode = "client"
+)
+
+// parseDecorationMode checks a mode's name, empty being no mode
+func parseDecorationMode(name string) (DecorationMode, error) {
+	switch mode := DecorationMode(name); mode {
+	case "", DecorationServer, DecorationClient:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown decoration mode %q, want %s or %s", name, DecorationServer, DecorationClient)
+}
+
+// protocol is the xdg-decoration mode for m
+func (m DecorationMode) protocol() protocols.ZxdgToplevelDecorationV1Mode_enum {
+	if m == DecorationClient {
+		return protocols.ZxdgToplevelDecorationV1Mode_enum_client_side
+	}
+	return protocols.ZxdgToplevelDecorationV1Mode_enum_server_side
+}
+
+// decorationModeOf is the mode for an xdg-decoration mode, empty for one
+// the protocol does not have
+func decorationModeOf(mode protocols.ZxdgToplevelDecorationV1Mode_enum) DecorationMode {
+	switch mode {
+	case protocols.ZxdgToplevelDecorationV1Mode_enum_client_side:
+		return DecorationClient
+	case protocols.ZxdgToplevelDecorationV1Mode_enum_server_side:
+		return DecorationServer
+	}
+	return ""
+}
+
+// decorationKey is a toplevel of a client
+type decorationKey struct {
+	client   protocols.ClientState
+	toplevel protocols.ObjectID[protocols.XdgToplevel]
+}
+
+// Decorations negotiates zxdg_decoration_manager_v1 modes. Toplevels are
+// server side, so the layout decides how they look, unless they ask to
+// draw their own; a window rule's decoration_mode, matched when the
+// toplevel asks, forces one or the other whatever the app prefers.
+type Decorations struct {
+	Rules *WindowRuleSet
+
+	mu    sync.Mutex
+	modes map[decorationKey]DecorationMode
+}
+
+// NewDecorations creates the negotiator and makes it the one every
+// display's clients talk to
+func NewDecorations(rules *WindowRuleSet) *Decorations {
+	d := &Decorations{Rules: rules, modes: make(map[decorationKey]DecorationMode)}
+	wayland.Global_ZxdgDecorationManagerV1.Delegate = &decorationManager{decorations: d}
+	return d
+}
+
+// Mode returns the mode a toplevel was configured with, empty when it has
+// not asked
+func (d *Decorations) Mode(c protocols.ClientState, toplevel protocols.ObjectID[protocols.XdgToplevel]) DecorationMode {
+	if d == nil {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.modes[decorationKey{client: c, toplevel: toplevel}]
+}
+
+// ClientSide reports whether a toplevel draws its own decorations
+func (d *Decorations) ClientSide(c protocols.ClientState, toplevel protocols.ObjectID[protocols.XdgToplevel]) bool {
+	return d.Mode(c, toplevel) == DecorationClient
+}
+
+// choose picks a toplevel's mode from its rules, then what it asked for,
+// and remembers it
+func (d *Decorations) choose(c protocols.ClientState, toplevel protocols.ObjectID[protocols.XdgToplevel], requested DecorationMode) DecorationMode {
+	var appID, title string
+	if t := wayland.GetXdgToplevelObject(c, toplevel); t != nil {
+		appID = t.AppID
+		if t.Title != nil {
+			title = *t.Title
+		}
+	}
+	mode := d.Rules.Match(appID, title).DecorationMode
+	if mode == "" {
+		mode = requested
+	}
+	if mode == "" {
+		mode = DecorationServer
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.modes[decorationKey{client: c, toplevel: toplevel}] = mode
+	return mode
+}
+
+// Forget drops the modes of a disconnected client's toplevels
+func (d *Decorations) Forget(c protocols.ClientState) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.modes {
+		if key.client == c {
+			delete(d.modes, key)
+		}
+	}
+}
+
+// negotiating reports whether a toplevel already has a decoration object
+func (d *Decorations) negotiating(c protocols.ClientState, toplevel protocols.ObjectID[protocols.XdgToplevel]) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.modes[decorationKey{client: c, toplevel: toplevel}]
+	return ok
+}
+
+// forget drops a toplevel whose decoration object is gone; it is back to
+// drawing its own
+func (d *Decorations) forget(c protocols.ClientState, toplevel protocols.ObjectID[protocols.XdgToplevel]) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.modes, decorationKey{client: c, toplevel: toplevel})
+}
+
+// decorationManager is the zxdg_decoration_manager_v1 global
+type decorationManager struct {
+	decorations *Decorations
+}
+
+func (m *decorationManager) ZxdgDecorationManagerV1_destroy(
+	_ protocols.ClientState,
+	_ protocols.ObjectID[protocols.ZxdgDecorationManagerV1],
+) bool {
+	return true
+}
+
+func (m *decorationManager) ZxdgDecorationManagerV1_get_toplevel_decoration(
+	s protocols.ClientState,
+	object_id protocols.ObjectID[protocols.ZxdgDecorationManagerV1],
+	id protocols.ObjectID[protocols.ZxdgToplevelDecorationV1],
+	toplevel protocols.ObjectID[protocols.XdgToplevel],
+) {
+	if m.decorations.negotiating(s, toplevel) {
+		wayland.SendError(s, object_id, protocols.ZxdgToplevelDecorationV1Error_enum_already_constructed, "toplevel already has a decoration object")
+		return
+	}
+	wayland.AddObject(s, id, &protocols.ZxdgToplevelDecorationV1{Delegate: &toplevelDecoration{decorations: m.decorations, toplevel: toplevel}})
+	mode := m.decorations.choose(s, toplevel, "")
+	protocols.ZxdgToplevelDecorationV1_configure(s, id, mode.protocol())
+}
+
+func (m *decorationManager) OnBind(
+	_ protocols.ClientState,
+	_ protocols.AnyObjectID,
+	_ string,
+	_ protocols.AnyObjectID,
+	_ uint32,
+) {
+}
+
+// toplevelDecoration is a zxdg_toplevel_decoration_v1. Each mode the
+// client asks for is answered with the one chosen and a new configure
+// for the toplevel to ack.
+type toplevelDecoration struct {
+	decorations *Decorations
+	toplevel    protocols.ObjectID[protocols.XdgToplevel]
+}
+
+func (t *toplevelDecoration) ZxdgToplevelDecorationV1_destroy(
+	s protocols.ClientState,
+	_ protocols.ObjectID[protocols.ZxdgToplevelDecorationV1],
+) bool {
+	t.decorations.forget(s, t.toplevel)
+	return true
+}
+
+func (t *toplevelDecoration) ZxdgToplevelDecorationV1_set_mode(
+	s protocols.ClientState,
+	object_id protocols.ObjectID[protocols.ZxdgToplevelDecorationV1],
+	mode protocols.ZxdgToplevelDecorationV1Mode_enum,
+) {
+	requested := decorationModeOf(mode)
+	if requested == "" {
+		wayland.SendError(s, object_id, protocols.ZxdgToplevelDecorationV1Error_enum_invalid_mode, fmt.Sprintf("invalid mode %d", mode))
+		return
+	}
+	t.configure(s, object_id, requested)
+}
+
+func (t *toplevelDecoration) ZxdgToplevelDecorationV1_unset_mode(
+	s protocols.ClientState,
+	object_id protocols.ObjectID[protocols.ZxdgToplevelDecorationV1],
+) {
+	t.configure(s, object_id, "")
+}
+
+// configure sends the mode chosen for requested, then an xdg_surface
+// configure so the client applies it
+func (t *toplevelDecoration) configure(s protocols.ClientState, id protocols.ObjectID[protocols.ZxdgToplevelDecorationV1], requested DecorationMode) {
+	mode := t.decorations.choose(s, t.toplevel, requested)
+	protocols.ZxdgToplevelDecorationV1_configure(s, id, mode.protocol())
+	surface := wayland.GetSurfaceFromRole(s, t.toplevel)
+	if surface == nil || surface.XdgSurfaceState == nil {
+		return
+	}
+	xdgSurface := wayland.GetXdgSurfaceObject(s, *surface.XdgSurfaceState)
+	if xdgSurface == nil {
+		return
+	}
+	serial := xdgSurface.LatestSerial
+	xdgSurface.LatestSerial++
+	protocols.XdgSurface_configure(s, *surface.XdgSurfaceState, serial)
+}
+
+func (t *toplevelDecoration) OnBind(
+	_ protocols.ClientState,
+	_ protocols.AnyObjectID,
+	_ string,
+	_ protocols.AnyObjectID,
+	_ uint32,
+) {
+}
@@ -0,0 +1,69 @@
+package compositor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// compositeDesktop draws placed surfaces (bottom first) into the desktop
+// buffer on the CPU. It replaces wayland.Desktop.DrawClients so that
+// subsurface stacking and positions follow SurfaceSnapshots.Collect.
+func compositeDesktop(desktop *wayland.Desktop, placed []PlacedSurface) {
+	desktop.Clear()
+
+	if len(placed) == 0 {
+		if desktop.IconImg != nil && desktop.AfterOpeningTimeout() {
+			desktop.DrawImage(desktop.IconImg, 0, 0)
+		}
+		return
+	}
+
+	for _, p := range placed {
+		if p.Scaled() {
+			drawScaled(desktop.RGBA, image.Rect(p.X, p.Y, p.X+p.Width(), p.Y+p.Height()), p.Texture.AsRGBA(), p.Source)
+			continue
+		}
+		desktop.DrawImage(p.Texture.AsRGBA(), p.X, p.Y)
+	}
+}
+
+// drawScaled draws the source part of src over dst, scaled with nearest
+// neighbour sampling to fill dstRect
+func drawScaled(dst *image.RGBA, dstRect image.Rectangle, src *image.RGBA, source image.Rectangle) {
+	visible := dstRect.Intersect(dst.Bounds())
+	if src == nil || visible.Empty() || source.Empty() {
+		return
+	}
+
+	scaled := image.NewRGBA(visible)
+	for y := visible.Min.Y; y < visible.Max.Y; y++ {
+		sy := source.Min.Y + (y-dstRect.Min.Y)*source.Dy()/dstRect.Dy()
+		for x := visible.Min.X; x < visible.Max.X; x++ {
+			sx := source.Min.X + (x-dstRect.Min.X)*source.Dx()/dstRect.Dx()
+			copy(scaled.Pix[scaled.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	draw.Draw(dst, visible, scaled, visible.Min, draw.Over)
+}
+
+// createIcon draws the icon shown on an empty desktop
+func createIcon() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	// Fill with blue
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{0, 0, 255, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Fatalf("Failed to create icon: %v", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,88 @@
+package compositor
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+)
+
+// DesktopDamage tells the render loop whether the desktop would look any
+// different from the last frame it composited, so an idle desktop is not
+// composited, uploaded or streamed again. Clients write new buffers into
+// their surface textures in place, so a frame is fingerprinted by where
+// each surface is and by the bytes in its texture; hashing them costs far
+// less than compositing them.
+type DesktopDamage struct {
+	seed maphash.Seed
+	last uint64
+	// valid is false until a frame is fingerprinted, and after Invalidate
+	valid bool
+	// pixels fingerprints each client surface's texture, to tell which
+	// ones committed new content
+	pixels    map[surfaceKey]uint64
+	committed []PlacedSurface
+}
+
+// NewDesktopDamage creates a tracker that reports the first frame dirty
+func NewDesktopDamage() *DesktopDamage {
+	return &DesktopDamage{seed: maphash.MakeSeed(), pixels: make(map[surfaceKey]uint64)}
+}
+
+// Dirty reports whether placed, with the icon shown when nothing is, looks
+// different from the frame last passed to Dirty, and remembers it
+func (d *DesktopDamage) Dirty(placed []PlacedSurface, showIcon bool) bool {
+	var h maphash.Hash
+	h.SetSeed(d.seed)
+	var geometry [48]byte
+	pixels := make(map[surfaceKey]uint64, len(placed))
+	d.committed = d.committed[:0]
+	for _, p := range placed {
+		var sum uint64
+		if p.Texture != nil {
+			sum = maphash.Bytes(d.seed, p.Texture.Data)
+			binary.LittleEndian.PutUint32(geometry[32:], p.Texture.Width)
+			binary.LittleEndian.PutUint32(geometry[36:], p.Texture.Height)
+		} else {
+			clear(geometry[32:40])
+		}
+		binary.LittleEndian.PutUint32(geometry[0:], uint32(p.X))
+		binary.LittleEndian.PutUint32(geometry[4:], uint32(p.Y))
+		binary.LittleEndian.PutUint32(geometry[8:], uint32(p.Size.X))
+		binary.LittleEndian.PutUint32(geometry[12:], uint32(p.Size.Y))
+		binary.LittleEndian.PutUint32(geometry[16:], uint32(p.Source.Min.X))
+		binary.LittleEndian.PutUint32(geometry[20:], uint32(p.Source.Min.Y))
+		binary.LittleEndian.PutUint32(geometry[24:], uint32(p.Source.Max.X))
+		binary.LittleEndian.PutUint32(geometry[28:], uint32(p.Source.Max.Y))
+		binary.LittleEndian.PutUint64(geometry[40:], sum)
+		h.Write(geometry[:])
+
+		if p.Client != nil {
+			key := surfaceKey{client: p.Client, id: p.SurfaceID}
+			if previous, ok := d.pixels[key]; !ok || previous != sum {
+				d.committed = append(d.committed, p)
+			}
+			pixels[key] = sum
+		}
+	}
+	if showIcon {
+		h.WriteByte(1)
+	}
+	d.pixels = pixels
+
+	sum := h.Sum64()
+	dirty := !d.valid || sum != d.last
+	d.last, d.valid = sum, true
+	return dirty
+}
+
+// Committed returns the client surfaces whose pixels changed in the last
+// call to Dirty, including ones that were not on the desktop before. The
+// slice is reused by the next call.
+func (d *DesktopDamage) Committed() []PlacedSurface {
+	return d.committed
+}
+
+// Invalidate makes the next frame dirty, for when the desktop buffer or
+// what it is drawn into was replaced
+func (d *DesktopDamage) Invalidate() {
+	d.valid = false
+}
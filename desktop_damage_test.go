@@ -0,0 +1,60 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestDesktopDamage(t *testing.T) {
+	d := NewDesktopDamage()
+	texture := &wayland.Texture{Width: 1, Height: 1, Stride: 4, Data: []byte{1, 2, 3, 4}}
+	placed := []PlacedSurface{{Texture: texture, X: 5, Y: 5, Source: image.Rect(0, 0, 1, 1), Size: image.Pt(1, 1)}}
+
+	if !d.Dirty(placed, false) {
+		t.Error("the first frame should be dirty")
+	}
+	if d.Dirty(placed, false) {
+		t.Error("an unchanged frame should not be dirty")
+	}
+
+	// Clients write their buffers into the same texture
+	texture.Data[2] = 9
+	if !d.Dirty(placed, false) {
+		t.Error("a frame with new pixels should be dirty")
+	}
+	placed[0].X = 6
+	if !d.Dirty(placed, false) {
+		t.Error("a frame with a moved surface should be dirty")
+	}
+	if !d.Dirty(nil, true) || d.Dirty(nil, true) || !d.Dirty(nil, false) {
+		t.Error("showing or hiding the icon should make the frame dirty once")
+	}
+
+	d.Invalidate()
+	if !d.Dirty(nil, false) {
+		t.Error("a frame after Invalidate should be dirty")
+	}
+}
+
+func TestDesktopDamageCommitted(t *testing.T) {
+	d := NewDesktopDamage()
+	c := wayland.MakeClient(nil)
+	a := PlacedSurface{Client: c, SurfaceID: 1, Texture: &wayland.Texture{Width: 1, Height: 1, Stride: 4, Data: []byte{1, 1, 1, 1}}}
+	b := PlacedSurface{Client: c, SurfaceID: 2, Texture: &wayland.Texture{Width: 1, Height: 1, Stride: 4, Data: []byte{2, 2, 2, 2}}}
+	widget := PlacedSurface{Texture: &wayland.Texture{Width: 1, Height: 1, Stride: 4, Data: []byte{3, 3, 3, 3}}}
+
+	d.Dirty([]PlacedSurface{a, b, widget}, false)
+	if got := d.Committed(); len(got) != 2 {
+		t.Errorf("%d surfaces committed, want both client surfaces on first sight", len(got))
+	}
+
+	b.Texture.Data[0] = 9
+	b.X = 10
+	a.X = 10
+	d.Dirty([]PlacedSurface{a, b, widget}, false)
+	if got := d.Committed(); len(got) != 1 || got[0].SurfaceID != 2 {
+		t.Errorf("committed %v, want only the surface with new pixels", got)
+	}
+}
@@ -0,0 +1,151 @@
+package compositor
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// DesktopPipeline composites the desktop on the CPU on its own goroutine.
+// The render loop submits each frame's placed surfaces and carries on
+// with the model; it takes the newest frame the pipeline has finished, so
+// it is never held up by a slow composite, at the cost of showing the
+// desktop up to a frame late. A frame submitted while another waits to be
+// drawn replaces it.
+//
+// The desktop is triple buffered: the pipeline draws into a back buffer
+// while the newest finished one waits, and Take swaps the finished buffer
+// with the desktop's own, so the frame being uploaded and streamed is
+// never drawn into. Clients' frame callbacks wait until Busy reports the
+// frames submitted are composited.
+type DesktopPipeline struct {
+	jobs chan desktopJob
+	done chan struct{}
+
+	mu sync.Mutex
+	// finished is the newest composited desktop, fresh until taken
+	finished *wayland.Desktop
+	fresh    bool
+	// took is how long finished took to composite
+	took time.Duration
+	// submitted counts the frames submitted, and composited is the count
+	// when the newest finished one was
+	submitted, composited uint64
+}
+
+// desktopJob is a frame to composite: the desktop it is for, its buffer
+// not used, and the surfaces on it
+type desktopJob struct {
+	desktop wayland.Desktop
+	placed  []PlacedSurface
+	// seq is the submitted count with this frame
+	seq uint64
+}
+
+// NewDesktopPipeline starts a compositing goroutine; Close stops it
+func NewDesktopPipeline() *DesktopPipeline {
+	p := &DesktopPipeline{
+		jobs: make(chan desktopJob, 1),
+		done: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Submit queues placed, bottom first, to be composited for desktop. It
+// never blocks.
+func (p *DesktopPipeline) Submit(desktop *wayland.Desktop, placed []PlacedSurface) {
+	p.mu.Lock()
+	p.submitted++
+	job := desktopJob{desktop: *desktop, placed: placed, seq: p.submitted}
+	p.mu.Unlock()
+	for {
+		select {
+		case p.jobs <- job:
+			return
+		default:
+		}
+		// Drop the frame still waiting for this newer one
+		select {
+		case <-p.jobs:
+		default:
+		}
+	}
+}
+
+// Take swaps the newest finished frame in as desktop's buffer; the old
+// buffer is drawn into later. It reports false, leaving the buffer alone,
+// when no frame has finished since the last Take or the one that has is
+// for another desktop size.
+func (p *DesktopPipeline) Take(desktop *wayland.Desktop) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	finished := p.finished
+	if !p.fresh || finished.Width != desktop.Width || finished.Height != desktop.Height || finished.Stride != desktop.Stride {
+		return false
+	}
+	desktop.Buffer, finished.Buffer = finished.Buffer, desktop.Buffer
+	desktop.RGBA, finished.RGBA = finished.RGBA, desktop.RGBA
+	p.fresh = false
+	return true
+}
+
+// Busy reports whether a frame submitted is still waiting or being
+// composited
+func (p *DesktopPipeline) Busy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.composited != p.submitted
+}
+
+// CompositeTime returns how long the newest finished frame took to
+// composite
+func (p *DesktopPipeline) CompositeTime() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.took
+}
+
+// Close stops the compositing goroutine
+func (p *DesktopPipeline) Close() {
+	close(p.done)
+}
+
+func (p *DesktopPipeline) run() {
+	// back is drawn into while finished waits to be taken and the
+	// desktop's own buffer is shown
+	var back *wayland.Desktop
+	for {
+		select {
+		case job := <-p.jobs:
+			back = p.composite(back, job)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// composite draws job into back, or a new buffer when back is missing or
+// the wrong size, publishes it as the finished frame and returns the
+// buffer to draw the next one into
+func (p *DesktopPipeline) composite(back *wayland.Desktop, job desktopJob) *wayland.Desktop {
+	target := job.desktop
+	if back != nil && back.Width == target.Width && back.Height == target.Height && back.Stride == target.Stride {
+		target.Buffer, target.RGBA = back.Buffer, back.RGBA
+	} else {
+		target.Buffer = make([]byte, target.Height*target.Stride)
+		target.RGBA = &image.RGBA{Pix: target.Buffer, Stride: target.Stride, Rect: image.Rect(0, 0, target.Width, target.Height)}
+	}
+	start := time.Now()
+	compositeDesktop(&target, job.placed)
+	took := time.Since(start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := p.finished
+	p.finished, p.fresh, p.took = &target, true, took
+	p.composited = job.seq
+	return next
+}
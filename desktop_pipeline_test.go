@@ -0,0 +1,108 @@
+package compositor
+
+import (
+	"bytes"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// takeWithin waits up to a second for the pipeline to finish a frame
+func takeWithin(t *testing.T, p *DesktopPipeline, desktop *wayland.Desktop) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !p.Take(desktop) {
+		if time.Now().After(deadline) {
+			t.Fatal("no frame finished")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDesktopPipelineComposites(t *testing.T) {
+	p := NewDesktopPipeline()
+	defer p.Close()
+	desktop := wayland.MakeDesktop(wayland.Size{Width: 4, Height: 4}, false, nil)
+
+	red := &wayland.Texture{Width: 2, Height: 2, Stride: 8, Data: []byte{
+		255, 0, 0, 255, 255, 0, 0, 255,
+		255, 0, 0, 255, 255, 0, 0, 255,
+	}}
+	placed := []PlacedSurface{{Texture: red, X: 1, Y: 1, Source: image.Rect(0, 0, 2, 2), Size: image.Pt(2, 2)}}
+	p.Submit(desktop, placed)
+	takeWithin(t, p, desktop)
+	if p.Busy() {
+		t.Error("the pipeline is busy after its only frame finished")
+	}
+
+	if got := desktop.RGBA.RGBAAt(1, 1); got.R != 255 || got.A != 255 {
+		t.Errorf("surface pixel %v, want red", got)
+	}
+	if got := desktop.RGBA.RGBAAt(0, 0); got.A != 0 {
+		t.Errorf("pixel outside the surface %v, want clear", got)
+	}
+	if p.Take(desktop) {
+		t.Error("a frame should only be taken once")
+	}
+
+	// An empty desktop is drawn into the other buffer and cleared
+	p.Submit(desktop, nil)
+	takeWithin(t, p, desktop)
+	if got := desktop.RGBA.RGBAAt(1, 1); got.A != 0 {
+		t.Errorf("surface still drawn after it went away: %v", got)
+	}
+}
+
+func TestDesktopPipelineNeverDrawsIntoTheTakenFrame(t *testing.T) {
+	p := NewDesktopPipeline()
+	defer p.Close()
+	desktop := wayland.MakeDesktop(wayland.Size{Width: 2, Height: 2}, false, nil)
+	white := &wayland.Texture{Width: 2, Height: 2, Stride: 8, Data: bytes.Repeat([]byte{255}, 16)}
+	placed := []PlacedSurface{{Texture: white, Source: image.Rect(0, 0, 2, 2), Size: image.Pt(2, 2)}}
+
+	for range 4 {
+		p.Submit(desktop, placed)
+		takeWithin(t, p, desktop)
+		shown := desktop.Buffer
+
+		// The next frame, empty, finishes while this one is shown
+		p.Submit(desktop, nil)
+		deadline := time.Now().Add(time.Second)
+		for {
+			p.mu.Lock()
+			fresh := p.fresh
+			p.mu.Unlock()
+			if fresh {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("no frame finished")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if !bytes.Equal(shown, white.Data) {
+			t.Fatal("the next frame was drawn into the one shown")
+		}
+		takeWithin(t, p, desktop)
+		if &desktop.Buffer[0] == &shown[0] || &desktop.RGBA.Pix[0] != &desktop.Buffer[0] {
+			t.Fatal("Take did not swap in the finished buffer and its image")
+		}
+	}
+}
+
+func TestDesktopPipelineSkipsOtherSizes(t *testing.T) {
+	p := NewDesktopPipeline()
+	defer p.Close()
+	small := wayland.MakeDesktop(wayland.Size{Width: 2, Height: 2}, false, nil)
+	p.Submit(small, nil)
+	takeWithin(t, p, small)
+
+	p.Submit(small, nil)
+	time.Sleep(10 * time.Millisecond)
+	large := wayland.MakeDesktop(wayland.Size{Width: 4, Height: 4}, false, nil)
+	if p.Take(large) {
+		t.Error("a frame for the old desktop size was taken after a resize")
+	}
+}
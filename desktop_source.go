@@ -0,0 +1,271 @@
+package compositor
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// Surface ids of the desktop sources' textures, apart from the widgets',
+// which also have no client, in the GPU compositor
+const (
+	mirrorSurfaceID = 1<<30 + iota
+	sourceSurfaceID
+)
+
+// DesktopSource is something other than the Wayland clients that shows on
+// the desktop, under them: another compositor's output, a video, a camera
+// or a test card
+type DesktopSource interface {
+	// Placed returns the source's newest frame fitted into bounds, or
+	// nothing before its first frame
+	Placed(bounds image.Rectangle) []PlacedSurface
+	// Reclaim lets the source draw into the frames placed so far again,
+	// once nothing composited from them is in flight
+	Reclaim()
+	Close()
+}
+
+// NewDesktopSource makes the -source given by spec: "desktop" for none,
+// "testcard", "video:<file>" or "camera:<device>". Frames are made size, the desktop's size.
+func NewDesktopSource(spec string, size image.Point) (DesktopSource, error) {
+	switch {
+	case spec == "" || spec == "desktop":
+		return nil, nil
+	case spec == "testcard":
+		return NewTestCard(), nil
+	case strings.HasPrefix(spec, "video:"):
+		path := strings.TrimPrefix(spec, "video:")
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		return NewVideoSource(path, size), nil
+	case strings.HasPrefix(spec, "camera:"):
+		device := strings.TrimPrefix(spec, "camera:")
+		if _, err := os.Stat(device); err != nil {
+			return nil, err
+		}
+		return NewCameraSource(device, size), nil
+	}
+	return nil, fmt.Errorf("unknown source %q, want desktop, testcard, video:<file> or camera:<device>", spec)
+}
+
+// sourceFrames hands a source's frames to the render loop, placed on the
+// desktop or as a TextureSource. Its textures take turns: the newest is
+// the front frame, and the oldest one not lent out is drawn into next. A
+// frame placed or read is lent until Reclaim, since the desktop pipeline
+// may composite it a few frames later; while every texture is lent, the
+// next frame is drawn into a new one.
+type sourceFrames struct {
+	id protocols.ObjectID[protocols.WlSurface]
+	// surface stands in for a client surface in PlacedSurface
+	surface *wayland.WlSurface
+
+	mu sync.Mutex
+	// textures are the frames, newest first
+	textures [3]*wayland.Texture
+	// lent are the textures handed out since the last Reclaim
+	lent map[*wayland.Texture]bool
+	// published counts the frames, for Damage
+	published uint64
+}
+
+func newSourceFrames(id protocols.ObjectID[protocols.WlSurface]) *sourceFrames {
+	return &sourceFrames{id: id, surface: &wayland.WlSurface{}, lent: make(map[*wayland.Texture]bool)}
+}
+
+// back returns the texture to draw the next frame into, width by height
+// RGBA
+func (f *sourceFrames) back(width, height uint32) *wayland.Texture {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.textures) - 1; i > 0; i-- {
+		next := f.textures[i]
+		if next != nil && !f.lent[next] && next.Width == width && next.Height == height {
+			return next
+		}
+	}
+	return &wayland.Texture{Width: width, Height: height, Stride: width * 4, Data: make([]byte, width*height*4)}
+}
+
+// publish makes texture, from back, the front frame
+func (f *sourceFrames) publish(texture *wayland.Texture) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	older := slices.DeleteFunc(f.textures[:], func(t *wayland.Texture) bool { return t == texture })
+	copy(f.textures[1:], older)
+	f.textures[0] = texture
+	f.published++
+}
+
+// Reclaim takes back the frames lent so far
+func (f *sourceFrames) Reclaim() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clear(f.lent)
+}
+
+// Frame returns the front frame's pixels, lent until Reclaim
+func (f *sourceFrames) Frame() ([]byte, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if texture := f.textures[0]; texture != nil {
+		f.lent[texture] = true
+		return texture.Data, int(texture.Stride)
+	}
+	return nil, 0
+}
+
+// Size returns the front frame's size
+func (f *sourceFrames) Size() image.Point {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if texture := f.textures[0]; texture != nil {
+		return image.Pt(int(texture.Width), int(texture.Height))
+	}
+	return image.Point{}
+}
+
+// Damage counts the frames published
+func (f *sourceFrames) Damage() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.published
+}
+
+// Placed returns the front frame fitted into bounds, keeping its aspect
+// ratio, lent until Reclaim
+func (f *sourceFrames) Placed(bounds image.Rectangle) []PlacedSurface {
+	f.mu.Lock()
+	texture, frame := f.textures[0], f.published
+	if texture == nil || bounds.Empty() {
+		f.mu.Unlock()
+		return nil
+	}
+	f.lent[texture] = true
+	f.mu.Unlock()
+	size := image.Pt(int(texture.Width), int(texture.Height))
+	fit := fitRect(size, bounds)
+	return []PlacedSurface{{
+		Surface:   f.surface,
+		SurfaceID: f.id,
+		Root:      f.id,
+		Texture:   texture,
+		Frame:     frame,
+		X:         fit.Min.X,
+		Y:         fit.Min.Y,
+		Source:    image.Rectangle{Max: size},
+		Size:      fit.Size(),
+	}}
+}
+
+// fitRect is the largest rectangle of size's aspect ratio centred in bounds
+func fitRect(size image.Point, bounds image.Rectangle) image.Rectangle {
+	if size.X <= 0 || size.Y <= 0 {
+		return image.Rectangle{}
+	}
+	w, h := bounds.Dx(), bounds.Dy()
+	if w*size.Y > h*size.X {
+		w = h * size.X / size.Y
+	} else {
+		h = w * size.Y / size.X
+	}
+	origin := bounds.Min.Add(image.Pt((bounds.Dx()-w)/2, (bounds.Dy()-h)/2))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(w, h))}
+}
+
+// VideoSource plays a video file on the desktop, looping, or a V4L2
+// camera's feed, decoded by ffmpeg to RGBA at the desktop's size and paced
+// to the video's frame rate
+type VideoSource struct {
+	*sourceFrames
+	path string
+	// input is ffmpeg's input options, ending with -i
+	input  []string
+	size   image.Point
+	cancel context.CancelFunc
+}
+
+// NewVideoSource starts playing path at size
+func NewVideoSource(path string, size image.Point) *VideoSource {
+	return newVideoSource(path, []string{"-re", "-stream_loop", "-1", "-i", path}, size)
+}
+
+// NewCameraSource starts capturing the V4L2 device, such as /dev/video0, at
+// size. Frames come as fast as the camera takes them.
+func NewCameraSource(device string, size image.Point) *VideoSource {
+	return newVideoSource(device, []string{"-f", "v4l2", "-i", device}, size)
+}
+
+func newVideoSource(path string, input []string, size image.Point) *VideoSource {
+	ctx, cancel := context.WithCancel(context.Background())
+	v := &VideoSource{sourceFrames: newSourceFrames(sourceSurfaceID), path: path, input: input, size: size, cancel: cancel}
+	go v.run(ctx)
+	return v
+}
+
+// Close stops ffmpeg
+func (v *VideoSource) Close() {
+	v.cancel()
+}
+
+// run runs ffmpeg until Close, restarting it with backoff if it stops
+func (v *VideoSource) run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := v.decode(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > audioMaxBackoff {
+			backoff = time.Second
+		}
+		log.Printf("Video %q stopped: %v; restarting in %v", v.path, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, audioMaxBackoff)
+	}
+}
+
+// decode runs ffmpeg once, publishing each frame it writes
+func (v *VideoSource) decode(ctx context.Context) error {
+	w, h := v.size.X, v.size.Y
+	// Letterbox to exactly the desktop's size, so every frame is the same
+	// number of bytes
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2", w, h, w, h)
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, v.input...)
+	args = append(args, "-an", "-vf", scale, "-pix_fmt", "rgba", "-f", "rawvideo", "-")
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	for {
+		texture := v.back(uint32(w), uint32(h))
+		if _, err := io.ReadFull(stdout, texture.Data); err != nil {
+			return err
+		}
+		v.publish(texture)
+	}
+}
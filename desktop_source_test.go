@@ -0,0 +1,135 @@
+package compositor
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDesktopSource(t *testing.T) {
+	size := image.Pt(64, 48)
+	if source, err := NewDesktopSource("desktop", size); source != nil || err != nil {
+		t.Errorf("desktop = %v, %v, want no source", source, err)
+	}
+	if _, err := NewDesktopSource("webcam", size); err == nil {
+		t.Error("unknown source accepted")
+	}
+	if _, err := NewDesktopSource("video:"+filepath.Join(t.TempDir(), "missing.mp4"), size); err == nil {
+		t.Error("missing video accepted")
+	}
+	if _, err := NewDesktopSource("camera:"+filepath.Join(t.TempDir(), "video9"), size); err == nil {
+		t.Error("missing camera accepted")
+	}
+	source, err := NewDesktopSource("testcard", size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	if _, ok := source.(*TestCard); !ok {
+		t.Errorf("testcard made a %T", source)
+	}
+}
+
+func TestTestCardChangesEveryFrame(t *testing.T) {
+	card := NewTestCard()
+	bounds := image.Rect(0, 0, 320, 240)
+	var frames []*PlacedSurface
+	var firstPixels string
+	for range 4 {
+		placed := card.Placed(bounds)
+		if len(placed) != 1 {
+			t.Fatalf("placed %d surfaces, want 1", len(placed))
+		}
+		frames = append(frames, &placed[0])
+		if firstPixels == "" {
+			firstPixels = string(placed[0].Texture.Data)
+		}
+	}
+	first, second := frames[0], frames[1]
+	if first.Texture == second.Texture {
+		t.Error("consecutive frames share a texture")
+	}
+	if string(first.Texture.Data) == string(second.Texture.Data) {
+		t.Error("consecutive frames look the same")
+	}
+	if first.Size != bounds.Size() || first.Texture.Width != 320 {
+		t.Errorf("frame is %v, %dpx wide, want the desktop's size", first.Size, first.Texture.Width)
+	}
+	// Frames placed may still be composited, so they are not drawn into
+	// until they are reclaimed
+	if frames[3].Texture == first.Texture || string(first.Texture.Data) != firstPixels {
+		t.Error("a texture placed was drawn into again")
+	}
+	card.Reclaim()
+	reused := card.Placed(bounds)[0].Texture
+	if !slices.ContainsFunc(frames[:3], func(p *PlacedSurface) bool { return p.Texture == reused }) {
+		t.Error("textures are not reused once reclaimed")
+	}
+}
+
+func TestVideoSourceReadsFrames(t *testing.T) {
+	// A stand-in ffmpeg that writes one white 2x2 frame
+	bin := t.TempDir()
+	script := "#!/bin/sh\nhead -c 16 /dev/zero | tr '\\000' '\\377'\nexec sleep 5\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	video := NewVideoSource("clip.mp4", image.Pt(2, 2))
+	defer video.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	var placed []PlacedSurface
+	for len(placed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no frame decoded")
+		}
+		time.Sleep(time.Millisecond)
+		placed = video.Placed(image.Rect(0, 0, 4, 4))
+	}
+	if placed[0].Size != image.Pt(4, 4) || placed[0].Texture.Data[0] != 255 {
+		t.Errorf("placed %v with %v, want a white frame filling 4x4", placed[0].Size, placed[0].Texture.Data)
+	}
+}
+
+func TestCameraSourceCapturesV4L2(t *testing.T) {
+	// A stand-in ffmpeg that notes its arguments and writes one frame
+	bin := t.TempDir()
+	args := filepath.Join(bin, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\nhead -c 16 /dev/zero\nexec sleep 5\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	camera := NewCameraSource("/dev/video0", image.Pt(2, 2))
+	defer camera.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for camera.Damage() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no frame captured")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	written, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(written), "-f v4l2 -i /dev/video0") || strings.Contains(string(written), "-stream_loop") {
+		t.Errorf("ffmpeg ran with %q, want a V4L2 capture", written)
+	}
+}
+
+func TestFitRect(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	if got := fitRect(image.Pt(100, 100), bounds); got != image.Rect(50, 0, 150, 100) {
+		t.Errorf("square in wide bounds = %v", got)
+	}
+	if got := fitRect(image.Pt(400, 100), bounds); got != image.Rect(0, 25, 200, 75) {
+		t.Errorf("wide in bounds = %v", got)
+	}
+}
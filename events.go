@@ -0,0 +1,316 @@
+package compositor
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// ToplevelMappedEvent is emitted when a toplevel window first shows up on
+// the desktop
+type ToplevelMappedEvent struct {
+	Client    *wayland.Client
+	SurfaceID protocols.ObjectID[protocols.WlSurface]
+	AppID     string
+	Title     string
+}
+
+// ToplevelUnmappedEvent is emitted when a toplevel window leaves the
+// desktop, because it was destroyed, unmapped or its client went away.
+// AppID and Title are the last ones it had.
+type ToplevelUnmappedEvent struct {
+	Client    *wayland.Client
+	SurfaceID protocols.ObjectID[protocols.WlSurface]
+	AppID     string
+	Title     string
+}
+
+// ToplevelChangedEvent is emitted when a mapped toplevel changes its title
+// or app id
+type ToplevelChangedEvent struct {
+	Client        *wayland.Client
+	SurfaceID     protocols.ObjectID[protocols.WlSurface]
+	AppID         string
+	Title         string
+	PreviousAppID string
+	PreviousTitle string
+}
+
+// SurfaceCommittedEvent is emitted when a client surface on the desktop
+// shows new content. Commits are seen once a frame, so several between
+// two frames are one event, and surfaces that are hidden are not watched.
+type SurfaceCommittedEvent struct {
+	Client    *wayland.Client
+	SurfaceID protocols.ObjectID[protocols.WlSurface]
+	Frame     uint64
+}
+
+// ClientConnectedEvent is emitted on the frame after a Wayland client
+// connects
+type ClientConnectedEvent struct {
+	Client *wayland.Client
+}
+
+// ClientDisconnectedEvent is emitted once a Wayland client is gone, whether
+// it quit or was dropped by the compositor
+type ClientDisconnectedEvent struct {
+	Client *wayland.Client
+}
+
+// FrameCompositedEvent is emitted after every composited desktop frame
+type FrameCompositedEvent struct {
+	Frame   uint64
+	Time    time.Time
+	Visible int
+	Hidden  int
+}
+
+// ViewerJoinedEvent is emitted when a browser connects to the desktop stream
+type ViewerJoinedEvent struct {
+	RemoteAddr string
+	Viewers    int
+}
+
+// PreviewRecoveredEvent is emitted after the preview window and its GL
+// context were rebuilt
+type PreviewRecoveredEvent struct {
+	Reason string
+	Time   time.Time
+}
+
+// PowerChangedEvent is emitted when -power-governor changes how much the
+// compositor is held back, with the reading that made it
+type PowerChangedEvent struct {
+	Level    string
+	Previous string
+	Reason   string
+	Reading  PowerReading
+	Time     time.Time
+}
+
+// OutputPowerChangedEvent is emitted when the output is turned on or off
+// through the control API
+type OutputPowerChangedEvent struct {
+	On   bool
+	Time time.Time
+}
+
+// AnimationPlayback is where the model's animation is, see
+// render.AnimationPlayback
+type AnimationPlayback = render.AnimationPlayback
+
+// AnimationLayerInfo describes an animation layered over the current one
+type AnimationLayerInfo = render.AnimationLayerInfo
+
+// AnimationPlaybackEvent is emitted when the model's animation is played,
+// paused, resumed, sought, stopped, loops or finishes, see
+// render.AnimationPlaybackEvent
+type AnimationPlaybackEvent = render.AnimationPlaybackEvent
+
+// Events is a typed event bus for code embedding the compositor. Handlers
+// run synchronously on the goroutine that emits the event (the render loop
+// for everything but ViewerJoined), so they must return quickly.
+type Events struct {
+	toplevelMapped     handlerSet[ToplevelMappedEvent]
+	toplevelUnmapped   handlerSet[ToplevelUnmappedEvent]
+	toplevelChanged    handlerSet[ToplevelChangedEvent]
+	surfaceCommitted   handlerSet[SurfaceCommittedEvent]
+	clientConnected    handlerSet[ClientConnectedEvent]
+	clientDisconnected handlerSet[ClientDisconnectedEvent]
+	frameComposited    handlerSet[FrameCompositedEvent]
+	viewerJoined       handlerSet[ViewerJoinedEvent]
+	previewRecovered   handlerSet[PreviewRecoveredEvent]
+	powerChanged       handlerSet[PowerChangedEvent]
+	outputPower        handlerSet[OutputPowerChangedEvent]
+	animationPlayback  handlerSet[AnimationPlaybackEvent]
+}
+
+// NewEvents creates an event bus with no subscribers
+func NewEvents() *Events {
+	return &Events{}
+}
+
+// OnToplevelMapped subscribes to toplevels appearing. The returned function
+// unsubscribes.
+func (e *Events) OnToplevelMapped(handler func(ToplevelMappedEvent)) func() {
+	return e.toplevelMapped.add(handler)
+}
+
+// OnToplevelUnmapped subscribes to toplevels leaving the desktop. The
+// returned function unsubscribes.
+func (e *Events) OnToplevelUnmapped(handler func(ToplevelUnmappedEvent)) func() {
+	return e.toplevelUnmapped.add(handler)
+}
+
+// OnToplevelChanged subscribes to toplevels changing their title or app
+// id. The returned function unsubscribes.
+func (e *Events) OnToplevelChanged(handler func(ToplevelChangedEvent)) func() {
+	return e.toplevelChanged.add(handler)
+}
+
+// OnSurfaceCommitted subscribes to surfaces on the desktop showing new
+// content, up to once a frame each. The returned function unsubscribes.
+func (e *Events) OnSurfaceCommitted(handler func(SurfaceCommittedEvent)) func() {
+	return e.surfaceCommitted.add(handler)
+}
+
+// OnClientConnected subscribes to new Wayland clients. The returned
+// function unsubscribes.
+func (e *Events) OnClientConnected(handler func(ClientConnectedEvent)) func() {
+	return e.clientConnected.add(handler)
+}
+
+// OnClientDisconnected subscribes to clients going away. The returned
+// function unsubscribes.
+func (e *Events) OnClientDisconnected(handler func(ClientDisconnectedEvent)) func() {
+	return e.clientDisconnected.add(handler)
+}
+
+// OnFrameComposited subscribes to composited frames. The returned function
+// unsubscribes.
+func (e *Events) OnFrameComposited(handler func(FrameCompositedEvent)) func() {
+	return e.frameComposited.add(handler)
+}
+
+// OnViewerJoined subscribes to WebSocket viewers connecting. The returned
+// function unsubscribes.
+func (e *Events) OnViewerJoined(handler func(ViewerJoinedEvent)) func() {
+	return e.viewerJoined.add(handler)
+}
+
+// OnPreviewRecovered subscribes to the preview window being rebuilt. The
+// returned function unsubscribes.
+func (e *Events) OnPreviewRecovered(handler func(PreviewRecoveredEvent)) func() {
+	return e.previewRecovered.add(handler)
+}
+
+// OnPowerChanged subscribes to the power governor changing level. The
+// returned function unsubscribes.
+func (e *Events) OnPowerChanged(handler func(PowerChangedEvent)) func() {
+	return e.powerChanged.add(handler)
+}
+
+// OnOutputPowerChanged subscribes to the output being turned on or off.
+// The returned function unsubscribes.
+func (e *Events) OnOutputPowerChanged(handler func(OutputPowerChangedEvent)) func() {
+	return e.outputPower.add(handler)
+}
+
+// OnAnimationPlayback subscribes to changes in the model's animation
+// playback. The returned function unsubscribes.
+func (e *Events) OnAnimationPlayback(handler func(AnimationPlaybackEvent)) func() {
+	return e.animationPlayback.add(handler)
+}
+
+// handlerSet holds the subscribers of one event type in subscription order
+type handlerSet[E any] struct {
+	mu       sync.Mutex
+	nextID   int
+	handlers []subscriber[E]
+}
+
+type subscriber[E any] struct {
+	id      int
+	handler func(E)
+}
+
+func (h *handlerSet[E]) add(handler func(E)) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	id := h.nextID
+	h.handlers = append(h.handlers, subscriber[E]{id: id, handler: handler})
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for i, s := range h.handlers {
+			if s.id == id {
+				h.handlers = append(h.handlers[:i:i], h.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// emit calls every handler without holding the lock, so handlers may
+// subscribe or unsubscribe
+func (h *handlerSet[E]) emit(event E) {
+	h.mu.Lock()
+	handlers := h.handlers
+	h.mu.Unlock()
+	for _, s := range handlers {
+		s.handler(event)
+	}
+}
+
+// toplevelWatcher remembers which toplevels were on the desktop last frame,
+// and what they were called, so each change is reported once
+type toplevelWatcher struct {
+	mapped map[surfaceKey]toplevelName
+}
+
+// toplevelName is a toplevel's app id and title
+type toplevelName struct {
+	AppID, Title string
+}
+
+// toplevelChanges is what happened to the toplevels since the last frame
+type toplevelChanges struct {
+	Mapped   []ToplevelMappedEvent
+	Unmapped []ToplevelUnmappedEvent
+	Changed  []ToplevelChangedEvent
+}
+
+// Update compares the toplevels in placed with those there last time
+func (w *toplevelWatcher) Update(placed []PlacedSurface) toplevelChanges {
+	var changes toplevelChanges
+	current := make(map[surfaceKey]toplevelName)
+	for _, p := range placed {
+		role, ok := p.Surface.Role.(*wayland.SurfaceRoleXdgToplevel)
+		if !ok {
+			continue
+		}
+		key := surfaceKey{client: p.Client, id: p.SurfaceID}
+		var name toplevelName
+		if role.Data != nil {
+			if toplevel := wayland.GetXdgToplevelObject(p.Client, *role.Data); toplevel != nil {
+				name.AppID = toplevel.AppID
+				if toplevel.Title != nil {
+					name.Title = *toplevel.Title
+				}
+			}
+		}
+		current[key] = name
+
+		previous, wasMapped := w.mapped[key]
+		switch {
+		case !wasMapped:
+			changes.Mapped = append(changes.Mapped, ToplevelMappedEvent{Client: p.Client, SurfaceID: p.SurfaceID, AppID: name.AppID, Title: name.Title})
+		case previous != name:
+			changes.Changed = append(changes.Changed, ToplevelChangedEvent{
+				Client:        p.Client,
+				SurfaceID:     p.SurfaceID,
+				AppID:         name.AppID,
+				Title:         name.Title,
+				PreviousAppID: previous.AppID,
+				PreviousTitle: previous.Title,
+			})
+		}
+	}
+	for key, name := range w.mapped {
+		if _, ok := current[key]; !ok {
+			changes.Unmapped = append(changes.Unmapped, ToplevelUnmappedEvent{Client: key.client, SurfaceID: key.id, AppID: name.AppID, Title: name.Title})
+		}
+	}
+	// Map order is random; report in a stable one
+	slices.SortFunc(changes.Unmapped, func(a, b ToplevelUnmappedEvent) int {
+		return int(a.SurfaceID) - int(b.SurfaceID)
+	})
+	w.mapped = current
+	return changes
+}
@@ -0,0 +1,53 @@
+package compositor
+
+import (
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestEventsSubscribeAndUnsubscribe(t *testing.T) {
+	events := NewEvents()
+	var got []uint64
+	unsubscribe := events.OnFrameComposited(func(e FrameCompositedEvent) {
+		got = append(got, e.Frame)
+	})
+	events.OnFrameComposited(func(e FrameCompositedEvent) {
+		got = append(got, e.Frame*10)
+	})
+
+	events.frameComposited.emit(FrameCompositedEvent{Frame: 1})
+	unsubscribe()
+	events.frameComposited.emit(FrameCompositedEvent{Frame: 2})
+
+	if len(got) != 3 || got[0] != 1 || got[1] != 10 || got[2] != 20 {
+		t.Errorf("Handlers should run in subscription order until unsubscribed, got %v", got)
+	}
+}
+
+func TestToplevelWatcher(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	window := PlacedSurface{Client: c, SurfaceID: 7, Surface: &wayland.WlSurface{Role: &wayland.SurfaceRoleXdgToplevel{}}}
+	cursor := PlacedSurface{Client: c, SurfaceID: 8, Surface: &wayland.WlSurface{}}
+
+	var w toplevelWatcher
+	if changes := w.Update([]PlacedSurface{window, cursor}); len(changes.Mapped) != 1 || changes.Mapped[0].SurfaceID != 7 {
+		t.Fatalf("Expected toplevel 7 mapped, got %+v", changes)
+	}
+	if changes := w.Update([]PlacedSurface{window}); len(changes.Mapped)+len(changes.Unmapped)+len(changes.Changed) != 0 {
+		t.Errorf("Toplevel should be reported once, got %+v", changes)
+	}
+	if changes := w.Update(nil); len(changes.Unmapped) != 1 || changes.Unmapped[0].SurfaceID != 7 {
+		t.Errorf("Expected toplevel 7 unmapped, got %+v", changes)
+	}
+	if changes := w.Update([]PlacedSurface{window}); len(changes.Mapped) != 1 {
+		t.Errorf("Toplevel should be reported again after unmapping, got %+v", changes)
+	}
+
+	// A toplevel that was called something else last frame has changed
+	w.mapped[surfaceKey{client: c, id: 7}] = toplevelName{AppID: "foot", Title: "~"}
+	changes := w.Update([]PlacedSurface{window})
+	if len(changes.Changed) != 1 || changes.Changed[0].PreviousAppID != "foot" || changes.Changed[0].PreviousTitle != "~" {
+		t.Errorf("Expected the rename reported, got %+v", changes)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !nogl
+
+package compositor
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mmulet/pupapppupps/render"
+)
+
+func TestExpressionsFromConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"expressions": {
+		"happy": {"morphs": {"Smile": 1}, "animation": "Wag", "loop": true, "tint": [1, 0.9, 0.6]},
+		"alert": {"flash": {"color": [1, 0, 0], "duration": "500ms"}, "layers": [{"name": "Wave", "bones": ["Right*"]}]}
+	}}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	expressions := render.Expressions{}
+	fs.Var(&expressions, "expressions", "")
+	config, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyConfig(fs, config, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := expressions.Names(); !reflect.DeepEqual(got, []string{"alert", "happy"}) {
+		t.Fatalf("Expected alert and happy, got %v", got)
+	}
+	if happy := expressions["happy"]; happy.Morphs["Smile"] != 1 || happy.Animation != "Wag" || *happy.Tint != [3]float32{1, 0.9, 0.6} {
+		t.Errorf("Unexpected happy preset %+v", happy)
+	}
+	if flash := expressions["alert"].Flash; flash.Duration != "500ms" {
+		t.Errorf("Expected a 500ms flash, got %q", flash.Duration)
+	}
+
+	// Reloading the same presets changes nothing
+	changed, err := applyConfig(fs, config, nil)
+	if err != nil || len(changed) != 0 {
+		t.Errorf("Expected no changes on reload, got %v (%v)", changed, err)
+	}
+}
@@ -0,0 +1,50 @@
+package compositor
+
+import "strings"
+
+// Features are the parts of the compositor a build can leave out, for
+// embedded deployments that want a smaller binary without the libraries
+// they need. Each has a build tag that turns it off:
+//
+//	nogl      OpenGL (go-gl): the 3D preview and GPU compositing
+//	nosdl     SDL2 (go-sdl2): the preview window and its input
+//	noweb     the HTTP server (gorilla/websocket): the viewer, the control API and pupctl
+//	nostream  the -output sinks: raw frames, RTMP live streams and v4l2 webcams
+//
+// Each tag leaves out its feature alone, but for the preview window, which
+// only shows what OpenGL draws in it: without OpenGL or SDL the compositor
+// runs headless, with no preview window, clients composited on the CPU,
+// and the desktop sent to whichever of the viewers and the -output sinks
+// are built in.
+type Features struct {
+	GL     bool `json:"gl"`
+	SDL    bool `json:"sdl"`
+	Web    bool `json:"web"`
+	Stream bool `json:"stream"`
+}
+
+// BuiltFeatures reports the features built into this binary
+func BuiltFeatures() Features {
+	return Features{GL: featureGL, SDL: featureSDL, Web: featureWeb, Stream: featureStream}
+}
+
+// Full reports whether every feature is built in
+func (f Features) Full() bool {
+	return f.GL && f.SDL && f.Web && f.Stream
+}
+
+// String lists the features, those left out with a leading "-", e.g.
+// "gl sdl -web stream"
+func (f Features) String() string {
+	var names []string
+	for _, feature := range []struct {
+		name  string
+		built bool
+	}{{"gl", f.GL}, {"sdl", f.SDL}, {"web", f.Web}, {"stream", f.Stream}} {
+		if !feature.built {
+			feature.name = "-" + feature.name
+		}
+		names = append(names, feature.name)
+	}
+	return strings.Join(names, " ")
+}
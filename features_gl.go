@@ -0,0 +1,6 @@
+//go:build !nogl
+
+package compositor
+
+// featureGL is whether this build has OpenGL, see Features
+const featureGL = true
@@ -0,0 +1,17 @@
+//go:build noweb
+
+package compositor
+
+import (
+	"fmt"
+	"io"
+)
+
+// featureWeb is whether this build has the web server, see Features
+const featureWeb = false
+
+// runCtl fails: there is no control API to talk to without the web server
+func runCtl(args []string, stdout, stderr io.Writer) int {
+	fmt.Fprintln(stderr, "pupctl: this build has no control API (built with noweb)")
+	return 1
+}
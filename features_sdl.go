@@ -0,0 +1,6 @@
+//go:build !nosdl
+
+package compositor
+
+// featureSDL is whether this build has SDL, see Features
+const featureSDL = true
@@ -0,0 +1,6 @@
+//go:build !nostream
+
+package compositor
+
+// featureStream is whether this build has the -output sinks, see Features
+const featureStream = true
@@ -0,0 +1,80 @@
+package compositor
+
+import (
+	"os/exec"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFeaturesString(t *testing.T) {
+	f := Features{GL: true, SDL: true, Stream: true}
+	if got := f.String(); got != "gl sdl -web stream" {
+		t.Errorf("Expected \"gl sdl -web stream\", got %q", got)
+	}
+	if f.Full() {
+		t.Error("A build without the web server is not full")
+	}
+}
+
+// featureDependencies are the modules each tag leaves out of the build
+var featureDependencies = map[string]string{
+	"nogl":  "github.com/go-gl/gl",
+	"nosdl": "github.com/veandco/go-sdl2",
+	"noweb": "github.com/gorilla/websocket",
+}
+
+// featureImplies are the features a tag leaves out along with its own: the
+// preview window only shows what OpenGL draws in it
+var featureImplies = map[string]string{
+	"nogl": "nosdl",
+}
+
+// TestFeatureMatrix builds the compositor and its tests with each feature
+// left out and with all of them left out, and checks that the modules a
+// feature needs are left out with it, and that the other features' are not
+func TestFeatureMatrix(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the compositor once per feature")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command")
+	}
+	// Keep the tags the tests were built with
+	var built string
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "-tags" {
+				built = setting.Value + ","
+			}
+		}
+	}
+	for _, tags := range []string{"nogl", "nosdl", "noweb", "nostream", "nogl,nosdl,noweb,nostream"} {
+		t.Run(tags, func(t *testing.T) {
+			out, err := exec.Command("go", "list", "-tags", built+tags, "-deps", "./cmd/wayland-compositor").CombinedOutput()
+			if err != nil {
+				t.Fatalf("go list: %v\n%s", err, out)
+			}
+			deps := strings.Fields(string(out))
+			left := strings.Split(built+tags, ",")
+			for _, tag := range left {
+				if implied, ok := featureImplies[tag]; ok {
+					left = append(left, implied)
+				}
+			}
+			for tag, module := range featureDependencies {
+				i := slices.IndexFunc(deps, func(dep string) bool { return strings.HasPrefix(dep, module) })
+				switch {
+				case slices.Contains(left, tag) && i >= 0:
+					t.Errorf("Built with %s, but still depends on %s", tag, deps[i])
+				case !slices.Contains(left, tag) && i < 0:
+					t.Errorf("Built with %s, but no longer depends on %s", tags, module)
+				}
+			}
+			if out, err := exec.Command("go", "vet", "-tags", built+tags, "./...").CombinedOutput(); err != nil {
+				t.Errorf("go vet: %v\n%s", err, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !noweb
+
+package compositor
+
+// featureWeb is whether this build has the web server, see Features
+const featureWeb = true
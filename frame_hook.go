@@ -0,0 +1,107 @@
+package compositor
+
+import (
+	"image"
+	"time"
+
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+// Frame is a composited desktop frame handed to a FrameHook
+type Frame struct {
+	// Sequence counts the composited frames, so a hook that samples them
+	// sees the gaps between the ones it got
+	Sequence uint64
+	// Time is when the frame was handed over
+	Time time.Time
+	// Pixels are the frame's RGBA rows, Stride bytes apart
+	Pixels []byte
+	Stride int
+	Size   image.Point
+}
+
+// FrameHook gets composited frames, see AddFrameHook
+type FrameHook func(frame Frame)
+
+// FrameHookOptions say which frames a FrameHook gets and how
+type FrameHookOptions struct {
+	// Rate is the most frames a second the hook gets, 0 for every frame
+	// that changed
+	Rate float64
+	// Borrow calls the hook on the render loop with the desktop buffer
+	// itself, which is only valid until the hook returns, so it must copy
+	// what it keeps and return quickly. Otherwise the hook is called on a
+	// goroutine of its own with a copy it may keep, and frames that come
+	// while it is busy are skipped.
+	Borrow bool
+}
+
+// frameHookSink samples the frames for a FrameHook
+type frameHookSink struct {
+	hook     FrameHook
+	interval time.Duration
+	// copies hands copies to the hook, unless it borrows the frames
+	copies *stream.FrameSink
+	// damage and next are the frame last handed over and when the next
+	// may be, touched only by Stream on the render loop
+	damage uint64
+	next   time.Time
+}
+
+func newFrameHookSink(hook FrameHook, options FrameHookOptions) *frameHookSink {
+	s := &frameHookSink{hook: hook}
+	if options.Rate > 0 {
+		s.interval = time.Duration(float64(time.Second) / options.Rate)
+	}
+	if !options.Borrow {
+		s.copies = stream.NewFrameSink("frame hook", frameHookWriter{hook}, 0)
+	}
+	return s
+}
+
+// Stream hands the hook source's frame if it changed and the hook is due
+// another
+func (s *frameHookSink) Stream(source TextureSource) {
+	now := time.Now()
+	damage := source.Damage()
+	if damage == s.damage || now.Before(s.next) {
+		return
+	}
+	pixels, stride := source.Frame()
+	if len(pixels) == 0 {
+		return
+	}
+	s.damage, s.next = damage, now.Add(s.interval)
+	if s.copies != nil {
+		s.copies.Stream(source)
+		return
+	}
+	s.hook(Frame{Sequence: damage, Time: now, Pixels: pixels, Stride: stride, Size: source.Size()})
+}
+
+func (s *frameHookSink) Close() error {
+	if s.copies == nil {
+		return nil
+	}
+	return s.copies.Close()
+}
+
+// frameHookWriter calls a hook with the copies of a frameSink
+type frameHookWriter struct {
+	hook FrameHook
+}
+
+func (w frameHookWriter) WriteFrame(frame stream.SinkFrame) error {
+	w.hook(Frame{
+		Sequence: frame.Sequence,
+		Time:     frame.Time,
+		Pixels:   frame.Pixels,
+		Stride:   frame.Size.X * 4,
+		Size:     frame.Size,
+	})
+	return nil
+}
+
+func (w frameHookWriter) Close() error {
+	return nil
+}
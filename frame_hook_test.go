@@ -0,0 +1,66 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestFrameHookBorrow(t *testing.T) {
+	var frames []Frame
+	sink := newFrameHookSink(func(frame Frame) {
+		frames = append(frames, frame)
+	}, FrameHookOptions{Rate: 1, Borrow: true})
+	defer sink.Close()
+
+	source := render.NewDesktopTextureSource()
+	desktop := &wayland.Desktop{Width: 1, Height: 1, Stride: 8, Buffer: make([]byte, 8)}
+	source.Publish(desktop)
+	sink.Stream(source)
+	sink.Stream(source)
+	// The next frame comes sooner than the rate allows
+	source.Publish(desktop)
+	sink.Stream(source)
+	if len(frames) != 1 {
+		t.Fatalf("Expected one frame, got %d", len(frames))
+	}
+	frame := frames[0]
+	if frame.Sequence != 1 || frame.Stride != 8 || frame.Size != image.Pt(1, 1) || &frame.Pixels[0] != &desktop.Buffer[0] {
+		t.Errorf("Expected the desktop buffer itself as frame 1, got %+v", frame)
+	}
+}
+
+func TestFrameHookCopy(t *testing.T) {
+	frames := make(chan Frame, 4)
+	sink := newFrameHookSink(func(frame Frame) {
+		frames <- frame
+	}, FrameHookOptions{})
+
+	source := render.NewDesktopTextureSource()
+	desktop := &wayland.Desktop{Width: 1, Height: 1, Stride: 8, Buffer: []byte{1, 2, 3, 4, 0, 0, 0, 0}}
+	source.Publish(desktop)
+	sink.Stream(source)
+	select {
+	case frame := <-frames:
+		if frame.Sequence != 1 || frame.Stride != 4 || string(frame.Pixels) != "\x01\x02\x03\x04" || frame.Time.IsZero() {
+			t.Errorf("Expected a packed copy of frame 1, got %+v", frame)
+		}
+		if &frame.Pixels[0] == &desktop.Buffer[0] {
+			t.Error("Expected a copy, got the desktop buffer")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The frame never came")
+	}
+
+	source.Publish(desktop)
+	sink.Stream(source)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if frame := <-frames; frame.Sequence != 2 {
+		t.Errorf("Expected frame 2, got %d", frame.Sequence)
+	}
+}
@@ -0,0 +1,180 @@
+package compositor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// FramePacer holds wl_surface.frame callbacks until the compositor has
+// actually drawn a frame, so clients render at the compositor's refresh
+// rate instead of as fast as they can. Apps with a max-fps rule are held
+// back further by delaying their callbacks across frames, and clients whose
+// surfaces are all hidden get no callbacks until they become visible.
+type FramePacer struct {
+	mu      sync.Mutex
+	pending map[*wayland.Client][]protocols.ObjectID[protocols.WlCallback]
+
+	// Per-app frame rate caps, keyed by xdg_toplevel app_id
+	maxFPS   map[string]int
+	lastDone map[*wayland.Client]time.Time
+	appIDs   map[*wayland.Client]string
+	appID    func(*wayland.Client) (string, bool)
+
+	// Clients with nothing visible on the desktop
+	hidden map[*wayland.Client]bool
+}
+
+// NewFramePacer creates an empty frame pacer
+func NewFramePacer() *FramePacer {
+	return &FramePacer{
+		pending:  make(map[*wayland.Client][]protocols.ObjectID[protocols.WlCallback]),
+		maxFPS:   make(map[string]int),
+		lastDone: make(map[*wayland.Client]time.Time),
+		appIDs:   make(map[*wayland.Client]string),
+		appID:    clientAppID,
+	}
+}
+
+// SetMaxFPS caps how often clients with the given app_id get frame
+// callbacks. A value of zero or less removes the cap.
+func (p *FramePacer) SetMaxFPS(appID string, fps int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if fps <= 0 {
+		delete(p.maxFPS, appID)
+		return
+	}
+	p.maxFPS[appID] = fps
+}
+
+// SetHiddenClients replaces the set of clients whose frame callbacks are
+// held because none of their surfaces are visible
+func (p *FramePacer) SetHiddenClients(hidden map[*wayland.Client]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hidden = hidden
+}
+
+// Queue records a frame callback to be completed on the next Flush
+func (p *FramePacer) Queue(client *wayland.Client, callbackID protocols.ObjectID[protocols.WlCallback]) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending[client] = append(p.pending[client], callbackID)
+}
+
+// Flush sends wl_callback.done for every queued callback whose client is
+// not being throttled. Call this right after the desktop has been composited.
+// The callbacks go out through guard; those of clients it has no room for
+// wait for the next Flush.
+func (p *FramePacer) Flush(now time.Time, guard *SendGuard) {
+	p.mu.Lock()
+	ready := make(map[*wayland.Client][]protocols.ObjectID[protocols.WlCallback], len(p.pending))
+	for client, callbacks := range p.pending {
+		if p.throttled(client, now) {
+			continue
+		}
+		ready[client] = callbacks
+		delete(p.pending, client)
+	}
+	p.mu.Unlock()
+
+	timestamp := uint32(now.UnixMilli())
+	held := make(map[*wayland.Client][]protocols.ObjectID[protocols.WlCallback])
+	for client, callbacks := range ready {
+		sent := guard.Send(client, len(callbacks), func(c *wayland.Client) {
+			for _, callbackID := range callbacks {
+				protocols.WlCallback_done(c, callbackID, timestamp)
+			}
+		})
+		if !sent {
+			held[client] = callbacks
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for client := range ready {
+		if callbacks, ok := held[client]; ok {
+			// Callbacks queued while these were being sent come after them
+			p.pending[client] = append(callbacks, p.pending[client]...)
+		} else {
+			p.lastDone[client] = now
+		}
+	}
+}
+
+// Forget drops a disconnected client's callbacks, which are never sent
+func (p *FramePacer) Forget(client *wayland.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, client)
+	delete(p.lastDone, client)
+	delete(p.appIDs, client)
+}
+
+// throttled reports whether a client is hidden or its max-fps rule says it
+// must wait longer before its next frame. Must be called with p.mu held.
+func (p *FramePacer) throttled(client *wayland.Client, now time.Time) bool {
+	if p.hidden[client] {
+		return true
+	}
+	if len(p.maxFPS) == 0 {
+		return false
+	}
+	if appID, ok := p.appID(client); ok {
+		p.appIDs[client] = appID
+	}
+	fps, ok := p.maxFPS[p.appIDs[client]]
+	if !ok {
+		return false
+	}
+	last, ok := p.lastDone[client]
+	return ok && now.Sub(last) < frameInterval(fps)
+}
+
+// PendingCount returns the number of callbacks waiting for the next frame
+func (p *FramePacer) PendingCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := 0
+	for _, callbacks := range p.pending {
+		count += len(callbacks)
+	}
+	return count
+}
+
+// frameInterval converts a target frame rate into a ticker interval
+func frameInterval(fps int) time.Duration {
+	if fps <= 0 {
+		fps = 60
+	}
+	return time.Second / time.Duration(fps)
+}
+
+// parseMaxFPSRules parses a comma separated list of app_id=fps pairs,
+// e.g. "mpv=30,org.gnome.Clocks=1".
+func parseMaxFPSRules(spec string) (map[string]int, error) {
+	rules := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		appID, value, ok := strings.Cut(entry, "=")
+		if !ok || appID == "" {
+			return nil, fmt.Errorf("invalid max-fps rule %q, expected app_id=fps", entry)
+		}
+		fps, err := strconv.Atoi(value)
+		if err != nil || fps <= 0 {
+			return nil, fmt.Errorf("invalid frame rate in max-fps rule %q", entry)
+		}
+		rules[appID] = fps
+	}
+	return rules, nil
+}
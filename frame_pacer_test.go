@@ -0,0 +1,156 @@
+package compositor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+func TestFramePacerFlush(t *testing.T) {
+	connected := &wayland.Client{
+		Status:          wayland.ClientStatus_Connected,
+		OutgoingChannel: make(chan protocols.OutgoingEvent, inputRoom+4),
+	}
+	disconnected := &wayland.Client{
+		Status:          wayland.ClientStatus_Disconnected,
+		OutgoingChannel: make(chan protocols.OutgoingEvent, inputRoom+4),
+	}
+
+	pacer := NewFramePacer()
+	guard := NewSendGuard(0, time.Second)
+	pacer.Queue(connected, 10)
+	pacer.Queue(connected, 11)
+	pacer.Queue(disconnected, 12)
+	pacer.Forget(disconnected)
+
+	if pacer.PendingCount() != 2 {
+		t.Fatalf("Expected 2 pending callbacks, got %d", pacer.PendingCount())
+	}
+	if len(connected.OutgoingChannel) != 0 {
+		t.Fatal("Callbacks were sent before Flush")
+	}
+
+	pacer.Flush(time.Now(), guard)
+
+	if len(connected.OutgoingChannel) != 2 {
+		t.Errorf("Expected 2 done events, got %d", len(connected.OutgoingChannel))
+	}
+	if len(disconnected.OutgoingChannel) != 0 {
+		t.Errorf("Disconnected client received %d events", len(disconnected.OutgoingChannel))
+	}
+	if pacer.PendingCount() != 0 {
+		t.Errorf("Expected no pending callbacks after Flush, got %d", pacer.PendingCount())
+	}
+}
+
+func TestFrameInterval(t *testing.T) {
+	if got := frameInterval(50); got != 20*time.Millisecond {
+		t.Errorf("Expected 20ms, got %v", got)
+	}
+	if got := frameInterval(0); got != frameInterval(60) {
+		t.Errorf("Expected fallback to 60 FPS, got %v", got)
+	}
+}
+
+func TestFramePacerMaxFPS(t *testing.T) {
+	client := &wayland.Client{
+		Status:          wayland.ClientStatus_Connected,
+		OutgoingChannel: make(chan protocols.OutgoingEvent, inputRoom+8),
+	}
+
+	pacer := NewFramePacer()
+	guard := NewSendGuard(0, time.Second)
+	pacer.appID = func(*wayland.Client) (string, bool) { return "mpv", true }
+	pacer.SetMaxFPS("mpv", 10)
+
+	start := time.Now()
+	pacer.Queue(client, 1)
+	pacer.Flush(start, guard)
+	if len(client.OutgoingChannel) != 1 {
+		t.Fatalf("First frame should not be throttled, got %d events", len(client.OutgoingChannel))
+	}
+
+	pacer.Queue(client, 2)
+	pacer.Flush(start.Add(16*time.Millisecond), guard)
+	if len(client.OutgoingChannel) != 1 {
+		t.Errorf("Frame inside the 100ms window should be held back")
+	}
+
+	pacer.Flush(start.Add(100*time.Millisecond), guard)
+	if len(client.OutgoingChannel) != 2 {
+		t.Errorf("Held frame should be released after 100ms, got %d events", len(client.OutgoingChannel))
+	}
+}
+
+func TestParseMaxFPSRules(t *testing.T) {
+	rules, err := parseMaxFPSRules("mpv=30, foot=15")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rules["mpv"] != 30 || rules["foot"] != 15 {
+		t.Errorf("Unexpected rules: %v", rules)
+	}
+
+	for _, bad := range []string{"mpv", "=30", "mpv=fast", "mpv=0"} {
+		if _, err := parseMaxFPSRules(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestFramePacerHiddenClients(t *testing.T) {
+	client := &wayland.Client{
+		Status:          wayland.ClientStatus_Connected,
+		OutgoingChannel: make(chan protocols.OutgoingEvent, inputRoom+4),
+	}
+
+	pacer := NewFramePacer()
+	guard := NewSendGuard(0, time.Second)
+	pacer.SetHiddenClients(map[*wayland.Client]bool{client: true})
+	pacer.Queue(client, 1)
+	pacer.Flush(time.Now(), guard)
+	if len(client.OutgoingChannel) != 0 {
+		t.Fatal("Hidden client should not get frame callbacks")
+	}
+
+	pacer.SetHiddenClients(nil)
+	pacer.Flush(time.Now(), guard)
+	if len(client.OutgoingChannel) != 1 {
+		t.Errorf("Callback should be released once the client is visible, got %d events", len(client.OutgoingChannel))
+	}
+}
+
+func TestFramePacerHeldForRoom(t *testing.T) {
+	client := makeTestClient(0, inputRoom+2)
+	pacer := NewFramePacer()
+	guard := NewSendGuard(0, time.Second)
+	pacer.Queue(client, 1)
+	pacer.Queue(client, 2)
+	pacer.Queue(client, 3)
+
+	// Three callbacks do not fit with room for input left
+	pacer.Flush(time.Now(), guard)
+	if len(client.OutgoingChannel) != 0 {
+		t.Fatalf("Expected callbacks held for a client without room, got %d events", len(client.OutgoingChannel))
+	}
+	if pacer.PendingCount() != 3 {
+		t.Fatalf("Expected the held callbacks still pending, got %d", pacer.PendingCount())
+	}
+
+	// Nor are they sent while the client's lock is held
+	client.OutgoingChannel = make(chan protocols.OutgoingEvent, inputRoom+4)
+	client.Access.Lock()
+	pacer.Flush(time.Now(), guard)
+	client.Access.Unlock()
+	if len(client.OutgoingChannel) != 0 {
+		t.Fatalf("Expected callbacks held while the client is busy, got %d events", len(client.OutgoingChannel))
+	}
+
+	pacer.Queue(client, 4)
+	pacer.Flush(time.Now(), guard)
+	if len(client.OutgoingChannel) != 4 || pacer.PendingCount() != 0 {
+		t.Errorf("Expected all 4 callbacks sent once there is room, got %d events and %d pending", len(client.OutgoingChannel), pacer.PendingCount())
+	}
+}
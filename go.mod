@@ -1,4 +1,4 @@
-module wayland-compositor
+module github.com/mmulet/pupapppupps
 
 go 1.25.2
 
@@ -8,5 +8,6 @@ require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/mmulet/term.everything v0.0.0-20260201015430-554ea94c4e98
 	github.com/qmuntal/gltf v0.28.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/veandco/go-sdl2 v0.4.40
 )
@@ -0,0 +1,41 @@
+package compositor
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// defaultHTTPAddr is where the HTTP server listens unless -http says
+// otherwise: this machine only, since the control API can launch commands
+const defaultHTTPAddr = "127.0.0.1:8080"
+
+// listenHTTP listens on addr, a TCP address or unix:<path> for a Unix
+// socket only the user can connect to. A socket file left behind by a
+// compositor that is gone is replaced; one something still answers on is
+// in use.
+func listenHTTP(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	listener, err := net.Listen("unix", path)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		if conn, dialErr := net.Dial("unix", path); dialErr == nil {
+			conn.Close()
+			return nil, err
+		}
+		os.Remove(path)
+		listener, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
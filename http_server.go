@@ -0,0 +1,347 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+// HTTPServer wraps the HTTP server with static file serving and WebSocket
+type HTTPServer struct {
+	staticDir   string
+	wsServer    *stream.WebSocketServer
+	screenshots *stream.Screenshots
+	mjpeg       *stream.MJPEGStreams
+	mux         *http.ServeMux
+	server      *http.Server
+	// listener, when set, is served in place of listening on the address
+	listener net.Listener
+	// apiToken is the bearer token /api/ requests must carry
+	apiToken string
+}
+
+// NewHTTPServer creates a new HTTP server. Without a static directory the
+// embedded viewer is served at /.
+func NewHTTPServer(addr string, staticDir string) *HTTPServer {
+	wsServer := stream.NewWebSocketServer()
+	screenshots := stream.NewScreenshots()
+	mjpeg := stream.NewMJPEGStreams(75, 10)
+
+	mux := http.NewServeMux()
+
+	// Serve static files from the static directory
+	if staticDir != "" {
+		mux.Handle("/", http.FileServer(http.Dir(staticDir)))
+	} else {
+		mux.Handle("/", viewerHandler())
+	}
+
+	// WebSocket endpoint for desktop buffer streaming
+	mux.HandleFunc("/ws", wsServer.HandleWebSocket)
+
+	// PNG of the next composited desktop frame
+	mux.Handle("/screenshot.png", screenshots)
+	mux.Handle("/frame.png", screenshots)
+
+	// MJPEG stream for viewers that cannot use WebSockets
+	mux.Handle("/stream.mjpeg", mjpeg)
+
+	// Health check endpoint
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	server := &http.Server{
+		Addr:         addr,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	h := &HTTPServer{
+		staticDir:   staticDir,
+		wsServer:    wsServer,
+		screenshots: screenshots,
+		mjpeg:       mjpeg,
+		mux:         mux,
+		server:      server,
+	}
+	server.Handler = http.HandlerFunc(h.serveHTTP)
+	return h
+}
+
+// SetAPIToken sets the bearer token requests to /api/ must carry in their
+// Authorization header. Until it is set the API answers no one.
+func (h *HTTPServer) SetAPIToken(token string) {
+	h.apiToken = token
+}
+
+// serveHTTP serves a request, turning away API requests without the
+// token
+func (h *HTTPServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") && !bearerTokenMatches(r, h.apiToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		http.Error(w, "a bearer token is required", http.StatusUnauthorized)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// SetListener makes Start serve listener, such as a socket systemd passed,
+// rather than listen on the server's address
+func (h *HTTPServer) SetListener(listener net.Listener) {
+	h.listener = listener
+}
+
+// Addr is the address the server listens on, its listener's when it has
+// one
+func (h *HTTPServer) Addr() string {
+	if h.listener != nil {
+		return h.listener.Addr().String()
+	}
+	return h.server.Addr
+}
+
+// Start starts the HTTP server in a goroutine
+func (h *HTTPServer) Start() error {
+	if h.listener == nil {
+		listener, err := listenHTTP(h.server.Addr)
+		if err != nil {
+			return err
+		}
+		h.listener = listener
+	}
+	addr := h.Addr()
+	log.Printf("Starting HTTP server on %s", addr)
+	if h.staticDir != "" {
+		log.Printf("Static files served from: %s", h.staticDir)
+	} else {
+		log.Printf("Serving the built-in viewer at http://%s/", addr)
+	}
+	log.Printf("WebSocket endpoint: ws://%s/ws", addr)
+
+	go func() {
+		err := h.server.Serve(h.listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop gracefully stops the HTTP server
+func (h *HTTPServer) Stop() error {
+	return h.server.Close()
+}
+
+// HandleFunc registers an extra HTTP endpoint
+func (h *HTTPServer) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	h.mux.HandleFunc(pattern, handler)
+}
+
+// Stream broadcasts source's frame to WebSocket clients if it changed or
+// one is waiting for it
+func (h *HTTPServer) Stream(source TextureSource) {
+	h.wsServer.Stream(source)
+}
+
+// Broadcaster is the main stream, which the viewers at /ws watch
+func (h *HTTPServer) Broadcaster() Broadcaster {
+	return h.wsServer
+}
+
+// BroadcastDesktopBuffer forwards the desktop buffer to all WebSocket clients
+func (h *HTTPServer) BroadcastDesktopBuffer(buffer []byte, width, height, stride int) {
+	h.wsServer.BroadcastDesktopBuffer(buffer, width, height, stride)
+}
+
+// ServeScreenshots answers pending /screenshot.png requests with the
+// desktop buffer
+func (h *HTTPServer) ServeScreenshots(buffer []byte, width, height, stride int) {
+	h.screenshots.Fulfil(buffer, width, height, stride)
+}
+
+// ScreenshotPending reports whether a screenshot request is waiting
+func (h *HTTPServer) ScreenshotPending() bool {
+	return h.screenshots.Pending()
+}
+
+// ConfigureMJPEG sets the default JPEG quality and frame rate of
+// /stream.mjpeg, reporting either out of bounds
+func (h *HTTPServer) ConfigureMJPEG(quality, fps int) error {
+	return h.mjpeg.SetDefaults(quality, fps)
+}
+
+// MJPEGDue reports whether an MJPEG viewer is waiting for a frame
+func (h *HTTPServer) MJPEGDue(now time.Time) bool {
+	return h.mjpeg.Due(now)
+}
+
+// PublishMJPEG hands the desktop buffer to MJPEG viewers that are due
+func (h *HTTPServer) PublishMJPEG(buffer []byte, width, height, stride int, now time.Time) {
+	h.mjpeg.Publish(buffer, width, height, stride, now)
+}
+
+// ServeWebSocket serves the main stream, for routes other than /ws
+func (h *HTTPServer) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	h.wsServer.HandleWebSocket(w, r)
+}
+
+// FrameWanted reports whether a viewer of the main stream is waiting for
+// the desktop to be broadcast
+func (h *HTTPServer) FrameWanted() bool {
+	return h.wsServer.FrameWanted()
+}
+
+// WebSocketClientCount returns the number of connected WebSocket clients
+func (h *HTTPServer) WebSocketClientCount() int {
+	return h.wsServer.ClientCount()
+}
+
+// Viewers lists the viewers of the main stream
+func (h *HTTPServer) Viewers() []stream.ViewerInfo {
+	return h.wsServer.Viewers()
+}
+
+// ViewerPreferences returns the preferences of a viewer of the main stream
+func (h *HTTPServer) ViewerPreferences(id int) (stream.ViewerPreferences, bool) {
+	return h.wsServer.Preferences(id)
+}
+
+// SetViewerPreferences replaces the preferences of a viewer of the main
+// stream
+func (h *HTTPServer) SetViewerPreferences(id int, preferences stream.ViewerPreferences) bool {
+	return h.wsServer.SetPreferences(id, preferences)
+}
+
+// SetExclusiveControl switches the main stream's viewers between shared
+// and exclusive input control
+func (h *HTTPServer) SetExclusiveControl(exclusive bool) {
+	h.wsServer.SetExclusiveControl(exclusive)
+}
+
+// Control returns who holds input control of the main stream
+func (h *HTTPServer) Control() stream.ControlInfo {
+	return h.wsServer.Control()
+}
+
+// GrantControl gives input control of the main stream to a viewer
+func (h *HTTPServer) GrantControl(id int) bool {
+	return h.wsServer.GrantControl(id)
+}
+
+// RevokeControl takes input control of the main stream from its holder
+func (h *HTTPServer) RevokeControl() int {
+	return h.wsServer.RevokeControl()
+}
+
+// StreamStats returns what streaming costs for each viewer of the main
+// stream
+func (h *HTTPServer) StreamStats() []stream.ViewerStreamStats {
+	return h.wsServer.StreamStats()
+}
+
+// KickViewer disconnects a viewer of the main stream
+func (h *HTTPServer) KickViewer(id int) bool {
+	return h.wsServer.Kick(id)
+}
+
+// SetPointerLock tells viewers of the main stream to capture the mouse, or
+// to stop
+func (h *HTTPServer) SetPointerLock(locked bool) {
+	h.wsServer.SetPointerLock(locked)
+}
+
+// Resync sends every viewer of the main stream a keyframe next, see
+// WebSocketServer.Resync
+func (h *HTTPServer) Resync() {
+	h.wsServer.Resync()
+}
+
+// SetStreamStatus announces the main stream's status to its viewers
+func (h *HTTPServer) SetStreamStatus(status string) {
+	h.wsServer.SetStreamStatus(status)
+}
+
+// Restarting tells viewers of the main stream the compositor is
+// restarting and disconnects them
+func (h *HTTPServer) Restarting(retry time.Duration) {
+	h.wsServer.Restarting(retry)
+}
+
+// SetViewerTokens sets the store viewers' reconnect tokens are kept in
+func (h *HTTPServer) SetViewerTokens(tokens *stream.ViewerTokens) {
+	h.wsServer.SetViewerTokens(tokens)
+}
+
+// SetQualityFloor keeps every viewer at or below a quality tier
+func (h *HTTPServer) SetQualityFloor(floor int) {
+	h.wsServer.SetQualityFloor(floor)
+}
+
+// SetPlayoutDelay sets how long after capture synced viewers of the main
+// stream show a frame
+func (h *HTTPServer) SetPlayoutDelay(delay time.Duration) {
+	h.wsServer.SetPlayoutDelay(delay)
+}
+
+// SetKeyboardHandler sets the callback for keyboard events received from WebSocket clients
+func (h *HTTPServer) SetKeyboardHandler(handler stream.KeyboardEventHandler) {
+	h.wsServer.SetKeyboardHandler(handler)
+}
+
+// SetTextHandler sets the callback for text committed by WebSocket clients
+func (h *HTTPServer) SetTextHandler(handler stream.TextInputHandler) {
+	h.wsServer.SetTextHandler(handler)
+}
+
+// SetPointerHandler sets the callback for pointer events received from WebSocket clients
+func (h *HTTPServer) SetPointerHandler(handler stream.PointerEventHandler) {
+	h.wsServer.SetPointerHandler(handler)
+}
+
+// SetInputObserver sets the callback that sees every input message from
+// WebSocket clients
+func (h *HTTPServer) SetInputObserver(observer stream.InputObserver) {
+	h.wsServer.SetInputObserver(observer)
+}
+
+// Inject handles an input message as if a WebSocket client had sent it
+func (h *HTTPServer) Inject(message []byte) {
+	h.wsServer.HandleInput(message)
+}
+
+// SetWindowActionHandler sets the callback for viewers focusing and
+// closing windows
+func (h *HTTPServer) SetWindowActionHandler(handler stream.WindowActionHandler) {
+	h.wsServer.SetWindowActionHandler(handler)
+}
+
+// WindowsWanted reports whether any viewer wants the window list, and
+// whether any wants thumbnails
+func (h *HTTPServer) WindowsWanted() (list, thumbnails bool) {
+	return h.wsServer.WindowsWanted()
+}
+
+// PublishWindows sends the window list to the viewers that want it
+func (h *HTTPServer) PublishWindows(windows []stream.WindowInfo) {
+	h.wsServer.PublishWindows(windows)
+}
+
+// SetViewerJoinedHandler sets the callback for new WebSocket clients
+func (h *HTTPServer) SetViewerJoinedHandler(handler stream.ViewerJoinedHandler) {
+	h.wsServer.SetViewerJoinedHandler(handler)
+}
+
+// SetControlChangedHandler sets the callback for input control passing
+// between viewers
+func (h *HTTPServer) SetControlChangedHandler(handler stream.ControlChangedHandler) {
+	h.wsServer.SetControlChangedHandler(handler)
+}
@@ -0,0 +1,24 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuiltInViewer(t *testing.T) {
+	server := NewHTTPServer("", "")
+	for path, want := range map[string]string{
+		"/":          "<canvas",
+		"/viewer.js": "INPUT_POINTER_MOTION",
+	} {
+		rec := httptest.NewRecorder()
+		server.mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: expected 200 containing %q, got %d", path, want, rec.Code)
+		}
+	}
+}
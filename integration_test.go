@@ -0,0 +1,355 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// testSession runs a session of the compositor in the test, with a viewer
+// on its stream. The test drives the render loop with composite, so what
+// clients and the viewer see follows from what it did, not from timing.
+type testSession struct {
+	t       *testing.T
+	session *Session
+	viewer  *websocket.Conn
+	// negotiated is set once the viewer has sent a hello, and previous is
+	// the last frame it got, for delta frames
+	negotiated bool
+	previous   []byte
+}
+
+// newTestSession starts a session with a width by height desktop and
+// connects a viewer to it
+func newTestSession(t *testing.T, width, height int) *testSession {
+	t.Helper()
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	manager := NewSessionManager(http.NotFoundHandler(), 64, time.Second, nil)
+	t.Cleanup(manager.Close)
+	s, err := manager.Create("test", width, height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws/{session}", manager.ServeWebSocket)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	viewer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { viewer.Close() })
+	for deadline := time.Now().Add(5 * time.Second); s.stream.ClientCount() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("The viewer did not connect")
+		}
+	}
+	return &testSession{t: t, session: s, viewer: viewer}
+}
+
+// dial connects a synthetic client to the session
+func (s *testSession) dial() *testClient {
+	s.t.Helper()
+	// The wayland package keeps some state shared by every client, such as
+	// the xdg_wm_base version bound last, which it reads and writes on each
+	// client's goroutine without a lock. Taking the clients' locks, then the
+	// list's that accepting the new client takes, orders the new client's
+	// requests after the earlier clients' for the race detector, as they are
+	// on the wire.
+	for _, c := range s.session.clients.Snapshot() {
+		c.Access.Lock()
+		c.Access.Unlock()
+	}
+	s.session.clients.mu.Lock()
+	before := len(*s.session.clients.list.Load())
+	s.session.clients.mu.Unlock()
+	c := dialTestClient(s.t, s.session.Display)
+	for deadline := time.Now().Add(5 * time.Second); s.session.clients.Len() == before; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			s.t.Fatal("The session did not accept the client")
+		}
+	}
+	return c
+}
+
+// composite runs one turn of the render loop
+func (s *testSession) composite() {
+	s.session.composite(time.Now())
+}
+
+// hello negotiates compressed delta frames for the viewer, as the built-in
+// viewer does, and reads the reply
+func (s *testSession) hello() {
+	s.t.Helper()
+	if err := s.viewer.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello","compression":["deflate"],"delta":true}`)); err != nil {
+		s.t.Fatal(err)
+	}
+	s.negotiated = true
+}
+
+// frame reads the next frame the viewer gets, skipping text messages,
+// decoded as a viewer would
+func (s *testSession) frame() *image.RGBA {
+	s.t.Helper()
+	s.viewer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		kind, message, err := s.viewer.ReadMessage()
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		if kind != websocket.BinaryMessage {
+			continue
+		}
+		if !s.negotiated {
+			if len(message) < 12 {
+				s.t.Fatalf("Frame too short: %d bytes", len(message))
+			}
+			width := int(binary.LittleEndian.Uint32(message[0:4]))
+			height := int(binary.LittleEndian.Uint32(message[4:8]))
+			stride := int(binary.LittleEndian.Uint32(message[8:12]))
+			return &image.RGBA{Pix: message[12:], Stride: stride, Rect: image.Rect(0, 0, width, height)}
+		}
+		width, height, stride, pixels, err := stream.DecodeFrame(message, s.previous)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		s.previous = pixels
+		return &image.RGBA{Pix: pixels, Stride: stride, Rect: image.Rect(0, 0, width, height)}
+	}
+}
+
+// text reads the viewer's messages until a text one of kind
+func (s *testSession) text(kind string) map[string]any {
+	s.t.Helper()
+	s.viewer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		messageType, message, err := s.viewer.ReadMessage()
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		var fields map[string]any
+		if messageType == websocket.TextMessage && json.Unmarshal(message, &fields) == nil && fields["type"] == kind {
+			return fields
+		}
+	}
+}
+
+func TestIntegrationCompositeOutput(t *testing.T) {
+	s := newTestSession(t, 320, 240)
+	c := s.dial()
+	c.openWindow("test.pattern")
+	pattern := testPattern(64, 48)
+	c.draw(64, 48, pattern)
+
+	s.composite()
+	frame := s.frame()
+	if frame.Rect.Dx() != 320 || frame.Rect.Dy() != 240 {
+		t.Fatalf("Expected a 320x240 frame, got %v", frame.Rect)
+	}
+	checkPattern(t, frame, image.Point{}, 64, 48, pattern)
+	if got := frame.RGBAAt(100, 100); got != (color.RGBA{}) {
+		t.Errorf("Expected the desktop clear outside the window, got %v", got)
+	}
+
+	// A new buffer replaces the last one on the next frame
+	red := func(x, y int) color.RGBA { return color.RGBA{R: 0xff, A: 0xff} }
+	c.draw(64, 48, red)
+	s.composite()
+	checkPattern(t, s.frame(), image.Point{}, 64, 48, red)
+}
+
+func TestIntegrationFramePacing(t *testing.T) {
+	s := newTestSession(t, 320, 240)
+	c := s.dial()
+	c.openWindow("test.pacing")
+	c.draw(16, 16, testPattern(16, 16))
+
+	callback := c.requestFrame()
+	c.draw(16, 16, testPattern(16, 16))
+	// The callback is held until the render loop has drawn the commit
+	if c.waitFrame(callback, 50*time.Millisecond) {
+		t.Fatal("Expected the frame callback held until a frame was composited")
+	}
+	s.composite()
+	if !c.waitFrame(callback, 5*time.Second) {
+		t.Fatal("Expected the frame callback done after a frame was composited")
+	}
+
+	// One a frame: a second callback waits for the next turn
+	next := c.requestFrame()
+	c.draw(16, 16, testPattern(16, 16))
+	if c.waitFrame(next, 50*time.Millisecond) {
+		t.Fatal("Expected the next frame callback held until the next frame")
+	}
+	s.composite()
+	if !c.waitFrame(next, 5*time.Second) {
+		t.Fatal("Expected the next frame callback done")
+	}
+}
+
+func TestIntegrationKeyboardRouting(t *testing.T) {
+	s := newTestSession(t, 320, 240)
+	typing := s.dial()
+	typing.openWindow("test.typing")
+	typing.getKeyboard()
+	other := s.dial()
+	other.openWindow("test.other")
+
+	// KEY_A pressed and released in the viewer
+	for _, pressed := range []byte{1, 0} {
+		if err := s.viewer.WriteMessage(websocket.BinaryMessage, []byte{stream.InputKeyboard, 30, 0, 0, 0, pressed}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !typing.waitFor(5*time.Second, func() bool { return len(typing.keys) == 2 }) {
+		t.Fatalf("Expected the key pressed and released, got %v", typing.keys)
+	}
+	if typing.keys[0] != (testKey{Keycode: 30, Pressed: true}) || typing.keys[1] != (testKey{Keycode: 30}) {
+		t.Errorf("Expected KEY_A pressed then released, got %v", typing.keys)
+	}
+	// A client without a keyboard gets no keys
+	other.sync(nil)
+	if len(other.keys) != 0 {
+		t.Errorf("Expected no keys for a client without a keyboard, got %v", other.keys)
+	}
+}
+
+func TestIntegrationStreamFormat(t *testing.T) {
+	s := newTestSession(t, 64, 32)
+	c := s.dial()
+	c.openWindow("test.stream")
+	pattern := testPattern(32, 32)
+	c.draw(32, 32, pattern)
+
+	// Before a hello, frames are a bare header and the rows
+	s.composite()
+	checkPattern(t, s.frame(), image.Point{}, 32, 32, pattern)
+
+	s.hello()
+	reply := s.text("hello")
+	if reply["compression"] != "deflate" || reply["delta"] != true {
+		t.Fatalf("Expected deflate and delta frames, got %v", reply)
+	}
+	s.session.stream.Resync()
+	s.composite()
+	checkPattern(t, s.frame(), image.Point{}, 32, 32, pattern)
+
+	// The next frame is a delta against the last
+	blue := func(x, y int) color.RGBA { return color.RGBA{B: 0xff, A: 0xff} }
+	c.draw(32, 32, blue)
+	s.composite()
+	frame := s.frame()
+	checkPattern(t, frame, image.Point{}, 32, 32, blue)
+	if got := frame.RGBAAt(40, 10); got != (color.RGBA{}) {
+		t.Errorf("Expected the desktop clear outside the window, got %v", got)
+	}
+}
+
+func TestIntegrationRegion(t *testing.T) {
+	s := newTestSession(t, 320, 240)
+	c := s.dial()
+	c.openWindow("test.region")
+	pattern := testPattern(64, 48)
+	c.draw(64, 48, pattern)
+	s.hello()
+	s.text("hello")
+
+	region, frame := s.region(stream.ViewerRegion{X: 16, Y: 8, Width: 32, Height: 16})
+	if region["x"] != 16.0 || region["y"] != 8.0 || region["width"] != 32.0 || region["height"] != 16.0 || region["zoom"] != 1.0 {
+		t.Fatalf("Expected the region echoed, got %v", region)
+	}
+	if frame.Rect.Dx() != 32 || frame.Rect.Dy() != 16 {
+		t.Fatalf("Expected a 32x16 frame, got %v", frame.Rect)
+	}
+	checkPattern(t, frame, image.Point{}, 32, 16, func(x, y int) color.RGBA { return pattern(x+16, y+8) })
+
+	// Zoomed, each desktop pixel is sent twice in each direction
+	_, frame = s.region(stream.ViewerRegion{Width: 8, Height: 8, Zoom: 2})
+	if frame.Rect.Dx() != 16 || frame.Rect.Dy() != 16 {
+		t.Fatalf("Expected a 16x16 frame, got %v", frame.Rect)
+	}
+	checkPattern(t, frame, image.Point{}, 16, 16, func(x, y int) color.RGBA { return pattern(x/2, y/2) })
+}
+
+func TestIntegrationDecorations(t *testing.T) {
+	rules, err := parseWindowRules(`[{"app_id": "^test\\.forced$", "decoration_mode": "server"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := wayland.Global_ZxdgDecorationManagerV1.Delegate
+	t.Cleanup(func() { wayland.Global_ZxdgDecorationManagerV1.Delegate = previous })
+	decorations := NewDecorations(NewWindowRuleSet(rules))
+	const serverSide, clientSide = 2, 1
+
+	s := newTestSession(t, 320, 240)
+	c := s.dial()
+	c.openWindow("test.decorations")
+	if mode := c.decorate(); mode != serverSide {
+		t.Fatalf("Expected server side decorations by default, got mode %d", mode)
+	}
+	if mode := c.setDecorationMode(clientSide); mode != clientSide {
+		t.Fatalf("Expected the client's own decorations when it asks, got mode %d", mode)
+	}
+	client := s.session.clients.Snapshot()[0]
+	client.Access.Lock()
+	for id := range client.TopLevelSurfaces() {
+		if !decorations.ClientSide(client, id) {
+			t.Errorf("Expected toplevel %d to draw its own decorations", id)
+		}
+	}
+	client.Access.Unlock()
+
+	// A rule's mode wins over the app's
+	forced := s.dial()
+	forced.openWindow("test.forced")
+	forced.decorate()
+	if mode := forced.setDecorationMode(clientSide); mode != serverSide {
+		t.Errorf("Expected the rule to keep server side decorations, got mode %d", mode)
+	}
+}
+
+// region asks for a region of the desktop and composites, returning the
+// region notice and the frame after it
+func (s *testSession) region(region stream.ViewerRegion) (map[string]any, *image.RGBA) {
+	s.t.Helper()
+	request, _ := json.Marshal(struct {
+		Type string `json:"type"`
+		stream.ViewerRegion
+	}{"region", region})
+	if err := s.viewer.WriteMessage(websocket.TextMessage, request); err != nil {
+		s.t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !s.requested(region); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			s.t.Fatal("The session did not take the region")
+		}
+	}
+	s.composite()
+	notice := s.text("region")
+	// The first frame of a region is whole
+	s.previous = nil
+	return notice, s.frame()
+}
+
+// requested reports whether the viewer's region is region
+func (s *testSession) requested(region stream.ViewerRegion) bool {
+	for _, viewer := range s.session.stream.Viewers() {
+		if viewer.Region != nil && *viewer.Region == region {
+			return true
+		}
+	}
+	return false
+}
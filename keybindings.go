@@ -0,0 +1,279 @@
+package compositor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keyMods are the modifiers held for a key chord
+type keyMods uint8
+
+const (
+	modSuper keyMods = 1 << iota
+	modCtrl
+	modShift
+	modAlt
+)
+
+// keyModNames are the names modifiers are written with, in the order a
+// chord lists them, and their other names
+var keyModNames = []struct {
+	mod   keyMods
+	names []string
+}{
+	{modSuper, []string{"Super", "Meta", "Logo", "Mod4"}},
+	{modCtrl, []string{"Ctrl", "Control"}},
+	{modShift, []string{"Shift"}},
+	{modAlt, []string{"Alt", "Mod1"}},
+}
+
+// keyRightAlt is the Linux input keycode of the right Alt key
+const keyRightAlt = 100
+
+// keyNames are the keys a chord can name, by Linux input keycode: the
+// letters, digits and punctuation of the US keymap, F1 to F12 and the
+// named keys. Names are matched without regard to case.
+var keyNames = func() map[string]uint32 {
+	names := map[string]uint32{
+		"Space": keySpace, "Enter": keyEnter, "Return": keyEnter, "Tab": keyTab,
+		"Escape": keyEsc, "Esc": keyEsc, "Backspace": keyBackspace,
+		"Left": keyLeft, "Right": keyRight, "Up": keyUp, "Down": keyDown,
+		"Home": keyHome, "End": keyEnd, "PageUp": keyPageUp, "PageDown": keyPageDown,
+		"Insert": keyInsert, "Delete": keyDelete,
+		"F11": 87, "F12": 88,
+	}
+	for i := range 10 {
+		names["F"+strconv.Itoa(i+1)] = 59 + uint32(i)
+	}
+	for _, row := range usKeyRows[:4] {
+		for i, r := range row.plain {
+			names[strings.ToUpper(string(r))] = row.first + uint32(i)
+		}
+	}
+	return names
+}()
+
+// keyNamesByCode is the name each keycode is written with
+var keyNamesByCode = func() map[uint32]string {
+	byCode := make(map[uint32]string, len(keyNames))
+	for name, code := range keyNames {
+		// Prefer the shorter of two names, then the first alphabetically
+		if have, ok := byCode[code]; !ok || len(name) < len(have) || len(name) == len(have) && name < have {
+			byCode[code] = name
+		}
+	}
+	return byCode
+}()
+
+// KeyChord is a key pressed while holding modifiers, such as Super+Q
+type KeyChord struct {
+	mods keyMods
+	key  uint32
+}
+
+// parseKeyChord parses modifiers and a key joined by +, e.g.
+// Super+Shift+Left
+func parseKeyChord(value string) (KeyChord, error) {
+	parts := strings.Split(value, "+")
+	var chord KeyChord
+	for _, part := range parts[:len(parts)-1] {
+		mod, ok := parseKeyMod(strings.TrimSpace(part))
+		if !ok {
+			return KeyChord{}, fmt.Errorf("unknown modifier %q in %q, want Super, Ctrl, Shift or Alt", part, value)
+		}
+		chord.mods |= mod
+	}
+	name := strings.TrimSpace(parts[len(parts)-1])
+	for known, code := range keyNames {
+		if strings.EqualFold(name, known) {
+			chord.key = code
+			return chord, nil
+		}
+	}
+	return KeyChord{}, fmt.Errorf("unknown key %q in %q", name, value)
+}
+
+func parseKeyMod(name string) (keyMods, bool) {
+	for _, m := range keyModNames {
+		for _, known := range m.names {
+			if strings.EqualFold(name, known) {
+				return m.mod, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// String writes the chord as parseKeyChord reads it
+func (c KeyChord) String() string {
+	var parts []string
+	for _, m := range keyModNames {
+		if c.mods&m.mod != 0 {
+			parts = append(parts, m.names[0])
+		}
+	}
+	name, ok := keyNamesByCode[c.key]
+	if !ok {
+		name = strconv.Itoa(int(c.key))
+	}
+	return strings.Join(append(parts, name), "+")
+}
+
+// Set parses a chord, for flags that take one
+func (c *KeyChord) Set(value string) error {
+	chord, err := parseKeyChord(value)
+	if err != nil {
+		return err
+	}
+	*c = chord
+	return nil
+}
+
+// Shortcut actions a key binding can run
+const (
+	actionNextLayout    = "next-layout"
+	actionWorkspaceNext = "workspace-next"
+	actionWorkspacePrev = "workspace-prev"
+	actionMoveNext      = "move-next"
+	actionMovePrev      = "move-prev"
+	actionPointerLock   = "pointer-lock"
+	actionStatsOverlay  = "stats-overlay"
+	actionClose         = "close"
+	actionFullscreen    = "fullscreen"
+	actionRecord        = "record"
+	// actionKioskExit leaves -kiosk mode; it is bound to -kiosk-escape
+	// while kiosk mode is on, not through key bindings
+	actionKioskExit = "kiosk-exit"
+	// actionLayout and actionWorkspace are followed by a layout's name or a
+	// workspace's number, e.g. layout:grid or workspace:2
+	actionLayout    = "layout:"
+	actionWorkspace = "workspace:"
+)
+
+var shortcutActions = []string{
+	actionNextLayout, actionWorkspaceNext, actionWorkspacePrev, actionMoveNext, actionMovePrev,
+	actionPointerLock, actionStatsOverlay, actionClose, actionFullscreen, actionRecord,
+}
+
+// validShortcutAction checks an action's name
+func validShortcutAction(action string) error {
+	if mode, ok := strings.CutPrefix(action, actionLayout); ok {
+		_, err := parseLayoutMode(mode)
+		return err
+	}
+	if n, ok := strings.CutPrefix(action, actionWorkspace); ok {
+		if i, err := strconv.Atoi(n); err != nil || i < 1 || i > maxWorkspaces {
+			return fmt.Errorf("unknown workspace %q, want 1 to %d", n, maxWorkspaces)
+		}
+		return nil
+	}
+	for _, known := range shortcutActions {
+		if action == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown action %q, want one of %v, layout:<layout> or workspace:<n>", action, shortcutActions)
+}
+
+// KeyBindings are the compositor's shortcuts, the action each chord runs.
+// As the -keybindings flag it is a JSON object of chord to action laid
+// over the defaults, an empty action unbinding a default chord.
+type KeyBindings map[KeyChord]string
+
+// DefaultKeyBindings returns the shortcuts the compositor starts with
+func DefaultKeyBindings() KeyBindings {
+	b := KeyBindings{
+		{modSuper, keySpace}:            actionNextLayout,
+		{modSuper | modCtrl, keyLeft}:   actionWorkspacePrev,
+		{modSuper | modCtrl, keyRight}:  actionWorkspaceNext,
+		{modSuper | modShift, keyLeft}:  actionMovePrev,
+		{modSuper | modShift, keyRight}: actionMoveNext,
+		{modSuper, keyG}:                actionPointerLock,
+		{modSuper, keyS}:                actionStatsOverlay,
+		{modSuper, keyQ}:                actionClose,
+		{modSuper, keyF}:                actionFullscreen,
+		{modSuper, keyR}:                actionRecord,
+	}
+	for i, mode := range layoutModes {
+		b[KeyChord{modSuper, key1 + uint32(i)}] = actionLayout + string(mode)
+	}
+	return b
+}
+
+// String returns the bindings as JSON
+func (b *KeyBindings) String() string {
+	if b == nil || len(*b) == 0 {
+		return ""
+	}
+	bindings := make(map[string]string, len(*b))
+	for chord, action := range *b {
+		bindings[chord.String()] = action
+	}
+	data, _ := json.Marshal(bindings)
+	return string(data)
+}
+
+// Set parses bindings and lays them over the defaults, replacing the
+// current ones
+func (b *KeyBindings) Set(value string) error {
+	bindings, err := parseKeyBindings(value)
+	if err != nil {
+		return err
+	}
+	*b = bindings
+	return nil
+}
+
+// parseKeyBindings parses a JSON object of chord to action and lays it
+// over the defaults
+func parseKeyBindings(value string) (KeyBindings, error) {
+	bindings := DefaultKeyBindings()
+	if value == "" {
+		return bindings, nil
+	}
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("parse key bindings: %w", err)
+	}
+	// Chords in order, so errors are the same from run to run
+	chords := make([]string, 0, len(raw))
+	for chord := range raw {
+		chords = append(chords, chord)
+	}
+	sort.Strings(chords)
+	for _, name := range chords {
+		chord, err := parseKeyChord(name)
+		if err != nil {
+			return nil, err
+		}
+		action := raw[name]
+		if action == "" {
+			delete(bindings, chord)
+			continue
+		}
+		if err := validShortcutAction(action); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		bindings[chord] = action
+	}
+	return bindings, nil
+}
+
+// List returns the bindings sorted by chord, for the control API
+func (b KeyBindings) List() []KeyBinding {
+	list := make([]KeyBinding, 0, len(b))
+	for chord, action := range b {
+		list = append(list, KeyBinding{Chord: chord.String(), Action: action})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Chord < list[j].Chord })
+	return list
+}
+
+// KeyBinding is a chord and the action it runs
+type KeyBinding struct {
+	Chord  string `json:"chord"`
+	Action string `json:"action"`
+}
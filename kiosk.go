@@ -0,0 +1,169 @@
+package compositor
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// How long the kiosk app waits to be started again after it exits. Each
+// exit within kioskMaxRestartDelay of starting doubles the wait, so an app
+// that crashes on start does not spin; one that ran longer starts again
+// after kioskMinRestartDelay.
+const (
+	kioskMinRestartDelay = time.Second
+	kioskMaxRestartDelay = 30 * time.Second
+)
+
+// Kiosk is the -kiosk mode: one app filling the desktop without
+// decorations, started again whenever it exits, the cursor hidden while
+// the pointer is still and the compositor shortcuts off but for an escape
+// chord that leaves kiosk mode
+type Kiosk struct {
+	Command string
+	// Escape is the one shortcut kept, leaving kiosk mode
+	Escape KeyChord
+	// CursorTimeout is how long the pointer is still before the cursor is
+	// hidden, 0 to always show it
+	CursorTimeout time.Duration
+	// Layout, when set, fills the desktop with every window until kiosk
+	// mode is left
+	Layout *LayoutEngine
+
+	mu       sync.Mutex
+	active   bool
+	moved    time.Time
+	pid      int
+	restarts int
+}
+
+// NewKiosk enters kiosk mode for command, laying out every window to fill
+// the desktop
+func NewKiosk(command string, escape KeyChord, cursorTimeout time.Duration, layout *LayoutEngine, now time.Time) *Kiosk {
+	k := &Kiosk{Command: command, Escape: escape, CursorTimeout: cursorTimeout, Layout: layout, active: true, moved: now}
+	if layout != nil {
+		layout.SetKiosk(true)
+	}
+	return k
+}
+
+// Active reports whether kiosk mode is on; a nil Kiosk is never on
+func (k *Kiosk) Active() bool {
+	if k == nil {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.active
+}
+
+// Leave ends kiosk mode: the layout and shortcuts are back, the cursor is
+// shown and the app, which keeps running, is not started again
+func (k *Kiosk) Leave() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.active {
+		return
+	}
+	k.active = false
+	if k.Layout != nil {
+		k.Layout.SetKiosk(false)
+	}
+}
+
+// PointerMoved notes pointer activity, showing the cursor again
+func (k *Kiosk) PointerMoved(now time.Time) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.moved = now
+}
+
+// CursorHidden reports whether the cursor is hidden at now
+func (k *Kiosk) CursorHidden(now time.Time) bool {
+	if k == nil {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.active && k.CursorTimeout > 0 && now.Sub(k.moved) >= k.CursorTimeout
+}
+
+// Run starts the app with env added to the compositor's environment and
+// starts it again each time it exits, until kiosk mode is left
+func (k *Kiosk) Run(env []string) {
+	var delay time.Duration
+	for k.Active() {
+		started := time.Now()
+		cmd := exec.Command("/bin/sh", "-c", k.Command)
+		cmd.Env = append(clientEnviron(), env...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			log.Printf("Kiosk: failed to launch %q: %v", k.Command, err)
+		} else {
+			k.mu.Lock()
+			k.pid = cmd.Process.Pid
+			k.mu.Unlock()
+			log.Printf("Kiosk: launched %q (pid %d)", k.Command, cmd.Process.Pid)
+			err := cmd.Wait()
+			log.Printf("Kiosk: %q exited: %v", k.Command, err)
+		}
+
+		delay = kioskRestartDelay(delay, time.Since(started))
+		if !k.Active() {
+			return
+		}
+		log.Printf("Kiosk: starting %q again in %v", k.Command, delay)
+		time.Sleep(delay)
+		k.mu.Lock()
+		k.restarts++
+		k.mu.Unlock()
+	}
+}
+
+// KioskInfo is the kiosk's state for the control API
+type KioskInfo struct {
+	Active   bool   `json:"active"`
+	Command  string `json:"command"`
+	Escape   string `json:"escape"`
+	PID      int    `json:"pid,omitempty"`
+	Restarts int    `json:"restarts"`
+}
+
+// Info returns the kiosk's state
+func (k *Kiosk) Info() KioskInfo {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return KioskInfo{Active: k.active, Command: k.Command, Escape: k.Escape.String(), PID: k.pid, Restarts: k.restarts}
+}
+
+// kioskRestartDelay is how long to wait before starting the app again
+// after it ran for ran, the last wait having been previous
+func kioskRestartDelay(previous, ran time.Duration) time.Duration {
+	if ran >= kioskMaxRestartDelay || previous == 0 {
+		return kioskMinRestartDelay
+	}
+	return min(previous*2, kioskMaxRestartDelay)
+}
+
+// splitCursors separates the cursor surfaces from the rest of placed,
+// keeping both in order
+func splitCursors(placed []PlacedSurface) (others, cursors []PlacedSurface) {
+	for _, p := range placed {
+		if root := wayland.GetWlSurfaceObject(p.Client, p.Root); root != nil {
+			if _, isCursor := root.Role.(*wayland.SurfaceRoleCursor); isCursor {
+				cursors = append(cursors, p)
+				continue
+			}
+		}
+		others = append(others, p)
+	}
+	return others, cursors
+}
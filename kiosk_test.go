@@ -0,0 +1,98 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func TestKioskRestartDelay(t *testing.T) {
+	delay := time.Duration(0)
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		delay = kioskRestartDelay(delay, 100*time.Millisecond)
+		if delay != want {
+			t.Errorf("Expected %v after a quick exit, got %v", want, delay)
+		}
+	}
+	for range 10 {
+		delay = kioskRestartDelay(delay, 0)
+	}
+	if delay != kioskMaxRestartDelay {
+		t.Errorf("Expected the delay capped at %v, got %v", kioskMaxRestartDelay, delay)
+	}
+	// An app that ran a while starts again quickly
+	if delay = kioskRestartDelay(delay, time.Hour); delay != kioskMinRestartDelay {
+		t.Errorf("Expected %v after a long run, got %v", kioskMinRestartDelay, delay)
+	}
+}
+
+func TestKioskCursor(t *testing.T) {
+	start := time.Now()
+	k := NewKiosk("app", KeyChord{}, 3*time.Second, nil, start)
+	if k.CursorHidden(start.Add(time.Second)) {
+		t.Error("Expected the cursor shown right after the pointer moved")
+	}
+	if !k.CursorHidden(start.Add(3 * time.Second)) {
+		t.Error("Expected the cursor hidden once the pointer was still")
+	}
+	k.PointerMoved(start.Add(4 * time.Second))
+	if k.CursorHidden(start.Add(5 * time.Second)) {
+		t.Error("Expected the cursor shown again when the pointer moved")
+	}
+
+	k.Leave()
+	if k.CursorHidden(start.Add(time.Hour)) {
+		t.Error("Expected the cursor always shown after leaving kiosk mode")
+	}
+	var none *Kiosk
+	if none.Active() || none.CursorHidden(start.Add(time.Hour)) {
+		t.Error("Expected no kiosk mode without a kiosk")
+	}
+}
+
+func TestKioskLayout(t *testing.T) {
+	l := layoutWithWindows(LayoutColumns, 2)
+	l.SetExclusive([4]int{40, 0, 0, 0})
+	k := NewKiosk("app", KeyChord{}, 0, l, time.Now())
+	// Every window fills the desktop, over panels
+	for i, got := range l.arrange() {
+		if got != image.Rect(0, 0, 1200, 800) {
+			t.Errorf("Window %d at %v, want the whole desktop", i, got)
+		}
+	}
+	k.Leave()
+	if got := l.arrange(); got[0] != image.Rect(0, 40, 600, 800) {
+		t.Errorf("Expected the windows back in their columns, got %v", got)
+	}
+}
+
+func TestKioskShortcuts(t *testing.T) {
+	l := layoutWithWindows(LayoutFullscreen, 0)
+	escape, _ := parseKeyChord("Ctrl+Alt+Backspace")
+	k := NewKiosk("app", escape, 0, l, time.Now())
+	s := &Shortcuts{Layout: l, Kiosk: k}
+
+	s.Handle(keyLeftMeta, true)
+	if s.Handle(keySpace, true) || l.Mode() != LayoutFullscreen {
+		t.Error("Super+Space was taken as a shortcut in kiosk mode")
+	}
+	s.Handle(keySpace, false)
+	s.Handle(keyLeftMeta, false)
+
+	s.Handle(keyLeftCtrl, true)
+	s.Handle(keyLeftAlt, true)
+	if !s.Handle(keyBackspace, true) || k.Active() {
+		t.Fatal("The escape chord did not leave kiosk mode")
+	}
+	if !s.Handle(keyBackspace, false) {
+		t.Error("Release of the escape chord reached clients")
+	}
+	s.Handle(keyLeftCtrl, false)
+	s.Handle(keyLeftAlt, false)
+
+	// The shortcuts are back
+	s.Handle(keyLeftMeta, true)
+	if !s.Handle(keySpace, true) || l.Mode() != LayoutFloating {
+		t.Errorf("Super+Space after kiosk mode: mode %s, want %s", l.Mode(), LayoutFloating)
+	}
+}
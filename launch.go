@@ -0,0 +1,48 @@
+package compositor
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// launchCommand runs a shell command as a client of the compositor and
+// reaps it in the background
+func launchCommand(command string, env []string) (int, error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(clientEnviron(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("Launched command %q exited: %v", command, err)
+		}
+	}()
+	return cmd.Process.Pid, nil
+}
+
+// LaunchCommands is the -launch flag: the apps to start once the
+// compositor is up, given once each
+type LaunchCommands []string
+
+// String returns the commands, one per line
+func (l *LaunchCommands) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, "\n")
+}
+
+// Set adds a command
+func (l *LaunchCommands) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("empty command")
+	}
+	*l = append(*l, value)
+	return nil
+}
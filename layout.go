@@ -0,0 +1,672 @@
+package compositor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// LayoutMode is how the layout engine arranges Wayland toplevels
+type LayoutMode string
+
+const (
+	// LayoutFullscreen fills the desktop with every window, stacked
+	LayoutFullscreen LayoutMode = "fullscreen"
+	// LayoutFloating puts windows where they were last, at their own size
+	LayoutFloating LayoutMode = "floating"
+	// LayoutColumns gives the first window the left half and stacks the
+	// others in the right half
+	LayoutColumns LayoutMode = "columns"
+	// LayoutGrid tiles windows in a near-square grid
+	LayoutGrid LayoutMode = "grid"
+	// LayoutAuto gives each app an equal cell of a grid shaped to the
+	// desktop, with the app's windows stacked in it
+	LayoutAuto LayoutMode = "auto"
+)
+
+// layoutModes are the modes in the order shortcuts cycle through them
+var layoutModes = []LayoutMode{LayoutFullscreen, LayoutFloating, LayoutColumns, LayoutGrid, LayoutAuto}
+
+// parseLayoutMode checks a mode name
+func parseLayoutMode(name string) (LayoutMode, error) {
+	for _, mode := range layoutModes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown layout %q, want one of %v", name, layoutModes)
+}
+
+// WindowGeometry is a floating window's place on the desktop
+type WindowGeometry struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (g WindowGeometry) rect() image.Rectangle {
+	return image.Rect(g.X, g.Y, g.X+g.Width, g.Y+g.Height)
+}
+
+func geometryOf(r image.Rectangle) WindowGeometry {
+	return WindowGeometry{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()}
+}
+
+// LayoutCell is a cell of the grid or auto layout, for drawing its border
+// and label around the windows in it
+type LayoutCell struct {
+	Bounds image.Rectangle
+	// Label is the title of the cell's first window, or its app id
+	Label string
+	// Index counts the cells shown, for telling them apart by color
+	Index int
+}
+
+// Cell decorations: a label bar across the top of each cell and a border
+// around the rest, the windows inset within them
+const (
+	cellBorder      = 3
+	cellLabelScale  = 2
+	cellLabelHeight = (cellHeight + 2) * cellLabelScale
+)
+
+// layoutWindow is a toplevel the engine places
+type layoutWindow struct {
+	key      surfaceKey
+	toplevel protocols.ObjectID[protocols.XdgToplevel]
+	appID    string
+
+	// floating is where the window goes in floating mode
+	floating image.Rectangle
+	// float, when set by a window rule, floats the window or tiles it
+	// whatever the mode, and undecorated leaves it out of cell decorations
+	float       *bool
+	undecorated bool
+	// fullscreen fills the window's workspace with it whatever the mode
+	fullscreen bool
+	// configured is the size last asked of the client, and settled is
+	// set once the client has drawn at it
+	configured           image.Point
+	configuredFullscreen bool
+	settled              bool
+}
+
+// LayoutEngine places Wayland toplevels by the current mode. Floating
+// geometry is remembered by app id, so an app that restarts comes back
+// where it was, and saved to Path when it is set. X11 windows are left to
+// the X window manager.
+type LayoutEngine struct {
+	Path string
+	// Workspaces, when set, lays each workspace out on its own, in its
+	// region of the desktop if it has one
+	Workspaces *Workspaces
+	// Rules, when set, may give new windows their geometry, float or tile
+	// them, and leave them undecorated
+	Rules *WindowRuleSet
+	// Decorations, when set, leaves windows that draw their own
+	// decorations out of cell decorations
+	Decorations *Decorations
+
+	mu     sync.Mutex
+	mode   LayoutMode
+	bounds image.Rectangle
+	// exclusive is the space kept free of tiled windows at the top,
+	// right, bottom and left edges
+	exclusive [4]int
+	windows   []*layoutWindow // in the order they appeared
+	saved     map[string]WindowGeometry
+	// pointerOffsets are how far each client's top window was moved
+	pointerOffsets map[*wayland.Client]image.Point
+	// decorated insets windows in the grid and auto layouts to make room
+	// for their cells' labels and borders, cells being those shown at the
+	// last Apply
+	decorated bool
+	cells     []LayoutCell
+	// kiosk fills the desktop with every window, as if each were
+	// fullscreen
+	kiosk bool
+}
+
+// NewLayoutEngine creates an engine for a desktop of the given size,
+// loading saved geometry from path if it exists
+func NewLayoutEngine(mode LayoutMode, width, height int, path string) (*LayoutEngine, error) {
+	l := &LayoutEngine{
+		Path:   path,
+		mode:   mode,
+		bounds: image.Rect(0, 0, width, height),
+		saved:  make(map[string]WindowGeometry),
+	}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read window geometry: %w", err)
+	}
+	if err := json.Unmarshal(data, &l.saved); err != nil {
+		return nil, fmt.Errorf("parse window geometry %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// Mode returns the current mode
+func (l *LayoutEngine) Mode() LayoutMode {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.mode
+}
+
+// SetMode switches modes; windows are rearranged on the next Apply
+func (l *LayoutEngine) SetMode(mode LayoutMode) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mode = mode
+}
+
+// Cycle switches to the next mode and returns it
+func (l *LayoutEngine) Cycle() LayoutMode {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, mode := range layoutModes {
+		if mode == l.mode {
+			l.mode = layoutModes[(i+1)%len(layoutModes)]
+			break
+		}
+	}
+	return l.mode
+}
+
+// Decorated reports whether grid and auto layout cells get labels and
+// borders
+func (l *LayoutEngine) Decorated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.decorated
+}
+
+// SetDecorated switches the labels and borders of grid and auto layout
+// cells on or off; windows are rearranged on the next Apply
+func (l *LayoutEngine) SetDecorated(decorated bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.decorated = decorated
+}
+
+// SetKiosk makes every window fill its workspace, over any panels, or
+// puts them back in the layout
+func (l *LayoutEngine) SetKiosk(kiosk bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.kiosk = kiosk
+}
+
+// Cells returns the grid or auto layout cells shown at the last Apply,
+// none unless they are decorated
+func (l *LayoutEngine) Cells() []LayoutCell {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cells
+}
+
+// SetBounds resizes the area windows are laid out in
+func (l *LayoutEngine) SetBounds(width, height int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bounds = image.Rect(0, 0, width, height)
+}
+
+// SetExclusive keeps space at the top, right, bottom and left edges free
+// of windows in every layout but floating, as for a panel
+func (l *LayoutEngine) SetExclusive(zones [4]int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.exclusive = zones
+}
+
+// area is the part of the desktop windows are laid out in
+func (l *LayoutEngine) area() image.Rectangle {
+	if l.mode == LayoutFloating {
+		return l.bounds
+	}
+	z := l.exclusive
+	area := image.Rect(l.bounds.Min.X+z[3], l.bounds.Min.Y+z[0], l.bounds.Max.X-z[1], l.bounds.Max.Y-z[2])
+	if area.Dx() < 1 || area.Dy() < 1 {
+		return l.bounds
+	}
+	return area
+}
+
+// Move puts a floating window's top left corner at x, y
+func (l *LayoutEngine) Move(c *wayland.Client, surfaceID protocols.ObjectID[protocols.WlSurface], x, y int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, w := range l.windows {
+		if w.key == (surfaceKey{client: c, id: surfaceID}) {
+			if !l.floats(w) {
+				return fmt.Errorf("windows can only be moved in the %s layout, or when a rule floats them", LayoutFloating)
+			}
+			w.floating = w.floating.Add(image.Pt(x, y).Sub(w.floating.Min))
+			l.remember(w)
+			return nil
+		}
+	}
+	return fmt.Errorf("toplevel surface %d has not been shown yet", surfaceID)
+}
+
+// ToggleFullscreen fills the window's workspace with a toplevel, over
+// any panels, or puts it back in the layout, and reports whether it is
+// now fullscreen
+func (l *LayoutEngine) ToggleFullscreen(key surfaceKey) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, w := range l.windows {
+		if w.key == key {
+			w.fullscreen = !w.fullscreen
+			return w.fullscreen, nil
+		}
+	}
+	return false, fmt.Errorf("toplevel surface %d has not been shown yet", key.id)
+}
+
+// CloseWindow asks a toplevel's client to close it
+func (l *LayoutEngine) CloseWindow(key surfaceKey) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, w := range l.windows {
+		if w.key == key {
+			protocols.XdgToplevel_close(key.client, w.toplevel)
+			return nil
+		}
+	}
+	return fmt.Errorf("toplevel surface %d has not been shown yet", key.id)
+}
+
+// fills reports whether a window fills its workspace, fullscreen
+func (l *LayoutEngine) fills(w *layoutWindow) bool {
+	return w.fullscreen || l.kiosk
+}
+
+// floats reports whether a window keeps its floating geometry
+func (l *LayoutEngine) floats(w *layoutWindow) bool {
+	if w.float != nil {
+		return *w.float
+	}
+	return l.mode == LayoutFloating
+}
+
+// Apply moves the surfaces of each toplevel in placed (bottom first) to
+// its place in the layout, asking clients to resize when their place
+// changes size. Call it from the render loop.
+func (l *LayoutEngine) Apply(placed []PlacedSurface) []PlacedSurface {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	roots := make(map[surfaceKey]PlacedSurface)
+	for _, p := range placed {
+		if isToplevelRoot(p) {
+			roots[surfaceKey{client: p.Client, id: p.SurfaceID}] = p
+		}
+	}
+
+	// Forget closed windows, then add new ones in stacking order
+	known := make(map[surfaceKey]bool, len(l.windows))
+	windows := l.windows[:0]
+	for _, w := range l.windows {
+		if _, ok := roots[w.key]; ok {
+			windows = append(windows, w)
+			known[w.key] = true
+		}
+	}
+	l.windows = windows
+	for _, p := range placed {
+		key := surfaceKey{client: p.Client, id: p.SurfaceID}
+		if _, ok := roots[key]; ok && !known[key] {
+			l.windows = append(l.windows, l.newWindow(p))
+			known[key] = true
+		}
+	}
+
+	rects := l.arrange()
+	l.decorate(rects)
+	offsets := make(map[surfaceKey]image.Point, len(l.windows))
+	for i, w := range l.windows {
+		root := roots[w.key]
+		rect := rects[i]
+		fullscreen := l.mode == LayoutFullscreen && !l.floats(w) || l.fills(w)
+
+		if l.floats(w) && !l.fills(w) && w.settled && w.configured == w.floating.Size() && root.Size != w.configured {
+			// The client picked another size, e.g. after a resize request
+			w.floating.Max = w.floating.Min.Add(root.Size)
+			l.remember(w)
+			rect.Max = rect.Min.Add(root.Size)
+		}
+		if rect.Size() != w.configured || fullscreen != w.configuredFullscreen {
+			if err := configureToplevel(w.key.client, w.toplevel, rect.Dx(), rect.Dy(), fullscreen); err != nil {
+				log.Printf("Layout: failed to configure toplevel %d: %v", w.toplevel, err)
+			}
+			w.configured, w.configuredFullscreen, w.settled = rect.Size(), fullscreen, false
+		}
+		if root.Size == w.configured {
+			w.settled = true
+		}
+		offsets[w.key] = rect.Min.Sub(image.Pt(root.X, root.Y))
+	}
+
+	l.pointerOffsets = make(map[*wayland.Client]image.Point)
+	for i, p := range placed {
+		key := surfaceKey{client: p.Client, id: p.Root}
+		if offset, ok := offsets[key]; ok {
+			placed[i].X += offset.X
+			placed[i].Y += offset.Y
+			if l.Workspaces == nil || l.Workspaces.Shown(key) {
+				l.pointerOffsets[p.Client] = offset
+			}
+		}
+	}
+	return placed
+}
+
+// decorate collects the cells of the grid and auto layouts when they are
+// decorated and insets the windows in rects to fit inside their borders
+// and under their labels
+func (l *LayoutEngine) decorate(rects []image.Rectangle) {
+	l.cells = nil
+	if !l.decorated || (l.mode != LayoutGrid && l.mode != LayoutAuto) {
+		return
+	}
+	// The windows of an app share their cell in the auto layout
+	type cellKey struct {
+		workspace int
+		bounds    image.Rectangle
+	}
+	seen := make(map[cellKey]bool)
+	for i, w := range l.windows {
+		if w.undecorated || l.fills(w) || l.floats(w) || l.Decorations.ClientSide(w.key.client, w.toplevel) {
+			continue
+		}
+		cell := rects[i]
+		inset := image.Rect(cell.Min.X+cellBorder, cell.Min.Y+cellLabelHeight, cell.Max.X-cellBorder, cell.Max.Y-cellBorder)
+		if inset.Dx() < 1 || inset.Dy() < 1 {
+			continue
+		}
+		rects[i] = inset
+		ws := 1
+		if l.Workspaces != nil {
+			if !l.Workspaces.Shown(w.key) {
+				continue
+			}
+			ws = l.Workspaces.Of(w.key)
+		}
+		key := cellKey{workspace: ws, bounds: cell}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		l.cells = append(l.cells, LayoutCell{Bounds: cell, Label: w.label(), Index: len(l.cells)})
+	}
+}
+
+// label is a window's title, or its app id before it has one
+func (w *layoutWindow) label() string {
+	if toplevel := wayland.GetXdgToplevelObject(w.key.client, w.toplevel); toplevel != nil && toplevel.Title != nil && *toplevel.Title != "" {
+		return *toplevel.Title
+	}
+	return w.appID
+}
+
+// isToplevelRoot reports whether a placed surface is an xdg_toplevel
+// rather than one of the surfaces stacked on it
+func isToplevelRoot(p PlacedSurface) bool {
+	if p.SurfaceID != p.Root {
+		return false
+	}
+	role, ok := p.Surface.Role.(*wayland.SurfaceRoleXdgToplevel)
+	return ok && role.Data != nil
+}
+
+// SendPointerMotion sends a desktop position through guard to each client
+// relative to where its top window was moved, since clients take it as is
+func (l *LayoutEngine) SendPointerMotion(guard *SendGuard, clients []*wayland.Client, x, y float32) {
+	l.mu.Lock()
+	offsets := l.pointerOffsets
+	l.mu.Unlock()
+	timestamp := uint32(time.Now().UnixMilli())
+	for _, c := range clients {
+		offset := offsets[c]
+		guard.Deliver([]*wayland.Client{c}, pointerMotion(timestamp, x-float32(offset.X), y-float32(offset.Y)))
+	}
+	wayland.Pointer.WindowX, wayland.Pointer.WindowY = x, y
+}
+
+// newWindow starts tracking a toplevel, placing it where its app was
+// last, where its rules put it, or cascading it at two thirds of the
+// desktop
+func (l *LayoutEngine) newWindow(p PlacedSurface) *layoutWindow {
+	role := p.Surface.Role.(*wayland.SurfaceRoleXdgToplevel)
+	w := &layoutWindow{
+		key:      surfaceKey{client: p.Client, id: p.SurfaceID},
+		toplevel: *role.Data,
+	}
+	if toplevel := wayland.GetXdgToplevelObject(p.Client, *role.Data); toplevel != nil {
+		w.appID = toplevel.AppID
+	}
+	actions := l.Rules.MatchToplevel(p)
+	w.float = actions.Floating
+	w.undecorated = actions.Decorations != nil && !*actions.Decorations
+	if saved, ok := l.saved[w.appID]; ok && w.appID != "" {
+		w.floating = saved.rect()
+		return w
+	}
+	if actions.Geometry != nil {
+		w.floating = actions.Geometry.rect()
+		return w
+	}
+	bounds := l.workspaceBounds(w.key)
+	step := 32 * (len(l.windows) % 8)
+	w.floating = image.Rect(step, step, step+bounds.Dx()*2/3, step+bounds.Dy()*2/3)
+	return w
+}
+
+// workspaceBounds is the area a window is laid out in
+func (l *LayoutEngine) workspaceBounds(key surfaceKey) image.Rectangle {
+	if l.Workspaces == nil {
+		return l.area()
+	}
+	return l.Workspaces.Bounds(l.Workspaces.Of(key), l.area())
+}
+
+// fullscreenBounds is the area a fullscreen window fills: its workspace's
+// region of the whole desktop
+func (l *LayoutEngine) fullscreenBounds(key surfaceKey) image.Rectangle {
+	if l.Workspaces == nil {
+		return l.bounds
+	}
+	return l.Workspaces.Bounds(l.Workspaces.Of(key), l.bounds)
+}
+
+// arrange returns where each window goes in the current mode, laying
+// each workspace out on its own
+func (l *LayoutEngine) arrange() []image.Rectangle {
+	rects := make([]image.Rectangle, len(l.windows))
+	groups := make(map[int][]int)
+	var order []int
+	for i, w := range l.windows {
+		ws := 1
+		if l.Workspaces != nil {
+			ws = l.Workspaces.Of(w.key)
+		}
+		if _, ok := groups[ws]; !ok {
+			order = append(order, ws)
+		}
+		groups[ws] = append(groups[ws], i)
+	}
+	for _, ws := range order {
+		bounds := l.workspaceBounds(l.windows[groups[ws][0]].key)
+		// Windows floated by their rules keep their geometry, and those
+		// tiled by them fill the floating layout
+		var indices []int
+		for _, i := range groups[ws] {
+			w := l.windows[i]
+			switch {
+			case l.fills(w):
+				rects[i] = l.fullscreenBounds(w.key)
+			case l.floats(w):
+				rects[i] = w.floating.Add(bounds.Min)
+			case l.mode == LayoutFloating:
+				rects[i] = bounds
+			default:
+				indices = append(indices, i)
+			}
+		}
+		if len(indices) == 0 {
+			continue
+		}
+		floating := make([]image.Rectangle, len(indices))
+		for j, i := range indices {
+			floating[j] = l.windows[i].floating
+		}
+		placed := arrangeIn(l.mode, bounds, floating)
+		if l.mode == LayoutAuto {
+			// Number the apps in the order their first window appeared
+			apps := make([]int, len(indices))
+			numbers := make(map[*wayland.Client]int)
+			for j, i := range indices {
+				client := l.windows[i].key.client
+				if _, ok := numbers[client]; !ok {
+					numbers[client] = len(numbers)
+				}
+				apps[j] = numbers[client]
+			}
+			placed = arrangeApps(bounds, apps)
+		}
+		for j, rect := range placed {
+			rects[indices[j]] = rect
+		}
+	}
+	return rects
+}
+
+// arrangeIn lays out windows in bounds; floating windows keep their
+// floating geometry, relative to the corner of bounds
+func arrangeIn(mode LayoutMode, b image.Rectangle, floating []image.Rectangle) []image.Rectangle {
+	n := len(floating)
+	rects := make([]image.Rectangle, n)
+	switch {
+	case mode == LayoutFloating:
+		for i, f := range floating {
+			rects[i] = f.Add(b.Min)
+		}
+	case mode == LayoutFullscreen || n == 1:
+		for i := range rects {
+			rects[i] = b
+		}
+	case mode == LayoutColumns:
+		mid := b.Min.X + b.Dx()/2
+		rects[0] = image.Rect(b.Min.X, b.Min.Y, mid, b.Max.Y)
+		for i := 1; i < n; i++ {
+			rects[i] = image.Rect(mid, b.Min.Y+b.Dy()*(i-1)/(n-1), b.Max.X, b.Min.Y+b.Dy()*i/(n-1))
+		}
+	case mode == LayoutGrid:
+		cols := int(math.Ceil(math.Sqrt(float64(n))))
+		rows := (n + cols - 1) / cols
+		for i := range rects {
+			col, row := i%cols, i/cols
+			rects[i] = image.Rect(
+				b.Min.X+b.Dx()*col/cols, b.Min.Y+b.Dy()*row/rows,
+				b.Min.X+b.Dx()*(col+1)/cols, b.Min.Y+b.Dy()*(row+1)/rows,
+			)
+		}
+	}
+	return rects
+}
+
+// gridCellAspect is the width to height ratio the auto layout's cells
+// aim for
+const gridCellAspect = 4.0 / 3
+
+// arrangeApps lays out windows in bounds by app, apps[i] being the
+// number of window i's app counted from 0: each app gets an equal cell of
+// a grid, and a lone app all of bounds
+func arrangeApps(b image.Rectangle, apps []int) []image.Rectangle {
+	n := 0
+	for _, app := range apps {
+		n = max(n, app+1)
+	}
+	cols, rows := gridShape(n, b.Size())
+	rects := make([]image.Rectangle, len(apps))
+	for i, app := range apps {
+		col, row := app%cols, app/cols
+		rects[i] = image.Rect(
+			b.Min.X+b.Dx()*col/cols, b.Min.Y+b.Dy()*row/rows,
+			b.Min.X+b.Dx()*(col+1)/cols, b.Min.Y+b.Dy()*(row+1)/rows,
+		)
+	}
+	return rects
+}
+
+// gridShape picks the columns and rows of a grid of n cells over size,
+// weighing cells close to the shape of a typical window against leaving
+// cells empty
+func gridShape(n int, size image.Point) (cols, rows int) {
+	cols, rows = 1, max(n, 1)
+	best := math.Inf(1)
+	for c := 1; c <= n; c++ {
+		r := (n + c - 1) / c
+		aspect := (float64(size.X) / float64(c)) / (float64(size.Y) / float64(r))
+		score := math.Abs(math.Log(aspect/gridCellAspect)) + float64(c*r-n)/float64(n)
+		if score < best {
+			cols, rows, best = c, r, score
+		}
+	}
+	return cols, rows
+}
+
+// remember saves a floating window's geometry under its app id
+func (l *LayoutEngine) remember(w *layoutWindow) {
+	if w.appID == "" || l.saved[w.appID] == geometryOf(w.floating) {
+		return
+	}
+	l.saved[w.appID] = geometryOf(w.floating)
+	if l.Path == "" {
+		return
+	}
+	if err := l.save(); err != nil {
+		log.Printf("Layout: failed to save window geometry: %v", err)
+	}
+}
+
+// save writes the saved geometry to Path through a temporary file, so a
+// crash never leaves it half written
+func (l *LayoutEngine) save() error {
+	data, err := json.MarshalIndent(l.saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.Path), ".layout-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.Path)
+}
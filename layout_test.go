@@ -0,0 +1,201 @@
+package compositor
+
+import (
+	"image"
+	"path/filepath"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// layoutWithWindows returns an engine for a 1200x800 desktop tracking n
+// windows
+func layoutWithWindows(mode LayoutMode, n int) *LayoutEngine {
+	l, _ := NewLayoutEngine(mode, 1200, 800, "")
+	for i := range n {
+		l.windows = append(l.windows, &layoutWindow{floating: image.Rect(10*i, 10*i, 10*i+300, 10*i+200)})
+	}
+	return l
+}
+
+func TestLayoutArrange(t *testing.T) {
+	tests := []struct {
+		mode LayoutMode
+		n    int
+		want []image.Rectangle
+	}{
+		{LayoutFullscreen, 2, []image.Rectangle{image.Rect(0, 0, 1200, 800), image.Rect(0, 0, 1200, 800)}},
+		{LayoutFloating, 2, []image.Rectangle{image.Rect(0, 0, 300, 200), image.Rect(10, 10, 310, 210)}},
+		{LayoutColumns, 1, []image.Rectangle{image.Rect(0, 0, 1200, 800)}},
+		{LayoutColumns, 3, []image.Rectangle{
+			image.Rect(0, 0, 600, 800), image.Rect(600, 0, 1200, 400), image.Rect(600, 400, 1200, 800),
+		}},
+		{LayoutGrid, 3, []image.Rectangle{
+			image.Rect(0, 0, 600, 400), image.Rect(600, 0, 1200, 400), image.Rect(0, 400, 600, 800),
+		}},
+	}
+	for _, tt := range tests {
+		got := layoutWithWindows(tt.mode, tt.n).arrange()
+		if len(got) != len(tt.want) {
+			t.Errorf("%s with %d windows: got %v, want %v", tt.mode, tt.n, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s with %d windows: window %d at %v, want %v", tt.mode, tt.n, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestLayoutAuto(t *testing.T) {
+	// Three apps on a 1200x800 desktop are a 2x2 grid, the second app's
+	// dialog stacked on its window
+	l := layoutWithWindows(LayoutAuto, 0)
+	apps := []*wayland.Client{new(wayland.Client), new(wayland.Client), new(wayland.Client)}
+	for _, c := range []*wayland.Client{apps[0], apps[1], apps[2], apps[1]} {
+		l.windows = append(l.windows, &layoutWindow{key: surfaceKey{client: c}})
+	}
+	want := []image.Rectangle{
+		image.Rect(0, 0, 600, 400), image.Rect(600, 0, 1200, 400), image.Rect(0, 400, 600, 800), image.Rect(600, 0, 1200, 400),
+	}
+	for i, rect := range l.arrange() {
+		if rect != want[i] {
+			t.Errorf("Window %d at %v, want %v", i, rect, want[i])
+		}
+	}
+
+	for _, tt := range []struct {
+		n          int
+		size       image.Point
+		cols, rows int
+	}{
+		{1, image.Pt(1920, 1080), 1, 1},
+		{2, image.Pt(1920, 1080), 2, 1},
+		{3, image.Pt(1920, 1080), 2, 2},
+		{4, image.Pt(1920, 1080), 2, 2},
+		{5, image.Pt(1920, 1080), 3, 2},
+		{3, image.Pt(1080, 1920), 1, 3},
+	} {
+		if cols, rows := gridShape(tt.n, tt.size); cols != tt.cols || rows != tt.rows {
+			t.Errorf("%d cells over %v: got %dx%d, want %dx%d", tt.n, tt.size, cols, rows, tt.cols, tt.rows)
+		}
+	}
+}
+
+func TestLayoutCycleAndParse(t *testing.T) {
+	l := layoutWithWindows(LayoutAuto, 0)
+	if got := l.Cycle(); got != LayoutFullscreen {
+		t.Errorf("Cycle from auto = %s, want %s", got, LayoutFullscreen)
+	}
+	if _, err := parseLayoutMode("spiral"); err == nil {
+		t.Error("parseLayoutMode accepted an unknown mode")
+	}
+	if mode, err := parseLayoutMode("columns"); err != nil || mode != LayoutColumns {
+		t.Errorf("parseLayoutMode(columns) = %q, %v", mode, err)
+	}
+}
+
+func TestLayoutGeometryPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "layout.json")
+	l, err := NewLayoutEngine(LayoutFloating, 1200, 800, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &layoutWindow{key: surfaceKey{id: 7}, appID: "foot", floating: image.Rect(0, 0, 300, 200)}
+	l.windows = append(l.windows, w)
+
+	l.SetMode(LayoutGrid)
+	if err := l.Move(nil, 7, 50, 60); err == nil {
+		t.Error("Move succeeded outside the floating layout")
+	}
+	l.SetMode(LayoutFloating)
+	if err := l.Move(nil, 7, 50, 60); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if err := l.Move(nil, 8, 0, 0); err == nil {
+		t.Error("Move of an unknown window succeeded")
+	}
+
+	reloaded, err := NewLayoutEngine(LayoutFloating, 1200, 800, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := WindowGeometry{X: 50, Y: 60, Width: 300, Height: 200}
+	if got := reloaded.saved["foot"]; got != want {
+		t.Errorf("reloaded geometry = %+v, want %+v", got, want)
+	}
+}
+
+func TestLayoutExclusive(t *testing.T) {
+	l := layoutWithWindows(LayoutColumns, 2)
+	l.SetExclusive([4]int{40, 0, 0, 100})
+	got := l.arrange()
+	want := []image.Rectangle{image.Rect(100, 40, 650, 800), image.Rect(650, 40, 1200, 800)}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("window %d at %v, want %v", i, got[i], want[i])
+		}
+	}
+	// Floating windows may go anywhere
+	l.SetMode(LayoutFloating)
+	if got := l.arrange(); got[0] != image.Rect(0, 0, 300, 200) {
+		t.Errorf("floating window at %v, want it unmoved", got[0])
+	}
+}
+
+func TestLayoutDecorated(t *testing.T) {
+	l := layoutWithWindows(LayoutAuto, 0)
+	l.SetDecorated(true)
+	apps := []*wayland.Client{new(wayland.Client), new(wayland.Client)}
+	for i, c := range []*wayland.Client{apps[0], apps[1], apps[1]} {
+		l.windows = append(l.windows, &layoutWindow{key: surfaceKey{client: c}, appID: []string{"clock", "feed", "feed"}[i]})
+	}
+	rects := l.arrange()
+	l.decorate(rects)
+	if want := image.Rect(cellBorder, cellLabelHeight, 600-cellBorder, 800-cellBorder); rects[0] != want {
+		t.Errorf("Expected the first window inset to %v, got %v", want, rects[0])
+	}
+	if rects[1] != rects[2] {
+		t.Errorf("Expected an app's windows to share a cell, got %v and %v", rects[1], rects[2])
+	}
+	cells := l.Cells()
+	if len(cells) != 2 || cells[1].Bounds != image.Rect(600, 0, 1200, 800) || cells[1].Label != "feed" || cells[1].Index != 1 {
+		t.Errorf("Expected a cell for each app, got %+v", cells)
+	}
+
+	// Other layouts are left alone
+	l.SetMode(LayoutColumns)
+	rects = l.arrange()
+	l.decorate(rects)
+	if len(l.Cells()) != 0 || rects[0] != image.Rect(0, 0, 600, 800) {
+		t.Errorf("Expected no cells in the columns layout, got %+v and %v", l.Cells(), rects[0])
+	}
+}
+
+func TestLayoutWindowRules(t *testing.T) {
+	floats, tiles := true, false
+	l := layoutWithWindows(LayoutColumns, 3)
+	l.windows[1].float = &floats
+	if got := l.arrange(); got[0] != image.Rect(0, 0, 600, 800) || got[1] != image.Rect(10, 10, 310, 210) || got[2] != image.Rect(600, 0, 1200, 800) {
+		t.Errorf("Expected the floating window kept out of the columns, got %v", got)
+	}
+
+	l.SetMode(LayoutFloating)
+	l.windows[2].float = &tiles
+	if got := l.arrange(); got[0] != image.Rect(0, 0, 300, 200) || got[2] != image.Rect(0, 0, 1200, 800) {
+		t.Errorf("Expected the tiled window to fill the floating layout, got %v", got)
+	}
+
+	l.SetMode(LayoutGrid)
+	l.SetDecorated(true)
+	l.windows[2].undecorated = true
+	for _, w := range l.windows {
+		w.key.client = new(wayland.Client)
+	}
+	rects := l.arrange()
+	l.decorate(rects)
+	if cells := l.Cells(); len(cells) != 1 || rects[2] != image.Rect(600, 0, 1200, 800) {
+		t.Errorf("Expected only the first window decorated, got %+v and %v", cells, rects)
+	}
+}
@@ -0,0 +1,319 @@
+package compositor
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// wl_shm formats a mirror can read. The ARGB ones are little endian, so
+// their bytes are B, G, R, A.
+const (
+	shmARGB8888 = 0
+	shmXRGB8888 = 1
+	shmABGR8888 = 0x34324241
+	shmXBGR8888 = 0x34324258
+)
+
+// Mirror captures the first output of another compositor with the
+// wlr-screencopy-unstable-v1 protocol, so the real desktop can be shown on
+// the model underneath the clients hosted here. Frames are captured on
+// the mirror's own goroutine.
+type Mirror struct {
+	*sourceFrames
+	conn     *wlConn
+	interval time.Duration
+
+	// Globals bound on the other compositor
+	shm, output, manager uint32
+	managerVersion       uint32
+
+	// The shared memory buffer the other compositor copies frames into
+	buffer                        uint32
+	pool                          uint32
+	file                          *os.File
+	data                          []byte
+	width, height, stride, format uint32
+
+	mu   sync.Mutex
+	err  error
+	done chan struct{}
+}
+
+// NewMirror connects to display, a Wayland socket name such as wayland-0
+// or a path, and captures its first output at most every interval
+func NewMirror(display string, interval time.Duration) (*Mirror, error) {
+	conn, err := dialWayland(display)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mirror{sourceFrames: newSourceFrames(mirrorSurfaceID), conn: conn, interval: interval, done: make(chan struct{})}
+	if err := m.bind(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go m.run()
+	return m, nil
+}
+
+// bind finds and binds wl_shm, the first wl_output and the screencopy
+// manager
+func (m *Mirror) bind() error {
+	registry := m.conn.newID()
+	if err := m.conn.request(wlDisplayID, 1, wlArgs{}.uint32(registry)); err != nil {
+		return err
+	}
+	type global struct{ name, version uint32 }
+	globals := make(map[string]global)
+	err := m.conn.roundtrip(func(e wlEvent) {
+		if e.Object != registry || e.Opcode != 0 {
+			return
+		}
+		name, iface, version := e.Args.uint32(), e.Args.string(), e.Args.uint32()
+		if _, seen := globals[iface]; !seen {
+			globals[iface] = global{name, version}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("list globals: %w", err)
+	}
+
+	bind := func(iface string, maxVersion uint32) (uint32, uint32, error) {
+		g, ok := globals[iface]
+		if !ok {
+			return 0, 0, fmt.Errorf("the compositor does not offer %s", iface)
+		}
+		version := min(g.version, maxVersion)
+		id := m.conn.newID()
+		args := wlArgs{}.uint32(g.name).string(iface).uint32(version).uint32(id)
+		return id, version, m.conn.request(registry, 0, args)
+	}
+	if m.shm, _, err = bind("wl_shm", 1); err != nil {
+		return err
+	}
+	if m.output, _, err = bind("wl_output", 1); err != nil {
+		return err
+	}
+	if m.manager, m.managerVersion, err = bind("zwlr_screencopy_manager_v1", 3); err != nil {
+		return err
+	}
+	return m.conn.roundtrip(func(wlEvent) {})
+}
+
+// Close stops capturing and disconnects
+func (m *Mirror) Close() {
+	close(m.done)
+	m.conn.Close()
+}
+
+func (m *Mirror) run() {
+	defer m.releaseBuffer()
+	var failing bool
+	next := time.Now()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		next = time.Now().Add(m.interval)
+
+		err := m.capture()
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+		if err != nil {
+			if !failing {
+				log.Printf("Mirror: %v", err)
+			}
+			failing = true
+			m.mu.Lock()
+			m.err = err
+			m.mu.Unlock()
+			// Only a failed capture is worth trying again; a lost
+			// connection does not come back
+			if _, retry := err.(*frameFailedError); !retry {
+				return
+			}
+			next = time.Now().Add(time.Second)
+			continue
+		}
+		failing = false
+	}
+}
+
+// frameFailedError is a capture the other compositor could not make, which
+// may work next time
+type frameFailedError struct{}
+
+func (*frameFailedError) Error() string {
+	return "the compositor could not capture its output"
+}
+
+// capture takes one frame: it asks for the output, copies it into the
+// shared buffer once the compositor says which buffer it wants, and
+// converts it into the back texture
+func (m *Mirror) capture() error {
+	frame := m.conn.newID()
+	// overlay_cursor 1 draws the pointer into the frame
+	if err := m.conn.request(m.manager, 0, wlArgs{}.uint32(frame).int32(1).uint32(m.output)); err != nil {
+		return err
+	}
+	defer m.conn.request(frame, 1, wlArgs{})
+
+	var width, height, stride, format uint32
+	var offered, copied, yInvert bool
+	for {
+		event, err := m.conn.next()
+		if err != nil {
+			return fmt.Errorf("read from the compositor: %w", err)
+		}
+		if event.Object != frame {
+			continue
+		}
+		switch event.Opcode {
+		case 0: // buffer
+			f, w, h, s := event.Args.uint32(), event.Args.uint32(), event.Args.uint32(), event.Args.uint32()
+			if !offered && mirrorFormat(f) {
+				format, width, height, stride, offered = f, w, h, s, true
+			}
+			// Before version 3 there is one buffer event and no buffer_done
+			if m.managerVersion < 3 {
+				if err := m.copy(frame, &copied, offered, width, height, stride, format); err != nil {
+					return err
+				}
+			}
+		case 1: // flags
+			yInvert = event.Args.uint32()&1 != 0
+		case 2: // ready
+			m.convert(yInvert)
+			return nil
+		case 3: // failed
+			return &frameFailedError{}
+		case 6: // buffer_done
+			if err := m.copy(frame, &copied, offered, width, height, stride, format); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// copy asks the compositor to copy frame into the shared buffer, making
+// one of the right size first
+func (m *Mirror) copy(frame uint32, copied *bool, offered bool, width, height, stride, format uint32) error {
+	if *copied {
+		return nil
+	}
+	if !offered {
+		return fmt.Errorf("the compositor offers no shared memory format the mirror can read")
+	}
+	if err := m.ensureBuffer(width, height, stride, format); err != nil {
+		return err
+	}
+	*copied = true
+	return m.conn.request(frame, 0, wlArgs{}.uint32(m.buffer))
+}
+
+// ensureBuffer makes the shared buffer the given size and format
+func (m *Mirror) ensureBuffer(width, height, stride, format uint32) error {
+	if m.data != nil && m.width == width && m.height == height && m.stride == stride && m.format == format {
+		return nil
+	}
+	m.releaseBuffer()
+
+	size := int(stride * height)
+	file, err := os.CreateTemp(os.Getenv("XDG_RUNTIME_DIR"), "mirror-*")
+	if err != nil {
+		return fmt.Errorf("create shared memory: %w", err)
+	}
+	os.Remove(file.Name())
+	if err := file.Truncate(int64(size)); err != nil {
+		file.Close()
+		return fmt.Errorf("size shared memory: %w", err)
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("map shared memory: %w", err)
+	}
+	m.file, m.data = file, data
+	m.width, m.height, m.stride, m.format = width, height, stride, format
+
+	m.pool = m.conn.newID()
+	if err := m.conn.request(m.shm, 0, wlArgs{}.uint32(m.pool).int32(int32(size)), int(file.Fd())); err != nil {
+		return err
+	}
+	m.buffer = m.conn.newID()
+	args := wlArgs{}.uint32(m.buffer).int32(0).int32(int32(width)).int32(int32(height)).int32(int32(stride)).uint32(format)
+	return m.conn.request(m.pool, 0, args)
+}
+
+// releaseBuffer destroys the shared buffer, if there is one
+func (m *Mirror) releaseBuffer() {
+	if m.data == nil {
+		return
+	}
+	m.conn.request(m.buffer, 0, wlArgs{})
+	m.conn.request(m.pool, 1, wlArgs{})
+	syscall.Munmap(m.data)
+	m.file.Close()
+	m.data, m.file = nil, nil
+}
+
+// mirrorFormat reports whether the mirror can convert format to RGBA
+func mirrorFormat(format uint32) bool {
+	switch format {
+	case shmARGB8888, shmXRGB8888, shmABGR8888, shmXBGR8888:
+		return true
+	}
+	return false
+}
+
+// convert converts the shared buffer into the oldest texture not in use
+// and makes it the front one
+func (m *Mirror) convert(yInvert bool) {
+	back := m.back(m.width, m.height)
+	convertShm(back.Data, m.data, int(m.width), int(m.height), int(m.stride), m.format, yInvert)
+	m.publish(back)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = nil
+}
+
+// convertShm converts a wl_shm buffer to tightly packed RGBA, flipping it
+// when yInvert
+func convertShm(dst, src []byte, width, height, stride int, format uint32, yInvert bool) {
+	swap := format == shmARGB8888 || format == shmXRGB8888
+	opaque := format == shmXRGB8888 || format == shmXBGR8888
+	for y := range height {
+		row := src[y*stride:]
+		if yInvert {
+			row = src[(height-1-y)*stride:]
+		}
+		out := dst[y*width*4:]
+		for x := range width {
+			p, q := row[x*4:x*4+4], out[x*4:x*4+4]
+			if swap {
+				q[0], q[1], q[2], q[3] = p[2], p[1], p[0], p[3]
+			} else {
+				copy(q, p)
+			}
+			if opaque {
+				q[3] = 255
+			}
+		}
+	}
+}
+
+// Err returns why the last capture failed, nil after one succeeds
+func (m *Mirror) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
@@ -0,0 +1,150 @@
+package compositor
+
+import (
+	"encoding/binary"
+	"image"
+	"net"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeScreencopy is a compositor that offers wlr-screencopy and answers
+// every capture with a two pixel XRGB frame, red then blue
+type fakeScreencopy struct {
+	t    *testing.T
+	conn *net.UnixConn
+	ids  map[string]uint32
+	// memory is the client's shared memory pool
+	memory []byte
+}
+
+func (f *fakeScreencopy) send(object uint32, opcode uint16, args wlArgs) {
+	message := make([]byte, 8)
+	binary.LittleEndian.PutUint32(message[0:4], object)
+	binary.LittleEndian.PutUint32(message[4:8], uint32(8+len(args))<<16|uint32(opcode))
+	f.conn.Write(append(message, args...))
+}
+
+func (f *fakeScreencopy) serve() {
+	var pending []byte
+	var fds []int
+	registry := uint32(0)
+	globals := []string{"wl_shm", "wl_output", "zwlr_screencopy_manager_v1"}
+	for {
+		buf := make([]byte, 4096)
+		oob := make([]byte, syscall.CmsgSpace(4*4))
+		n, oobn, _, _, err := f.conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return
+		}
+		if messages, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+			for _, m := range messages {
+				received, _ := syscall.ParseUnixRights(&m)
+				fds = append(fds, received...)
+			}
+		}
+		pending = append(pending, buf[:n]...)
+		for len(pending) >= 8 {
+			size := int(binary.LittleEndian.Uint32(pending[4:8]) >> 16)
+			if len(pending) < size {
+				break
+			}
+			object := binary.LittleEndian.Uint32(pending[0:4])
+			opcode := uint16(binary.LittleEndian.Uint32(pending[4:8]))
+			args := wlReader(pending[8:size])
+			pending = pending[size:]
+
+			switch {
+			case object == wlDisplayID && opcode == 0: // sync
+				f.send(args.uint32(), 0, wlArgs{}.uint32(0))
+			case object == wlDisplayID && opcode == 1: // get_registry
+				registry = args.uint32()
+				for i, iface := range globals {
+					f.send(registry, 0, wlArgs{}.uint32(uint32(i+1)).string(iface).uint32(3))
+				}
+			case object == registry && opcode == 0: // bind
+				args.uint32()
+				iface := args.string()
+				args.uint32()
+				f.ids[iface] = args.uint32()
+			case object == f.ids["wl_shm"] && opcode == 0: // create_pool
+				f.ids["pool"] = args.uint32()
+				poolSize := int(args.uint32())
+				memory, err := syscall.Mmap(fds[0], 0, poolSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+				if err != nil {
+					f.t.Errorf("map the pool: %v", err)
+					return
+				}
+				f.memory, fds = memory, fds[1:]
+			case object == f.ids["zwlr_screencopy_manager_v1"] && opcode == 0: // capture_output
+				frame := args.uint32()
+				f.ids["frame"] = frame
+				f.send(frame, 0, wlArgs{}.uint32(shmXRGB8888).uint32(2).uint32(1).uint32(8))
+				f.send(frame, 6, wlArgs{})
+			case object == f.ids["frame"] && opcode == 0: // copy
+				// Little endian XRGB: blue, green, red, unused
+				copy(f.memory, []byte{0, 0, 255, 0, 255, 0, 0, 0})
+				f.send(object, 1, wlArgs{}.uint32(0))
+				f.send(object, 2, wlArgs{}.uint32(0).uint32(0).uint32(0))
+			}
+		}
+	}
+}
+
+func TestMirrorCapturesFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wayland-test")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.AcceptUnix()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		(&fakeScreencopy{t: t, conn: conn, ids: make(map[string]uint32)}).serve()
+	}()
+
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	m, err := NewMirror(path, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var placed []PlacedSurface
+	for len(placed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no frame captured (%v)", m.Err())
+		}
+		time.Sleep(time.Millisecond)
+		placed = m.Placed(image.Rect(0, 0, 100, 100))
+	}
+
+	p := placed[0]
+	if p.X != 0 || p.Y != 25 || p.Size != image.Pt(100, 50) {
+		t.Errorf("placed at %d,%d size %v, want fitted at 0,25 size 100x50", p.X, p.Y, p.Size)
+	}
+	if got := p.Texture.Data; string(got) != string([]byte{255, 0, 0, 255, 0, 0, 255, 255}) {
+		t.Errorf("pixels %v, want opaque red then blue", got)
+	}
+}
+
+func TestConvertShm(t *testing.T) {
+	// Two rows of one ABGR pixel each, flipped
+	src := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	dst := make([]byte, 8)
+	convertShm(dst, src, 1, 2, 4, shmABGR8888, true)
+	if string(dst) != string([]byte{5, 6, 7, 8, 1, 2, 3, 4}) {
+		t.Errorf("flipped %v", dst)
+	}
+	convertShm(dst, src, 1, 2, 4, shmARGB8888, false)
+	if string(dst) != string([]byte{3, 2, 1, 4, 7, 6, 5, 8}) {
+		t.Errorf("swapped %v", dst)
+	}
+}
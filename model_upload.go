@@ -0,0 +1,121 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/qmuntal/gltf"
+)
+
+// modelUploadLimit bounds the size of a model uploaded to /api/v1/model
+const modelUploadLimit = 256 << 20
+
+// saveUploadedModel writes a model uploaded in format, a model file
+// extension such as "glb", to a temporary file and parses it. The file is
+// kept, so the preview can load it again when it is rebuilt; the caller
+// removes it once it is replaced.
+func saveUploadedModel(data []byte, format string) (string, *gltf.Document, error) {
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+	if !render.IsModelFile("model." + format) {
+		return "", nil, fmt.Errorf("unsupported model format %q, want glb, gltf, obj or stl", format)
+	}
+	f, err := os.CreateTemp("", "uploaded-model-*."+format)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	doc, err := render.OpenModel(f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), doc, nil
+}
+
+// readModelRequest parses the model of a POST /api/v1/model: a JSON body
+// {"path": ...} names a builtin model or a model file in dir, any other
+// body is the model itself in the ?format= given (glb by default).
+// uploaded reports that path is a temporary file holding an upload.
+func readModelRequest(r *http.Request, dir string) (path string, doc *gltf.Document, uploaded bool, err error) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		req := struct {
+			Path string `json:"path"`
+		}{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, controlBodyLimit)).Decode(&req); err != nil {
+			return "", nil, false, badRequest("invalid JSON body: %v", err)
+		}
+		if req.Path == "" {
+			return "", nil, false, badRequest("path is required")
+		}
+		path, err := modelDirPath(req.Path, dir)
+		if err != nil {
+			return "", nil, false, err
+		}
+		doc, err := render.OpenModel(path)
+		if err != nil {
+			return "", nil, false, badRequest("%v", err)
+		}
+		return path, doc, false, nil
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "glb"
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, modelUploadLimit+1))
+	if err != nil {
+		return "", nil, false, badRequest("failed to read model: %v", err)
+	}
+	if len(data) > modelUploadLimit {
+		return "", nil, false, &controlError{http.StatusRequestEntityTooLarge, fmt.Sprintf("models are limited to %d MiB", modelUploadLimit>>20)}
+	}
+	path, doc, err = saveUploadedModel(data, format)
+	if err != nil {
+		return "", nil, false, badRequest("%v", err)
+	}
+	return path, doc, true, nil
+}
+
+// modelDirPath resolves a model path named in a request: builtin models
+// as they are, files by a path inside dir, relative to it or absolute.
+// Links are followed first, so one in dir cannot lead out of it. Without
+// a dir no file can be named.
+func modelDirPath(path, dir string) (string, error) {
+	if strings.HasPrefix(path, render.BuiltinModelPrefix) {
+		return path, nil
+	}
+	if dir == "" {
+		return "", &controlError{http.StatusForbidden, "models can only be opened by path from -model-dir; upload the file instead"}
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", badRequest("%v", err)
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", &controlError{http.StatusForbidden, fmt.Sprintf("%s is outside -model-dir", path)}
+	}
+	return resolved, nil
+}
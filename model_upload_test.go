@@ -0,0 +1,76 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// triangleOBJ is the smallest OBJ model
+const triangleOBJ = "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"
+
+func TestReadModelRequest(t *testing.T) {
+	upload := httptest.NewRequest("POST", "/api/v1/model?format=obj", strings.NewReader(triangleOBJ))
+	path, doc, uploaded, err := readModelRequest(upload, "")
+	if err != nil || !uploaded || doc == nil {
+		t.Fatalf("upload: %v, uploaded %v", err, uploaded)
+	}
+	defer os.Remove(path)
+	if filepath.Ext(path) != ".obj" {
+		t.Errorf("upload saved as %s", path)
+	}
+
+	named := httptest.NewRequest("POST", "/api/v1/model", strings.NewReader(`{"path": "builtin:cube"}`))
+	named.Header.Set("Content-Type", "application/json")
+	if path, doc, uploaded, err := readModelRequest(named, ""); err != nil || uploaded || doc == nil || path != "builtin:cube" {
+		t.Errorf("path: %q, %v, uploaded %v", path, err, uploaded)
+	}
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest("POST", "/api/v1/model?format=fbx", bytes.NewReader([]byte("x"))),
+		httptest.NewRequest("POST", "/api/v1/model", bytes.NewReader([]byte("not a glb"))),
+	} {
+		if _, _, _, err := readModelRequest(r, ""); err == nil {
+			t.Errorf("%s: expected an error", r.URL)
+		}
+	}
+}
+
+func TestReadModelRequestDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "tri.obj"), []byte(triangleOBJ), 0o644)
+	outside := filepath.Join(t.TempDir(), "outside.obj")
+	os.WriteFile(outside, []byte(triangleOBJ), 0o644)
+	os.Symlink(outside, filepath.Join(dir, "link.obj"))
+	named := func(path string) *http.Request {
+		r := httptest.NewRequest("POST", "/api/v1/model", strings.NewReader(`{"path": "`+path+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		return r
+	}
+
+	for _, path := range []string{"tri.obj", filepath.Join(dir, "tri.obj")} {
+		got, doc, _, err := readModelRequest(named(path), dir)
+		if err != nil || doc == nil || filepath.Base(got) != "tri.obj" {
+			t.Errorf("%s: %q, %v", path, got, err)
+		}
+	}
+
+	var ce *controlError
+	for _, c := range []struct{ path, dir string }{
+		{filepath.Join(dir, "tri.obj"), ""},
+		{outside, dir},
+		{"../" + filepath.Base(filepath.Dir(outside)) + "/outside.obj", dir},
+		{"link.obj", dir},
+	} {
+		if _, _, _, err := readModelRequest(named(c.path), c.dir); !errors.As(err, &ce) || ce.status != http.StatusForbidden {
+			t.Errorf("%s in %q: expected 403, got %v", c.path, c.dir, err)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package compositor
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types, in the high nibble of the first byte
+const (
+	mqttConnect   = 1
+	mqttConnAck   = 2
+	mqttPublish   = 3
+	mqttSubscribe = 8
+	mqttSubAck    = 9
+	mqttPingReq   = 12
+	mqttPingResp  = 13
+)
+
+const (
+	mqttKeepAlive    = 60 * time.Second
+	mqttDialTimeout  = 10 * time.Second
+	mqttMaxBackoff   = 30 * time.Second
+	mqttMaxPacketLen = 1 << 20
+)
+
+// MQTTSubscriber subscribes to one topic on an MQTT 3.1.1 broker and hands
+// each message to Handler, reconnecting when the connection drops. It asks
+// for QoS 0, so it never has to acknowledge anything.
+type MQTTSubscriber struct {
+	// Broker is mqtt://[user:password@]host[:port], or mqtts:// for TLS
+	Broker   *url.URL
+	Topic    string
+	ClientID string
+	Handler  func(topic string, payload []byte)
+}
+
+// NewMQTTSubscriber parses the broker URL
+func NewMQTTSubscriber(broker, topic string, handler func(topic string, payload []byte)) (*MQTTSubscriber, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("parse MQTT broker: %w", err)
+	}
+	if u.Scheme != "mqtt" && u.Scheme != "mqtts" {
+		return nil, fmt.Errorf("MQTT broker must be an mqtt:// or mqtts:// URL, got %q", broker)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("MQTT broker %q has no host", broker)
+	}
+	if topic == "" {
+		return nil, errors.New("MQTT topic is empty")
+	}
+	return &MQTTSubscriber{
+		Broker:   u,
+		Topic:    topic,
+		ClientID: fmt.Sprintf("wayland-compositor-%d", time.Now().UnixNano()%1e9),
+		Handler:  handler,
+	}, nil
+}
+
+// Run keeps the subscription up until the process exits, backing off
+// between failed connections
+func (m *MQTTSubscriber) Run() {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := m.session()
+		if time.Since(start) > mqttMaxBackoff {
+			backoff = time.Second
+		}
+		log.Printf("MQTT connection to %s lost: %v; retrying in %v", m.Broker.Host, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, mqttMaxBackoff)
+	}
+}
+
+// dial connects to the broker, with TLS for mqtts
+func (m *MQTTSubscriber) dial() (net.Conn, error) {
+	host := m.Broker.Host
+	if m.Broker.Port() == "" {
+		port := "1883"
+		if m.Broker.Scheme == "mqtts" {
+			port = "8883"
+		}
+		host = net.JoinHostPort(m.Broker.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: mqttDialTimeout}
+	if m.Broker.Scheme == "mqtts" {
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: m.Broker.Hostname()})
+	}
+	return dialer.Dial("tcp", host)
+}
+
+// session runs one connection: connect, subscribe, then read messages and
+// ping the broker until something fails
+func (m *MQTTSubscriber) session() error {
+	conn, err := m.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return m.serve(conn)
+}
+
+// serve speaks MQTT over an established connection
+func (m *MQTTSubscriber) serve(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+
+	if err := writeMQTTPacket(conn, mqttConnect<<4, m.connectBody()); err != nil {
+		return fmt.Errorf("send CONNECT: %w", err)
+	}
+	header, body, err := readMQTTPacket(r)
+	if err != nil {
+		return fmt.Errorf("read CONNACK: %w", err)
+	}
+	if header>>4 != mqttConnAck || len(body) < 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", header>>4)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("broker refused the connection (code %d)", body[1])
+	}
+
+	// Packet identifier 1, then the topic filter at QoS 0
+	subscribe := appendMQTTString([]byte{0, 1}, m.Topic)
+	subscribe = append(subscribe, 0)
+	if err := writeMQTTPacket(conn, mqttSubscribe<<4|0x02, subscribe); err != nil {
+		return fmt.Errorf("send SUBSCRIBE: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	log.Printf("MQTT subscribed to %q on %s", m.Topic, m.Broker.Host)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(mqttKeepAlive / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := writeMQTTPacket(conn, mqttPingReq<<4, nil); err != nil {
+					conn.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+		header, body, err := readMQTTPacket(r)
+		if err != nil {
+			return err
+		}
+		switch header >> 4 {
+		case mqttSubAck:
+			if len(body) >= 3 && body[2] == 0x80 {
+				return fmt.Errorf("broker refused the subscription to %q", m.Topic)
+			}
+		case mqttPublish:
+			topic, payload, err := parseMQTTPublish(header, body)
+			if err != nil {
+				return err
+			}
+			m.Handler(topic, payload)
+		case mqttPingResp:
+		}
+	}
+}
+
+// connectBody is a clean-session CONNECT with the URL's credentials
+func (m *MQTTSubscriber) connectBody() []byte {
+	body := appendMQTTString(nil, "MQTT")
+	flags := byte(0x02) // clean session
+	user := m.Broker.User
+	if user != nil {
+		flags |= 0x80
+		if _, ok := user.Password(); ok {
+			flags |= 0x40
+		}
+	}
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = appendMQTTString(body, m.ClientID)
+	if user != nil {
+		body = appendMQTTString(body, user.Username())
+		if password, ok := user.Password(); ok {
+			body = appendMQTTString(body, password)
+		}
+	}
+	return body
+}
+
+// parseMQTTPublish splits a PUBLISH packet into its topic and payload
+func parseMQTTPublish(header byte, body []byte) (topic string, payload []byte, err error) {
+	if len(body) < 2 {
+		return "", nil, errors.New("short PUBLISH")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+n {
+		return "", nil, errors.New("short PUBLISH topic")
+	}
+	topic, rest := string(body[2:2+n]), body[2+n:]
+	if qos := header >> 1 & 0x03; qos > 0 {
+		// A packet identifier follows the topic above QoS 0
+		if len(rest) < 2 {
+			return "", nil, errors.New("short PUBLISH packet identifier")
+		}
+		rest = rest[2:]
+	}
+	return topic, rest, nil
+}
+
+// appendMQTTString appends a length-prefixed UTF-8 string
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// writeMQTTPacket writes a fixed header, the remaining length and the body
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	for n := len(body); ; {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+// readMQTTPacket reads one packet, returning its first byte and body
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed MQTT remaining length")
+		}
+		multiplier *= 128
+	}
+	if length > mqttMaxPacketLen {
+		return 0, nil, fmt.Errorf("MQTT packet of %d bytes is too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,216 @@
+package compositor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// Banners fade in and out over notificationFade, at most
+// notificationsShown show at once, the oldest going first, and they sit
+// notificationMargin pixels from the edge and from each other
+const (
+	notificationFade   = 250 * time.Millisecond
+	notificationsShown = 5
+	notificationMargin = 8
+	notificationScale  = 2
+)
+
+var (
+	notificationText       = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	notificationBackground = color.RGBA{A: 0xc0}
+)
+
+// Notification is a text banner flashed over the desktop
+type Notification struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+	// Anchor is the edge or corner the banner shows in, e.g. "top-right"
+	Anchor     string        `json:"anchor"`
+	Posted     time.Time     `json:"posted"`
+	Duration   time.Duration `json:"-"`
+	DurationMS int64         `json:"duration_ms"`
+}
+
+// banner is a notification and its drawing, and the faded copy drawn
+// last with its alpha
+type banner struct {
+	Notification
+	image      *image.RGBA
+	opaque     *wayland.Texture
+	faded      *wayland.Texture
+	fadedAlpha uint8
+}
+
+// Notifications flashes banners such as "Recording started" over the
+// desktop, in the stream, fading each in and out. Banners at the same
+// anchor stack away from its edge in the order they were posted.
+type Notifications struct {
+	// Wake, if set, is called when a banner is posted, so the render loop
+	// draws it
+	Wake func()
+
+	mu       sync.Mutex
+	anchor   string
+	duration time.Duration
+	banners  []*banner
+	nextID   int
+	surface  *wayland.WlSurface
+}
+
+// NewNotifications creates banners shown at anchor for duration unless
+// posted with their own
+func NewNotifications(anchor string, duration time.Duration) (*Notifications, error) {
+	n := &Notifications{surface: &wayland.WlSurface{}}
+	if err := n.SetDefaults(anchor, duration); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// SetDefaults sets the anchor and duration of banners posted without
+// their own
+func (n *Notifications) SetDefaults(anchor string, duration time.Duration) error {
+	if _, ok := widgetAnchors[anchor]; !ok {
+		return fmt.Errorf("unknown anchor %q", anchor)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.anchor, n.duration = anchor, duration
+	return nil
+}
+
+// Post shows text from now, at anchor for duration, or the defaults where
+// they are empty or zero. Posting to nil Notifications does nothing.
+func (n *Notifications) Post(text, anchor string, duration time.Duration, now time.Time) (Notification, error) {
+	if n == nil {
+		return Notification{}, nil
+	}
+	if text == "" {
+		return Notification{}, fmt.Errorf("no text")
+	}
+	if _, ok := widgetAnchors[anchor]; anchor != "" && !ok {
+		return Notification{}, fmt.Errorf("unknown anchor %q", anchor)
+	}
+	if duration < 0 {
+		return Notification{}, fmt.Errorf("duration must not be negative")
+	}
+
+	n.mu.Lock()
+	if anchor == "" {
+		anchor = n.anchor
+	}
+	if duration == 0 {
+		duration = n.duration
+	}
+	n.nextID++
+	b := &banner{
+		Notification: Notification{ID: n.nextID, Text: text, Anchor: anchor, Posted: now, Duration: duration, DurationMS: duration.Milliseconds()},
+		image:        renderWidget(text, notificationScale, notificationText, notificationBackground),
+	}
+	n.banners = append(n.banners, b)
+	if len(n.banners) > notificationsShown {
+		n.banners = n.banners[len(n.banners)-notificationsShown:]
+	}
+	n.mu.Unlock()
+
+	if n.Wake != nil {
+		n.Wake()
+	}
+	return b.Notification, nil
+}
+
+// Showing returns the banners not yet faded out at now
+func (n *Notifications) Showing(now time.Time) []Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.expire(now)
+	showing := make([]Notification, len(n.banners))
+	for i, b := range n.banners {
+		showing[i] = b.Notification
+	}
+	return showing
+}
+
+// expire drops the banners that have faded out. Call it with n.mu held.
+func (n *Notifications) expire(now time.Time) {
+	kept := n.banners[:0]
+	for _, b := range n.banners {
+		if now.Before(b.Posted.Add(b.Duration)) {
+			kept = append(kept, b)
+		}
+	}
+	clear(n.banners[len(kept):])
+	n.banners = kept
+}
+
+// Place returns the banners showing at now as surfaces within bounds.
+// While one is fading the desktop changes every frame.
+func (n *Notifications) Place(bounds image.Rectangle, now time.Time) []PlacedSurface {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.expire(now)
+	var placed []PlacedSurface
+	stacked := make(map[string]int)
+	for i, b := range n.banners {
+		texture := b.texture(now)
+		size := image.Pt(int(texture.Width), int(texture.Height))
+		rect := anchorRect(b.Anchor, size, bounds.Inset(notificationMargin), stacked[b.Anchor])
+		stacked[b.Anchor] += size.Y + notificationMargin/2
+		p := PlacedSurface{
+			SurfaceID: protocols.ObjectID[protocols.WlSurface](i + 1),
+			Surface:   n.surface,
+			Texture:   texture,
+			X:         rect.Min.X,
+			Y:         rect.Min.Y,
+			Source:    image.Rect(0, 0, size.X, size.Y),
+			Size:      size,
+		}
+		p.Root = p.SurfaceID
+		placed = append(placed, p)
+	}
+	return placed
+}
+
+// alpha is how opaque the banner is at now, fading in after it is posted
+// and out before it ends
+func (b *banner) alpha(now time.Time) float64 {
+	elapsed := now.Sub(b.Posted)
+	remaining := b.Posted.Add(b.Duration).Sub(now)
+	fade := min(notificationFade, b.Duration/2)
+	if fade <= 0 {
+		return 1
+	}
+	return max(0, min(1, float64(elapsed)/float64(fade), float64(remaining)/float64(fade)))
+}
+
+// texture returns the banner drawn with its alpha at now, only drawing it
+// again when the alpha has changed
+func (b *banner) texture(now time.Time) *wayland.Texture {
+	img := b.image
+	alpha := uint8(b.alpha(now) * 255)
+	if alpha == 255 {
+		if b.opaque == nil {
+			b.opaque = &wayland.Texture{Stride: uint32(img.Stride), Width: uint32(img.Rect.Dx()), Height: uint32(img.Rect.Dy()), Data: img.Pix}
+		}
+		return b.opaque
+	}
+	if b.faded == nil || b.fadedAlpha != alpha {
+		// Premultiplied, so every channel scales with the alpha
+		pix := make([]byte, len(img.Pix))
+		for i, v := range img.Pix {
+			pix[i] = uint8(uint32(v) * uint32(alpha) / 255)
+		}
+		b.faded = &wayland.Texture{Stride: uint32(img.Stride), Width: uint32(img.Rect.Dx()), Height: uint32(img.Rect.Dy()), Data: pix}
+		b.fadedAlpha = alpha
+	}
+	return b.faded
+}
@@ -0,0 +1,77 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func TestNotifications(t *testing.T) {
+	if _, err := NewNotifications("middle", time.Second); err == nil {
+		t.Error("Expected an error for an unknown anchor")
+	}
+	n, err := NewNotifications("top-right", 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	woken := 0
+	n.Wake = func() { woken++ }
+	now := time.Unix(1000, 0)
+	bounds := image.Rect(0, 0, 800, 600)
+
+	if _, err := n.Post("", "", 0, now); err == nil {
+		t.Error("Expected an error for a banner without text")
+	}
+	first, err := n.Post("Recording started", "", 0, now)
+	if err != nil || first.Anchor != "top-right" || first.Duration != 2*time.Second {
+		t.Fatalf("Expected the default anchor and duration, got %+v, %v", first, err)
+	}
+	if _, err := n.Post("Viewer 2 took control", "", time.Second, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.Post("Hello", "bottom", 0, now); err != nil {
+		t.Fatal(err)
+	}
+	if woken != 3 {
+		t.Errorf("Expected 3 wakes, got %d", woken)
+	}
+
+	placed := n.Place(bounds, now.Add(500*time.Millisecond))
+	if len(placed) != 3 {
+		t.Fatalf("Expected 3 banners, got %d", len(placed))
+	}
+	top, stacked, bottom := placed[0], placed[1], placed[2]
+	if top.X+top.Size.X != 800-notificationMargin || top.Y != notificationMargin {
+		t.Errorf("Expected the first banner in the top right corner, got %v at %d,%d", top.Size, top.X, top.Y)
+	}
+	if stacked.Y <= top.Y {
+		t.Errorf("Expected the second banner below the first, got y %d and %d", stacked.Y, top.Y)
+	}
+	if bottom.Y+bottom.Size.Y != 600-notificationMargin {
+		t.Errorf("Expected the third banner at the bottom, got y %d", bottom.Y)
+	}
+
+	// Fading in, the banner is drawn with part of its alpha
+	placed = n.Place(bounds, now.Add(notificationFade/2))
+	opaque := n.Place(bounds, now.Add(time.Second/2))[0].Texture
+	faded := placed[0].Texture
+	if faded == opaque || faded.Data[3] >= opaque.Data[3] || faded.Data[3] == 0 {
+		t.Errorf("Expected a half faded banner, got alpha %d of %d", faded.Data[3], opaque.Data[3])
+	}
+
+	// The one second banner has gone
+	showing := n.Showing(now.Add(1500 * time.Millisecond))
+	if len(showing) != 2 || showing[0].Text != "Recording started" || showing[1].Text != "Hello" {
+		t.Errorf("Expected two banners left, got %+v", showing)
+	}
+	if placed := n.Place(bounds, now.Add(2*time.Second)); len(placed) != 0 {
+		t.Errorf("Expected every banner gone, got %d", len(placed))
+	}
+
+	for i := range notificationsShown + 2 {
+		n.Post("Banner", "", 0, now.Add(time.Duration(i)))
+	}
+	if showing := n.Showing(now); len(showing) != notificationsShown {
+		t.Errorf("Expected %d banners, got %d", notificationsShown, len(showing))
+	}
+}
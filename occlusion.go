@@ -0,0 +1,155 @@
+package compositor
+
+import (
+	"image"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+type opacityEntry struct {
+	source *wayland.Texture
+	frame  uint64
+	opaque bool
+}
+
+// VisibilityTracker works out which surfaces are completely hidden, either
+// behind opaque surfaces stacked above them or outside the desktop.
+// wl_region is not implemented by the wayland package, so opacity is
+// derived from the buffer's alpha channel and cached while the same buffer
+// is placed: client buffers are placed as copies, a new one whenever the
+// pixels change.
+type VisibilityTracker struct {
+	Bounds image.Rectangle
+
+	opacity map[surfaceKey]opacityEntry
+}
+
+// NewVisibilityTracker creates a tracker for a width x height desktop
+func NewVisibilityTracker(width, height int) *VisibilityTracker {
+	return &VisibilityTracker{
+		Bounds:  image.Rect(0, 0, width, height),
+		opacity: make(map[surfaceKey]opacityEntry),
+	}
+}
+
+// Cull splits placed surfaces (bottom first) into visible and hidden ones,
+// both kept in draw order.
+func (v *VisibilityTracker) Cull(placed []PlacedSurface) (visible, hidden []PlacedSurface) {
+	hiddenAt := make([]bool, len(placed))
+	var covering []image.Rectangle
+
+	seen := make(map[surfaceKey]bool, len(placed))
+	for i := len(placed) - 1; i >= 0; i-- {
+		p := placed[i]
+		key := surfaceKey{client: p.Client, id: p.SurfaceID}
+		seen[key] = true
+
+		// Only the part on the desktop matters; fully off-desktop
+		// surfaces end up empty and are hidden.
+		rect := image.Rect(p.X, p.Y, p.X+p.Width(), p.Y+p.Height()).Intersect(v.Bounds)
+		if rect.Empty() || coveredBy(rect, covering) {
+			hiddenAt[i] = true
+			continue
+		}
+		if v.isOpaque(key, p) {
+			covering = append(covering, rect)
+		}
+	}
+
+	for key := range v.opacity {
+		if !seen[key] {
+			delete(v.opacity, key)
+		}
+	}
+
+	for i, p := range placed {
+		if hiddenAt[i] {
+			hidden = append(hidden, p)
+		} else {
+			visible = append(visible, p)
+		}
+	}
+	return visible, hidden
+}
+
+// hiddenClients returns the clients that have surfaces on the desktop but
+// none of them visible
+func hiddenClients(visible, hidden []PlacedSurface) map[*wayland.Client]bool {
+	result := make(map[*wayland.Client]bool)
+	for _, p := range hidden {
+		result[p.Client] = true
+	}
+	for _, p := range visible {
+		delete(result, p.Client)
+	}
+	return result
+}
+
+func (v *VisibilityTracker) isOpaque(key surfaceKey, p PlacedSurface) bool {
+	entry, ok := v.opacity[key]
+	if ok && entry.source == p.Texture && entry.frame == p.Frame {
+		return entry.opaque
+	}
+	entry = opacityEntry{source: p.Texture, frame: p.Frame, opaque: textureIsOpaque(p.Texture)}
+	v.opacity[key] = entry
+	return entry.opaque
+}
+
+// textureIsOpaque reports whether every pixel of the buffer has full alpha
+func textureIsOpaque(t *wayland.Texture) bool {
+	if t == nil || t.Width == 0 || t.Height == 0 {
+		return false
+	}
+	rowBytes := int(t.Width) * 4
+	for y := 0; y < int(t.Height); y++ {
+		row := t.Data[y*int(t.Stride):]
+		if len(row) < rowBytes {
+			return false
+		}
+		for x := 3; x < rowBytes; x += 4 {
+			if row[x] != 0xff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// coveredBy reports whether rect is entirely inside the union of covers
+func coveredBy(rect image.Rectangle, covers []image.Rectangle) bool {
+	remaining := []image.Rectangle{rect}
+	for _, cover := range covers {
+		next := remaining[:0:0]
+		for _, r := range remaining {
+			next = append(next, subtractRect(r, cover)...)
+		}
+		remaining = next
+		if len(remaining) == 0 {
+			return true
+		}
+	}
+	return rect.Empty()
+}
+
+// subtractRect returns the parts of r not covered by cut, as at most four
+// non-overlapping rectangles
+func subtractRect(r, cut image.Rectangle) []image.Rectangle {
+	inter := r.Intersect(cut)
+	if inter.Empty() {
+		return []image.Rectangle{r}
+	}
+	var parts []image.Rectangle
+	if inter.Min.Y > r.Min.Y {
+		parts = append(parts, image.Rect(r.Min.X, r.Min.Y, r.Max.X, inter.Min.Y))
+	}
+	if inter.Max.Y < r.Max.Y {
+		parts = append(parts, image.Rect(r.Min.X, inter.Max.Y, r.Max.X, r.Max.Y))
+	}
+	if inter.Min.X > r.Min.X {
+		parts = append(parts, image.Rect(r.Min.X, inter.Min.Y, inter.Min.X, inter.Max.Y))
+	}
+	if inter.Max.X < r.Max.X {
+		parts = append(parts, image.Rect(inter.Max.X, inter.Min.Y, r.Max.X, inter.Max.Y))
+	}
+	return parts
+}
@@ -0,0 +1,77 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func fillOpaque(s *wayland.WlSurface) {
+	for i := 3; i < len(s.Texture.Data); i += 4 {
+		s.Texture.Data[i] = 0xff
+	}
+}
+
+func TestCoveredBy(t *testing.T) {
+	rect := image.Rect(0, 0, 100, 100)
+	halves := []image.Rectangle{image.Rect(0, 0, 50, 100), image.Rect(50, 0, 100, 100)}
+	if !coveredBy(rect, halves) {
+		t.Error("Rect should be covered by the union of its two halves")
+	}
+	if coveredBy(rect, halves[:1]) {
+		t.Error("Rect should not be covered by one half")
+	}
+	if coveredBy(rect, nil) {
+		t.Error("Rect should not be covered by nothing")
+	}
+}
+
+func TestVisibilityTrackerCull(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	addTestSurface(c, 1, 10, 10, 0, 50, 50)             // under the opaque window
+	addTestSurface(c, 2, 0, 0, 1, 100, 100)             // transparent, hides nothing
+	fillOpaque(addTestSurface(c, 3, 0, 0, 2, 100, 100)) // opaque
+	addTestSurface(c, 4, 90, 90, 3, 20, 20)             // on top
+	addTestSurface(c, 5, 900, 10, 4, 20, 20)            // off the desktop
+
+	visible, hidden := NewVisibilityTracker(800, 600).Cull(NewSurfaceSnapshots().Collect([]*wayland.Client{c}, image.Rect(0, 0, 800, 600)))
+	if len(hidden) != 3 || hidden[0].SurfaceID != 1 || hidden[1].SurfaceID != 2 || hidden[2].SurfaceID != 5 {
+		t.Errorf("Expected surfaces 1, 2 and 5 hidden, got %v", hidden)
+	}
+	if len(visible) != 2 || visible[0].SurfaceID != 3 || visible[1].SurfaceID != 4 {
+		t.Errorf("Expected surfaces 3 and 4 visible, got %v", visible)
+	}
+}
+
+func TestVisibilityTrackerBufferChanges(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	addTestSurface(c, 1, 10, 10, 0, 50, 50)
+	top := addTestSurface(c, 2, 0, 0, 1, 100, 100)
+	fillOpaque(top)
+	snapshots := NewSurfaceSnapshots()
+	tracker := NewVisibilityTracker(800, 600)
+	bounds := image.Rect(0, 0, 800, 600)
+
+	if _, hidden := tracker.Cull(snapshots.Collect([]*wayland.Client{c}, bounds)); len(hidden) != 1 {
+		t.Fatalf("Expected the surface under the opaque window hidden, got %v", hidden)
+	}
+	// Nothing clears damage when frames are composited on the CPU, so the
+	// cache must notice the buffer changing by itself
+	top.Texture.Data[3] = 0
+	if _, hidden := tracker.Cull(snapshots.Collect([]*wayland.Client{c}, bounds)); len(hidden) != 0 {
+		t.Errorf("Expected nothing hidden once the window has a transparent pixel, got %v", hidden)
+	}
+}
+
+func TestHiddenClients(t *testing.T) {
+	covered := wayland.MakeClient(nil)
+	partly := wayland.MakeClient(nil)
+	visible := []PlacedSurface{{Client: partly}}
+	hidden := []PlacedSurface{{Client: covered}, {Client: partly}}
+
+	result := hiddenClients(visible, hidden)
+	if !result[covered] || result[partly] || len(result) != 1 {
+		t.Errorf("Only the fully covered client should be hidden, got %v", result)
+	}
+}
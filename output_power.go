@@ -0,0 +1,89 @@
+package compositor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Output power modes, as in DPMS
+const (
+	OutputPowerOn  = "on"
+	OutputPowerOff = "off"
+)
+
+// OutputPowerInfo describes the output's power for the control API
+type OutputPowerInfo struct {
+	Mode  string    `json:"mode"`
+	Since time.Time `json:"since"`
+}
+
+// parseOutputPowerMode reads a DPMS mode. Standby and suspend are off, a
+// virtual output having nothing to keep warm.
+func parseOutputPowerMode(mode string) (on bool, err error) {
+	switch mode {
+	case OutputPowerOn:
+		return true, nil
+	case OutputPowerOff, "standby", "suspend":
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown mode %q, have on, off, standby and suspend", mode)
+}
+
+// OutputPower is whether the virtual output is on. It is set from the
+// control API and picked up by the render loop, which while it is off
+// composites, draws and streams nothing and holds clients' frame
+// callbacks, as for a monitor in DPMS off, but keeps serving the clients.
+type OutputPower struct {
+	mu    sync.Mutex
+	on    bool
+	since time.Time
+	// changed is set until Poll reports the change
+	changed bool
+}
+
+// NewOutputPower starts with the output on
+func NewOutputPower(now time.Time) *OutputPower {
+	return &OutputPower{on: true, since: now}
+}
+
+// Set turns the output on or off
+func (p *OutputPower) Set(on bool, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if on == p.on {
+		return
+	}
+	p.on, p.since = on, now
+	p.changed = !p.changed
+}
+
+// On reports whether the output is on
+func (p *OutputPower) On() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.on
+}
+
+// Poll returns a change since the last Poll. Turning the output off and
+// on again in between is no change.
+func (p *OutputPower) Poll() (OutputPowerChangedEvent, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.changed {
+		return OutputPowerChangedEvent{}, false
+	}
+	p.changed = false
+	return OutputPowerChangedEvent{On: p.on, Time: p.since}, true
+}
+
+// Info describes the output's power
+func (p *OutputPower) Info() OutputPowerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mode := OutputPowerOff
+	if p.on {
+		mode = OutputPowerOn
+	}
+	return OutputPowerInfo{Mode: mode, Since: p.since}
+}
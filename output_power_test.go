@@ -0,0 +1,48 @@
+package compositor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutputPower(t *testing.T) {
+	start := time.Now()
+	p := NewOutputPower(start)
+	if !p.On() || p.Info().Mode != OutputPowerOn {
+		t.Fatalf("Expected the output on, got %+v", p.Info())
+	}
+	if _, ok := p.Poll(); ok {
+		t.Error("Expected no change to start with")
+	}
+
+	off := start.Add(time.Hour)
+	p.Set(false, off)
+	p.Set(false, off.Add(time.Minute))
+	if info := p.Info(); info.Mode != OutputPowerOff || !info.Since.Equal(off) {
+		t.Errorf("Expected the output off since it was turned off, got %+v", info)
+	}
+	if change, ok := p.Poll(); !ok || change.On || !change.Time.Equal(off) {
+		t.Errorf("Expected the output turned off, got %+v, %v", change, ok)
+	}
+	if _, ok := p.Poll(); ok {
+		t.Error("Expected a change reported once")
+	}
+
+	// Off and on again before the render loop looked is no change
+	p.Set(true, off.Add(2*time.Minute))
+	p.Set(false, off.Add(3*time.Minute))
+	if _, ok := p.Poll(); ok {
+		t.Error("Expected no change after turning the output on and off again")
+	}
+}
+
+func TestParseOutputPowerMode(t *testing.T) {
+	for mode, want := range map[string]bool{"on": true, "off": false, "standby": false, "suspend": false} {
+		if on, err := parseOutputPowerMode(mode); err != nil || on != want {
+			t.Errorf("%s: expected %v, got %v, %v", mode, want, on, err)
+		}
+	}
+	if _, err := parseOutputPowerMode("dim"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
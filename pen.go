@@ -0,0 +1,80 @@
+package compositor
+
+import (
+	"sync"
+
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+// penTipPressure is the pressure above which the pen tip counts as down,
+// so resting the pen lightly does not draw
+const penTipPressure = 0.02
+
+// Linux button codes pen buttons are sent as
+const (
+	btnLeft  = 0x110
+	btnRight = 0x111
+)
+
+// penButton is a button press or release made with the pen
+type penButton struct {
+	Button  uint32
+	Pressed bool
+}
+
+// PenState turns viewer pen messages into pointer buttons. There is no
+// zwp_tablet_manager_v2 to send pressure and tilt with, so clients see the
+// pen as a mouse: the tip is the left button and the barrel button the
+// right one.
+type PenState struct {
+	mu       sync.Mutex
+	tip      bool
+	barrel   bool
+	last     stream.PointerEvent
+	received bool
+}
+
+// Update records a pen message and returns the buttons it pressed or
+// released
+func (p *PenState) Update(e stream.PointerEvent) []penButton {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last, p.received = e, true
+
+	var changes []penButton
+	tip := e.PenButtons&stream.PenTip != 0 && e.Pressure > penTipPressure
+	if tip != p.tip {
+		p.tip = tip
+		changes = append(changes, penButton{btnLeft, tip})
+	}
+	barrel := e.PenButtons&stream.PenBarrel != 0
+	if barrel != p.barrel {
+		p.barrel = barrel
+		changes = append(changes, penButton{btnRight, barrel})
+	}
+	return changes
+}
+
+// PenInfo is the last pen message for the control API
+type PenInfo struct {
+	X        float32    `json:"x"`
+	Y        float32    `json:"y"`
+	Pressure float32    `json:"pressure"`
+	Tilt     [2]float32 `json:"tilt"`
+	Tip      bool       `json:"tip"`
+	Barrel   bool       `json:"barrel"`
+}
+
+// Info returns the last pen message, or false before the first one
+func (p *PenState) Info() (PenInfo, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PenInfo{
+		X:        p.last.X,
+		Y:        p.last.Y,
+		Pressure: p.last.Pressure,
+		Tilt:     p.last.Tilt,
+		Tip:      p.tip,
+		Barrel:   p.barrel,
+	}, p.received
+}
@@ -0,0 +1,37 @@
+package compositor
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+func TestPenState(t *testing.T) {
+	var p PenState
+	if _, ok := p.Info(); ok {
+		t.Error("pen info before any pen message")
+	}
+
+	steps := []struct {
+		event stream.PointerEvent
+		want  []penButton
+	}{
+		{stream.PointerEvent{Type: stream.InputPen, X: 5, Y: 6}, nil},
+		// Resting the pen lightly does not draw
+		{stream.PointerEvent{Type: stream.InputPen, PenButtons: stream.PenTip, Pressure: 0.01}, nil},
+		{stream.PointerEvent{Type: stream.InputPen, PenButtons: stream.PenTip, Pressure: 0.4}, []penButton{{btnLeft, true}}},
+		{stream.PointerEvent{Type: stream.InputPen, PenButtons: stream.PenTip | stream.PenBarrel, Pressure: 0.6}, []penButton{{btnRight, true}}},
+		{stream.PointerEvent{Type: stream.InputPen, X: 7, Y: 8, Tilt: [2]float32{10, -5}}, []penButton{{btnLeft, false}, {btnRight, false}}},
+	}
+	for i, step := range steps {
+		if got := p.Update(step.event); !slices.Equal(got, step.want) {
+			t.Errorf("step %d: buttons %v, want %v", i, got, step.want)
+		}
+	}
+
+	info, ok := p.Info()
+	if !ok || info.X != 7 || info.Tilt != [2]float32{10, -5} || info.Tip {
+		t.Errorf("info %+v, %v", info, ok)
+	}
+}
@@ -0,0 +1,109 @@
+package compositor
+
+import (
+	"image"
+	"sync"
+)
+
+// PointerLock keeps the pointer inside the top window while it is locked,
+// for games and remote desktop apps. The wayland package cannot offer
+// zwp_pointer_constraints_v1 or zwp_relative_pointer_manager_v1, so the
+// lock is turned on by the user rather than the client. While it is on,
+// the preview window and viewers capture the mouse and send relative
+// motion, which moves the pointer within the window.
+type PointerLock struct {
+	mu     sync.Mutex
+	locked bool
+	// bounds is where the pointer is kept: the top window, or the whole
+	// desktop when there is none
+	bounds image.Rectangle
+	x, y   float32
+}
+
+// NewPointerLock creates an unlocked pointer on a desktop of the given size
+func NewPointerLock(width, height int) *PointerLock {
+	return &PointerLock{bounds: image.Rect(0, 0, width, height)}
+}
+
+// Locked reports whether the pointer is locked
+func (p *PointerLock) Locked() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.locked
+}
+
+// Set locks or unlocks the pointer; locking pulls it into the bounds
+func (p *PointerLock) Set(locked bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.locked = locked
+	p.clamp()
+}
+
+// Toggle flips the lock and returns the new state
+func (p *PointerLock) Toggle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.locked = !p.locked
+	p.clamp()
+	return p.locked
+}
+
+// SetBounds sets the rectangle a locked pointer is kept in. Call it from
+// the render loop with the top window.
+func (p *PointerLock) SetBounds(bounds image.Rectangle) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bounds.Empty() {
+		return
+	}
+	p.bounds = bounds
+	p.clamp()
+}
+
+// Warp moves the pointer to an absolute desktop position and returns where
+// it ends up
+func (p *PointerLock) Warp(x, y float32) (float32, float32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.x, p.y = x, y
+	p.clamp()
+	return p.x, p.y
+}
+
+// Move moves the pointer by a relative amount and returns where it ends up
+func (p *PointerLock) Move(dx, dy float32) (float32, float32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.x, p.y = p.x+dx, p.y+dy
+	p.clamp()
+	return p.x, p.y
+}
+
+// Position returns the pointer's desktop position
+func (p *PointerLock) Position() (float32, float32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.x, p.y
+}
+
+// clamp keeps a locked pointer on a pixel inside bounds
+func (p *PointerLock) clamp() {
+	if !p.locked {
+		return
+	}
+	p.x = min(max(p.x, float32(p.bounds.Min.X)), float32(p.bounds.Max.X-1))
+	p.y = min(max(p.y, float32(p.bounds.Min.Y)), float32(p.bounds.Max.Y-1))
+}
+
+// topWindowBounds is the desktop rectangle of the top toplevel in placed
+// (bottom first), or an empty rectangle without one
+func topWindowBounds(placed []PlacedSurface) image.Rectangle {
+	for i := len(placed) - 1; i >= 0; i-- {
+		if isToplevelRoot(placed[i]) {
+			p := placed[i]
+			return image.Rectangle{Min: image.Pt(p.X, p.Y), Max: image.Pt(p.X, p.Y).Add(p.Size)}
+		}
+	}
+	return image.Rectangle{}
+}
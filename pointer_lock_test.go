@@ -0,0 +1,45 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestPointerLock(t *testing.T) {
+	p := NewPointerLock(800, 600)
+	if x, y := p.Warp(700, 500); x != 700 || y != 500 {
+		t.Errorf("unlocked warp to %v, %v", x, y)
+	}
+
+	p.SetBounds(image.Rect(100, 100, 300, 200))
+	p.Set(true)
+	if x, y := p.Position(); x != 299 || y != 199 {
+		t.Errorf("locking left the pointer at %v, %v; want 299, 199", x, y)
+	}
+	if x, y := p.Move(-50, -500); x != 249 || y != 100 {
+		t.Errorf("relative move to %v, %v; want 249, 100", x, y)
+	}
+	if x, y := p.Warp(0, 0); x != 100 || y != 100 {
+		t.Errorf("locked warp to %v, %v; want 100, 100", x, y)
+	}
+
+	p.Set(false)
+	if x, y := p.Move(-150, 0); x != -50 || y != 100 {
+		t.Errorf("unlocked move to %v, %v; want -50, 100", x, y)
+	}
+}
+
+func TestTopWindowBounds(t *testing.T) {
+	placed := append(workspaceToplevels(2), PlacedSurface{SurfaceID: 3, Root: 2, Surface: &wayland.WlSurface{}})
+	placed[0].Size = image.Pt(800, 600)
+	placed[1].X, placed[1].Y, placed[1].Size = 50, 40, image.Pt(200, 100)
+	placed[2].X, placed[2].Y, placed[2].Size = 60, 50, image.Pt(20, 20)
+	if got, want := topWindowBounds(placed), image.Rect(50, 40, 250, 140); got != want {
+		t.Errorf("topWindowBounds = %v, want %v", got, want)
+	}
+	if got := topWindowBounds(nil); !got.Empty() {
+		t.Errorf("topWindowBounds of nothing = %v", got)
+	}
+}
@@ -0,0 +1,195 @@
+package compositor
+
+import (
+	"image"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// positionPopup solves an xdg_positioner: it returns the popup's window
+// geometry relative to the parent's window geometry. bounds is the area the
+// popup must stay inside, in the same coordinate space. Constraint
+// adjustments are applied in the order the protocol specifies: flip, slide,
+// then resize, each axis independently.
+func positionPopup(state wayland.XdgPositionerState, bounds image.Rectangle) image.Rectangle {
+	anchor := state.Anchor
+	gravity := state.Gravity
+	rect := popupRect(state, anchor, gravity)
+	adjust := state.ConstraintAdjustment
+
+	// Flip
+	if adjust&protocols.XdgPositionerConstraintAdjustment_enum_flip_x != 0 && constrainedX(rect, bounds) {
+		flipped := popupRect(state, flipAnchorX(anchor), flipGravityX(gravity))
+		if !constrainedX(flipped, bounds) {
+			anchor, gravity, rect = flipAnchorX(anchor), flipGravityX(gravity), flipped
+		}
+	}
+	if adjust&protocols.XdgPositionerConstraintAdjustment_enum_flip_y != 0 && constrainedY(rect, bounds) {
+		flipped := popupRect(state, flipAnchorY(anchor), flipGravityY(gravity))
+		if !constrainedY(flipped, bounds) {
+			rect = flipped
+		}
+	}
+
+	// Slide
+	if adjust&protocols.XdgPositionerConstraintAdjustment_enum_slide_x != 0 && constrainedX(rect, bounds) {
+		dx := 0
+		if rect.Max.X > bounds.Max.X {
+			dx = bounds.Max.X - rect.Max.X
+		}
+		// Prefer keeping the left edge visible
+		if rect.Min.X+dx < bounds.Min.X {
+			dx = bounds.Min.X - rect.Min.X
+		}
+		rect = rect.Add(image.Pt(dx, 0))
+	}
+	if adjust&protocols.XdgPositionerConstraintAdjustment_enum_slide_y != 0 && constrainedY(rect, bounds) {
+		dy := 0
+		if rect.Max.Y > bounds.Max.Y {
+			dy = bounds.Max.Y - rect.Max.Y
+		}
+		// Prefer keeping the top edge visible
+		if rect.Min.Y+dy < bounds.Min.Y {
+			dy = bounds.Min.Y - rect.Min.Y
+		}
+		rect = rect.Add(image.Pt(0, dy))
+	}
+
+	// Resize
+	if adjust&protocols.XdgPositionerConstraintAdjustment_enum_resize_x != 0 && constrainedX(rect, bounds) {
+		if clipped := image.Rect(max(rect.Min.X, bounds.Min.X), rect.Min.Y, min(rect.Max.X, bounds.Max.X), rect.Max.Y); clipped.Dx() > 0 {
+			rect = clipped
+		}
+	}
+	if adjust&protocols.XdgPositionerConstraintAdjustment_enum_resize_y != 0 && constrainedY(rect, bounds) {
+		if clipped := image.Rect(rect.Min.X, max(rect.Min.Y, bounds.Min.Y), rect.Max.X, min(rect.Max.Y, bounds.Max.Y)); clipped.Dy() > 0 {
+			rect = clipped
+		}
+	}
+
+	return rect
+}
+
+// popupRect places a popup of the positioner's size at the anchor point,
+// extending in the gravity direction, then applies the offset
+func popupRect(
+	state wayland.XdgPositionerState,
+	anchor protocols.XdgPositionerAnchor_enum,
+	gravity protocols.XdgPositionerGravity_enum,
+) image.Rectangle {
+	a := state.AnchorRect
+	x := int(a.X) + int(a.Width)/2
+	y := int(a.Y) + int(a.Height)/2
+	switch anchor {
+	case protocols.XdgPositionerAnchor_enum_left,
+		protocols.XdgPositionerAnchor_enum_top_left,
+		protocols.XdgPositionerAnchor_enum_bottom_left:
+		x = int(a.X)
+	case protocols.XdgPositionerAnchor_enum_right,
+		protocols.XdgPositionerAnchor_enum_top_right,
+		protocols.XdgPositionerAnchor_enum_bottom_right:
+		x = int(a.X + a.Width)
+	}
+	switch anchor {
+	case protocols.XdgPositionerAnchor_enum_top,
+		protocols.XdgPositionerAnchor_enum_top_left,
+		protocols.XdgPositionerAnchor_enum_top_right:
+		y = int(a.Y)
+	case protocols.XdgPositionerAnchor_enum_bottom,
+		protocols.XdgPositionerAnchor_enum_bottom_left,
+		protocols.XdgPositionerAnchor_enum_bottom_right:
+		y = int(a.Y + a.Height)
+	}
+
+	w, h := int(state.Width), int(state.Height)
+	left, top := x-w/2, y-h/2
+	switch gravity {
+	case protocols.XdgPositionerGravity_enum_left,
+		protocols.XdgPositionerGravity_enum_top_left,
+		protocols.XdgPositionerGravity_enum_bottom_left:
+		left = x - w
+	case protocols.XdgPositionerGravity_enum_right,
+		protocols.XdgPositionerGravity_enum_top_right,
+		protocols.XdgPositionerGravity_enum_bottom_right:
+		left = x
+	}
+	switch gravity {
+	case protocols.XdgPositionerGravity_enum_top,
+		protocols.XdgPositionerGravity_enum_top_left,
+		protocols.XdgPositionerGravity_enum_top_right:
+		top = y - h
+	case protocols.XdgPositionerGravity_enum_bottom,
+		protocols.XdgPositionerGravity_enum_bottom_left,
+		protocols.XdgPositionerGravity_enum_bottom_right:
+		top = y
+	}
+
+	left += int(state.Offset.X)
+	top += int(state.Offset.Y)
+	return image.Rect(left, top, left+w, top+h)
+}
+
+func constrainedX(r, bounds image.Rectangle) bool {
+	return r.Min.X < bounds.Min.X || r.Max.X > bounds.Max.X
+}
+
+func constrainedY(r, bounds image.Rectangle) bool {
+	return r.Min.Y < bounds.Min.Y || r.Max.Y > bounds.Max.Y
+}
+
+func flipAnchorX(a protocols.XdgPositionerAnchor_enum) protocols.XdgPositionerAnchor_enum {
+	switch a {
+	case protocols.XdgPositionerAnchor_enum_left:
+		return protocols.XdgPositionerAnchor_enum_right
+	case protocols.XdgPositionerAnchor_enum_right:
+		return protocols.XdgPositionerAnchor_enum_left
+	case protocols.XdgPositionerAnchor_enum_top_left:
+		return protocols.XdgPositionerAnchor_enum_top_right
+	case protocols.XdgPositionerAnchor_enum_top_right:
+		return protocols.XdgPositionerAnchor_enum_top_left
+	case protocols.XdgPositionerAnchor_enum_bottom_left:
+		return protocols.XdgPositionerAnchor_enum_bottom_right
+	case protocols.XdgPositionerAnchor_enum_bottom_right:
+		return protocols.XdgPositionerAnchor_enum_bottom_left
+	}
+	return a
+}
+
+func flipAnchorY(a protocols.XdgPositionerAnchor_enum) protocols.XdgPositionerAnchor_enum {
+	switch a {
+	case protocols.XdgPositionerAnchor_enum_top:
+		return protocols.XdgPositionerAnchor_enum_bottom
+	case protocols.XdgPositionerAnchor_enum_bottom:
+		return protocols.XdgPositionerAnchor_enum_top
+	case protocols.XdgPositionerAnchor_enum_top_left:
+		return protocols.XdgPositionerAnchor_enum_bottom_left
+	case protocols.XdgPositionerAnchor_enum_bottom_left:
+		return protocols.XdgPositionerAnchor_enum_top_left
+	case protocols.XdgPositionerAnchor_enum_top_right:
+		return protocols.XdgPositionerAnchor_enum_bottom_right
+	case protocols.XdgPositionerAnchor_enum_bottom_right:
+		return protocols.XdgPositionerAnchor_enum_top_right
+	}
+	return a
+}
+
+// Gravity values mirror the anchor values, so flipping reuses the anchor
+// tables
+func flipGravityX(g protocols.XdgPositionerGravity_enum) protocols.XdgPositionerGravity_enum {
+	return protocols.XdgPositionerGravity_enum(flipAnchorX(protocols.XdgPositionerAnchor_enum(g)))
+}
+
+func flipGravityY(g protocols.XdgPositionerGravity_enum) protocols.XdgPositionerGravity_enum {
+	return protocols.XdgPositionerGravity_enum(flipAnchorY(protocols.XdgPositionerAnchor_enum(g)))
+}
+
+// getXdgPopup returns the library's xdg_popup state for an object ID
+func getXdgPopup(c *wayland.Client, id protocols.ObjectID[protocols.XdgPopup]) *wayland.XdgPopup {
+	obj, ok := c.GetObject(protocols.AnyObjectID(id)).(*protocols.XdgPopup)
+	if !ok {
+		return nil
+	}
+	popup, _ := obj.Delegate.(*wayland.XdgPopup)
+	return popup
+}
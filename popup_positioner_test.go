@@ -0,0 +1,81 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// menuPositioner describes a 100x50 menu opened below a 20x10 button at
+// (10, 10)
+func menuPositioner() wayland.XdgPositionerState {
+	var state wayland.XdgPositionerState
+	state.Width = 100
+	state.Height = 50
+	state.AnchorRect.X = 10
+	state.AnchorRect.Y = 10
+	state.AnchorRect.Width = 20
+	state.AnchorRect.Height = 10
+	state.Anchor = protocols.XdgPositionerAnchor_enum_bottom_left
+	state.Gravity = protocols.XdgPositionerGravity_enum_bottom_right
+	return state
+}
+
+func TestPositionPopupUnconstrained(t *testing.T) {
+	got := positionPopup(menuPositioner(), image.Rect(0, 0, 800, 600))
+	if want := image.Rect(10, 20, 110, 70); got != want {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestPositionPopupFlipY(t *testing.T) {
+	state := menuPositioner()
+	state.ConstraintAdjustment = protocols.XdgPositionerConstraintAdjustment_enum_flip_y
+	// Only 40 pixels below the button, so the menu opens upwards
+	got := positionPopup(state, image.Rect(-100, -100, 700, 60))
+	if want := image.Rect(10, -40, 110, 10); got != want {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestPositionPopupSlideX(t *testing.T) {
+	state := menuPositioner()
+	state.ConstraintAdjustment = protocols.XdgPositionerConstraintAdjustment_enum_slide_x
+	got := positionPopup(state, image.Rect(0, 0, 80, 600))
+	if want := image.Rect(0, 20, 100, 70); got != want {
+		t.Errorf("A popup wider than the bounds should keep its left edge visible, got %v", got)
+	}
+	got = positionPopup(state, image.Rect(0, 0, 105, 600))
+	if want := image.Rect(5, 20, 105, 70); got != want {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestCollectSurfacesPopupAboveParent(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	addTestSurface(c, 30, 100, 100, 0, 300, 200)
+	popup := addTestSurface(c, 31, 0, 0, 0, 100, 50)
+	addTestSurface(c, 32, 0, 0, 1, 10, 10)
+
+	xdgSurfaceID := protocols.ObjectID[protocols.XdgSurface](40)
+	c.RegisterRoleToSurface(protocols.AnyObjectID(xdgSurfaceID), 30)
+	popupID := protocols.ObjectID[protocols.XdgPopup](41)
+	popup.Role = &wayland.SurfaceRoleXdgPopup{Data: &popupID}
+	c.Objects[protocols.AnyObjectID(popupID)] = &protocols.XdgPopup{
+		Delegate: &wayland.XdgPopup{Parent: &xdgSurfaceID, State: menuPositioner()},
+	}
+
+	placed := NewSurfaceSnapshots().Collect([]*wayland.Client{c}, image.Rect(0, 0, 800, 600))
+	if len(placed) != 3 {
+		t.Fatalf("Expected 3 surfaces, got %d", len(placed))
+	}
+	if placed[0].SurfaceID != 30 || placed[1].SurfaceID != 31 {
+		t.Errorf("Popup should be drawn right after its parent, got %d then %d",
+			placed[0].SurfaceID, placed[1].SurfaceID)
+	}
+	if placed[1].X != 110 || placed[1].Y != 120 {
+		t.Errorf("Expected popup at (110, 120), got (%d, %d)", placed[1].X, placed[1].Y)
+	}
+}
@@ -0,0 +1,252 @@
+package compositor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// powerPollInterval is how often -power-governor reads the sensors
+	powerPollInterval = 5 * time.Second
+	// powerWarmMargin below -thermal-limit already counts as warm
+	powerWarmMargin = 10.0
+	// powerHysteresis is how far under a threshold the temperature has
+	// to fall before a level is left for a better one
+	powerHysteresis = 5.0
+	// powerSettle is how long things have to stay better before quality
+	// goes back up
+	powerSettle = 30 * time.Second
+)
+
+// hwmon drivers whose temperature is the GPU's
+var gpuHwmonNames = map[string]bool{"amdgpu": true, "radeon": true, "nouveau": true, "i915": true, "xe": true}
+
+// PowerReading is what the sensors said. Temperatures are in degrees
+// Celsius, 0 when there is no sensor.
+type PowerReading struct {
+	CPUTemp   float64 `json:"cpu_temp"`
+	GPUTemp   float64 `json:"gpu_temp"`
+	OnBattery bool    `json:"on_battery"`
+	// Battery is the charge in percent, -1 without a battery
+	Battery int `json:"battery"`
+}
+
+// readMilliCelsius reads a sysfs temperature, given in thousandths of a
+// degree
+func readMilliCelsius(path string) (float64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	milli, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || milli <= 0 {
+		return 0, false
+	}
+	return float64(milli) / 1000, true
+}
+
+func readSysfsString(path string) string {
+	data, _ := os.ReadFile(path)
+	return strings.TrimSpace(string(data))
+}
+
+// readPowerState reads the thermal zones, the GPU drivers' hwmon sensors
+// and the batteries under sys, normally /sys. The CPU temperature is the
+// hottest thermal zone.
+func readPowerState(sys string) PowerReading {
+	reading := PowerReading{Battery: -1}
+
+	zones, _ := filepath.Glob(filepath.Join(sys, "class/thermal/thermal_zone*/temp"))
+	for _, zone := range zones {
+		if temp, ok := readMilliCelsius(zone); ok {
+			reading.CPUTemp = max(reading.CPUTemp, temp)
+		}
+	}
+
+	hwmons, _ := filepath.Glob(filepath.Join(sys, "class/hwmon/hwmon*"))
+	for _, hwmon := range hwmons {
+		if !gpuHwmonNames[readSysfsString(filepath.Join(hwmon, "name"))] {
+			continue
+		}
+		inputs, _ := filepath.Glob(filepath.Join(hwmon, "temp*_input"))
+		for _, input := range inputs {
+			if temp, ok := readMilliCelsius(input); ok {
+				reading.GPUTemp = max(reading.GPUTemp, temp)
+			}
+		}
+	}
+
+	supplies, _ := filepath.Glob(filepath.Join(sys, "class/power_supply/*"))
+	for _, supply := range supplies {
+		if readSysfsString(filepath.Join(supply, "type")) != "Battery" {
+			continue
+		}
+		if readSysfsString(filepath.Join(supply, "status")) == "Discharging" {
+			reading.OnBattery = true
+		}
+		if capacity, err := strconv.Atoi(readSysfsString(filepath.Join(supply, "capacity"))); err == nil {
+			reading.Battery = max(reading.Battery, capacity)
+		}
+	}
+	return reading
+}
+
+// PowerLevel is how much the governor holds the compositor back
+type PowerLevel int
+
+const (
+	PowerFull PowerLevel = iota
+	PowerReduced
+	PowerMinimal
+)
+
+// powerLevelEffects are what each level does: divide the frame rate, keep
+// viewers at or below a stream quality tier (see stream.QualityTierCount), and
+// whether post-processing runs
+var powerLevelEffects = []struct {
+	Name        string
+	FPSDivisor  int
+	StreamFloor int
+	PostProcess bool
+}{
+	PowerFull:    {"full", 1, 0, true},
+	PowerReduced: {"reduced", 2, 1, false},
+	PowerMinimal: {"minimal", 4, 2, false},
+}
+
+func (l PowerLevel) String() string {
+	return powerLevelEffects[l].Name
+}
+
+// FPS is the frame rate to run at instead of fps
+func (l PowerLevel) FPS(fps int) int {
+	return max(fps/powerLevelEffects[l].FPSDivisor, 1)
+}
+
+// StreamFloor is the best stream quality tier viewers may get
+func (l PowerLevel) StreamFloor() int {
+	return powerLevelEffects[l].StreamFloor
+}
+
+// PostProcess reports whether post-processing may run
+func (l PowerLevel) PostProcess() bool {
+	return powerLevelEffects[l].PostProcess
+}
+
+// PowerInfo describes the governor for the control API
+type PowerInfo struct {
+	Level   string       `json:"level"`
+	Reason  string       `json:"reason"`
+	Reading PowerReading `json:"reading"`
+	Limit   float64      `json:"thermal_limit"`
+}
+
+// PowerGovernor lowers the frame rate, stream quality and post-processing
+// when the host is hot or on battery. It steps down at once but only back
+// up once the temperature is powerHysteresis under the threshold and has
+// stayed there, and the power has stayed on mains, for powerSettle.
+type PowerGovernor struct {
+	// Limit is the temperature at which quality drops to the minimum
+	Limit float64
+
+	mu          sync.Mutex
+	level       PowerLevel
+	reason      string
+	reading     PowerReading
+	betterSince time.Time
+	pending     *PowerChangedEvent
+}
+
+// NewPowerGovernor starts at full quality
+func NewPowerGovernor(limit float64) *PowerGovernor {
+	return &PowerGovernor{Limit: limit, reason: "no reading yet", reading: PowerReading{Battery: -1}}
+}
+
+// target is the level a reading calls for when limit is the hot threshold
+func (g *PowerGovernor) target(r PowerReading, limit float64) (PowerLevel, string) {
+	sensor, temp := "CPU", r.CPUTemp
+	if r.GPUTemp > temp {
+		sensor, temp = "GPU", r.GPUTemp
+	}
+	hot := fmt.Sprintf("%s at %.0f°C", sensor, temp)
+	switch {
+	case temp >= limit:
+		return PowerMinimal, hot
+	case temp >= limit-powerWarmMargin:
+		return PowerReduced, hot
+	case r.OnBattery:
+		return PowerReduced, "on battery"
+	}
+	return PowerFull, "cool and on mains power"
+}
+
+// Observe takes a reading and moves the level
+func (g *PowerGovernor) Observe(r PowerReading, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reading = r
+
+	worse, reason := g.target(r, g.Limit)
+	if worse > g.level {
+		g.change(worse, reason, now)
+		return
+	}
+	better, reason := g.target(r, g.Limit-powerHysteresis)
+	if better >= g.level {
+		g.betterSince = time.Time{}
+		if worse == g.level {
+			// Same level, but say why it holds now
+			g.reason = reason
+		}
+		return
+	}
+	if g.betterSince.IsZero() {
+		g.betterSince = now
+	}
+	if now.Sub(g.betterSince) >= powerSettle {
+		g.change(better, reason, now)
+	}
+}
+
+func (g *PowerGovernor) change(level PowerLevel, reason string, now time.Time) {
+	event := PowerChangedEvent{
+		Level:    level.String(),
+		Previous: g.level.String(),
+		Reason:   reason,
+		Reading:  g.reading,
+		Time:     now,
+	}
+	g.level, g.reason, g.betterSince = level, reason, time.Time{}
+	g.pending = &event
+}
+
+// Poll returns the last change since the previous Poll, if any
+func (g *PowerGovernor) Poll() (PowerChangedEvent, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pending == nil {
+		return PowerChangedEvent{}, false
+	}
+	event := *g.pending
+	g.pending = nil
+	return event, true
+}
+
+// Level returns the current level
+func (g *PowerGovernor) Level() PowerLevel {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.level
+}
+
+// Info describes the governor
+func (g *PowerGovernor) Info() PowerInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return PowerInfo{Level: g.level.String(), Reason: g.reason, Reading: g.reading, Limit: g.Limit}
+}
@@ -0,0 +1,118 @@
+package compositor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+func writeSysfs(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadPowerState(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, map[string]string{
+		"class/thermal/thermal_zone0/temp": "45000",
+		"class/thermal/thermal_zone1/temp": "71500",
+		"class/hwmon/hwmon0/name":          "amdgpu",
+		"class/hwmon/hwmon0/temp1_input":   "63000",
+		"class/hwmon/hwmon1/name":          "nvme",
+		"class/hwmon/hwmon1/temp1_input":   "99000",
+		"class/power_supply/AC/type":       "Mains",
+		"class/power_supply/BAT0/type":     "Battery",
+		"class/power_supply/BAT0/status":   "Discharging",
+		"class/power_supply/BAT0/capacity": "57",
+	})
+	want := PowerReading{CPUTemp: 71.5, GPUTemp: 63, OnBattery: true, Battery: 57}
+	if got := readPowerState(root); got != want {
+		t.Errorf("readPowerState = %+v, want %+v", got, want)
+	}
+
+	if got := readPowerState(t.TempDir()); got != (PowerReading{Battery: -1}) {
+		t.Errorf("no sensors: %+v", got)
+	}
+}
+
+func TestPowerGovernorStepsDownAtOnce(t *testing.T) {
+	g := NewPowerGovernor(85)
+	now := time.Now()
+	g.Observe(PowerReading{CPUTemp: 50, Battery: -1}, now)
+	if _, ok := g.Poll(); ok {
+		t.Error("change reported while cool")
+	}
+
+	g.Observe(PowerReading{CPUTemp: 50, GPUTemp: 88, Battery: -1}, now)
+	change, ok := g.Poll()
+	if !ok || change.Level != "minimal" || change.Previous != "full" || change.Reason != "GPU at 88°C" {
+		t.Errorf("change = %+v, %v", change, ok)
+	}
+	if _, ok := g.Poll(); ok {
+		t.Error("change reported twice")
+	}
+}
+
+func TestPowerGovernorHysteresis(t *testing.T) {
+	g := NewPowerGovernor(85)
+	now := time.Now()
+	g.Observe(PowerReading{CPUTemp: 86}, now)
+	g.Poll()
+
+	// Just under the limit is not cool enough to leave minimal
+	g.Observe(PowerReading{CPUTemp: 83}, now.Add(time.Minute))
+	if g.Level() != PowerMinimal {
+		t.Errorf("level = %v at 83°C, want minimal", g.Level())
+	}
+
+	// Cool enough, but it has to stay that way for powerSettle
+	start := now.Add(2 * time.Minute)
+	g.Observe(PowerReading{CPUTemp: 60}, start)
+	if g.Level() != PowerMinimal {
+		t.Errorf("level = %v right after cooling, want minimal", g.Level())
+	}
+	g.Observe(PowerReading{CPUTemp: 60}, start.Add(powerSettle))
+	change, ok := g.Poll()
+	if !ok || g.Level() != PowerFull || change.Reason != "cool and on mains power" {
+		t.Errorf("after settling: level %v, change %+v", g.Level(), change)
+	}
+}
+
+func TestPowerGovernorBattery(t *testing.T) {
+	g := NewPowerGovernor(85)
+	now := time.Now()
+	g.Observe(PowerReading{CPUTemp: 50, OnBattery: true, Battery: 40}, now)
+	if change, ok := g.Poll(); !ok || change.Level != "reduced" || change.Reason != "on battery" {
+		t.Errorf("change = %+v, %v", change, ok)
+	}
+	// A blip of mains power does not bring quality back
+	g.Observe(PowerReading{CPUTemp: 50, Battery: 40}, now.Add(powerPollInterval))
+	g.Observe(PowerReading{CPUTemp: 50, OnBattery: true, Battery: 40}, now.Add(2*powerPollInterval))
+	g.Observe(PowerReading{CPUTemp: 50, Battery: 40}, now.Add(3*powerPollInterval))
+	if g.Level() != PowerReduced {
+		t.Errorf("level = %v, want reduced", g.Level())
+	}
+}
+
+func TestPowerLevelEffects(t *testing.T) {
+	if PowerFull.FPS(60) != 60 || PowerReduced.FPS(60) != 30 || PowerMinimal.FPS(2) != 1 {
+		t.Error("wrong frame rates")
+	}
+	if !PowerFull.PostProcess() || PowerReduced.PostProcess() {
+		t.Error("wrong post-processing")
+	}
+	if PowerMinimal.StreamFloor() >= stream.QualityTierCount() {
+		t.Error("stream floor past the last tier")
+	}
+}
@@ -0,0 +1,514 @@
+package compositor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// wireDumpMagic starts a -wl-dump file. Records follow it back to back:
+//
+//	[direction:uint8][client:uint32][time:int64][fds:uint8][length:uint32][bytes]
+//
+// little endian, direction 0 for requests and 1 for events, time in
+// nanoseconds since the dump started, and fds the number of file
+// descriptors that went with the bytes, which are not themselves kept.
+const wireDumpMagic = "WLDUMP1\n"
+
+// WireTracer sits between Wayland clients and the compositor, passing
+// their traffic through untouched while logging every request and event
+// WAYLAND_DEBUG style and dumping the raw bytes to a file, for -wl-debug
+// and -wl-dump. The compositor serves the other end of a socket pair,
+// whose peer is the compositor itself, so the client's pid is read before
+// it is wrapped and kept for connPID.
+type WireTracer struct {
+	logMessages bool
+	start       time.Time
+	clients     atomic.Uint32
+
+	mu   sync.Mutex
+	file *os.File
+	dump *bufio.Writer
+}
+
+// NewWireTracer creates a tracer that logs messages if logMessages is set
+// and dumps them to dumpPath unless it is empty
+func NewWireTracer(logMessages bool, dumpPath string) (*WireTracer, error) {
+	t := &WireTracer{logMessages: logMessages, start: time.Now()}
+	if dumpPath != "" {
+		file, err := os.Create(dumpPath)
+		if err != nil {
+			return nil, err
+		}
+		t.file, t.dump = file, bufio.NewWriter(file)
+		if _, err := t.dump.WriteString(wireDumpMagic); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Close flushes and closes the dump
+func (t *WireTracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		return nil
+	}
+	err := errors.Join(t.dump.Flush(), t.file.Close())
+	t.file, t.dump = nil, nil
+	return err
+}
+
+// Wrap traces a newly accepted client connection, returning the end the
+// compositor should serve in its place. A nil tracer returns conn.
+func (t *WireTracer) Wrap(conn *net.UnixConn) (*net.UnixConn, error) {
+	if t == nil {
+		return conn, nil
+	}
+	inner, proxy, err := socketPair()
+	if err != nil {
+		return nil, err
+	}
+	// Read now, as the compositor's own end no longer leads to the client
+	forgetPeer := proxyPeer(inner, conn)
+	id := t.clients.Add(1)
+	decoder := newWireDecoder()
+	closeBoth := func() {
+		conn.Close()
+		proxy.Close()
+		forgetPeer()
+	}
+	go func() {
+		defer closeBoth()
+		t.pump(conn, proxy, id, false, decoder)
+	}()
+	go func() {
+		defer closeBoth()
+		t.pump(proxy, conn, id, true, decoder)
+	}()
+	if t.logMessages {
+		log.Printf("Wayland client %d: connected", id)
+	}
+	return inner, nil
+}
+
+// pump copies one direction of a client's traffic, file descriptors
+// included, tracing it on the way
+func (t *WireTracer) pump(from, to *net.UnixConn, client uint32, event bool, decoder *wireDecoder) {
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(4*28))
+	for {
+		n, oobn, _, _, err := from.ReadMsgUnix(buf, oob)
+		if err != nil || n == 0 && oobn == 0 {
+			return
+		}
+		fds := parseRights(oob[:oobn])
+		var rights []byte
+		if len(fds) > 0 {
+			rights = syscall.UnixRights(fds...)
+		}
+		// Traced before it is passed on, so the objects a request creates
+		// are known by the time events about them come back
+		t.record(client, event, len(fds), buf[:n], decoder)
+		_, _, err = to.WriteMsgUnix(buf[:n], rights, nil)
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// record logs and dumps bytes that passed between a client and the
+// compositor
+func (t *WireTracer) record(client uint32, event bool, fds int, data []byte, decoder *wireDecoder) {
+	if t.logMessages {
+		for _, m := range decoder.decode(event, data) {
+			if event {
+				log.Printf("Wayland client %d:  -> %s", client, m)
+			} else {
+				log.Printf("Wayland client %d: %s", client, m)
+			}
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dump == nil {
+		return
+	}
+	header := make([]byte, 0, 18)
+	direction := uint8(0)
+	if event {
+		direction = 1
+	}
+	header = append(header, direction)
+	header = binary.LittleEndian.AppendUint32(header, client)
+	header = binary.LittleEndian.AppendUint64(header, uint64(time.Since(t.start)))
+	header = append(header, uint8(fds))
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(data)))
+	t.dump.Write(header)
+	t.dump.Write(data)
+}
+
+// parseRights returns the file descriptors passed in a control message
+func parseRights(oob []byte) []int {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	var fds []int
+	for _, m := range messages {
+		rights, err := syscall.ParseUnixRights(&m)
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	return fds
+}
+
+// socketPair returns the two ends of a new connected Unix socket
+func socketPair() (*net.UnixConn, *net.UnixConn, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn := func(fd int) (*net.UnixConn, error) {
+		f := os.NewFile(uintptr(fd), "wayland")
+		defer f.Close()
+		c, err := net.FileConn(f)
+		if err != nil {
+			return nil, err
+		}
+		return c.(*net.UnixConn), nil
+	}
+	a, err := conn(fds[0])
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	b, err := conn(fds[1])
+	if err != nil {
+		a.Close()
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+// wireRecord is one record of a -wl-dump file
+type wireRecord struct {
+	Event  bool
+	Client uint32
+	Time   time.Duration
+	FDs    int
+	Data   []byte
+}
+
+// readWireDump reads the records of a -wl-dump file
+func readWireDump(r io.Reader) ([]wireRecord, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(wireDumpMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != wireDumpMagic {
+		return nil, fmt.Errorf("not a Wayland wire dump")
+	}
+	var records []wireRecord
+	header := make([]byte, 18)
+	for {
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, fmt.Errorf("record %d: %w", len(records)+1, err)
+		}
+		rec := wireRecord{
+			Event:  header[0] == 1,
+			Client: binary.LittleEndian.Uint32(header[1:5]),
+			Time:   time.Duration(binary.LittleEndian.Uint64(header[5:13])),
+			FDs:    int(header[13]),
+			Data:   make([]byte, binary.LittleEndian.Uint32(header[14:18])),
+		}
+		if _, err := io.ReadFull(br, rec.Data); err != nil {
+			return records, fmt.Errorf("record %d: %w", len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// replayWire sends one client's recorded requests to conn in the order
+// they were made. The file descriptors they carried were not recorded:
+// shared memory pools get zeroed memory of the recorded size instead and
+// anything else an empty file. Events are not waited for.
+func replayWire(records []wireRecord, client uint32, conn *net.UnixConn) error {
+	decoder := newWireDecoder()
+	for _, rec := range records {
+		if rec.Client != client {
+			continue
+		}
+		messages := decoder.decode(rec.Event, rec.Data)
+		if rec.Event {
+			continue
+		}
+		var sizes []int64
+		for _, m := range messages {
+			for i, arg := range m.Args {
+				if arg.Kind != 'h' {
+					continue
+				}
+				size := int64(0)
+				if m.Interface == "wl_shm" && m.Type != nil && m.Type.Name == "create_pool" && i+1 < len(m.Args) {
+					size = m.Args[i+1].Int
+				}
+				sizes = append(sizes, size)
+			}
+		}
+		if err := replayRecord(conn, rec, sizes); err != nil {
+			return fmt.Errorf("replay: %w", err)
+		}
+	}
+	return nil
+}
+
+// replayRecord sends a record's bytes with stand-ins for its file
+// descriptors, the first sized by sizes
+func replayRecord(conn *net.UnixConn, rec wireRecord, sizes []int64) error {
+	var fds []int
+	for i := range rec.FDs {
+		f, err := os.CreateTemp("", "wl-replay-*")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		os.Remove(f.Name())
+		if i < len(sizes) {
+			if err := f.Truncate(max(sizes[i], 0)); err != nil {
+				return err
+			}
+		}
+		fds = append(fds, int(f.Fd()))
+	}
+	var rights []byte
+	if len(fds) > 0 {
+		rights = syscall.UnixRights(fds...)
+	}
+	_, _, err := conn.WriteMsgUnix(rec.Data, rights, nil)
+	return err
+}
+
+// wireDecoder decodes one client's requests and events, following the
+// objects they create to name their interfaces
+type wireDecoder struct {
+	mu      sync.Mutex
+	objects map[uint32]string
+	// pending holds the bytes of a message not yet complete, requests
+	// first
+	pending [2][]byte
+}
+
+func newWireDecoder() *wireDecoder {
+	return &wireDecoder{objects: map[uint32]string{wlDisplayID: "wl_display"}}
+}
+
+// wireMessage is a decoded request or event
+type wireMessage struct {
+	Object    uint32
+	Interface string
+	Opcode    uint16
+	// Type is the message's name and signature, nil if its interface or
+	// opcode is not known
+	Type *wlMessageType
+	Args []wireArg
+	Size int
+}
+
+// wireArg is one argument of a message, by its signature letter
+type wireArg struct {
+	Kind byte
+	// Int is an i, u, o or n argument, or f as 24.8 fixed point
+	Int int64
+	// Str is an s argument, or the interface of an o or n one
+	Str  string
+	Null bool
+	// Len is the size of an a argument
+	Len int
+}
+
+// decode returns the messages completed by data
+func (d *wireDecoder) decode(event bool, data []byte) []wireMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dir := 0
+	if event {
+		dir = 1
+	}
+	buf := append(d.pending[dir], data...)
+	var messages []wireMessage
+	for len(buf) >= 8 {
+		size := int(binary.LittleEndian.Uint32(buf[4:8]) >> 16)
+		if size < 8 {
+			// Out of step with the stream; nothing after this can be read
+			buf = nil
+			break
+		}
+		if len(buf) < size {
+			break
+		}
+		m := wireMessage{
+			Object: binary.LittleEndian.Uint32(buf[0:4]),
+			Opcode: uint16(binary.LittleEndian.Uint32(buf[4:8])),
+			Size:   size,
+		}
+		d.parse(&m, event, wlReader(buf[8:size]))
+		messages = append(messages, m)
+		buf = buf[size:]
+	}
+	d.pending[dir] = append([]byte(nil), buf...)
+	return messages
+}
+
+// message decodes one complete message, for a caller that splits the
+// stream into messages itself
+func (d *wireDecoder) message(event bool, data []byte) wireMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := wireMessage{
+		Object: binary.LittleEndian.Uint32(data[0:4]),
+		Opcode: uint16(binary.LittleEndian.Uint32(data[4:8])),
+		Size:   len(data),
+	}
+	d.parse(&m, event, wlReader(data[8:]))
+	return m
+}
+
+// forget drops an object deleted without the compositor's delete_id
+func (d *wireDecoder) forget(id uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.objects, id)
+}
+
+// parse decodes a message's arguments by its signature and keeps track
+// of the objects it creates and deletes
+func (d *wireDecoder) parse(m *wireMessage, event bool, args wlReader) {
+	m.Interface = d.objects[m.Object]
+	iface, ok := wlInterfaces[m.Interface]
+	if !ok {
+		return
+	}
+	types := iface.Requests
+	if event {
+		types = iface.Events
+	}
+	if int(m.Opcode) >= len(types) {
+		return
+	}
+	m.Type = &types[m.Opcode]
+	for _, kind := range []byte(m.Type.Signature) {
+		if kind == '?' {
+			// Null objects and strings are told by their zero id or length
+			continue
+		}
+		arg := wireArg{Kind: kind}
+		switch kind {
+		case 'i', 'f':
+			arg.Int = int64(int32(args.uint32()))
+		case 'u':
+			arg.Int = int64(args.uint32())
+		case 'o':
+			arg.Int = int64(args.uint32())
+			arg.Null = arg.Int == 0
+			arg.Str = d.objects[uint32(arg.Int)]
+		case 'n':
+			arg.Int = int64(args.uint32())
+			arg.Str = m.Type.Interface
+			if arg.Str == "" {
+				// wl_registry.bind names the interface in the argument before
+				// the version
+				for i := len(m.Args) - 1; i >= 0; i-- {
+					if m.Args[i].Kind == 's' {
+						arg.Str = m.Args[i].Str
+						break
+					}
+				}
+			}
+			d.objects[uint32(arg.Int)] = arg.Str
+		case 's':
+			arg.Null = len(args) >= 4 && binary.LittleEndian.Uint32(args) == 0
+			arg.Str = args.string()
+		case 'a':
+			arg.Len = int(args.uint32())
+			padded := (arg.Len + 3) &^ 3
+			if padded > len(args) {
+				padded = len(args)
+			}
+			args = args[padded:]
+		}
+		m.Args = append(m.Args, arg)
+	}
+	if event && m.Interface == "wl_display" && m.Type.Name == "delete_id" && len(m.Args) == 1 {
+		delete(d.objects, uint32(m.Args[0].Int))
+	}
+}
+
+// String formats the message as WAYLAND_DEBUG does, e.g.
+// wl_surface#12.attach(wl_buffer#15, 0, 0)
+func (m wireMessage) String() string {
+	iface := m.Interface
+	if iface == "" {
+		iface = "[unknown]"
+	}
+	if m.Type == nil {
+		return fmt.Sprintf("%s#%d.[opcode %d](%d bytes)", iface, m.Object, m.Opcode, m.Size-8)
+	}
+	args := make([]string, len(m.Args))
+	for i, arg := range m.Args {
+		switch arg.Kind {
+		case 'i', 'u':
+			args[i] = strconv.FormatInt(arg.Int, 10)
+		case 'f':
+			args[i] = strconv.FormatFloat(float64(arg.Int)/256, 'f', -1, 64)
+		case 'o':
+			switch {
+			case arg.Null:
+				args[i] = "nil"
+			case arg.Str == "":
+				args[i] = fmt.Sprintf("[unknown]#%d", arg.Int)
+			default:
+				args[i] = fmt.Sprintf("%s#%d", arg.Str, arg.Int)
+			}
+		case 'n':
+			args[i] = fmt.Sprintf("new id %s#%d", arg.Str, arg.Int)
+		case 's':
+			if arg.Null {
+				args[i] = "nil"
+			} else {
+				args[i] = strconv.Quote(arg.Str)
+			}
+		case 'a':
+			args[i] = fmt.Sprintf("array[%d]", arg.Len)
+		case 'h':
+			args[i] = "fd"
+		}
+	}
+	return fmt.Sprintf("%s#%d.%s(%s)", iface, m.Object, m.Type.Name, strings.Join(args, ", "))
+}
@@ -0,0 +1,401 @@
+package compositor
+
+// wlMessageType is a request or event: its name, its arguments'
+// signature and the interface of the object it creates, if any
+type wlMessageType struct {
+	Name      string
+	Signature string
+	Interface string
+}
+
+// wlInterface is an interface's requests and events, by opcode
+type wlInterface struct {
+	Requests []wlMessageType
+	Events   []wlMessageType
+}
+
+// wlInterfaces are the interfaces the compositor serves, as its protocol
+// XML describes them, for tracing. Signatures are written as libwayland
+// writes them: i int, u uint, f fixed, s string, o object, n new object,
+// a array and h file descriptor, ? before one that may be null. The
+// interface-less new object of wl_registry.bind is written sun.
+var wlInterfaces = map[string]wlInterface{
+	"wl_display": {
+		Requests: []wlMessageType{
+			{"sync", "n", "wl_callback"},
+			{"get_registry", "n", "wl_registry"},
+		},
+		Events: []wlMessageType{
+			{"error", "ous", ""},
+			{"delete_id", "u", ""},
+		},
+	},
+	"wl_registry": {
+		Requests: []wlMessageType{
+			{"bind", "usun", ""},
+		},
+		Events: []wlMessageType{
+			{"global", "usu", ""},
+			{"global_remove", "u", ""},
+		},
+	},
+	"wl_callback": {
+		Events: []wlMessageType{
+			{"done", "u", ""},
+		},
+	},
+	"wl_compositor": {
+		Requests: []wlMessageType{
+			{"create_surface", "n", "wl_surface"},
+			{"create_region", "n", "wl_region"},
+		},
+	},
+	"wl_shm_pool": {
+		Requests: []wlMessageType{
+			{"create_buffer", "niiiiu", "wl_buffer"},
+			{"destroy", "", ""},
+			{"resize", "i", ""},
+		},
+	},
+	"wl_shm": {
+		Requests: []wlMessageType{
+			{"create_pool", "nhi", "wl_shm_pool"},
+			{"release", "", ""},
+		},
+		Events: []wlMessageType{
+			{"format", "u", ""},
+		},
+	},
+	"wl_buffer": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+		},
+		Events: []wlMessageType{
+			{"release", "", ""},
+		},
+	},
+	"wl_data_offer": {
+		Requests: []wlMessageType{
+			{"accept", "u?s", ""},
+			{"receive", "sh", ""},
+			{"destroy", "", ""},
+			{"finish", "", ""},
+			{"set_actions", "uu", ""},
+		},
+		Events: []wlMessageType{
+			{"offer", "s", ""},
+			{"source_actions", "u", ""},
+			{"action", "u", ""},
+		},
+	},
+	"wl_data_source": {
+		Requests: []wlMessageType{
+			{"offer", "s", ""},
+			{"destroy", "", ""},
+			{"set_actions", "u", ""},
+		},
+		Events: []wlMessageType{
+			{"target", "?s", ""},
+			{"send", "sh", ""},
+			{"cancelled", "", ""},
+			{"dnd_drop_performed", "", ""},
+			{"dnd_finished", "", ""},
+			{"action", "u", ""},
+		},
+	},
+	"wl_data_device": {
+		Requests: []wlMessageType{
+			{"start_drag", "?oo?ou", ""},
+			{"set_selection", "?ou", ""},
+			{"release", "", ""},
+		},
+		Events: []wlMessageType{
+			{"data_offer", "n", "wl_data_offer"},
+			{"enter", "uoff?o", ""},
+			{"leave", "", ""},
+			{"motion", "uff", ""},
+			{"drop", "", ""},
+			{"selection", "?o", ""},
+		},
+	},
+	"wl_data_device_manager": {
+		Requests: []wlMessageType{
+			{"create_data_source", "n", "wl_data_source"},
+			{"get_data_device", "no", "wl_data_device"},
+		},
+	},
+	"wl_shell": {
+		Requests: []wlMessageType{
+			{"get_shell_surface", "no", "wl_shell_surface"},
+		},
+	},
+	"wl_shell_surface": {
+		Requests: []wlMessageType{
+			{"pong", "u", ""},
+			{"move", "ou", ""},
+			{"resize", "ouu", ""},
+			{"set_toplevel", "", ""},
+			{"set_transient", "oiiu", ""},
+			{"set_fullscreen", "uu?o", ""},
+			{"set_popup", "ouoiiu", ""},
+			{"set_maximized", "?o", ""},
+			{"set_title", "s", ""},
+			{"set_class", "s", ""},
+		},
+		Events: []wlMessageType{
+			{"ping", "u", ""},
+			{"configure", "uii", ""},
+			{"popup_done", "", ""},
+		},
+	},
+	"wl_surface": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"attach", "?oii", ""},
+			{"damage", "iiii", ""},
+			{"frame", "n", "wl_callback"},
+			{"set_opaque_region", "?o", ""},
+			{"set_input_region", "?o", ""},
+			{"commit", "", ""},
+			{"set_buffer_transform", "i", ""},
+			{"set_buffer_scale", "i", ""},
+			{"damage_buffer", "iiii", ""},
+			{"offset", "ii", ""},
+		},
+		Events: []wlMessageType{
+			{"enter", "o", ""},
+			{"leave", "o", ""},
+			{"preferred_buffer_scale", "i", ""},
+			{"preferred_buffer_transform", "u", ""},
+		},
+	},
+	"wl_seat": {
+		Requests: []wlMessageType{
+			{"get_pointer", "n", "wl_pointer"},
+			{"get_keyboard", "n", "wl_keyboard"},
+			{"get_touch", "n", "wl_touch"},
+			{"release", "", ""},
+		},
+		Events: []wlMessageType{
+			{"capabilities", "u", ""},
+			{"name", "s", ""},
+		},
+	},
+	"wl_pointer": {
+		Requests: []wlMessageType{
+			{"set_cursor", "u?oii", ""},
+			{"release", "", ""},
+		},
+		Events: []wlMessageType{
+			{"enter", "uoff", ""},
+			{"leave", "uo", ""},
+			{"motion", "uff", ""},
+			{"button", "uuuu", ""},
+			{"axis", "uuf", ""},
+			{"frame", "", ""},
+			{"axis_source", "u", ""},
+			{"axis_stop", "uu", ""},
+			{"axis_discrete", "ui", ""},
+			{"axis_value120", "ui", ""},
+			{"axis_relative_direction", "uu", ""},
+		},
+	},
+	"wl_keyboard": {
+		Requests: []wlMessageType{
+			{"release", "", ""},
+		},
+		Events: []wlMessageType{
+			{"keymap", "uhu", ""},
+			{"enter", "uoa", ""},
+			{"leave", "uo", ""},
+			{"key", "uuuu", ""},
+			{"modifiers", "uuuuu", ""},
+			{"repeat_info", "ii", ""},
+		},
+	},
+	"wl_touch": {
+		Requests: []wlMessageType{
+			{"release", "", ""},
+		},
+		Events: []wlMessageType{
+			{"down", "uuoiff", ""},
+			{"up", "uui", ""},
+			{"motion", "uiff", ""},
+			{"frame", "", ""},
+			{"cancel", "", ""},
+			{"shape", "iff", ""},
+			{"orientation", "if", ""},
+		},
+	},
+	"wl_output": {
+		Requests: []wlMessageType{
+			{"release", "", ""},
+		},
+		Events: []wlMessageType{
+			{"geometry", "iiiiissi", ""},
+			{"mode", "uiii", ""},
+			{"done", "", ""},
+			{"scale", "i", ""},
+			{"name", "s", ""},
+			{"description", "s", ""},
+		},
+	},
+	"wl_region": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"add", "iiii", ""},
+			{"subtract", "iiii", ""},
+		},
+	},
+	"wl_subcompositor": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"get_subsurface", "noo", "wl_subsurface"},
+		},
+	},
+	"wl_subsurface": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"set_position", "ii", ""},
+			{"place_above", "o", ""},
+			{"place_below", "o", ""},
+			{"set_sync", "", ""},
+			{"set_desync", "", ""},
+		},
+	},
+	"xdg_wm_base": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"create_positioner", "n", "xdg_positioner"},
+			{"get_xdg_surface", "no", "xdg_surface"},
+			{"pong", "u", ""},
+		},
+		Events: []wlMessageType{
+			{"ping", "u", ""},
+		},
+	},
+	"xdg_positioner": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"set_size", "ii", ""},
+			{"set_anchor_rect", "iiii", ""},
+			{"set_anchor", "u", ""},
+			{"set_gravity", "u", ""},
+			{"set_constraint_adjustment", "u", ""},
+			{"set_offset", "ii", ""},
+			{"set_reactive", "", ""},
+			{"set_parent_size", "ii", ""},
+			{"set_parent_configure", "u", ""},
+		},
+	},
+	"xdg_surface": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"get_toplevel", "n", "xdg_toplevel"},
+			{"get_popup", "n?oo", "xdg_popup"},
+			{"set_window_geometry", "iiii", ""},
+			{"ack_configure", "u", ""},
+		},
+		Events: []wlMessageType{
+			{"configure", "u", ""},
+		},
+	},
+	"xdg_toplevel": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"set_parent", "?o", ""},
+			{"set_title", "s", ""},
+			{"set_app_id", "s", ""},
+			{"show_window_menu", "ouii", ""},
+			{"move", "ou", ""},
+			{"resize", "ouu", ""},
+			{"set_max_size", "ii", ""},
+			{"set_min_size", "ii", ""},
+			{"set_maximized", "", ""},
+			{"unset_maximized", "", ""},
+			{"set_fullscreen", "?o", ""},
+			{"unset_fullscreen", "", ""},
+			{"set_minimized", "", ""},
+		},
+		Events: []wlMessageType{
+			{"configure", "iia", ""},
+			{"close", "", ""},
+			{"configure_bounds", "ii", ""},
+			{"wm_capabilities", "a", ""},
+		},
+	},
+	"xdg_popup": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"grab", "ou", ""},
+			{"reposition", "ou", ""},
+		},
+		Events: []wlMessageType{
+			{"configure", "iiii", ""},
+			{"popup_done", "", ""},
+			{"repositioned", "u", ""},
+		},
+	},
+	"zxdg_decoration_manager_v1": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"get_toplevel_decoration", "no", "zxdg_toplevel_decoration_v1"},
+		},
+	},
+	"zxdg_toplevel_decoration_v1": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"set_mode", "u", ""},
+			{"unset_mode", "", ""},
+		},
+		Events: []wlMessageType{
+			{"configure", "u", ""},
+		},
+	},
+	"xwayland_shell_v1": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"get_xwayland_surface", "no", "xwayland_surface_v1"},
+		},
+	},
+	"xwayland_surface_v1": {
+		Requests: []wlMessageType{
+			{"set_serial", "uu", ""},
+			{"destroy", "", ""},
+		},
+	},
+	"zwp_xwayland_keyboard_grab_manager_v1": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+			{"grab_keyboard", "noo", "zwp_xwayland_keyboard_grab_v1"},
+		},
+	},
+	"zwp_xwayland_keyboard_grab_v1": {
+		Requests: []wlMessageType{
+			{"destroy", "", ""},
+		},
+	},
+	"zwlr_screencopy_manager_v1": {
+		Requests: []wlMessageType{
+			{"capture_output", "nio", "zwlr_screencopy_frame_v1"},
+			{"capture_output_region", "nioiiii", "zwlr_screencopy_frame_v1"},
+			{"destroy", "", ""},
+		},
+	},
+	"zwlr_screencopy_frame_v1": {
+		Requests: []wlMessageType{
+			{"copy", "o", ""},
+			{"destroy", "", ""},
+			{"copy_with_damage", "o", ""},
+		},
+		Events: []wlMessageType{
+			{"buffer", "uuuu", ""},
+			{"flags", "u", ""},
+			{"ready", "uuu", ""},
+			{"failed", "", ""},
+			{"damage", "uuuu", ""},
+			{"linux_dmabuf", "uuu", ""},
+			{"buffer_done", "", ""},
+		},
+	},
+}
@@ -0,0 +1,173 @@
+package compositor
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWireDecoder(t *testing.T) {
+	d := newWireDecoder()
+	requests := append(wlMessage(wlDisplayID, 1, wlArgs{}.uint32(2)),
+		wlMessage(2, 0, wlArgs{}.uint32(1).string("wl_compositor").uint32(4).uint32(3))...)
+	requests = append(requests, wlMessage(3, 0, wlArgs{}.uint32(4))...)
+	requests = append(requests, wlMessage(4, 1, wlArgs{}.uint32(0).int32(-2).int32(5))...)
+
+	// Messages split across reads come out once they are complete
+	var decoded []string
+	for _, part := range [][]byte{requests[:10], requests[10:]} {
+		for _, m := range d.decode(false, part) {
+			decoded = append(decoded, m.String())
+		}
+	}
+	want := []string{
+		"wl_display#1.get_registry(new id wl_registry#2)",
+		`wl_registry#2.bind(1, "wl_compositor", 4, new id wl_compositor#3)`,
+		"wl_compositor#3.create_surface(new id wl_surface#4)",
+		"wl_surface#4.attach(nil, -2, 5)",
+	}
+	if len(decoded) != len(want) {
+		t.Fatalf("Expected %d messages, got %q", len(want), decoded)
+	}
+	for i := range want {
+		if decoded[i] != want[i] {
+			t.Errorf("Expected %s, got %s", want[i], decoded[i])
+		}
+	}
+
+	events := d.decode(true, wlMessage(wlDisplayID, 1, wlArgs{}.uint32(4)))
+	if len(events) != 1 || events[0].String() != "wl_display#1.delete_id(4)" {
+		t.Errorf("Expected delete_id, got %v", events)
+	}
+	if m := d.decode(false, wlMessage(4, 2, nil)); len(m) != 1 || m[0].String() != "[unknown]#4.[opcode 2](0 bytes)" {
+		t.Errorf("Expected a deleted object to be unknown, got %v", m)
+	}
+}
+
+func TestWireTracer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.wl")
+	tracer, err := NewWireTracer(false, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, accepted := unixPair(t)
+	server, err := tracer.Wrap(accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	pool, err := os.CreateTemp(t.TempDir(), "pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	c := &wlConn{conn: client, nextID: wlDisplayID}
+	if err := c.request(wlDisplayID, 1, wlArgs{}.uint32(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.request(2, 0, wlArgs{}.uint32(1).string("wl_shm").uint32(1).uint32(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.request(3, 0, wlArgs{}.uint32(4).int32(4096), int(pool.Fd())); err != nil {
+		t.Fatal(err)
+	}
+	sent, fds := readWire(t, server, 3)
+	if len(fds) != 1 {
+		t.Fatalf("Expected the pool's file descriptor passed through, got %d", len(fds))
+	}
+	syscall.Close(fds[0])
+	if _, err := server.Write(wlMessage(3, 0, wlArgs{}.uint32(0))); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if event, err := c.next(); err != nil || event.Object != 3 {
+		t.Fatalf("Expected the wl_shm.format event, got %+v, %v", event, err)
+	}
+
+	client.Close()
+	// The tracer sees the client go and closes the compositor's end
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := server.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Expected the traced connection closed, read %d bytes", n)
+	}
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := readWireDump(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests, events, passed int
+	for _, rec := range records {
+		if rec.Client != 1 {
+			t.Errorf("Expected only client 1, got %d", rec.Client)
+		}
+		if rec.Event {
+			events += len(rec.Data)
+		} else {
+			requests += len(rec.Data)
+		}
+		passed += rec.FDs
+	}
+	if requests != len(sent) || events != 12 || passed != 1 {
+		t.Errorf("Expected %d request bytes, 12 event bytes and 1 fd, got %d, %d and %d", len(sent), requests, events, passed)
+	}
+
+	// Replayed, the same requests arrive, with a pool of the recorded size
+	replay, replayed := unixPair(t)
+	if err := replayWire(records, 1, replay); err != nil {
+		t.Fatal(err)
+	}
+	got, fds := readWire(t, replayed, 3)
+	if string(got) != string(sent) {
+		t.Error("Expected the replayed requests to match the recorded ones")
+	}
+	if len(fds) != 1 {
+		t.Fatalf("Expected a stand-in file descriptor, got %d", len(fds))
+	}
+	defer syscall.Close(fds[0])
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fds[0], &stat); err != nil || stat.Size != 4096 {
+		t.Errorf("Expected a 4096 byte pool, got %d, %v", stat.Size, err)
+	}
+}
+
+func TestReadWireDump(t *testing.T) {
+	if _, err := readWireDump(strings.NewReader("WAYLAND")); err == nil {
+		t.Error("Expected an error for a file that is not a dump")
+	}
+}
+
+// readWire reads count messages from conn, with the file descriptors
+// passed alongside them
+func readWire(t *testing.T, conn *net.UnixConn, count int) ([]byte, []int) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var data []byte
+	var fds []int
+	for {
+		d := newWireDecoder()
+		if len(d.decode(false, data)) >= count {
+			return data, fds
+		}
+		buf := make([]byte, 4096)
+		oob := make([]byte, syscall.CmsgSpace(4*28))
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf[:n]...)
+		fds = append(fds, parseRights(oob[:oobn])...)
+	}
+}
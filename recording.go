@@ -0,0 +1,385 @@
+package compositor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// A recording is recordingMagic followed by records, each
+// [kind:uint8][at:int64][length:uint32][payload], little endian, where at
+// is microseconds since the recording started. Frames are stream frames
+// (see encodeFrame), deflated and XORed with the frame before except for
+// keyframes; input is a viewer input message (see the input message types
+// in server.go), whether it came from a viewer or the preview window.
+const (
+	recordingMagic = "PUPREC\x00\x01"
+	recordFrame    = 1
+	recordInput    = 2
+	// recordingKeyframeInterval is how often a full frame is recorded,
+	// bounding how far a damaged file loses frames
+	recordingKeyframeInterval = 10 * time.Second
+)
+
+// Recorder writes the desktop's frames and the input sent to clients to a
+// file for Replayer, to reproduce bugs and for demos. Frames are
+// compressed on the recorder's own goroutine; a frame that arrives while
+// the one before is still being compressed replaces it, so a slow disk
+// costs frames, not the render loop's time.
+type Recorder struct {
+	file  *os.File
+	start time.Time
+
+	mu  sync.Mutex
+	out *bufio.Writer
+	err error
+	// While paused nothing is recorded and the recording's clock stops:
+	// skipped is the time spent paused before pausedAt, and resumed is
+	// set until the first frame after resuming
+	paused   bool
+	pausedAt time.Time
+	skipped  time.Duration
+	resumed  bool
+
+	frames chan recordedFrame
+	done   chan struct{}
+	// source and damage are the frame last handed over, touched only by
+	// Frame on the render loop
+	source TextureSource
+	damage uint64
+}
+
+// recordedFrame is a copy of a frame waiting to be compressed
+type recordedFrame struct {
+	pixels                []byte
+	width, height, stride int
+	at                    time.Time
+}
+
+// NewRecorder creates path and starts recording into it
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	r := &Recorder{
+		file:   file,
+		start:  time.Now(),
+		out:    bufio.NewWriterSize(file, 1<<20),
+		frames: make(chan recordedFrame, 1),
+		done:   make(chan struct{}),
+	}
+	if _, err := r.out.WriteString(recordingMagic); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write recording: %w", err)
+	}
+	go r.encodeLoop()
+	return r, nil
+}
+
+// Frame records source's frame unless it was the last one recorded. Call
+// it from the render loop.
+func (r *Recorder) Frame(source TextureSource, now time.Time) {
+	now, ok := r.clock(now)
+	if !ok {
+		return
+	}
+	resumed := r.takeResumed()
+	damage := source.Damage()
+	if source == r.source && damage == r.damage && !resumed {
+		return
+	}
+	pixels, stride := source.Frame()
+	if len(pixels) == 0 {
+		return
+	}
+	r.source, r.damage = source, damage
+	size := source.Size()
+	frame := recordedFrame{bytes.Clone(pixels), size.X, size.Y, stride, now}
+	select {
+	case r.frames <- frame:
+		return
+	default:
+	}
+	select {
+	case <-r.frames:
+	default:
+	}
+	select {
+	case r.frames <- frame:
+	default:
+	}
+}
+
+// Input records a viewer input message sent to the clients at now
+func (r *Recorder) Input(message []byte, now time.Time) {
+	if now, ok := r.clock(now); ok {
+		r.write(recordInput, now, message)
+	}
+}
+
+// SetPaused pauses or resumes recording at now. The time spent paused is
+// left out, so the recording plays on from where it paused.
+func (r *Recorder) SetPaused(paused bool, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setPaused(paused, now)
+}
+
+// Toggle pauses or resumes recording at now and reports whether it is
+// recording
+func (r *Recorder) Toggle(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setPaused(!r.paused, now)
+	return !r.paused
+}
+
+// setPaused pauses or resumes recording. Call with r.mu held.
+func (r *Recorder) setPaused(paused bool, now time.Time) {
+	if paused == r.paused {
+		return
+	}
+	r.paused = paused
+	if paused {
+		r.pausedAt = now
+		return
+	}
+	r.skipped += now.Sub(r.pausedAt)
+	r.resumed = true
+}
+
+// Paused reports whether recording is paused
+func (r *Recorder) Paused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+// clock returns now on the recording's clock, which stops while paused,
+// and false while paused
+func (r *Recorder) clock(now time.Time) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return now.Add(-r.skipped), !r.paused
+}
+
+// takeResumed reports whether recording resumed since the last frame, so
+// the desktop is recorded again even if it has not changed
+func (r *Recorder) takeResumed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resumed := r.resumed
+	r.resumed = false
+	return resumed
+}
+
+// keyboardMessage, pointerMotionMessage, pointerButtonMessage and
+// pointerAxisMessage encode the preview window's input as viewer input
+// messages, for Input
+func keyboardMessage(keycode uint32, pressed bool) []byte {
+	return append(binary.LittleEndian.AppendUint32([]byte{stream.InputKeyboard}, keycode), boolByte(pressed))
+}
+
+func pointerMotionMessage(x, y float32) []byte {
+	message := binary.LittleEndian.AppendUint32([]byte{stream.InputPointerMotion}, math.Float32bits(x))
+	return binary.LittleEndian.AppendUint32(message, math.Float32bits(y))
+}
+
+func pointerButtonMessage(button uint32, pressed bool) []byte {
+	return append(binary.LittleEndian.AppendUint32([]byte{stream.InputPointerButton}, button), boolByte(pressed))
+}
+
+func pointerAxisMessage(axis protocols.WlPointerAxis_enum, value float32) []byte {
+	var horizontal byte
+	if axis == protocols.WlPointerAxis_enum_horizontal_scroll {
+		horizontal = 1
+	}
+	return binary.LittleEndian.AppendUint32([]byte{stream.InputPointerAxis, horizontal}, math.Float32bits(value))
+}
+
+// boolByte is 1 for true and 0 for false
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// encodeLoop compresses frames until Close
+func (r *Recorder) encodeLoop() {
+	defer close(r.done)
+	var previous []byte
+	var keyframe time.Time
+	var encoder stream.FrameEncoder
+	for frame := range r.frames {
+		base := previous
+		if frame.at.Sub(keyframe) >= recordingKeyframeInterval || len(base) != len(frame.pixels) {
+			base, keyframe = nil, frame.at
+		}
+		message, err := encoder.Encode(frame.pixels, base, frame.width, frame.height, frame.stride, "deflate", flate.BestSpeed, time.Time{})
+		if err != nil {
+			log.Printf("Recording: %v", err)
+			continue
+		}
+		r.write(recordFrame, frame.at, message...)
+		previous = frame.pixels
+	}
+}
+
+// write appends a record, its payload in parts; after the first failure
+// nothing more is written
+func (r *Recorder) write(kind byte, at time.Time, payload ...[]byte) {
+	size := 0
+	for _, part := range payload {
+		size += len(part)
+	}
+	header := make([]byte, 13)
+	header[0] = kind
+	binary.LittleEndian.PutUint64(header[1:9], uint64(at.Sub(r.start).Microseconds()))
+	binary.LittleEndian.PutUint32(header[9:13], uint32(size))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if _, err := r.out.Write(header); err != nil {
+		r.fail(err)
+		return
+	}
+	for _, part := range payload {
+		if _, err := r.out.Write(part); err != nil {
+			r.fail(err)
+			return
+		}
+	}
+}
+
+// fail stops recording after a write error. Call with r.mu held.
+func (r *Recorder) fail(err error) {
+	r.err = err
+	log.Printf("Recording stopped: %v", err)
+}
+
+// Close compresses the last frame and finishes the file
+func (r *Recorder) Close() error {
+	close(r.frames)
+	<-r.done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.out.Flush()
+	}
+	if err := r.file.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// Replayer plays a recording back as a TextureSource at the speed it was
+// recorded, once, then holds its last frame. With an input handler, the
+// recorded input is sent to it as it comes up, to replay it into live
+// clients.
+type Replayer struct {
+	*sourceFrames
+	file  *os.File
+	input func(message []byte)
+	done  chan struct{}
+}
+
+// NewReplayer opens path and starts playing it. input may be nil.
+func NewReplayer(path string, input func(message []byte)) (*Replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	magic := make([]byte, len(recordingMagic))
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != recordingMagic {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a recording", path)
+	}
+	r := &Replayer{sourceFrames: newSourceFrames(sourceSurfaceID), file: file, input: input, done: make(chan struct{})}
+	go r.run()
+	return r, nil
+}
+
+// Close stops playing
+func (r *Replayer) Close() {
+	close(r.done)
+	r.file.Close()
+}
+
+// run plays each record at its time after the start. Records written a
+// little out of order, as frames are compressed while input is written,
+// play as soon as they are read.
+func (r *Replayer) run() {
+	in := bufio.NewReaderSize(r.file, 1<<20)
+	start := time.Now()
+	var previous []byte
+	header := make([]byte, 13)
+	for {
+		if _, err := io.ReadFull(in, header); err != nil {
+			if !errors.Is(err, io.EOF) {
+				r.stopped(err)
+			} else {
+				log.Printf("Replay finished")
+			}
+			return
+		}
+		at := time.Duration(binary.LittleEndian.Uint64(header[1:9])) * time.Microsecond
+		payload := make([]byte, binary.LittleEndian.Uint32(header[9:13]))
+		if _, err := io.ReadFull(in, payload); err != nil {
+			r.stopped(err)
+			return
+		}
+		select {
+		case <-r.done:
+			return
+		case <-time.After(time.Until(start.Add(at))):
+		}
+
+		switch header[0] {
+		case recordFrame:
+			width, height, stride, pixels, err := stream.DecodeFrame(payload, previous)
+			if err != nil {
+				r.stopped(err)
+				return
+			}
+			previous = pixels
+			texture := r.back(uint32(width), uint32(height))
+			texture.Stride = uint32(stride)
+			if len(texture.Data) != len(pixels) {
+				texture = &wayland.Texture{Width: uint32(width), Height: uint32(height), Stride: uint32(stride), Data: make([]byte, len(pixels))}
+			}
+			copy(texture.Data, pixels)
+			r.publish(texture)
+		case recordInput:
+			if r.input != nil {
+				r.input(payload)
+			}
+		}
+	}
+}
+
+// stopped logs why playing stopped early, unless it was closed
+func (r *Replayer) stopped(err error) {
+	select {
+	case <-r.done:
+	default:
+		log.Printf("Replay stopped: %v", err)
+	}
+}
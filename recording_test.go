@@ -0,0 +1,153 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestRecordingReplays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.rec")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := render.NewDesktopTextureSource()
+	desktop := &wayland.Desktop{Width: 2, Height: 1, Stride: 8, Buffer: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	now := time.Now()
+	source.Publish(desktop)
+	recorder.Frame(source, now)
+	recorder.Input(keyboardMessage(30, true), now.Add(time.Millisecond))
+	// The last frame recorded is never dropped
+	desktop.Buffer = []byte{8, 7, 6, 5, 4, 3, 2, 1}
+	source.Publish(desktop)
+	recorder.Frame(source, now.Add(2*time.Millisecond))
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	input := make(chan []byte, 4)
+	replayer, err := NewReplayer(path, func(message []byte) { input <- message })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+	select {
+	case message := <-input:
+		if !bytes.Equal(message, keyboardMessage(30, true)) {
+			t.Errorf("Replayed input %v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The input was not replayed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pixels, stride := replayer.Frame()
+		if bytes.Equal(pixels, desktop.Buffer) {
+			if stride != 8 || replayer.Size() != image.Pt(2, 1) {
+				t.Errorf("Replayed frame has stride %d, size %v", stride, replayer.Size())
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The last frame was not replayed, showing %v", pixels)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRecordingPause(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.rec")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := render.NewDesktopTextureSource()
+	source.Publish(&wayland.Desktop{Width: 1, Height: 1, Stride: 4, Buffer: []byte{1, 2, 3, 4}})
+	now := recorder.start
+	recorder.Frame(source, now)
+	if recorder.Toggle(now.Add(time.Second)) || !recorder.Paused() {
+		t.Fatal("Toggle did not pause the recording")
+	}
+	recorder.Input(keyboardMessage(30, true), now.Add(2*time.Second))
+	if !recorder.Toggle(now.Add(time.Minute)) {
+		t.Fatal("Toggle did not resume the recording")
+	}
+	// The unchanged desktop is recorded again on resuming, and the minute
+	// paused is left out
+	recorder.Frame(source, now.Add(time.Minute+time.Second))
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var times []time.Duration
+	for rest := data[len(recordingMagic):]; len(rest) >= 13; {
+		if rest[0] != recordFrame {
+			t.Errorf("Expected only frames, got a record of kind %d", rest[0])
+		}
+		times = append(times, time.Duration(binary.LittleEndian.Uint64(rest[1:9]))*time.Microsecond)
+		rest = rest[13+binary.LittleEndian.Uint32(rest[9:13]):]
+	}
+	// The first frame may have been replaced by the second before it was
+	// compressed
+	if len(times) == 0 || times[len(times)-1] != 2*time.Second {
+		t.Errorf("Expected the last frame at 2s, got %v", times)
+	}
+}
+
+func TestReplayerRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not.rec")
+	if err := os.WriteFile(path, []byte("hello, world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReplayer(path, nil); err == nil {
+		t.Error("A file without the recording header should not play")
+	}
+}
+
+func TestReplayInputInjected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.rec")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Input(keyboardMessage(30, true), time.Now())
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// -replay-input hands the recorded input to the viewers' input path
+	server := NewHTTPServer("", "")
+	keys := make(chan uint32, 1)
+	server.SetKeyboardHandler(func(keycode uint32, pressed bool) {
+		if pressed {
+			keys <- keycode
+		}
+	})
+	replayer, err := NewReplayer(path, server.Inject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+	select {
+	case key := <-keys:
+		if key != 30 {
+			t.Errorf("Replayed key %d, want 30", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The input was not injected")
+	}
+}
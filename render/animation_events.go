@@ -0,0 +1,31 @@
+package render
+
+import "time"
+
+// AnimationPlayback is where the model's animation is
+type AnimationPlayback struct {
+	Name string `json:"name"`
+	// Time is how far into the animation playback is, in seconds
+	Time     float32 `json:"time"`
+	Duration float32 `json:"duration"`
+	// Progress is Time over Duration, 0 to 1
+	Progress float32 `json:"progress"`
+	Loop     bool    `json:"loop"`
+	Paused   bool    `json:"paused"`
+}
+
+// AnimationLayerInfo describes an animation layered over the current one
+type AnimationLayerInfo struct {
+	Name  string   `json:"name"`
+	Loop  bool     `json:"loop"`
+	Bones []string `json:"bones"`
+}
+
+// AnimationPlaybackEvent is emitted when the model's animation is played,
+// paused, resumed, sought, stopped, loops or finishes. Playback is where
+// it was at Time; while it plays it moves on in real time from there.
+type AnimationPlaybackEvent struct {
+	Action   string
+	Playback AnimationPlayback
+	Time     time.Time
+}
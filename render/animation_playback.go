@@ -0,0 +1,90 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+	"time"
+)
+
+// Playback reports where the current animation is, the zero value
+// without one
+func (r *GLBRenderer) Playback() AnimationPlayback {
+	anim := r.CurrentAnim
+	if anim == nil {
+		return AnimationPlayback{}
+	}
+	p := AnimationPlayback{Name: anim.Name, Duration: anim.Duration, Loop: r.AnimLoop, Paused: r.AnimPaused}
+	p.Time, _ = animationTime(anim, r.playbackNow().Sub(r.AnimStartTime), r.AnimLoop)
+	p.Time = min(max(p.Time, 0), anim.Duration)
+	if anim.Duration > 0 {
+		p.Progress = p.Time / anim.Duration
+	}
+	return p
+}
+
+// playbackNow is the moment the current pose belongs to: now, or when
+// playback was paused
+func (r *GLBRenderer) playbackNow() time.Time {
+	if r.AnimPaused {
+		return r.pausedAt
+	}
+	return time.Now()
+}
+
+// Seek moves the named animation, or the current one when name is empty,
+// to t seconds in and poses the model there. Another animation becomes the
+// current one, keeping the loop setting; a paused animation stays paused.
+func (r *GLBRenderer) Seek(name string, t float32) error {
+	anim := r.CurrentAnim
+	if name != "" {
+		var ok bool
+		if anim, ok = r.Animations[name]; !ok {
+			return fmt.Errorf("animation '%s' not found", name)
+		}
+	}
+	if anim == nil {
+		return fmt.Errorf("no animation is playing")
+	}
+	if t < 0 || t > anim.Duration {
+		return fmt.Errorf("time %v is outside animation '%s', 0 to %v seconds", t, anim.Name, anim.Duration)
+	}
+
+	now := r.playbackNow()
+	r.CurrentAnim = anim
+	r.AnimStartTime = now.Add(-time.Duration(float64(t) * float64(time.Second)))
+	r.loops = 0
+	r.applyPose(now)
+	r.notePlayback("seek")
+	return nil
+}
+
+// SetNormalizedTime seeks to progress, 0 the start and 1 the end, of the
+// named animation or the current one. A looping animation's end is its
+// start again.
+func (r *GLBRenderer) SetNormalizedTime(name string, progress float32) error {
+	if progress < 0 || progress > 1 {
+		return fmt.Errorf("progress %v is outside 0 to 1", progress)
+	}
+	anim := r.CurrentAnim
+	if name != "" {
+		anim = r.Animations[name]
+	}
+	if anim == nil {
+		// Let Seek say what is missing
+		return r.Seek(name, 0)
+	}
+	return r.Seek(name, progress*anim.Duration)
+}
+
+// notePlayback queues an AnimationPlaybackEvent for action
+func (r *GLBRenderer) notePlayback(action string) {
+	r.playback = append(r.playback, AnimationPlaybackEvent{Action: action, Playback: r.Playback(), Time: time.Now()})
+}
+
+// TakePlaybackEvents returns the playback changes since the last call
+func (r *GLBRenderer) TakePlaybackEvents() []AnimationPlaybackEvent {
+	events := r.playback
+	r.playback = nil
+	return events
+}
@@ -0,0 +1,74 @@
+//go:build !nogl
+
+package render
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSeekPosesAndReports(t *testing.T) {
+	r := &GLBRenderer{GL: newRecordingGL(), Animations: make(map[string]*Animation)}
+	r.lookupUniforms()
+	if err := r.LoadDocument(flippedRotationClip(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.PlayAnimation("Turn", false); err != nil {
+		t.Fatal(err)
+	}
+	r.PauseAnimation()
+	r.TakePlaybackEvents()
+
+	if err := r.Seek("", 0.25); err != nil {
+		t.Fatal(err)
+	}
+	p := r.Playback()
+	if math.Abs(float64(p.Time)-0.25) > 1e-3 || math.Abs(float64(p.Progress)-0.25) > 1e-3 {
+		t.Errorf("after seeking to 0.25s of 1s, time %v progress %v", p.Time, p.Progress)
+	}
+	if !p.Paused || p.Loop || p.Name != "Turn" {
+		t.Errorf("seeking should keep the loop and pause settings, got %+v", p)
+	}
+	quarter := r.NodeTransforms[0].Rotation
+
+	if err := r.SetNormalizedTime("Turn", 1); err != nil {
+		t.Fatal(err)
+	}
+	if p := r.Playback(); math.Abs(float64(p.Time)-1) > 1e-3 {
+		t.Errorf("progress 1 is at %vs, want the end", p.Time)
+	}
+	if r.NodeTransforms[0].Rotation.ApproxEqual(quarter) {
+		t.Error("seeking while paused should pose the model at the new time")
+	}
+
+	events := r.TakePlaybackEvents()
+	if len(events) != 2 || events[0].Action != "seek" || events[1].Playback.Progress != 1 {
+		t.Errorf("events %+v, want two seeks ending at progress 1", events)
+	}
+	if len(r.TakePlaybackEvents()) != 0 {
+		t.Error("events should be taken once")
+	}
+}
+
+func TestSeekErrors(t *testing.T) {
+	r := &GLBRenderer{GL: newRecordingGL(), Animations: make(map[string]*Animation)}
+	r.lookupUniforms()
+	if err := r.LoadDocument(flippedRotationClip(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Seek("", 0); err == nil {
+		t.Error("seeking with no animation playing should fail")
+	}
+	if err := r.Seek("Missing", 0); err == nil {
+		t.Error("seeking an unknown animation should fail")
+	}
+	if err := r.Seek("Turn", 2); err == nil {
+		t.Error("seeking past the end should fail")
+	}
+	if err := r.SetNormalizedTime("Turn", -0.5); err == nil {
+		t.Error("negative progress should fail")
+	}
+	if r.CurrentAnim != nil || len(r.TakePlaybackEvents()) != 0 {
+		t.Error("a failed seek should change nothing")
+	}
+}
@@ -0,0 +1,237 @@
+//go:build !nogl
+
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// syncInterval is how often the leader sends its state
+	syncInterval = 100 * time.Millisecond
+	// syncTimeout is how long a follower goes without hearing from the
+	// leader before it plays on its own again
+	syncTimeout = 2 * time.Second
+	// syncMaxDrift is how far a follower's animation may be from the
+	// leader's before it seeks, about a frame
+	syncMaxDrift = 1.0 / 30
+)
+
+// syncState is what a sync leader sends its followers, as JSON over UDP
+type syncState struct {
+	// Leader tells leaders apart, Seq orders one leader's messages
+	Leader int64  `json:"leader"`
+	Seq    uint64 `json:"seq"`
+
+	Animation string  `json:"animation,omitempty"`
+	Time      float32 `json:"time"`
+	Loop      bool    `json:"loop"`
+	Paused    bool    `json:"paused"`
+	// Rotation is the model's turn in radians, changing by RotationRate
+	// radians a second
+	Rotation     float32 `json:"rotation"`
+	RotationRate float32 `json:"rotation_rate"`
+}
+
+// SyncLeader sends the model's animation and rotation to followers on
+// other compositors, so several screens play in lockstep
+type SyncLeader struct {
+	conn *net.UDPConn
+	id   int64
+	seq  uint64
+
+	lastSent     time.Time
+	lastRotation float32
+}
+
+// NewSyncLeader sends to addr, a UDP host:port: a follower's address, a
+// broadcast address or a multicast group
+func NewSyncLeader(addr string) (*SyncLeader, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve sync address: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial sync address: %w", err)
+	}
+	return &SyncLeader{conn: conn, id: time.Now().UnixNano()}, nil
+}
+
+// Tick sends r's state when it is due. Call it every frame.
+func (l *SyncLeader) Tick(r *GLBRenderer, now time.Time) {
+	if now.Sub(l.lastSent) < syncInterval {
+		return
+	}
+	playback := r.Playback()
+	l.seq++
+	state := syncState{
+		Leader:    l.id,
+		Seq:       l.seq,
+		Animation: playback.Name,
+		Time:      playback.Time,
+		Loop:      playback.Loop,
+		Paused:    playback.Paused,
+		Rotation:  r.Rotation,
+	}
+	if !l.lastSent.IsZero() {
+		state.RotationRate = (r.Rotation - l.lastRotation) / float32(now.Sub(l.lastSent).Seconds())
+	}
+	l.lastSent, l.lastRotation = now, r.Rotation
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	// A lost message is made up for by the next one
+	l.conn.Write(data)
+}
+
+// Close stops sending
+func (l *SyncLeader) Close() error {
+	return l.conn.Close()
+}
+
+// SyncFollower plays the model's animation and rotation the way a
+// SyncLeader on another compositor says, extrapolating between messages.
+// It lets the model play on its own while no leader is heard.
+type SyncFollower struct {
+	conn *net.UDPConn
+
+	mu       sync.Mutex
+	state    syncState
+	received time.Time
+	// failed is the animation that could not be played, so it is logged
+	// once
+	failed string
+}
+
+// NewSyncFollower listens on addr, a UDP host:port or a multicast group
+func NewSyncFollower(addr string) (*SyncFollower, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve sync address: %w", err)
+	}
+	var conn *net.UDPConn
+	if udpAddr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", nil, udpAddr)
+	} else {
+		conn, err = net.ListenUDP("udp", udpAddr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listen for sync: %w", err)
+	}
+	f := &SyncFollower{conn: conn}
+	go f.listen()
+	return f, nil
+}
+
+// Addr is the address the follower listens on
+func (f *SyncFollower) Addr() net.Addr {
+	return f.conn.LocalAddr()
+}
+
+// Close stops listening
+func (f *SyncFollower) Close() error {
+	return f.conn.Close()
+}
+
+func (f *SyncFollower) listen() {
+	buf := make([]byte, 2048)
+	for {
+		n, err := f.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		var state syncState
+		if err := json.Unmarshal(buf[:n], &state); err != nil {
+			continue
+		}
+		f.receive(state, time.Now())
+	}
+}
+
+// receive keeps state unless it is older than one already received from
+// the same leader
+func (f *SyncFollower) receive(state syncState, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if state.Leader == f.state.Leader && state.Seq <= f.state.Seq {
+		return
+	}
+	f.state, f.received = state, now
+}
+
+// Apply brings r to where the leader is at now. It returns false, changing
+// nothing, while no leader has been heard for syncTimeout; the caller
+// then turns the model itself.
+func (f *SyncFollower) Apply(r *GLBRenderer, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.received.IsZero() || now.Sub(f.received) > syncTimeout {
+		return false
+	}
+	state := f.state
+	elapsed := float32(now.Sub(f.received).Seconds())
+
+	r.Rotation = state.Rotation + state.RotationRate*elapsed
+	if state.Animation == "" {
+		// An animation played once finishes here on its own, holding its
+		// last pose as the leader's did; stopping it would reset the pose
+		if r.CurrentAnim != nil && r.AnimLoop {
+			r.StopAnimation()
+		}
+		return true
+	}
+
+	target := state.Time
+	if !state.Paused {
+		target += elapsed
+	}
+	anim := r.Animations[state.Animation]
+	if anim != nil && !state.Loop && target >= anim.Duration && r.CurrentAnim == nil {
+		// Played once and finished here as on the leader
+		return true
+	}
+	if r.CurrentAnim != anim || anim == nil || r.AnimLoop != state.Loop {
+		if err := r.PlayAnimation(state.Animation, state.Loop); err != nil {
+			if f.failed != state.Animation {
+				log.Printf("Sync: cannot follow the leader: %v", err)
+				f.failed = state.Animation
+			}
+			return true
+		}
+	}
+	f.failed = ""
+
+	if state.Paused && !r.AnimPaused {
+		r.PauseAnimation()
+	} else if !state.Paused && r.AnimPaused {
+		r.ResumeAnimation()
+	}
+
+	if state.Loop && anim.Duration > 0 {
+		target = float32(math.Mod(float64(target), float64(anim.Duration)))
+	}
+	target = min(max(target, 0), anim.Duration)
+	if syncDrift(r.Playback().Time, target, anim.Duration, state.Loop) > syncMaxDrift {
+		r.Seek("", target)
+	}
+	return true
+}
+
+// syncDrift is how far apart two times in an animation are, the short way
+// round for a looping one
+func syncDrift(a, b, duration float32, loop bool) float32 {
+	d := float32(math.Abs(float64(a - b)))
+	if loop && duration > 0 {
+		d = min(d, duration-d)
+	}
+	return d
+}
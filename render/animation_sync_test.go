@@ -0,0 +1,101 @@
+//go:build !nogl
+
+package render
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func syncTestRenderer(t *testing.T) *GLBRenderer {
+	t.Helper()
+	r := &GLBRenderer{GL: newRecordingGL(), Animations: make(map[string]*Animation)}
+	r.lookupUniforms()
+	if err := r.LoadDocument(flippedRotationClip(t)); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestSyncFollowerApply(t *testing.T) {
+	r := syncTestRenderer(t)
+	f := &SyncFollower{}
+	now := time.Now()
+	if f.Apply(r, now) {
+		t.Error("a follower that heard no leader should leave the model alone")
+	}
+
+	f.receive(syncState{Leader: 1, Seq: 2, Animation: "Turn", Time: 0.4, Loop: true, Rotation: 1, RotationRate: 2}, now.Add(-100*time.Millisecond))
+	// An older message is ignored
+	f.receive(syncState{Leader: 1, Seq: 1, Animation: "Other"}, now)
+	if !f.Apply(r, now) {
+		t.Fatal("the follower did not apply the leader's state")
+	}
+	if math.Abs(float64(r.Rotation)-1.2) > 1e-3 {
+		t.Errorf("rotation %v, want the leader's extrapolated to 1.2", r.Rotation)
+	}
+	p := r.Playback()
+	if p.Name != "Turn" || !p.Loop || math.Abs(float64(p.Time)-0.5) > 0.01 {
+		t.Errorf("following %+v, want Turn looping at 0.5s", p)
+	}
+
+	// A looping animation wraps round
+	f.receive(syncState{Leader: 1, Seq: 3, Animation: "Turn", Time: 0.95, Loop: true}, now.Add(-100*time.Millisecond))
+	f.Apply(r, now)
+	if p := r.Playback(); math.Abs(float64(p.Time)-0.05) > 0.01 {
+		t.Errorf("time %v after wrapping, want 0.05", p.Time)
+	}
+
+	// A new leader is followed at once, paused where it is
+	f.receive(syncState{Leader: 2, Seq: 1, Animation: "Turn", Time: 0.25, Paused: true}, now.Add(-time.Second))
+	f.Apply(r, now)
+	if p := r.Playback(); !p.Paused || p.Loop || math.Abs(float64(p.Time)-0.25) > 1e-3 {
+		t.Errorf("following a paused leader: %+v", p)
+	}
+
+	if f.Apply(r, now.Add(syncTimeout)) {
+		t.Error("the follower should play on its own once the leader goes quiet")
+	}
+}
+
+func TestSyncDrift(t *testing.T) {
+	if d := syncDrift(0.05, 0.95, 1, true); math.Abs(float64(d)-0.1) > 1e-6 {
+		t.Errorf("looping drift %v, want 0.1 across the loop", d)
+	}
+	if d := syncDrift(0.05, 0.95, 1, false); math.Abs(float64(d)-0.9) > 1e-6 {
+		t.Errorf("drift %v, want 0.9", d)
+	}
+}
+
+func TestSyncOverUDP(t *testing.T) {
+	follower, err := NewSyncFollower("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+	leader, err := NewSyncLeader(follower.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+
+	source := syncTestRenderer(t)
+	if err := source.PlayAnimation("Turn", true); err != nil {
+		t.Fatal(err)
+	}
+	source.Rotation = 0.5
+	leader.Tick(source, time.Now())
+
+	deadline := time.Now().Add(time.Second)
+	target := syncTestRenderer(t)
+	for !follower.Apply(target, time.Now()) {
+		if time.Now().After(deadline) {
+			t.Fatal("the follower heard nothing")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if target.CurrentAnim == nil || target.CurrentAnim.Name != "Turn" || target.Rotation != 0.5 {
+		t.Errorf("follower plays %v at rotation %v", target.CurrentAnim, target.Rotation)
+	}
+}
@@ -0,0 +1,43 @@
+package render
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/qmuntal/gltf"
+)
+
+// boneMask marks the nodes selected by node-name globs (path.Match syntax)
+// and every node below them, so naming a shoulder selects the whole arm
+// chain. Each glob has to match at least one node.
+func boneMask(doc *gltf.Document, globs []string) ([]bool, error) {
+	mask := make([]bool, len(doc.Nodes))
+	var pending []int
+	for _, glob := range globs {
+		matched := false
+		for i, node := range doc.Nodes {
+			ok, err := path.Match(glob, node.Name)
+			if err != nil {
+				return nil, fmt.Errorf("bone glob %q: %w", glob, err)
+			}
+			if ok {
+				matched = true
+				pending = append(pending, i)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("bone glob %q matches no node", glob)
+		}
+	}
+
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if node < 0 || node >= len(mask) || mask[node] {
+			continue
+		}
+		mask[node] = true
+		pending = append(pending, doc.Nodes[node].Children...)
+	}
+	return mask, nil
+}
@@ -0,0 +1,41 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qmuntal/gltf"
+)
+
+func TestBoneMask(t *testing.T) {
+	doc := &gltf.Document{Nodes: []*gltf.Node{
+		{Name: "Hips", Children: []int{1, 3}},
+		{Name: "RightShoulder", Children: []int{2}},
+		{Name: "RightHand"},
+		{Name: "LeftShoulder", Children: []int{4}},
+		{Name: "LeftHand"},
+	}}
+
+	mask, err := boneMask(doc, []string{"Right*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{false, true, true, false, false}; !reflect.DeepEqual(mask, want) {
+		t.Errorf("Expected %v, got %v", want, mask)
+	}
+
+	mask, err = boneMask(doc, []string{"LeftHand", "RightHand"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{false, false, true, false, true}; !reflect.DeepEqual(mask, want) {
+		t.Errorf("Expected %v, got %v", want, mask)
+	}
+
+	if _, err := boneMask(doc, []string{"Tail*"}); err == nil {
+		t.Error("Expected an error for a glob matching nothing")
+	}
+	if _, err := boneMask(doc, []string{"[Right"}); err == nil {
+		t.Error("Expected an error for a malformed glob")
+	}
+}
@@ -0,0 +1,216 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qmuntal/gltf"
+)
+
+// BuiltinModelPrefix marks a -model that is generated rather than loaded,
+// e.g. "builtin:curved-27in"
+const BuiltinModelPrefix = "builtin:"
+
+// curvedRadius is the curvature of the curved monitor, 1800R in meters
+const curvedRadius = 1.8
+
+// casingUV is where the back and sides of a display sample the desktop.
+// The model has one texture, so they take the color of its corner pixel.
+var casingUV = [2]float32{0.002, 0.002}
+
+// builtinShape generates a display mesh with a screen of the given size,
+// in meters
+type builtinShape struct {
+	diagonal float32 // inches
+	aspect   [2]float32
+	build    func(m *meshBuilder, width, height float32)
+}
+
+// builtinShapes are the models -model builtin:<name> can generate
+var builtinShapes = map[string]builtinShape{
+	"plane":  {27, [2]float32{16, 9}, buildPlane},
+	"curved": {27, [2]float32{16, 9}, buildCurved},
+	"crt":    {14, [2]float32{4, 3}, buildCRT},
+	"cube":   {27, [2]float32{1, 1}, buildCube},
+}
+
+// builtinModel generates the model named by spec, a shape optionally
+// followed by "-<diagonal>in" and "-<w>x<h>" for the screen's size and
+// aspect ratio, e.g. "curved-34in-21x9". Like imported models it is scaled
+// to importedModelSize, so the size only matters for the curved monitor's
+// curvature.
+func builtinModel(spec string) (*gltf.Document, error) {
+	name, options, _ := strings.Cut(spec, "-")
+	shape, ok := builtinShapes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown builtin model %q, want plane, curved, crt or cube", name)
+	}
+	if options != "" {
+		for _, option := range strings.Split(options, "-") {
+			if inches, ok := strings.CutSuffix(option, "in"); ok {
+				d, err := strconv.ParseFloat(inches, 32)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("invalid builtin model size %q", option)
+				}
+				shape.diagonal = float32(d)
+				continue
+			}
+			w, h, ok := strings.Cut(option, "x")
+			aw, errW := strconv.ParseFloat(w, 32)
+			ah, errH := strconv.ParseFloat(h, 32)
+			if !ok || errW != nil || errH != nil || aw <= 0 || ah <= 0 {
+				return nil, fmt.Errorf("invalid builtin model option %q, want e.g. 27in or 16x9", option)
+			}
+			shape.aspect = [2]float32{float32(aw), float32(ah)}
+		}
+	}
+
+	const metersPerInch = 0.0254
+	diagonal := shape.diagonal * metersPerInch
+	hyp := float32(math.Hypot(float64(shape.aspect[0]), float64(shape.aspect[1])))
+	var m meshBuilder
+	shape.build(&m, diagonal*shape.aspect[0]/hyp, diagonal*shape.aspect[1]/hyp)
+	return m.mesh.document(), nil
+}
+
+// meshBuilder collects the triangles of a generated model. Normals are
+// left to importedMesh.document.
+type meshBuilder struct {
+	mesh importedMesh
+}
+
+// grid adds a surface of cols x rows quads. at and uv map u (0 left to 1
+// right) and v (0 top to 1 bottom) to a position and texture coordinate;
+// the surface faces the way a screen at z = 0 facing +z would.
+func (m *meshBuilder) grid(cols, rows int, at func(u, v float32) [3]float32, uv func(u, v float32) [2]float32) {
+	base := uint32(len(m.mesh.positions))
+	for row := 0; row <= rows; row++ {
+		for col := 0; col <= cols; col++ {
+			u, v := float32(col)/float32(cols), float32(row)/float32(rows)
+			m.mesh.positions = append(m.mesh.positions, at(u, v))
+			m.mesh.uvs = append(m.mesh.uvs, uv(u, v))
+		}
+	}
+	stride := uint32(cols + 1)
+	for row := range uint32(rows) {
+		for col := range uint32(cols) {
+			a := base + row*stride + col
+			b, c := a+1, a+stride
+			m.mesh.indices = append(m.mesh.indices, a, c, b, b, c, c+1)
+		}
+	}
+}
+
+// quad adds a casing quad, turned to face away from centre
+func (m *meshBuilder) quad(corners [4][3]float32, centre mgl32.Vec3) {
+	a, b, c := mgl32.Vec3(corners[0]), mgl32.Vec3(corners[1]), mgl32.Vec3(corners[2])
+	mid := a.Add(c).Mul(0.5)
+	if b.Sub(a).Cross(c.Sub(a)).Dot(mid.Sub(centre)) < 0 {
+		corners[1], corners[3] = corners[3], corners[1]
+	}
+	base := uint32(len(m.mesh.positions))
+	for _, p := range corners {
+		m.mesh.positions = append(m.mesh.positions, p)
+		m.mesh.uvs = append(m.mesh.uvs, casingUV)
+	}
+	m.mesh.indices = append(m.mesh.indices, base, base+1, base+2, base, base+2, base+3)
+}
+
+func screenUV(u, v float32) [2]float32 {
+	return [2]float32{u, v}
+}
+
+func casing(u, v float32) [2]float32 {
+	return casingUV
+}
+
+// buildPlane is a flat screen with a plain back
+func buildPlane(m *meshBuilder, width, height float32) {
+	at := func(u, v float32) [3]float32 {
+		return [3]float32{(u - 0.5) * width, (0.5 - v) * height, 0}
+	}
+	m.grid(1, 1, at, screenUV)
+	m.grid(1, 1, func(u, v float32) [3]float32 { return at(1-u, v) }, casing)
+}
+
+// buildCurved is a 1800R monitor curved around the viewer, 2cm thick
+func buildCurved(m *meshBuilder, width, height float32) {
+	const depth = 0.02
+	const segments = 48
+	// at is a point on the arc at radius r from the centre of curvature,
+	// which is in front of the screen
+	arc := func(r float32) func(u, v float32) [3]float32 {
+		return func(u, v float32) [3]float32 {
+			angle := float64((u - 0.5) * width / curvedRadius)
+			return [3]float32{
+				r * float32(math.Sin(angle)),
+				(0.5 - v) * height,
+				curvedRadius - r*float32(math.Cos(angle)),
+			}
+		}
+	}
+	front, back := arc(curvedRadius), arc(curvedRadius+depth)
+	m.grid(segments, 1, front, screenUV)
+	m.grid(segments, 1, func(u, v float32) [3]float32 { return back(1-u, v) }, casing)
+
+	centre := mgl32.Vec3{0, 0, -depth}
+	for i := range segments {
+		u0, u1 := float32(i)/segments, float32(i+1)/segments
+		for _, v := range []float32{0, 1} {
+			m.quad([4][3]float32{front(u0, v), front(u1, v), back(u1, v), back(u0, v)}, centre)
+		}
+	}
+	for _, u := range []float32{0, 1} {
+		m.quad([4][3]float32{front(u, 0), front(u, 1), back(u, 1), back(u, 0)}, centre)
+	}
+}
+
+// buildCRT is a bulging 4:3 tube in a bezel, on a body that tapers to the
+// back
+func buildCRT(m *meshBuilder, width, height float32) {
+	bulge := 0.04 * width
+	m.grid(24, 18, func(u, v float32) [3]float32 {
+		x, y := 2*u-1, 2*v-1
+		return [3]float32{(u - 0.5) * width, (0.5 - v) * height, bulge * (1 - x*x) * (1 - y*y)}
+	}, screenUV)
+
+	margin := 0.08 * width
+	depth := 0.9 * width
+	rect := func(w, h, z float32) [4][3]float32 {
+		return [4][3]float32{{-w / 2, h / 2, z}, {w / 2, h / 2, z}, {w / 2, -h / 2, z}, {-w / 2, -h / 2, z}}
+	}
+	screen := rect(width, height, 0)
+	bezel := rect(width+2*margin, height+2*margin, 0)
+	bezelBack := rect(width+2*margin, height+2*margin, -0.15*depth)
+	tail := rect(0.5*width, 0.5*height, -depth)
+	centre := mgl32.Vec3{0, 0, -depth / 2}
+	for i := range 4 {
+		j := (i + 1) % 4
+		m.quad([4][3]float32{screen[i], screen[j], bezel[j], bezel[i]}, mgl32.Vec3{0, 0, -1})
+		m.quad([4][3]float32{bezel[i], bezel[j], bezelBack[j], bezelBack[i]}, centre)
+		m.quad([4][3]float32{bezelBack[i], bezelBack[j], tail[j], tail[i]}, centre)
+	}
+	m.quad(tail, mgl32.Vec3{0, 0, 0})
+}
+
+// buildCube shows the whole desktop on each face of a cube
+func buildCube(m *meshBuilder, width, _ float32) {
+	// Each face by its centre, right and down directions
+	faces := [6][3]mgl32.Vec3{
+		{{0, 0, 1}, {1, 0, 0}, {0, -1, 0}},
+		{{0, 0, -1}, {-1, 0, 0}, {0, -1, 0}},
+		{{1, 0, 0}, {0, 0, -1}, {0, -1, 0}},
+		{{-1, 0, 0}, {0, 0, 1}, {0, -1, 0}},
+		{{0, 1, 0}, {1, 0, 0}, {0, 0, 1}},
+		{{0, -1, 0}, {1, 0, 0}, {0, 0, -1}},
+	}
+	half := width / 2
+	for _, f := range faces {
+		m.grid(1, 1, func(u, v float32) [3]float32 {
+			return f[0].Add(f[1].Mul(2*u - 1)).Add(f[2].Mul(2*v - 1)).Mul(half)
+		}, screenUV)
+	}
+}
@@ -0,0 +1,66 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestBuiltinModels(t *testing.T) {
+	for _, spec := range []string{"plane", "curved", "curved-34in-21x9", "crt", "cube"} {
+		doc, err := OpenModel(BuiltinModelPrefix + spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		positions, normals, uvs, indices := importedAttributes(t, doc)
+		if len(indices) == 0 || len(indices)%3 != 0 {
+			t.Errorf("%s: %d indices", spec, len(indices))
+		}
+		lo, hi := [2]float32{1, 1}, [2]float32{0, 0}
+		for _, uv := range uvs {
+			for i := range 2 {
+				lo[i], hi[i] = min(lo[i], uv[i]), max(hi[i], uv[i])
+			}
+		}
+		if lo != [2]float32{0, 0} || hi != [2]float32{1, 1} {
+			t.Errorf("%s: uvs span %v to %v, want the whole desktop", spec, lo, hi)
+		}
+		if spec == "cube" {
+			// Every face faces out
+			for i, p := range positions {
+				if mgl32.Vec3(normals[i]).Dot(mgl32.Vec3(p)) <= 0 {
+					t.Errorf("cube: normal %v at %v faces in", normals[i], p)
+					break
+				}
+			}
+		}
+	}
+
+	doc, _ := builtinModel("plane")
+	_, normals, _, _ := importedAttributes(t, doc)
+	if normals[0] != [3]float32{0, 0, 1} {
+		t.Errorf("plane screen normal %v, want facing the camera", normals[0])
+	}
+
+	for _, spec := range []string{"sphere", "plane-big", "curved-0in", "crt-4x0"} {
+		if _, err := builtinModel(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestCurvedModelFacesViewer(t *testing.T) {
+	doc, _ := builtinModel("curved")
+	positions, normals, uvs, _ := importedAttributes(t, doc)
+	// The screen's left edge comes towards the camera and faces the middle
+	for i, uv := range uvs {
+		if uv == [2]float32{0, 0} {
+			if normals[i][0] <= 0 || normals[i][2] <= 0 || positions[i][2] <= 0 {
+				t.Errorf("left edge at %v has normal %v", positions[i], normals[i])
+			}
+			return
+		}
+	}
+	t.Error("no screen corner")
+}
@@ -0,0 +1,32 @@
+//go:build !nogl
+
+package render
+
+import "github.com/go-gl/gl/v4.1-core/gl"
+
+// graphicsResetStatus returns the robustness extension's reset query for
+// the current context, nil when the driver has neither extension
+func graphicsResetStatus() func() uint32 {
+	switch {
+	case hasGLExtension("GL_KHR_robustness"):
+		return gl.GetGraphicsResetStatusKHR
+	case hasGLExtension("GL_ARB_robustness"):
+		return gl.GetGraphicsResetStatusARB
+	}
+	return nil
+}
+
+// contextResetReason says why a context was lost from its reset status,
+// "" when it was not
+func contextResetReason(status uint32) string {
+	switch status {
+	case gl.NO_ERROR:
+		return ""
+	case gl.GUILTY_CONTEXT_RESET:
+		return "OpenGL context reset by its own rendering"
+	case gl.INNOCENT_CONTEXT_RESET:
+		return "OpenGL context reset by another process"
+	default:
+		return "OpenGL context reset"
+	}
+}
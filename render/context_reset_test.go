@@ -0,0 +1,20 @@
+//go:build !nogl
+
+package render
+
+import (
+	"testing"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+func TestContextResetReason(t *testing.T) {
+	if reason := contextResetReason(gl.NO_ERROR); reason != "" {
+		t.Errorf("Expected no reason for a working context, got %q", reason)
+	}
+	for _, status := range []uint32{gl.GUILTY_CONTEXT_RESET, gl.INNOCENT_CONTEXT_RESET, gl.UNKNOWN_CONTEXT_RESET} {
+		if contextResetReason(status) == "" {
+			t.Errorf("Expected a reason for reset status %#x", status)
+		}
+	}
+}
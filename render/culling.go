@@ -0,0 +1,166 @@
+//go:build !nogl
+
+package render
+
+import (
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// frustum is the six planes around what the camera sees. A point is on
+// the inside of plane a, b, c, d when a*x + b*y + c*z + d >= 0.
+type frustum [6]mgl32.Vec4
+
+// newFrustum extracts the planes of a projection times view matrix
+func newFrustum(m mgl32.Mat4) frustum {
+	row := func(i int) mgl32.Vec4 { return mgl32.Vec4{m[i], m[4+i], m[8+i], m[12+i]} }
+	r0, r1, r2, r3 := row(0), row(1), row(2), row(3)
+	return frustum{r3.Add(r0), r3.Sub(r0), r3.Add(r1), r3.Sub(r1), r3.Add(r2), r3.Sub(r2)}
+}
+
+// intersects reports whether the box from lo to hi may be in view. Boxes
+// near a corner of the frustum can pass without being seen, never the
+// other way round.
+func (f frustum) intersects(lo, hi mgl32.Vec3) bool {
+	for _, plane := range f {
+		// The corner furthest along the plane's normal
+		corner := lo
+		for i := range 3 {
+			if plane[i] >= 0 {
+				corner[i] = hi[i]
+			}
+		}
+		if plane.Vec3().Dot(corner)+plane[3] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// transformBounds returns the box around the box from lo to hi moved by m
+func transformBounds(m mgl32.Mat4, lo, hi mgl32.Vec3) (mgl32.Vec3, mgl32.Vec3) {
+	outLo, outHi := m.Col(3).Vec3(), m.Col(3).Vec3()
+	for row := range 3 {
+		for col := range 3 {
+			a, b := m.At(row, col)*lo[col], m.At(row, col)*hi[col]
+			outLo[row] += min(a, b)
+			outHi[row] += max(a, b)
+		}
+	}
+	return outLo, outHi
+}
+
+// positionBounds returns the lowest and highest corners of positions
+func positionBounds(positions [][3]float32) [2]mgl32.Vec3 {
+	if len(positions) == 0 {
+		return [2]mgl32.Vec3{}
+	}
+	lo, hi := mgl32.Vec3(positions[0]), mgl32.Vec3(positions[0])
+	for _, p := range positions {
+		for i := range 3 {
+			lo[i], hi[i] = min(lo[i], p[i]), max(hi[i], p[i])
+		}
+	}
+	return [2]mgl32.Vec3{lo, hi}
+}
+
+// nodeWorldTransforms returns every node's transform in the model, its
+// parents' applied
+func (r *GLBRenderer) nodeWorldTransforms() []mgl32.Mat4 {
+	world := make([]mgl32.Mat4, len(r.NodeTransforms))
+	done := make([]bool, len(world))
+	var visit func(node int) mgl32.Mat4
+	visit = func(node int) mgl32.Mat4 {
+		if !done[node] {
+			world[node] = r.getNodeTransformMatrix(node)
+			if parent := r.NodeParents[node]; parent >= 0 {
+				world[node] = visit(parent).Mul4(world[node])
+			}
+			done[node] = true
+		}
+		return world[node]
+	}
+	for node := range world {
+		visit(node)
+	}
+	return world
+}
+
+// meshDraw is a mesh to draw this frame, at the transforms of its nodes
+// that are in view
+type meshDraw struct {
+	mesh  *Mesh
+	nodes []mgl32.Mat4
+	// distance from the camera to the nearest of them
+	distance float32
+}
+
+// drawList culls the meshes against the camera and puts them in the order
+// to draw: opaque ones nearest first, so what they hide fails the depth
+// test early, then transparent ones furthest first, so they blend over
+// what is behind them. Skinned meshes are posed by their joints rather
+// than their node and are always drawn.
+func (r *GLBRenderer) drawList(projection, view, base mgl32.Mat4) []meshDraw {
+	visible := newFrustum(projection.Mul4(view))
+	eye := view.Inv().Col(3).Vec3()
+	world := r.nodeWorldTransforms()
+
+	distance := func(m mgl32.Mat4, mesh *Mesh) float32 {
+		lo, hi := transformBounds(base.Mul4(m), mesh.Bounds[0], mesh.Bounds[1])
+		return lo.Add(hi).Mul(0.5).Sub(eye).Len()
+	}
+
+	draws := make([]meshDraw, 0, len(r.Meshes))
+	for i := range r.Meshes {
+		mesh := &r.Meshes[i]
+		if mesh.SkinIndex >= 0 && mesh.SkinIndex < len(r.Skins) {
+			identity := mgl32.Ident4()
+			draws = append(draws, meshDraw{mesh, []mgl32.Mat4{identity}, distance(identity, mesh)})
+			continue
+		}
+
+		d := meshDraw{mesh: mesh}
+		for _, node := range mesh.Nodes {
+			m := mgl32.Ident4()
+			if node >= 0 && node < len(world) {
+				m = world[node]
+			}
+			// Morphing can move vertices out of the loaded bounds
+			if mesh.morph == nil {
+				lo, hi := transformBounds(base.Mul4(m), mesh.Bounds[0], mesh.Bounds[1])
+				if !visible.intersects(lo, hi) {
+					continue
+				}
+			}
+			d.nodes = append(d.nodes, m)
+		}
+		if len(d.nodes) == 0 {
+			continue
+		}
+		if mesh.Transparent {
+			// Blend this mesh's own instances back to front too
+			sort.SliceStable(d.nodes, func(a, b int) bool {
+				return distance(d.nodes[a], mesh) > distance(d.nodes[b], mesh)
+			})
+			d.distance = distance(d.nodes[len(d.nodes)-1], mesh)
+		} else {
+			d.distance = distance(d.nodes[0], mesh)
+			for _, m := range d.nodes[1:] {
+				d.distance = min(d.distance, distance(m, mesh))
+			}
+		}
+		draws = append(draws, d)
+	}
+
+	sort.SliceStable(draws, func(a, b int) bool {
+		if draws[a].mesh.Transparent != draws[b].mesh.Transparent {
+			return !draws[a].mesh.Transparent
+		}
+		if draws[a].mesh.Transparent {
+			return draws[a].distance > draws[b].distance
+		}
+		return draws[a].distance < draws[b].distance
+	})
+	return draws
+}
@@ -0,0 +1,118 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qmuntal/gltf"
+)
+
+func TestFrustumIntersects(t *testing.T) {
+	projection, view := camera(800, 600)
+	f := newFrustum(projection.Mul4(view))
+
+	box := func(center mgl32.Vec3) (mgl32.Vec3, mgl32.Vec3) {
+		half := mgl32.Vec3{0.1, 0.1, 0.1}
+		return center.Sub(half), center.Add(half)
+	}
+	for _, tc := range []struct {
+		center mgl32.Vec3
+		want   bool
+	}{
+		{mgl32.Vec3{0, 0, 0}, true},
+		{mgl32.Vec3{0.3, 0.2, 0}, true},
+		{mgl32.Vec3{5, 0, 0}, false},
+		{mgl32.Vec3{0, -5, 0}, false},
+		{mgl32.Vec3{0, 0, 3}, false},    // behind the camera
+		{mgl32.Vec3{0, 0, -200}, false}, // past the far plane
+	} {
+		if got := f.intersects(box(tc.center)); got != tc.want {
+			t.Errorf("box at %v in view = %v, want %v", tc.center, got, tc.want)
+		}
+	}
+}
+
+func TestTransformBounds(t *testing.T) {
+	m := mgl32.Translate3D(1, 0, 0).Mul4(mgl32.HomogRotate3DZ(mgl32.DegToRad(90)))
+	lo, hi := transformBounds(m, mgl32.Vec3{0, 0, 0}, mgl32.Vec3{2, 1, 1})
+	wantLo, wantHi := mgl32.Vec3{0, 0, 0}, mgl32.Vec3{1, 2, 1}
+	if lo.Sub(wantLo).Len() > 1e-5 || hi.Sub(wantHi).Len() > 1e-5 {
+		t.Errorf("got %v to %v, want %v to %v", lo, hi, wantLo, wantHi)
+	}
+}
+
+func TestDrawListCullsAndSorts(t *testing.T) {
+	unit := [2]mgl32.Vec3{{-0.05, -0.05, -0.05}, {0.05, 0.05, 0.05}}
+	at := func(z float32) NodeTransform {
+		return NodeTransform{Translation: mgl32.Vec3{0, 0, z}, Rotation: mgl32.QuatIdent(), Scale: mgl32.Vec3{1, 1, 1}}
+	}
+	r := &GLBRenderer{
+		NodeTransforms: []NodeTransform{at(-0.5), at(0), at(-2), at(0.5), at(30)},
+		NodeParents:    []int{-1, -1, -1, -1, -1},
+		Meshes: []Mesh{
+			{Nodes: []int{0}, Bounds: unit, SkinIndex: -1},
+			{Nodes: []int{2, 1}, Bounds: unit, SkinIndex: -1},
+			{Nodes: []int{1}, Bounds: unit, SkinIndex: -1, Transparent: true},
+			{Nodes: []int{2}, Bounds: unit, SkinIndex: -1, Transparent: true},
+			{Nodes: []int{4}, Bounds: unit, SkinIndex: -1}, // behind the camera
+		},
+	}
+	projection, view := camera(800, 600)
+	draws := r.drawList(projection, view, mgl32.Ident4())
+
+	var order []int
+	for _, d := range draws {
+		order = append(order, d.mesh.Nodes[0])
+	}
+	// Opaque nearest first: mesh 1 has a node at 0, mesh 0 is at -0.5.
+	// Then transparent furthest first.
+	if fmt.Sprint(order) != "[2 0 2 1]" {
+		t.Errorf("drawn meshes by first node %v, want [2 0 2 1]", order)
+	}
+	if len(draws[0].nodes) != 2 {
+		t.Errorf("mesh drawn by two nodes in view has %d instances", len(draws[0].nodes))
+	}
+}
+
+func TestSharedMeshIsDrawnInstanced(t *testing.T) {
+	doc, err := builtinModel("cube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []float64{0.2, -0.2, 50} {
+		doc.Nodes = append(doc.Nodes, &gltf.Node{Mesh: gltf.Index(0), Translation: [3]float64{x, 0, -1}})
+		doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, len(doc.Nodes)-1)
+	}
+
+	fake := newRecordingGL()
+	r := &GLBRenderer{GL: fake, Animations: make(map[string]*Animation), Brightness: 1, Tint: mgl32.Vec3{1, 1, 1}}
+	r.lookupUniforms()
+	if err := r.LoadDocument(doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Meshes) != 1 || len(r.Meshes[0].Nodes) != 4 {
+		t.Fatalf("expected one mesh for four nodes, got %d meshes", len(r.Meshes))
+	}
+	if fake.count("GenVertexArrays") != 1 || fake.count("VertexAttribDivisor") != 4 {
+		t.Error("a shared mesh should be uploaded once, with a per-instance transform")
+	}
+
+	fake.calls = nil
+	r.Render(800, 600)
+	// The node at x=50 is out of view
+	want := fmt.Sprintf("DrawElementsInstanced %d x3", r.Meshes[0].IndexCount)
+	if fake.count(want) != 1 || fake.count("DrawElements ") != 0 {
+		t.Errorf("expected a single %q, got %v", want, fake.calls)
+	}
+
+	// Without the instanced uniform each node is drawn on its own
+	r.instancedLoc = -1
+	fake.calls = nil
+	r.Render(800, 600)
+	if got := fake.count("DrawElements "); got != 3 {
+		t.Errorf("%d draws for three nodes in view without instancing", got)
+	}
+}
@@ -0,0 +1,328 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// TransitionKind is the kind of desktop switch that starts a transition
+type TransitionKind string
+
+const (
+	// TransitionOpen is the first app appearing on an empty desktop
+	TransitionOpen TransitionKind = "open"
+	// TransitionClose is the last app leaving the desktop
+	TransitionClose TransitionKind = "close"
+	// TransitionApp is a different app's window becoming the topmost one
+	TransitionApp TransitionKind = "app"
+)
+
+// TransitionEffect selects the shader used to blend the old and new frames.
+// The values are passed to the shader as the effect uniform.
+type TransitionEffect int32
+
+const (
+	EffectNone TransitionEffect = iota
+	EffectCrossfade
+	EffectCube
+	EffectGlitch
+)
+
+var transitionEffectNames = map[string]TransitionEffect{
+	"none":      EffectNone,
+	"crossfade": EffectCrossfade,
+	"cube":      EffectCube,
+	"glitch":    EffectGlitch,
+}
+
+const transitionVertexShaderSource = `
+#version 410 core
+layout (location = 0) in vec2 aPos;
+
+out vec2 TexCoord;
+
+void main() {
+    gl_Position = vec4(aPos * 2.0 - 1.0, 0.0, 1.0);
+    TexCoord = aPos;
+}
+` + "\x00"
+
+const transitionFragmentShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform sampler2D previousFrame;
+uniform sampler2D currentFrame;
+uniform int effect;
+uniform float progress;
+uniform float seed;
+
+float hash(float n) {
+    return fract(sin(n * 12.9898) * 43758.5453);
+}
+
+// One face of a cube spinning left: the outgoing face shrinks towards the
+// left edge while the incoming face grows from the right, each face
+// foreshortened towards its far edge.
+vec4 cube(vec2 uv, float t) {
+    float edge = 1.0 - smoothstep(0.0, 1.0, t);
+    if (uv.x < edge) {
+        float u = uv.x / edge;
+        float depth = mix(1.0 - 0.2 * t, 1.0, u);
+        float v = (uv.y - 0.5) / depth + 0.5;
+        if (v < 0.0 || v > 1.0) {
+            return vec4(0.0);
+        }
+        return texture(previousFrame, vec2(u, v)) * mix(1.0, 0.5, t);
+    }
+    float u = (uv.x - edge) / max(1.0 - edge, 0.0001);
+    float depth = mix(1.0, 1.0 - 0.2 * (1.0 - t), u);
+    float v = (uv.y - 0.5) / depth + 0.5;
+    if (v < 0.0 || v > 1.0) {
+        return vec4(0.0);
+    }
+    return texture(currentFrame, vec2(u, v)) * mix(0.5, 1.0, t);
+}
+
+// Horizontal bands jump sideways with split color channels, switching from
+// the old frame to the new one band by band.
+vec4 glitch(vec2 uv, float t) {
+    float intensity = sin(t * 3.14159265);
+    float band = floor(uv.y * 40.0);
+    float r = hash(band + seed + floor(t * 12.0));
+    vec2 shifted = vec2(uv.x + (r - 0.5) * 0.1 * intensity, uv.y);
+    vec2 split = vec2(0.01 * intensity, 0.0);
+    if (r < t) {
+        return vec4(texture(currentFrame, shifted + split).r,
+                    texture(currentFrame, shifted).g,
+                    texture(currentFrame, shifted - split).b,
+                    texture(currentFrame, shifted).a);
+    }
+    return vec4(texture(previousFrame, shifted + split).r,
+                texture(previousFrame, shifted).g,
+                texture(previousFrame, shifted - split).b,
+                texture(previousFrame, shifted).a);
+}
+
+void main() {
+    if (effect == 2) {
+        FragColor = cube(TexCoord, progress);
+    } else if (effect == 3) {
+        FragColor = glitch(TexCoord, progress);
+    } else {
+        FragColor = mix(texture(previousFrame, TexCoord), texture(currentFrame, TexCoord), progress);
+    }
+}
+` + "\x00"
+
+// SwitchDetector watches the topmost toplevel on the desktop and reports
+// when it changes to another app
+type SwitchDetector struct {
+	active  *wayland.Client
+	started bool
+}
+
+// Detect returns the kind of switch since the last call, if any. placed is
+// in draw order (bottom first).
+func (d *SwitchDetector) Detect(placed []PlacedSurface) (TransitionKind, bool) {
+	var active *wayland.Client
+	for i := len(placed) - 1; i >= 0; i-- {
+		if _, ok := placed[i].Surface.Role.(*wayland.SurfaceRoleXdgToplevel); ok {
+			active = placed[i].Client
+			break
+		}
+	}
+
+	previous, started := d.active, d.started
+	d.active, d.started = active, true
+	switch {
+	case !started || active == previous:
+		return "", false
+	case previous == nil:
+		return TransitionOpen, true
+	case active == nil:
+		return TransitionClose, true
+	default:
+		return TransitionApp, true
+	}
+}
+
+// DesktopTransition renders a short animation between the desktop frame
+// shown before a switch and the live one, into a framebuffer whose color
+// texture is sampled by the model while the transition runs.
+type DesktopTransition struct {
+	Width        int32
+	Height       int32
+	Duration     time.Duration
+	Effects      map[TransitionKind]TransitionEffect
+	FBO          uint32
+	ColorTexture uint32
+
+	previous    uint32
+	readFBO     uint32
+	program     uint32
+	quadVAO     uint32
+	quadVBO     uint32
+	previousLoc int32
+	currentLoc  int32
+	effectLoc   int32
+	progressLoc int32
+	seedLoc     int32
+
+	effect TransitionEffect
+	start  time.Time
+	end    time.Time
+}
+
+// NewDesktopTransition creates transitions for a width x height desktop
+func NewDesktopTransition(width, height int32, duration time.Duration, effects map[TransitionKind]TransitionEffect, programs *ProgramCache) (*DesktopTransition, error) {
+	t := &DesktopTransition{
+		Width:    width,
+		Height:   height,
+		Duration: duration,
+		Effects:  effects,
+	}
+
+	program, err := newShaderProgram(programs, transitionVertexShaderSource, transitionFragmentShaderSource)
+	if err != nil {
+		return nil, fmt.Errorf("transition shader: %w", err)
+	}
+	t.program = program
+	t.previousLoc = gl.GetUniformLocation(program, gl.Str("previousFrame\x00"))
+	t.currentLoc = gl.GetUniformLocation(program, gl.Str("currentFrame\x00"))
+	t.effectLoc = gl.GetUniformLocation(program, gl.Str("effect\x00"))
+	t.progressLoc = gl.GetUniformLocation(program, gl.Str("progress\x00"))
+	t.seedLoc = gl.GetUniformLocation(program, gl.Str("seed\x00"))
+
+	quad := []float32{0, 0, 1, 0, 0, 1, 1, 1}
+	gl.GenVertexArrays(1, &t.quadVAO)
+	gl.BindVertexArray(t.quadVAO)
+	gl.GenBuffers(1, &t.quadVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, t.quadVBO)
+	gl.BufferData(gl.ARRAY_BUFFER, len(quad)*4, gl.Ptr(quad), gl.STATIC_DRAW)
+	gl.VertexAttribPointerWithOffset(0, 2, gl.FLOAT, false, 2*4, 0)
+	gl.EnableVertexAttribArray(0)
+	gl.BindVertexArray(0)
+
+	t.previous = newSurfaceGLTexture()
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	t.ColorTexture = newSurfaceGLTexture()
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+
+	gl.GenFramebuffers(1, &t.readFBO)
+	gl.GenFramebuffers(1, &t.FBO)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, t.FBO)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, t.ColorTexture, 0)
+	status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	if status != gl.FRAMEBUFFER_COMPLETE {
+		t.Destroy()
+		return nil, fmt.Errorf("framebuffer incomplete: 0x%x", status)
+	}
+
+	return t, nil
+}
+
+// Start snapshots the frame currently in source as the outgoing frame and
+// starts the effect configured for kind. Kinds without an effect are
+// ignored.
+func (t *DesktopTransition) Start(kind TransitionKind, source uint32, now time.Time) {
+	effect := t.Effects[kind]
+	if effect == EffectNone || source == 0 || t.Duration <= 0 {
+		return
+	}
+
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, t.readFBO)
+	gl.FramebufferTexture2D(gl.READ_FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, source, 0)
+	// The source has no storage yet when nothing was drawn
+	if gl.CheckFramebufferStatus(gl.READ_FRAMEBUFFER) == gl.FRAMEBUFFER_COMPLETE {
+		gl.BindTexture(gl.TEXTURE_2D, t.previous)
+		gl.CopyTexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, 0, 0, t.Width, t.Height)
+		t.effect = effect
+		t.start = now
+		t.end = now.Add(t.Duration)
+	}
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
+}
+
+// Active reports whether a transition is still running
+func (t *DesktopTransition) Active(now time.Time) bool {
+	return now.Before(t.end)
+}
+
+// Render draws the transition between the snapshot and the live frame in
+// current into the framebuffer
+func (t *DesktopTransition) Render(current uint32, now time.Time) {
+	progress := float32(now.Sub(t.start)) / float32(t.Duration)
+	progress = min(max(progress, 0), 1)
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, t.FBO)
+	gl.Viewport(0, 0, t.Width, t.Height)
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.CULL_FACE)
+
+	gl.UseProgram(t.program)
+	gl.Uniform1i(t.previousLoc, 0)
+	gl.Uniform1i(t.currentLoc, 1)
+	gl.Uniform1i(t.effectLoc, int32(t.effect))
+	gl.Uniform1f(t.progressLoc, progress)
+	gl.Uniform1f(t.seedLoc, float32(t.start.UnixNano()%1000))
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, t.previous)
+	gl.ActiveTexture(gl.TEXTURE1)
+	gl.BindTexture(gl.TEXTURE_2D, current)
+	gl.BindVertexArray(t.quadVAO)
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+
+	gl.BindVertexArray(0)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+}
+
+// Destroy releases all GL resources owned by the transition
+func (t *DesktopTransition) Destroy() {
+	gl.DeleteFramebuffers(1, &t.FBO)
+	gl.DeleteFramebuffers(1, &t.readFBO)
+	gl.DeleteTextures(1, &t.ColorTexture)
+	gl.DeleteTextures(1, &t.previous)
+	gl.DeleteBuffers(1, &t.quadVBO)
+	gl.DeleteVertexArrays(1, &t.quadVAO)
+	gl.DeleteProgram(t.program)
+}
+
+// ParseTransitionRules parses a comma separated list of kind=effect pairs,
+// e.g. "app=cube,open=crossfade,close=glitch".
+func ParseTransitionRules(spec string) (map[TransitionKind]TransitionEffect, error) {
+	rules := make(map[TransitionKind]TransitionEffect)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kind, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid transition rule %q, expected kind=effect", entry)
+		}
+		switch TransitionKind(kind) {
+		case TransitionOpen, TransitionClose, TransitionApp:
+		default:
+			return nil, fmt.Errorf("unknown switch kind in transition rule %q", entry)
+		}
+		effect, ok := transitionEffectNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown effect in transition rule %q", entry)
+		}
+		rules[TransitionKind(kind)] = effect
+	}
+	return rules, nil
+}
@@ -0,0 +1,53 @@
+//go:build !nogl
+
+package render
+
+import (
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestSwitchDetector(t *testing.T) {
+	first := wayland.MakeClient(nil)
+	second := wayland.MakeClient(nil)
+	toplevel := func(c *wayland.Client) PlacedSurface {
+		return PlacedSurface{Client: c, Surface: &wayland.WlSurface{Role: &wayland.SurfaceRoleXdgToplevel{}}}
+	}
+	cursor := PlacedSurface{Client: second, Surface: &wayland.WlSurface{}}
+
+	var d SwitchDetector
+	steps := []struct {
+		placed []PlacedSurface
+		kind   TransitionKind
+		ok     bool
+	}{
+		{nil, "", false},
+		{[]PlacedSurface{toplevel(first)}, TransitionOpen, true},
+		{[]PlacedSurface{toplevel(first), cursor}, "", false},
+		{[]PlacedSurface{toplevel(first), toplevel(second)}, TransitionApp, true},
+		{nil, TransitionClose, true},
+	}
+	for i, step := range steps {
+		kind, ok := d.Detect(step.placed)
+		if kind != step.kind || ok != step.ok {
+			t.Errorf("Step %d: expected (%q, %v), got (%q, %v)", i, step.kind, step.ok, kind, ok)
+		}
+	}
+}
+
+func TestParseTransitionRules(t *testing.T) {
+	rules, err := ParseTransitionRules("app=cube, open=crossfade,close=none")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rules[TransitionApp] != EffectCube || rules[TransitionOpen] != EffectCrossfade || rules[TransitionClose] != EffectNone {
+		t.Errorf("Unexpected rules: %v", rules)
+	}
+
+	for _, bad := range []string{"app", "workspace=cube", "app=spin"} {
+		if _, err := ParseTransitionRules(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package render
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// EnvImage is an equirectangular environment in linear RGB, row 0 looking
+// straight up and the middle column looking down -z, away from the camera
+type EnvImage struct {
+	Width, Height int
+	// Pix holds three floats per pixel, row by row
+	Pix []float32
+}
+
+func (e *EnvImage) at(x, y int) [3]float32 {
+	i := (y*e.Width + x) * 3
+	return [3]float32{e.Pix[i], e.Pix[i+1], e.Pix[i+2]}
+}
+
+// Cube face files in a -env directory, in GL's face order
+var cubeFaceNames = [6]string{"px", "nx", "py", "ny", "pz", "nz"}
+
+// LoadEnvironment loads -env: a Radiance .hdr or PNG/JPEG equirectangular
+// image, or a directory of six cube faces named px, nx, py, ny, pz and nz
+// with any of those extensions
+func LoadEnvironment(path string) (*EnvImage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return loadEnvImage(path)
+	}
+
+	var faces [6]*EnvImage
+	for i, name := range cubeFaceNames {
+		matches, _ := filepath.Glob(filepath.Join(path, name+".*"))
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no %s cube face in %s", name, path)
+		}
+		faces[i], err = loadEnvImage(matches[0])
+		if err != nil {
+			return nil, err
+		}
+		if faces[i].Width != faces[i].Height || faces[i].Width != faces[0].Width {
+			return nil, fmt.Errorf("%s: cube faces must be square and the same size", matches[0])
+		}
+	}
+	return cubemapToEquirect(faces), nil
+}
+
+// loadEnvImage reads one image, Radiance HDR by its extension and anything
+// else as an 8-bit sRGB image
+func loadEnvImage(path string) (*EnvImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".hdr") {
+		env, err := decodeRadianceHDR(bufio.NewReader(f))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return env, nil
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	bounds := img.Bounds()
+	env := &EnvImage{Width: bounds.Dx(), Height: bounds.Dy(), Pix: make([]float32, bounds.Dx()*bounds.Dy()*3)}
+	for y := 0; y < env.Height; y++ {
+		for x := 0; x < env.Width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			i := (y*env.Width + x) * 3
+			env.Pix[i] = srgbToLinear(float32(r) / 0xffff)
+			env.Pix[i+1] = srgbToLinear(float32(g) / 0xffff)
+			env.Pix[i+2] = srgbToLinear(float32(b) / 0xffff)
+		}
+	}
+	return env, nil
+}
+
+func srgbToLinear(c float32) float32 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return float32(math.Pow((float64(c)+0.055)/1.055, 2.4))
+}
+
+// decodeRadianceHDR decodes a Radiance RGBE image with the usual -Y H +X W
+// orientation, flat or run-length encoded
+func decodeRadianceHDR(r *bufio.Reader) (*EnvImage, error) {
+	magic, err := r.ReadString('\n')
+	if err != nil || (!strings.HasPrefix(magic, "#?RADIANCE") && !strings.HasPrefix(magic, "#?RGBE")) {
+		return nil, errors.New("not a Radiance HDR image")
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if format, ok := strings.CutPrefix(line, "FORMAT="); ok && format != "32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("unsupported format %s", format)
+		}
+	}
+	resolution, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read resolution: %w", err)
+	}
+	fields := strings.Fields(resolution)
+	if len(fields) != 4 || fields[0] != "-Y" || fields[2] != "+X" {
+		return nil, fmt.Errorf("unsupported orientation %q", strings.TrimSpace(resolution))
+	}
+	height, errH := strconv.Atoi(fields[1])
+	width, errW := strconv.Atoi(fields[3])
+	if errH != nil || errW != nil || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid resolution %q", strings.TrimSpace(resolution))
+	}
+
+	env := &EnvImage{Width: width, Height: height, Pix: make([]float32, width*height*3)}
+	scanline := make([]byte, width*4)
+	for y := 0; y < height; y++ {
+		if err := readRGBEScanline(r, scanline, width); err != nil {
+			return nil, fmt.Errorf("scanline %d: %w", y, err)
+		}
+		for x := 0; x < width; x++ {
+			rgbe := scanline[x*4 : x*4+4]
+			if rgbe[3] == 0 {
+				continue
+			}
+			scale := float32(math.Ldexp(1, int(rgbe[3])-(128+8)))
+			i := (y*width + x) * 3
+			env.Pix[i] = float32(rgbe[0]) * scale
+			env.Pix[i+1] = float32(rgbe[1]) * scale
+			env.Pix[i+2] = float32(rgbe[2]) * scale
+		}
+	}
+	return env, nil
+}
+
+// readRGBEScanline reads one row into scanline as RGBE quadruples
+func readRGBEScanline(r *bufio.Reader, scanline []byte, width int) error {
+	if _, err := io.ReadFull(r, scanline[:4]); err != nil {
+		return err
+	}
+	rle := width >= 8 && width < 0x8000 && scanline[0] == 2 && scanline[1] == 2 &&
+		int(scanline[2])<<8|int(scanline[3]) == width
+	if !rle {
+		_, err := io.ReadFull(r, scanline[4:])
+		return err
+	}
+
+	// Each channel is run-length encoded on its own
+	channel := make([]byte, width)
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				n := int(count - 128)
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				if x+n > width {
+					return errors.New("run past the end of the scanline")
+				}
+				for i := 0; i < n; i++ {
+					channel[x+i] = value
+				}
+				x += n
+				continue
+			}
+			n := int(count)
+			if n == 0 || x+n > width {
+				return errors.New("bad run length")
+			}
+			if _, err := io.ReadFull(r, channel[x:x+n]); err != nil {
+				return err
+			}
+			x += n
+		}
+		for x := 0; x < width; x++ {
+			scanline[x*4+c] = channel[x]
+		}
+	}
+	return nil
+}
+
+// envDirection is the direction the pixel centre at u, v (0 to 1 across
+// and down the image) looks along
+func envDirection(u, v float64) [3]float64 {
+	phi := (u - 0.5) * 2 * math.Pi
+	theta := v * math.Pi
+	return [3]float64{math.Sin(theta) * math.Sin(phi), math.Cos(theta), -math.Sin(theta) * math.Cos(phi)}
+}
+
+// cubemapToEquirect resamples six square faces, in GL's face order, into
+// an equirectangular image four faces wide and two high
+func cubemapToEquirect(faces [6]*EnvImage) *EnvImage {
+	size := faces[0].Width
+	env := &EnvImage{Width: size * 4, Height: size * 2}
+	env.Pix = make([]float32, env.Width*env.Height*3)
+	for y := 0; y < env.Height; y++ {
+		for x := 0; x < env.Width; x++ {
+			d := envDirection((float64(x)+0.5)/float64(env.Width), (float64(y)+0.5)/float64(env.Height))
+			face, s, t := cubeFace(d)
+			fx := min(int(s*float64(size)), size-1)
+			fy := min(int(t*float64(size)), size-1)
+			copy(env.Pix[(y*env.Width+x)*3:], faces[face].Pix[(fy*size+fx)*3:(fy*size+fx)*3+3])
+		}
+	}
+	return env
+}
+
+// cubeFace picks the face d points at and where on it, s across and t
+// down from 0 to 1, as GL samples cube maps
+func cubeFace(d [3]float64) (face int, s, t float64) {
+	ax, ay, az := math.Abs(d[0]), math.Abs(d[1]), math.Abs(d[2])
+	var sc, tc, ma float64
+	switch {
+	case ax >= ay && ax >= az:
+		ma = ax
+		if d[0] > 0 {
+			face, sc, tc = 0, -d[2], -d[1]
+		} else {
+			face, sc, tc = 1, d[2], -d[1]
+		}
+	case ay >= az:
+		ma = ay
+		if d[1] > 0 {
+			face, sc, tc = 2, d[0], d[2]
+		} else {
+			face, sc, tc = 3, d[0], -d[2]
+		}
+	default:
+		ma = az
+		if d[2] > 0 {
+			face, sc, tc = 4, d[0], -d[1]
+		} else {
+			face, sc, tc = 5, -d[0], -d[1]
+		}
+	}
+	return face, (sc/ma + 1) / 2, (tc/ma + 1) / 2
+}
+
+// EnvSH is the environment's diffuse light as nine spherical harmonic
+// coefficients per channel, already convolved with the cosine lobe and
+// divided by pi, so evaluating them for a normal gives the light a white
+// diffuse surface facing that way reflects
+type EnvSH [9][3]float32
+
+// shBasis is the real spherical harmonic basis up to the second band
+func shBasis(d [3]float64) [9]float64 {
+	x, y, z := d[0], d[1], d[2]
+	return [9]float64{
+		0.282095,
+		0.488603 * y, 0.488603 * z, 0.488603 * x,
+		1.092548 * x * y, 1.092548 * y * z, 0.315392 * (3*z*z - 1),
+		1.092548 * x * z, 0.546274 * (x*x - y*y),
+	}
+}
+
+// envSHSamples is roughly how many pixels across the environment is
+// sampled at to project it, plenty for the lowest frequencies
+const envSHSamples = 256
+
+// projectEnvSH projects the environment onto the spherical harmonics
+func projectEnvSH(env *EnvImage) EnvSH {
+	step := max(env.Width/envSHSamples, 1)
+	var sum [9][3]float64
+	var totalWeight float64
+	for y := step / 2; y < env.Height; y += step {
+		v := (float64(y) + 0.5) / float64(env.Height)
+		// Pixels near the poles cover less of the sphere
+		weight := math.Sin(v * math.Pi)
+		for x := step / 2; x < env.Width; x += step {
+			d := envDirection((float64(x)+0.5)/float64(env.Width), v)
+			basis := shBasis(d)
+			c := env.at(x, y)
+			for i := range basis {
+				for ch := 0; ch < 3; ch++ {
+					sum[i][ch] += float64(c[ch]) * basis[i] * weight
+				}
+			}
+			totalWeight += weight
+		}
+	}
+
+	// The cosine lobe's bands, over pi
+	bands := [9]float64{1, 2.0 / 3, 2.0 / 3, 2.0 / 3, 0.25, 0.25, 0.25, 0.25, 0.25}
+	var sh EnvSH
+	if totalWeight == 0 {
+		return sh
+	}
+	for i := range sh {
+		for ch := 0; ch < 3; ch++ {
+			sh[i][ch] = float32(sum[i][ch] * 4 * math.Pi / totalWeight * bands[i])
+		}
+	}
+	return sh
+}
+
+// irradiance evaluates the coefficients for normal n, as the model
+// shader's environmentLight does
+func (sh EnvSH) irradiance(n [3]float64) [3]float32 {
+	var out [3]float32
+	for i, b := range shBasis(n) {
+		for ch := 0; ch < 3; ch++ {
+			out[ch] += sh[i][ch] * float32(b)
+		}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestDecodeRadianceHDR(t *testing.T) {
+	header := "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 2 +X 8\n"
+	var data bytes.Buffer
+	data.WriteString(header)
+	// A flat scanline of 1.0 (128, 128, 128 with exponent 129)
+	for x := 0; x < 8; x++ {
+		data.Write([]byte{128, 128, 128, 129})
+	}
+	// A run-length encoded scanline of 0.5 red: one run per channel
+	data.Write([]byte{2, 2, 0, 8})
+	for _, value := range []byte{128, 0, 0, 128} {
+		data.Write([]byte{128 + 8, value})
+	}
+
+	env, err := decodeRadianceHDR(bufio.NewReader(&data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Width != 8 || env.Height != 2 {
+		t.Fatalf("size = %dx%d, want 8x2", env.Width, env.Height)
+	}
+	if got := env.at(3, 0); got != [3]float32{1, 1, 1} {
+		t.Errorf("flat pixel = %v, want white", got)
+	}
+	if got := env.at(5, 1); got != [3]float32{0.5, 0, 0} {
+		t.Errorf("run-length encoded pixel = %v, want half red", got)
+	}
+
+	if _, err := decodeRadianceHDR(bufio.NewReader(bytes.NewBufferString("P6\n"))); err == nil {
+		t.Error("non-HDR data decoded")
+	}
+}
+
+func uniformEnv(width, height int, c [3]float32) *EnvImage {
+	env := &EnvImage{Width: width, Height: height, Pix: make([]float32, width*height*3)}
+	for i := 0; i < width*height; i++ {
+		copy(env.Pix[i*3:], c[:])
+	}
+	return env
+}
+
+func TestProjectEnvSHUniform(t *testing.T) {
+	sh := projectEnvSH(uniformEnv(64, 32, [3]float32{1, 0.5, 0}))
+	for _, n := range [][3]float64{{0, 1, 0}, {0, -1, 0}, {1, 0, 0}, {0, 0, -1}} {
+		got := sh.irradiance(n)
+		if math.Abs(float64(got[0]-1)) > 0.02 || math.Abs(float64(got[1]-0.5)) > 0.02 || math.Abs(float64(got[2])) > 0.02 {
+			t.Errorf("irradiance(%v) = %v, want [1 0.5 0]", n, got)
+		}
+	}
+}
+
+func TestProjectEnvSHSky(t *testing.T) {
+	// Light only from the upper half
+	env := uniformEnv(64, 32, [3]float32{})
+	for i := 0; i < 64*16*3; i++ {
+		env.Pix[i] = 1
+	}
+	sh := projectEnvSH(env)
+	up, side, down := sh.irradiance([3]float64{0, 1, 0}), sh.irradiance([3]float64{1, 0, 0}), sh.irradiance([3]float64{0, -1, 0})
+	if !(up[0] > side[0] && side[0] > down[0]) {
+		t.Errorf("up %v, side %v, down %v: want brightest facing up", up[0], side[0], down[0])
+	}
+	if math.Abs(float64(side[0])-0.5) > 0.05 {
+		t.Errorf("side = %v, want about 0.5", side[0])
+	}
+}
+
+func TestCubemapToEquirect(t *testing.T) {
+	var faces [6]*EnvImage
+	for i := range faces {
+		faces[i] = uniformEnv(4, 4, [3]float32{float32(i), 0, 0})
+	}
+	env := cubemapToEquirect(faces)
+	if env.Width != 16 || env.Height != 8 {
+		t.Fatalf("size = %dx%d, want 16x8", env.Width, env.Height)
+	}
+	tests := []struct {
+		x, y int
+		face string
+	}{
+		{8, 4, "nz"},  // straight ahead of the camera, into the scene
+		{0, 4, "pz"},  // behind the camera
+		{12, 4, "px"}, // to the right
+		{8, 0, "py"},
+		{8, 7, "ny"},
+	}
+	for _, tt := range tests {
+		if got := cubeFaceNames[int(env.at(tt.x, tt.y)[0])]; got != tt.face {
+			t.Errorf("pixel %d,%d from %s, want %s", tt.x, tt.y, got, tt.face)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+//go:build !nogl
+
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Expression is a named preset that changes the display's mood in one
+// call: morph weights, an animation and bone-masked layers, a tint on the
+// screen and a flash. Unset parts are left as they are.
+type Expression struct {
+	Morphs    map[string]float32   `json:"morphs,omitempty"`
+	Animation string               `json:"animation,omitempty"`
+	Loop      bool                 `json:"loop,omitempty"`
+	Layers    []AnimationLayerInfo `json:"layers,omitempty"`
+	Tint      *[3]float32          `json:"tint,omitempty"`
+	Flash     *ExpressionFlash     `json:"flash,omitempty"`
+}
+
+// ExpressionFlash covers the model in a color that fades out
+type ExpressionFlash struct {
+	Color    [3]float32 `json:"color"`
+	Duration string     `json:"duration"`
+	duration time.Duration
+}
+
+// defaultFlashDuration is used when a flash gives no duration
+const defaultFlashDuration = 300 * time.Millisecond
+
+// Expressions is the -expressions flag: presets by name, given as a JSON
+// object
+type Expressions map[string]Expression
+
+// String returns the presets as JSON
+func (e *Expressions) String() string {
+	if e == nil || len(*e) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(*e)
+	return string(data)
+}
+
+// Set parses and validates the presets, replacing the current ones
+func (e *Expressions) Set(value string) error {
+	presets, err := parseExpressions(value)
+	if err != nil {
+		return err
+	}
+	*e = presets
+	return nil
+}
+
+// Names returns the preset names, sorted
+func (e Expressions) Names() []string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseExpressions parses a JSON object of presets
+func parseExpressions(value string) (Expressions, error) {
+	presets := Expressions{}
+	if value == "" {
+		return presets, nil
+	}
+	if err := json.Unmarshal([]byte(value), &presets); err != nil {
+		return nil, fmt.Errorf("parse expressions: %w", err)
+	}
+	for name, e := range presets {
+		if e.Flash != nil {
+			e.Flash.duration = defaultFlashDuration
+			if e.Flash.Duration != "" {
+				d, err := time.ParseDuration(e.Flash.Duration)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("expression %q: invalid flash duration %q", name, e.Flash.Duration)
+				}
+				e.Flash.duration = d
+			}
+		}
+		for _, layer := range e.Layers {
+			if layer.Name == "" || len(layer.Bones) == 0 {
+				return nil, fmt.Errorf("expression %q: layers need a name and bones", name)
+			}
+		}
+	}
+	return presets, nil
+}
+
+// ApplyExpression applies a preset to the model. Parts the model does not
+// have (animations, morph targets) are skipped and returned, so one set of
+// presets can serve every model of a playlist.
+func (r *GLBRenderer) ApplyExpression(e Expression) (missing []string) {
+	if len(e.Morphs) > 0 && r.SetMorphWeights(e.Morphs) == 0 {
+		missing = append(missing, "morph targets")
+	}
+	if e.Animation != "" {
+		if err := r.PlayAnimation(e.Animation, e.Loop); err != nil {
+			missing = append(missing, "animation "+e.Animation)
+		}
+	}
+	for _, layer := range e.Layers {
+		if err := r.PlayLayer(layer.Name, layer.Loop, layer.Bones); err != nil {
+			missing = append(missing, "layer "+layer.Name)
+		}
+	}
+	if e.Tint != nil {
+		r.Tint = mgl32.Vec3(*e.Tint)
+	}
+	if e.Flash != nil {
+		r.Flash(mgl32.Vec3(e.Flash.Color), e.Flash.duration)
+	}
+	return missing
+}
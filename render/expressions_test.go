@@ -0,0 +1,58 @@
+//go:build !nogl
+
+package render
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestParseExpressionsErrors(t *testing.T) {
+	for _, value := range []string{
+		`{"a": {"flash": {"duration": "soon"}}}`,
+		`{"a": {"layers": [{"name": "Wave"}]}}`,
+		`["happy"]`,
+	} {
+		if _, err := parseExpressions(value); err == nil {
+			t.Errorf("Expected an error for %s", value)
+		}
+	}
+	presets, err := parseExpressions(`{"a": {"flash": {"color": [1, 1, 1]}}}`)
+	if err != nil || presets["a"].Flash.duration != defaultFlashDuration {
+		t.Errorf("Expected the default flash duration, got %+v (%v)", presets["a"].Flash, err)
+	}
+}
+
+func TestApplyExpression(t *testing.T) {
+	r := &GLBRenderer{
+		Animations: map[string]*Animation{"Wag": {Name: "Wag", Duration: 1}},
+		Tint:       mgl32.Vec3{1, 1, 1},
+	}
+	tint := [3]float32{1, 0, 0}
+	missing := r.ApplyExpression(Expression{
+		Morphs:    map[string]float32{"Smile": 1},
+		Animation: "Wag",
+		Tint:      &tint,
+		Flash:     &ExpressionFlash{Color: [3]float32{1, 1, 1}, duration: time.Second},
+	})
+
+	if !reflect.DeepEqual(missing, []string{"morph targets"}) {
+		t.Errorf("Expected only the morph targets missing, got %v", missing)
+	}
+	if r.CurrentAnim == nil || r.CurrentAnim.Name != "Wag" {
+		t.Error("Expected Wag to play")
+	}
+	if r.Tint != (mgl32.Vec3{1, 0, 0}) || r.FlashDuration != time.Second {
+		t.Errorf("Expected the tint and flash set, got %v and %v", r.Tint, r.FlashDuration)
+	}
+
+	if missing := r.ApplyExpression(Expression{Animation: "Sit"}); !reflect.DeepEqual(missing, []string{"animation Sit"}) {
+		t.Errorf("Expected animation Sit missing, got %v", missing)
+	}
+	if r.CurrentAnim.Name != "Wag" {
+		t.Error("A missing animation should leave the current one playing")
+	}
+}
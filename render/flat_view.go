@@ -0,0 +1,123 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const flatVertexShaderSource = `
+#version 410 core
+layout (location = 0) in vec2 aPos;
+
+out vec2 TexCoord;
+
+void main() {
+    gl_Position = vec4(aPos * 2.0 - 1.0, 0.0, 1.0);
+    // The desktop's first row is its top
+    TexCoord = vec2(aPos.x, 1.0 - aPos.y);
+}
+` + "\x00"
+
+const flatFragmentShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform sampler2D desktop;
+
+void main() {
+    FragColor = vec4(texture(desktop, TexCoord).rgb, 1.0);
+}
+` + "\x00"
+
+// FlatView draws the desktop into the preview window pixel for pixel, in
+// place of the model, for -flat
+type FlatView struct {
+	program    uint32
+	quadVAO    uint32
+	quadVBO    uint32
+	desktopLoc int32
+}
+
+// NewFlatView creates the shader and quad the desktop is drawn with
+func NewFlatView(programs *ProgramCache) (*FlatView, error) {
+	f := &FlatView{}
+	program, err := newShaderProgram(programs, flatVertexShaderSource, flatFragmentShaderSource)
+	if err != nil {
+		return nil, fmt.Errorf("flat view shader: %w", err)
+	}
+	f.program = program
+	f.desktopLoc = gl.GetUniformLocation(program, gl.Str("desktop\x00"))
+
+	quad := []float32{0, 0, 1, 0, 0, 1, 1, 1}
+	gl.GenVertexArrays(1, &f.quadVAO)
+	gl.BindVertexArray(f.quadVAO)
+	gl.GenBuffers(1, &f.quadVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, f.quadVBO)
+	gl.BufferData(gl.ARRAY_BUFFER, len(quad)*4, gl.Ptr(quad), gl.STATIC_DRAW)
+	gl.VertexAttribPointerWithOffset(0, 2, gl.FLOAT, false, 2*4, 0)
+	gl.EnableVertexAttribArray(0)
+	gl.BindVertexArray(0)
+	return f, nil
+}
+
+// flatViewport is where a width x height desktop goes in a window: 1:1 and
+// centred when it fits, otherwise shrunk to fit keeping its aspect ratio
+func flatViewport(winW, winH, width, height int32) (x, y, w, h int32) {
+	w, h = width, height
+	if w > winW || h > winH {
+		scale := min(float64(winW)/float64(width), float64(winH)/float64(height))
+		w, h = int32(float64(width)*scale), int32(float64(height)*scale)
+	}
+	return (winW - w) / 2, (winH - h) / 2, w, h
+}
+
+// Render draws the desktop texture, width x height pixels, into a window of
+// winW x winH with nearest filtering
+func (f *FlatView) Render(texture uint32, width, height, winW, winH int32) {
+	gl.Viewport(0, 0, winW, winH)
+	gl.ClearColor(0, 0, 0, 1)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	gl.ClearColor(0.1, 0.1, 0.1, 1.0)
+	gl.Viewport(flatViewport(winW, winH, width, height))
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.CULL_FACE)
+	gl.UseProgram(f.program)
+	gl.Uniform1i(f.desktopLoc, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, texture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.BindVertexArray(f.quadVAO)
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+
+	gl.BindVertexArray(0)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+}
+
+// Destroy releases the GL resources of the view
+func (f *FlatView) Destroy() {
+	gl.DeleteBuffers(1, &f.quadVBO)
+	gl.DeleteVertexArrays(1, &f.quadVAO)
+	gl.DeleteProgram(f.program)
+}
+
+// FlatToDesktop turns a point in the window into desktop pixels, undoing
+// flatViewport
+func FlatToDesktop(winW, winH, width, height int32, x, y float32) (float32, float32) {
+	vx, vy, w, h := flatViewport(winW, winH, width, height)
+	if w <= 0 || h <= 0 {
+		return 0, 0
+	}
+	// GL viewports start at the bottom, window points at the top
+	top := winH - vy - h
+	dx := (x - float32(vx)) * float32(width) / float32(w)
+	dy := (y - float32(top)) * float32(height) / float32(h)
+	return min(max(dx, 0), float32(width-1)), min(max(dy, 0), float32(height-1))
+}
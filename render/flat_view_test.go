@@ -0,0 +1,44 @@
+//go:build !nogl
+
+package render
+
+import "testing"
+
+func TestFlatViewport(t *testing.T) {
+	tests := []struct {
+		winW, winH, width, height int32
+		x, y, w, h                int32
+	}{
+		{800, 600, 800, 600, 0, 0, 800, 600},
+		// A bigger window shows the desktop 1:1 in the middle
+		{1000, 700, 800, 600, 100, 50, 800, 600},
+		// A smaller one shrinks it
+		{400, 400, 800, 600, 0, 50, 400, 300},
+	}
+	for _, tt := range tests {
+		x, y, w, h := flatViewport(tt.winW, tt.winH, tt.width, tt.height)
+		if x != tt.x || y != tt.y || w != tt.w || h != tt.h {
+			t.Errorf("flatViewport(%d, %d, %d, %d) = %d, %d, %d, %d; want %d, %d, %d, %d",
+				tt.winW, tt.winH, tt.width, tt.height, x, y, w, h, tt.x, tt.y, tt.w, tt.h)
+		}
+	}
+}
+
+func TestFlatToDesktop(t *testing.T) {
+	tests := []struct {
+		winW, winH int32
+		x, y       float32
+		dx, dy     float32
+	}{
+		{800, 600, 10, 20, 10, 20},
+		{1000, 700, 110, 70, 10, 20},
+		{400, 400, 5, 60, 10, 20},
+		// Outside the desktop sticks to its edge
+		{1000, 700, 0, 690, 0, 599},
+	}
+	for _, tt := range tests {
+		if dx, dy := FlatToDesktop(tt.winW, tt.winH, 800, 600, tt.x, tt.y); dx != tt.dx || dy != tt.dy {
+			t.Errorf("FlatToDesktop in %dx%d at %v, %v = %v, %v; want %v, %v", tt.winW, tt.winH, tt.x, tt.y, dx, dy, tt.dx, tt.dy)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//go:build !nogl
+
+package render
+
+import (
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// GL is the part of OpenGL the model renderer loads and draws with. The
+// methods mirror go-gl's, except that uniform names are Go strings.
+// goGL forwards to the real binding; tests swap in a recording fake so
+// what the loader and draw path do can be checked without a GPU.
+type GL interface {
+	Enable(capability uint32)
+	Disable(capability uint32)
+	BlendFunc(sfactor, dfactor uint32)
+	DepthMask(flag bool)
+
+	UseProgram(program uint32)
+	DeleteProgram(program uint32)
+	GetUniformLocation(program uint32, name string) int32
+	Uniform1i(location int32, v0 int32)
+	Uniform1f(location int32, v0 float32)
+	Uniform3f(location int32, v0, v1, v2 float32)
+	Uniform4f(location int32, v0, v1, v2, v3 float32)
+	Uniform3fv(location int32, count int32, value *float32)
+	UniformMatrix4fv(location int32, count int32, transpose bool, value *float32)
+
+	GenTextures(n int32, textures *uint32)
+	DeleteTextures(n int32, textures *uint32)
+	ActiveTexture(texture uint32)
+	BindTexture(target uint32, texture uint32)
+	TexParameteri(target uint32, pname uint32, param int32)
+	PixelStorei(pname uint32, param int32)
+	TexImage2D(target uint32, level int32, internalformat int32, width, height int32, border int32, format, xtype uint32, pixels unsafe.Pointer)
+	TexSubImage2D(target uint32, level int32, xoffset, yoffset, width, height int32, format, xtype uint32, pixels unsafe.Pointer)
+
+	GenVertexArrays(n int32, arrays *uint32)
+	DeleteVertexArrays(n int32, arrays *uint32)
+	BindVertexArray(array uint32)
+	GenBuffers(n int32, buffers *uint32)
+	DeleteBuffers(n int32, buffers *uint32)
+	BindBuffer(target uint32, buffer uint32)
+	BufferData(target uint32, size int, data unsafe.Pointer, usage uint32)
+	BufferSubData(target uint32, offset int, size int, data unsafe.Pointer)
+	VertexAttribPointerWithOffset(index uint32, size int32, xtype uint32, normalized bool, stride int32, offset uintptr)
+	EnableVertexAttribArray(index uint32)
+	VertexAttribDivisor(index uint32, divisor uint32)
+
+	DrawArrays(mode uint32, first int32, count int32)
+	DrawElements(mode uint32, count int32, xtype uint32, indices unsafe.Pointer)
+	DrawArraysInstanced(mode uint32, first int32, count int32, instancecount int32)
+	DrawElementsInstanced(mode uint32, count int32, xtype uint32, indices unsafe.Pointer, instancecount int32)
+}
+
+// goGL is GL on the current context through go-gl
+type goGL struct{}
+
+func (goGL) Enable(capability uint32)          { gl.Enable(capability) }
+func (goGL) Disable(capability uint32)         { gl.Disable(capability) }
+func (goGL) BlendFunc(sfactor, dfactor uint32) { gl.BlendFunc(sfactor, dfactor) }
+func (goGL) DepthMask(flag bool)               { gl.DepthMask(flag) }
+
+func (goGL) UseProgram(program uint32)    { gl.UseProgram(program) }
+func (goGL) DeleteProgram(program uint32) { gl.DeleteProgram(program) }
+
+func (goGL) GetUniformLocation(program uint32, name string) int32 {
+	return gl.GetUniformLocation(program, gl.Str(name+"\x00"))
+}
+
+func (goGL) Uniform1i(location int32, v0 int32)   { gl.Uniform1i(location, v0) }
+func (goGL) Uniform1f(location int32, v0 float32) { gl.Uniform1f(location, v0) }
+
+func (goGL) Uniform3f(location int32, v0, v1, v2 float32) { gl.Uniform3f(location, v0, v1, v2) }
+
+func (goGL) Uniform4f(location int32, v0, v1, v2, v3 float32) {
+	gl.Uniform4f(location, v0, v1, v2, v3)
+}
+
+func (goGL) Uniform3fv(location int32, count int32, value *float32) {
+	gl.Uniform3fv(location, count, value)
+}
+
+func (goGL) UniformMatrix4fv(location int32, count int32, transpose bool, value *float32) {
+	gl.UniformMatrix4fv(location, count, transpose, value)
+}
+
+func (goGL) GenTextures(n int32, textures *uint32)     { gl.GenTextures(n, textures) }
+func (goGL) DeleteTextures(n int32, textures *uint32)  { gl.DeleteTextures(n, textures) }
+func (goGL) ActiveTexture(texture uint32)              { gl.ActiveTexture(texture) }
+func (goGL) BindTexture(target uint32, texture uint32) { gl.BindTexture(target, texture) }
+
+func (goGL) TexParameteri(target uint32, pname uint32, param int32) {
+	gl.TexParameteri(target, pname, param)
+}
+
+func (goGL) PixelStorei(pname uint32, param int32) { gl.PixelStorei(pname, param) }
+
+func (goGL) TexImage2D(target uint32, level int32, internalformat int32, width, height int32, border int32, format, xtype uint32, pixels unsafe.Pointer) {
+	gl.TexImage2D(target, level, internalformat, width, height, border, format, xtype, pixels)
+}
+
+func (goGL) TexSubImage2D(target uint32, level int32, xoffset, yoffset, width, height int32, format, xtype uint32, pixels unsafe.Pointer) {
+	gl.TexSubImage2D(target, level, xoffset, yoffset, width, height, format, xtype, pixels)
+}
+
+func (goGL) GenVertexArrays(n int32, arrays *uint32)    { gl.GenVertexArrays(n, arrays) }
+func (goGL) DeleteVertexArrays(n int32, arrays *uint32) { gl.DeleteVertexArrays(n, arrays) }
+func (goGL) BindVertexArray(array uint32)               { gl.BindVertexArray(array) }
+func (goGL) GenBuffers(n int32, buffers *uint32)        { gl.GenBuffers(n, buffers) }
+func (goGL) DeleteBuffers(n int32, buffers *uint32)     { gl.DeleteBuffers(n, buffers) }
+func (goGL) BindBuffer(target uint32, buffer uint32)    { gl.BindBuffer(target, buffer) }
+
+func (goGL) BufferData(target uint32, size int, data unsafe.Pointer, usage uint32) {
+	gl.BufferData(target, size, data, usage)
+}
+
+func (goGL) BufferSubData(target uint32, offset int, size int, data unsafe.Pointer) {
+	gl.BufferSubData(target, offset, size, data)
+}
+
+func (goGL) VertexAttribPointerWithOffset(index uint32, size int32, xtype uint32, normalized bool, stride int32, offset uintptr) {
+	gl.VertexAttribPointerWithOffset(index, size, xtype, normalized, stride, offset)
+}
+
+func (goGL) EnableVertexAttribArray(index uint32) { gl.EnableVertexAttribArray(index) }
+
+func (goGL) VertexAttribDivisor(index uint32, divisor uint32) {
+	gl.VertexAttribDivisor(index, divisor)
+}
+
+func (goGL) DrawArrays(mode uint32, first int32, count int32) { gl.DrawArrays(mode, first, count) }
+
+func (goGL) DrawElements(mode uint32, count int32, xtype uint32, indices unsafe.Pointer) {
+	gl.DrawElements(mode, count, xtype, indices)
+}
+
+func (goGL) DrawArraysInstanced(mode uint32, first int32, count int32, instancecount int32) {
+	gl.DrawArraysInstanced(mode, first, count, instancecount)
+}
+
+func (goGL) DrawElementsInstanced(mode uint32, count int32, xtype uint32, indices unsafe.Pointer, instancecount int32) {
+	gl.DrawElementsInstanced(mode, count, xtype, indices, instancecount)
+}
@@ -0,0 +1,370 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// recordingGL is a GL that draws nothing and writes down what it was asked
+// to do, one call per line
+type recordingGL struct {
+	calls    []string
+	next     uint32
+	uniforms map[string]int32
+}
+
+func newRecordingGL() *recordingGL {
+	return &recordingGL{uniforms: make(map[string]int32)}
+}
+
+func (f *recordingGL) record(format string, args ...any) {
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+// count returns how many recorded calls start with prefix
+func (f *recordingGL) count(prefix string) int {
+	n := 0
+	for _, call := range f.calls {
+		if strings.HasPrefix(call, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *recordingGL) gen(n int32, names *uint32) {
+	ids := unsafe.Slice(names, n)
+	for i := range ids {
+		f.next++
+		ids[i] = f.next
+	}
+}
+
+func (f *recordingGL) Enable(capability uint32)  { f.record("Enable %d", capability) }
+func (f *recordingGL) Disable(capability uint32) { f.record("Disable %d", capability) }
+
+func (f *recordingGL) BlendFunc(sfactor, dfactor uint32) {
+	f.record("BlendFunc %d %d", sfactor, dfactor)
+}
+
+func (f *recordingGL) DepthMask(flag bool) { f.record("DepthMask %v", flag) }
+
+func (f *recordingGL) UseProgram(program uint32)    { f.record("UseProgram %d", program) }
+func (f *recordingGL) DeleteProgram(program uint32) { f.record("DeleteProgram %d", program) }
+
+func (f *recordingGL) GetUniformLocation(program uint32, name string) int32 {
+	f.record("GetUniformLocation %s", name)
+	loc, ok := f.uniforms[name]
+	if !ok {
+		loc = int32(len(f.uniforms))
+		f.uniforms[name] = loc
+	}
+	return loc
+}
+
+func (f *recordingGL) Uniform1i(location int32, v0 int32) { f.record("Uniform1i %d %d", location, v0) }
+func (f *recordingGL) Uniform1f(location int32, v0 float32) {
+	f.record("Uniform1f %d %g", location, v0)
+}
+
+func (f *recordingGL) Uniform3f(location int32, v0, v1, v2 float32) {
+	f.record("Uniform3f %d %g %g %g", location, v0, v1, v2)
+}
+
+func (f *recordingGL) Uniform4f(location int32, v0, v1, v2, v3 float32) {
+	f.record("Uniform4f %d %g %g %g %g", location, v0, v1, v2, v3)
+}
+
+func (f *recordingGL) Uniform3fv(location int32, count int32, value *float32) {
+	f.record("Uniform3fv %d %d", location, count)
+}
+
+func (f *recordingGL) UniformMatrix4fv(location int32, count int32, transpose bool, value *float32) {
+	f.record("UniformMatrix4fv %d %d", location, count)
+}
+
+func (f *recordingGL) GenTextures(n int32, textures *uint32) { f.gen(n, textures) }
+func (f *recordingGL) DeleteTextures(n int32, textures *uint32) {
+	f.record("DeleteTextures %d", *textures)
+}
+func (f *recordingGL) ActiveTexture(texture uint32) { f.record("ActiveTexture %d", texture) }
+
+func (f *recordingGL) BindTexture(target uint32, texture uint32) {
+	f.record("BindTexture %d", texture)
+}
+
+func (f *recordingGL) TexParameteri(target uint32, pname uint32, param int32) {}
+
+func (f *recordingGL) PixelStorei(pname uint32, param int32) {}
+
+func (f *recordingGL) TexImage2D(target uint32, level int32, internalformat int32, width, height int32, border int32, format, xtype uint32, pixels unsafe.Pointer) {
+	f.record("TexImage2D %dx%d", width, height)
+}
+
+func (f *recordingGL) TexSubImage2D(target uint32, level int32, xoffset, yoffset, width, height int32, format, xtype uint32, pixels unsafe.Pointer) {
+	f.record("TexSubImage2D %dx%d", width, height)
+}
+
+func (f *recordingGL) GenVertexArrays(n int32, arrays *uint32) {
+	f.gen(n, arrays)
+	f.record("GenVertexArrays %d", *arrays)
+}
+
+func (f *recordingGL) DeleteVertexArrays(n int32, arrays *uint32) {
+	f.record("DeleteVertexArrays %d", *arrays)
+}
+
+func (f *recordingGL) BindVertexArray(array uint32) { f.record("BindVertexArray %d", array) }
+
+func (f *recordingGL) GenBuffers(n int32, buffers *uint32) {
+	f.gen(n, buffers)
+	f.record("GenBuffers %d", *buffers)
+}
+
+func (f *recordingGL) DeleteBuffers(n int32, buffers *uint32)  { f.record("DeleteBuffers %d", *buffers) }
+func (f *recordingGL) BindBuffer(target uint32, buffer uint32) {}
+
+func (f *recordingGL) BufferData(target uint32, size int, data unsafe.Pointer, usage uint32) {
+	kind := "vertices"
+	if target == gl.ELEMENT_ARRAY_BUFFER {
+		kind = "indices"
+	}
+	f.record("BufferData %s %d", kind, size)
+}
+
+func (f *recordingGL) BufferSubData(target uint32, offset int, size int, data unsafe.Pointer) {
+	f.record("BufferSubData %d", size)
+}
+
+func (f *recordingGL) VertexAttribPointerWithOffset(index uint32, size int32, xtype uint32, normalized bool, stride int32, offset uintptr) {
+	f.record("VertexAttribPointer %d %d %d", index, size, offset)
+}
+
+func (f *recordingGL) EnableVertexAttribArray(index uint32) {}
+
+func (f *recordingGL) VertexAttribDivisor(index uint32, divisor uint32) {
+	f.record("VertexAttribDivisor %d %d", index, divisor)
+}
+
+func (f *recordingGL) DrawArrays(mode uint32, first int32, count int32) {
+	f.record("DrawArrays %d", count)
+}
+
+func (f *recordingGL) DrawElements(mode uint32, count int32, xtype uint32, indices unsafe.Pointer) {
+	f.record("DrawElements %d", count)
+}
+
+func (f *recordingGL) DrawArraysInstanced(mode uint32, first int32, count int32, instancecount int32) {
+	f.record("DrawArraysInstanced %d x%d", count, instancecount)
+}
+
+func (f *recordingGL) DrawElementsInstanced(mode uint32, count int32, xtype uint32, indices unsafe.Pointer, instancecount int32) {
+	f.record("DrawElementsInstanced %d x%d", count, instancecount)
+}
+
+// newFakeGLBRenderer is a renderer set up as NewGLBRenderer does, drawing
+// into fake
+func newFakeGLBRenderer(t *testing.T, fake *recordingGL, model string) *GLBRenderer {
+	t.Helper()
+	r := &GLBRenderer{
+		GL:         fake,
+		Animations: make(map[string]*Animation),
+		Brightness: 1,
+		Tint:       mgl32.Vec3{1, 1, 1},
+	}
+	r.lookupUniforms()
+	doc, err := builtinModel(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.LoadDocument(doc); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestLoadUploadsEachPrimitive(t *testing.T) {
+	fake := newRecordingGL()
+	r := newFakeGLBRenderer(t, fake, "crt")
+
+	if got := fake.count("GenVertexArrays"); got != len(r.Meshes) {
+		t.Errorf("%d VAOs for %d meshes", got, len(r.Meshes))
+	}
+	if got := fake.count("VertexAttribPointer"); got != 5*len(r.Meshes) {
+		t.Errorf("%d vertex attributes for %d meshes, want 5 each", got, len(r.Meshes))
+	}
+	for _, mesh := range r.Meshes {
+		if !mesh.HasIndices {
+			t.Fatal("builtin meshes should be indexed")
+		}
+		vertices := fmt.Sprintf("BufferData vertices %d", mesh.Vertices*16*4)
+		indices := fmt.Sprintf("BufferData indices %d", mesh.IndexCount*4)
+		if fake.count(vertices) == 0 || fake.count(indices) == 0 {
+			t.Errorf("expected %q and %q in %v", vertices, indices, fake.calls)
+		}
+	}
+
+	meshes := len(r.Meshes)
+	fake.calls = nil
+	r.Destroy()
+	if got := fake.count("DeleteVertexArrays"); got != meshes {
+		t.Errorf("%d VAOs deleted for %d meshes", got, meshes)
+	}
+	if got := fake.count("DeleteBuffers"); got != 2*meshes {
+		t.Errorf("%d buffers deleted for %d indexed meshes", got, meshes)
+	}
+}
+
+func TestRenderUsesCachedUniforms(t *testing.T) {
+	fake := newRecordingGL()
+	r := newFakeGLBRenderer(t, fake, "crt")
+	// A second mesh on the same buffers, glowing
+	glowing := r.Meshes[0]
+	glowing.Emissive = [3]float32{1, 0.5, 0}
+	r.Meshes = append(r.Meshes, glowing)
+
+	fake.calls = nil
+	r.Render(800, 600)
+	r.Render(800, 600)
+
+	if got := fake.count("GetUniformLocation"); got != 0 {
+		t.Errorf("Render looked up %d uniforms, want all cached", got)
+	}
+	if got := fake.count("DrawElements"); got != 2*len(r.Meshes) {
+		t.Errorf("%d draws for %d meshes over two frames", got, len(r.Meshes))
+	}
+	// Every mesh is unskinned, so identity bones go up once a frame
+	bones := fmt.Sprintf("UniformMatrix4fv %d 128", r.boneMatricesLoc)
+	if got := fake.count(bones); got != 2 {
+		t.Errorf("identity bones uploaded %d times over two frames, want 2", got)
+	}
+	emissive := fmt.Sprintf("Uniform3f %d 1 0.5 0", r.emissiveLoc)
+	if got := fake.count(emissive); got != 2 {
+		t.Errorf("glowing mesh's emissive set %d times, want 2", got)
+	}
+	if got := fake.count(fmt.Sprintf("Uniform1i %d 0", r.useEnvLoc)); got != 2 {
+		t.Error("the environment should be off without -env")
+	}
+}
+
+func TestBlendedMeshesDrawInTheirOwnPass(t *testing.T) {
+	fake := newRecordingGL()
+	r := newFakeGLBRenderer(t, fake, "plane")
+	glass := r.Meshes[0]
+	glass.Transparent, glass.Opacity = true, 0.5
+	leaf := r.Meshes[0]
+	leaf.AlphaCutoff, leaf.DoubleSided = 0.25, true
+	r.Meshes = append(r.Meshes, glass, leaf)
+
+	fake.calls = nil
+	r.Render(800, 600)
+
+	index := func(call string) int {
+		for i, c := range fake.calls {
+			if c == call {
+				return i
+			}
+		}
+		t.Fatalf("no %q in %v", call, fake.calls)
+		return -1
+	}
+	blend := index(fmt.Sprintf("Enable %d", gl.BLEND))
+	opacity := index(fmt.Sprintf("Uniform1f %d 0.5", r.opacityLoc))
+	noDepthWrites := index("DepthMask false")
+	noCulling := index(fmt.Sprintf("Disable %d", gl.CULL_FACE))
+	cutoff := index(fmt.Sprintf("Uniform1f %d 0.25", r.alphaCutoffLoc))
+	if !(noCulling < cutoff && cutoff < blend && blend < noDepthWrites && noDepthWrites < opacity) {
+		t.Errorf("the double-sided masked mesh should draw before the blended pass: %v", fake.calls)
+	}
+	// Depth writes, blending and culling are back as they were
+	if index("DepthMask true") < opacity || index(fmt.Sprintf("Disable %d", gl.BLEND)) < opacity ||
+		index(fmt.Sprintf("Enable %d", gl.CULL_FACE)) < noCulling {
+		t.Errorf("state not restored after drawing: %v", fake.calls)
+	}
+}
+
+func TestUploadSourceSkipsUndamagedFrames(t *testing.T) {
+	fake := newRecordingGL()
+	r := newFakeGLBRenderer(t, fake, "plane")
+	fake.calls = nil
+
+	source := NewDesktopTextureSource()
+	r.UploadSource(source)
+	desktop := &wayland.Desktop{Width: 2, Height: 2, Stride: 8, Buffer: make([]byte, 2*2*4)}
+	source.Publish(desktop)
+	r.UploadSource(source)
+	r.UploadSource(source)
+	if got := fake.count("TexSubImage2D"); got != 1 {
+		t.Errorf("texture updated %d times, want once for the one frame", got)
+	}
+	source.Publish(desktop)
+	r.UploadSource(source)
+	if got := fake.count("TexSubImage2D"); got != 2 {
+		t.Errorf("texture updated %d times, want again for the new frame", got)
+	}
+}
+
+func TestNodeTexturesDrawInPlaceOfTheScreen(t *testing.T) {
+	fake := newRecordingGL()
+	r := newFakeGLBRenderer(t, fake, "plane")
+	r.Document.Nodes[r.Meshes[0].Nodes[0]].Name = "TV"
+	source := NewDesktopTextureSource()
+	r.SetNodeTextures(map[string]TextureSource{"TV": source, "Radio": nil})
+	fake.calls = nil
+
+	// Without a frame the mesh shows the screen
+	r.Render(800, 600)
+	if got := fake.count("BindTexture"); got != 1 {
+		t.Errorf("%d textures bound before the first frame, want the screen alone: %v", got, fake.calls)
+	}
+	source.Publish(&wayland.Desktop{Width: 2, Height: 2, Stride: 16, Buffer: make([]byte, 2*16)})
+	r.Render(800, 600)
+	r.Render(800, 600)
+	if got := fake.count("TexSubImage2D"); got != 1 {
+		t.Errorf("node texture updated %d times, want once for the one frame", got)
+	}
+	tv := r.nodeTextures["TV"].id
+	if got := fake.count(fmt.Sprintf("BindTexture %d", tv)); got != 3 {
+		t.Errorf("node texture bound %d times, want for the upload and each draw", got)
+	}
+
+	// The same source keeps its texture; none gives the screen back
+	r.SetNodeTextures(map[string]TextureSource{"TV": source})
+	if r.nodeTextures["TV"].id != tv {
+		t.Error("setting the same source made a new texture")
+	}
+	r.SetNodeTextures(nil)
+	if len(r.nodeTextures) != 0 || fake.count(fmt.Sprintf("DeleteTextures %d", tv)) != 1 {
+		t.Errorf("node texture not freed: %v", fake.calls)
+	}
+}
+
+func TestUpdateTextureReallocatesOnResize(t *testing.T) {
+	fake := newRecordingGL()
+	r := newFakeGLBRenderer(t, fake, "plane")
+	fake.calls = nil
+
+	frame := make([]byte, 4*4*4)
+	r.UpdateTexture(frame, 4, 4, 16)
+	r.UpdateTexture(frame, 4, 4, 16)
+	r.UpdateTexture(frame[:2*2*4], 2, 2, 8)
+
+	if got := fake.count("TexImage2D"); got != 2 {
+		t.Errorf("texture allocated %d times, want once per size", got)
+	}
+	if got := fake.count("TexSubImage2D"); got != 3 {
+		t.Errorf("texture updated %d times, want 3", got)
+	}
+	r.UpdateTexture(nil, 4, 4, 16)
+	if got := fake.count("TexSubImage2D"); got != 3 {
+		t.Error("an empty buffer should not be uploaded")
+	}
+}
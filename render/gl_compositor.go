@@ -0,0 +1,287 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+const compositeVertexShaderSource = `
+#version 410 core
+layout (location = 0) in vec2 aPos;
+
+out vec2 TexCoord;
+
+uniform vec4 rect;         // x, y, width, height in desktop pixels
+uniform vec4 sourceRect;   // shown part of the texture, in texture coordinates
+uniform vec2 viewportSize; // desktop size in pixels
+
+void main() {
+    vec2 pos = rect.xy + aPos * rect.zw;
+    // Desktop row 0 ends up at texture row 0, matching the CPU upload path
+    gl_Position = vec4(pos / viewportSize * 2.0 - 1.0, 0.0, 1.0);
+    TexCoord = sourceRect.xy + aPos * sourceRect.zw;
+}
+` + "\x00"
+
+const compositeFragmentShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform sampler2D surfaceTexture;
+
+void main() {
+    FragColor = texture(surfaceTexture, TexCoord);
+}
+` + "\x00"
+
+// surfaceTexture is the GL copy of one client surface's buffer
+type surfaceTexture struct {
+	ID     uint32
+	Width  int32
+	Height int32
+	source *wayland.Texture
+	frame  uint64
+}
+
+// GLCompositor composites client surfaces on the GPU. Each surface is kept
+// in its own texture, re-uploaded only when its buffer changes, and
+// drawn into a framebuffer whose color texture is sampled by the model.
+type GLCompositor struct {
+	Width        int32
+	Height       int32
+	FBO          uint32
+	ColorTexture uint32
+
+	program      uint32
+	quadVAO      uint32
+	quadVBO      uint32
+	rectLoc      int32
+	sourceLoc    int32
+	viewportLoc  int32
+	textureLoc   int32
+	surfaces     map[surfaceKey]*surfaceTexture
+	fallback     *surfaceTexture
+	fallbackSize image.Point
+}
+
+// NewGLCompositor creates a compositor that renders into a width x height
+// framebuffer
+func NewGLCompositor(width, height int32, programs *ProgramCache) (*GLCompositor, error) {
+	c := &GLCompositor{
+		Width:    width,
+		Height:   height,
+		surfaces: make(map[surfaceKey]*surfaceTexture),
+	}
+
+	program, err := newShaderProgram(programs, compositeVertexShaderSource, compositeFragmentShaderSource)
+	if err != nil {
+		return nil, fmt.Errorf("composite shader: %w", err)
+	}
+	c.program = program
+	c.rectLoc = gl.GetUniformLocation(program, gl.Str("rect\x00"))
+	c.sourceLoc = gl.GetUniformLocation(program, gl.Str("sourceRect\x00"))
+	c.viewportLoc = gl.GetUniformLocation(program, gl.Str("viewportSize\x00"))
+	c.textureLoc = gl.GetUniformLocation(program, gl.Str("surfaceTexture\x00"))
+
+	// Unit quad drawn as a triangle strip, scaled by the rect uniform
+	quad := []float32{0, 0, 1, 0, 0, 1, 1, 1}
+	gl.GenVertexArrays(1, &c.quadVAO)
+	gl.BindVertexArray(c.quadVAO)
+	gl.GenBuffers(1, &c.quadVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, c.quadVBO)
+	gl.BufferData(gl.ARRAY_BUFFER, len(quad)*4, gl.Ptr(quad), gl.STATIC_DRAW)
+	gl.VertexAttribPointerWithOffset(0, 2, gl.FLOAT, false, 2*4, 0)
+	gl.EnableVertexAttribArray(0)
+	gl.BindVertexArray(0)
+
+	// Framebuffer with a single color attachment
+	c.ColorTexture = newSurfaceGLTexture()
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+
+	gl.GenFramebuffers(1, &c.FBO)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, c.FBO)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, c.ColorTexture, 0)
+	status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	if status != gl.FRAMEBUFFER_COMPLETE {
+		c.Destroy()
+		return nil, fmt.Errorf("framebuffer incomplete: 0x%x", status)
+	}
+
+	return c, nil
+}
+
+// Composite draws placed surfaces (bottom first) into the framebuffer. When
+// there are none, the fallback image (usually the desktop icon) is drawn.
+func (c *GLCompositor) Composite(placed []PlacedSurface, fallback image.Image) {
+	gl.BindFramebuffer(gl.FRAMEBUFFER, c.FBO)
+	gl.Viewport(0, 0, c.Width, c.Height)
+	gl.ClearColor(0, 0, 0, 0)
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.CULL_FACE)
+	gl.Enable(gl.BLEND)
+	// Client buffers are premultiplied, matching draw.Over in the CPU path
+	gl.BlendFunc(gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+
+	gl.UseProgram(c.program)
+	gl.Uniform2f(c.viewportLoc, float32(c.Width), float32(c.Height))
+	gl.Uniform1i(c.textureLoc, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindVertexArray(c.quadVAO)
+
+	seen := make(map[surfaceKey]bool, len(placed))
+	for _, p := range placed {
+		key := surfaceKey{client: p.Client, id: p.SurfaceID}
+		seen[key] = true
+		tex := c.upload(key, p)
+		c.drawQuad(tex, image.Rect(p.X, p.Y, p.X+p.Width(), p.Y+p.Height()), p.Source)
+	}
+
+	if len(placed) == 0 && fallback != nil {
+		if tex := c.fallbackTexture(fallback); tex != nil {
+			full := image.Rect(0, 0, int(tex.Width), int(tex.Height))
+			c.drawQuad(tex, full, full)
+		}
+	}
+
+	// Free textures of surfaces that are gone
+	for key, tex := range c.surfaces {
+		if !seen[key] {
+			gl.DeleteTextures(1, &tex.ID)
+			delete(c.surfaces, key)
+		}
+	}
+
+	gl.BindVertexArray(0)
+	gl.Disable(gl.BLEND)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.ClearColor(0.1, 0.1, 0.1, 1.0)
+}
+
+// Forget drops the uploaded surfaces so each is uploaded again by the next
+// Composite, for when frames were composited on the CPU in between
+func (c *GLCompositor) Forget() {
+	for key, tex := range c.surfaces {
+		gl.DeleteTextures(1, &tex.ID)
+		delete(c.surfaces, key)
+	}
+}
+
+// ReadPixels copies the composited frame into buf (RGBA, width*4 stride),
+// for consumers that still need the desktop on the CPU such as streaming.
+func (c *GLCompositor) ReadPixels(buf []byte) {
+	if len(buf) < int(c.Width*c.Height*4) {
+		return
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, c.FBO)
+	gl.ReadPixels(0, 0, c.Width, c.Height, gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&buf[0]))
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+}
+
+// upload makes sure the GL texture for a surface holds its latest buffer.
+// Only new, resized, or changed buffers are re-uploaded.
+func (c *GLCompositor) upload(key surfaceKey, p PlacedSurface) *surfaceTexture {
+	tex, ok := c.surfaces[key]
+	if !ok {
+		tex = &surfaceTexture{ID: newSurfaceGLTexture()}
+		c.surfaces[key] = tex
+	}
+
+	width := int32(p.Texture.Width)
+	height := int32(p.Texture.Height)
+	if tex.source == p.Texture && tex.frame == p.Frame && tex.Width == width && tex.Height == height {
+		return tex
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, tex.ID)
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(p.Texture.Stride/4))
+	if tex.Width != width || tex.Height != height {
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&p.Texture.Data[0]))
+	} else {
+		gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, width, height, gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&p.Texture.Data[0]))
+	}
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+
+	tex.Width = width
+	tex.Height = height
+	tex.source = p.Texture
+	tex.frame = p.Frame
+	return tex
+}
+
+func (c *GLCompositor) fallbackTexture(img image.Image) *surfaceTexture {
+	size := img.Bounds().Size()
+	if c.fallback != nil && c.fallbackSize == size {
+		return c.fallback
+	}
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || size.X == 0 || size.Y == 0 {
+		return nil
+	}
+	if c.fallback == nil {
+		c.fallback = &surfaceTexture{ID: newSurfaceGLTexture()}
+	}
+	gl.BindTexture(gl.TEXTURE_2D, c.fallback.ID)
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(nrgba.Stride/4))
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, int32(size.X), int32(size.Y), 0, gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&nrgba.Pix[0]))
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+	c.fallback.Width = int32(size.X)
+	c.fallback.Height = int32(size.Y)
+	c.fallbackSize = size
+	return c.fallback
+}
+
+// drawQuad draws the source part of a texture (in texels) into rect (in
+// desktop pixels)
+func (c *GLCompositor) drawQuad(tex *surfaceTexture, rect, source image.Rectangle) {
+	if tex.Width == 0 || tex.Height == 0 {
+		return
+	}
+	w, h := float32(tex.Width), float32(tex.Height)
+	gl.BindTexture(gl.TEXTURE_2D, tex.ID)
+	gl.Uniform4f(c.rectLoc, float32(rect.Min.X), float32(rect.Min.Y), float32(rect.Dx()), float32(rect.Dy()))
+	gl.Uniform4f(c.sourceLoc, float32(source.Min.X)/w, float32(source.Min.Y)/h, float32(source.Dx())/w, float32(source.Dy())/h)
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+}
+
+// Destroy releases all GL resources owned by the compositor
+func (c *GLCompositor) Destroy() {
+	for key, tex := range c.surfaces {
+		gl.DeleteTextures(1, &tex.ID)
+		delete(c.surfaces, key)
+	}
+	if c.fallback != nil {
+		gl.DeleteTextures(1, &c.fallback.ID)
+		c.fallback = nil
+	}
+	gl.DeleteFramebuffers(1, &c.FBO)
+	gl.DeleteTextures(1, &c.ColorTexture)
+	gl.DeleteBuffers(1, &c.quadVBO)
+	gl.DeleteVertexArrays(1, &c.quadVAO)
+	gl.DeleteProgram(c.program)
+}
+
+// newSurfaceGLTexture creates a texture with the same sampling parameters
+// as the desktop texture and leaves it bound
+func newSurfaceGLTexture() uint32 {
+	var id uint32
+	gl.GenTextures(1, &id)
+	gl.BindTexture(gl.TEXTURE_2D, id)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	return id
+}
@@ -0,0 +1,1763 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+)
+
+// Mesh represents a loaded mesh with OpenGL buffers
+type Mesh struct {
+	VAO         uint32
+	VBO         uint32
+	EBO         uint32
+	IndexCount  int32
+	HasIndices  bool
+	VertexCount int32
+	SkinIndex   int // Index of the skin for this mesh (-1 if not skinned)
+	Vertices    int32
+
+	// Nodes are the nodes that draw the mesh. Unskinned, unmorphed glTF
+	// meshes are loaded once and drawn instanced for every node using them.
+	Nodes []int
+	// InstanceVBO holds the transforms of the Nodes in view, 0 for a mesh
+	// only one node draws
+	InstanceVBO uint32
+	// Bounds are the lowest and highest corners of the mesh's positions
+	Bounds [2]mgl32.Vec3
+	// Transparent is set for materials with alphaMode BLEND
+	Transparent bool
+	// Opacity is the material's base color alpha, and AlphaCutoff the
+	// alpha MASK materials discard fragments below, see materialAlpha
+	Opacity     float32
+	AlphaCutoff float32
+	// DoubleSided meshes are drawn without back-face culling
+	DoubleSided bool
+
+	// cache holds the skinned vertices while the pose is not changing
+	cache *skinCache
+	// morph is nil unless the primitive has morph targets
+	morph *meshMorph
+	// Emissive is the light the mesh's material gives off
+	Emissive [3]float32
+}
+
+// Skin represents a glTF skin with joint matrices
+type Skin struct {
+	Joints              []int // Node indices of the joints
+	InverseBindMatrices []mgl32.Mat4
+}
+
+// AnimationChannel represents a single animation channel (target + sampler)
+type AnimationChannel struct {
+	NodeIndex  int
+	Path       string // "translation", "rotation", "scale"
+	Timestamps []float32
+	Values     []float32 // Flat array of values
+}
+
+// Animation represents a glTF animation
+type Animation struct {
+	Name     string
+	Channels []AnimationChannel
+	Duration float32
+}
+
+// AnimationLayer plays an animation over the current one, on the nodes of
+// its mask only
+type AnimationLayer struct {
+	Anim      *Animation
+	Loop      bool
+	StartTime time.Time
+	// Bones are the node-name globs Mask was built from
+	Bones []string
+	Mask  []bool
+}
+
+// NodeTransform holds the current transform for a node
+type NodeTransform struct {
+	Translation mgl32.Vec3
+	Rotation    mgl32.Quat
+	Scale       mgl32.Vec3
+}
+
+// GLBRenderer handles loading and rendering GLB models with dynamic textures
+type GLBRenderer struct {
+	// GL is what the model is loaded and drawn with. The skin cache's
+	// transform feedback and shader compiling still call go-gl directly.
+	GL GL
+
+	Meshes        []Mesh
+	ShaderProgram uint32
+	TextureID     uint32
+	TextureWidth  int32
+	TextureHeight int32
+	// uploadedSource and uploadedDamage are the TextureSource frame last
+	// uploaded to TextureID
+	uploadedSource TextureSource
+	uploadedDamage uint64
+
+	// ExternalTexture, when non-zero, is bound instead of TextureID
+	// (e.g. the color attachment of the GPU compositor's framebuffer)
+	ExternalTexture uint32
+	// nodeTextures are drawn on the meshes of the nodes named, instead of
+	// the screen, see SetNodeTexture
+	nodeTextures map[string]*nodeTexture
+
+	// Brightness scales the screen texture, 1 is unchanged and 0 is black
+	Brightness float32
+	// Tint multiplies the screen texture's color, {1, 1, 1} is unchanged
+	Tint mgl32.Vec3
+	// A flash covers the model in FlashColor, fading out over
+	// FlashDuration from FlashStart
+	FlashColor    mgl32.Vec3
+	FlashStart    time.Time
+	FlashDuration time.Duration
+	// Glow lights the model's silhouette in GlowColor, 0 is off
+	Glow      float32
+	GlowColor mgl32.Vec3
+	// Pulse grows the model by that fraction of its size, 0 is unchanged
+	Pulse float32
+	// Environment, when set, is drawn behind the model and lights it in
+	// place of the built-in light. The preview owns it.
+	Environment *Skybox
+
+	// Uniform locations
+	modelLoc        int32
+	viewLoc         int32
+	projectionLoc   int32
+	textureLoc      int32
+	brightnessLoc   int32
+	tintLoc         int32
+	flashLoc        int32
+	glowLoc         int32
+	boneMatricesLoc int32
+	emissiveLoc     int32
+	opacityLoc      int32
+	alphaCutoffLoc  int32
+	useEnvLoc       int32
+	envSHLoc        int32
+	instancedLoc    int32
+
+	// programs keeps the programs linked for the next start, nil for none
+	programs *ProgramCache
+	// shaders compiles reloaded model shaders without stalling the render
+	// loop. ShaderProgram is drawn with until pendingShaders is ready.
+	shaders        *ShaderQueue
+	pendingShaders *PendingProgram
+	// shaderSources are the vertex and fragment sources of ShaderProgram,
+	// empty for the built-in ones
+	shaderSources [2]string
+
+	// Transform
+	Rotation float32
+
+	// Animation support
+	Animations     map[string]*Animation
+	NodeTransforms []NodeTransform
+	BaseTransforms []NodeTransform // Original transforms from the file
+	CurrentAnim    *Animation
+	AnimStartTime  time.Time
+	AnimLoop       bool
+	AnimPaused     bool
+	pausedAt       time.Time
+	// loops counts how often CurrentAnim has wrapped round
+	loops int
+	// playback holds the playback changes not yet taken, see
+	// TakePlaybackEvents
+	playback []AnimationPlaybackEvent
+	// Layers are applied over CurrentAnim in order, later ones on top
+	Layers   []AnimationLayer
+	Document *gltf.Document // Keep reference to the document
+
+	// SceneSelector picks the scene LoadDocument shows, by name or index;
+	// empty means the document's default scene
+	SceneSelector string
+	// Scene is the index of the shown scene, -1 when the document has none
+	Scene int
+
+	// TriangleBudget caps the triangles of the loaded scene by picking
+	// MSFT_lod levels and then decimating; zero or less loads full detail
+	TriangleBudget int
+
+	// morphWeights are the morph target weights set by name, kept for
+	// scene switches and snapshots
+	morphWeights map[string]float32
+
+	// Skinning support
+	Skins        []Skin
+	NodeParents  []int        // Parent index for each node (-1 for root)
+	BoneMatrices []mgl32.Mat4 // Computed bone matrices for current frame
+
+	// Skinned meshes are skinned once into a cache while the pose holds
+	// still (no animation, or a paused one). pose counts pose changes.
+	skinCacheProgram  uint32
+	skinCacheBonesLoc int32
+	pose              uint64
+}
+
+const vertexShaderSource = `
+#version 410 core
+layout (location = 0) in vec3 aPos;
+layout (location = 1) in vec3 aNormal;
+layout (location = 2) in vec2 aTexCoord;
+layout (location = 3) in vec4 aJoints;
+layout (location = 4) in vec4 aWeights;
+layout (location = 5) in mat4 aInstance;
+
+out vec2 TexCoord;
+out vec3 Normal;
+out vec3 FragPos;
+
+uniform mat4 model;
+uniform mat4 view;
+uniform mat4 projection;
+uniform mat4 boneMatrices[128];
+// Set when a mesh several nodes share is drawn instanced, each node's
+// transform coming in as aInstance
+uniform bool instanced;
+
+void main() {
+    // Compute skinned position and normal
+    mat4 skinMatrix = mat4(0.0);
+    float totalWeight = aWeights.x + aWeights.y + aWeights.z + aWeights.w;
+    
+    if (totalWeight > 0.0) {
+        skinMatrix += boneMatrices[int(aJoints.x)] * aWeights.x;
+        skinMatrix += boneMatrices[int(aJoints.y)] * aWeights.y;
+        skinMatrix += boneMatrices[int(aJoints.z)] * aWeights.z;
+        skinMatrix += boneMatrices[int(aJoints.w)] * aWeights.w;
+    } else {
+        skinMatrix = mat4(1.0);
+    }
+    
+    vec4 skinnedPos = skinMatrix * vec4(aPos, 1.0);
+    vec3 skinnedNormal = mat3(skinMatrix) * aNormal;
+    
+    mat4 world = instanced ? model * aInstance : model;
+    FragPos = vec3(world * skinnedPos);
+    Normal = mat3(transpose(inverse(world))) * skinnedNormal;
+    TexCoord = aTexCoord;
+    gl_Position = projection * view * world * skinnedPos;
+}
+` + "\x00"
+
+const fragmentShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+in vec3 Normal;
+in vec3 FragPos;
+
+uniform sampler2D desktopTexture;
+uniform float brightness;
+uniform vec3 tint;
+uniform vec4 flash; // rgb, and how much of it covers the model
+uniform vec4 glow; // rgb, and how strongly it lights the silhouette
+uniform vec3 emissive; // the material's own light
+uniform float opacity; // the material's base color alpha
+uniform float alphaCutoff; // alpha below which the fragment is cut away
+uniform bool useEnvironment;
+uniform vec3 environmentSH[9]; // -env's diffuse light
+
+vec3 environmentLight(vec3 n) {
+    return environmentSH[0] * 0.282095
+        + environmentSH[1] * 0.488603 * n.y + environmentSH[2] * 0.488603 * n.z + environmentSH[3] * 0.488603 * n.x
+        + environmentSH[4] * 1.092548 * n.x * n.y + environmentSH[5] * 1.092548 * n.y * n.z
+        + environmentSH[6] * 0.315392 * (3.0 * n.z * n.z - 1.0)
+        + environmentSH[7] * 1.092548 * n.x * n.z + environmentSH[8] * 0.546274 * (n.x * n.x - n.y * n.y);
+}
+
+void main() {
+    // Simple lighting, or the environment's light when there is one
+    vec3 lightDir = normalize(vec3(1.0, 1.0, 1.0));
+    vec3 norm = normalize(Normal);
+    float diff = max(dot(norm, lightDir), 0.0);
+    float ambient = 0.3;
+    vec3 lighting = vec3(ambient + diff * 0.7);
+    if (useEnvironment) {
+        lighting = max(environmentLight(norm), vec3(0.0));
+    }
+    
+    vec4 texColor = texture(desktopTexture, TexCoord);
+    float alpha = texColor.a * opacity;
+    if (alpha < alphaCutoff) {
+        discard;
+    }
+    vec3 color = texColor.rgb * tint * lighting * brightness;
+    // Light the silhouette, where the surface turns away from the camera
+    float rim = 1.0 - max(dot(norm, normalize(vec3(0.0, 0.0, 1.0) - FragPos)), 0.0);
+    color += glow.rgb * glow.a * rim * rim;
+    color += emissive * brightness;
+    FragColor = vec4(mix(color, flash.rgb, flash.a), alpha);
+}
+` + "\x00"
+
+// NewGLBRenderer creates a new GLB renderer, keeping the programs it links
+// in programs
+func NewGLBRenderer(programs *ProgramCache) (*GLBRenderer, error) {
+	r := &GLBRenderer{
+		GL:         goGL{},
+		programs:   programs,
+		Animations: make(map[string]*Animation),
+		Brightness: 1,
+		Tint:       mgl32.Vec3{1, 1, 1},
+	}
+
+	// Compile and link shaders
+	program, err := newShaderProgram(programs, vertexShaderSource, fragmentShaderSource)
+	if err != nil {
+		return nil, err
+	}
+	r.ShaderProgram = program
+
+	r.skinCacheProgram, err = newSkinCacheProgram()
+	if err != nil {
+		r.GL.DeleteProgram(r.ShaderProgram)
+		return nil, err
+	}
+	r.skinCacheBonesLoc = gl.GetUniformLocation(r.skinCacheProgram, gl.Str("boneMatrices\x00"))
+
+	r.lookupUniforms()
+	r.shaders = NewShaderQueue(programs)
+
+	// Create texture for desktop buffer
+	r.GL.GenTextures(1, &r.TextureID)
+	r.GL.BindTexture(gl.TEXTURE_2D, r.TextureID)
+	r.GL.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	r.GL.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	r.GL.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	r.GL.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+
+	return r, nil
+}
+
+// lookupUniforms finds the uniforms of the model shader program
+func (r *GLBRenderer) lookupUniforms() {
+	r.modelLoc = r.GL.GetUniformLocation(r.ShaderProgram, "model")
+	r.viewLoc = r.GL.GetUniformLocation(r.ShaderProgram, "view")
+	r.projectionLoc = r.GL.GetUniformLocation(r.ShaderProgram, "projection")
+	r.textureLoc = r.GL.GetUniformLocation(r.ShaderProgram, "desktopTexture")
+	r.brightnessLoc = r.GL.GetUniformLocation(r.ShaderProgram, "brightness")
+	r.tintLoc = r.GL.GetUniformLocation(r.ShaderProgram, "tint")
+	r.flashLoc = r.GL.GetUniformLocation(r.ShaderProgram, "flash")
+	r.glowLoc = r.GL.GetUniformLocation(r.ShaderProgram, "glow")
+	r.boneMatricesLoc = r.GL.GetUniformLocation(r.ShaderProgram, "boneMatrices")
+	r.emissiveLoc = r.GL.GetUniformLocation(r.ShaderProgram, "emissive")
+	r.opacityLoc = r.GL.GetUniformLocation(r.ShaderProgram, "opacity")
+	r.alphaCutoffLoc = r.GL.GetUniformLocation(r.ShaderProgram, "alphaCutoff")
+	r.useEnvLoc = r.GL.GetUniformLocation(r.ShaderProgram, "useEnvironment")
+	r.envSHLoc = r.GL.GetUniformLocation(r.ShaderProgram, "environmentSH")
+	r.instancedLoc = r.GL.GetUniformLocation(r.ShaderProgram, "instanced")
+}
+
+// ReloadShaders replaces the model shader program with one built from the
+// given sources. If they do not compile the current program is kept.
+func (r *GLBRenderer) ReloadShaders(vertexSource, fragmentSource string) error {
+	program, err := newShaderProgram(r.programs, vertexSource, fragmentSource)
+	if err != nil {
+		return err
+	}
+	r.GL.DeleteProgram(r.ShaderProgram)
+	r.ShaderProgram = program
+	r.shaderSources = [2]string{vertexSource, fragmentSource}
+	r.lookupUniforms()
+	return nil
+}
+
+// LoadShaderDir replaces the model shaders with the ones in dir, see
+// loadModelShaders
+func (r *GLBRenderer) LoadShaderDir(dir string) error {
+	vertex, fragment, err := loadModelShaders(dir)
+	if err != nil {
+		return err
+	}
+	return r.ReloadShaders(vertex, fragment)
+}
+
+// QueueShaders compiles a model shader program from the given sources in
+// the background. The current program is drawn with until it is ready and
+// kept if it does not compile. A program queued before and not yet ready
+// is dropped.
+func (r *GLBRenderer) QueueShaders(vertexSource, fragmentSource string) {
+	if r.shaders == nil {
+		if err := r.ReloadShaders(vertexSource, fragmentSource); err != nil {
+			log.Printf("Keeping the current shaders: %v", err)
+		}
+		return
+	}
+	if r.pendingShaders != nil {
+		r.shaders.Cancel(r.pendingShaders)
+	}
+	r.pendingShaders = r.shaders.Compile(vertexSource, fragmentSource)
+}
+
+// QueueShaderDir queues the model shaders in dir, see QueueShaders and
+// loadModelShaders
+func (r *GLBRenderer) QueueShaderDir(dir string) error {
+	vertex, fragment, err := loadModelShaders(dir)
+	if err != nil {
+		return err
+	}
+	r.QueueShaders(vertex, fragment)
+	return nil
+}
+
+// adoptShaders switches to the queued model shaders once they are ready
+func (r *GLBRenderer) adoptShaders() {
+	if r.shaders == nil {
+		return
+	}
+	r.shaders.Poll()
+	p := r.pendingShaders
+	if p == nil || !p.Finished() {
+		return
+	}
+	r.pendingShaders = nil
+	program, ok := p.Ready()
+	if !ok {
+		log.Printf("Keeping the current shaders: %v", p.Err())
+		return
+	}
+	r.GL.DeleteProgram(r.ShaderProgram)
+	r.ShaderProgram = program
+	r.shaderSources = [2]string{p.vertexSource, p.fragmentSource}
+	r.lookupUniforms()
+	log.Printf("Switched to the new shaders")
+}
+
+// LoadGLB loads a glTF, OBJ or STL file and creates OpenGL buffers
+func (r *GLBRenderer) LoadGLB(filename string) error {
+	doc, err := OpenModel(filename)
+	if err != nil {
+		return fmt.Errorf("open model: %w", err)
+	}
+	return r.LoadDocument(doc)
+}
+
+// LoadDocument replaces the current model with an already parsed glTF
+// document. Parsing can happen on any goroutine; this must run on the GL
+// thread.
+func (r *GLBRenderer) LoadDocument(doc *gltf.Document) error {
+	if err := checkRequiredExtensions(doc); err != nil {
+		return err
+	}
+	r.unloadModel()
+	r.Document = doc
+
+	// Build node parent hierarchy
+	r.NodeParents = make([]int, len(doc.Nodes))
+	for i := range r.NodeParents {
+		r.NodeParents[i] = -1 // Default to no parent
+	}
+	for parentIdx, node := range doc.Nodes {
+		for _, childIdx := range node.Children {
+			r.NodeParents[childIdx] = parentIdx
+		}
+	}
+
+	// Initialize node transforms
+	r.NodeTransforms = make([]NodeTransform, len(doc.Nodes))
+	r.BaseTransforms = make([]NodeTransform, len(doc.Nodes))
+	for i, node := range doc.Nodes {
+		// Set default values
+		r.NodeTransforms[i] = NodeTransform{
+			Translation: mgl32.Vec3{0, 0, 0},
+			Rotation:    mgl32.QuatIdent(),
+			Scale:       mgl32.Vec3{1, 1, 1},
+		}
+		// Load from node if present
+		if node.Translation != [3]float64{0, 0, 0} {
+			r.NodeTransforms[i].Translation = mgl32.Vec3{
+				float32(node.Translation[0]),
+				float32(node.Translation[1]),
+				float32(node.Translation[2]),
+			}
+		}
+		if node.Rotation != [4]float64{0, 0, 0, 1} {
+			r.NodeTransforms[i].Rotation = mgl32.Quat{
+				W: float32(node.Rotation[3]),
+				V: mgl32.Vec3{
+					float32(node.Rotation[0]),
+					float32(node.Rotation[1]),
+					float32(node.Rotation[2]),
+				},
+			}.Normalize()
+		}
+		if node.Scale != [3]float64{1, 1, 1} && node.Scale != [3]float64{0, 0, 0} {
+			r.NodeTransforms[i].Scale = mgl32.Vec3{
+				float32(node.Scale[0]),
+				float32(node.Scale[1]),
+				float32(node.Scale[2]),
+			}
+		}
+		r.BaseTransforms[i] = r.NodeTransforms[i]
+	}
+
+	// Load skins
+	for _, skin := range doc.Skins {
+		s := Skin{
+			Joints: make([]int, len(skin.Joints)),
+		}
+		for i, jointIdx := range skin.Joints {
+			s.Joints[i] = int(jointIdx)
+		}
+
+		// Load inverse bind matrices
+		if skin.InverseBindMatrices != nil {
+			matrices, err := r.readAccessorFloats(doc, int(*skin.InverseBindMatrices))
+			if err == nil {
+				s.InverseBindMatrices = make([]mgl32.Mat4, len(s.Joints))
+				for i := 0; i < len(s.Joints) && i*16+16 <= len(matrices); i++ {
+					// glTF stores matrices in column-major order, same as mgl32.Mat4
+					for j := 0; j < 16; j++ {
+						s.InverseBindMatrices[i][j] = matrices[i*16+j]
+					}
+				}
+			}
+		} else {
+			// Default to identity matrices
+			s.InverseBindMatrices = make([]mgl32.Mat4, len(s.Joints))
+			for i := range s.InverseBindMatrices {
+				s.InverseBindMatrices[i] = mgl32.Ident4()
+			}
+		}
+
+		r.Skins = append(r.Skins, s)
+	}
+
+	// Initialize bone matrices
+	if len(r.Skins) > 0 {
+		maxJoints := 0
+		for _, skin := range r.Skins {
+			if len(skin.Joints) > maxJoints {
+				maxJoints = len(skin.Joints)
+			}
+		}
+		r.BoneMatrices = make([]mgl32.Mat4, maxJoints)
+		for i := range r.BoneMatrices {
+			r.BoneMatrices[i] = mgl32.Ident4()
+		}
+	}
+
+	// Only the nodes of the selected scene get meshes. A selector this
+	// document does not have falls back to its default scene, so one
+	// -scene can serve a whole playlist.
+	scene, err := resolveScene(doc, r.SceneSelector)
+	if err != nil {
+		log.Printf("Warning: %v, showing the default scene", err)
+		scene, _ = resolveScene(doc, "")
+	}
+	meshes, err := r.loadSceneMeshes(doc, scene)
+	if err != nil {
+		return err
+	}
+	r.Meshes = meshes
+	r.Scene = scene
+
+	log.Printf("Loaded %d skins, %d nodes, scene %d of %d", len(r.Skins), len(doc.Nodes), r.Scene, len(doc.Scenes))
+
+	// Load animations
+	for _, anim := range doc.Animations {
+		name := anim.Name
+		if name == "" {
+			name = fmt.Sprintf("animation_%d", len(r.Animations))
+		}
+
+		a := &Animation{
+			Name:     name,
+			Channels: make([]AnimationChannel, 0),
+		}
+
+		for _, channel := range anim.Channels {
+			if channel.Target.Node == nil {
+				continue
+			}
+
+			sampler := anim.Samplers[channel.Sampler]
+
+			// Read timestamps
+			timestamps, err := r.readAccessorFloats(doc, int(sampler.Input))
+			if err != nil {
+				log.Printf("Failed to read animation timestamps: %v", err)
+				continue
+			}
+
+			// Read values
+			values, err := r.readAccessorFloats(doc, int(sampler.Output))
+			if err != nil {
+				log.Printf("Failed to read animation values: %v", err)
+				continue
+			}
+
+			// Track maximum duration
+			if len(timestamps) > 0 && timestamps[len(timestamps)-1] > a.Duration {
+				a.Duration = timestamps[len(timestamps)-1]
+			}
+
+			ac := AnimationChannel{
+				NodeIndex:  int(*channel.Target.Node),
+				Path:       channel.Target.Path.String(),
+				Timestamps: timestamps,
+				Values:     values,
+			}
+			if ac.Path == "rotation" && sampler.Interpolation != gltf.InterpolationCubicSpline {
+				normalizeRotationKeys(ac.Values)
+			}
+			a.Channels = append(a.Channels, ac)
+		}
+
+		if len(a.Channels) > 0 {
+			r.Animations[name] = a
+			log.Printf("Loaded animation: %s (duration: %.2fs, channels: %d)", name, a.Duration, len(a.Channels))
+		}
+	}
+
+	return nil
+}
+
+// loadSceneMeshes creates the meshes of every node in a scene, at the
+// level of detail that fits TriangleBudget
+func (r *GLBRenderer) loadSceneMeshes(doc *gltf.Document, scene int) ([]Mesh, error) {
+	var chains [][]int
+	for nodeIdx, inScene := range sceneNodes(doc, scene) {
+		if inScene && doc.Nodes[nodeIdx].Mesh != nil {
+			chains = append(chains, lodChain(doc, nodeIdx))
+		}
+	}
+	level, triangles := selectLOD(chains, func(node int) int { return nodeTriangles(doc, node) }, r.TriangleBudget)
+
+	// Decimate evenly when even the coarsest level is over budget
+	keep := 1.0
+	if r.TriangleBudget > 0 && triangles > r.TriangleBudget {
+		keep = float64(r.TriangleBudget) / float64(triangles)
+	}
+	if level > 0 || keep < 1 {
+		log.Printf("Triangle budget %d: using LOD level %d (%d triangles), keeping %.0f%%", r.TriangleBudget, level, triangles, keep*100)
+	}
+
+	var meshes []Mesh
+	// Where the primitives of each shareable glTF mesh were loaded
+	shared := make(map[int][]int)
+	for _, chain := range chains {
+		nodeIdx := chain[min(level, len(chain)-1)]
+		node := doc.Nodes[nodeIdx]
+		if node.Mesh == nil {
+			// An empty LOD level draws nothing
+			continue
+		}
+		mesh := doc.Meshes[*node.Mesh]
+		shareable := node.Skin == nil && !hasMorphTargets(mesh)
+		if loaded, ok := shared[*node.Mesh]; ok && shareable {
+			for _, i := range loaded {
+				meshes[i].Nodes = append(meshes[i].Nodes, nodeIdx)
+			}
+			continue
+		}
+		for _, prim := range mesh.Primitives {
+			m, err := r.loadPrimitive(doc, prim, keep)
+			if err != nil {
+				r.deleteMeshes(meshes)
+				return nil, fmt.Errorf("load primitive: %w", err)
+			}
+			m.Nodes = []int{nodeIdx}
+			if shareable {
+				shared[*node.Mesh] = append(shared[*node.Mesh], len(meshes))
+			}
+			if m.morph != nil {
+				r.initMorph(&m, mesh)
+			}
+			// Check if this node has a skin
+			if node.Skin != nil {
+				m.SkinIndex = int(*node.Skin)
+			} else {
+				m.SkinIndex = -1
+			}
+			meshes = append(meshes, m)
+		}
+	}
+
+	if len(meshes) == 0 {
+		return nil, fmt.Errorf("no meshes found in scene %d of GLB file", scene)
+	}
+	for i := range meshes {
+		if len(meshes[i].Nodes) > 1 {
+			r.setupInstancing(&meshes[i])
+		}
+	}
+	return meshes, nil
+}
+
+// hasMorphTargets reports whether any primitive of mesh has morph targets
+func hasMorphTargets(mesh *gltf.Mesh) bool {
+	for _, prim := range mesh.Primitives {
+		if len(prim.Targets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// setupInstancing gives a mesh drawn by several nodes a buffer for their
+// transforms, read once per instance as the mat4 at locations 5 to 8
+func (r *GLBRenderer) setupInstancing(m *Mesh) {
+	r.GL.BindVertexArray(m.VAO)
+	r.GL.GenBuffers(1, &m.InstanceVBO)
+	r.GL.BindBuffer(gl.ARRAY_BUFFER, m.InstanceVBO)
+	for column := uint32(0); column < 4; column++ {
+		r.GL.VertexAttribPointerWithOffset(5+column, 4, gl.FLOAT, false, 16*4, uintptr(column*4*4))
+		r.GL.EnableVertexAttribArray(5 + column)
+		r.GL.VertexAttribDivisor(5+column, 1)
+	}
+	r.GL.BindVertexArray(0)
+	r.GL.BindBuffer(gl.ARRAY_BUFFER, 0)
+}
+
+// SetScene switches the loaded model to another of its scenes, by name or
+// index. The current scene stays up if the new one cannot be loaded.
+func (r *GLBRenderer) SetScene(selector string) error {
+	if r.Document == nil {
+		return fmt.Errorf("no model loaded")
+	}
+	scene, err := resolveScene(r.Document, selector)
+	if err != nil {
+		return err
+	}
+	meshes, err := r.loadSceneMeshes(r.Document, scene)
+	if err != nil {
+		return err
+	}
+	r.deleteMeshes(r.Meshes)
+	r.Meshes = meshes
+	r.Scene = scene
+	r.SceneSelector = selector
+	return nil
+}
+
+// loadPrimitive uploads a primitive, decimated to the keep fraction of its
+// triangles
+func (r *GLBRenderer) loadPrimitive(doc *gltf.Document, prim *gltf.Primitive, keep float64) (Mesh, error) {
+	var m Mesh
+	if _, ok := prim.Extensions[extDraco]; ok {
+		return m, fmt.Errorf("Draco-compressed primitives are not supported")
+	}
+	m.Emissive = materialEmissive(doc, prim)
+	m.Opacity, m.AlphaCutoff = materialAlpha(doc, prim)
+	if material := primitiveMaterial(doc, prim); material != nil {
+		m.Transparent = material.AlphaMode == gltf.AlphaBlend
+		m.DoubleSided = material.DoubleSided
+	}
+
+	// Get position data
+	posAccessorIdx, ok := prim.Attributes[gltf.POSITION]
+	if !ok {
+		return m, fmt.Errorf("no POSITION attribute")
+	}
+	positions, err := modeler.ReadPosition(doc, doc.Accessors[posAccessorIdx], nil)
+	if err != nil {
+		return m, fmt.Errorf("read positions: %w", err)
+	}
+
+	// Get normal data (optional)
+	var normals [][3]float32
+	if normalIdx, ok := prim.Attributes[gltf.NORMAL]; ok {
+		normals, err = modeler.ReadNormal(doc, doc.Accessors[normalIdx], nil)
+		if err != nil {
+			normals = nil
+		}
+	}
+
+	// Get texture coordinates (optional), the ones the material's base
+	// color texture uses and transformed the way it is
+	var texCoords [][2]float32
+	texAttribute, transform := baseColorTexCoords(doc, prim)
+	if texIdx, ok := prim.Attributes[texAttribute]; ok {
+		texCoords, err = modeler.ReadTextureCoord(doc, doc.Accessors[texIdx], nil)
+		if err != nil {
+			texCoords = nil
+		}
+	}
+	if transform != nil {
+		for i := range texCoords {
+			texCoords[i] = transform.apply(texCoords[i])
+		}
+	}
+
+	// Get joint indices (for skinning)
+	var joints [][4]uint16
+	if jointIdx, ok := prim.Attributes[gltf.JOINTS_0]; ok {
+		joints, err = modeler.ReadJoints(doc, doc.Accessors[jointIdx], nil)
+		if err != nil {
+			log.Printf("Failed to read joints: %v", err)
+			joints = nil
+		}
+	}
+
+	// Get weights (for skinning)
+	var weights [][4]float32
+	if weightIdx, ok := prim.Attributes[gltf.WEIGHTS_0]; ok {
+		weights, err = modeler.ReadWeights(doc, doc.Accessors[weightIdx], nil)
+		if err != nil {
+			log.Printf("Failed to read weights: %v", err)
+			weights = nil
+		}
+	}
+
+	// Build interleaved vertex data: position (3) + normal (3) + texcoord (2) + joints (4) + weights (4) = 16 floats per vertex
+	vertexData := make([]float32, 0, len(positions)*16)
+	for i, pos := range positions {
+		// Position
+		vertexData = append(vertexData, pos[0], pos[1], pos[2])
+
+		// Normal
+		if normals != nil && i < len(normals) {
+			vertexData = append(vertexData, normals[i][0], normals[i][1], normals[i][2])
+		} else {
+			vertexData = append(vertexData, 0, 1, 0)
+		}
+
+		// Texture coordinates
+		if texCoords != nil && i < len(texCoords) {
+			vertexData = append(vertexData, texCoords[i][0], texCoords[i][1])
+		} else {
+			// Generate UV based on position if not available
+			vertexData = append(vertexData, (pos[0]+1)/2, (pos[1]+1)/2)
+		}
+
+		// Joint indices (as floats for shader)
+		if joints != nil && i < len(joints) {
+			vertexData = append(vertexData,
+				float32(joints[i][0]),
+				float32(joints[i][1]),
+				float32(joints[i][2]),
+				float32(joints[i][3]))
+		} else {
+			vertexData = append(vertexData, 0, 0, 0, 0)
+		}
+
+		// Weights
+		if weights != nil && i < len(weights) {
+			vertexData = append(vertexData,
+				weights[i][0],
+				weights[i][1],
+				weights[i][2],
+				weights[i][3])
+		} else {
+			vertexData = append(vertexData, 0, 0, 0, 0)
+		}
+	}
+
+	// Create VAO
+	r.GL.GenVertexArrays(1, &m.VAO)
+	r.GL.BindVertexArray(m.VAO)
+
+	// Create VBO
+	r.GL.GenBuffers(1, &m.VBO)
+	r.GL.BindBuffer(gl.ARRAY_BUFFER, m.VBO)
+	r.GL.BufferData(gl.ARRAY_BUFFER, len(vertexData)*4, gl.Ptr(vertexData), gl.STATIC_DRAW)
+
+	setVertexAttributes(r.GL)
+	m.Vertices = int32(len(positions))
+	m.Bounds = positionBounds(positions)
+	m.morph = loadMorphTargets(doc, prim, vertexData)
+
+	// Handle indices if present
+	var indices []uint32
+	if prim.Indices != nil {
+		indices, err = modeler.ReadIndices(doc, doc.Accessors[*prim.Indices], nil)
+		if err != nil {
+			indices = nil
+		}
+	}
+	if keep < 1 {
+		if indices == nil {
+			indices = make([]uint32, len(positions))
+			for i := range indices {
+				indices[i] = uint32(i)
+			}
+		}
+		indices = decimate(positions, indices, int(float64(len(indices)/3)*keep))
+		if len(indices) == 0 {
+			// A degenerate triangle, so the mesh is not drawn unindexed
+			indices = []uint32{0, 0, 0}
+		}
+	}
+	if len(indices) > 0 {
+		r.GL.GenBuffers(1, &m.EBO)
+		r.GL.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, m.EBO)
+		r.GL.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, gl.Ptr(indices), gl.STATIC_DRAW)
+		m.HasIndices = true
+		m.IndexCount = int32(len(indices))
+	}
+
+	if !m.HasIndices {
+		m.VertexCount = int32(len(positions))
+	}
+
+	r.GL.BindVertexArray(0)
+	return m, nil
+}
+
+// setVertexAttributes describes the interleaved vertex layout of the bound
+// array buffer to the bound VAO
+func setVertexAttributes(api GL) {
+	stride := int32(16 * 4) // 16 floats * 4 bytes
+
+	// Position attribute (location 0)
+	api.VertexAttribPointerWithOffset(0, 3, gl.FLOAT, false, stride, 0)
+	api.EnableVertexAttribArray(0)
+
+	// Normal attribute (location 1)
+	api.VertexAttribPointerWithOffset(1, 3, gl.FLOAT, false, stride, 3*4)
+	api.EnableVertexAttribArray(1)
+
+	// Texture coordinate attribute (location 2)
+	api.VertexAttribPointerWithOffset(2, 2, gl.FLOAT, false, stride, 6*4)
+	api.EnableVertexAttribArray(2)
+
+	// Joint indices attribute (location 3)
+	api.VertexAttribPointerWithOffset(3, 4, gl.FLOAT, false, stride, 8*4)
+	api.EnableVertexAttribArray(3)
+
+	// Weights attribute (location 4)
+	api.VertexAttribPointerWithOffset(4, 4, gl.FLOAT, false, stride, 12*4)
+	api.EnableVertexAttribArray(4)
+}
+
+// UpdateTexture updates the desktop texture with new buffer data
+func (r *GLBRenderer) UpdateTexture(buffer []byte, width, height, stride int32) {
+	if len(buffer) == 0 {
+		return
+	}
+
+	r.GL.BindTexture(gl.TEXTURE_2D, r.TextureID)
+
+	// Check if texture needs to be resized
+	if r.TextureWidth != width || r.TextureHeight != height {
+		r.GL.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+		r.TextureWidth = width
+		r.TextureHeight = height
+	}
+
+	// Update texture data
+	r.GL.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, width, height, gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&buffer[0]))
+}
+
+// UploadSource uploads source's frame to the desktop texture, unless it is
+// the frame last uploaded from it
+func (r *GLBRenderer) UploadSource(source TextureSource) {
+	damage := source.Damage()
+	if source == r.uploadedSource && damage == r.uploadedDamage {
+		return
+	}
+	pixels, stride := source.Frame()
+	if len(pixels) == 0 {
+		return
+	}
+	size := source.Size()
+	r.UpdateTexture(pixels, int32(size.X), int32(size.Y), int32(stride))
+	r.uploadedSource, r.uploadedDamage = source, damage
+}
+
+// PlayAnimation starts playing an animation by name
+func (r *GLBRenderer) PlayAnimation(name string, loop bool) error {
+	anim, ok := r.Animations[name]
+	if !ok {
+		// List available animations for debugging
+		available := make([]string, 0, len(r.Animations))
+		for k := range r.Animations {
+			available = append(available, k)
+		}
+		return fmt.Errorf("animation '%s' not found, available: %v", name, available)
+	}
+
+	r.CurrentAnim = anim
+	r.AnimStartTime = time.Now()
+	r.AnimLoop = loop
+	r.AnimPaused = false
+	r.loops = 0
+	log.Printf("Playing animation: %s (loop: %v)", name, loop)
+	r.notePlayback("play")
+	return nil
+}
+
+// SceneSnapshot is the renderer state that lives outside OpenGL, enough to
+// make a freshly loaded renderer look the same
+type SceneSnapshot struct {
+	Rotation      float32
+	Brightness    float32
+	Tint          mgl32.Vec3
+	Morphs        map[string]float32
+	Animation     string
+	AnimLoop      bool
+	AnimStartTime time.Time
+	AnimPaused    bool
+	PausedAt      time.Time
+	Layers        []LayerSnapshot
+	// Document, Scene and Shaders are what the renderer had loaded, for
+	// rebuilding it in a new GL context without going back to the files,
+	// which may have changed since
+	Document *gltf.Document
+	Scene    string
+	Shaders  [2]string
+}
+
+// LayerSnapshot is an animation layer in a SceneSnapshot
+type LayerSnapshot struct {
+	Animation string
+	Loop      bool
+	StartTime time.Time
+	Bones     []string
+}
+
+// Snapshot captures the current scene state
+func (r *GLBRenderer) Snapshot() SceneSnapshot {
+	s := SceneSnapshot{
+		Rotation:      r.Rotation,
+		Brightness:    r.Brightness,
+		Tint:          r.Tint,
+		Morphs:        maps.Clone(r.morphWeights),
+		AnimLoop:      r.AnimLoop,
+		AnimStartTime: r.AnimStartTime,
+		AnimPaused:    r.AnimPaused,
+		PausedAt:      r.pausedAt,
+		Document:      r.Document,
+		Scene:         r.SceneSelector,
+		Shaders:       r.shaderSources,
+	}
+	if r.CurrentAnim != nil {
+		s.Animation = r.CurrentAnim.Name
+	}
+	for _, layer := range r.Layers {
+		s.Layers = append(s.Layers, LayerSnapshot{
+			Animation: layer.Anim.Name,
+			Loop:      layer.Loop,
+			StartTime: layer.StartTime,
+			Bones:     layer.Bones,
+		})
+	}
+	return s
+}
+
+// Restore applies a snapshot taken from another renderer of the same model,
+// continuing its animation where it was
+func (r *GLBRenderer) Restore(s SceneSnapshot) {
+	r.Rotation = s.Rotation
+	r.Brightness = s.Brightness
+	if s.Tint != (mgl32.Vec3{}) {
+		r.Tint = s.Tint
+	}
+	if len(s.Morphs) > 0 {
+		r.SetMorphWeights(s.Morphs)
+	}
+	if anim, ok := r.Animations[s.Animation]; ok {
+		r.CurrentAnim = anim
+		r.AnimLoop = s.AnimLoop
+		r.AnimStartTime = s.AnimStartTime
+	}
+	for _, layer := range s.Layers {
+		if err := r.PlayLayer(layer.Animation, layer.Loop, layer.Bones); err == nil {
+			r.Layers[len(r.Layers)-1].StartTime = layer.StartTime
+		}
+	}
+	if s.AnimPaused {
+		// Pose the model where it was paused, then hold it there
+		r.AnimPaused, r.pausedAt = true, s.PausedAt
+		r.ResumeAnimation()
+		r.UpdateAnimation()
+		r.PauseAnimation()
+	}
+	// Carrying on where the old renderer was is not a change of playback
+	r.playback = nil
+}
+
+// PlayDefaultAnimation loops the preferred animation, or the first one by
+// name when the model does not have it
+func (r *GLBRenderer) PlayDefaultAnimation(preferred string) {
+	if _, ok := r.Animations[preferred]; !ok {
+		names := make([]string, 0, len(r.Animations))
+		for name := range r.Animations {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			return
+		}
+		sort.Strings(names)
+		preferred = names[0]
+	}
+	r.PlayAnimation(preferred, true)
+}
+
+// StopAnimation stops the current animation
+func (r *GLBRenderer) StopAnimation() {
+	if r.CurrentAnim != nil {
+		r.notePlayback("stop")
+	}
+	r.CurrentAnim = nil
+	r.AnimPaused = false
+	// Reset to base transforms
+	for i := range r.NodeTransforms {
+		r.NodeTransforms[i] = r.BaseTransforms[i]
+	}
+	r.pose++
+}
+
+// PlayLayer plays an animation over the current one, restricted to the
+// nodes matched by bones (see boneMask). Playing a layer that is already
+// playing restarts it with the new mask.
+func (r *GLBRenderer) PlayLayer(name string, loop bool, bones []string) error {
+	anim, ok := r.Animations[name]
+	if !ok {
+		return fmt.Errorf("animation '%s' not found", name)
+	}
+	if r.Document == nil {
+		return fmt.Errorf("no model loaded")
+	}
+	mask, err := boneMask(r.Document, bones)
+	if err != nil {
+		return err
+	}
+
+	r.StopLayer(name)
+	r.Layers = append(r.Layers, AnimationLayer{
+		Anim:      anim,
+		Loop:      loop,
+		StartTime: time.Now(),
+		Bones:     bones,
+		Mask:      mask,
+	})
+	log.Printf("Playing animation layer: %s on %v (loop: %v)", name, bones, loop)
+	return nil
+}
+
+// StopLayer stops the layer playing the named animation, if any
+func (r *GLBRenderer) StopLayer(name string) bool {
+	for i, layer := range r.Layers {
+		if layer.Anim.Name == name {
+			r.Layers = append(r.Layers[:i], r.Layers[i+1:]...)
+			r.pose++
+			return true
+		}
+	}
+	return false
+}
+
+// PauseAnimation holds the current animation at its current pose
+func (r *GLBRenderer) PauseAnimation() {
+	if (r.CurrentAnim == nil && len(r.Layers) == 0) || r.AnimPaused {
+		return
+	}
+	r.AnimPaused = true
+	r.pausedAt = time.Now()
+	r.notePlayback("pause")
+}
+
+// ResumeAnimation continues a paused animation from where it was held
+func (r *GLBRenderer) ResumeAnimation() {
+	if !r.AnimPaused {
+		return
+	}
+	paused := time.Since(r.pausedAt)
+	r.AnimStartTime = r.AnimStartTime.Add(paused)
+	for i := range r.Layers {
+		r.Layers[i].StartTime = r.Layers[i].StartTime.Add(paused)
+	}
+	r.AnimPaused = false
+	r.notePlayback("resume")
+}
+
+// Flash covers the model in color, fading out over d
+func (r *GLBRenderer) Flash(color mgl32.Vec3, d time.Duration) {
+	r.FlashColor = color
+	r.FlashStart = time.Now()
+	r.FlashDuration = d
+}
+
+// flashAmount is how much of a flash started at start still covers the
+// model at now: 1 when it starts, fading linearly to 0 after d
+func flashAmount(start time.Time, d time.Duration, now time.Time) float32 {
+	elapsed := now.Sub(start)
+	if d <= 0 || elapsed < 0 || elapsed >= d {
+		return 0
+	}
+	return 1 - float32(elapsed)/float32(d)
+}
+
+// UpdateAnimation updates the animation state - call this each frame
+func (r *GLBRenderer) UpdateAnimation() {
+	if (r.CurrentAnim == nil && len(r.Layers) == 0) || r.AnimPaused {
+		return
+	}
+	now := time.Now()
+
+	// Finished animations stop, leaving the pose where they ended
+	if r.CurrentAnim != nil {
+		if _, finished := animationTime(r.CurrentAnim, now.Sub(r.AnimStartTime), r.AnimLoop); finished {
+			r.notePlayback("finish")
+			r.CurrentAnim = nil
+		} else if r.AnimLoop && r.CurrentAnim.Duration > 0 {
+			if loops := int(now.Sub(r.AnimStartTime).Seconds() / float64(r.CurrentAnim.Duration)); loops > r.loops {
+				r.loops = loops
+				r.notePlayback("loop")
+			}
+		}
+	}
+	layers := r.Layers[:0]
+	for _, layer := range r.Layers {
+		if _, finished := animationTime(layer.Anim, now.Sub(layer.StartTime), layer.Loop); !finished {
+			layers = append(layers, layer)
+		}
+	}
+	r.Layers = layers
+	if r.CurrentAnim == nil && len(r.Layers) == 0 {
+		return
+	}
+	r.applyPose(now)
+}
+
+// applyPose poses the nodes as the current animation and layers have them
+// at now
+func (r *GLBRenderer) applyPose(now time.Time) {
+	// Reset to base transforms before applying animation
+	for i := range r.NodeTransforms {
+		r.NodeTransforms[i] = r.BaseTransforms[i]
+	}
+	r.pose++
+
+	if r.CurrentAnim != nil {
+		elapsed, _ := animationTime(r.CurrentAnim, now.Sub(r.AnimStartTime), r.AnimLoop)
+		r.applyAnimation(r.CurrentAnim, elapsed, nil)
+	}
+	for _, layer := range r.Layers {
+		t, _ := animationTime(layer.Anim, now.Sub(layer.StartTime), layer.Loop)
+		r.applyAnimation(layer.Anim, t, layer.Mask)
+	}
+}
+
+// animationTime returns how far into anim playback is after running for
+// d, and whether a non-looping animation has finished
+func animationTime(anim *Animation, d time.Duration, loop bool) (float32, bool) {
+	elapsed := float32(d.Seconds())
+	if loop && anim.Duration > 0 {
+		return float32(math.Mod(float64(elapsed), float64(anim.Duration))), false
+	}
+	return elapsed, elapsed > anim.Duration
+}
+
+// applyAnimation poses the nodes anim drives at time elapsed. A non-nil
+// mask restricts it to the marked nodes.
+func (r *GLBRenderer) applyAnimation(anim *Animation, elapsed float32, mask []bool) {
+	for _, channel := range anim.Channels {
+		if channel.NodeIndex < 0 || channel.NodeIndex >= len(r.NodeTransforms) {
+			continue
+		}
+		if mask != nil && (channel.NodeIndex >= len(mask) || !mask[channel.NodeIndex]) {
+			continue
+		}
+
+		// Find the keyframe
+		value := r.interpolateKeyframes(channel, elapsed)
+
+		switch channel.Path {
+		case "translation":
+			if len(value) >= 3 {
+				r.NodeTransforms[channel.NodeIndex].Translation = mgl32.Vec3{value[0], value[1], value[2]}
+			}
+		case "rotation":
+			if len(value) >= 4 {
+				r.NodeTransforms[channel.NodeIndex].Rotation = mgl32.Quat{
+					W: value[3],
+					V: mgl32.Vec3{value[0], value[1], value[2]},
+				}
+			}
+		case "scale":
+			if len(value) >= 3 {
+				r.NodeTransforms[channel.NodeIndex].Scale = mgl32.Vec3{value[0], value[1], value[2]}
+			}
+		}
+	}
+}
+
+// interpolateKeyframes interpolates between keyframes for a given time
+func (r *GLBRenderer) interpolateKeyframes(channel AnimationChannel, t float32) []float32 {
+	if len(channel.Timestamps) == 0 {
+		return nil
+	}
+
+	// Determine component count based on path
+	components := 3
+	if channel.Path == "rotation" {
+		components = 4
+	}
+
+	// Find keyframe indices using binary search
+	count := len(channel.Timestamps)
+	// Find smallest index i such that Timestamps[i] > t.
+	// Then the interval is [i-1, i].
+	idx := sort.Search(count, func(i int) bool {
+		return channel.Timestamps[i] > t
+	})
+
+	// If idx == 0, t is before the first keyframe (shouldn't happen with mod, but for robustness)
+	if idx == 0 {
+		if components <= len(channel.Values) {
+			return channel.Values[0:components]
+		}
+		return nil
+	}
+
+	// If idx == count, t is past the last keyframe (or equal to it)
+	if idx == count {
+		startIdx := (count - 1) * components
+		if startIdx+components <= len(channel.Values) {
+			return channel.Values[startIdx : startIdx+components]
+		}
+		return nil
+	}
+
+	// We are between idx-1 and idx
+	keyIdx := idx - 1
+
+	// Linear interpolation between keyframes
+	t0 := channel.Timestamps[keyIdx]
+	t1 := channel.Timestamps[keyIdx+1]
+	factor := (t - t0) / (t1 - t0)
+	if factor < 0 {
+		factor = 0
+	}
+	if factor > 1 {
+		factor = 1
+	}
+
+	startIdx0 := keyIdx * components
+	startIdx1 := (keyIdx + 1) * components
+
+	if startIdx1+components > len(channel.Values) {
+		return channel.Values[startIdx0 : startIdx0+components]
+	}
+
+	result := make([]float32, components)
+	if channel.Path == "rotation" {
+		// Spherical linear interpolation for quaternions, which takes
+		// the shortest path whatever sign the keys were written with
+		q0 := mgl32.Quat{
+			W: channel.Values[startIdx0+3],
+			V: mgl32.Vec3{channel.Values[startIdx0], channel.Values[startIdx0+1], channel.Values[startIdx0+2]},
+		}
+		q1 := mgl32.Quat{
+			W: channel.Values[startIdx1+3],
+			V: mgl32.Vec3{channel.Values[startIdx1], channel.Values[startIdx1+1], channel.Values[startIdx1+2]},
+		}
+		qr := mgl32.QuatSlerp(q0, q1, factor)
+		result[0] = qr.V[0]
+		result[1] = qr.V[1]
+		result[2] = qr.V[2]
+		result[3] = qr.W
+	} else {
+		// Linear interpolation for translation and scale
+		for i := 0; i < components; i++ {
+			v0 := channel.Values[startIdx0+i]
+			v1 := channel.Values[startIdx1+i]
+			result[i] = v0 + (v1-v0)*factor
+		}
+	}
+
+	return result
+}
+
+// normalizeRotationKeys brings rotation keyframes, x, y, z, w each, to
+// unit length, as keys are used as they are at the ends of a clip. Some
+// exporters also write keys as -q, the same rotation as q, so each key is
+// flipped into the same hemisphere as the one before it.
+func normalizeRotationKeys(values []float32) {
+	var previous mgl32.Quat
+	for i := 0; i+4 <= len(values); i += 4 {
+		q := mgl32.Quat{W: values[i+3], V: mgl32.Vec3{values[i], values[i+1], values[i+2]}}.Normalize()
+		if i > 0 && previous.Dot(q) < 0 {
+			q = q.Scale(-1)
+		}
+		values[i], values[i+1], values[i+2], values[i+3] = q.V[0], q.V[1], q.V[2], q.W
+		previous = q
+	}
+}
+
+// getNodeTransformMatrix returns the transform matrix for a node
+func (r *GLBRenderer) getNodeTransformMatrix(nodeIndex int) mgl32.Mat4 {
+	if nodeIndex < 0 || nodeIndex >= len(r.NodeTransforms) {
+		return mgl32.Ident4()
+	}
+
+	t := r.NodeTransforms[nodeIndex]
+	translation := mgl32.Translate3D(t.Translation[0], t.Translation[1], t.Translation[2])
+	rotation := t.Rotation.Mat4()
+	scale := mgl32.Scale3D(t.Scale[0], t.Scale[1], t.Scale[2])
+
+	return translation.Mul4(rotation).Mul4(scale)
+}
+
+// readAccessorFloats reads float data from a glTF accessor
+func (r *GLBRenderer) readAccessorFloats(doc *gltf.Document, accessorIndex int) ([]float32, error) {
+	if accessorIndex < 0 || accessorIndex >= len(doc.Accessors) {
+		return nil, fmt.Errorf("invalid accessor index: %d", accessorIndex)
+	}
+
+	accessor := doc.Accessors[accessorIndex]
+	bufferView := doc.BufferViews[*accessor.BufferView]
+	buffer := doc.Buffers[bufferView.Buffer]
+
+	data := buffer.Data[bufferView.ByteOffset+accessor.ByteOffset:]
+
+	// Determine element count based on accessor type
+	var elemCount int
+	switch accessor.Type {
+	case gltf.AccessorScalar:
+		elemCount = 1
+	case gltf.AccessorVec2:
+		elemCount = 2
+	case gltf.AccessorVec3:
+		elemCount = 3
+	case gltf.AccessorVec4:
+		elemCount = 4
+	case gltf.AccessorMat4:
+		elemCount = 16
+	default:
+		elemCount = 1
+	}
+
+	totalFloats := int(accessor.Count) * elemCount
+	result := make([]float32, totalFloats)
+
+	for i := 0; i < totalFloats; i++ {
+		offset := i * 4
+		if offset+4 <= len(data) {
+			bits := uint32(data[offset]) | uint32(data[offset+1])<<8 | uint32(data[offset+2])<<16 | uint32(data[offset+3])<<24
+			result[i] = float32frombits(bits)
+		}
+	}
+
+	return result, nil
+}
+
+func float32frombits(b uint32) float32 {
+	return *(*float32)(unsafe.Pointer(&b))
+}
+
+// getGlobalNodeTransform computes the global (world) transform for a node
+func (r *GLBRenderer) getGlobalNodeTransform(nodeIndex int) mgl32.Mat4 {
+	if nodeIndex < 0 || nodeIndex >= len(r.NodeTransforms) {
+		return mgl32.Ident4()
+	}
+
+	localTransform := r.getNodeTransformMatrix(nodeIndex)
+
+	// Walk up the parent chain
+	parentIdx := r.NodeParents[nodeIndex]
+	if parentIdx >= 0 {
+		parentGlobal := r.getGlobalNodeTransform(parentIdx)
+		return parentGlobal.Mul4(localTransform)
+	}
+
+	return localTransform
+}
+
+// computeBoneMatrices calculates the bone matrices for skinned meshes
+func (r *GLBRenderer) computeBoneMatrices(skinIndex int) {
+	if skinIndex < 0 || skinIndex >= len(r.Skins) {
+		return
+	}
+
+	skin := r.Skins[skinIndex]
+
+	// Ensure we have enough space for bone matrices
+	if len(r.BoneMatrices) < len(skin.Joints) {
+		r.BoneMatrices = make([]mgl32.Mat4, len(skin.Joints))
+	}
+
+	for i, jointIndex := range skin.Joints {
+		// Get global transform for the joint
+		globalJointTransform := r.getGlobalNodeTransform(jointIndex)
+
+		// Compute final bone matrix: globalJointTransform * inverseBindMatrix
+		r.BoneMatrices[i] = globalJointTransform.Mul4(skin.InverseBindMatrices[i])
+	}
+}
+
+// camera returns the projection and view matrices the model is drawn with
+func camera(windowWidth, windowHeight int32) (projection, view mgl32.Mat4) {
+	aspect := float32(windowWidth) / float32(windowHeight)
+	projection = mgl32.Perspective(mgl32.DegToRad(45.0), aspect, 0.1, 100.0)
+	view = mgl32.LookAtV(mgl32.Vec3{0, 0, 1}, mgl32.Vec3{0, 0, 0}, mgl32.Vec3{0, 1, 0})
+	return projection, view
+}
+
+// baseModel is the model's rotation and pulse, applied to every mesh
+func (r *GLBRenderer) baseModel() mgl32.Mat4 {
+	return mgl32.HomogRotate3DY(r.Rotation).Mul4(mgl32.Scale3D(1+r.Pulse, 1+r.Pulse, 1+r.Pulse))
+}
+
+// identityBones fills the shader's boneMatrices for unskinned meshes
+var identityBones = func() (bones [128]mgl32.Mat4) {
+	for i := range bones {
+		bones[i] = mgl32.Ident4()
+	}
+	return bones
+}()
+
+// Render draws the loaded model with the current texture
+func (r *GLBRenderer) Render(windowWidth, windowHeight int32) {
+	r.adoptShaders()
+
+	// Update animation
+	r.UpdateAnimation()
+
+	// Set up matrices
+	projection, view := camera(windowWidth, windowHeight)
+
+	// The environment fills the background and lights the model
+	if r.Environment != nil {
+		r.Environment.Render(projection, view)
+	}
+
+	r.GL.UseProgram(r.ShaderProgram)
+	if r.Environment != nil {
+		r.GL.Uniform1i(r.useEnvLoc, 1)
+		r.GL.Uniform3fv(r.envSHLoc, 9, &r.Environment.Light[0][0])
+	} else {
+		r.GL.Uniform1i(r.useEnvLoc, 0)
+	}
+
+	r.GL.UniformMatrix4fv(r.projectionLoc, 1, false, &projection[0])
+	r.GL.UniformMatrix4fv(r.viewLoc, 1, false, &view[0])
+
+	// Bind texture
+	r.uploadNodeTextures()
+	r.GL.ActiveTexture(gl.TEXTURE0)
+	screenTexture := r.TextureID
+	if r.ExternalTexture != 0 {
+		screenTexture = r.ExternalTexture
+	}
+	bound := screenTexture
+	r.GL.BindTexture(gl.TEXTURE_2D, bound)
+	r.GL.Uniform1i(r.textureLoc, 0)
+	r.GL.Uniform1f(r.brightnessLoc, r.Brightness)
+	r.GL.Uniform3f(r.tintLoc, r.Tint[0], r.Tint[1], r.Tint[2])
+	r.GL.Uniform4f(r.flashLoc, r.FlashColor[0], r.FlashColor[1], r.FlashColor[2],
+		flashAmount(r.FlashStart, r.FlashDuration, time.Now()))
+	r.GL.Uniform4f(r.glowLoc, r.GlowColor[0], r.GlowColor[1], r.GlowColor[2], r.Glow)
+
+	// The pose holds still until an animation plays again
+	static := (r.CurrentAnim == nil && len(r.Layers) == 0) || r.AnimPaused
+
+	// Draw the meshes in view, in depth order, with their node transforms
+	baseModel := r.baseModel()
+	identityUploaded := false
+	var emissive mgl32.Vec3
+	opacity, cutoff := float32(1), float32(0)
+	r.GL.Uniform3f(r.emissiveLoc, 0, 0, 0)
+	r.GL.Uniform1f(r.opacityLoc, opacity)
+	r.GL.Uniform1f(r.alphaCutoffLoc, cutoff)
+	r.GL.Uniform1i(r.instancedLoc, 0)
+	// The opaque meshes come first, then the blended ones
+	blending, culling := false, true
+	for _, d := range r.drawList(projection, view, baseModel) {
+		mesh := d.mesh
+		// Meshes of nodes with a texture of their own draw it instead of
+		// the screen
+		texture := r.meshTexture(mesh)
+		if texture == 0 {
+			texture = screenTexture
+		}
+		if texture != bound {
+			r.GL.BindTexture(gl.TEXTURE_2D, texture)
+			bound = texture
+		}
+		if mesh.Transparent && !blending {
+			// Blended meshes show what is behind them and do not hide
+			// each other
+			r.GL.Enable(gl.BLEND)
+			r.GL.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+			r.GL.DepthMask(false)
+			blending = true
+		}
+		if mesh.DoubleSided == culling {
+			culling = !culling
+			r.setCulling(culling)
+		}
+		if mesh.Emissive != emissive {
+			emissive = mesh.Emissive
+			r.GL.Uniform3f(r.emissiveLoc, emissive[0], emissive[1], emissive[2])
+		}
+		if mesh.Opacity != opacity {
+			opacity = mesh.Opacity
+			r.GL.Uniform1f(r.opacityLoc, opacity)
+		}
+		if mesh.AlphaCutoff != cutoff {
+			cutoff = mesh.AlphaCutoff
+			r.GL.Uniform1f(r.alphaCutoffLoc, cutoff)
+		}
+		skinned := mesh.SkinIndex >= 0 && mesh.SkinIndex < len(r.Skins)
+
+		// Skin a still pose once and draw the cached, already skinned
+		// vertices until it moves
+		if skinned && static {
+			if mesh.cache == nil || mesh.cache.pose != r.pose {
+				r.computeBoneMatrices(mesh.SkinIndex)
+				r.cacheSkinnedMesh(mesh)
+			}
+			r.GL.UniformMatrix4fv(r.modelLoc, 1, false, &baseModel[0])
+			r.GL.BindVertexArray(mesh.cache.VAO)
+			r.drawMesh(mesh, 1)
+			continue
+		}
+
+		// Compute and upload bone matrices for skinned meshes
+		if skinned {
+			r.computeBoneMatrices(mesh.SkinIndex)
+
+			if numJoints := min(len(r.Skins[mesh.SkinIndex].Joints), len(identityBones)); numJoints > 0 {
+				r.GL.UniformMatrix4fv(r.boneMatricesLoc, int32(numJoints), false, &r.BoneMatrices[0][0])
+				identityUploaded = false
+			}
+		} else if !identityUploaded {
+			// Unskinned meshes all take identity bones, uploaded once
+			r.GL.UniformMatrix4fv(r.boneMatricesLoc, int32(len(identityBones)), false, &identityBones[0][0])
+			identityUploaded = true
+		}
+
+		r.GL.BindVertexArray(mesh.VAO)
+		// A shader without the instanced uniform, from -shader-dir, gets
+		// one draw per node
+		if len(d.nodes) > 1 && mesh.InstanceVBO != 0 && r.instancedLoc >= 0 {
+			r.GL.BindBuffer(gl.ARRAY_BUFFER, mesh.InstanceVBO)
+			r.GL.BufferData(gl.ARRAY_BUFFER, len(d.nodes)*16*4, gl.Ptr(&d.nodes[0][0]), gl.STREAM_DRAW)
+			r.GL.BindBuffer(gl.ARRAY_BUFFER, 0)
+			r.GL.UniformMatrix4fv(r.modelLoc, 1, false, &baseModel[0])
+			r.GL.Uniform1i(r.instancedLoc, 1)
+			r.drawMesh(mesh, len(d.nodes))
+			r.GL.Uniform1i(r.instancedLoc, 0)
+			continue
+		}
+		for _, node := range d.nodes {
+			model := baseModel.Mul4(node)
+			r.GL.UniformMatrix4fv(r.modelLoc, 1, false, &model[0])
+			r.drawMesh(mesh, 1)
+		}
+	}
+
+	if blending {
+		r.GL.DepthMask(true)
+		r.GL.Disable(gl.BLEND)
+	}
+	if !culling {
+		r.setCulling(true)
+	}
+	r.GL.BindVertexArray(0)
+}
+
+// setCulling turns back-face culling on or off
+func (r *GLBRenderer) setCulling(on bool) {
+	if on {
+		r.GL.Enable(gl.CULL_FACE)
+	} else {
+		r.GL.Disable(gl.CULL_FACE)
+	}
+}
+
+// drawMesh draws the bound VAO's triangles, instances times
+func (r *GLBRenderer) drawMesh(mesh *Mesh, instances int) {
+	switch {
+	case instances > 1 && mesh.HasIndices:
+		r.GL.DrawElementsInstanced(gl.TRIANGLES, mesh.IndexCount, gl.UNSIGNED_INT, nil, int32(instances))
+	case instances > 1:
+		r.GL.DrawArraysInstanced(gl.TRIANGLES, 0, mesh.VertexCount, int32(instances))
+	case mesh.HasIndices:
+		r.GL.DrawElements(gl.TRIANGLES, mesh.IndexCount, gl.UNSIGNED_INT, nil)
+	default:
+		r.GL.DrawArrays(gl.TRIANGLES, 0, mesh.VertexCount)
+	}
+}
+
+// unloadModel frees the current model's buffers and forgets its state
+func (r *GLBRenderer) unloadModel() {
+	r.deleteMeshes(r.Meshes)
+	r.Meshes = nil
+	r.Skins = nil
+	r.BoneMatrices = nil
+	r.NodeParents = nil
+	r.NodeTransforms = nil
+	r.BaseTransforms = nil
+	r.Animations = make(map[string]*Animation)
+	r.CurrentAnim = nil
+	r.Layers = nil
+	r.morphWeights = nil
+	r.Document = nil
+}
+
+// deleteMeshes frees the GL buffers of meshes
+func (r *GLBRenderer) deleteMeshes(meshes []Mesh) {
+	for i := range meshes {
+		mesh := &meshes[i]
+		deleteSkinCache(mesh)
+		r.GL.DeleteVertexArrays(1, &mesh.VAO)
+		r.GL.DeleteBuffers(1, &mesh.VBO)
+		if mesh.HasIndices {
+			r.GL.DeleteBuffers(1, &mesh.EBO)
+		}
+		if mesh.InstanceVBO != 0 {
+			r.GL.DeleteBuffers(1, &mesh.InstanceVBO)
+		}
+	}
+}
+
+// Destroy cleans up OpenGL resources
+func (r *GLBRenderer) Destroy() {
+	r.unloadModel()
+	r.GL.DeleteTextures(1, &r.TextureID)
+	r.deleteNodeTextures()
+	r.GL.DeleteProgram(r.ShaderProgram)
+	if r.pendingShaders != nil {
+		r.shaders.Cancel(r.pendingShaders)
+		r.pendingShaders = nil
+	}
+	if r.skinCacheProgram != 0 {
+		gl.DeleteProgram(r.skinCacheProgram)
+	}
+}
+
+// newShaderProgram compiles and links a vertex/fragment shader pair, or
+// loads it from cache
+func newShaderProgram(cache *ProgramCache, vertexSource, fragmentSource string) (uint32, error) {
+	return linkProgram(glShaderCompiler{}, cache, vertexSource, fragmentSource)
+}
+
+func compileShader(source string, shaderType uint32) (uint32, error) {
+	shader := gl.CreateShader(shaderType)
+	csources, free := gl.Strs(source)
+	gl.ShaderSource(shader, 1, csources, nil)
+	free()
+	gl.CompileShader(shader)
+
+	var status int32
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
+		log := make([]byte, logLength)
+		gl.GetShaderInfoLog(shader, logLength, nil, &log[0])
+		return 0, fmt.Errorf("compile: %s", string(log))
+	}
+
+	return shader, nil
+}
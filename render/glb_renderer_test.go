@@ -0,0 +1,197 @@
+//go:build !nogl
+
+package render
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+)
+
+func TestSceneSnapshotRestore(t *testing.T) {
+	started := time.Now().Add(-3 * time.Second)
+	old := &GLBRenderer{
+		Animations:    map[string]*Animation{"Bark": {Name: "Bark"}},
+		Rotation:      1.5,
+		Brightness:    0.2,
+		AnimLoop:      true,
+		AnimStartTime: started,
+		Document:      &gltf.Document{},
+		SceneSelector: "Outdoor",
+		shaderSources: [2]string{"vertex", "fragment"},
+	}
+	old.CurrentAnim = old.Animations["Bark"]
+
+	// A renderer for the same model loads its own copy of each animation
+	fresh := &GLBRenderer{
+		Animations: map[string]*Animation{"Bark": {Name: "Bark"}},
+		Brightness: 1,
+	}
+	fresh.Restore(old.Snapshot())
+
+	if fresh.Rotation != 1.5 || fresh.Brightness != 0.2 {
+		t.Errorf("Expected rotation 1.5 and brightness 0.2, got %v and %v", fresh.Rotation, fresh.Brightness)
+	}
+	if fresh.CurrentAnim != fresh.Animations["Bark"] || !fresh.AnimLoop || !fresh.AnimStartTime.Equal(started) {
+		t.Error("Animation should continue on the new renderer's copy from where it was")
+	}
+
+	// A renderer in a new context is built from what the old one loaded
+	snapshot := old.Snapshot()
+	if snapshot.Document != old.Document || snapshot.Scene != "Outdoor" || snapshot.Shaders != old.shaderSources {
+		t.Errorf("Expected the loaded document, scene and shaders kept, got %p, %q and %q", snapshot.Document, snapshot.Scene, snapshot.Shaders)
+	}
+}
+
+func TestPauseAnimationHoldsThePose(t *testing.T) {
+	r := &GLBRenderer{Animations: map[string]*Animation{"Wave": {Name: "Wave", Duration: 10}}}
+	r.PlayAnimation("Wave", true)
+	r.UpdateAnimation()
+	playing := r.pose
+
+	r.PauseAnimation()
+	r.UpdateAnimation()
+	if r.pose != playing {
+		t.Error("A paused animation should not change the pose")
+	}
+
+	started := r.AnimStartTime
+	r.pausedAt = r.pausedAt.Add(-time.Second)
+	r.ResumeAnimation()
+	if r.AnimPaused || r.AnimStartTime.Sub(started) < time.Second {
+		t.Errorf("Resuming should continue where it paused, start moved by %v", r.AnimStartTime.Sub(started))
+	}
+	r.UpdateAnimation()
+	if r.pose == playing {
+		t.Error("A playing animation should change the pose")
+	}
+}
+
+func TestAnimationLayerMask(t *testing.T) {
+	doc := &gltf.Document{Nodes: []*gltf.Node{
+		{Name: "Hips", Children: []int{1}},
+		{Name: "RightArm"},
+	}}
+	move := func(name string, x float32) *Animation {
+		return &Animation{Name: name, Duration: 1, Channels: []AnimationChannel{
+			{NodeIndex: 0, Path: "translation", Timestamps: []float32{0}, Values: []float32{x, 0, 0}},
+			{NodeIndex: 1, Path: "translation", Timestamps: []float32{0}, Values: []float32{x, 0, 0}},
+		}}
+	}
+	base := []NodeTransform{{Rotation: mgl32.QuatIdent()}, {Rotation: mgl32.QuatIdent()}}
+	r := &GLBRenderer{
+		Document:       doc,
+		Animations:     map[string]*Animation{"Idle": move("Idle", 1), "Wave": move("Wave", 2)},
+		NodeTransforms: append([]NodeTransform(nil), base...),
+		BaseTransforms: base,
+	}
+	r.PlayAnimation("Idle", true)
+	if err := r.PlayLayer("Wave", true, []string{"Right*"}); err != nil {
+		t.Fatal(err)
+	}
+	r.UpdateAnimation()
+	if x := r.NodeTransforms[0].Translation.X(); x != 1 {
+		t.Errorf("Hips should follow the base animation, got x=%v", x)
+	}
+	if x := r.NodeTransforms[1].Translation.X(); x != 2 {
+		t.Errorf("The arm should follow the layer, got x=%v", x)
+	}
+
+	if !r.StopLayer("Wave") || r.StopLayer("Wave") {
+		t.Error("Expected StopLayer to remove the layer once")
+	}
+	r.UpdateAnimation()
+	if x := r.NodeTransforms[1].Translation.X(); x != 1 {
+		t.Errorf("The arm should go back to the base animation, got x=%v", x)
+	}
+}
+
+func TestFlashAmount(t *testing.T) {
+	start := time.Unix(100, 0)
+	for _, c := range []struct {
+		at   time.Duration
+		want float32
+	}{{-time.Second, 0}, {0, 1}, {250 * time.Millisecond, 0.75}, {time.Second, 0}} {
+		if got := flashAmount(start, time.Second, start.Add(c.at)); got != c.want {
+			t.Errorf("At %v: expected %v, got %v", c.at, c.want, got)
+		}
+	}
+	if flashAmount(start, 0, start) != 0 {
+		t.Error("A flash without a duration should not show")
+	}
+}
+
+// flippedRotationClip is a model whose "Turn" animation turns node 0 from
+// 20 to 60 degrees about Y, exported the way some tools do: the first key
+// three times unit length and the second written as -q
+func flippedRotationClip(t *testing.T) *gltf.Document {
+	t.Helper()
+	doc, err := builtinModel("cube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := mgl32.QuatRotate(mgl32.DegToRad(20), mgl32.Vec3{0, 1, 0}).Scale(3)
+	to := mgl32.QuatRotate(mgl32.DegToRad(60), mgl32.Vec3{0, 1, 0}).Scale(-1)
+	times := modeler.WriteAccessor(doc, gltf.TargetNone, []float32{0, 1})
+	rotations := modeler.WriteAccessor(doc, gltf.TargetNone, [][4]float32{
+		{from.V[0], from.V[1], from.V[2], from.W},
+		{to.V[0], to.V[1], to.V[2], to.W},
+	})
+	doc.Animations = []*gltf.Animation{{
+		Name:     "Turn",
+		Samplers: []*gltf.AnimationSampler{{Input: times, Output: rotations}},
+		Channels: []*gltf.AnimationChannel{{
+			Sampler: 0,
+			Target:  gltf.AnimationChannelTarget{Node: gltf.Index(0), Path: gltf.TRSRotation},
+		}},
+	}}
+	return doc
+}
+
+func TestSignFlippedRotationClip(t *testing.T) {
+	r := &GLBRenderer{GL: newRecordingGL(), Animations: make(map[string]*Animation)}
+	r.lookupUniforms()
+	if err := r.LoadDocument(flippedRotationClip(t)); err != nil {
+		t.Fatal(err)
+	}
+	turn := r.Animations["Turn"]
+	if turn == nil {
+		t.Fatal("the Turn animation did not load")
+	}
+	values := turn.Channels[0].Values
+	for i := 0; i < len(values); i += 4 {
+		q := mgl32.Quat{W: values[i+3], V: mgl32.Vec3{values[i], values[i+1], values[i+2]}}
+		if math.Abs(float64(q.Len())-1) > 1e-5 {
+			t.Errorf("key %d has length %v after loading, want 1", i/4, q.Len())
+		}
+	}
+
+	// The first key is used as it is before the clip starts moving
+	r.applyAnimation(turn, 0, nil)
+	first := mgl32.QuatRotate(mgl32.DegToRad(20), mgl32.Vec3{0, 1, 0})
+	if got := r.NodeTransforms[0].Rotation; !got.ApproxEqualThreshold(first, 1e-5) {
+		t.Errorf("at the start the rotation is %v, want %v", got, first)
+	}
+
+	want := mgl32.QuatRotate(mgl32.DegToRad(40), mgl32.Vec3{0, 1, 0})
+	r.applyAnimation(turn, 0.5, nil)
+	if got := r.NodeTransforms[0].Rotation; math.Abs(float64(got.Dot(want))) < 0.9999 {
+		t.Errorf("halfway through the rotation is %v, want 40 degrees about Y (%v)", got, want)
+	}
+}
+
+func TestNormalizeRotationKeys(t *testing.T) {
+	q := mgl32.QuatRotate(mgl32.DegToRad(30), mgl32.Vec3{1, 0, 0})
+	values := []float32{0, 0, 0, 2, -q.V[0], -q.V[1], -q.V[2], -q.W, 0, 0, 0, 0}
+	normalizeRotationKeys(values)
+	want := []float32{0, 0, 0, 1, q.V[0], q.V[1], q.V[2], q.W, 0, 0, 0, 1}
+	for i := range values {
+		if math.Abs(float64(values[i]-want[i])) > 1e-6 {
+			t.Fatalf("got %v, want %v", values, want)
+		}
+	}
+}
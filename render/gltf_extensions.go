@@ -0,0 +1,162 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/qmuntal/gltf"
+)
+
+const (
+	extDraco            = "KHR_draco_mesh_compression"
+	extTextureTransform = "KHR_texture_transform"
+	extEmissiveStrength = "KHR_materials_emissive_strength"
+)
+
+// handledExtensions are the extensions a model can require and still be
+// shown right. Materials are not drawn, the desktop is, so material-only
+// extensions are harmless.
+var handledExtensions = map[string]bool{
+	extTextureTransform:                   true,
+	extEmissiveStrength:                   true,
+	"MSFT_lod":                            true,
+	"KHR_materials_unlit":                 true,
+	"KHR_materials_specular":              true,
+	"KHR_materials_ior":                   true,
+	"KHR_materials_clearcoat":             true,
+	"KHR_materials_sheen":                 true,
+	"KHR_materials_transmission":          true,
+	"KHR_materials_volume":                true,
+	"KHR_materials_pbrSpecularGlossiness": true,
+	"KHR_lights_punctual":                 true,
+}
+
+// decodeExtension decodes the extension called name into v, whether the
+// gltf package left it as raw JSON or decoded it itself
+func decodeExtension(exts gltf.Extensions, name string, v any) bool {
+	raw, ok := exts[name]
+	if !ok {
+		return false
+	}
+	var data []byte
+	switch r := raw.(type) {
+	case json.RawMessage:
+		data = r
+	default:
+		data, _ = json.Marshal(r)
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// checkRequiredExtensions fails for a model that requires extensions the
+// loader cannot handle, rather than drawing it wrong
+func checkRequiredExtensions(doc *gltf.Document) error {
+	var missing []string
+	for _, name := range doc.ExtensionsRequired {
+		if name == extDraco {
+			return fmt.Errorf("the model is Draco-compressed (%s), which is not supported; decompress it first, e.g. with glTF-Transform", extDraco)
+		}
+		if !handledExtensions[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("the model requires unsupported extensions: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// textureTransform is KHR_texture_transform on a texture reference
+type textureTransform struct {
+	Offset   [2]float64  `json:"offset"`
+	Rotation float64     `json:"rotation"`
+	Scale    *[2]float64 `json:"scale"`
+	TexCoord *int        `json:"texCoord"`
+}
+
+// apply maps uv as the extension does: scaled, then rotated, then offset
+func (t textureTransform) apply(uv [2]float32) [2]float32 {
+	scale := [2]float64{1, 1}
+	if t.Scale != nil {
+		scale = *t.Scale
+	}
+	u, v := float64(uv[0])*scale[0], float64(uv[1])*scale[1]
+	sin, cos := math.Sincos(t.Rotation)
+	return [2]float32{
+		float32(cos*u + sin*v + t.Offset[0]),
+		float32(-sin*u + cos*v + t.Offset[1]),
+	}
+}
+
+// primitiveMaterial returns the primitive's material, nil without one
+func primitiveMaterial(doc *gltf.Document, prim *gltf.Primitive) *gltf.Material {
+	if prim.Material == nil || *prim.Material >= len(doc.Materials) {
+		return nil
+	}
+	return doc.Materials[*prim.Material]
+}
+
+// baseColorTexCoords returns the TEXCOORD attribute the primitive's base
+// color texture is mapped with, and its KHR_texture_transform if it has
+// one. The desktop takes the base color texture's place, so it is mapped
+// the same way.
+func baseColorTexCoords(doc *gltf.Document, prim *gltf.Primitive) (string, *textureTransform) {
+	material := primitiveMaterial(doc, prim)
+	if material == nil || material.PBRMetallicRoughness == nil || material.PBRMetallicRoughness.BaseColorTexture == nil {
+		return gltf.TEXCOORD_0, nil
+	}
+	info := material.PBRMetallicRoughness.BaseColorTexture
+	texCoord := info.TexCoord
+	var transform *textureTransform
+	var t textureTransform
+	if decodeExtension(info.Extensions, extTextureTransform, &t) {
+		transform = &t
+		if t.TexCoord != nil {
+			texCoord = *t.TexCoord
+		}
+	}
+	return fmt.Sprintf("TEXCOORD_%d", texCoord), transform
+}
+
+// materialAlpha is how see-through the primitive's material makes it: the
+// alpha of its base color factor, which the desktop's alpha is multiplied
+// by, and for alphaMode MASK the alpha below which it is cut away, 0 for
+// none. OPAQUE materials are fully opaque whatever their factor says.
+func materialAlpha(doc *gltf.Document, prim *gltf.Primitive) (opacity, cutoff float32) {
+	material := primitiveMaterial(doc, prim)
+	if material == nil || material.AlphaMode == gltf.AlphaOpaque {
+		return 1, 0
+	}
+	opacity = 1
+	if pbr := material.PBRMetallicRoughness; pbr != nil {
+		opacity = float32(pbr.BaseColorFactorOrDefault()[3])
+	}
+	if material.AlphaMode == gltf.AlphaMask {
+		cutoff = float32(material.AlphaCutoffOrDefault())
+	}
+	return opacity, cutoff
+}
+
+// materialEmissive is the light the primitive's material gives off: its
+// emissive factor times KHR_materials_emissive_strength. Emissive textures
+// are not loaded, so a material with one gives off nothing rather than
+// glowing all over.
+func materialEmissive(doc *gltf.Document, prim *gltf.Primitive) [3]float32 {
+	material := primitiveMaterial(doc, prim)
+	if material == nil || material.EmissiveTexture != nil {
+		return [3]float32{}
+	}
+	strength := struct {
+		EmissiveStrength float64 `json:"emissiveStrength"`
+	}{1}
+	decodeExtension(material.Extensions, extEmissiveStrength, &strength)
+	var emissive [3]float32
+	for i, f := range material.EmissiveFactor {
+		emissive[i] = float32(f * strength.EmissiveStrength)
+	}
+	return emissive
+}
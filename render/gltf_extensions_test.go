@@ -0,0 +1,116 @@
+package render
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/qmuntal/gltf"
+)
+
+func TestCheckRequiredExtensions(t *testing.T) {
+	doc := &gltf.Document{ExtensionsRequired: []string{extTextureTransform, "KHR_materials_unlit"}}
+	if err := checkRequiredExtensions(doc); err != nil {
+		t.Errorf("handled extensions rejected: %v", err)
+	}
+
+	doc.ExtensionsRequired = []string{extDraco}
+	if err := checkRequiredExtensions(doc); err == nil || !strings.Contains(err.Error(), "Draco") {
+		t.Errorf("Draco: %v", err)
+	}
+
+	doc.ExtensionsRequired = []string{"KHR_texture_basisu", "EXT_meshopt_compression"}
+	err := checkRequiredExtensions(doc)
+	if err == nil || !strings.HasSuffix(err.Error(), "EXT_meshopt_compression, KHR_texture_basisu") {
+		t.Errorf("unsupported extensions: %v", err)
+	}
+}
+
+func TestTextureTransformApply(t *testing.T) {
+	tests := []struct {
+		transform textureTransform
+		uv, want  [2]float32
+	}{
+		{textureTransform{}, [2]float32{0.25, 0.5}, [2]float32{0.25, 0.5}},
+		{textureTransform{Offset: [2]float64{0.5, 0}, Scale: &[2]float64{0.5, 2}}, [2]float32{1, 1}, [2]float32{1, 2}},
+		// A quarter turn sends u to -v
+		{textureTransform{Rotation: math.Pi / 2}, [2]float32{1, 0}, [2]float32{0, -1}},
+	}
+	for _, tt := range tests {
+		got := tt.transform.apply(tt.uv)
+		if math.Abs(float64(got[0]-tt.want[0])) > 1e-6 || math.Abs(float64(got[1]-tt.want[1])) > 1e-6 {
+			t.Errorf("%+v.apply(%v) = %v, want %v", tt.transform, tt.uv, got, tt.want)
+		}
+	}
+}
+
+func TestBaseColorTexCoords(t *testing.T) {
+	doc := &gltf.Document{Materials: []*gltf.Material{
+		{PBRMetallicRoughness: &gltf.PBRMetallicRoughness{BaseColorTexture: &gltf.TextureInfo{
+			TexCoord:   0,
+			Extensions: gltf.Extensions{extTextureTransform: json.RawMessage(`{"offset": [0.5, 0], "texCoord": 1}`)},
+		}}},
+		{PBRMetallicRoughness: &gltf.PBRMetallicRoughness{BaseColorTexture: &gltf.TextureInfo{TexCoord: 2}}},
+	}}
+
+	attribute, transform := baseColorTexCoords(doc, &gltf.Primitive{Material: gltf.Index(0)})
+	if attribute != "TEXCOORD_1" || transform == nil || transform.Offset != [2]float64{0.5, 0} {
+		t.Errorf("transformed: %s, %+v", attribute, transform)
+	}
+	if attribute, transform := baseColorTexCoords(doc, &gltf.Primitive{Material: gltf.Index(1)}); attribute != "TEXCOORD_2" || transform != nil {
+		t.Errorf("plain: %s, %+v", attribute, transform)
+	}
+	if attribute, _ := baseColorTexCoords(doc, &gltf.Primitive{}); attribute != gltf.TEXCOORD_0 {
+		t.Errorf("no material: %s", attribute)
+	}
+}
+
+func TestMaterialEmissive(t *testing.T) {
+	doc := &gltf.Document{Materials: []*gltf.Material{
+		{EmissiveFactor: [3]float64{1, 0.5, 0}, Extensions: gltf.Extensions{extEmissiveStrength: json.RawMessage(`{"emissiveStrength": 4}`)}},
+		{EmissiveFactor: [3]float64{1, 1, 1}, EmissiveTexture: &gltf.TextureInfo{Index: 0}},
+		{EmissiveFactor: [3]float64{0.2, 0.2, 0.2}},
+	}}
+	tests := []struct {
+		material int
+		want     [3]float32
+	}{
+		{0, [3]float32{4, 2, 0}},
+		{1, [3]float32{}},
+		{2, [3]float32{0.2, 0.2, 0.2}},
+	}
+	for _, tt := range tests {
+		if got := materialEmissive(doc, &gltf.Primitive{Material: gltf.Index(tt.material)}); got != tt.want {
+			t.Errorf("material %d: emissive %v, want %v", tt.material, got, tt.want)
+		}
+	}
+}
+
+func TestMaterialAlpha(t *testing.T) {
+	half := &gltf.PBRMetallicRoughness{BaseColorFactor: &[4]float64{1, 1, 1, 0.5}}
+	doc := &gltf.Document{Materials: []*gltf.Material{
+		{AlphaMode: gltf.AlphaOpaque, PBRMetallicRoughness: half},
+		{AlphaMode: gltf.AlphaBlend, PBRMetallicRoughness: half},
+		{AlphaMode: gltf.AlphaMask},
+		{AlphaMode: gltf.AlphaMask, AlphaCutoff: gltf.Float(0.25), PBRMetallicRoughness: half},
+	}}
+	tests := []struct {
+		material        int
+		opacity, cutoff float32
+	}{
+		{0, 1, 0},
+		{1, 0.5, 0},
+		{2, 1, 0.5},
+		{3, 0.5, 0.25},
+	}
+	for _, tt := range tests {
+		opacity, cutoff := materialAlpha(doc, &gltf.Primitive{Material: gltf.Index(tt.material)})
+		if opacity != tt.opacity || cutoff != tt.cutoff {
+			t.Errorf("material %d: opacity %v cutoff %v, want %v and %v", tt.material, opacity, cutoff, tt.opacity, tt.cutoff)
+		}
+	}
+	if opacity, cutoff := materialAlpha(doc, &gltf.Primitive{}); opacity != 1 || cutoff != 0 {
+		t.Errorf("no material: opacity %v cutoff %v", opacity, cutoff)
+	}
+}
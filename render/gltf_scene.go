@@ -0,0 +1,71 @@
+package render
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/qmuntal/gltf"
+)
+
+// resolveScene picks a scene of doc by name or index. An empty selector
+// means the document's default scene (doc.Scene, else the first one). It
+// returns -1 for documents without scenes, whose nodes are all shown.
+func resolveScene(doc *gltf.Document, selector string) (int, error) {
+	if len(doc.Scenes) == 0 {
+		if selector != "" {
+			return 0, fmt.Errorf("scene %q not found, the model has no scenes", selector)
+		}
+		return -1, nil
+	}
+	if selector == "" {
+		if doc.Scene != nil && *doc.Scene < len(doc.Scenes) {
+			return *doc.Scene, nil
+		}
+		return 0, nil
+	}
+	for i, scene := range doc.Scenes {
+		if scene.Name == selector {
+			return i, nil
+		}
+	}
+	if i, err := strconv.Atoi(selector); err == nil && i >= 0 && i < len(doc.Scenes) {
+		return i, nil
+	}
+	return 0, fmt.Errorf("scene %q not found, available: %v", selector, sceneNames(doc))
+}
+
+// sceneNodes marks the nodes reachable from a scene's root nodes. Scene -1
+// marks every node.
+func sceneNodes(doc *gltf.Document, scene int) []bool {
+	nodes := make([]bool, len(doc.Nodes))
+	if scene < 0 || scene >= len(doc.Scenes) {
+		for i := range nodes {
+			nodes[i] = true
+		}
+		return nodes
+	}
+
+	pending := append([]int(nil), doc.Scenes[scene].Nodes...)
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if node < 0 || node >= len(nodes) || nodes[node] {
+			continue
+		}
+		nodes[node] = true
+		pending = append(pending, doc.Nodes[node].Children...)
+	}
+	return nodes
+}
+
+// sceneNames lists the scenes of doc, using the index for unnamed ones
+func sceneNames(doc *gltf.Document) []string {
+	names := make([]string, len(doc.Scenes))
+	for i, scene := range doc.Scenes {
+		names[i] = scene.Name
+		if names[i] == "" {
+			names[i] = strconv.Itoa(i)
+		}
+	}
+	return names
+}
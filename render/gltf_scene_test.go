@@ -0,0 +1,64 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/qmuntal/gltf"
+)
+
+func multiSceneDocument() *gltf.Document {
+	return &gltf.Document{
+		Scene: gltf.Index(1),
+		Scenes: []*gltf.Scene{
+			{Name: "Day", Nodes: []int{0}},
+			{Name: "Night", Nodes: []int{2}},
+		},
+		Nodes: []*gltf.Node{
+			{Children: []int{1}},
+			{},
+			{Children: []int{3}},
+			{},
+		},
+	}
+}
+
+func TestResolveScene(t *testing.T) {
+	doc := multiSceneDocument()
+	tests := []struct {
+		selector string
+		want     int
+	}{
+		{"", 1},
+		{"Day", 0},
+		{"Night", 1},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		got, err := resolveScene(doc, tt.selector)
+		if err != nil || got != tt.want {
+			t.Errorf("resolveScene(%q) = %d, %v; want %d", tt.selector, got, err, tt.want)
+		}
+	}
+	if _, err := resolveScene(doc, "Dusk"); err == nil {
+		t.Error("Expected an error for an unknown scene")
+	}
+	if got, err := resolveScene(&gltf.Document{}, ""); err != nil || got != -1 {
+		t.Errorf("Expected -1 for a document without scenes, got %d, %v", got, err)
+	}
+}
+
+func TestSceneNodes(t *testing.T) {
+	doc := multiSceneDocument()
+	nodes := sceneNodes(doc, 1)
+	want := []bool{false, false, true, true}
+	for i := range want {
+		if nodes[i] != want[i] {
+			t.Errorf("Node %d: expected in scene %v, got %v", i, want[i], nodes[i])
+		}
+	}
+	for i, in := range sceneNodes(doc, -1) {
+		if !in {
+			t.Errorf("Node %d should be shown without a scene", i)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+//go:build !nogl
+
+package render
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qmuntal/gltf"
+)
+
+// hotReloadInterval is how often -watch looks at the model and shader files
+const hotReloadInterval = 500 * time.Millisecond
+
+// Files in -shader-dir that replace the model's built-in shaders
+const (
+	modelVertexShaderFile   = "model.vert"
+	modelFragmentShaderFile = "model.frag"
+)
+
+// fileStamp is what is compared to notice a file changed
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// loadModelShaders reads the model shaders in dir, using the built-in one
+// for whichever of the two files is missing
+func loadModelShaders(dir string) (vertex, fragment string, err error) {
+	read := func(name, builtin string) (string, bool, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return builtin, false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		return string(data) + "\x00", true, nil
+	}
+	vertex, haveVertex, err := read(modelVertexShaderFile, vertexShaderSource)
+	if err != nil {
+		return "", "", err
+	}
+	fragment, haveFragment, err := read(modelFragmentShaderFile, fragmentShaderSource)
+	if err != nil {
+		return "", "", err
+	}
+	if !haveVertex && !haveFragment {
+		return "", "", fmt.Errorf("no %s or %s in %s", modelVertexShaderFile, modelFragmentShaderFile, dir)
+	}
+	return vertex, fragment, nil
+}
+
+// HotReload watches the model file and the shader directory for -watch.
+// There is no file notification package among the dependencies, so the
+// files are polled. A changed model is parsed in the background, so the
+// render thread only uploads its buffers, as with playlists.
+type HotReload struct {
+	ShaderDir string
+
+	mu        sync.Mutex
+	modelPath string
+	model     fileStamp
+	shaders   [2]fileStamp
+	nextCheck time.Time
+	parsing   bool
+	parsed    chan preloadedModel
+}
+
+// NewHotReload starts watching modelPath and, if set, shaderDir as they
+// are now
+func NewHotReload(modelPath, shaderDir string) *HotReload {
+	h := &HotReload{ShaderDir: shaderDir, parsed: make(chan preloadedModel, 1)}
+	h.SetModel(modelPath)
+	h.shaders = h.shaderStamps()
+	return h
+}
+
+// SetModel watches path instead of the previous model, e.g. after a
+// playlist swap or an upload
+func (h *HotReload) SetModel(path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.modelPath = path
+	h.model = fileStamp{}
+	if !strings.HasPrefix(path, BuiltinModelPrefix) {
+		h.model = statFile(path)
+	}
+}
+
+// Parsing reports whether a changed model is being parsed
+func (h *HotReload) Parsing() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.parsing
+}
+
+func (h *HotReload) shaderStamps() [2]fileStamp {
+	if h.ShaderDir == "" {
+		return [2]fileStamp{}
+	}
+	return [2]fileStamp{
+		statFile(filepath.Join(h.ShaderDir, modelVertexShaderFile)),
+		statFile(filepath.Join(h.ShaderDir, modelFragmentShaderFile)),
+	}
+}
+
+// Poll returns the model once a change to it has been parsed, and reports
+// whether the shaders changed. It never blocks, so it can be called every
+// frame.
+func (h *HotReload) Poll(now time.Time) (path string, doc *gltf.Document, shadersChanged bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	select {
+	case model := <-h.parsed:
+		h.parsing = false
+		switch {
+		case model.err != nil:
+			log.Printf("Hot reload: failed to load %s: %v", model.path, model.err)
+		case model.path == h.modelPath:
+			path, doc = model.path, model.doc
+		}
+	default:
+	}
+
+	if now.Before(h.nextCheck) {
+		return path, doc, false
+	}
+	h.nextCheck = now.Add(hotReloadInterval)
+
+	if !h.parsing && !strings.HasPrefix(h.modelPath, BuiltinModelPrefix) {
+		if stamp := statFile(h.modelPath); stamp.exists && stamp != h.model {
+			h.model = stamp
+			h.parsing = true
+			modelPath := h.modelPath
+			go func() {
+				doc, err := OpenModel(modelPath)
+				h.parsed <- preloadedModel{path: modelPath, doc: doc, err: err}
+			}()
+		}
+	}
+
+	if stamps := h.shaderStamps(); stamps != h.shaders {
+		h.shaders = stamps
+		shadersChanged = true
+	}
+	return path, doc, shadersChanged
+}
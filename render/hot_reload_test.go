@@ -0,0 +1,76 @@
+//go:build !nogl
+
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadModelShaders(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := loadModelShaders(dir); err == nil {
+		t.Error("expected an error for a directory without shaders")
+	}
+
+	os.WriteFile(filepath.Join(dir, modelFragmentShaderFile), []byte("void main() {}"), 0o644)
+	vertex, fragment, err := loadModelShaders(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vertex != vertexShaderSource {
+		t.Error("missing model.vert should fall back to the built-in vertex shader")
+	}
+	if fragment != "void main() {}\x00" {
+		t.Errorf("fragment shader %q", fragment)
+	}
+}
+
+// pollUntil polls h until it reports a model or shader change, advancing
+// the clock past hotReloadInterval each time
+func pollUntil(t *testing.T, h *HotReload, now *time.Time) (string, bool, bool) {
+	t.Helper()
+	for range 100 {
+		*now = now.Add(hotReloadInterval)
+		path, doc, shaders := h.Poll(*now)
+		if doc != nil || shaders {
+			return path, doc != nil, shaders
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return "", false, false
+}
+
+func TestHotReload(t *testing.T) {
+	dir := t.TempDir()
+	model := filepath.Join(dir, "tri.obj")
+	os.WriteFile(model, []byte(triangleOBJ), 0o644)
+	h := NewHotReload(model, dir)
+	now := time.Now()
+
+	if path, doc, shaders := h.Poll(now); doc != nil || shaders {
+		t.Fatalf("change reported before any edit: %s", path)
+	}
+
+	os.WriteFile(model, []byte(triangleOBJ+"v 0 0 1\nf 1 2 4\n"), 0o644)
+	if path, changed, _ := pollUntil(t, h, &now); !changed || path != model {
+		t.Fatalf("model edit not picked up, got %q", path)
+	}
+
+	os.WriteFile(filepath.Join(dir, modelVertexShaderFile), []byte("void main() {}"), 0o644)
+	if _, _, shaders := pollUntil(t, h, &now); !shaders {
+		t.Error("shader edit not picked up")
+	}
+
+	// A model that stops parsing is logged and the old one kept
+	os.WriteFile(model, []byte("f 1 2 3\n"), 0o644)
+	for range 20 {
+		now = now.Add(hotReloadInterval)
+		if _, doc, _ := h.Poll(now); doc != nil {
+			t.Fatal("broken model was returned")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
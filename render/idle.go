@@ -0,0 +1,81 @@
+//go:build !nogl
+
+package render
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// IdleMonitor tracks user input and reports when nobody has touched the
+// session for Timeout
+type IdleMonitor struct {
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	lastInput time.Time
+	idle      bool
+}
+
+// NewIdleMonitor creates a monitor that counts from now. A timeout of zero
+// or less never goes idle.
+func NewIdleMonitor(timeout time.Duration, now time.Time) *IdleMonitor {
+	return &IdleMonitor{Timeout: timeout, lastInput: now}
+}
+
+// Activity records user input, which also wakes an idle session
+func (m *IdleMonitor) Activity(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastInput = now
+}
+
+// Update returns whether the session is idle and whether that changed since
+// the previous call
+func (m *IdleMonitor) Update(now time.Time) (idle, changed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	idle = m.Timeout > 0 && now.Sub(m.lastInput) >= m.Timeout
+	changed = idle != m.idle
+	m.idle = idle
+	return idle, changed
+}
+
+// Screensaver dims the model and optionally swaps its animation while the
+// session is idle, restoring both on wake
+type Screensaver struct {
+	Brightness float32
+	Animation  string
+
+	savedAnim string
+	savedLoop bool
+}
+
+// Start switches the renderer into screensaver mode
+func (s *Screensaver) Start(r *GLBRenderer) {
+	r.Brightness = s.Brightness
+	if s.Animation == "" {
+		return
+	}
+	s.savedAnim, s.savedLoop = "", r.AnimLoop
+	if r.CurrentAnim != nil {
+		s.savedAnim = r.CurrentAnim.Name
+	}
+	if err := r.PlayAnimation(s.Animation, true); err != nil {
+		log.Printf("Screensaver: %v", err)
+	}
+}
+
+// Stop restores the renderer to how it was before Start
+func (s *Screensaver) Stop(r *GLBRenderer) {
+	r.Brightness = 1
+	if s.Animation == "" {
+		return
+	}
+	// Looked up by name, the model may have been swapped in between
+	if s.savedAnim == "" || r.PlayAnimation(s.savedAnim, s.savedLoop) != nil {
+		r.StopAnimation()
+	}
+	s.savedAnim = ""
+}
@@ -0,0 +1,36 @@
+//go:build !nogl
+
+package render
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleMonitor(t *testing.T) {
+	start := time.Now()
+	m := NewIdleMonitor(time.Minute, start)
+
+	if idle, changed := m.Update(start.Add(30 * time.Second)); idle || changed {
+		t.Errorf("Should not be idle yet, got idle=%v changed=%v", idle, changed)
+	}
+	if idle, changed := m.Update(start.Add(time.Minute)); !idle || !changed {
+		t.Errorf("Should become idle after the timeout, got idle=%v changed=%v", idle, changed)
+	}
+	if idle, changed := m.Update(start.Add(2 * time.Minute)); !idle || changed {
+		t.Errorf("Should stay idle without reporting a change, got idle=%v changed=%v", idle, changed)
+	}
+
+	m.Activity(start.Add(2 * time.Minute))
+	if idle, changed := m.Update(start.Add(2 * time.Minute)); idle || !changed {
+		t.Errorf("Input should wake the session, got idle=%v changed=%v", idle, changed)
+	}
+}
+
+func TestIdleMonitorDisabled(t *testing.T) {
+	start := time.Now()
+	m := NewIdleMonitor(0, start)
+	if idle, _ := m.Update(start.Add(24 * time.Hour)); idle {
+		t.Error("A zero timeout should never go idle")
+	}
+}
@@ -0,0 +1,135 @@
+package render
+
+import (
+	"math"
+
+	"github.com/qmuntal/gltf"
+)
+
+// msftLOD is the MSFT_lod node extension: the nodes replacing this one at
+// lower levels of detail, highest detail first
+type msftLOD struct {
+	IDs []int `json:"ids"`
+}
+
+// lodChain returns a node followed by its MSFT_lod replacements. Nodes
+// without the extension are a chain of one.
+func lodChain(doc *gltf.Document, node int) []int {
+	chain := []int{node}
+	var ext msftLOD
+	if !decodeExtension(doc.Nodes[node].Extensions, "MSFT_lod", &ext) {
+		return chain
+	}
+	for _, id := range ext.IDs {
+		if id >= 0 && id < len(doc.Nodes) {
+			chain = append(chain, id)
+		}
+	}
+	return chain
+}
+
+// nodeTriangles counts the triangles drawn for a node's mesh
+func nodeTriangles(doc *gltf.Document, node int) int {
+	if doc.Nodes[node].Mesh == nil {
+		return 0
+	}
+	triangles := 0
+	for _, prim := range doc.Meshes[*doc.Nodes[node].Mesh].Primitives {
+		if prim.Indices != nil {
+			triangles += int(doc.Accessors[*prim.Indices].Count) / 3
+		} else if pos, ok := prim.Attributes[gltf.POSITION]; ok {
+			triangles += int(doc.Accessors[pos].Count) / 3
+		}
+	}
+	return triangles
+}
+
+// selectLOD picks the level of detail for a set of LOD chains: the most
+// detailed level whose triangle count fits budget, where chains shorter
+// than the level use their last entry. It returns the level and its
+// triangle count; when even the coarsest level is over budget, that level
+// is returned. A budget of zero or less always picks level 0.
+func selectLOD(chains [][]int, triangles func(node int) int, budget int) (level, total int) {
+	levels := 1
+	for _, chain := range chains {
+		levels = max(levels, len(chain))
+	}
+	for level = 0; level < levels; level++ {
+		total = 0
+		for _, chain := range chains {
+			total += triangles(chain[min(level, len(chain)-1)])
+		}
+		if budget <= 0 || total <= budget {
+			return level, total
+		}
+	}
+	return levels - 1, total
+}
+
+// decimate reduces a triangle list to at most target triangles by vertex
+// clustering: vertices falling in the same grid cell are merged into the
+// first of them and triangles that collapse are dropped. Only the indices
+// change, so every vertex attribute (UVs, skin weights) stays valid. The
+// grid is refined by bisection to keep as much detail as fits.
+func decimate(positions [][3]float32, indices []uint32, target int) []uint32 {
+	if len(indices)/3 <= target || len(positions) == 0 {
+		return indices
+	}
+	if target <= 0 {
+		return nil
+	}
+
+	lo, hi := positions[0], positions[0]
+	for _, p := range positions {
+		for i := range 3 {
+			lo[i] = min(lo[i], p[i])
+			hi[i] = max(hi[i], p[i])
+		}
+	}
+	extent := max(hi[0]-lo[0], hi[1]-lo[1], hi[2]-lo[2])
+	if extent == 0 {
+		return nil
+	}
+
+	cluster := func(cells int) []uint32 {
+		size := extent / float32(cells)
+		representative := make(map[[3]int32]uint32)
+		remap := make([]uint32, len(positions))
+		for v, p := range positions {
+			var cell [3]int32
+			for i := range 3 {
+				cell[i] = int32(math.Floor(float64((p[i] - lo[i]) / size)))
+			}
+			rep, ok := representative[cell]
+			if !ok {
+				rep = uint32(v)
+				representative[cell] = rep
+			}
+			remap[v] = rep
+		}
+		out := make([]uint32, 0, len(indices))
+		for t := 0; t+2 < len(indices); t += 3 {
+			a, b, c := remap[indices[t]], remap[indices[t+1]], remap[indices[t+2]]
+			if a != b && b != c && a != c {
+				out = append(out, a, b, c)
+			}
+		}
+		return out
+	}
+
+	// The coarsest grid is one cell per axis; find the finest that fits
+	best := cluster(1)
+	lowCells, highCells := 1, 1024
+	for lowCells+1 < highCells {
+		mid := (lowCells + highCells) / 2
+		if out := cluster(mid); len(out)/3 <= target {
+			best, lowCells = out, mid
+		} else {
+			highCells = mid
+		}
+	}
+	if len(best)/3 > target {
+		return best[:target*3]
+	}
+	return best
+}
@@ -0,0 +1,75 @@
+package render
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/qmuntal/gltf"
+)
+
+func TestLODChain(t *testing.T) {
+	doc := &gltf.Document{Nodes: []*gltf.Node{
+		{Extensions: gltf.Extensions{"MSFT_lod": json.RawMessage(`{"ids": [1, 2, 9]}`)}},
+		{},
+		{},
+	}}
+	if got, want := lodChain(doc, 0), []int{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := lodChain(doc, 1); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("Expected a node without MSFT_lod alone, got %v", got)
+	}
+}
+
+func TestSelectLOD(t *testing.T) {
+	triangles := map[int]int{0: 1000, 1: 400, 2: 100, 3: 300}
+	count := func(node int) int { return triangles[node] }
+	chains := [][]int{{0, 1, 2}, {3}}
+
+	for _, tc := range []struct {
+		budget, level, total int
+	}{
+		{0, 0, 1300},
+		{5000, 0, 1300},
+		{800, 1, 700},
+		{500, 2, 400},
+		{100, 2, 400},
+	} {
+		level, total := selectLOD(chains, count, tc.budget)
+		if level != tc.level || total != tc.total {
+			t.Errorf("Budget %d: expected level %d with %d triangles, got %d with %d", tc.budget, tc.level, tc.total, level, total)
+		}
+	}
+}
+
+func TestDecimate(t *testing.T) {
+	// A 20x20 grid of quads
+	const n = 20
+	var positions [][3]float32
+	for y := 0; y <= n; y++ {
+		for x := 0; x <= n; x++ {
+			positions = append(positions, [3]float32{float32(x), float32(y), 0})
+		}
+	}
+	var indices []uint32
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			i := uint32(y*(n+1) + x)
+			indices = append(indices, i, i+1, i+n+1, i+1, i+n+2, i+n+1)
+		}
+	}
+
+	if out := decimate(positions, indices, 1000); len(out) != len(indices) {
+		t.Errorf("Expected no change under budget, got %d indices", len(out))
+	}
+	out := decimate(positions, indices, 200)
+	if len(out)/3 > 200 || len(out)/3 < 50 {
+		t.Errorf("Expected between 50 and 200 triangles, got %d", len(out)/3)
+	}
+	for i := 0; i < len(out); i += 3 {
+		if out[i] == out[i+1] || out[i+1] == out[i+2] || out[i] == out[i+2] {
+			t.Fatal("Decimation left a degenerate triangle")
+		}
+	}
+}
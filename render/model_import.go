@@ -0,0 +1,325 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+)
+
+// importedModelSize is the largest side of an imported OBJ or STL model,
+// about that of the bundled pup. Neither format has units.
+const importedModelSize = 0.5
+
+// modelExtensions are the model file types -model and playlists accept
+var modelExtensions = []string{".glb", ".gltf", ".obj", ".stl"}
+
+// IsModelFile reports whether path has a model file extension
+func IsModelFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range modelExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenModel parses a glTF, OBJ or STL file, or generates a builtin model.
+// OBJ and STL models are turned into a one-mesh glTF document, centred and
+// scaled to importedModelSize, with UVs projected from the front where the
+// file has none.
+func OpenModel(path string) (*gltf.Document, error) {
+	if spec, ok := strings.CutPrefix(path, BuiltinModelPrefix); ok {
+		return builtinModel(spec)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".obj":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseOBJ(f)
+	case ".stl":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return parseSTL(data)
+	case ".fbx":
+		return nil, fmt.Errorf("FBX models are not supported, convert %s to glTF or OBJ", path)
+	default:
+		return gltf.Open(path)
+	}
+}
+
+// importedMesh is a triangle mesh read from an OBJ or STL file, or a
+// generated one
+type importedMesh struct {
+	positions [][3]float32
+	normals   [][3]float32 // nil when the file has none
+	uvs       [][2]float32 // nil when the file has none
+	indices   []uint32
+}
+
+// parseOBJ reads the vertices and faces of a Wavefront OBJ file, fanning
+// polygons into triangles. Groups, materials and smoothing are ignored.
+func parseOBJ(r io.Reader) (*gltf.Document, error) {
+	var positions, normals [][3]float32
+	var uvs [][2]float32
+	var mesh importedMesh
+	hasUVs, hasNormals := true, true
+	vertices := make(map[[3]int]uint32)
+
+	// vertex resolves one "v/vt/vn" corner of a face to a mesh vertex
+	vertex := func(corner string) (uint32, error) {
+		parts := strings.Split(corner, "/")
+		var ids [3]int
+		counts := []int{len(positions), len(uvs), len(normals)}
+		for i := range min(len(parts), 3) {
+			if parts[i] == "" {
+				continue
+			}
+			n, err := strconv.Atoi(parts[i])
+			if err != nil {
+				return 0, fmt.Errorf("invalid face corner %q", corner)
+			}
+			if n < 0 {
+				n += counts[i] + 1
+			}
+			if n < 1 || n > counts[i] {
+				return 0, fmt.Errorf("face corner %q is out of range", corner)
+			}
+			ids[i] = n
+		}
+		if ids[1] == 0 {
+			hasUVs = false
+		}
+		if ids[2] == 0 {
+			hasNormals = false
+		}
+		if index, ok := vertices[ids]; ok {
+			return index, nil
+		}
+		index := uint32(len(mesh.positions))
+		vertices[ids] = index
+		mesh.positions = append(mesh.positions, positions[ids[0]-1])
+		var uv [2]float32
+		if ids[1] != 0 {
+			// OBJ's V runs up, glTF's down
+			uv = [2]float32{uvs[ids[1]-1][0], 1 - uvs[ids[1]-1][1]}
+		}
+		mesh.uvs = append(mesh.uvs, uv)
+		var normal [3]float32
+		if ids[2] != 0 {
+			normal = normals[ids[2]-1]
+		}
+		mesh.normals = append(mesh.normals, normal)
+		return index, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		values, err := parseFloats(fields[1:])
+		switch fields[0] {
+		case "v", "vn":
+			if err != nil || len(values) < 3 {
+				return nil, fmt.Errorf("obj line %d: want three numbers", line)
+			}
+			v := [3]float32{values[0], values[1], values[2]}
+			if fields[0] == "v" {
+				positions = append(positions, v)
+			} else {
+				normals = append(normals, v)
+			}
+		case "vt":
+			if err != nil || len(values) < 2 {
+				return nil, fmt.Errorf("obj line %d: want two numbers", line)
+			}
+			uvs = append(uvs, [2]float32{values[0], values[1]})
+		case "f":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("obj line %d: a face needs three corners", line)
+			}
+			corners := make([]uint32, len(fields)-1)
+			for i, corner := range fields[1:] {
+				if corners[i], err = vertex(corner); err != nil {
+					return nil, fmt.Errorf("obj line %d: %w", line, err)
+				}
+			}
+			for i := 1; i+1 < len(corners); i++ {
+				mesh.indices = append(mesh.indices, corners[0], corners[i], corners[i+1])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read obj: %w", err)
+	}
+	if len(mesh.indices) == 0 {
+		return nil, fmt.Errorf("obj has no faces")
+	}
+	if !hasUVs {
+		mesh.uvs = nil
+	}
+	if !hasNormals {
+		mesh.normals = nil
+	}
+	return mesh.document(), nil
+}
+
+func parseFloats(fields []string) ([]float32, error) {
+	values := make([]float32, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 32)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = float32(v)
+	}
+	return values, nil
+}
+
+// parseSTL reads a binary or ASCII STL file. STL is Z up, so the model is
+// turned to glTF's Y up.
+func parseSTL(data []byte) (*gltf.Document, error) {
+	var triangles [][3][3]float32
+	if len(data) >= 84 && len(data) == 84+50*int(binary.LittleEndian.Uint32(data[80:84])) {
+		count := int(binary.LittleEndian.Uint32(data[80:84]))
+		for i := range count {
+			facet := data[84+50*i:]
+			var t [3][3]float32
+			for v := range 3 {
+				for c := range 3 {
+					t[v][c] = math.Float32frombits(binary.LittleEndian.Uint32(facet[12+12*v+4*c:]))
+				}
+			}
+			triangles = append(triangles, t)
+		}
+	} else {
+		if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("solid")) {
+			return nil, fmt.Errorf("stl is neither binary nor ASCII")
+		}
+		var t [3][3]float32
+		corner := 0
+		for line, text := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(text)
+			if len(fields) == 0 || fields[0] != "vertex" {
+				continue
+			}
+			values, err := parseFloats(fields[1:])
+			if err != nil || len(values) != 3 {
+				return nil, fmt.Errorf("stl line %d: want three numbers", line+1)
+			}
+			t[corner] = [3]float32(values)
+			if corner++; corner == 3 {
+				triangles = append(triangles, t)
+				corner = 0
+			}
+		}
+	}
+	if len(triangles) == 0 {
+		return nil, fmt.Errorf("stl has no triangles")
+	}
+
+	// Facet normals in STL files are often zero or wrong, so the normals
+	// are worked out from the corners
+	var mesh importedMesh
+	for _, t := range triangles {
+		for _, v := range t {
+			mesh.indices = append(mesh.indices, uint32(len(mesh.positions)))
+			mesh.positions = append(mesh.positions, [3]float32{v[0], v[2], -v[1]})
+		}
+	}
+	return mesh.document(), nil
+}
+
+// document fits the mesh to importedModelSize, fills in normals and UVs,
+// and wraps it in a glTF document
+func (m *importedMesh) document() *gltf.Document {
+	lo, hi := m.positions[0], m.positions[0]
+	for _, p := range m.positions {
+		for i := range 3 {
+			lo[i], hi[i] = min(lo[i], p[i]), max(hi[i], p[i])
+		}
+	}
+	size := max(hi[0]-lo[0], hi[1]-lo[1], hi[2]-lo[2])
+	scale := float32(1)
+	if size > 0 {
+		scale = importedModelSize / size
+	}
+	for i, p := range m.positions {
+		for c := range 3 {
+			m.positions[i][c] = (p[c] - (lo[c]+hi[c])/2) * scale
+		}
+	}
+
+	if m.normals == nil {
+		m.normals = smoothNormals(m.positions, m.indices)
+	}
+	if m.uvs == nil {
+		// Project from the front, where the camera is, so the desktop
+		// shows the right way up on the model's face
+		w, h := (hi[0]-lo[0])*scale, (hi[1]-lo[1])*scale
+		m.uvs = make([][2]float32, len(m.positions))
+		for i, p := range m.positions {
+			if w > 0 && h > 0 {
+				m.uvs[i] = [2]float32{p[0]/w + 0.5, 0.5 - p[1]/h}
+			}
+		}
+	}
+
+	doc := gltf.NewDocument()
+	doc.Meshes = []*gltf.Mesh{{
+		Name: "Imported",
+		Primitives: []*gltf.Primitive{{
+			Indices: gltf.Index(modeler.WriteIndices(doc, m.indices)),
+			Attributes: gltf.PrimitiveAttributes{
+				gltf.POSITION:   modeler.WritePosition(doc, m.positions),
+				gltf.NORMAL:     modeler.WriteNormal(doc, m.normals),
+				gltf.TEXCOORD_0: modeler.WriteTextureCoord(doc, m.uvs),
+			},
+		}},
+	}}
+	doc.Nodes = []*gltf.Node{{Name: "Imported", Mesh: gltf.Index(0)}}
+	doc.Scenes[0].Nodes = []int{0}
+	return doc
+}
+
+// smoothNormals averages the normals of the triangles around each vertex,
+// weighted by their area
+func smoothNormals(positions [][3]float32, indices []uint32) [][3]float32 {
+	sums := make([]mgl32.Vec3, len(positions))
+	for i := 0; i+2 < len(indices); i += 3 {
+		a, b, c := mgl32.Vec3(positions[indices[i]]), mgl32.Vec3(positions[indices[i+1]]), mgl32.Vec3(positions[indices[i+2]])
+		n := b.Sub(a).Cross(c.Sub(a))
+		for _, v := range indices[i : i+3] {
+			sums[v] = sums[v].Add(n)
+		}
+	}
+	normals := make([][3]float32, len(positions))
+	for i, n := range sums {
+		if n.Len() > 0 {
+			n = n.Normalize()
+		} else {
+			n = mgl32.Vec3{0, 1, 0}
+		}
+		normals[i] = n
+	}
+	return normals
+}
@@ -0,0 +1,139 @@
+package render
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+)
+
+// triangleOBJ is the smallest OBJ model
+const triangleOBJ = "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"
+
+// importedAttributes reads back what the importer wrote
+func importedAttributes(t *testing.T, doc *gltf.Document) (positions, normals [][3]float32, uvs [][2]float32, indices []uint32) {
+	t.Helper()
+	prim := doc.Meshes[0].Primitives[0]
+	var err error
+	if positions, err = modeler.ReadPosition(doc, doc.Accessors[prim.Attributes[gltf.POSITION]], nil); err != nil {
+		t.Fatal(err)
+	}
+	if normals, err = modeler.ReadNormal(doc, doc.Accessors[prim.Attributes[gltf.NORMAL]], nil); err != nil {
+		t.Fatal(err)
+	}
+	if uvs, err = modeler.ReadTextureCoord(doc, doc.Accessors[prim.Attributes[gltf.TEXCOORD_0]], nil); err != nil {
+		t.Fatal(err)
+	}
+	if indices, err = modeler.ReadIndices(doc, doc.Accessors[*prim.Indices], nil); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestParseOBJ(t *testing.T) {
+	// A quad with UVs and normals, written with negative indices
+	obj := `# quad
+v 0 0 0
+v 2 0 0
+v 2 2 0
+v 0 2 0
+vt 0 0
+vt 1 0
+vt 1 1
+vt 0 1
+vn 0 0 1
+f -4/-4/-1 -3/-3/-1 -2/-2/-1 -1/-1/-1
+`
+	doc, err := parseOBJ(strings.NewReader(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	positions, normals, uvs, indices := importedAttributes(t, doc)
+	if len(positions) != 4 || len(indices) != 6 {
+		t.Fatalf("%d vertices and %d indices, want 4 and 6", len(positions), len(indices))
+	}
+	if positions[0] != [3]float32{-0.25, -0.25, 0} || positions[2] != [3]float32{0.25, 0.25, 0} {
+		t.Errorf("quad not centred and scaled: %v", positions)
+	}
+	if normals[0] != [3]float32{0, 0, 1} {
+		t.Errorf("normal %v, want the file's", normals[0])
+	}
+	if uvs[0] != [2]float32{0, 1} || uvs[2] != [2]float32{1, 0} {
+		t.Errorf("uvs %v, want V flipped", uvs)
+	}
+
+	if _, err := parseOBJ(strings.NewReader("v 0 0 0\nf 1 2 3\n")); err == nil {
+		t.Error("out of range face accepted")
+	}
+	if _, err := parseOBJ(strings.NewReader("v 0 0 0\n")); err == nil {
+		t.Error("obj without faces accepted")
+	}
+}
+
+func TestParseOBJGeneratesUVs(t *testing.T) {
+	doc, err := parseOBJ(strings.NewReader("v 0 0 0\nv 4 0 0\nv 4 2 0\nf 1 2 3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, normals, uvs, _ := importedAttributes(t, doc)
+	want := [][2]float32{{0, 1}, {1, 1}, {1, 0}}
+	for i := range want {
+		if uvs[i] != want[i] {
+			t.Errorf("uv %d = %v, want %v", i, uvs[i], want[i])
+		}
+	}
+	if normals[0] != [3]float32{0, 0, 1} {
+		t.Errorf("computed normal %v, want facing the camera", normals[0])
+	}
+}
+
+func TestParseSTL(t *testing.T) {
+	ascii := `solid tri
+facet normal 0 0 0
+ outer loop
+  vertex 0 0 0
+  vertex 1 0 0
+  vertex 0 0 1
+ endloop
+endfacet
+endsolid tri
+`
+	binarySTL := make([]byte, 84+50)
+	binary.LittleEndian.PutUint32(binarySTL[80:], 1)
+	for i, v := range []float32{0, 0, 0, 1, 0, 0, 0, 0, 1} {
+		binary.LittleEndian.PutUint32(binarySTL[84+12+4*i:], math.Float32bits(v))
+	}
+
+	for name, data := range map[string][]byte{"ascii": []byte(ascii), "binary": binarySTL} {
+		doc, err := parseSTL(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		positions, normals, _, indices := importedAttributes(t, doc)
+		if len(positions) != 3 || len(indices) != 3 {
+			t.Fatalf("%s: %d vertices and %d indices, want 3 and 3", name, len(positions), len(indices))
+		}
+		// Z up in the file is Y up in the document
+		if positions[2][1] != 0.25 {
+			t.Errorf("%s: top vertex %v, want Y 0.25", name, positions[2])
+		}
+		if normals[0] != [3]float32{0, 0, 1} {
+			t.Errorf("%s: normal %v, want facing the camera", name, normals[0])
+		}
+	}
+
+	if _, err := parseSTL([]byte("not a model")); err == nil {
+		t.Error("garbage accepted as stl")
+	}
+}
+
+func TestIsModelFile(t *testing.T) {
+	for path, want := range map[string]bool{"a.glb": true, "b.OBJ": true, "c.stl": true, "d.fbx": false, "e.txt": false} {
+		if got := IsModelFile(path); got != want {
+			t.Errorf("IsModelFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+//go:build !nogl
+
+package render
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+)
+
+// meshMorph holds what a primitive needs to re-blend its morph targets.
+// Morphing is done on the CPU and re-uploaded, since weights only change
+// when something asks them to.
+type meshMorph struct {
+	// base is the unmorphed interleaved vertex data
+	base    []float32
+	targets []morphTarget
+	// names are the target names from the mesh's extras.targetNames,
+	// falling back to the target index
+	names   []string
+	weights []float32
+}
+
+// morphTarget is one primitive morph target's deltas; either may be nil
+type morphTarget struct {
+	Positions [][3]float32
+	Normals   [][3]float32
+}
+
+// loadMorphTargets reads a primitive's morph targets. It returns nil when
+// the primitive has none.
+func loadMorphTargets(doc *gltf.Document, prim *gltf.Primitive, base []float32) *meshMorph {
+	if len(prim.Targets) == 0 {
+		return nil
+	}
+	m := &meshMorph{base: base, weights: make([]float32, len(prim.Targets))}
+	for _, attrs := range prim.Targets {
+		var target morphTarget
+		if idx, ok := attrs[gltf.POSITION]; ok {
+			target.Positions, _ = modeler.ReadPosition(doc, doc.Accessors[idx], nil)
+		}
+		if idx, ok := attrs[gltf.NORMAL]; ok {
+			target.Normals, _ = modeler.ReadNormal(doc, doc.Accessors[idx], nil)
+		}
+		m.targets = append(m.targets, target)
+	}
+	return m
+}
+
+// morphTargetNames returns the names of a mesh's morph targets, from the
+// extras.targetNames convention exporters use. Unnamed targets are named by
+// index.
+func morphTargetNames(mesh *gltf.Mesh, count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+	}
+	if mesh.Extras == nil {
+		return names
+	}
+	var data []byte
+	switch v := mesh.Extras.(type) {
+	case json.RawMessage:
+		data = v
+	default:
+		data, _ = json.Marshal(v)
+	}
+	var extras struct {
+		TargetNames []string `json:"targetNames"`
+	}
+	if err := json.Unmarshal(data, &extras); err != nil {
+		return names
+	}
+	for i, name := range extras.TargetNames {
+		if i < count && name != "" {
+			names[i] = name
+		}
+	}
+	return names
+}
+
+// initMorph names a freshly loaded mesh's targets and blends in the glTF
+// mesh's default weights, overridden by any set by name
+func (r *GLBRenderer) initMorph(m *Mesh, mesh *gltf.Mesh) {
+	morph := m.morph
+	morph.names = morphTargetNames(mesh, len(morph.targets))
+	blend := false
+	for t := range morph.weights {
+		if t < len(mesh.Weights) {
+			morph.weights[t] = float32(mesh.Weights[t])
+		}
+		if w, ok := r.morphWeights[morph.names[t]]; ok {
+			morph.weights[t] = w
+		}
+		blend = blend || morph.weights[t] != 0
+	}
+	if blend {
+		r.uploadMorph(m)
+	}
+}
+
+// blendMorphTargets returns base with the weighted target deltas added to
+// the position (floats 0-2) and normal (floats 3-5) of each 16 float vertex
+func blendMorphTargets(base []float32, targets []morphTarget, weights []float32) []float32 {
+	out := make([]float32, len(base))
+	copy(out, base)
+	vertices := len(base) / 16
+	for t, target := range targets {
+		w := weights[t]
+		if w == 0 {
+			continue
+		}
+		for v := 0; v < vertices && v < len(target.Positions); v++ {
+			for i := range 3 {
+				out[v*16+i] += w * target.Positions[v][i]
+			}
+		}
+		for v := 0; v < vertices && v < len(target.Normals); v++ {
+			for i := range 3 {
+				out[v*16+3+i] += w * target.Normals[v][i]
+			}
+		}
+	}
+	return out
+}
+
+// SetMorphWeights sets morph target weights by target name on every mesh
+// that has the target, leaving the others as they are. It returns how many
+// targets matched.
+func (r *GLBRenderer) SetMorphWeights(weights map[string]float32) int {
+	matched := 0
+	for i := range r.Meshes {
+		morph := r.Meshes[i].morph
+		if morph == nil {
+			continue
+		}
+		changed := false
+		for t, name := range morph.names {
+			if w, ok := weights[name]; ok {
+				matched++
+				if morph.weights[t] != w {
+					morph.weights[t] = w
+					changed = true
+				}
+			}
+		}
+		if changed {
+			r.uploadMorph(&r.Meshes[i])
+		}
+	}
+	if r.morphWeights == nil {
+		r.morphWeights = make(map[string]float32)
+	}
+	for name, w := range weights {
+		r.morphWeights[name] = w
+	}
+	return matched
+}
+
+// uploadMorph re-blends a mesh's vertices at its current weights
+func (r *GLBRenderer) uploadMorph(mesh *Mesh) {
+	data := blendMorphTargets(mesh.morph.base, mesh.morph.targets, mesh.morph.weights)
+	r.GL.BindBuffer(gl.ARRAY_BUFFER, mesh.VBO)
+	r.GL.BufferSubData(gl.ARRAY_BUFFER, 0, len(data)*4, gl.Ptr(data))
+	r.GL.BindBuffer(gl.ARRAY_BUFFER, 0)
+	// Skinned vertices cached at the old shape are stale
+	r.pose++
+}
@@ -0,0 +1,46 @@
+//go:build !nogl
+
+package render
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/qmuntal/gltf"
+)
+
+func TestBlendMorphTargets(t *testing.T) {
+	// Two vertices of 16 floats; only position and normal are morphed
+	base := make([]float32, 32)
+	base[0], base[16] = 1, 2
+	base[8], base[24] = 0.5, 0.5 // texture coordinates stay put
+	targets := []morphTarget{
+		{Positions: [][3]float32{{1, 0, 0}, {0, 1, 0}}},
+		{Normals: [][3]float32{{0, 0, 1}}},
+	}
+
+	out := blendMorphTargets(base, targets, []float32{0.5, 1})
+	if out[0] != 1.5 || out[17] != 0.5 || out[5] != 1 || out[21] != 0 {
+		t.Errorf("Unexpected blend %v", out)
+	}
+	if out[8] != 0.5 || out[24] != 0.5 {
+		t.Error("Texture coordinates should not change")
+	}
+	if base[0] != 1 {
+		t.Error("The base vertices should not change")
+	}
+}
+
+func TestMorphTargetNames(t *testing.T) {
+	var mesh gltf.Mesh
+	if err := json.Unmarshal([]byte(`{"primitives": [], "extras": {"targetNames": ["Smile", ""]}}`), &mesh); err != nil {
+		t.Fatal(err)
+	}
+	if got := morphTargetNames(&mesh, 3); !reflect.DeepEqual(got, []string{"Smile", "1", "2"}) {
+		t.Errorf("Expected Smile, 1, 2, got %v", got)
+	}
+	if got := morphTargetNames(&gltf.Mesh{}, 2); !reflect.DeepEqual(got, []string{"0", "1"}) {
+		t.Errorf("Expected index names, got %v", got)
+	}
+}
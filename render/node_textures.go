@@ -0,0 +1,110 @@
+//go:build !nogl
+
+package render
+
+import (
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// nodeTexture is a TextureSource drawn on a node's meshes in place of the
+// screen
+type nodeTexture struct {
+	source TextureSource
+	// id is 0 until the source's first frame is uploaded; the meshes show
+	// the screen until then
+	id            uint32
+	width, height int32
+	damage        uint64
+}
+
+// SetNodeTextures draws each source on the meshes of the node it is keyed
+// by and of that node's children, in place of the screen. Nodes left out,
+// or given a nil source, show the screen. A node keeps its texture while
+// its source stays the same.
+func (r *GLBRenderer) SetNodeTextures(sources map[string]TextureSource) {
+	for node, t := range r.nodeTextures {
+		if source := sources[node]; source == nil || source != t.source {
+			if t.id != 0 {
+				r.GL.DeleteTextures(1, &t.id)
+			}
+			delete(r.nodeTextures, node)
+		}
+	}
+	for node, source := range sources {
+		if source == nil || r.nodeTextures[node] != nil {
+			continue
+		}
+		if r.nodeTextures == nil {
+			r.nodeTextures = make(map[string]*nodeTexture)
+		}
+		r.nodeTextures[node] = &nodeTexture{source: source}
+	}
+}
+
+// uploadNodeTextures uploads each node texture's source when it has a
+// frame not uploaded yet
+func (r *GLBRenderer) uploadNodeTextures() {
+	for _, t := range r.nodeTextures {
+		damage := t.source.Damage()
+		if t.id != 0 && damage == t.damage {
+			continue
+		}
+		pixels, stride := t.source.Frame()
+		size := t.source.Size()
+		if len(pixels) == 0 || size.X <= 0 || size.Y <= 0 {
+			continue
+		}
+		if t.id == 0 {
+			r.GL.GenTextures(1, &t.id)
+			r.GL.BindTexture(gl.TEXTURE_2D, t.id)
+			r.GL.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+			r.GL.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+			r.GL.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+			r.GL.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+		} else {
+			r.GL.BindTexture(gl.TEXTURE_2D, t.id)
+		}
+		width, height := int32(size.X), int32(size.Y)
+		if t.width != width || t.height != height {
+			r.GL.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+			t.width, t.height = width, height
+		}
+		// Client buffers may be wider than their pixels
+		r.GL.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(stride/4))
+		r.GL.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, width, height, gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&pixels[0]))
+		r.GL.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+		t.damage = damage
+	}
+}
+
+// meshTexture returns the node texture a mesh is drawn with, found on its
+// nodes or their nearest parent with one, or 0 for the screen
+func (r *GLBRenderer) meshTexture(mesh *Mesh) uint32 {
+	if len(r.nodeTextures) == 0 || r.Document == nil {
+		return 0
+	}
+	for _, node := range mesh.Nodes {
+		for n := node; n >= 0 && n < len(r.Document.Nodes); {
+			if t, ok := r.nodeTextures[r.Document.Nodes[n].Name]; ok && t.id != 0 {
+				return t.id
+			}
+			if n >= len(r.NodeParents) {
+				break
+			}
+			n = r.NodeParents[n]
+		}
+	}
+	return 0
+}
+
+// deleteNodeTextures frees the node textures and forgets their sources
+func (r *GLBRenderer) deleteNodeTextures() {
+	for node, t := range r.nodeTextures {
+		if t.id != 0 {
+			r.GL.DeleteTextures(1, &t.id)
+		}
+		delete(r.nodeTextures, node)
+	}
+}
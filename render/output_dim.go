@@ -0,0 +1,83 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+const outputDimFragmentShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform float level;
+
+void main() {
+    FragColor = vec4(0.0, 0.0, 0.0, 1.0 - level);
+}
+` + "\x00"
+
+// OutputDimmer darkens everything drawn in the preview window, the way a
+// panel's backlight would
+type OutputDimmer struct {
+	program  uint32
+	quadVAO  uint32
+	quadVBO  uint32
+	levelLoc int32
+}
+
+// NewOutputDimmer creates the shader and quad the window is dimmed with
+func NewOutputDimmer(programs *ProgramCache) (*OutputDimmer, error) {
+	d := &OutputDimmer{}
+	program, err := newShaderProgram(programs, transitionVertexShaderSource, outputDimFragmentShaderSource)
+	if err != nil {
+		return nil, fmt.Errorf("output dim shader: %w", err)
+	}
+	d.program = program
+	d.levelLoc = gl.GetUniformLocation(program, gl.Str("level\x00"))
+
+	quad := []float32{0, 0, 1, 0, 0, 1, 1, 1}
+	gl.GenVertexArrays(1, &d.quadVAO)
+	gl.BindVertexArray(d.quadVAO)
+	gl.GenBuffers(1, &d.quadVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, d.quadVBO)
+	gl.BufferData(gl.ARRAY_BUFFER, len(quad)*4, gl.Ptr(quad), gl.STATIC_DRAW)
+	gl.VertexAttribPointerWithOffset(0, 2, gl.FLOAT, false, 2*4, 0)
+	gl.EnableVertexAttribArray(0)
+	gl.BindVertexArray(0)
+	return d, nil
+}
+
+// Render scales the window's colors by level, 1 leaves them as they are
+func (d *OutputDimmer) Render(level float64, winW, winH int32) {
+	if level >= 1 {
+		return
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.Viewport(0, 0, winW, winH)
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.CULL_FACE)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+
+	gl.UseProgram(d.program)
+	gl.Uniform1f(d.levelLoc, float32(max(level, 0)))
+	gl.BindVertexArray(d.quadVAO)
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+
+	gl.BindVertexArray(0)
+	gl.Disable(gl.BLEND)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+}
+
+// Destroy releases the GL resources of the dimmer
+func (d *OutputDimmer) Destroy() {
+	gl.DeleteBuffers(1, &d.quadVBO)
+	gl.DeleteVertexArrays(1, &d.quadVAO)
+	gl.DeleteProgram(d.program)
+}
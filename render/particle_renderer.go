@@ -0,0 +1,233 @@
+//go:build !nogl
+
+package render
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Particle kinds as the shader numbers them
+var particleKindIDs = map[ParticleKind]int32{
+	ParticleSparks:   0,
+	ParticleConfetti: 1,
+	ParticleSnow:     2,
+}
+
+// The particles are never simulated on the CPU: each has four fixed random
+// numbers, and the vertex shader works out where it is from them and the
+// burst's age
+const particleVertexShaderSource = `
+#version 410 core
+layout (location = 0) in vec4 aRandom;
+
+uniform mat4 projection;
+uniform mat4 view;
+uniform vec3 origin;
+uniform float age;
+uniform float lifetime;
+uniform float speed;
+uniform float size;
+uniform int kind; // 0 sparks, 1 confetti, 2 snow
+uniform vec3 color;
+uniform float viewportHeight;
+
+out vec4 vColor;
+flat out int vKind;
+
+vec3 hue(float h) {
+    return clamp(abs(mod(h * 6.0 + vec3(0.0, 4.0, 2.0), 6.0) - 3.0) - 1.0, 0.0, 1.0);
+}
+
+void main() {
+    vKind = kind;
+    // Snowflakes are born over the first lifetime, the others all at once
+    float birth = kind == 2 ? aRandom.w * lifetime : 0.0;
+    float life = lifetime * (0.5 + 0.5 * aRandom.z);
+    float t = age - birth;
+    if (t < 0.0 || t > life) {
+        gl_Position = vec4(2.0, 2.0, 2.0, 1.0);
+        gl_PointSize = 0.0;
+        vColor = vec4(0.0);
+        return;
+    }
+
+    float theta = aRandom.x * 6.2831853;
+    vec3 pos;
+    if (kind == 2) {
+        // Drift down from a disc above the emitter, swaying
+        float radius = sqrt(aRandom.y) * 0.5;
+        pos = origin + vec3(cos(theta) * radius, 0.4 - speed * t, sin(theta) * radius);
+        pos.x += 0.03 * sin(t * 2.0 + theta * 3.0);
+    } else {
+        // Burst up in a cone and fall back, confetti slowly and fluttering
+        float phi = aRandom.y * 0.8;
+        vec3 dir = vec3(sin(phi) * cos(theta), cos(phi), sin(phi) * sin(theta));
+        float gravity = kind == 0 ? 1.5 : 0.4;
+        pos = origin + dir * speed * (0.5 + 0.5 * aRandom.w) * t;
+        pos.y -= 0.5 * gravity * t * t;
+        if (kind == 1) {
+            pos.x += 0.02 * sin(t * 6.0 + theta);
+        }
+    }
+
+    float fade = 1.0 - t / life;
+    if (kind == 0) {
+        vColor = vec4(mix(color, vec3(1.0), fade * 0.5), fade);
+    } else if (kind == 1) {
+        vColor = vec4(hue(aRandom.w), min(1.0, fade * 3.0));
+    } else {
+        vColor = vec4(color, min(1.0, fade * 3.0));
+    }
+
+    vec4 viewPos = view * vec4(pos, 1.0);
+    gl_Position = projection * viewPos;
+    gl_PointSize = size * viewportHeight / 600.0 / max(0.1, -viewPos.z);
+}
+` + "\x00"
+
+const particleFragmentShaderSource = `
+#version 410 core
+in vec4 vColor;
+flat in int vKind;
+out vec4 FragColor;
+
+void main() {
+    // Confetti are square, sparks and snow soft dots
+    float alpha = vColor.a;
+    if (vKind != 1) {
+        vec2 p = gl_PointCoord * 2.0 - 1.0;
+        float d = dot(p, p);
+        if (d > 1.0) {
+            discard;
+        }
+        alpha *= 1.0 - d;
+    }
+    FragColor = vec4(vColor.rgb, alpha);
+}
+` + "\x00"
+
+// ParticleRenderer draws particle bursts over the model
+type ParticleRenderer struct {
+	program uint32
+	vao     uint32
+	vbo     uint32
+
+	projectionLoc     int32
+	viewLoc           int32
+	originLoc         int32
+	ageLoc            int32
+	lifetimeLoc       int32
+	speedLoc          int32
+	sizeLoc           int32
+	kindLoc           int32
+	colorLoc          int32
+	viewportHeightLoc int32
+}
+
+// NewParticleRenderer compiles the particle shaders and fills the shared
+// buffer of per-particle random numbers
+func NewParticleRenderer(programs *ProgramCache) (*ParticleRenderer, error) {
+	program, err := newShaderProgram(programs, particleVertexShaderSource, particleFragmentShaderSource)
+	if err != nil {
+		return nil, err
+	}
+	p := &ParticleRenderer{program: program}
+	uniform := func(name string) int32 {
+		return gl.GetUniformLocation(program, gl.Str(name+"\x00"))
+	}
+	p.projectionLoc = uniform("projection")
+	p.viewLoc = uniform("view")
+	p.originLoc = uniform("origin")
+	p.ageLoc = uniform("age")
+	p.lifetimeLoc = uniform("lifetime")
+	p.speedLoc = uniform("speed")
+	p.sizeLoc = uniform("size")
+	p.kindLoc = uniform("kind")
+	p.colorLoc = uniform("color")
+	p.viewportHeightLoc = uniform("viewportHeight")
+
+	// Every burst draws a prefix of the same numbers, so two bursts of
+	// one emitter look alike; the seed only needs to be fixed
+	random := rand.New(rand.NewSource(1))
+	data := make([]float32, maxParticles*4)
+	for i := range data {
+		data[i] = random.Float32()
+	}
+	gl.GenVertexArrays(1, &p.vao)
+	gl.GenBuffers(1, &p.vbo)
+	gl.BindVertexArray(p.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, p.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(data)*4, gl.Ptr(data), gl.STATIC_DRAW)
+	gl.VertexAttribPointerWithOffset(0, 4, gl.FLOAT, false, 4*4, 0)
+	gl.EnableVertexAttribArray(0)
+	gl.BindVertexArray(0)
+	return p, nil
+}
+
+// Render draws the bursts over what is already drawn, from the nodes of
+// the model r shows
+func (p *ParticleRenderer) Render(r *GLBRenderer, bursts []ParticleBurst, windowWidth, windowHeight int32, now time.Time) {
+	if len(bursts) == 0 {
+		return
+	}
+	projection, view := camera(windowWidth, windowHeight)
+
+	gl.UseProgram(p.program)
+	gl.UniformMatrix4fv(p.projectionLoc, 1, false, &projection[0])
+	gl.UniformMatrix4fv(p.viewLoc, 1, false, &view[0])
+	gl.Uniform1f(p.viewportHeightLoc, float32(windowHeight))
+	gl.Enable(gl.PROGRAM_POINT_SIZE)
+	gl.Enable(gl.BLEND)
+	gl.DepthMask(false)
+	gl.BindVertexArray(p.vao)
+
+	for _, b := range bursts {
+		e := b.Emitter
+		origin := r.nodeOrigin(e.Node, mgl32.Vec3(e.Offset))
+		if e.Kind == ParticleSparks {
+			gl.BlendFunc(gl.SRC_ALPHA, gl.ONE)
+		} else {
+			gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+		}
+		gl.Uniform3f(p.originLoc, origin[0], origin[1], origin[2])
+		gl.Uniform1f(p.ageLoc, float32(now.Sub(b.Start).Seconds()))
+		gl.Uniform1f(p.lifetimeLoc, float32(e.lifetime.Seconds()))
+		gl.Uniform1f(p.speedLoc, e.Speed)
+		gl.Uniform1f(p.sizeLoc, e.Size)
+		gl.Uniform1i(p.kindLoc, particleKindIDs[e.Kind])
+		gl.Uniform3f(p.colorLoc, e.Color[0], e.Color[1], e.Color[2])
+		gl.DrawArrays(gl.POINTS, 0, int32(e.Count))
+	}
+
+	gl.BindVertexArray(0)
+	gl.DepthMask(true)
+	gl.Disable(gl.BLEND)
+	gl.Disable(gl.PROGRAM_POINT_SIZE)
+}
+
+// Destroy frees the GL resources
+func (p *ParticleRenderer) Destroy() {
+	gl.DeleteVertexArrays(1, &p.vao)
+	gl.DeleteBuffers(1, &p.vbo)
+	gl.DeleteProgram(p.program)
+}
+
+// nodeOrigin returns where a node of the model is in world space, plus an
+// offset in the node's space. Unknown nodes fall back to the model's
+// origin.
+func (r *GLBRenderer) nodeOrigin(name string, offset mgl32.Vec3) mgl32.Vec3 {
+	node := mgl32.Ident4()
+	if name != "" && r.Document != nil {
+		for i, n := range r.Document.Nodes {
+			if n.Name == name {
+				node = r.getGlobalNodeTransform(i)
+				break
+			}
+		}
+	}
+	return r.baseModel().Mul4(node).Mul4x1(offset.Vec4(1)).Vec3()
+}
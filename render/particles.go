@@ -0,0 +1,225 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ParticleKind picks how an emitter's particles move and look
+type ParticleKind string
+
+const (
+	// ParticleSparks shoot up and fall fast, fading out
+	ParticleSparks ParticleKind = "sparks"
+	// ParticleConfetti burst up in every color and flutter down
+	ParticleConfetti ParticleKind = "confetti"
+	// ParticleSnow drifts down from above the emitter for a while
+	ParticleSnow ParticleKind = "snow"
+)
+
+const (
+	// maxParticles caps one emitter's particle count
+	maxParticles = 4096
+	// maxBursts caps the bursts shown at once; the oldest go first
+	maxBursts = 32
+)
+
+// particleEvents are the compositor events an emitter can fire on, named as
+// on the /api/v1/events stream
+var particleEvents = []string{"client_connected", "toplevel_mapped", "toplevel_unmapped", "client_disconnected", "viewer_joined", "preview_recovered"}
+
+// ParticleEmitter is one configured emitter. Zero fields take the kind's
+// defaults.
+type ParticleEmitter struct {
+	Kind ParticleKind `json:"kind"`
+	// Node is the glTF node the particles come from; the model's origin
+	// when it is empty or the model has no such node
+	Node   string     `json:"node,omitempty"`
+	Offset [3]float32 `json:"offset,omitempty"`
+	Count  int        `json:"count,omitempty"`
+	// Lifetime is how long a particle lives, at most
+	Lifetime string  `json:"lifetime,omitempty"`
+	Speed    float32 `json:"speed,omitempty"`
+	// Size is the point size in pixels at the model's distance, for a
+	// 600 pixel tall window
+	Size float32 `json:"size,omitempty"`
+	// Color is ignored by confetti, which is every color
+	Color *[3]float32 `json:"color,omitempty"`
+	// On lists the compositor events that fire the emitter
+	On []string `json:"on,omitempty"`
+
+	lifetime time.Duration
+}
+
+// particleDefaults fill in an emitter's unset fields by kind
+var particleDefaults = map[ParticleKind]ParticleEmitter{
+	ParticleSparks:   {Count: 200, lifetime: time.Second, Speed: 0.6, Size: 6, Color: &[3]float32{1, 0.7, 0.2}},
+	ParticleConfetti: {Count: 300, lifetime: 3 * time.Second, Speed: 0.5, Size: 8, Color: &[3]float32{1, 1, 1}},
+	ParticleSnow:     {Count: 400, lifetime: 6 * time.Second, Speed: 0.08, Size: 4, Color: &[3]float32{1, 1, 1}},
+}
+
+// Duration is how long a burst shows. Snow keeps falling for a lifetime,
+// so its last flakes are gone after two.
+func (e ParticleEmitter) Duration() time.Duration {
+	if e.Kind == ParticleSnow {
+		return 2 * e.lifetime
+	}
+	return e.lifetime
+}
+
+// Particles is the -particles flag: emitters by name, given as a JSON
+// object
+type Particles map[string]ParticleEmitter
+
+// String returns the emitters as JSON
+func (p *Particles) String() string {
+	if p == nil || len(*p) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(*p)
+	return string(data)
+}
+
+// Set parses and validates the emitters, replacing the current ones
+func (p *Particles) Set(value string) error {
+	emitters, err := parseParticles(value)
+	if err != nil {
+		return err
+	}
+	*p = emitters
+	return nil
+}
+
+// Names returns the emitter names, sorted
+func (p Particles) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseParticles parses a JSON object of emitters, filling in defaults
+func parseParticles(value string) (Particles, error) {
+	emitters := Particles{}
+	if value == "" {
+		return emitters, nil
+	}
+	if err := json.Unmarshal([]byte(value), &emitters); err != nil {
+		return nil, fmt.Errorf("parse particles: %w", err)
+	}
+	for name, e := range emitters {
+		defaults, ok := particleDefaults[e.Kind]
+		if !ok {
+			return nil, fmt.Errorf("particle emitter %q: unknown kind %q, want sparks, confetti or snow", name, e.Kind)
+		}
+		if e.Count == 0 {
+			e.Count = defaults.Count
+		}
+		if e.Count < 0 || e.Count > maxParticles {
+			return nil, fmt.Errorf("particle emitter %q: count must be between 1 and %d", name, maxParticles)
+		}
+		e.lifetime = defaults.lifetime
+		if e.Lifetime != "" {
+			d, err := time.ParseDuration(e.Lifetime)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("particle emitter %q: invalid lifetime %q", name, e.Lifetime)
+			}
+			e.lifetime = d
+		}
+		if e.Speed == 0 {
+			e.Speed = defaults.Speed
+		}
+		if e.Size == 0 {
+			e.Size = defaults.Size
+		}
+		if e.Color == nil {
+			e.Color = defaults.Color
+		}
+		for _, event := range e.On {
+			if !slices.Contains(particleEvents, event) {
+				return nil, fmt.Errorf("particle emitter %q: unknown event %q, want one of %v", name, event, particleEvents)
+			}
+		}
+		emitters[name] = e
+	}
+	return emitters, nil
+}
+
+// ParticleBurst is one firing of an emitter
+type ParticleBurst struct {
+	Emitter ParticleEmitter
+	Start   time.Time
+}
+
+// ParticleSystem keeps track of the bursts in flight. Emitters are fired
+// from any goroutine; the render loop draws Active.
+type ParticleSystem struct {
+	mu       sync.Mutex
+	emitters Particles
+	bursts   []ParticleBurst
+}
+
+// NewParticleSystem creates a system with the given emitters
+func NewParticleSystem(emitters Particles) *ParticleSystem {
+	return &ParticleSystem{emitters: emitters}
+}
+
+// SetEmitters replaces the emitters; bursts in flight play out
+func (s *ParticleSystem) SetEmitters(emitters Particles) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emitters = emitters
+}
+
+// Names returns the emitter names, sorted
+func (s *ParticleSystem) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.emitters.Names()
+}
+
+// Fire starts a burst of the named emitter
+func (s *ParticleSystem) Fire(name string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.emitters[name]
+	if !ok {
+		return fmt.Errorf("no particle emitter %q", name)
+	}
+	s.start(e, now)
+	return nil
+}
+
+// FireEvent starts a burst of every emitter listening for event
+func (s *ParticleSystem) FireEvent(event string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.emitters {
+		if slices.Contains(e.On, event) {
+			s.start(e, now)
+		}
+	}
+}
+
+func (s *ParticleSystem) start(e ParticleEmitter, now time.Time) {
+	if len(s.bursts) >= maxBursts {
+		s.bursts = slices.Delete(s.bursts, 0, len(s.bursts)-maxBursts+1)
+	}
+	s.bursts = append(s.bursts, ParticleBurst{Emitter: e, Start: now})
+}
+
+// Active returns the bursts still showing, forgetting finished ones
+func (s *ParticleSystem) Active(now time.Time) []ParticleBurst {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bursts = slices.DeleteFunc(s.bursts, func(b ParticleBurst) bool {
+		return now.Sub(b.Start) > b.Emitter.Duration()
+	})
+	return slices.Clone(s.bursts)
+}
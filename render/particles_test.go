@@ -0,0 +1,66 @@
+package render
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseParticles(t *testing.T) {
+	p, err := parseParticles(`{"cheer": {"kind": "confetti", "node": "Head", "on": ["toplevel_mapped"]}, "fizz": {"kind": "sparks", "count": 50, "lifetime": "500ms"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheer := p["cheer"]
+	if cheer.Count != 300 || cheer.lifetime != 3*time.Second || cheer.Speed == 0 || cheer.Color == nil {
+		t.Errorf("cheer did not get the confetti defaults: %+v", cheer)
+	}
+	if fizz := p["fizz"]; fizz.Count != 50 || fizz.lifetime != 500*time.Millisecond {
+		t.Errorf("fizz = %+v, want 50 particles living 500ms", fizz)
+	}
+
+	for _, bad := range []string{
+		`{"x": {"kind": "smoke"}}`,
+		`{"x": {"kind": "snow", "count": 100000}}`,
+		`{"x": {"kind": "snow", "lifetime": "-1s"}}`,
+		`{"x": {"kind": "snow", "on": ["notification"]}}`,
+	} {
+		if _, err := parseParticles(bad); err == nil {
+			t.Errorf("parseParticles(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestParticleSystemBursts(t *testing.T) {
+	p, err := parseParticles(`{"fizz": {"kind": "sparks", "lifetime": "1s", "on": ["viewer_joined"]}, "flakes": {"kind": "snow", "lifetime": "1s"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewParticleSystem(p)
+	now := time.Unix(0, 0)
+
+	if err := s.Fire("missing", now); err == nil {
+		t.Error("Fire of an unknown emitter succeeded")
+	}
+	s.FireEvent("viewer_joined", now)
+	s.FireEvent("toplevel_mapped", now)
+	if err := s.Fire("flakes", now); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.Active(now)); got != 2 {
+		t.Fatalf("%d bursts active, want 2", got)
+	}
+	// Sparks are done after their lifetime, snow falls for two
+	if got := s.Active(now.Add(1500 * time.Millisecond)); len(got) != 1 || got[0].Emitter.Kind != ParticleSnow {
+		t.Errorf("after 1.5s active = %+v, want only the snow", got)
+	}
+	if got := len(s.Active(now.Add(3 * time.Second))); got != 0 {
+		t.Errorf("%d bursts active after 3s, want 0", got)
+	}
+
+	for range maxBursts + 5 {
+		s.Fire("fizz", now)
+	}
+	if got := len(s.Active(now)); got != maxBursts {
+		t.Errorf("%d bursts active, want the cap of %d", got, maxBursts)
+	}
+}
@@ -0,0 +1,51 @@
+package render
+
+import (
+	"image"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// surfaceKey identifies a client's surface
+type surfaceKey struct {
+	client *wayland.Client
+	id     protocols.ObjectID[protocols.WlSurface]
+}
+
+// PlacedSurface is a drawable client surface with its absolute position on
+// the desktop, in the order it should be composited (bottom first).
+type PlacedSurface struct {
+	Client    *wayland.Client
+	SurfaceID protocols.ObjectID[protocols.WlSurface]
+	Surface   *wayland.WlSurface
+	Texture   *wayland.Texture
+	// Frame tells apart the contents of a Texture that is drawn into
+	// again; caches of a buffer hold while Texture and Frame are the same
+	Frame uint64
+	X, Y  int
+	// Root is the toplevel (or other root) surface this one is stacked on
+	Root protocols.ObjectID[protocols.WlSurface]
+
+	// Source is the part of the buffer that is shown, in buffer pixels
+	Source image.Rectangle
+	// Size is the size of the surface on the desktop
+	Size image.Point
+}
+
+// Width returns the width of the surface on the desktop
+func (p PlacedSurface) Width() int {
+	return p.Size.X
+}
+
+// Height returns the height of the surface on the desktop
+func (p PlacedSurface) Height() int {
+	return p.Size.Y
+}
+
+// Scaled reports whether the buffer is cropped or scaled on its way to the
+// desktop
+func (p PlacedSurface) Scaled() bool {
+	full := image.Rect(0, 0, int(p.Texture.Width), int(p.Texture.Height))
+	return p.Source != full || p.Size != full.Size()
+}
@@ -0,0 +1,185 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qmuntal/gltf"
+)
+
+// preloadedModel is a playlist entry parsed off the render thread
+type preloadedModel struct {
+	path string
+	doc  *gltf.Document
+	err  error
+}
+
+// Playlist cycles the displayed model through a list of model files. The next
+// model is parsed in the background so the swap on the render thread only
+// uploads buffers. A directory source is rescanned every time the playlist
+// wraps around, picking up added and removed files.
+type Playlist struct {
+	Source   string
+	Interval time.Duration
+
+	mu        sync.Mutex
+	paths     []string
+	index     int
+	current   string
+	nextAt    time.Time
+	skip      bool
+	preloaded chan preloadedModel
+}
+
+// NewPlaylist loads the playlist from source, a directory of model files or
+// a text file listing one path per line, and starts preloading the second
+// entry
+func NewPlaylist(source string, interval time.Duration, now time.Time) (*Playlist, error) {
+	p := &Playlist{
+		Source:    source,
+		Interval:  interval,
+		nextAt:    now.Add(interval),
+		preloaded: make(chan preloadedModel, 1),
+	}
+	paths, err := loadPlaylist(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no model files in playlist %s", source)
+	}
+	p.paths = paths
+	p.current = paths[0]
+	p.preloadNext()
+	return p, nil
+}
+
+// Current returns the path of the model that should be shown
+func (p *Playlist) Current() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current
+}
+
+// Next asks for the next model as soon as it is preloaded
+func (p *Playlist) Next() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.skip = true
+}
+
+// Poll returns the next model once it is due and preloaded. It never
+// blocks, so it can be called every frame.
+func (p *Playlist) Poll(now time.Time) (string, *gltf.Document, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.paths) < 2 {
+		return "", nil, false
+	}
+	due := p.skip || (p.Interval > 0 && !now.Before(p.nextAt))
+	if !due {
+		return "", nil, false
+	}
+
+	var model preloadedModel
+	select {
+	case model = <-p.preloaded:
+	default:
+		return "", nil, false
+	}
+
+	p.advance()
+	p.preloadNext()
+	if model.err != nil {
+		// Try the entry after it on the next frame
+		log.Printf("Playlist: skipping %s: %v", model.path, model.err)
+		return "", nil, false
+	}
+	p.skip = false
+	p.nextAt = now.Add(p.Interval)
+	p.current = model.path
+	return model.path, model.doc, true
+}
+
+// advance moves to the next entry, rescanning the source on wrap around.
+// Must be called with p.mu held.
+func (p *Playlist) advance() {
+	p.index++
+	if p.index < len(p.paths) {
+		return
+	}
+	p.index = 0
+	if paths, err := loadPlaylist(p.Source); err != nil {
+		log.Printf("Playlist: keeping previous entries: %v", err)
+	} else if len(paths) > 0 {
+		p.paths = paths
+	}
+}
+
+// preloadNext parses the entry after the current one in the background.
+// Must be called with p.mu held.
+func (p *Playlist) preloadNext() {
+	if len(p.paths) < 2 {
+		return
+	}
+	path := p.paths[(p.index+1)%len(p.paths)]
+	go func() {
+		doc, err := OpenModel(path)
+		p.preloaded <- preloadedModel{path: path, doc: doc, err: err}
+	}()
+}
+
+// loadPlaylist lists the models of a playlist source. Directories yield
+// their model files sorted by name; files list one path per line, relative to
+// the file, with blank lines and # comments ignored.
+func loadPlaylist(source string) ([]string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, fmt.Errorf("playlist: %w", err)
+	}
+
+	if info.IsDir() {
+		entries, err := os.ReadDir(source)
+		if err != nil {
+			return nil, fmt.Errorf("playlist: %w", err)
+		}
+		var paths []string
+		for _, entry := range entries {
+			if !entry.IsDir() && IsModelFile(entry.Name()) {
+				paths = append(paths, filepath.Join(source, entry.Name()))
+			}
+		}
+		sort.Strings(paths)
+		return paths, nil
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("playlist: %w", err)
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) && !strings.HasPrefix(line, BuiltinModelPrefix) {
+			line = filepath.Join(filepath.Dir(source), line)
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("playlist: %w", err)
+	}
+	return paths, nil
+}
@@ -0,0 +1,79 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qmuntal/gltf"
+)
+
+func TestLoadPlaylist(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.glb", "a.GLB", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := loadPlaylist(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(paths) != 2 || filepath.Base(paths[0]) != "a.GLB" || filepath.Base(paths[1]) != "b.glb" {
+		t.Errorf("Expected a.GLB and b.glb, got %v", paths)
+	}
+
+	list := filepath.Join(dir, "playlist.txt")
+	os.WriteFile(list, []byte("# lobby\nb.glb\n\n/models/dog.glb\n"), 0o644)
+	paths, err = loadPlaylist(list)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(paths) != 2 || paths[0] != filepath.Join(dir, "b.glb") || paths[1] != "/models/dog.glb" {
+		t.Errorf("Unexpected entries from list file: %v", paths)
+	}
+}
+
+func TestPlaylistPoll(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.glb", "b.glb"} {
+		if err := gltf.SaveBinary(&gltf.Document{}, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not a valid model, skipped when its turn comes
+	os.WriteFile(filepath.Join(dir, "c.glb"), []byte("broken"), 0o644)
+
+	start := time.Now()
+	p, err := NewPlaylist(dir, time.Minute, start)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	poll := func(now time.Time) string {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if path, _, ok := p.Poll(now); ok {
+				return filepath.Base(path)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return ""
+	}
+
+	if _, _, ok := p.Poll(start); ok {
+		t.Fatal("Should not switch before the interval")
+	}
+	if got := poll(start.Add(time.Minute)); got != "b.glb" {
+		t.Errorf("Expected b.glb after the interval, got %q", got)
+	}
+	p.Next()
+	if got := poll(start.Add(time.Minute)); got != "a.glb" {
+		t.Errorf("Next should skip the broken model and wrap to a.glb, got %q", got)
+	}
+	if filepath.Base(p.Current()) != "a.glb" {
+		t.Errorf("Current should follow the shown model, got %s", p.Current())
+	}
+}
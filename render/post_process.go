@@ -0,0 +1,435 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// Bloom settings: how bright a pixel has to be to bleed light, how much of
+// the blurred light is added back, and how many times it is blurred
+const (
+	bloomThreshold  = 0.8
+	bloomStrength   = 0.6
+	bloomBlurPasses = 2
+)
+
+// PostProcess is which passes the 3D preview goes through after the model
+// and particles are drawn into an HDR framebuffer, each from its own flag
+type PostProcess struct {
+	// MSAA is the samples per pixel the scene is drawn with, 0 is off
+	MSAA     int
+	FXAA     bool
+	Bloom    bool
+	Tonemap  bool
+	Vignette bool
+}
+
+// Enabled reports whether any pass is on. Without one the model is drawn
+// straight to the window as before.
+func (p PostProcess) Enabled() bool {
+	return p.MSAA > 0 || p.FXAA || p.Bloom || p.Tonemap || p.Vignette
+}
+
+// Validate checks the MSAA sample count
+func (p PostProcess) Validate() error {
+	switch p.MSAA {
+	case 0, 2, 4, 8, 16:
+		return nil
+	}
+	return fmt.Errorf("MSAA samples must be 0, 2, 4, 8 or 16, not %d", p.MSAA)
+}
+
+// compositeSource is the fragment shader that adds the bloom, tone maps
+// and darkens the corners, with only the passes that are on compiled in
+func (p PostProcess) compositeSource() string {
+	var defines strings.Builder
+	for _, pass := range []struct {
+		name string
+		on   bool
+	}{
+		{"BLOOM", p.Bloom},
+		{"TONEMAP", p.Tonemap},
+		{"VIGNETTE", p.Vignette},
+	} {
+		if pass.on {
+			fmt.Fprintf(&defines, "#define %s\n", pass.name)
+		}
+	}
+	return "#version 410 core\n" + defines.String() + postCompositeShaderBody
+}
+
+const postCompositeShaderBody = `
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform sampler2D scene;
+uniform sampler2D bloom;
+uniform float bloomStrength;
+
+// Narkowicz's fit of the ACES filmic curve
+vec3 aces(vec3 x) {
+    return clamp((x * (2.51 * x + 0.03)) / (x * (2.43 * x + 0.59) + 0.14), 0.0, 1.0);
+}
+
+void main() {
+    vec3 color = texture(scene, TexCoord).rgb;
+#ifdef BLOOM
+    color += texture(bloom, TexCoord).rgb * bloomStrength;
+#endif
+#ifdef TONEMAP
+    color = aces(color);
+#endif
+#ifdef VIGNETTE
+    color *= 1.0 - 0.6 * smoothstep(0.4, 0.8, length(TexCoord - 0.5));
+#endif
+    FragColor = vec4(color, 1.0);
+}
+` + "\x00"
+
+const postBrightShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform sampler2D scene;
+uniform float threshold;
+
+void main() {
+    vec3 color = texture(scene, TexCoord).rgb;
+    float brightest = max(color.r, max(color.g, color.b));
+    FragColor = vec4(color * max(brightest - threshold, 0.0) / max(brightest, 0.0001), 1.0);
+}
+` + "\x00"
+
+const postBlurShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform sampler2D image;
+// One texel along the direction of the blur
+uniform vec2 direction;
+
+const float weights[5] = float[](0.227027, 0.1945946, 0.1216216, 0.054054, 0.016216);
+
+void main() {
+    vec3 color = texture(image, TexCoord).rgb * weights[0];
+    for (int i = 1; i < 5; i++) {
+        color += texture(image, TexCoord + direction * float(i)).rgb * weights[i];
+        color += texture(image, TexCoord - direction * float(i)).rgb * weights[i];
+    }
+    FragColor = vec4(color, 1.0);
+}
+` + "\x00"
+
+// postFXAAShaderSource is the classic FXAA pass on tone mapped colors
+const postFXAAShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform sampler2D image;
+uniform vec2 texel;
+
+const float reduceMin = 1.0 / 128.0;
+const float reduceMul = 1.0 / 8.0;
+const float spanMax = 8.0;
+
+void main() {
+    const vec3 toLuma = vec3(0.299, 0.587, 0.114);
+    vec3 rgbM = texture(image, TexCoord).rgb;
+    float lumaNW = dot(texture(image, TexCoord + vec2(-1.0, -1.0) * texel).rgb, toLuma);
+    float lumaNE = dot(texture(image, TexCoord + vec2(1.0, -1.0) * texel).rgb, toLuma);
+    float lumaSW = dot(texture(image, TexCoord + vec2(-1.0, 1.0) * texel).rgb, toLuma);
+    float lumaSE = dot(texture(image, TexCoord + vec2(1.0, 1.0) * texel).rgb, toLuma);
+    float lumaM = dot(rgbM, toLuma);
+    float lumaMin = min(lumaM, min(min(lumaNW, lumaNE), min(lumaSW, lumaSE)));
+    float lumaMax = max(lumaM, max(max(lumaNW, lumaNE), max(lumaSW, lumaSE)));
+
+    vec2 dir = vec2(-((lumaNW + lumaNE) - (lumaSW + lumaSE)), (lumaNW + lumaSW) - (lumaNE + lumaSE));
+    float dirReduce = max((lumaNW + lumaNE + lumaSW + lumaSE) * 0.25 * reduceMul, reduceMin);
+    float rcpDirMin = 1.0 / (min(abs(dir.x), abs(dir.y)) + dirReduce);
+    dir = clamp(dir * rcpDirMin, vec2(-spanMax), vec2(spanMax)) * texel;
+
+    vec3 rgbA = 0.5 * (texture(image, TexCoord + dir * (1.0 / 3.0 - 0.5)).rgb +
+                       texture(image, TexCoord + dir * (2.0 / 3.0 - 0.5)).rgb);
+    vec3 rgbB = rgbA * 0.5 + 0.25 * (texture(image, TexCoord - dir * 0.5).rgb +
+                                     texture(image, TexCoord + dir * 0.5).rgb);
+    float lumaB = dot(rgbB, toLuma);
+    FragColor = vec4((lumaB < lumaMin || lumaB > lumaMax) ? rgbA : rgbB, 1.0);
+}
+` + "\x00"
+
+// PostProcessor draws the 3D preview into an HDR framebuffer, multisampled
+// with MSAA, and runs the enabled passes on the way to the window: bloom,
+// then ACES tone mapping and the vignette in one pass, then FXAA.
+type PostProcessor struct {
+	Passes PostProcess
+
+	width, height int32
+
+	compositeProgram uint32
+	brightProgram    uint32
+	blurProgram      uint32
+	fxaaProgram      uint32
+	quadVAO          uint32
+	quadVBO          uint32
+
+	// msFBO is drawn into with MSAA and resolved into hdrFBO
+	msFBO   uint32
+	msColor uint32
+	// depth belongs to msFBO with MSAA and to hdrFBO without
+	depth      uint32
+	hdrFBO     uint32
+	hdrTexture uint32
+	// bloomTextures are half size, blurred back and forth
+	bloomFBOs     [2]uint32
+	bloomTextures [2]uint32
+	// ldrFBO holds the tone mapped image for FXAA
+	ldrFBO     uint32
+	ldrTexture uint32
+}
+
+// NewPostProcessor compiles the shaders of the enabled passes. The
+// framebuffers are made on the first Begin, at the window's size.
+func NewPostProcessor(passes PostProcess, programs *ProgramCache) (*PostProcessor, error) {
+	p := &PostProcessor{Passes: passes}
+	if passes.MSAA > 0 {
+		var maxSamples int32
+		gl.GetIntegerv(gl.MAX_SAMPLES, &maxSamples)
+		if int32(passes.MSAA) > maxSamples {
+			log.Printf("MSAA: %d samples asked for, the GPU does %d", passes.MSAA, maxSamples)
+			p.Passes.MSAA = int(maxSamples)
+		}
+	}
+
+	var err error
+	if p.compositeProgram, err = newShaderProgram(programs, transitionVertexShaderSource, p.Passes.compositeSource()); err != nil {
+		p.Destroy()
+		return nil, fmt.Errorf("composite shader: %w", err)
+	}
+	if passes.Bloom {
+		if p.brightProgram, err = newShaderProgram(programs, transitionVertexShaderSource, postBrightShaderSource); err != nil {
+			p.Destroy()
+			return nil, fmt.Errorf("bloom shader: %w", err)
+		}
+		if p.blurProgram, err = newShaderProgram(programs, transitionVertexShaderSource, postBlurShaderSource); err != nil {
+			p.Destroy()
+			return nil, fmt.Errorf("blur shader: %w", err)
+		}
+	}
+	if passes.FXAA {
+		if p.fxaaProgram, err = newShaderProgram(programs, transitionVertexShaderSource, postFXAAShaderSource); err != nil {
+			p.Destroy()
+			return nil, fmt.Errorf("FXAA shader: %w", err)
+		}
+	}
+
+	quad := []float32{0, 0, 1, 0, 0, 1, 1, 1}
+	gl.GenVertexArrays(1, &p.quadVAO)
+	gl.BindVertexArray(p.quadVAO)
+	gl.GenBuffers(1, &p.quadVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, p.quadVBO)
+	gl.BufferData(gl.ARRAY_BUFFER, len(quad)*4, gl.Ptr(quad), gl.STATIC_DRAW)
+	gl.VertexAttribPointerWithOffset(0, 2, gl.FLOAT, false, 2*4, 0)
+	gl.EnableVertexAttribArray(0)
+	gl.BindVertexArray(0)
+	return p, nil
+}
+
+// newFramebuffer makes a framebuffer drawing into texture
+func newFramebuffer(texture uint32) (uint32, error) {
+	var fbo uint32
+	gl.GenFramebuffers(1, &fbo)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, fbo)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, texture, 0)
+	status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	if status != gl.FRAMEBUFFER_COMPLETE {
+		gl.DeleteFramebuffers(1, &fbo)
+		return 0, fmt.Errorf("framebuffer incomplete: 0x%x", status)
+	}
+	return fbo, nil
+}
+
+// resize makes the framebuffers for a width x height window
+func (p *PostProcessor) resize(width, height int32) error {
+	p.destroyTargets()
+	p.width, p.height = width, height
+
+	var err error
+	p.hdrTexture = newSurfaceGLTexture()
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA16F, width, height, 0, gl.RGBA, gl.HALF_FLOAT, nil)
+	if p.hdrFBO, err = newFramebuffer(p.hdrTexture); err != nil {
+		return err
+	}
+
+	gl.GenRenderbuffers(1, &p.depth)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, p.depth)
+	if p.Passes.MSAA > 0 {
+		samples := int32(p.Passes.MSAA)
+		gl.RenderbufferStorageMultisample(gl.RENDERBUFFER, samples, gl.DEPTH_COMPONENT24, width, height)
+		gl.GenRenderbuffers(1, &p.msColor)
+		gl.BindRenderbuffer(gl.RENDERBUFFER, p.msColor)
+		gl.RenderbufferStorageMultisample(gl.RENDERBUFFER, samples, gl.RGBA16F, width, height)
+
+		gl.GenFramebuffers(1, &p.msFBO)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, p.msFBO)
+		gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.RENDERBUFFER, p.msColor)
+	} else {
+		gl.RenderbufferStorage(gl.RENDERBUFFER, gl.DEPTH_COMPONENT24, width, height)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, p.hdrFBO)
+	}
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, p.depth)
+	status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	if status != gl.FRAMEBUFFER_COMPLETE {
+		return fmt.Errorf("scene framebuffer incomplete: 0x%x", status)
+	}
+
+	if p.Passes.Bloom {
+		for i := range p.bloomTextures {
+			p.bloomTextures[i] = newSurfaceGLTexture()
+			gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA16F, max(width/2, 1), max(height/2, 1), 0, gl.RGBA, gl.HALF_FLOAT, nil)
+			if p.bloomFBOs[i], err = newFramebuffer(p.bloomTextures[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if p.Passes.FXAA {
+		p.ldrTexture = newSurfaceGLTexture()
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+		if p.ldrFBO, err = newFramebuffer(p.ldrTexture); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Begin binds the framebuffer the scene is drawn into, remaking it when
+// the window's size changed. The caller clears and draws, then calls End.
+func (p *PostProcessor) Begin(width, height int32) error {
+	if width != p.width || height != p.height || p.hdrFBO == 0 {
+		if err := p.resize(width, height); err != nil {
+			p.destroyTargets()
+			return err
+		}
+	}
+	if p.msFBO != 0 {
+		gl.BindFramebuffer(gl.FRAMEBUFFER, p.msFBO)
+	} else {
+		gl.BindFramebuffer(gl.FRAMEBUFFER, p.hdrFBO)
+	}
+	gl.Viewport(0, 0, width, height)
+	return nil
+}
+
+// drawQuad runs the current program over the whole of fbo
+func (p *PostProcessor) drawQuad(fbo uint32, width, height int32) {
+	gl.BindFramebuffer(gl.FRAMEBUFFER, fbo)
+	gl.Viewport(0, 0, width, height)
+	gl.BindVertexArray(p.quadVAO)
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+}
+
+// End runs the passes over what was drawn since Begin and leaves the
+// result in the window's framebuffer
+func (p *PostProcessor) End() {
+	if p.msFBO != 0 {
+		gl.BindFramebuffer(gl.READ_FRAMEBUFFER, p.msFBO)
+		gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, p.hdrFBO)
+		gl.BlitFramebuffer(0, 0, p.width, p.height, 0, 0, p.width, p.height, gl.COLOR_BUFFER_BIT, gl.NEAREST)
+	}
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.CULL_FACE)
+	gl.ActiveTexture(gl.TEXTURE0)
+
+	if p.Passes.Bloom {
+		bloomWidth, bloomHeight := max(p.width/2, 1), max(p.height/2, 1)
+		gl.UseProgram(p.brightProgram)
+		gl.Uniform1i(gl.GetUniformLocation(p.brightProgram, gl.Str("scene\x00")), 0)
+		gl.Uniform1f(gl.GetUniformLocation(p.brightProgram, gl.Str("threshold\x00")), bloomThreshold)
+		gl.BindTexture(gl.TEXTURE_2D, p.hdrTexture)
+		p.drawQuad(p.bloomFBOs[0], bloomWidth, bloomHeight)
+
+		gl.UseProgram(p.blurProgram)
+		gl.Uniform1i(gl.GetUniformLocation(p.blurProgram, gl.Str("image\x00")), 0)
+		directionLoc := gl.GetUniformLocation(p.blurProgram, gl.Str("direction\x00"))
+		for i := 0; i < bloomBlurPasses; i++ {
+			gl.Uniform2f(directionLoc, 1/float32(bloomWidth), 0)
+			gl.BindTexture(gl.TEXTURE_2D, p.bloomTextures[0])
+			p.drawQuad(p.bloomFBOs[1], bloomWidth, bloomHeight)
+			gl.Uniform2f(directionLoc, 0, 1/float32(bloomHeight))
+			gl.BindTexture(gl.TEXTURE_2D, p.bloomTextures[1])
+			p.drawQuad(p.bloomFBOs[0], bloomWidth, bloomHeight)
+		}
+	}
+
+	gl.UseProgram(p.compositeProgram)
+	gl.Uniform1i(gl.GetUniformLocation(p.compositeProgram, gl.Str("scene\x00")), 0)
+	gl.Uniform1i(gl.GetUniformLocation(p.compositeProgram, gl.Str("bloom\x00")), 1)
+	gl.Uniform1f(gl.GetUniformLocation(p.compositeProgram, gl.Str("bloomStrength\x00")), bloomStrength)
+	gl.BindTexture(gl.TEXTURE_2D, p.hdrTexture)
+	gl.ActiveTexture(gl.TEXTURE1)
+	gl.BindTexture(gl.TEXTURE_2D, p.bloomTextures[0])
+	gl.ActiveTexture(gl.TEXTURE0)
+	// Without FXAA ldrFBO is 0 and this goes straight to the window
+	p.drawQuad(p.ldrFBO, p.width, p.height)
+
+	if p.Passes.FXAA {
+		gl.UseProgram(p.fxaaProgram)
+		gl.Uniform1i(gl.GetUniformLocation(p.fxaaProgram, gl.Str("image\x00")), 0)
+		gl.Uniform2f(gl.GetUniformLocation(p.fxaaProgram, gl.Str("texel\x00")), 1/float32(p.width), 1/float32(p.height))
+		gl.BindTexture(gl.TEXTURE_2D, p.ldrTexture)
+		p.drawQuad(0, p.width, p.height)
+	}
+
+	gl.BindVertexArray(0)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+}
+
+func (p *PostProcessor) destroyTargets() {
+	for _, fbo := range []*uint32{&p.msFBO, &p.hdrFBO, &p.bloomFBOs[0], &p.bloomFBOs[1], &p.ldrFBO} {
+		if *fbo != 0 {
+			gl.DeleteFramebuffers(1, fbo)
+			*fbo = 0
+		}
+	}
+	for _, texture := range []*uint32{&p.hdrTexture, &p.bloomTextures[0], &p.bloomTextures[1], &p.ldrTexture} {
+		if *texture != 0 {
+			gl.DeleteTextures(1, texture)
+			*texture = 0
+		}
+	}
+	for _, renderbuffer := range []*uint32{&p.msColor, &p.depth} {
+		if *renderbuffer != 0 {
+			gl.DeleteRenderbuffers(1, renderbuffer)
+			*renderbuffer = 0
+		}
+	}
+	p.width, p.height = 0, 0
+}
+
+// Destroy releases all GL resources owned by the post processor
+func (p *PostProcessor) Destroy() {
+	p.destroyTargets()
+	for _, program := range []uint32{p.compositeProgram, p.brightProgram, p.blurProgram, p.fxaaProgram} {
+		if program != 0 {
+			gl.DeleteProgram(program)
+		}
+	}
+	if p.quadVAO != 0 {
+		gl.DeleteBuffers(1, &p.quadVBO)
+		gl.DeleteVertexArrays(1, &p.quadVAO)
+	}
+}
@@ -0,0 +1,47 @@
+//go:build !nogl
+
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostProcessEnabled(t *testing.T) {
+	if (PostProcess{}).Enabled() {
+		t.Error("no passes reported as enabled")
+	}
+	for _, p := range []PostProcess{{MSAA: 4}, {FXAA: true}, {Bloom: true}, {Tonemap: true}, {Vignette: true}} {
+		if !p.Enabled() {
+			t.Errorf("%+v reported as disabled", p)
+		}
+	}
+}
+
+func TestPostProcessValidate(t *testing.T) {
+	for _, samples := range []int{0, 2, 4, 8, 16} {
+		if err := (PostProcess{MSAA: samples}).Validate(); err != nil {
+			t.Errorf("%d samples: %v", samples, err)
+		}
+	}
+	for _, samples := range []int{-1, 3, 32} {
+		if err := (PostProcess{MSAA: samples}).Validate(); err == nil {
+			t.Errorf("%d samples accepted", samples)
+		}
+	}
+}
+
+func TestPostProcessCompositeSource(t *testing.T) {
+	source := PostProcess{Bloom: true, Vignette: true}.compositeSource()
+	if !strings.HasPrefix(source, "#version 410 core\n") {
+		t.Errorf("source does not start with #version: %q", source[:40])
+	}
+	for define, want := range map[string]bool{"BLOOM": true, "TONEMAP": false, "VIGNETTE": true} {
+		if got := strings.Contains(source, "#define "+define+"\n"); got != want {
+			t.Errorf("#define %s present = %v, want %v", define, got, want)
+		}
+	}
+	if !strings.HasSuffix(source, "\x00") {
+		t.Error("source is not NUL terminated")
+	}
+}
@@ -0,0 +1,596 @@
+//go:build !nogl && !nosdl
+
+package render
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/qmuntal/gltf"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// sdlGLContextResetLoseContext is SDL_GL_CONTEXT_RESET_LOSE_CONTEXT, which
+// go-sdl2 does not name
+const sdlGLContextResetLoseContext = 1
+
+// PreviewOptions describes how to build the preview window
+type PreviewOptions struct {
+	ModelPath          string
+	Scene              string
+	TriangleBudget     int
+	DesktopWidth       int32
+	DesktopHeight      int32
+	GPUComposite       bool
+	Transitions        map[TransitionKind]TransitionEffect
+	TransitionDuration time.Duration
+	ScreenTrail        time.Duration
+	ScreenTrailMode    TrailMode
+	// ScreenShaders run over the desktop before it is shown on the model
+	ScreenShaders ScreenShaders
+	// ShaderDir holds model.vert and model.frag to use in place of the
+	// model's built-in shaders
+	ShaderDir string
+	// Environment is the -env image behind and lighting the model
+	Environment *EnvImage
+	// PostProcess is the passes the model goes through on its way to the
+	// window
+	PostProcess PostProcess
+	// Flat shows the desktop pixel for pixel instead of the model, which
+	// is not loaded, and leaves out transitions and the screen trail
+	Flat bool
+	// Document, when set, is shown in place of the model at ModelPath, as
+	// when rebuilding a lost preview with the model it had
+	Document *gltf.Document
+	// ProgramCache keeps the shader programs linked so the next start,
+	// or rebuilding a lost preview, loads them; nil keeps none
+	ProgramCache *ProgramCache
+}
+
+// Preview is the SDL window showing the model, together with everything that
+// lives in its OpenGL context. When the window or context is lost the whole
+// preview is thrown away and rebuilt.
+type Preview struct {
+	Window    *sdl.Window
+	GLContext sdl.GLContext
+	Renderer  *GLBRenderer
+	// Compositor is nil unless compositing on the GPU
+	Compositor *GLCompositor
+	// Transition is nil when no transitions are configured
+	Transition *DesktopTransition
+	// Trail is nil unless -screen-trail is set
+	Trail *ScreenTrail
+	// ScreenShaders is nil unless -screen-shaders lists some
+	ScreenShaders *ScreenShaderChain
+	Particles     *ParticleRenderer
+	// Post is nil unless a post-processing pass is on
+	Post *PostProcessor
+	// Skybox is nil unless there is an environment
+	Skybox *Skybox
+	// Dimmer stands in for a backlight
+	Dimmer *OutputDimmer
+	// Flat is set with PreviewOptions.Flat and draws instead of Renderer
+	Flat *FlatView
+	// resetStatus asks whether the context was reset, nil when the driver
+	// cannot tell
+	resetStatus func() uint32
+}
+
+// NewPreview creates the window, its GL context, and the model renderer.
+// SDL must already be initialized.
+func NewPreview(opts PreviewOptions) (*Preview, error) {
+	// Set OpenGL attributes
+	sdl.GLSetAttribute(sdl.GL_CONTEXT_MAJOR_VERSION, 4)
+	sdl.GLSetAttribute(sdl.GL_CONTEXT_MINOR_VERSION, 1)
+	sdl.GLSetAttribute(sdl.GL_CONTEXT_PROFILE_MASK, sdl.GL_CONTEXT_PROFILE_CORE)
+	sdl.GLSetAttribute(sdl.GL_DOUBLEBUFFER, 1)
+	sdl.GLSetAttribute(sdl.GL_DEPTH_SIZE, 24)
+
+	p := &Preview{}
+	title, width, height := "Wayland Compositor - 3D View", int32(800), int32(600)
+	if opts.Flat {
+		title, width, height = "Wayland Compositor", opts.DesktopWidth, opts.DesktopHeight
+	}
+	window, err := sdl.CreateWindow(title,
+		sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
+		width, height,
+		sdl.WINDOW_SHOWN|sdl.WINDOW_OPENGL|sdl.WINDOW_RESIZABLE)
+	if err != nil {
+		return nil, fmt.Errorf("create SDL2 window: %w", err)
+	}
+	p.Window = window
+
+	// Create OpenGL context, one that reports GPU resets rather than
+	// drawing garbage after them when the driver can make one
+	sdl.GLSetAttribute(sdl.GL_CONTEXT_FLAGS, sdl.GL_CONTEXT_ROBUST_ACCESS_FLAG)
+	sdl.GLSetAttribute(sdl.GL_CONTEXT_RESET_NOTIFICATION, sdlGLContextResetLoseContext)
+	p.GLContext, err = window.GLCreateContext()
+	if err != nil {
+		sdl.GLSetAttribute(sdl.GL_CONTEXT_FLAGS, 0)
+		sdl.GLSetAttribute(sdl.GL_CONTEXT_RESET_NOTIFICATION, 0)
+		p.GLContext, err = window.GLCreateContext()
+	}
+	if err != nil {
+		p.Destroy()
+		return nil, fmt.Errorf("create OpenGL context: %w", err)
+	}
+
+	// Initialize OpenGL
+	if err := gl.Init(); err != nil {
+		p.Destroy()
+		return nil, fmt.Errorf("initialize OpenGL: %w", err)
+	}
+
+	log.Printf("OpenGL Version: %s", gl.GoStr(gl.GetString(gl.VERSION)))
+	log.Printf("GLSL Version: %s", gl.GoStr(gl.GetString(gl.SHADING_LANGUAGE_VERSION)))
+	p.resetStatus = graphicsResetStatus()
+
+	// Enable depth testing and other OpenGL settings
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+	gl.CullFace(gl.BACK)
+	gl.ClearColor(0.1, 0.1, 0.1, 1.0)
+
+	// Create GLB renderer
+	p.Renderer, err = NewGLBRenderer(opts.ProgramCache)
+	if err != nil {
+		p.Destroy()
+		return nil, fmt.Errorf("create GLB renderer: %w", err)
+	}
+
+	// Load the GLB model, or get ready to show the desktop flat. The
+	// renderer stays for its desktop texture.
+	if opts.Flat {
+		p.Flat, err = NewFlatView(opts.ProgramCache)
+		if err != nil {
+			p.Destroy()
+			return nil, fmt.Errorf("create flat view: %w", err)
+		}
+	} else {
+		p.Renderer.SceneSelector = opts.Scene
+		p.Renderer.TriangleBudget = opts.TriangleBudget
+		if opts.Document != nil {
+			if err := p.Renderer.LoadDocument(opts.Document); err != nil {
+				p.Destroy()
+				return nil, fmt.Errorf("load GLB model: %w", err)
+			}
+		} else {
+			if err := p.Renderer.LoadGLB(opts.ModelPath); err != nil {
+				p.Destroy()
+				return nil, fmt.Errorf("load GLB model: %w", err)
+			}
+			log.Printf("Loaded GLB model: %s (%d meshes)", opts.ModelPath, len(p.Renderer.Meshes))
+		}
+		if opts.ShaderDir != "" {
+			if err := p.Renderer.LoadShaderDir(opts.ShaderDir); err != nil {
+				log.Printf("Keeping the built-in model shaders: %v", err)
+			}
+		}
+		if opts.Environment != nil {
+			p.Skybox, err = NewSkybox(opts.Environment, opts.ProgramCache)
+			if err != nil {
+				p.Destroy()
+				return nil, fmt.Errorf("create skybox: %w", err)
+			}
+			p.Renderer.Environment = p.Skybox
+		}
+	}
+
+	p.Particles, err = NewParticleRenderer(opts.ProgramCache)
+	if err != nil {
+		p.Destroy()
+		return nil, fmt.Errorf("create particle renderer: %w", err)
+	}
+
+	p.Dimmer, err = NewOutputDimmer(opts.ProgramCache)
+	if err != nil {
+		p.Destroy()
+		return nil, fmt.Errorf("create output dimmer: %w", err)
+	}
+
+	// Optionally composite on the GPU straight into the model's texture
+	if opts.GPUComposite {
+		p.Compositor, err = NewGLCompositor(opts.DesktopWidth, opts.DesktopHeight, opts.ProgramCache)
+		if err != nil {
+			p.Destroy()
+			return nil, fmt.Errorf("create GPU compositor: %w", err)
+		}
+		p.Renderer.ExternalTexture = p.Compositor.ColorTexture
+	}
+
+	// Animate the model's screen when the app shown on it changes
+	if len(opts.Transitions) > 0 && !opts.Flat {
+		p.Transition, err = NewDesktopTransition(opts.DesktopWidth, opts.DesktopHeight, opts.TransitionDuration, opts.Transitions, opts.ProgramCache)
+		if err != nil {
+			p.Destroy()
+			return nil, fmt.Errorf("create desktop transitions: %w", err)
+		}
+	}
+
+	// Compile the user's screen shaders, failing on the first that does
+	// not compile
+	if len(opts.ScreenShaders) > 0 && !opts.Flat {
+		p.ScreenShaders, err = NewScreenShaderChain(opts.DesktopWidth, opts.DesktopHeight, opts.ScreenShaders, opts.ProgramCache)
+		if err != nil {
+			p.Destroy()
+			return nil, fmt.Errorf("create screen shaders: %w", err)
+		}
+	}
+
+	// Leave a fading trail of earlier frames on the screen
+	if opts.ScreenTrail > 0 && !opts.Flat {
+		p.Trail, err = NewScreenTrail(opts.DesktopWidth, opts.DesktopHeight, opts.ScreenTrail, opts.ScreenTrailMode, opts.ProgramCache)
+		if err != nil {
+			p.Destroy()
+			return nil, fmt.Errorf("create screen trail: %w", err)
+		}
+	}
+
+	// Draw the model into an HDR framebuffer and post-process it
+	if opts.PostProcess.Enabled() && !opts.Flat {
+		p.Post, err = NewPostProcessor(opts.PostProcess, opts.ProgramCache)
+		if err != nil {
+			p.Destroy()
+			return nil, fmt.Errorf("create post-processing: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// SwapModel replaces the model with an already parsed one, carrying the
+// animation over when the new model has one of the same name
+func (p *Preview) SwapModel(doc *gltf.Document) error {
+	var animation string
+	if p.Renderer.CurrentAnim != nil {
+		animation = p.Renderer.CurrentAnim.Name
+	}
+	if err := p.Renderer.LoadDocument(doc); err != nil {
+		return err
+	}
+	p.Renderer.PlayDefaultAnimation(animation)
+	return nil
+}
+
+// Restore makes a preview built for a lost one look like it: the
+// snapshot's shaders, then its scene state. Build it with the snapshot's
+// document and scene in PreviewOptions.
+func (p *Preview) Restore(s SceneSnapshot) {
+	if s.Shaders != p.Renderer.shaderSources && s.Shaders != [2]string{} {
+		if err := p.Renderer.ReloadShaders(s.Shaders[0], s.Shaders[1]); err != nil {
+			log.Printf("Keeping the built-in model shaders: %v", err)
+		}
+	}
+	p.Renderer.Restore(s)
+}
+
+// PreviewFrame is what Preview.Draw shows
+type PreviewFrame struct {
+	// Screen is the texture shown on the model's screen, 0 for the
+	// desktop
+	Screen uint32
+	// DesktopWidth and DesktopHeight are the desktop's size, which the
+	// flat view shows it at
+	DesktopWidth, DesktopHeight int32
+	// ScreenShaders says which of the screen shaders are on
+	ScreenShaders []bool
+	Particles     []ParticleBurst
+	// PostProcess is false to draw without post-processing
+	PostProcess bool
+	// Backlight is the level from 0 to 1 the window is dimmed to
+	Backlight float64
+}
+
+// Draw draws frame in the window and shows it: the desktop itself with
+// PreviewOptions.Flat, or else the model with its screen going through the
+// transition, the screen shaders and the trail
+func (p *Preview) Draw(frame PreviewFrame, now time.Time) {
+	winW, winH := p.Window.GetSize()
+	if p.Flat != nil {
+		p.Flat.Render(p.DesktopTexture(), frame.DesktopWidth, frame.DesktopHeight, winW, winH)
+		p.Dimmer.Render(frame.Backlight, winW, winH)
+		p.Window.GLSwap()
+		return
+	}
+
+	// Show the old and new frames blended while a transition runs, then
+	// apply the screen shaders and leave the trail
+	screen := frame.Screen
+	if screen == 0 {
+		screen = p.DesktopTexture()
+	}
+	if p.Transition != nil && p.Transition.Active(now) {
+		p.Transition.Render(screen, now)
+		screen = p.Transition.ColorTexture
+	}
+	if p.ScreenShaders != nil {
+		screen = p.ScreenShaders.Render(screen, frame.ScreenShaders, now)
+	}
+	if p.Trail != nil {
+		screen = p.Trail.Render(screen, now)
+	}
+	p.Renderer.ExternalTexture = screen
+
+	// Draw into the post-processing framebuffer when there is one, going
+	// without it if it cannot be made
+	gl.Viewport(0, 0, winW, winH)
+	post := p.Post
+	if !frame.PostProcess {
+		post = nil
+	}
+	if post != nil {
+		if err := post.Begin(winW, winH); err != nil {
+			log.Printf("Turning off post-processing: %v", err)
+			p.Post.Destroy()
+			p.Post, post = nil, nil
+			gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+		}
+	}
+
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	p.Renderer.Render(winW, winH)
+	p.Particles.Render(p.Renderer, frame.Particles, winW, winH, now)
+	if post != nil {
+		post.End()
+	}
+	p.Dimmer.Render(frame.Backlight, winW, winH)
+	p.Window.GLSwap()
+}
+
+// Blank shows black in the window, for while the output is off
+func (p *Preview) Blank() {
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.ClearColor(0, 0, 0, 1)
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+	gl.ClearColor(0.1, 0.1, 0.1, 1.0)
+	p.Window.GLSwap()
+}
+
+// Lost returns why the preview's GL context is gone, or "" while it
+// works. Without a robustness extension only a GL_CONTEXT_LOST error
+// tells, which not every driver raises.
+func (p *Preview) Lost() string {
+	if p.resetStatus != nil {
+		return contextResetReason(p.resetStatus())
+	}
+	if gl.GetError() == gl.CONTEXT_LOST {
+		return "OpenGL context lost"
+	}
+	return ""
+}
+
+// DesktopTexture returns the texture holding the composited desktop
+func (p *Preview) DesktopTexture() uint32 {
+	if p.Compositor != nil {
+		return p.Compositor.ColorTexture
+	}
+	return p.Renderer.TextureID
+}
+
+// Destroy releases the GL resources, the context, and the window
+func (p *Preview) Destroy() {
+	if p.GLContext != nil {
+		if p.Transition != nil {
+			p.Transition.Destroy()
+		}
+		if p.Trail != nil {
+			p.Trail.Destroy()
+		}
+		if p.ScreenShaders != nil {
+			p.ScreenShaders.Destroy()
+		}
+		if p.Post != nil {
+			p.Post.Destroy()
+		}
+		if p.Flat != nil {
+			p.Flat.Destroy()
+		}
+		if p.Particles != nil {
+			p.Particles.Destroy()
+		}
+		if p.Dimmer != nil {
+			p.Dimmer.Destroy()
+		}
+		if p.Skybox != nil {
+			p.Skybox.Destroy()
+		}
+		if p.Compositor != nil {
+			p.Compositor.Destroy()
+		}
+		if p.Renderer != nil {
+			p.Renderer.Destroy()
+		}
+		sdl.GLDeleteContext(p.GLContext)
+	}
+	if p.Window != nil {
+		p.Window.Destroy()
+	}
+	*p = Preview{}
+}
+
+// LostReason returns why an SDL event means the preview has to be rebuilt,
+// or "" if it does not
+func LostReason(event sdl.Event) string {
+	if e, ok := event.(*sdl.RenderEvent); ok && e.Type == sdl.RENDER_DEVICE_RESET {
+		return "graphics device reset"
+	}
+	return ""
+}
+
+// ScancodeToLinux converts SDL2 scancodes to Linux evdev keycodes
+func ScancodeToLinux(scancode sdl.Scancode) uint32 {
+	// SDL scancodes are based on USB HID usage codes
+	// Linux evdev keycodes are different, this maps common keys
+	switch scancode {
+	case sdl.SCANCODE_ESCAPE:
+		return 1
+	case sdl.SCANCODE_1:
+		return 2
+	case sdl.SCANCODE_2:
+		return 3
+	case sdl.SCANCODE_3:
+		return 4
+	case sdl.SCANCODE_4:
+		return 5
+	case sdl.SCANCODE_5:
+		return 6
+	case sdl.SCANCODE_6:
+		return 7
+	case sdl.SCANCODE_7:
+		return 8
+	case sdl.SCANCODE_8:
+		return 9
+	case sdl.SCANCODE_9:
+		return 10
+	case sdl.SCANCODE_0:
+		return 11
+	case sdl.SCANCODE_MINUS:
+		return 12
+	case sdl.SCANCODE_EQUALS:
+		return 13
+	case sdl.SCANCODE_BACKSPACE:
+		return 14
+	case sdl.SCANCODE_TAB:
+		return 15
+	case sdl.SCANCODE_Q:
+		return 16
+	case sdl.SCANCODE_W:
+		return 17
+	case sdl.SCANCODE_E:
+		return 18
+	case sdl.SCANCODE_R:
+		return 19
+	case sdl.SCANCODE_T:
+		return 20
+	case sdl.SCANCODE_Y:
+		return 21
+	case sdl.SCANCODE_U:
+		return 22
+	case sdl.SCANCODE_I:
+		return 23
+	case sdl.SCANCODE_O:
+		return 24
+	case sdl.SCANCODE_P:
+		return 25
+	case sdl.SCANCODE_LEFTBRACKET:
+		return 26
+	case sdl.SCANCODE_RIGHTBRACKET:
+		return 27
+	case sdl.SCANCODE_RETURN:
+		return 28
+	case sdl.SCANCODE_LCTRL:
+		return 29
+	case sdl.SCANCODE_A:
+		return 30
+	case sdl.SCANCODE_S:
+		return 31
+	case sdl.SCANCODE_D:
+		return 32
+	case sdl.SCANCODE_F:
+		return 33
+	case sdl.SCANCODE_G:
+		return 34
+	case sdl.SCANCODE_H:
+		return 35
+	case sdl.SCANCODE_J:
+		return 36
+	case sdl.SCANCODE_K:
+		return 37
+	case sdl.SCANCODE_L:
+		return 38
+	case sdl.SCANCODE_SEMICOLON:
+		return 39
+	case sdl.SCANCODE_APOSTROPHE:
+		return 40
+	case sdl.SCANCODE_GRAVE:
+		return 41
+	case sdl.SCANCODE_LSHIFT:
+		return 42
+	case sdl.SCANCODE_BACKSLASH:
+		return 43
+	case sdl.SCANCODE_Z:
+		return 44
+	case sdl.SCANCODE_X:
+		return 45
+	case sdl.SCANCODE_C:
+		return 46
+	case sdl.SCANCODE_V:
+		return 47
+	case sdl.SCANCODE_B:
+		return 48
+	case sdl.SCANCODE_N:
+		return 49
+	case sdl.SCANCODE_M:
+		return 50
+	case sdl.SCANCODE_COMMA:
+		return 51
+	case sdl.SCANCODE_PERIOD:
+		return 52
+	case sdl.SCANCODE_SLASH:
+		return 53
+	case sdl.SCANCODE_RSHIFT:
+		return 54
+	case sdl.SCANCODE_LALT:
+		return 56
+	case sdl.SCANCODE_SPACE:
+		return 57
+	case sdl.SCANCODE_CAPSLOCK:
+		return 58
+	case sdl.SCANCODE_F1:
+		return 59
+	case sdl.SCANCODE_F2:
+		return 60
+	case sdl.SCANCODE_F3:
+		return 61
+	case sdl.SCANCODE_F4:
+		return 62
+	case sdl.SCANCODE_F5:
+		return 63
+	case sdl.SCANCODE_F6:
+		return 64
+	case sdl.SCANCODE_F7:
+		return 65
+	case sdl.SCANCODE_F8:
+		return 66
+	case sdl.SCANCODE_F9:
+		return 67
+	case sdl.SCANCODE_F10:
+		return 68
+	case sdl.SCANCODE_F11:
+		return 87
+	case sdl.SCANCODE_F12:
+		return 88
+	case sdl.SCANCODE_RCTRL:
+		return 97
+	case sdl.SCANCODE_RALT:
+		return 100
+	case sdl.SCANCODE_HOME:
+		return 102
+	case sdl.SCANCODE_UP:
+		return 103
+	case sdl.SCANCODE_PAGEUP:
+		return 104
+	case sdl.SCANCODE_LEFT:
+		return 105
+	case sdl.SCANCODE_RIGHT:
+		return 106
+	case sdl.SCANCODE_END:
+		return 107
+	case sdl.SCANCODE_DOWN:
+		return 108
+	case sdl.SCANCODE_PAGEDOWN:
+		return 109
+	case sdl.SCANCODE_INSERT:
+		return 110
+	case sdl.SCANCODE_DELETE:
+		return 111
+	case sdl.SCANCODE_LGUI:
+		return 125
+	case sdl.SCANCODE_RGUI:
+		return 126
+	default:
+		return 0
+	}
+}
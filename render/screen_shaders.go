@@ -0,0 +1,273 @@
+//go:build !nogl
+
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// screenShaderPrelude comes before each snippet. The #line directive makes
+// compile errors point at the snippet's own lines.
+const screenShaderPrelude = `#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform sampler2D screen;
+uniform vec2 resolution;
+uniform float time;
+#line 1
+`
+
+const screenShaderMain = `
+void main() {
+    FragColor = effect(TexCoord);
+}
+` + "\x00"
+
+// ScreenShader is a GLSL snippet from the user that the desktop goes
+// through before it is shown on the model. The snippet defines
+// vec4 effect(vec2 uv), with uv from the desktop's top left, and can use
+// screen, resolution and time.
+type ScreenShader struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	// Enabled is whether the shader starts on; it does when unset
+	Enabled *bool `json:"enabled,omitempty"`
+
+	source string
+}
+
+// ScreenShaders is the -screen-shaders flag: snippets in the order they
+// are applied, given as a JSON array
+type ScreenShaders []ScreenShader
+
+// String returns the shaders as JSON
+func (s *ScreenShaders) String() string {
+	if s == nil || len(*s) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(*s)
+	return string(data)
+}
+
+// Set parses the shaders and reads their files, replacing the current ones
+func (s *ScreenShaders) Set(value string) error {
+	shaders, err := parseScreenShaders(value)
+	if err != nil {
+		return err
+	}
+	*s = shaders
+	return nil
+}
+
+// parseScreenShaders parses a JSON array of shaders and reads their files.
+// They are compiled when the preview is created.
+func parseScreenShaders(value string) (ScreenShaders, error) {
+	shaders := ScreenShaders{}
+	if value == "" {
+		return shaders, nil
+	}
+	if err := json.Unmarshal([]byte(value), &shaders); err != nil {
+		return nil, fmt.Errorf("parse screen shaders: %w", err)
+	}
+	seen := map[string]bool{}
+	for i := range shaders {
+		s := &shaders[i]
+		if s.Name == "" || s.File == "" {
+			return nil, fmt.Errorf("screen shader %d: name and file are required", i+1)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("screen shader %q is listed twice", s.Name)
+		}
+		seen[s.Name] = true
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return nil, fmt.Errorf("screen shader %q: %w", s.Name, err)
+		}
+		s.source = string(data)
+	}
+	return shaders, nil
+}
+
+// fragmentSource is the full fragment shader for the snippet
+func (s ScreenShader) fragmentSource() string {
+	return screenShaderPrelude + s.source + screenShaderMain
+}
+
+// ScreenShaderInfo describes a screen shader for the control API
+type ScreenShaderInfo struct {
+	Name    string `json:"name"`
+	File    string `json:"file"`
+	Enabled bool   `json:"enabled"`
+}
+
+// ScreenShaderToggles keeps which screen shaders are on. It outlives the
+// preview, so the choice survives the preview being rebuilt.
+type ScreenShaderToggles struct {
+	mu      sync.Mutex
+	shaders ScreenShaders
+	enabled []bool
+}
+
+// NewScreenShaderToggles turns on the shaders that start enabled
+func NewScreenShaderToggles(shaders ScreenShaders) *ScreenShaderToggles {
+	t := &ScreenShaderToggles{shaders: shaders, enabled: make([]bool, len(shaders))}
+	for i, s := range shaders {
+		t.enabled[i] = s.Enabled == nil || *s.Enabled
+	}
+	return t
+}
+
+// Enabled returns whether each shader is on, in order
+func (t *ScreenShaderToggles) Enabled() []bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]bool(nil), t.enabled...)
+}
+
+// Set turns a shader on or off
+func (t *ScreenShaderToggles) Set(name string, enabled bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, s := range t.shaders {
+		if s.Name == name {
+			t.enabled[i] = enabled
+			return nil
+		}
+	}
+	return fmt.Errorf("no screen shader %q", name)
+}
+
+// Info lists the shaders in order
+func (t *ScreenShaderToggles) Info() []ScreenShaderInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info := make([]ScreenShaderInfo, len(t.shaders))
+	for i, s := range t.shaders {
+		info[i] = ScreenShaderInfo{Name: s.Name, File: s.File, Enabled: t.enabled[i]}
+	}
+	return info
+}
+
+// screenShaderProgram is a compiled screen shader and its uniforms
+type screenShaderProgram struct {
+	program       uint32
+	screenLoc     int32
+	resolutionLoc int32
+	timeLoc       int32
+}
+
+// ScreenShaderChain runs the enabled screen shaders over the desktop, one
+// after another, through a pair of framebuffers
+type ScreenShaderChain struct {
+	Width  int32
+	Height int32
+
+	programs []screenShaderProgram
+	textures [2]uint32
+	fbos     [2]uint32
+	quadVAO  uint32
+	quadVBO  uint32
+	start    time.Time
+}
+
+// NewScreenShaderChain compiles the shaders for a width x height desktop.
+// A shader that does not compile fails the whole chain, naming the shader
+// and its file.
+func NewScreenShaderChain(width, height int32, shaders ScreenShaders, programs *ProgramCache) (*ScreenShaderChain, error) {
+	c := &ScreenShaderChain{Width: width, Height: height, start: time.Now()}
+	for _, s := range shaders {
+		program, err := newShaderProgram(programs, transitionVertexShaderSource, s.fragmentSource())
+		if err != nil {
+			c.Destroy()
+			return nil, fmt.Errorf("screen shader %q (%s): %w", s.Name, s.File, err)
+		}
+		c.programs = append(c.programs, screenShaderProgram{
+			program:       program,
+			screenLoc:     gl.GetUniformLocation(program, gl.Str("screen\x00")),
+			resolutionLoc: gl.GetUniformLocation(program, gl.Str("resolution\x00")),
+			timeLoc:       gl.GetUniformLocation(program, gl.Str("time\x00")),
+		})
+	}
+
+	quad := []float32{0, 0, 1, 0, 0, 1, 1, 1}
+	gl.GenVertexArrays(1, &c.quadVAO)
+	gl.BindVertexArray(c.quadVAO)
+	gl.GenBuffers(1, &c.quadVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, c.quadVBO)
+	gl.BufferData(gl.ARRAY_BUFFER, len(quad)*4, gl.Ptr(quad), gl.STATIC_DRAW)
+	gl.VertexAttribPointerWithOffset(0, 2, gl.FLOAT, false, 2*4, 0)
+	gl.EnableVertexAttribArray(0)
+	gl.BindVertexArray(0)
+
+	gl.GenFramebuffers(2, &c.fbos[0])
+	for i := range c.textures {
+		c.textures[i] = newSurfaceGLTexture()
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, c.fbos[i])
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, c.textures[i], 0)
+		status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+		if status != gl.FRAMEBUFFER_COMPLETE {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+			c.Destroy()
+			return nil, fmt.Errorf("framebuffer incomplete: 0x%x", status)
+		}
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	return c, nil
+}
+
+// Render runs the shaders that are enabled over source and returns the
+// texture to show, source itself when none are
+func (c *ScreenShaderChain) Render(source uint32, enabled []bool, now time.Time) uint32 {
+	seconds := float32(now.Sub(c.start).Seconds())
+	next := 0
+	for i, p := range c.programs {
+		if i >= len(enabled) || !enabled[i] {
+			continue
+		}
+		gl.BindFramebuffer(gl.FRAMEBUFFER, c.fbos[next])
+		gl.Viewport(0, 0, c.Width, c.Height)
+		gl.Disable(gl.DEPTH_TEST)
+		gl.Disable(gl.CULL_FACE)
+
+		gl.UseProgram(p.program)
+		gl.Uniform1i(p.screenLoc, 0)
+		gl.Uniform2f(p.resolutionLoc, float32(c.Width), float32(c.Height))
+		gl.Uniform1f(p.timeLoc, seconds)
+		gl.ActiveTexture(gl.TEXTURE0)
+		gl.BindTexture(gl.TEXTURE_2D, source)
+		gl.BindVertexArray(c.quadVAO)
+		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+
+		source = c.textures[next]
+		next = 1 - next
+	}
+	gl.BindVertexArray(0)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	return source
+}
+
+// Destroy releases all GL resources owned by the chain
+func (c *ScreenShaderChain) Destroy() {
+	for _, p := range c.programs {
+		gl.DeleteProgram(p.program)
+	}
+	if c.fbos[0] != 0 {
+		gl.DeleteFramebuffers(2, &c.fbos[0])
+		gl.DeleteTextures(2, &c.textures[0])
+	}
+	if c.quadVAO != 0 {
+		gl.DeleteBuffers(1, &c.quadVBO)
+		gl.DeleteVertexArrays(1, &c.quadVAO)
+	}
+}
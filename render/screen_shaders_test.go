@@ -0,0 +1,61 @@
+//go:build !nogl
+
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseScreenShaders(t *testing.T) {
+	dir := t.TempDir()
+	crt := filepath.Join(dir, "crt.glsl")
+	os.WriteFile(crt, []byte("vec4 effect(vec2 uv) { return texture(screen, uv); }\n"), 0o644)
+
+	shaders, err := parseScreenShaders(`[{"name": "crt", "file": "` + crt + `"}, {"name": "grade", "file": "` + crt + `", "enabled": false}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shaders) != 2 || shaders[0].Name != "crt" || shaders[1].Name != "grade" {
+		t.Fatalf("shaders %+v", shaders)
+	}
+	source := shaders[0].fragmentSource()
+	if !strings.Contains(source, "#line 1\nvec4 effect") || !strings.HasSuffix(source, "\x00") {
+		t.Errorf("snippet not placed after #line 1: %q", source)
+	}
+
+	for _, bad := range []string{
+		`[{"name": "crt"}]`,
+		`[{"name": "crt", "file": "` + crt + `"}, {"name": "crt", "file": "` + crt + `"}]`,
+		`[{"name": "gone", "file": "` + filepath.Join(dir, "missing.glsl") + `"}]`,
+		`{"crt": "crt.glsl"}`,
+	} {
+		if _, err := parseScreenShaders(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestScreenShaderToggles(t *testing.T) {
+	off := false
+	toggles := NewScreenShaderToggles(ScreenShaders{{Name: "crt"}, {Name: "grade", Enabled: &off}})
+	if got := toggles.Enabled(); !slices.Equal(got, []bool{true, false}) {
+		t.Errorf("enabled %v at start", got)
+	}
+	if err := toggles.Set("grade", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := toggles.Set("crt", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := toggles.Set("bloom", true); err == nil {
+		t.Error("expected an error for an unknown shader")
+	}
+	info := toggles.Info()
+	if len(info) != 2 || info[0].Enabled || !info[1].Enabled {
+		t.Errorf("info %+v", info)
+	}
+}
@@ -0,0 +1,182 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// TrailMode selects how the previous frames linger on the model's screen.
+// The values are passed to the shader as the mode uniform.
+type TrailMode int32
+
+const (
+	// TrailPhosphor keeps bright pixels glowing as they fade, like a CRT
+	TrailPhosphor TrailMode = iota
+	// TrailGhost blends every frame with the ones before, like motion blur
+	TrailGhost
+)
+
+var trailModeNames = map[string]TrailMode{
+	"phosphor": TrailPhosphor,
+	"ghost":    TrailGhost,
+}
+
+// ParseTrailMode looks up a -screen-trail-mode name
+func ParseTrailMode(name string) (TrailMode, error) {
+	mode, ok := trailModeNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown screen trail mode %q, want phosphor or ghost", name)
+	}
+	return mode, nil
+}
+
+// trailDecay is how much of the trail is left after dt for a trail that
+// fades to half in halfLife
+func trailDecay(halfLife, dt time.Duration) float32 {
+	if halfLife <= 0 {
+		return 0
+	}
+	return float32(math.Pow(0.5, dt.Seconds()/halfLife.Seconds()))
+}
+
+const trailFragmentShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 TexCoord;
+
+uniform sampler2D currentFrame;
+uniform sampler2D trail;
+uniform int mode;
+uniform float decay;
+
+void main() {
+    vec4 current = texture(currentFrame, TexCoord);
+    vec4 previous = texture(trail, TexCoord);
+    if (mode == 0) {
+        FragColor = max(current, previous * decay);
+    } else {
+        FragColor = mix(current, previous, decay);
+    }
+}
+` + "\x00"
+
+// ScreenTrail leaves a fading trail of earlier frames on the model's
+// screen. Each frame is blended with the trail so far into one of two
+// framebuffers, which swap places every frame.
+type ScreenTrail struct {
+	Width    int32
+	Height   int32
+	HalfLife time.Duration
+	Mode     TrailMode
+
+	textures [2]uint32
+	fbos     [2]uint32
+	// current is the index of the texture holding the trail so far
+	current  int
+	last     time.Time
+	program  uint32
+	quadVAO  uint32
+	quadVBO  uint32
+	frameLoc int32
+	trailLoc int32
+	modeLoc  int32
+	decayLoc int32
+}
+
+// NewScreenTrail creates a trail for a width x height desktop
+func NewScreenTrail(width, height int32, halfLife time.Duration, mode TrailMode, programs *ProgramCache) (*ScreenTrail, error) {
+	t := &ScreenTrail{Width: width, Height: height, HalfLife: halfLife, Mode: mode}
+
+	program, err := newShaderProgram(programs, transitionVertexShaderSource, trailFragmentShaderSource)
+	if err != nil {
+		return nil, fmt.Errorf("trail shader: %w", err)
+	}
+	t.program = program
+	t.frameLoc = gl.GetUniformLocation(program, gl.Str("currentFrame\x00"))
+	t.trailLoc = gl.GetUniformLocation(program, gl.Str("trail\x00"))
+	t.modeLoc = gl.GetUniformLocation(program, gl.Str("mode\x00"))
+	t.decayLoc = gl.GetUniformLocation(program, gl.Str("decay\x00"))
+
+	quad := []float32{0, 0, 1, 0, 0, 1, 1, 1}
+	gl.GenVertexArrays(1, &t.quadVAO)
+	gl.BindVertexArray(t.quadVAO)
+	gl.GenBuffers(1, &t.quadVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, t.quadVBO)
+	gl.BufferData(gl.ARRAY_BUFFER, len(quad)*4, gl.Ptr(quad), gl.STATIC_DRAW)
+	gl.VertexAttribPointerWithOffset(0, 2, gl.FLOAT, false, 2*4, 0)
+	gl.EnableVertexAttribArray(0)
+	gl.BindVertexArray(0)
+
+	gl.GenFramebuffers(2, &t.fbos[0])
+	for i := range t.textures {
+		t.textures[i] = newSurfaceGLTexture()
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, t.fbos[i])
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, t.textures[i], 0)
+		status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+		if status != gl.FRAMEBUFFER_COMPLETE {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+			t.Destroy()
+			return nil, fmt.Errorf("framebuffer incomplete: 0x%x", status)
+		}
+		// Start from black rather than whatever the memory held
+		gl.ClearColor(0, 0, 0, 0)
+		gl.Clear(gl.COLOR_BUFFER_BIT)
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.ClearColor(0.1, 0.1, 0.1, 1.0)
+
+	return t, nil
+}
+
+// Render blends the frame in source into the trail and returns the texture
+// to show
+func (t *ScreenTrail) Render(source uint32, now time.Time) uint32 {
+	decay := float32(0)
+	if !t.last.IsZero() {
+		decay = trailDecay(t.HalfLife, now.Sub(t.last))
+	}
+	t.last = now
+	next := 1 - t.current
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, t.fbos[next])
+	gl.Viewport(0, 0, t.Width, t.Height)
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.CULL_FACE)
+
+	gl.UseProgram(t.program)
+	gl.Uniform1i(t.frameLoc, 0)
+	gl.Uniform1i(t.trailLoc, 1)
+	gl.Uniform1i(t.modeLoc, int32(t.Mode))
+	gl.Uniform1f(t.decayLoc, decay)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, source)
+	gl.ActiveTexture(gl.TEXTURE1)
+	gl.BindTexture(gl.TEXTURE_2D, t.textures[t.current])
+	gl.BindVertexArray(t.quadVAO)
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+
+	gl.BindVertexArray(0)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+
+	t.current = next
+	return t.textures[t.current]
+}
+
+// Destroy releases all GL resources owned by the trail
+func (t *ScreenTrail) Destroy() {
+	gl.DeleteFramebuffers(2, &t.fbos[0])
+	gl.DeleteTextures(2, &t.textures[0])
+	gl.DeleteBuffers(1, &t.quadVBO)
+	gl.DeleteVertexArrays(1, &t.quadVAO)
+	gl.DeleteProgram(t.program)
+}
@@ -0,0 +1,35 @@
+//go:build !nogl
+
+package render
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTrailDecay(t *testing.T) {
+	tests := []struct {
+		halfLife, dt time.Duration
+		want         float32
+	}{
+		{100 * time.Millisecond, 100 * time.Millisecond, 0.5},
+		{100 * time.Millisecond, 200 * time.Millisecond, 0.25},
+		{time.Second, 0, 1},
+		{0, 16 * time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		if got := trailDecay(tt.halfLife, tt.dt); math.Abs(float64(got-tt.want)) > 1e-6 {
+			t.Errorf("trailDecay(%v, %v) = %v, want %v", tt.halfLife, tt.dt, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrailMode(t *testing.T) {
+	if mode, err := ParseTrailMode("ghost"); err != nil || mode != TrailGhost {
+		t.Errorf("ghost: %v, %v", mode, err)
+	}
+	if _, err := ParseTrailMode("smear"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
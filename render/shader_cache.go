@@ -0,0 +1,390 @@
+//go:build !nogl
+
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// glCompletionStatusKHR is GL_COMPLETION_STATUS_KHR, which
+// KHR_parallel_shader_compile adds to ask whether a shader or program has
+// finished compiling without waiting for it
+const glCompletionStatusKHR = 0x91B1
+
+// ProgramCache keeps linked shader programs on disk as the driver's own
+// binaries (glGetProgramBinary), keyed by a hash of their sources and the
+// driver, so starting again, or recreating a lost context, loads them
+// rather than compiling. Binaries from another driver or version are
+// never loaded, and ones the driver turns down are compiled again.
+type ProgramCache struct {
+	dir string
+}
+
+// NewProgramCache keeps programs in dir, creating it. An empty dir gives a
+// nil cache, which keeps nothing.
+func NewProgramCache(dir string) (*ProgramCache, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &ProgramCache{dir: dir}, nil
+}
+
+// DefaultShaderCacheDir is the program cache under $XDG_CACHE_HOME, or
+// ~/.cache, empty when there is no home directory either
+func DefaultShaderCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "wayland-compositor", "shaders")
+}
+
+// Key names a program by the driver that links it and its sources
+func (c *ProgramCache) Key(driver, vertexSource, fragmentSource string) string {
+	h := sha256.New()
+	for _, s := range []string{driver, vertexSource, fragmentSource} {
+		h.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(s))))
+		h.Write([]byte(s))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Load returns the binary kept under key and its format
+func (c *ProgramCache) Load(key string) (format uint32, data []byte, ok bool) {
+	if c == nil {
+		return 0, nil, false
+	}
+	file, err := os.ReadFile(c.path(key))
+	if err != nil || len(file) <= 4 {
+		return 0, nil, false
+	}
+	return binary.LittleEndian.Uint32(file), file[4:], true
+}
+
+// Store keeps a program's binary under key, replacing any kept before
+func (c *ProgramCache) Store(key string, format uint32, data []byte) error {
+	if c == nil || len(data) == 0 {
+		return nil
+	}
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(binary.LittleEndian.AppendUint32(nil, format), data...))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+// Remove drops the binary kept under key, for one the driver turned down
+func (c *ProgramCache) Remove(key string) {
+	if c != nil {
+		os.Remove(c.path(key))
+	}
+}
+
+func (c *ProgramCache) path(key string) string {
+	return filepath.Join(c.dir, key+".bin")
+}
+
+// shaderCompiler is the part of OpenGL that compiles, links and loads
+// programs, split in two so a compile can be started and its result
+// picked up later. glShaderCompiler is the real one; tests swap in a fake.
+type shaderCompiler interface {
+	// driver names the driver, so binaries are only loaded by the one that
+	// made them
+	driver() string
+	// start compiles and links a program without waiting for the result
+	start(vertexSource, fragmentSource string) (program uint32, shaders [2]uint32)
+	// done reports whether a started program has finished, without
+	// waiting
+	done(program uint32) bool
+	// finish waits for a started program and frees its shaders, returning
+	// why it did not compile or link
+	finish(program uint32, shaders [2]uint32) error
+	// binary returns a linked program's binary, empty if the driver has
+	// none to give
+	binary(program uint32) (format uint32, data []byte)
+	// load makes a program from a binary, reporting whether the driver
+	// took it
+	load(format uint32, data []byte) (uint32, bool)
+	deleteProgram(program uint32)
+}
+
+// linkProgram compiles and links a program, or loads it from cache
+func linkProgram(compiler shaderCompiler, cache *ProgramCache, vertexSource, fragmentSource string) (uint32, error) {
+	key := ""
+	if cache != nil {
+		key = cache.Key(compiler.driver(), vertexSource, fragmentSource)
+		if format, data, ok := cache.Load(key); ok {
+			if program, ok := compiler.load(format, data); ok {
+				return program, nil
+			}
+			cache.Remove(key)
+		}
+	}
+	program, shaders := compiler.start(vertexSource, fragmentSource)
+	if err := compiler.finish(program, shaders); err != nil {
+		compiler.deleteProgram(program)
+		return 0, err
+	}
+	storeProgram(compiler, cache, key, program)
+	return program, nil
+}
+
+// storeProgram keeps a newly linked program's binary under key
+func storeProgram(compiler shaderCompiler, cache *ProgramCache, key string, program uint32) {
+	if cache == nil {
+		return
+	}
+	if format, data := compiler.binary(program); len(data) > 0 {
+		if err := cache.Store(key, format, data); err != nil {
+			log.Printf("Failed to cache a shader program: %v", err)
+		}
+	}
+}
+
+// PendingProgram is a program ShaderQueue is compiling
+type PendingProgram struct {
+	vertexSource, fragmentSource string
+	key                          string
+	program                      uint32
+	shaders                      [2]uint32
+	started, finished            bool
+	err                          error
+}
+
+// Ready returns the program once it has compiled and linked
+func (p *PendingProgram) Ready() (uint32, bool) {
+	return p.program, p.finished && p.err == nil
+}
+
+// Err returns why the program did not compile or link, once it is
+// finished
+func (p *PendingProgram) Err() error {
+	return p.err
+}
+
+// Finished reports whether the program has compiled, or failed to
+func (p *PendingProgram) Finished() bool {
+	return p.finished
+}
+
+// ShaderQueue compiles programs on the render loop without stalling it.
+// A program asked for is loaded at once if it is cached; otherwise its
+// compile is started and picked up on a later frame, and the caller draws
+// with what it had, or a fallback, until then. Drivers with
+// KHR_parallel_shader_compile compile on their own threads and the queue
+// only picks up what they have finished; others finish at most one
+// program a frame, so a batch of new programs spreads its stalls out.
+type ShaderQueue struct {
+	compiler shaderCompiler
+	cache    *ProgramCache
+	// parallel is set when the driver compiles on its own threads
+	parallel bool
+	pending  []*PendingProgram
+}
+
+// NewShaderQueue compiles on the current context, keeping programs in
+// cache
+func NewShaderQueue(cache *ProgramCache) *ShaderQueue {
+	return &ShaderQueue{compiler: glShaderCompiler{}, cache: cache, parallel: hasGLExtension("GL_KHR_parallel_shader_compile")}
+}
+
+// Compile queues a program. Poll it each frame until it is finished.
+func (q *ShaderQueue) Compile(vertexSource, fragmentSource string) *PendingProgram {
+	p := &PendingProgram{vertexSource: vertexSource, fragmentSource: fragmentSource}
+	if q.cache != nil {
+		p.key = q.cache.Key(q.compiler.driver(), vertexSource, fragmentSource)
+		if format, data, ok := q.cache.Load(p.key); ok {
+			if program, ok := q.compiler.load(format, data); ok {
+				p.program, p.finished = program, true
+				return p
+			}
+			q.cache.Remove(p.key)
+		}
+	}
+	q.pending = append(q.pending, p)
+	return p
+}
+
+// Poll starts the queued compiles and picks up the finished ones. Call it
+// once a frame on the render loop.
+func (q *ShaderQueue) Poll() {
+	for _, p := range q.pending {
+		if !p.started {
+			p.program, p.shaders = q.compiler.start(p.vertexSource, p.fragmentSource)
+			p.started = true
+		}
+	}
+	// Without parallel compiles the driver has likely not started the
+	// work yet, so only the oldest is waited for
+	waited := false
+	kept := q.pending[:0]
+	for _, p := range q.pending {
+		ready := false
+		if q.parallel {
+			ready = q.compiler.done(p.program)
+		} else if !waited {
+			ready, waited = true, true
+		}
+		if !ready {
+			kept = append(kept, p)
+			continue
+		}
+		q.finish(p)
+	}
+	clear(q.pending[len(kept):])
+	q.pending = kept
+}
+
+func (q *ShaderQueue) finish(p *PendingProgram) {
+	p.finished = true
+	if p.err = q.compiler.finish(p.program, p.shaders); p.err != nil {
+		q.compiler.deleteProgram(p.program)
+		p.program = 0
+		return
+	}
+	storeProgram(q.compiler, q.cache, p.key, p.program)
+}
+
+// Cancel drops a program that is no longer wanted, freeing it whether or
+// not it has finished
+func (q *ShaderQueue) Cancel(p *PendingProgram) {
+	if i := slices.Index(q.pending, p); i >= 0 {
+		q.pending = slices.Delete(q.pending, i, i+1)
+		if p.started {
+			q.compiler.finish(p.program, p.shaders)
+		}
+		p.finished = true
+	}
+	if p.program != 0 {
+		q.compiler.deleteProgram(p.program)
+		p.program = 0
+	}
+}
+
+// Pending returns how many programs are still compiling
+func (q *ShaderQueue) Pending() int {
+	return len(q.pending)
+}
+
+// hasGLExtension reports whether the current context has extension
+func hasGLExtension(extension string) bool {
+	var count int32
+	gl.GetIntegerv(gl.NUM_EXTENSIONS, &count)
+	for i := range uint32(count) {
+		if gl.GoStr(gl.GetStringi(gl.EXTENSIONS, i)) == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// glShaderCompiler is shaderCompiler on the current context
+type glShaderCompiler struct{}
+
+func (glShaderCompiler) driver() string {
+	return strings.Join([]string{
+		gl.GoStr(gl.GetString(gl.VENDOR)),
+		gl.GoStr(gl.GetString(gl.RENDERER)),
+		gl.GoStr(gl.GetString(gl.VERSION)),
+	}, "\n")
+}
+
+func (glShaderCompiler) start(vertexSource, fragmentSource string) (uint32, [2]uint32) {
+	shaders := [2]uint32{gl.CreateShader(gl.VERTEX_SHADER), gl.CreateShader(gl.FRAGMENT_SHADER)}
+	for i, source := range []string{vertexSource, fragmentSource} {
+		csources, free := gl.Strs(source)
+		gl.ShaderSource(shaders[i], 1, csources, nil)
+		free()
+		gl.CompileShader(shaders[i])
+	}
+	program := gl.CreateProgram()
+	gl.ProgramParameteri(program, gl.PROGRAM_BINARY_RETRIEVABLE_HINT, gl.TRUE)
+	gl.AttachShader(program, shaders[0])
+	gl.AttachShader(program, shaders[1])
+	gl.LinkProgram(program)
+	return program, shaders
+}
+
+func (glShaderCompiler) done(program uint32) bool {
+	var status int32
+	gl.GetProgramiv(program, glCompletionStatusKHR, &status)
+	return status != gl.FALSE
+}
+
+func (glShaderCompiler) finish(program uint32, shaders [2]uint32) error {
+	defer gl.DeleteShader(shaders[0])
+	defer gl.DeleteShader(shaders[1])
+	for i, name := range []string{"vertex shader", "fragment shader"} {
+		var status int32
+		gl.GetShaderiv(shaders[i], gl.COMPILE_STATUS, &status)
+		if status == gl.FALSE {
+			var logLength int32
+			gl.GetShaderiv(shaders[i], gl.INFO_LOG_LENGTH, &logLength)
+			log := make([]byte, max(logLength, 1))
+			gl.GetShaderInfoLog(shaders[i], logLength, nil, &log[0])
+			return fmt.Errorf("%s: compile: %s", name, string(log))
+		}
+	}
+
+	var status int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLength)
+		log := make([]byte, max(logLength, 1))
+		gl.GetProgramInfoLog(program, logLength, nil, &log[0])
+		return fmt.Errorf("program link: %s", string(log))
+	}
+	return nil
+}
+
+func (glShaderCompiler) binary(program uint32) (uint32, []byte) {
+	var length int32
+	gl.GetProgramiv(program, gl.PROGRAM_BINARY_LENGTH, &length)
+	if length <= 0 {
+		return 0, nil
+	}
+	data := make([]byte, length)
+	var format uint32
+	gl.GetProgramBinary(program, length, &length, &format, unsafe.Pointer(&data[0]))
+	return format, data[:length]
+}
+
+func (glShaderCompiler) load(format uint32, data []byte) (uint32, bool) {
+	program := gl.CreateProgram()
+	gl.ProgramParameteri(program, gl.PROGRAM_BINARY_RETRIEVABLE_HINT, gl.TRUE)
+	gl.ProgramBinary(program, format, unsafe.Pointer(&data[0]), int32(len(data)))
+	var status int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		gl.DeleteProgram(program)
+		return 0, false
+	}
+	return program, true
+}
+
+func (glShaderCompiler) deleteProgram(program uint32) {
+	gl.DeleteProgram(program)
+}
@@ -0,0 +1,195 @@
+//go:build !nogl
+
+package render
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeCompiler is a shaderCompiler that compiles anything without "error"
+// in it, with programs' binaries being their sources
+type fakeCompiler struct {
+	next     uint32
+	sources  map[uint32]string
+	finished map[uint32]bool
+	// completed are the started programs done reports as finished
+	completed map[uint32]bool
+	deleted   []uint32
+	started   int
+}
+
+func newFakeCompiler() *fakeCompiler {
+	return &fakeCompiler{sources: make(map[uint32]string), finished: make(map[uint32]bool), completed: make(map[uint32]bool)}
+}
+
+func (c *fakeCompiler) driver() string { return "fake" }
+
+func (c *fakeCompiler) start(vertexSource, fragmentSource string) (uint32, [2]uint32) {
+	c.next++
+	c.started++
+	c.sources[c.next] = vertexSource + "|" + fragmentSource
+	return c.next, [2]uint32{}
+}
+
+func (c *fakeCompiler) done(program uint32) bool { return c.completed[program] }
+
+func (c *fakeCompiler) finish(program uint32, shaders [2]uint32) error {
+	c.finished[program] = true
+	if strings.Contains(c.sources[program], "error") {
+		return errors.New("fragment shader: compile: error")
+	}
+	return nil
+}
+
+func (c *fakeCompiler) binary(program uint32) (uint32, []byte) {
+	return 1, []byte(c.sources[program])
+}
+
+func (c *fakeCompiler) load(format uint32, data []byte) (uint32, bool) {
+	if format != 1 || strings.Contains(string(data), "bad") {
+		return 0, false
+	}
+	c.next++
+	c.sources[c.next] = string(data)
+	return c.next, true
+}
+
+func (c *fakeCompiler) deleteProgram(program uint32) {
+	c.deleted = append(c.deleted, program)
+}
+
+func TestProgramCache(t *testing.T) {
+	cache, err := NewProgramCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := cache.Key("driver", "vs", "fs")
+	for _, other := range []string{cache.Key("other", "vs", "fs"), cache.Key("driver", "vsf", "s"), cache.Key("driver", "vs", "fs2")} {
+		if other == key {
+			t.Errorf("Expected the key to change with the driver and sources, got %s for both", key)
+		}
+	}
+
+	if _, _, ok := cache.Load(key); ok {
+		t.Fatal("Expected nothing cached yet")
+	}
+	if err := cache.Store(key, 7, []byte("binary")); err != nil {
+		t.Fatal(err)
+	}
+	if format, data, ok := cache.Load(key); !ok || format != 7 || string(data) != "binary" {
+		t.Errorf("Expected format 7 and the stored binary, got %d, %q, %v", format, data, ok)
+	}
+	cache.Remove(key)
+	if _, _, ok := cache.Load(key); ok {
+		t.Error("Expected the removed program gone")
+	}
+
+	if nilCache, err := NewProgramCache(""); nilCache != nil || err != nil {
+		t.Errorf("Expected no cache for an empty directory, got %v, %v", nilCache, err)
+	}
+}
+
+func TestLinkProgram(t *testing.T) {
+	cache, err := NewProgramCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newFakeCompiler()
+	if _, err := linkProgram(c, cache, "vs", "fs"); err != nil {
+		t.Fatal(err)
+	}
+	// The second time it is loaded from the cache
+	if _, err := linkProgram(c, cache, "vs", "fs"); err != nil {
+		t.Fatal(err)
+	}
+	if c.started != 1 {
+		t.Errorf("Expected one compile, got %d", c.started)
+	}
+
+	if _, err := linkProgram(c, cache, "vs", "error"); err == nil {
+		t.Error("Expected a compile error")
+	}
+	if len(c.deleted) != 1 {
+		t.Errorf("Expected the failed program deleted, got %v", c.deleted)
+	}
+
+	// A binary the driver turns down is removed and compiled again
+	key := cache.Key("fake", "vs", "bad")
+	if err := cache.Store(key, 1, []byte("bad")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := linkProgram(c, cache, "vs", "bad"); err != nil {
+		t.Fatal(err)
+	}
+	if c.started != 3 {
+		t.Errorf("Expected the turned down binary compiled, got %d compiles", c.started)
+	}
+	if _, err := os.Stat(cache.path(key)); err != nil {
+		t.Errorf("Expected the compiled program cached again, got %v", err)
+	}
+}
+
+func TestShaderQueue(t *testing.T) {
+	c := newFakeCompiler()
+	q := &ShaderQueue{compiler: c}
+	first, second := q.Compile("vs", "one"), q.Compile("vs", "two")
+	failed := q.Compile("vs", "error")
+	if first.Finished() {
+		t.Fatal("Expected nothing finished before a poll")
+	}
+
+	// Without parallel compiles one program finishes a frame
+	for i, want := range []*PendingProgram{first, second, failed} {
+		q.Poll()
+		if !want.Finished() || q.Pending() != 2-i {
+			t.Fatalf("Poll %d: expected one more program finished, %d pending", i, q.Pending())
+		}
+	}
+	if _, ok := second.Ready(); !ok {
+		t.Error("Expected the second program ready")
+	}
+	if program, ok := failed.Ready(); ok || program != 0 || failed.Err() == nil {
+		t.Errorf("Expected the failed program reported, got %d, %v, %v", program, ok, failed.Err())
+	}
+
+	// With them, programs finish when the driver is done
+	q.parallel = true
+	p := q.Compile("vs", "three")
+	q.Poll()
+	if p.Finished() {
+		t.Fatal("Expected the program waited for until the driver is done")
+	}
+	c.completed[c.next] = true
+	q.Poll()
+	if _, ok := p.Ready(); !ok {
+		t.Error("Expected the program ready once the driver is done")
+	}
+
+	// Cancelled programs are freed
+	cancelled := q.Compile("vs", "four")
+	q.Poll()
+	q.Cancel(cancelled)
+	if q.Pending() != 0 || c.deleted[len(c.deleted)-1] != c.next {
+		t.Errorf("Expected the cancelled program dropped and deleted, %d pending, deleted %v", q.Pending(), c.deleted)
+	}
+}
+
+func TestShaderQueueCached(t *testing.T) {
+	cache, err := NewProgramCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newFakeCompiler()
+	q := &ShaderQueue{compiler: c, cache: cache}
+	q.Compile("vs", "fs")
+	q.Poll()
+
+	// The next time it is ready without a poll
+	p := q.Compile("vs", "fs")
+	if _, ok := p.Ready(); !ok || q.Pending() != 0 || c.started != 1 {
+		t.Errorf("Expected the cached program ready at once, %d pending, %d compiles", q.Pending(), c.started)
+	}
+}
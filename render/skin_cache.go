@@ -0,0 +1,132 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// skinCacheVertexShaderSource skins each vertex and captures the result
+// with transform feedback, in the same 16 float layout loadPrimitive
+// uploads. The captured vertices have zero weights, so the main shader
+// draws them unskinned.
+const skinCacheVertexShaderSource = `
+#version 410 core
+layout (location = 0) in vec3 aPos;
+layout (location = 1) in vec3 aNormal;
+layout (location = 2) in vec2 aTexCoord;
+layout (location = 3) in vec4 aJoints;
+layout (location = 4) in vec4 aWeights;
+
+out vec3 tfPos;
+out vec3 tfNormal;
+out vec2 tfTexCoord;
+out vec4 tfJoints;
+out vec4 tfWeights;
+
+uniform mat4 boneMatrices[128];
+
+void main() {
+    mat4 skinMatrix = mat4(0.0);
+    float totalWeight = aWeights.x + aWeights.y + aWeights.z + aWeights.w;
+
+    if (totalWeight > 0.0) {
+        skinMatrix += boneMatrices[int(aJoints.x)] * aWeights.x;
+        skinMatrix += boneMatrices[int(aJoints.y)] * aWeights.y;
+        skinMatrix += boneMatrices[int(aJoints.z)] * aWeights.z;
+        skinMatrix += boneMatrices[int(aJoints.w)] * aWeights.w;
+    } else {
+        skinMatrix = mat4(1.0);
+    }
+
+    tfPos = vec3(skinMatrix * vec4(aPos, 1.0));
+    tfNormal = mat3(skinMatrix) * aNormal;
+    tfTexCoord = aTexCoord;
+    tfJoints = vec4(0.0);
+    tfWeights = vec4(0.0);
+}
+` + "\x00"
+
+// skinCache holds a skinned mesh's vertices for one pose
+type skinCache struct {
+	VAO uint32
+	VBO uint32
+	// pose is the GLBRenderer.pose the vertices were captured at
+	pose uint64
+}
+
+// newSkinCacheProgram links the transform feedback skinning program
+func newSkinCacheProgram() (uint32, error) {
+	shader, err := compileShader(skinCacheVertexShaderSource, gl.VERTEX_SHADER)
+	if err != nil {
+		return 0, fmt.Errorf("skin cache shader: %w", err)
+	}
+	defer gl.DeleteShader(shader)
+
+	program := gl.CreateProgram()
+	gl.AttachShader(program, shader)
+	varyings, free := gl.Strs("tfPos\x00", "tfNormal\x00", "tfTexCoord\x00", "tfJoints\x00", "tfWeights\x00")
+	gl.TransformFeedbackVaryings(program, 5, varyings, gl.INTERLEAVED_ATTRIBS)
+	free()
+	gl.LinkProgram(program)
+
+	var status int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLength)
+		log := make([]byte, logLength)
+		gl.GetProgramInfoLog(program, logLength, nil, &log[0])
+		gl.DeleteProgram(program)
+		return 0, fmt.Errorf("skin cache program link: %s", string(log))
+	}
+	return program, nil
+}
+
+// cacheSkinnedMesh skins a mesh at the current pose into its cache,
+// creating the cache on first use. Bone matrices must already be computed.
+func (r *GLBRenderer) cacheSkinnedMesh(mesh *Mesh) {
+	if mesh.cache == nil {
+		mesh.cache = &skinCache{}
+		gl.GenVertexArrays(1, &mesh.cache.VAO)
+		gl.BindVertexArray(mesh.cache.VAO)
+		gl.GenBuffers(1, &mesh.cache.VBO)
+		gl.BindBuffer(gl.ARRAY_BUFFER, mesh.cache.VBO)
+		gl.BufferData(gl.ARRAY_BUFFER, int(mesh.Vertices)*16*4, nil, gl.DYNAMIC_COPY)
+		setVertexAttributes(goGL{})
+		if mesh.HasIndices {
+			gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, mesh.EBO)
+		}
+		gl.BindVertexArray(0)
+	}
+
+	gl.UseProgram(r.skinCacheProgram)
+	numJoints := min(len(r.Skins[mesh.SkinIndex].Joints), 128)
+	if numJoints > 0 {
+		gl.UniformMatrix4fv(r.skinCacheBonesLoc, int32(numJoints), false, &r.BoneMatrices[0][0])
+	}
+
+	gl.Enable(gl.RASTERIZER_DISCARD)
+	gl.BindVertexArray(mesh.VAO)
+	gl.BindBufferBase(gl.TRANSFORM_FEEDBACK_BUFFER, 0, mesh.cache.VBO)
+	gl.BeginTransformFeedback(gl.POINTS)
+	gl.DrawArrays(gl.POINTS, 0, mesh.Vertices)
+	gl.EndTransformFeedback()
+	gl.BindBufferBase(gl.TRANSFORM_FEEDBACK_BUFFER, 0, 0)
+	gl.Disable(gl.RASTERIZER_DISCARD)
+
+	mesh.cache.pose = r.pose
+	gl.UseProgram(r.ShaderProgram)
+}
+
+// deleteSkinCache frees a mesh's cached vertices
+func deleteSkinCache(mesh *Mesh) {
+	if mesh.cache == nil {
+		return
+	}
+	gl.DeleteVertexArrays(1, &mesh.cache.VAO)
+	gl.DeleteBuffers(1, &mesh.cache.VBO)
+	mesh.cache = nil
+}
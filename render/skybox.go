@@ -0,0 +1,119 @@
+//go:build !nogl
+
+package render
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const skyboxVertexShaderSource = `
+#version 410 core
+layout (location = 0) in vec2 aPos;
+
+out vec2 ClipPos;
+
+void main() {
+    ClipPos = aPos * 2.0 - 1.0;
+    gl_Position = vec4(ClipPos, 0.0, 1.0);
+}
+` + "\x00"
+
+const skyboxFragmentShaderSource = `
+#version 410 core
+out vec4 FragColor;
+
+in vec2 ClipPos;
+
+uniform sampler2D environment;
+// Inverse of the projection times the view's rotation
+uniform mat4 inverseViewProjection;
+
+const float PI = 3.14159265359;
+
+void main() {
+    vec4 far = inverseViewProjection * vec4(ClipPos, 1.0, 1.0);
+    vec3 dir = normalize(far.xyz / far.w);
+    vec2 uv = vec2(atan(dir.x, -dir.z) / (2.0 * PI) + 0.5, acos(clamp(dir.y, -1.0, 1.0)) / PI);
+    FragColor = vec4(texture(environment, uv).rgb, 1.0);
+}
+` + "\x00"
+
+// Skybox draws the -env environment behind the model, and carries the
+// diffuse light it gives the model
+type Skybox struct {
+	// Light is the environment's diffuse light for the model shader
+	Light EnvSH
+
+	program        uint32
+	texture        uint32
+	quadVAO        uint32
+	quadVBO        uint32
+	environmentLoc int32
+	inverseLoc     int32
+}
+
+// NewSkybox uploads the environment and works out its light
+func NewSkybox(env *EnvImage, programs *ProgramCache) (*Skybox, error) {
+	s := &Skybox{Light: projectEnvSH(env)}
+	program, err := newShaderProgram(programs, skyboxVertexShaderSource, skyboxFragmentShaderSource)
+	if err != nil {
+		return nil, fmt.Errorf("skybox shader: %w", err)
+	}
+	s.program = program
+	s.environmentLoc = gl.GetUniformLocation(program, gl.Str("environment\x00"))
+	s.inverseLoc = gl.GetUniformLocation(program, gl.Str("inverseViewProjection\x00"))
+
+	gl.GenTextures(1, &s.texture)
+	gl.BindTexture(gl.TEXTURE_2D, s.texture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.REPEAT)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGB16F, int32(env.Width), int32(env.Height), 0, gl.RGB, gl.FLOAT, gl.Ptr(env.Pix))
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+
+	quad := []float32{0, 0, 1, 0, 0, 1, 1, 1}
+	gl.GenVertexArrays(1, &s.quadVAO)
+	gl.BindVertexArray(s.quadVAO)
+	gl.GenBuffers(1, &s.quadVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, s.quadVBO)
+	gl.BufferData(gl.ARRAY_BUFFER, len(quad)*4, gl.Ptr(quad), gl.STATIC_DRAW)
+	gl.VertexAttribPointerWithOffset(0, 2, gl.FLOAT, false, 2*4, 0)
+	gl.EnableVertexAttribArray(0)
+	gl.BindVertexArray(0)
+	return s, nil
+}
+
+// Render fills the viewport with the environment as seen by the camera,
+// without touching the depth buffer
+func (s *Skybox) Render(projection, view mgl32.Mat4) {
+	inverse := projection.Mul4(view.Mat3().Mat4()).Inv()
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.CULL_FACE)
+	gl.DepthMask(false)
+	gl.UseProgram(s.program)
+	gl.UniformMatrix4fv(s.inverseLoc, 1, false, &inverse[0])
+	gl.Uniform1i(s.environmentLoc, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, s.texture)
+	gl.BindVertexArray(s.quadVAO)
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+
+	gl.BindVertexArray(0)
+	gl.DepthMask(true)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+}
+
+// Destroy releases the GL resources of the skybox
+func (s *Skybox) Destroy() {
+	gl.DeleteBuffers(1, &s.quadVBO)
+	gl.DeleteVertexArrays(1, &s.quadVAO)
+	gl.DeleteTextures(1, &s.texture)
+	gl.DeleteProgram(s.program)
+}
@@ -0,0 +1,68 @@
+// Package render draws the compositor's desktop: the 3D preview window with
+// the model the desktop is shown on, the model loading, and the GPU
+// compositing and effects. Everything that needs OpenGL is left out of
+// builds with the nogl tag, and the preview window of builds with nosdl.
+package render
+
+import (
+	"image"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// TextureSource is a picture the model's screen and the streams can show:
+// the composited Wayland desktop, or a video, camera or test card. Sources
+// are read on the render loop.
+type TextureSource interface {
+	// Frame returns the newest frame's RGBA rows and their stride, or no
+	// pixels before the first frame. The rows stay valid until the frame
+	// after next.
+	Frame() (pixels []byte, stride int)
+	// Size is the frame's size in pixels
+	Size() image.Point
+	// Damage counts the frames; it goes up whenever Frame would return a
+	// different picture, so a consumer can skip a frame it already has
+	Damage() uint64
+}
+
+// DesktopTextureSource is the composited Wayland desktop as a
+// TextureSource. The render loop publishes each frame once it is in the
+// desktop buffer.
+type DesktopTextureSource struct {
+	desktop *wayland.Desktop
+	damage  uint64
+}
+
+// NewDesktopTextureSource creates a source with no frame yet
+func NewDesktopTextureSource() *DesktopTextureSource {
+	return &DesktopTextureSource{}
+}
+
+// Publish makes desktop's buffer, which holds a newly composited frame,
+// the source's frame. Resizing swaps in another desktop, so it is passed
+// each time.
+func (s *DesktopTextureSource) Publish(desktop *wayland.Desktop) {
+	s.desktop = desktop
+	s.damage++
+}
+
+// Frame returns the desktop buffer
+func (s *DesktopTextureSource) Frame() ([]byte, int) {
+	if s.desktop == nil {
+		return nil, 0
+	}
+	return s.desktop.Buffer, s.desktop.Stride
+}
+
+// Size returns the desktop's size
+func (s *DesktopTextureSource) Size() image.Point {
+	if s.desktop == nil {
+		return image.Point{}
+	}
+	return image.Pt(s.desktop.Width, s.desktop.Height)
+}
+
+// Damage counts the published frames
+func (s *DesktopTextureSource) Damage() uint64 {
+	return s.damage
+}
@@ -0,0 +1,25 @@
+package render
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestDesktopTextureSource(t *testing.T) {
+	source := NewDesktopTextureSource()
+	if pixels, _ := source.Frame(); pixels != nil || source.Size() != (image.Point{}) {
+		t.Error("A source with nothing published should have no frame")
+	}
+	desktop := &wayland.Desktop{Width: 2, Height: 1, Stride: 8, Buffer: make([]byte, 8)}
+	source.Publish(desktop)
+	damage := source.Damage()
+	if pixels, stride := source.Frame(); len(pixels) != 8 || stride != 8 || source.Size() != image.Pt(2, 1) {
+		t.Errorf("Frame is %d bytes, stride %d, size %v", len(pixels), stride, source.Size())
+	}
+	source.Publish(desktop)
+	if source.Damage() == damage {
+		t.Error("Publishing should damage the source")
+	}
+}
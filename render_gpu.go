@@ -0,0 +1,85 @@
+package compositor
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// renderGPUs are the -render-gpu choices, by the environment variables that
+// make the GL driver pick that GPU for the preview window. Mesa reads
+// DRI_PRIME; NVIDIA's driver uses PRIME render offload.
+var renderGPUs = map[string][]string{
+	"default":    nil,
+	"integrated": {"DRI_PRIME=0"},
+	"discrete":   {"DRI_PRIME=1"},
+	"nvidia":     {"__NV_PRIME_RENDER_OFFLOAD=1", "__GLX_VENDOR_LIBRARY_NAME=nvidia"},
+}
+
+var (
+	renderGPUMu sync.Mutex
+	// clientEnv holds what selectRenderGPU overrode, so clients can be
+	// started with the environment the compositor was started with
+	clientEnv map[string]*string
+)
+
+// selectRenderGPU sets up the environment so the preview's GL context is
+// created on the named GPU. It must run before SDL loads the GL driver.
+// Clients keep the GPU they would have had, see clientEnviron.
+func selectRenderGPU(name string) error {
+	vars, ok := renderGPUs[name]
+	if !ok {
+		names := make([]string, 0, len(renderGPUs))
+		for n := range renderGPUs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown render GPU %q, want one of %s", name, strings.Join(names, ", "))
+	}
+
+	renderGPUMu.Lock()
+	defer renderGPUMu.Unlock()
+	for _, v := range vars {
+		key, value, _ := strings.Cut(v, "=")
+		if clientEnv == nil {
+			clientEnv = make(map[string]*string)
+		}
+		if _, saved := clientEnv[key]; !saved {
+			var previous *string
+			if old, ok := os.LookupEnv(key); ok {
+				previous = &old
+			}
+			clientEnv[key] = previous
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clientEnviron is the environment for launched clients and Xwayland: the
+// compositor's, without the GPU selection of -render-gpu, so clients and
+// their buffers stay on the GPU they would use anyway
+func clientEnviron() []string {
+	renderGPUMu.Lock()
+	defer renderGPUMu.Unlock()
+	env := os.Environ()
+	if len(clientEnv) == 0 {
+		return env
+	}
+	out := make([]string, 0, len(env))
+	for _, v := range env {
+		key, _, _ := strings.Cut(v, "=")
+		if previous, overridden := clientEnv[key]; overridden {
+			if previous != nil {
+				out = append(out, key+"="+*previous)
+			}
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,38 @@
+package compositor
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSelectRenderGPU(t *testing.T) {
+	t.Cleanup(func() { clientEnv = nil })
+	t.Setenv("DRI_PRIME", "2")
+	t.Setenv("__NV_PRIME_RENDER_OFFLOAD", "")
+	os.Unsetenv("__NV_PRIME_RENDER_OFFLOAD")
+
+	if err := selectRenderGPU("quantum"); err == nil {
+		t.Error("expected an error for an unknown GPU")
+	}
+	if err := selectRenderGPU("discrete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := selectRenderGPU("nvidia"); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("DRI_PRIME"); got != "1" {
+		t.Errorf("DRI_PRIME = %q, want 1", got)
+	}
+
+	env := clientEnviron()
+	if !slices.Contains(env, "DRI_PRIME=2") || slices.Contains(env, "DRI_PRIME=1") {
+		t.Error("clients should get the DRI_PRIME the compositor was started with")
+	}
+	for _, v := range env {
+		if strings.HasPrefix(v, "__NV_PRIME_RENDER_OFFLOAD=") {
+			t.Errorf("clients should not get %s", v)
+		}
+	}
+}
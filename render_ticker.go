@@ -0,0 +1,142 @@
+package compositor
+
+import (
+	"sync"
+	"time"
+)
+
+// renderIdleAfter is how long nothing has to happen before the render
+// loop slows to its idle rate
+const renderIdleAfter = 3 * time.Second
+
+// RenderTicker paces the render loop. It ticks at the frame rate while
+// anything happens: damage, input, clients asking for frames. Once
+// nothing has for renderIdleAfter it slows to the idle rate, and Wake
+// brings the next tick forward so a commit or a keypress is drawn without
+// waiting out an idle tick. Ticks never come faster than the frame rate,
+// and a tick the loop is too busy to take is dropped, as with time.Ticker.
+type RenderTicker struct {
+	// C delivers the ticks
+	C <-chan time.Time
+
+	c    chan time.Time
+	wake chan struct{}
+	stop chan struct{}
+	once sync.Once
+
+	mu      sync.Mutex
+	fps     int
+	idleFPS int
+	// active is when something last happened
+	active time.Time
+}
+
+// NewRenderTicker starts a ticker at fps, slowing to idleFPS while idle;
+// an idleFPS of zero or at least fps never slows down
+func NewRenderTicker(fps, idleFPS int) *RenderTicker {
+	c := make(chan time.Time, 1)
+	t := &RenderTicker{
+		C:       c,
+		c:       c,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		fps:     fps,
+		idleFPS: idleFPS,
+		active:  time.Now(),
+	}
+	go t.run()
+	return t
+}
+
+// SetFPS changes the frame rate
+func (t *RenderTicker) SetFPS(fps int) {
+	t.mu.Lock()
+	t.fps = fps
+	t.mu.Unlock()
+	t.signal()
+}
+
+// SetIdleFPS changes the idle rate, zero for none
+func (t *RenderTicker) SetIdleFPS(idleFPS int) {
+	t.mu.Lock()
+	t.idleFPS = idleFPS
+	t.mu.Unlock()
+	t.signal()
+}
+
+// Active records that something happened, keeping the full frame rate
+func (t *RenderTicker) Active(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active = now
+}
+
+// Wake records that something happened and ticks as soon as the frame
+// rate allows. It may be called from any goroutine.
+func (t *RenderTicker) Wake() {
+	t.Active(time.Now())
+	t.signal()
+}
+
+// Idle reports whether the ticker has slowed to its idle rate
+func (t *RenderTicker) Idle(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.idle(now)
+}
+
+// Stop stops the ticks
+func (t *RenderTicker) Stop() {
+	t.once.Do(func() {
+		close(t.stop)
+	})
+}
+
+func (t *RenderTicker) idle(now time.Time) bool {
+	return t.idleFPS > 0 && t.idleFPS < t.fps && now.Sub(t.active) >= renderIdleAfter
+}
+
+// intervals returns the shortest time between ticks and the time to the
+// next one
+func (t *RenderTicker) intervals(now time.Time) (least, next time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	least = frameInterval(t.fps)
+	if t.idle(now) {
+		return least, frameInterval(t.idleFPS)
+	}
+	return least, least
+}
+
+func (t *RenderTicker) signal() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (t *RenderTicker) run() {
+	last := time.Now()
+	_, next := t.intervals(last)
+	timer := time.NewTimer(next)
+	defer timer.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-t.wake:
+			// Tick at once unless that would beat the frame rate
+			now := time.Now()
+			least, _ := t.intervals(now)
+			timer.Reset(max(least-now.Sub(last), 0))
+		case now := <-timer.C:
+			select {
+			case t.c <- now:
+			default:
+			}
+			last = now
+			_, next := t.intervals(now)
+			timer.Reset(next)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package compositor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderTickerIdle(t *testing.T) {
+	ticker := NewRenderTicker(60, 5)
+	defer ticker.Stop()
+	now := time.Now()
+	if ticker.Idle(now) {
+		t.Fatal("Idle as soon as it started")
+	}
+	if !ticker.Idle(now.Add(renderIdleAfter)) {
+		t.Error("Still at the full rate after nothing happened")
+	}
+	if _, next := ticker.intervals(now.Add(renderIdleAfter)); next != 200*time.Millisecond {
+		t.Errorf("Expected idle ticks 200ms apart, got %v", next)
+	}
+	ticker.Active(now.Add(renderIdleAfter))
+	if ticker.Idle(now.Add(renderIdleAfter)) {
+		t.Error("Still idle after activity")
+	}
+
+	ticker.SetIdleFPS(0)
+	if ticker.Idle(now.Add(time.Hour)) {
+		t.Error("Idle with no idle rate")
+	}
+}
+
+func TestRenderTickerWake(t *testing.T) {
+	ticker := NewRenderTicker(50, 1)
+	defer ticker.Stop()
+	ticker.Active(time.Now().Add(-time.Minute))
+	// The tick set up at the full rate comes first, then the idle ones
+	select {
+	case <-ticker.C:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No tick")
+	}
+
+	start := time.Now()
+	ticker.Wake()
+	select {
+	case <-ticker.C:
+		if waited := time.Since(start); waited > 500*time.Millisecond {
+			t.Errorf("Woken tick took %v", waited)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wake did not tick")
+	}
+	if ticker.Idle(time.Now()) {
+		t.Error("Idle after waking")
+	}
+}
@@ -0,0 +1,827 @@
+//go:build !nogl && !nosdl
+
+package compositor
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Run parses the compositor's command line flags from c.Args and runs it
+// until Stop is called or it gets SIGINT or SIGTERM. It must be called
+// from the main goroutine with the OS thread locked, as SDL and OpenGL
+// require.
+func (c *Compositor) Run() error {
+	flags := flag.NewFlagSet("wayland-compositor", flag.ContinueOnError)
+	options := newRunOptions(flags)
+	if err := flags.Parse(c.Args); err != nil {
+		return err
+	}
+	s, err := newRunState(c, flags, options)
+	if err != nil {
+		return err
+	}
+
+	// As a hot spare, wait for the primary to fail before taking its
+	// display and port
+	if s.standby != nil {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		failed := s.standby.Wait(c.stop, signals)
+		signal.Stop(signals)
+		if !failed {
+			return nil
+		}
+	}
+
+	if features := BuiltFeatures(); !features.Full() {
+		log.Printf("Built with %s", features)
+	}
+	defer s.close()
+	if err := s.start(); err != nil {
+		return err
+	}
+	return s.loop()
+}
+
+// runState is the full compositor while Run runs it: its settings, what
+// start builds from them, and what the render loop keeps from frame to
+// frame. The control API's handlers run on the render loop too, so they
+// share it without locking but for the desktop, which mu guards.
+type runState struct {
+	c *Compositor
+	// flags are the command line flags options are bound to, which the
+	// control API reloads the config file into
+	flags    *flag.FlagSet
+	options  *runOptions
+	explicit map[string]bool
+
+	// Settings parsed from options
+	playlist          *render.Playlist
+	maxFPSRules       map[string]int
+	transitionRules   map[render.TransitionKind]render.TransitionEffect
+	screenTrailMode   render.TrailMode
+	postProcess       render.PostProcess
+	backlightSchedule BacklightSchedule
+	environment       *render.EnvImage
+	layoutMode        LayoutMode
+	workspaces        *Workspaces
+	standby           *Standby
+
+	// Serving and streaming
+	notifier      *SystemdNotifier
+	tracer        *WireTracer
+	screencopy    *Screencopy
+	viewers       runViewers
+	connectCode   *ConnectCode
+	sinks         []OutputSink
+	sceneRequests chan string
+	// expressionRequests are the expressions named on the -mqtt topic
+	expressionRequests chan string
+	events             *Events
+	runWeb
+
+	// The preview window. lostReason is set when the window or GL context
+	// has to be rebuilt, previewHidden while the window is minimized or
+	// hidden, when no GL work is done so the GPU can sleep, and outputOff
+	// while the output is turned off through the control API, when nothing
+	// is composited, drawn or streamed.
+	previewOptions      render.PreviewOptions
+	preview             *render.Preview
+	lostReason          string
+	lostSnapshot        render.SceneSnapshot
+	retryAt             time.Time
+	previewHidden       bool
+	outputPower         *OutputPower
+	outputOff           bool
+	suspend             *SuspendDetector
+	hotReload           *render.HotReload
+	switches            render.SwitchDetector
+	screensaver         *render.Screensaver
+	screenShaderToggles *render.ScreenShaderToggles
+	particleSystem      *render.ParticleSystem
+	syncLeader          *render.SyncLeader
+	syncFollower        *render.SyncFollower
+	backlight           *Backlight
+	// compositedOn is the GPU compositor the last frame was composited
+	// on, nil for the CPU
+	compositedOn *render.GLCompositor
+
+	// The clients and the desktop they are composited into
+	listener        *wayland.SocketListener
+	clients         *ClientList
+	mu              sync.Mutex
+	desktop         *wayland.Desktop
+	damage          *DesktopDamage
+	surfaces        *SurfaceSnapshots
+	visibility      *VisibilityTracker
+	bufferHints     *BufferHints
+	sendGuard       *SendGuard
+	framePacer      *FramePacer
+	toplevels       toplevelWatcher
+	xwayland        *Xwayland
+	xwm             *XWM
+	launchEnv       []string
+	activation      *ActivationTokens
+	announced       map[*wayland.Client]bool
+	desktopPipeline *DesktopPipeline
+	desktopSource   *render.DesktopTextureSource
+	// frameVersion counts the desktop frames composited, and bufferVersion
+	// is the one in the CPU buffer, so an unchanged frame is not read back
+	// again. Once there, it is published to desktopSource, which the
+	// model's texture and the stream take it from when it changed.
+	frameVersion, bufferVersion uint64
+	frameNumber                 uint64
+
+	// What is drawn over, under and around the clients' windows
+	sources         []DesktopSource
+	widgetLayer     *WidgetLayer
+	cellDecorations *CellDecorations
+	statsOverlay    *StatsOverlay
+	notifications   *Notifications
+	screenComposer  *ScreenComposer
+	surfaceCapture  *SurfaceCapture
+	screenAnalyzer  *ScreenAnalyzer
+	audioAnalyzer   *AudioAnalyzer
+	recorder        *Recorder
+
+	// Windows, input and pacing
+	layout          *LayoutEngine
+	windowRuleSet   *WindowRuleSet
+	decorations     *Decorations
+	windowStack     *WindowStack
+	windowList      *WindowList
+	pointerLock     *PointerLock
+	pointerCaptured bool
+	pen             PenState
+	kiosk           *Kiosk
+	shortcuts       *Shortcuts
+	idle            *render.IdleMonitor
+	renderTicker    *RenderTicker
+	powerLevel      PowerLevel
+	governor        *PowerGovernor
+	sigChan         chan os.Signal
+	frameCount      int
+	lastLog         time.Time
+
+	// cleanup is undone in reverse by close
+	cleanup []func()
+}
+
+// runViewers is what the render loop asks of the viewers' HTTP server,
+// which does nothing in builds without it
+type runViewers interface {
+	Resync()
+	SetQualityFloor(floor int)
+	SetPointerLock(locked bool)
+	StreamStats() []stream.ViewerStreamStats
+	WebSocketClientCount() int
+	ScreenshotPending() bool
+	ServeScreenshots(buffer []byte, width, height, stride int)
+	MJPEGDue(now time.Time) bool
+	PublishMJPEG(buffer []byte, width, height, stride int, now time.Time)
+}
+
+// newRunState parses and checks the settings in options, after reading
+// the -config file into flags
+func newRunState(c *Compositor, flags *flag.FlagSet, options *runOptions) (*runState, error) {
+	s := &runState{c: c, flags: flags, options: options}
+	var err error
+
+	// Fill in the settings not given on the command line from the config
+	// file. The control API can reload it later.
+	s.explicit = explicitFlags(flags)
+	if s.options.configPath != "" {
+		config, err := loadConfigFile(s.options.configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		if _, err := applyConfig(flags, config, s.explicit); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", s.options.configPath, err)
+		}
+	}
+
+	// Cycle through several models, starting with the first one
+	if s.options.playlistSource != "" {
+		s.playlist, err = render.NewPlaylist(s.options.playlistSource, s.options.playlistInterval, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load playlist: %w", err)
+		}
+		if s.options.glbFile == "" {
+			s.options.glbFile = s.playlist.Current()
+		}
+	}
+
+	if s.options.glbFile == "" && !s.options.flat {
+		s.options.glbFile = render.BuiltinModelPrefix + "plane"
+		log.Printf("No -model given, showing %s", s.options.glbFile)
+	}
+
+	s.maxFPSRules, err = parseMaxFPSRules(s.options.maxFPS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -max-fps: %w", err)
+	}
+
+	s.transitionRules, err = render.ParseTransitionRules(s.options.transitions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -transitions: %w", err)
+	}
+
+	s.screenTrailMode, err = render.ParseTrailMode(s.options.screenTrailModeName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -screen-trail-mode: %w", err)
+	}
+
+	s.postProcess = render.PostProcess{MSAA: s.options.postMSAA, FXAA: s.options.postFXAA, Bloom: s.options.postBloom, Tonemap: s.options.postTonemap, Vignette: s.options.postVignette}
+	if err := s.postProcess.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid -post-msaa: %w", err)
+	}
+
+	if err := checkBacklightLevel(s.options.backlightLevel); err != nil {
+		return nil, fmt.Errorf("invalid -backlight: %w", err)
+	}
+	s.backlightSchedule, err = parseBacklightSchedule(s.options.backlightSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -backlight-schedule: %w", err)
+	}
+
+	// Decoded once, so a rebuilt preview only uploads it again
+	if s.options.envPath != "" && !s.options.flat {
+		s.environment, err = render.LoadEnvironment(s.options.envPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load -env: %w", err)
+		}
+	}
+
+	s.layoutMode, err = parseLayoutMode(s.options.layoutName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -layout: %w", err)
+	}
+	workspaceRegions, err := parseWorkspaceRegions(s.options.workspaceRegions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -workspace-regions: %w", err)
+	}
+	s.workspaces, err = NewWorkspaces(s.options.workspaceCount, workspaceRegions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -workspaces: %w", err)
+	}
+
+	if s.options.standbyURL != "" {
+		s.standby, err = NewStandby(s.options.standbyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -standby: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// start builds what the compositor runs on: the HTTP server, the preview
+// window, the Wayland socket and everything the render loop drives. What
+// it has built is torn down by close, also when it fails part way.
+func (s *runState) start() error {
+	// Start HTTP server with WebSocket support
+	// Under systemd, serve the sockets it passed and tell it how we are doing
+	sockets, err := systemdSockets()
+	if err != nil {
+		return fmt.Errorf("invalid socket activation: %w", err)
+	}
+	s.notifier = NewSystemdNotifier()
+	s.onClose(s.notifier.Close)
+
+	if s.options.wlDebug || s.options.wlDump != "" {
+		s.tracer, err = NewWireTracer(s.options.wlDebug, s.options.wlDump)
+		if err != nil {
+			return fmt.Errorf("invalid -wl-dump: %w", err)
+		}
+		s.onClose(func() { s.tracer.Close() })
+	}
+	if s.options.screencopy {
+		s.screencopy = NewScreencopy()
+	}
+
+	if err := s.startWeb(sockets); err != nil {
+		return err
+	}
+	// The viewers, the -output sinks and any the embedding program added
+	// all take each frame
+	outputs, err := stream.ParseOutputSinks(s.options.outputSpecs, s.options.fps)
+	if err != nil {
+		return fmt.Errorf("invalid -output: %w", err)
+	}
+	s.sinks = slices.Concat(s.viewerSinks(), outputs, s.c.sinks)
+	s.onClose(func() {
+		for _, sink := range s.sinks {
+			if err := sink.Close(); err != nil {
+				log.Printf("Failed to close output: %v", err)
+			}
+		}
+	})
+
+	// Scenes are switched on the render loop, which owns the GL context
+	s.sceneRequests = make(chan string, 1)
+
+	// Pick the GPU before SDL loads the GL driver
+	if err := selectRenderGPU(s.options.renderGPU); err != nil {
+		return fmt.Errorf("invalid -render-gpu: %w", err)
+	}
+
+	// Initialize SDL2 with OpenGL
+	if err := sdl.Init(sdl.INIT_VIDEO | sdl.INIT_EVENTS); err != nil {
+		return fmt.Errorf("failed to initialize SDL2: %w", err)
+	}
+	s.onClose(sdl.Quit)
+
+	// Create the preview window, its GL context, and the model renderer
+	programCache, err := render.NewProgramCache(s.options.shaderCache)
+	if err != nil {
+		log.Printf("Not caching shaders: %v", err)
+	}
+	s.previewOptions = render.PreviewOptions{
+		ModelPath:          s.options.glbFile,
+		Scene:              s.options.scene,
+		TriangleBudget:     s.options.lodBudget,
+		DesktopWidth:       800,
+		DesktopHeight:      600,
+		GPUComposite:       s.options.gpuComposite,
+		Transitions:        s.transitionRules,
+		TransitionDuration: s.options.transitionDuration,
+		ScreenTrail:        s.options.screenTrail,
+		ScreenTrailMode:    s.screenTrailMode,
+		ScreenShaders:      s.options.screenShaders,
+		ShaderDir:          s.options.shaderDir,
+		Environment:        s.environment,
+		PostProcess:        s.postProcess,
+		Flat:               s.options.flat,
+		ProgramCache:       programCache,
+	}
+	s.preview, err = render.NewPreview(s.previewOptions)
+	if err != nil {
+		return fmt.Errorf("failed to create preview window: %w", err)
+	}
+	s.onClose(func() {
+		if s.preview != nil {
+			s.preview.Destroy()
+		}
+	})
+
+	// Rebuild the preview and resync the streams after the host slept
+	s.suspend = NewSuspendDetector(time.Now())
+	s.outputPower = NewOutputPower(time.Now())
+
+	// Play the "Bark" animation on loop
+	if !s.options.flat {
+		if err := s.preview.Renderer.PlayAnimation("Bark", true); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	// Reload the model and shaders as they are edited
+	if s.options.watch && !s.options.flat {
+		s.hotReload = render.NewHotReload(s.options.glbFile, s.options.shaderDir)
+	}
+
+	// Initialize arguments. Passing an empty string will let the library
+	// automatically choose a display name (e.g., wayland-0, wayland-1).
+	// A socket left by a primary that failed is replaced.
+	args := &Args{DisplayName: s.options.displayName}
+
+	// Create the socket listener, or take the one systemd passed.
+	if sockets.Wayland != nil {
+		s.listener = systemdWaylandListener(sockets.Wayland)
+	} else {
+		s.listener, err = wayland.MakeSocketListener(args)
+		if err != nil {
+			return fmt.Errorf("failed to create socket listener: %w", err)
+		}
+	}
+
+	fmt.Printf("Wayland Compositor started.\n")
+	fmt.Printf("Display: %s\n", s.listener.WaylandDisplayName)
+	fmt.Printf("Socket Path: %s\n", s.listener.Listener.Addr())
+	fmt.Printf("Set WAYLAND_DISPLAY=%s to connect clients.\n", s.listener.WaylandDisplayName)
+
+	// Start the listener loop in a background goroutine.
+	go func() {
+		if err := acceptClients(s.listener); err != nil {
+			log.Printf("Listener loop error: %v", err)
+		}
+	}()
+
+	// Track connected clients. mu guards the desktop, which the control
+	// API can replace.
+	s.clients = NewClientList()
+
+	// Typed events for code built on top of the compositor
+	s.events = NewEvents()
+
+	// Particle bursts fired by events or the control API
+	s.particleSystem = render.NewParticleSystem(s.options.particles)
+	s.events.OnClientConnected(func(ClientConnectedEvent) { s.particleSystem.FireEvent("client_connected", time.Now()) })
+	s.events.OnToplevelMapped(func(ToplevelMappedEvent) { s.particleSystem.FireEvent("toplevel_mapped", time.Now()) })
+	s.events.OnToplevelUnmapped(func(ToplevelUnmappedEvent) { s.particleSystem.FireEvent("toplevel_unmapped", time.Now()) })
+	s.events.OnClientDisconnected(func(ClientDisconnectedEvent) { s.particleSystem.FireEvent("client_disconnected", time.Now()) })
+	s.events.OnViewerJoined(func(ViewerJoinedEvent) { s.particleSystem.FireEvent("viewer_joined", time.Now()) })
+	s.events.OnPreviewRecovered(func(PreviewRecoveredEvent) { s.particleSystem.FireEvent("preview_recovered", time.Now()) })
+
+	// Clock, date and feed widgets drawn into the desktop
+	s.widgetLayer = NewWidgetLayer(s.options.widgets)
+	s.onClose(s.widgetLayer.Close)
+
+	// Keep a frozen client from stalling event delivery to the others.
+	s.sendGuard = NewSendGuard(s.options.clientQueue, s.options.clientTimeout)
+
+	// Dim the model and stop streaming when nobody is using the session.
+	s.idle = render.NewIdleMonitor(s.options.idleTimeout, time.Now())
+
+	// Render loop ticker, paced to the requested frame rate and slowed
+	// while nothing changes; input and client frame requests wake it
+	s.renderTicker = NewRenderTicker(s.options.fps, s.options.idleFPS)
+	s.onClose(s.renderTicker.Stop)
+	s.screensaver = &render.Screensaver{
+		Brightness: float32(s.options.idleBrightness),
+		Animation:  s.options.screensaverAnimation,
+	}
+
+	// The desktop's average color and brightness, for effects that follow it
+	s.screenAnalyzer = NewScreenAnalyzer()
+
+	// Play in lockstep with the other screens of an installation
+	if s.options.syncLead != "" && s.options.syncFollow != "" {
+		return errors.New("-sync-lead and -sync-follow cannot be used together")
+	}
+	if s.options.syncLead != "" {
+		s.syncLeader, err = render.NewSyncLeader(s.options.syncLead)
+		if err != nil {
+			return fmt.Errorf("invalid -sync-lead: %w", err)
+		}
+		s.onClose(func() { s.syncLeader.Close() })
+	}
+	if s.options.syncFollow != "" {
+		s.syncFollower, err = render.NewSyncFollower(s.options.syncFollow)
+		if err != nil {
+			return fmt.Errorf("invalid -sync-follow: %w", err)
+		}
+		s.onClose(func() { s.syncFollower.Close() })
+	}
+
+	// Show a test card, video or camera, and another compositor's desktop, under
+	// our own clients, bottom first
+	source, err := NewDesktopSource(s.options.sourceSpec, image.Pt(int(s.previewOptions.DesktopWidth), int(s.previewOptions.DesktopHeight)))
+	if err != nil {
+		return fmt.Errorf("invalid -source: %w", err)
+	}
+	if source != nil {
+		s.onClose(source.Close)
+		s.sources = append(s.sources, source)
+		log.Printf("Showing %s under the clients", s.options.sourceSpec)
+	}
+	if s.options.mirrorDisplay != "" {
+		if s.options.mirrorFPS <= 0 {
+			return errors.New("-mirror-fps must be positive")
+		}
+		mirror, err := NewMirror(s.options.mirrorDisplay, time.Second/time.Duration(s.options.mirrorFPS))
+		if err != nil {
+			return fmt.Errorf("invalid -mirror: %w", err)
+		}
+		s.onClose(mirror.Close)
+		s.sources = append(s.sources, mirror)
+		log.Printf("Mirroring the first output of %s", s.options.mirrorDisplay)
+	}
+
+	// Record the frames and the input sent to the clients, for -replay
+	if s.options.recordPath != "" {
+		s.recorder, err = NewRecorder(s.options.recordPath)
+		if err != nil {
+			return fmt.Errorf("invalid -record: %w", err)
+		}
+		s.onClose(func() {
+			if err := s.recorder.Close(); err != nil {
+				log.Printf("Recording %s failed: %v", s.options.recordPath, err)
+			}
+		})
+		if s.options.recordPaused {
+			s.recorder.SetPaused(true, time.Now())
+			log.Printf("Recording to %s once started", s.options.recordPath)
+		} else {
+			log.Printf("Recording to %s", s.options.recordPath)
+		}
+	}
+
+	// The clients' audio, for effects that follow the music
+	s.audioAnalyzer = NewAudioAnalyzer(s.options.audioRate)
+	if s.options.audioCapture != "" {
+		go captureAudio(s.options.audioCapture, s.audioAnalyzer)
+	}
+
+	// Arrange windows by the chosen layout, switched with Super+Space
+	s.layout, err = NewLayoutEngine(s.layoutMode, int(s.previewOptions.DesktopWidth), int(s.previewOptions.DesktopHeight), s.options.layoutFile)
+	if err != nil {
+		return fmt.Errorf("invalid -layout-file: %w", err)
+	}
+	// Window rules place, float and stack windows by app id and title
+	s.windowRuleSet = NewWindowRuleSet(s.options.windowRules)
+	s.workspaces.Rules = s.windowRuleSet
+	s.layout.Workspaces = s.workspaces
+	s.layout.Rules = s.windowRuleSet
+	s.layout.SetDecorated(s.options.cellLabels)
+	// Apps ask whether to draw their own decorations; rules may decide
+	s.decorations = NewDecorations(s.windowRuleSet)
+	s.layout.Decorations = s.decorations
+	s.cellDecorations = NewCellDecorations()
+
+	// Keep the pointer in the top window for games, toggled with Super+G
+	s.pointerLock = NewPointerLock(int(s.previewOptions.DesktopWidth), int(s.previewOptions.DesktopHeight))
+	s.statsOverlay = NewStatsOverlay(s.options.statsOverlayShown)
+	// Banners flashed over the desktop by the compositor and the control API
+	s.notifications, err = NewNotifications(s.options.notificationAnchor, s.options.notificationDuration)
+	if err != nil {
+		return fmt.Errorf("invalid notification settings: %w", err)
+	}
+	s.notifications.Wake = s.renderTicker.Wake
+	if s.screencopy != nil {
+		s.screencopy.Wake = s.renderTicker.Wake
+	}
+	if s.recorder != nil && !s.options.recordPaused {
+		s.notify("Recording started")
+	}
+	// One app fills the desktop until the escape chord is pressed
+	if s.options.kioskCommand != "" {
+		s.kiosk = NewKiosk(s.options.kioskCommand, s.options.kioskEscape, s.options.kioskCursorTimeout, s.layout, time.Now())
+	}
+	s.shortcuts = &Shortcuts{Layout: s.layout, Workspaces: s.workspaces, PointerLock: s.pointerLock, StatsOverlay: s.statsOverlay, Recorder: s.recorder, Kiosk: s.kiosk, Notify: s.notify}
+	s.shortcuts.SetBindings(s.options.keyBindings)
+
+	// Frame callbacks are held until the render loop has composited a frame.
+	s.framePacer = NewFramePacer()
+	for appID, limit := range s.maxFPSRules {
+		s.framePacer.SetMaxFPS(appID, limit)
+	}
+
+	// Run X11 apps through a rootless Xwayland with our own window manager
+	if s.options.xwaylandBinary != "" {
+		s.xwayland, err = StartXwayland(s.options.xwaylandBinary)
+		if err != nil {
+			return fmt.Errorf("failed to start Xwayland: %w", err)
+		}
+		s.onClose(s.xwayland.Close)
+
+		s.clients.Add(s.xwayland.Client)
+		go s.serve(s.xwayland.Client)
+
+		if err := s.xwayland.Ready(); err != nil {
+			return fmt.Errorf("failed to start Xwayland: %w", err)
+		}
+		s.xwm = s.xwayland.WM
+		fmt.Printf("Set DISPLAY=%s to connect X11 clients.\n", s.xwayland.Display)
+	}
+
+	s.handleViewerInput()
+
+	// Play a recording back on top of the other sources, once the input
+	// handlers it may replay into are set
+	if s.options.replayPath != "" {
+		var inject func(message []byte)
+		if s.options.replayInput {
+			if inject = s.viewerInput(); inject == nil {
+				return errors.New("-replay-input needs the viewers' input handling, which this build leaves out (built with noweb)")
+			}
+		}
+		replayer, err := NewReplayer(s.options.replayPath, inject)
+		if err != nil {
+			return fmt.Errorf("invalid -replay: %w", err)
+		}
+		s.onClose(replayer.Close)
+		s.sources = append(s.sources, replayer)
+		log.Printf("Replaying %s under the clients", s.options.replayPath)
+	}
+
+	// Draw into the terminal too, once the input handlers its keys and
+	// mouse go to are set
+	if s.options.terminal {
+		inject := s.viewerInput()
+		if inject == nil {
+			log.Println("The terminal's keys and mouse go nowhere: this build has no viewer input handling (built with noweb)")
+			inject = func([]byte) {}
+		}
+		terminalSink, err := NewTerminalSink(inject)
+		if err != nil {
+			return fmt.Errorf("invalid -terminal: %w", err)
+		}
+		s.sinks = append(s.sinks, terminalSink)
+	}
+
+	// Accept new client connections.
+	go func() {
+		for conn := range s.listener.OnConnection {
+			if s.options.maxClients > 0 && s.clients.Len() >= s.options.maxClients {
+				rejectClient(conn, s.options.maxClients)
+				continue
+			}
+			log.Printf("New client connection accepted.")
+			traced, err := s.tracer.Wrap(conn)
+			if err != nil {
+				log.Printf("Failed to trace client: %v", err)
+				conn.Close()
+				continue
+			}
+			served, err := s.screencopy.Wrap(traced)
+			if err != nil {
+				log.Printf("Failed to proxy client: %v", err)
+				traced.Close()
+				continue
+			}
+			client := wayland.MakeClient(served)
+
+			s.clients.Add(client)
+			s.renderTicker.Wake()
+			go s.serve(client)
+		}
+	}()
+
+	// Create a desktop for compositing. It starts at 800x600 and can be
+	// resized through the control API; clients are told to fill it.
+	s.desktop = wayland.MakeDesktop(
+		wayland.Size{Width: uint32(s.previewOptions.DesktopWidth), Height: uint32(s.previewOptions.DesktopHeight)},
+		false,        // willShowAppRightAtStartup
+		createIcon(), // icon data
+	)
+	wayland.VirtualMonitorSize = wayland.PixelSize{Width: wayland.Pixels(s.desktop.Width), Height: wayland.Pixels(s.desktop.Height)}
+	// The desktop is only composited again when it would look different
+	s.damage = NewDesktopDamage()
+
+	// Clients' surfaces are read under their locks each frame, and
+	// composited from copies of their buffers
+	s.surfaces = NewSurfaceSnapshots()
+	// Track which surfaces are hidden and hint clients accordingly.
+	s.visibility = NewVisibilityTracker(s.desktop.Width, s.desktop.Height)
+	s.bufferHints = NewBufferHints(int32(s.options.bufferScale))
+
+	// Setup signal handling for graceful shutdown.
+	s.sigChan = make(chan os.Signal, 1)
+	signal.Notify(s.sigChan, syscall.SIGINT, syscall.SIGTERM)
+	s.onClose(func() { signal.Stop(s.sigChan) })
+
+	// Environment for the apps we launch
+	s.launchEnv = []string{"WAYLAND_DISPLAY=" + s.listener.WaylandDisplayName}
+	if s.xwayland != nil {
+		s.launchEnv = append(s.launchEnv, "DISPLAY="+s.xwayland.Display)
+	}
+
+	// Launch Chrome with the Wayland display
+	go func() {
+		cmd := exec.Command("google-chrome")
+		cmd.Env = append(clientEnviron(), s.launchEnv...)
+		if err := cmd.Start(); err != nil {
+			log.Printf("Failed to launch Chrome: %v", err)
+		}
+	}()
+	for _, command := range s.options.launch {
+		pid, err := launchCommand(command, s.launchEnv)
+		if err != nil {
+			log.Printf("Failed to launch %q: %v", command, err)
+			continue
+		}
+		log.Printf("Launched %q (pid %d)", command, pid)
+	}
+	if s.kiosk != nil {
+		go s.kiosk.Run(s.launchEnv)
+	}
+
+	// Hold back when the host is hot or on battery. The sensors are read
+	// off the render loop; it applies the changes.
+	s.powerLevel = PowerFull
+	if s.options.powerGovernor {
+		s.governor = NewPowerGovernor(s.options.thermalLimit)
+		go func() {
+			for {
+				s.governor.Observe(readPowerState("/sys"), time.Now())
+				time.Sleep(powerPollInterval)
+			}
+		}()
+	}
+
+	// Windows raised through the control API
+	s.windowStack = NewWindowStack()
+	s.windowStack.Rules = s.windowRuleSet
+	// Windows listed to viewers
+	s.windowList = NewWindowList()
+
+	// Windows mapped with an activation token are raised
+	s.activation = NewActivationTokens()
+	// A client's first window announces it
+	s.announced = make(map[*wayland.Client]bool)
+	s.events.OnToplevelMapped(func(e ToplevelMappedEvent) {
+		if s.announced[e.Client] {
+			return
+		}
+		s.announced[e.Client] = true
+		name := e.AppID
+		if name == "" {
+			name = e.Title
+		}
+		if name != "" {
+			s.notify(fmt.Sprintf("Client %s connected", name))
+		}
+	})
+	s.events.OnClientDisconnected(func(e ClientDisconnectedEvent) { delete(s.announced, e.Client) })
+
+	s.events.OnToplevelMapped(func(e ToplevelMappedEvent) {
+		if _, ok := s.activation.Activate(clientPID(e.Client), e.AppID, time.Now()); ok {
+			s.windowStack.Raise(e.Client, e.SurfaceID)
+			log.Printf("Activated %q", e.AppID)
+		}
+	})
+
+	// Screen shaders are switched on and off without recompiling
+	s.screenShaderToggles = render.NewScreenShaderToggles(s.options.screenShaders)
+
+	// The model's screen can show several sources side by side in place of
+	// the desktop alone
+	s.screenComposer = NewScreenComposer()
+	s.onClose(s.screenComposer.Close)
+	if err := s.screenComposer.SetLayout(s.options.screenLayout.Root, image.Pt(int(s.previewOptions.DesktopWidth), int(s.previewOptions.DesktopHeight))); err != nil {
+		return fmt.Errorf("invalid -screen-layout: %w", err)
+	}
+
+	// Apps' windows can be drawn on meshes of their own, e.g. a video on
+	// the TV in the room while the screen shows the desktop
+	s.surfaceCapture = NewSurfaceCapture(s.options.surfaceTextures)
+
+	// There is no panel to drive, so the backlight dims the preview window
+	s.backlight = NewBacklight(s.options.backlightLevel, s.backlightSchedule)
+
+	// The /api/v1 control surface, see handleControlAPI
+	s.startControlAPI()
+
+	// Show the expressions named on an MQTT topic. They are shown on the
+	// render loop, like scenes.
+	s.expressionRequests = make(chan string, 8)
+	if s.options.mqttBroker != "" {
+		subscriber, err := NewMQTTSubscriber(s.options.mqttBroker, s.options.mqttTopic, func(topic string, payload []byte) {
+			name := strings.TrimSpace(string(payload))
+			select {
+			case s.expressionRequests <- name:
+				s.renderTicker.Wake()
+			default:
+				log.Printf("MQTT expression %q dropped, the render loop is busy", name)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("invalid -mqtt: %w", err)
+		}
+		go subscriber.Run()
+	}
+
+	// Without the GPU compositor the desktop is composited off the render
+	// loop
+	s.desktopPipeline = NewDesktopPipeline()
+	s.onClose(s.desktopPipeline.Close)
+	s.desktopSource = render.NewDesktopTextureSource()
+	return nil
+}
+
+// onClose has close call cleanup, before what was set up earlier
+func (s *runState) onClose(cleanup func()) {
+	s.cleanup = append(s.cleanup, cleanup)
+}
+
+// close tears down what start built, newest first
+func (s *runState) close() {
+	for i := len(s.cleanup) - 1; i >= 0; i-- {
+		s.cleanup[i]()
+	}
+}
+
+// notify flashes a banner over the desktop, unless -notifications is off
+func (s *runState) notify(text string) {
+	if s.options.notices {
+		s.notifications.Post(text, "", 0, time.Now())
+	}
+}
+
+// serve serves a client, handling its frame callbacks to know when it
+// wants to redraw. A client whose request panics is disconnected on its
+// own.
+func (s *runState) serve(client *wayland.Client) {
+	runClient(s.clients, client, func(callbackID protocols.ObjectID[protocols.WlCallback]) {
+		s.framePacer.Queue(client, callbackID)
+		s.renderTicker.Wake()
+	})
+}
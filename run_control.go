@@ -0,0 +1,888 @@
+//go:build !nogl && !nosdl && !noweb
+
+package compositor
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// handleControlAPI serves the /api/v1 control surface for dashboards and
+// scripts. Its handlers run on the render loop, so they may touch
+// everything it owns.
+func (s *runState) handleControlAPI() {
+	s.control.Handle(s.httpServer, "GET /api/v1/sessions", func(r *http.Request) (any, error) {
+		return s.sessions.List(), nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/sessions", func(r *http.Request) (any, error) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		width, height := s.desktop.Width, s.desktop.Height
+		s.mu.Unlock()
+		session, err := s.sessions.Create(req.Name, width, height)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		return session.Info(), nil
+	})
+
+	s.control.Handle(s.httpServer, "DELETE /api/v1/sessions/{name}", func(r *http.Request) (any, error) {
+		name := r.PathValue("name")
+		if !s.sessions.Destroy(name) {
+			return nil, notFound("no session %q", name)
+		}
+		return map[string]string{"destroyed": name}, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/clients", func(r *http.Request) (any, error) {
+		return s.control.ListClients(s.clients.Snapshot()), nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/clients/{client}/toplevels/{toplevel}/focus", func(r *http.Request) (any, error) {
+		c, id, err := s.control.Toplevel(r, s.clients.Snapshot())
+		if err != nil {
+			return nil, err
+		}
+		surfaceID := c.GetSurfaceIDFromRole(protocols.AnyObjectID(id))
+		if surfaceID == nil {
+			return nil, notFound("toplevel %d has no surface", id)
+		}
+		s.windowStack.Raise(c, *surfaceID)
+		return nil, nil
+	})
+
+	// Viewers with a taskbar focus and close windows like the API does
+	s.httpServer.SetWindowActionHandler(func(action string, clientID int, toplevelID uint32) {
+		s.control.Queue(func() {
+			c, id, err := s.control.FindToplevel(clientID, toplevelID, s.clients.Snapshot())
+			if err != nil {
+				return
+			}
+			switch action {
+			case "focus":
+				if surfaceID := c.GetSurfaceIDFromRole(protocols.AnyObjectID(id)); surfaceID != nil {
+					s.windowStack.Raise(c, *surfaceID)
+				}
+			case "close":
+				protocols.XdgToplevel_close(c, id)
+			}
+		})
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/activation-tokens", func(r *http.Request) (any, error) {
+		var req struct {
+			AppID string `json:"app_id"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		token, err := s.activation.Issue(req.AppID, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"token": token, "expires_in": activationTimeout.Seconds()}, nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/clients/{client}/toplevels/{toplevel}/move", func(r *http.Request) (any, error) {
+		var req struct {
+			X int `json:"x"`
+			Y int `json:"y"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		c, id, err := s.control.Toplevel(r, s.clients.Snapshot())
+		if err != nil {
+			return nil, err
+		}
+		surfaceID := c.GetSurfaceIDFromRole(protocols.AnyObjectID(id))
+		if surfaceID == nil {
+			return nil, notFound("toplevel %d has no surface", id)
+		}
+		if err := s.layout.Move(c, *surfaceID, req.X, req.Y); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		return nil, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/layout", func(r *http.Request) (any, error) {
+		return map[string]any{"mode": s.layout.Mode(), "modes": layoutModes, "labels": s.layout.Decorated()}, nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/layout", func(r *http.Request) (any, error) {
+		var req struct {
+			Mode   string `json:"mode"`
+			Labels *bool  `json:"labels"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Mode != "" || req.Labels == nil {
+			mode, err := parseLayoutMode(req.Mode)
+			if err != nil {
+				return nil, badRequest("%v", err)
+			}
+			s.layout.SetMode(mode)
+		}
+		if req.Labels != nil {
+			s.layout.SetDecorated(*req.Labels)
+		}
+		return map[string]any{"mode": s.layout.Mode(), "labels": s.layout.Decorated()}, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/keybindings", func(r *http.Request) (any, error) {
+		return s.shortcuts.Bindings().List(), nil
+	})
+
+	if s.kiosk != nil {
+		s.control.Handle(s.httpServer, "GET /api/v1/kiosk", func(r *http.Request) (any, error) {
+			return s.kiosk.Info(), nil
+		})
+	}
+
+	s.control.Handle(s.httpServer, "GET /api/v1/window-rules", func(r *http.Request) (any, error) {
+		return s.windowRuleSet.Rules(), nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/workspaces", func(r *http.Request) (any, error) {
+		return map[string]any{"current": s.workspaces.Current(), "count": s.workspaces.Count(), "regions": s.workspaces.Regions()}, nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/workspaces/{workspace}", func(r *http.Request) (any, error) {
+		n, err := strconv.Atoi(r.PathValue("workspace"))
+		if err != nil {
+			return nil, badRequest("invalid workspace %q", r.PathValue("workspace"))
+		}
+		if err := s.workspaces.Switch(n); err != nil {
+			return nil, notFound("%v", err)
+		}
+		return map[string]any{"current": n}, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/pen", func(r *http.Request) (any, error) {
+		info, ok := s.pen.Info()
+		if !ok {
+			return nil, notFound("no pen has been used")
+		}
+		return info, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/pointer-lock", func(r *http.Request) (any, error) {
+		return map[string]any{"locked": s.pointerLock.Locked()}, nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/pointer-lock", func(r *http.Request) (any, error) {
+		var req struct {
+			Locked bool `json:"locked"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		s.pointerLock.Set(req.Locked)
+		return map[string]any{"locked": req.Locked}, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/stats-overlay", func(r *http.Request) (any, error) {
+		return map[string]any{"enabled": s.statsOverlay.Enabled()}, nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/stats-overlay", func(r *http.Request) (any, error) {
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		s.statsOverlay.SetEnabled(req.Enabled)
+		return map[string]any{"enabled": req.Enabled}, nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/clients/{client}/toplevels/{toplevel}/workspace", func(r *http.Request) (any, error) {
+		var req struct {
+			Workspace int `json:"workspace"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		c, id, err := s.control.Toplevel(r, s.clients.Snapshot())
+		if err != nil {
+			return nil, err
+		}
+		surfaceID := c.GetSurfaceIDFromRole(protocols.AnyObjectID(id))
+		if surfaceID == nil {
+			return nil, notFound("toplevel %d has no surface", id)
+		}
+		if err := s.workspaces.Move(surfaceKey{client: c, id: *surfaceID}, req.Workspace); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		return nil, nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/clients/{client}/toplevels/{toplevel}/close", func(r *http.Request) (any, error) {
+		c, id, err := s.control.Toplevel(r, s.clients.Snapshot())
+		if err != nil {
+			return nil, err
+		}
+		protocols.XdgToplevel_close(c, id)
+		return nil, nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/clients/{client}/toplevels/{toplevel}/resize", func(r *http.Request) (any, error) {
+		var req struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Width <= 0 || req.Height <= 0 {
+			return nil, badRequest("width and height must be positive")
+		}
+		c, id, err := s.control.Toplevel(r, s.clients.Snapshot())
+		if err != nil {
+			return nil, err
+		}
+		return nil, configureToplevel(c, id, req.Width, req.Height, false)
+	})
+
+	// The model's animation, changed on the live renderer
+	s.control.Handle(s.httpServer, "GET /api/v1/animation", func(r *http.Request) (any, error) {
+		live, err := s.livePreview()
+		if err != nil {
+			return nil, err
+		}
+		return s.animationState(live.Renderer), nil
+	})
+	s.control.Handle(s.httpServer, "POST /api/v1/animation", func(r *http.Request) (any, error) {
+		req := struct {
+			Name   string `json:"name"`
+			Loop   *bool  `json:"loop"`
+			Paused *bool  `json:"paused"`
+		}{}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		live, err := s.livePreview()
+		if err != nil {
+			return nil, err
+		}
+		loop := req.Loop == nil || *req.Loop
+		if req.Name != "" {
+			if err := live.Renderer.PlayAnimation(req.Name, loop); err != nil {
+				return nil, badRequest("%v", err)
+			}
+		} else if req.Paused == nil {
+			live.Renderer.StopAnimation()
+		}
+		if req.Paused != nil {
+			if *req.Paused {
+				live.Renderer.PauseAnimation()
+			} else {
+				live.Renderer.ResumeAnimation()
+			}
+		}
+		return s.animationState(live.Renderer), nil
+	})
+
+	// Seeking moves an animation to a time, in seconds or as progress from
+	// 0 to 1, so controllers can scrub it or follow another timeline
+	s.control.Handle(s.httpServer, "POST /api/v1/animation/seek", func(r *http.Request) (any, error) {
+		req := struct {
+			Name     string   `json:"name"`
+			Time     *float32 `json:"time"`
+			Progress *float32 `json:"progress"`
+		}{}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if (req.Time == nil) == (req.Progress == nil) {
+			return nil, badRequest("one of time and progress is required")
+		}
+		live, err := s.livePreview()
+		if err != nil {
+			return nil, err
+		}
+		if req.Time != nil {
+			err = live.Renderer.Seek(req.Name, *req.Time)
+		} else {
+			err = live.Renderer.SetNormalizedTime(req.Name, *req.Progress)
+		}
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		return s.animationState(live.Renderer), nil
+	})
+
+	// Layers play an animation on a subset of the bones, over the current one
+	s.control.Handle(s.httpServer, "POST /api/v1/animation/layers", func(r *http.Request) (any, error) {
+		req := struct {
+			Name  string   `json:"name"`
+			Loop  *bool    `json:"loop"`
+			Bones []string `json:"bones"`
+		}{}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Name == "" || len(req.Bones) == 0 {
+			return nil, badRequest("name and bones are required")
+		}
+		live, err := s.livePreview()
+		if err != nil {
+			return nil, err
+		}
+		if err := live.Renderer.PlayLayer(req.Name, req.Loop == nil || *req.Loop, req.Bones); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		return s.animationState(live.Renderer), nil
+	})
+	s.control.Handle(s.httpServer, "DELETE /api/v1/animation/layers/{name}", func(r *http.Request) (any, error) {
+		live, err := s.livePreview()
+		if err != nil {
+			return nil, err
+		}
+		if !live.Renderer.StopLayer(r.PathValue("name")) {
+			return nil, notFound("no layer playing %q", r.PathValue("name"))
+		}
+		return s.animationState(live.Renderer), nil
+	})
+
+	// Swap the model at runtime, uploaded or from a path on this machine.
+	// The model is parsed here, off the render loop, which only uploads its
+	// buffers.
+	s.control.Handle(s.httpServer, "GET /api/v1/model", func(r *http.Request) (any, error) {
+		live, err := s.livePreview()
+		if err != nil {
+			return nil, err
+		}
+		return s.modelInfo(live), nil
+	})
+	s.httpServer.HandleFunc("POST /api/v1/model", func(w http.ResponseWriter, r *http.Request) {
+		path, doc, uploaded, err := readModelRequest(r, s.options.modelDir)
+		if err != nil {
+			writeControlResult(w, nil, err)
+			return
+		}
+		value, err := s.control.Call(r, func() (any, error) {
+			live, err := s.livePreview()
+			if err == nil && live.Flat != nil {
+				err = badRequest("-flat shows no model")
+			}
+			if err == nil {
+				err = live.SwapModel(doc)
+			}
+			if err != nil {
+				if uploaded {
+					os.Remove(path)
+				}
+				return nil, err
+			}
+			if s.uploadedModel != "" {
+				os.Remove(s.uploadedModel)
+				s.uploadedModel = ""
+			}
+			if uploaded {
+				s.uploadedModel = path
+			}
+			s.previewOptions.ModelPath = path
+			if s.hotReload != nil {
+				s.hotReload.SetModel(path)
+			}
+			log.Printf("Showing model %s (%d meshes)", path, len(live.Renderer.Meshes))
+			return s.modelInfo(live), nil
+		})
+		writeControlResult(w, value, err)
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/viewers", func(r *http.Request) (any, error) {
+		return s.httpServer.Viewers(), nil
+	})
+
+	s.control.Handle(s.httpServer, "DELETE /api/v1/viewers/{id}", func(r *http.Request) (any, error) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			return nil, badRequest("invalid viewer id %q", r.PathValue("id"))
+		}
+		if !s.httpServer.KickViewer(id) {
+			return nil, notFound("no viewer %d", id)
+		}
+		return nil, nil
+	})
+
+	// With exclusive input control one viewer at a time holds control
+	s.control.Handle(s.httpServer, "GET /api/v1/control", func(r *http.Request) (any, error) {
+		return s.httpServer.Control(), nil
+	})
+	s.control.Handle(s.httpServer, "POST /api/v1/control", func(r *http.Request) (any, error) {
+		var req struct {
+			Viewer int `json:"viewer"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if s.httpServer.Control().Mode != stream.ControlExclusive {
+			return nil, badRequest("input control is shared, start with -input-control exclusive")
+		}
+		if !s.httpServer.GrantControl(req.Viewer) {
+			return nil, notFound("no viewer %d that may take control", req.Viewer)
+		}
+		log.Printf("Input control given to viewer %d", req.Viewer)
+		return s.httpServer.Control(), nil
+	})
+	s.control.Handle(s.httpServer, "DELETE /api/v1/control", func(r *http.Request) (any, error) {
+		if s.httpServer.Control().Mode != stream.ControlExclusive {
+			return nil, badRequest("input control is shared, start with -input-control exclusive")
+		}
+		if id := s.httpServer.RevokeControl(); id != 0 {
+			log.Printf("Input control taken from viewer %d", id)
+		}
+		return s.httpServer.Control(), nil
+	})
+
+	// Viewers' preferences are kept under their reconnect tokens
+	s.control.Handle(s.httpServer, "GET /api/v1/viewers/{id}/preferences", func(r *http.Request) (any, error) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			return nil, badRequest("invalid viewer id %q", r.PathValue("id"))
+		}
+		preferences, ok := s.httpServer.ViewerPreferences(id)
+		if !ok {
+			return nil, notFound("no viewer %d", id)
+		}
+		return preferences, nil
+	})
+	s.control.Handle(s.httpServer, "POST /api/v1/viewers/{id}/preferences", func(r *http.Request) (any, error) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			return nil, badRequest("invalid viewer id %q", r.PathValue("id"))
+		}
+		var preferences stream.ViewerPreferences
+		if err := decodeBody(r, &preferences); err != nil {
+			return nil, err
+		}
+		if err := preferences.Validate(); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		if !s.httpServer.SetViewerPreferences(id, preferences) {
+			return nil, notFound("no viewer %d", id)
+		}
+		return preferences, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/notifications", func(r *http.Request) (any, error) {
+		return s.notifications.Showing(time.Now()), nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/notifications", func(r *http.Request) (any, error) {
+		var req struct {
+			Text       string `json:"text"`
+			Anchor     string `json:"anchor"`
+			DurationMS int64  `json:"duration_ms"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		notification, err := s.notifications.Post(req.Text, req.Anchor, time.Duration(req.DurationMS)*time.Millisecond, time.Now())
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		return notification, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/connect-code", func(r *http.Request) (any, error) {
+		return map[string]any{"url": s.connectCode.URL, "showing": s.connectCode.Showing(time.Now())}, nil
+	})
+	s.control.Handle(s.httpServer, "POST /api/v1/connect-code", func(r *http.Request) (any, error) {
+		var req struct {
+			DurationMS int64 `json:"duration_ms"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.DurationMS < 0 {
+			return nil, badRequest("duration must not be negative")
+		}
+		duration := time.Duration(req.DurationMS) * time.Millisecond
+		if duration == 0 {
+			duration = max(s.options.connectQR, 30*time.Second)
+		}
+		s.connectCode.Show(duration, time.Now())
+		s.renderTicker.Wake()
+		return map[string]any{"url": s.connectCode.URL, "showing": true}, nil
+	})
+	s.control.Handle(s.httpServer, "DELETE /api/v1/connect-code", func(r *http.Request) (any, error) {
+		s.connectCode.Hide()
+		return nil, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/widgets", func(r *http.Request) (any, error) {
+		return s.widgetLayer.Texts(time.Now()), nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/screen-shaders", func(r *http.Request) (any, error) {
+		return s.screenShaderToggles.Info(), nil
+	})
+	s.control.Handle(s.httpServer, "POST /api/v1/screen-shaders/{name}", func(r *http.Request) (any, error) {
+		var req struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Enabled == nil {
+			return nil, badRequest("enabled is required")
+		}
+		if err := s.screenShaderToggles.Set(r.PathValue("name"), *req.Enabled); err != nil {
+			return nil, notFound("%v", err)
+		}
+		return s.screenShaderToggles.Info(), nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/screen-layout", func(r *http.Request) (any, error) {
+		return s.screenComposer.Layout(), nil
+	})
+	s.control.Handle(s.httpServer, "PUT /api/v1/screen-layout", func(r *http.Request) (any, error) {
+		var root ScreenLayoutNode
+		if err := decodeBody(r, &root); err != nil {
+			return nil, err
+		}
+		if err := root.validate("layout", false); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		if err := s.screenComposer.SetLayout(&root, image.Pt(s.desktop.Width, s.desktop.Height)); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		s.options.screenLayout.Root = &root
+		return s.screenComposer.Layout(), nil
+	})
+	s.control.Handle(s.httpServer, "DELETE /api/v1/screen-layout", func(r *http.Request) (any, error) {
+		s.screenComposer.SetLayout(nil, image.Point{})
+		s.options.screenLayout.Root = nil
+		return nil, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/surface-textures", func(r *http.Request) (any, error) {
+		return s.surfaceCapture.Info(), nil
+	})
+	s.control.Handle(s.httpServer, "PUT /api/v1/surface-textures", func(r *http.Request) (any, error) {
+		var bindings SurfaceTextures
+		if err := decodeBody(r, &bindings); err != nil {
+			return nil, err
+		}
+		if err := bindings.validate(); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		s.surfaceCapture.SetBindings(bindings)
+		return s.surfaceCapture.Info(), nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/backlight", func(r *http.Request) (any, error) {
+		return s.backlight.Info(time.Now()), nil
+	})
+	s.control.Handle(s.httpServer, "POST /api/v1/backlight", func(r *http.Request) (any, error) {
+		var req struct {
+			Level *float64 `json:"level"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Level == nil {
+			return nil, badRequest("level is required")
+		}
+		if err := s.backlight.Set(*req.Level, time.Now()); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		return s.backlight.Info(time.Now()), nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/output/power", func(r *http.Request) (any, error) {
+		return s.outputPower.Info(), nil
+	})
+	s.control.Handle(s.httpServer, "POST /api/v1/output/power", func(r *http.Request) (any, error) {
+		var req struct {
+			Mode string `json:"mode"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		on, err := parseOutputPowerMode(req.Mode)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		s.outputPower.Set(on, time.Now())
+		s.renderTicker.Wake()
+		return s.outputPower.Info(), nil
+	})
+
+	if s.governor != nil {
+		s.control.Handle(s.httpServer, "GET /api/v1/power", func(r *http.Request) (any, error) {
+			return s.governor.Info(), nil
+		})
+	}
+
+	s.control.Handle(s.httpServer, "GET /api/v1/particles", func(r *http.Request) (any, error) {
+		return s.particleSystem.Names(), nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/particles/{name}", func(r *http.Request) (any, error) {
+		if err := s.particleSystem.Fire(r.PathValue("name"), time.Now()); err != nil {
+			return nil, notFound("%v", err)
+		}
+		return nil, nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/expressions", func(r *http.Request) (any, error) {
+		return s.options.expressions, nil
+	})
+	s.control.Handle(s.httpServer, "POST /api/v1/expressions/{name}", func(r *http.Request) (any, error) {
+		return s.applyExpression(r.PathValue("name"))
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/screen", func(r *http.Request) (any, error) {
+		return s.screenAnalyzer.Stats(), nil
+	})
+
+	s.control.Handle(s.httpServer, "GET /api/v1/audio", func(r *http.Request) (any, error) {
+		if s.options.audioCapture == "" {
+			return nil, badRequest("no -audio-capture command was given")
+		}
+		return s.audioAnalyzer.Spectrum(), nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/rotation", func(r *http.Request) (any, error) {
+		var req struct {
+			Speed *float64 `json:"speed"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Speed == nil {
+			return nil, badRequest("speed is required")
+		}
+		s.options.rotationSpeed = *req.Speed
+		return map[string]float64{"speed": s.options.rotationSpeed}, nil
+	})
+
+	// Resizing the desktop lays the windows out again at the new size and
+	// rebuilds the preview's GPU resources, which are sized to the desktop
+	s.control.Handle(s.httpServer, "POST /api/v1/resolution", func(r *http.Request) (any, error) {
+		var req struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Width <= 0 || req.Height <= 0 || req.Width > maxDesktopSize || req.Height > maxDesktopSize {
+			return nil, badRequest("width and height must be between 1 and %d", maxDesktopSize)
+		}
+
+		s.mu.Lock()
+		s.desktop = wayland.MakeDesktop(wayland.Size{Width: uint32(req.Width), Height: uint32(req.Height)}, false, createIcon())
+		wayland.VirtualMonitorSize = wayland.PixelSize{Width: wayland.Pixels(req.Width), Height: wayland.Pixels(req.Height)}
+		s.visibility.Bounds = image.Rect(0, 0, req.Width, req.Height)
+		s.layout.SetBounds(req.Width, req.Height)
+		s.damage.Invalidate()
+		s.mu.Unlock()
+		s.sessions.Resize(req.Width, req.Height)
+
+		s.previewOptions.DesktopWidth, s.previewOptions.DesktopHeight = int32(req.Width), int32(req.Height)
+		if s.lostReason == "" && (s.previewOptions.GPUComposite || len(s.previewOptions.Transitions) > 0 || s.previewOptions.ScreenTrail > 0 || len(s.previewOptions.ScreenShaders) > 0 || s.previewOptions.Flat) {
+			s.lostReason = "desktop resize"
+		}
+		log.Printf("Desktop resized to %dx%d", req.Width, req.Height)
+		return map[string]int{"width": req.Width, "height": req.Height}, nil
+	})
+
+	s.control.Handle(s.httpServer, "POST /api/v1/launch", func(r *http.Request) (any, error) {
+		var req struct {
+			Command string `json:"command"`
+			Session string `json:"session"`
+		}
+		if err := decodeBody(r, &req); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(req.Command) == "" {
+			return nil, badRequest("command is required")
+		}
+		env := s.launchEnv
+		if req.Session != "" && req.Session != mainSession {
+			session := s.sessions.Get(req.Session)
+			if session == nil {
+				return nil, notFound("no session %q", req.Session)
+			}
+			env = []string{"WAYLAND_DISPLAY=" + session.Display}
+		}
+		token, err := s.activation.Issue("", time.Now())
+		if err != nil {
+			return nil, err
+		}
+		pid, err := launchCommand(req.Command, append(activationEnv(token), env...))
+		if err != nil {
+			return nil, fmt.Errorf("launch %q: %w", req.Command, err)
+		}
+		s.activation.Bind(token, pid)
+		log.Printf("Launched %q (pid %d)", req.Command, pid)
+		return map[string]any{"pid": pid, "activation_token": token}, nil
+	})
+
+	// Settings the render loop reads as it goes are applied right away, the
+	// rest are reported as needing a restart
+	s.control.Handle(s.httpServer, "POST /api/v1/config/reload", func(r *http.Request) (any, error) {
+		if s.options.configPath == "" {
+			return nil, badRequest("no -config file was given")
+		}
+		config, err := loadConfigFile(s.options.configPath)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		changed, err := applyConfig(s.flags, config, s.explicit)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+
+		result := ConfigReloadResult{Applied: []string{}, RestartRequired: []string{}}
+		for _, name := range changed {
+			switch name {
+			case "fps":
+				s.renderTicker.SetFPS(s.powerLevel.FPS(s.options.fps))
+			case "idle-fps":
+				s.renderTicker.SetIdleFPS(s.options.idleFPS)
+			case "max-fps":
+				rules, err := parseMaxFPSRules(s.options.maxFPS)
+				if err != nil {
+					return nil, badRequest("invalid max-fps: %v", err)
+				}
+				for appID := range s.maxFPSRules {
+					s.framePacer.SetMaxFPS(appID, 0)
+				}
+				for appID, limit := range rules {
+					s.framePacer.SetMaxFPS(appID, limit)
+				}
+				s.maxFPSRules = rules
+			case "idle-timeout":
+				s.idle.Timeout = s.options.idleTimeout
+			case "idle-brightness":
+				s.screensaver.Brightness = float32(s.options.idleBrightness)
+			case "screensaver-animation":
+				s.screensaver.Animation = s.options.screensaverAnimation
+			case "backlight":
+				if err := s.backlight.Set(s.options.backlightLevel, time.Now()); err != nil {
+					return nil, badRequest("invalid backlight: %v", err)
+				}
+			case "backlight-schedule":
+				schedule, err := parseBacklightSchedule(s.options.backlightSchedule)
+				if err != nil {
+					return nil, badRequest("invalid backlight-schedule: %v", err)
+				}
+				s.backlight.SetSchedule(schedule)
+			case "playlist-interval":
+				if s.playlist != nil {
+					s.playlist.Interval = s.options.playlistInterval
+				}
+			case "particles":
+				s.particleSystem.SetEmitters(s.options.particles)
+			case "widgets":
+				s.widgetLayer.SetWidgets(s.options.widgets)
+			case "keybindings":
+				s.shortcuts.SetBindings(s.options.keyBindings)
+			case "window-rules":
+				s.windowRuleSet.SetRules(s.options.windowRules)
+			case "notification-anchor", "notification-duration":
+				if err := s.notifications.SetDefaults(s.options.notificationAnchor, s.options.notificationDuration); err != nil {
+					return nil, badRequest("invalid %s: %v", name, err)
+				}
+			case "screen-layout":
+				if err := s.screenComposer.SetLayout(s.options.screenLayout.Root, image.Pt(s.desktop.Width, s.desktop.Height)); err != nil {
+					return nil, badRequest("invalid screen-layout: %v", err)
+				}
+			case "surface-textures":
+				s.surfaceCapture.SetBindings(s.options.surfaceTextures)
+			case "mjpeg-quality", "mjpeg-fps":
+				if err := s.httpServer.ConfigureMJPEG(s.options.mjpegQuality, s.options.mjpegFPS); err != nil {
+					return nil, badRequest("mjpeg-%v", err)
+				}
+			case "rotation-speed", "expressions", "screen-glow", "screen-react", "audio-pulse", "audio-glow", "notifications":
+				// Read as they are used
+			default:
+				result.RestartRequired = append(result.RestartRequired, name)
+				continue
+			}
+			result.Applied = append(result.Applied, name)
+		}
+		log.Printf("Reloaded %s: applied %v, restart required for %v", s.options.configPath, result.Applied, result.RestartRequired)
+		return result, nil
+	})
+}
+
+// animationState describes the live renderer's animation
+func (s *runState) animationState(renderer *render.GLBRenderer) AnimationInfo {
+	playback := renderer.Playback()
+	info := AnimationInfo{
+		Name:      playback.Name,
+		Loop:      renderer.AnimLoop,
+		Paused:    renderer.AnimPaused,
+		Time:      playback.Time,
+		Duration:  playback.Duration,
+		Progress:  playback.Progress,
+		Layers:    []AnimationLayerInfo{},
+		Available: make([]string, 0, len(renderer.Animations)),
+	}
+	for _, layer := range renderer.Layers {
+		info.Layers = append(info.Layers, AnimationLayerInfo{Name: layer.Anim.Name, Loop: layer.Loop, Bones: layer.Bones})
+	}
+	for name := range renderer.Animations {
+		info.Available = append(info.Available, name)
+	}
+	sort.Strings(info.Available)
+	return info
+}
+
+// livePreview returns the preview, or an error while it is being rebuilt
+func (s *runState) livePreview() (*render.Preview, error) {
+	if s.preview == nil || s.lostReason != "" {
+		return nil, &controlError{http.StatusServiceUnavailable, "the preview window is being rebuilt"}
+	}
+	return s.preview, nil
+}
+
+// modelInfo describes the model live shows
+func (s *runState) modelInfo(live *render.Preview) ModelInfo {
+	return ModelInfo{Path: s.previewOptions.ModelPath, Meshes: len(live.Renderer.Meshes), Watching: s.hotReload != nil}
+}
+
+// applyExpression shows the expression preset name. Presets change
+// morphs, animations, tint and flash at once.
+func (s *runState) applyExpression(name string) (any, error) {
+	e, ok := s.options.expressions[name]
+	if !ok {
+		return nil, notFound("no expression %q, have %v", name, s.options.expressions.Names())
+	}
+	live, err := s.livePreview()
+	if err != nil {
+		return nil, err
+	}
+	missing := live.Renderer.ApplyExpression(e)
+	log.Printf("Expression %q applied", name)
+	if missing == nil {
+		missing = []string{}
+	}
+	return ExpressionResult{Name: name, Missing: missing}, nil
+}
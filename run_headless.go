@@ -0,0 +1,200 @@
+//go:build nogl || nosdl
+
+package compositor
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// The headless compositor's desktop, the size the full one starts at
+const (
+	headlessDesktopWidth  = 800
+	headlessDesktopHeight = 600
+)
+
+// Run parses the compositor's command line flags from c.Args and runs it
+// until Stop is called or it gets SIGINT or SIGTERM. This build leaves
+// out OpenGL or SDL (see Features), so it runs the headless compositor:
+// there is no preview window, clients are composited on the CPU, and the
+// desktop goes to the viewers and the -output sinks if they are built in,
+// and to c's renderers and sinks.
+func (c *Compositor) Run() error {
+	flags := flag.NewFlagSet("wayland-compositor", flag.ContinueOnError)
+	displayName := flags.String("display", "", "Wayland socket name to listen on, e.g. wayland-1 (default: the first free one)")
+	launch := LaunchCommands{}
+	flags.Var(&launch, "launch", "Shell command of an app to start once the compositor is up; repeat for more apps")
+	fps := flags.Int("fps", 60, "Most frames a second the compositor renders")
+	idleFPS := flags.Int("idle-fps", 5, "Frame rate the render loop slows to when nothing has changed for a few seconds, 0 to never slow down")
+	clientQueue := flags.Int("client-queue", 1024, "Maximum queued events per Wayland client before input is withheld")
+	clientTimeout := flags.Duration("client-timeout", 2*time.Second, "Disconnect Wayland clients whose event queue stays full this long")
+	wlDebug := flags.Bool("wl-debug", false, "Log every Wayland request and event, per client, with object ids, interface names and arguments, like WAYLAND_DEBUG")
+	wlDump := flags.String("wl-dump", "", "File to dump the raw Wayland wire traffic between clients and the compositor to, for replaying in tests")
+	screencopyFlag := flags.Bool("screencopy", false, "Offer clients wlr-screencopy, so grim and OBS can capture the desktop; their connections go through a proxy that adds it")
+	maxClients := flags.Int("max-clients", 64, "Most Wayland clients connected at once; more are turned away with a protocol error. 0 is no limit")
+	outputSpecs := flags.String("output", "", "Other outputs for the desktop, comma separated: raw:<file> (raw RGBA frames, - for stdout), an rtmp:// URL to live stream to, or v4l2:<device> for a v4l2loopback webcam")
+	viewer := newHeadlessViewer(flags)
+	if err := flags.Parse(c.Args); err != nil {
+		return err
+	}
+	if *fps <= 0 {
+		return fmt.Errorf("-fps must be positive")
+	}
+	log.Printf("Headless compositor, built with %s", BuiltFeatures())
+
+	var tracer *WireTracer
+	if *wlDebug || *wlDump != "" {
+		var err error
+		tracer, err = NewWireTracer(*wlDebug, *wlDump)
+		if err != nil {
+			return fmt.Errorf("invalid -wl-dump: %w", err)
+		}
+		defer tracer.Close()
+	}
+
+	clients := NewClientList()
+	sendGuard := NewSendGuard(*clientQueue, *clientTimeout)
+	writable := func() []*wayland.Client {
+		return sendGuard.Writable(clients.Snapshot())
+	}
+	renderTicker := NewRenderTicker(*fps, *idleFPS)
+	defer renderTicker.Stop()
+	var screencopy *Screencopy
+	if *screencopyFlag {
+		screencopy = NewScreencopy()
+		screencopy.Wake = renderTicker.Wake
+	}
+
+	if err := viewer.Start(sendGuard, writable, renderTicker.Wake); err != nil {
+		return err
+	}
+	defer viewer.Stop()
+	outputs, err := stream.ParseOutputSinks(*outputSpecs, *fps)
+	if err != nil {
+		return fmt.Errorf("invalid -output: %w", err)
+	}
+	sinks := slices.Concat(viewer.Sinks(), outputs, c.sinks)
+	defer func() {
+		for _, sink := range sinks {
+			if err := sink.Close(); err != nil {
+				log.Printf("Failed to close output: %v", err)
+			}
+		}
+	}()
+
+	listener, err := wayland.MakeSocketListener(&Args{DisplayName: *displayName})
+	if err != nil {
+		return fmt.Errorf("failed to create socket listener: %w", err)
+	}
+	fmt.Printf("Wayland Compositor started.\n")
+	fmt.Printf("Display: %s\n", listener.WaylandDisplayName)
+	fmt.Printf("Set WAYLAND_DISPLAY=%s to connect clients.\n", listener.WaylandDisplayName)
+	go func() {
+		if err := acceptClients(listener); err != nil {
+			log.Printf("Listener loop error: %v", err)
+		}
+	}()
+
+	// Frame callbacks are held until the render loop has composited a frame
+	framePacer := NewFramePacer()
+	go func() {
+		for conn := range listener.OnConnection {
+			if *maxClients > 0 && clients.Len() >= *maxClients {
+				rejectClient(conn, *maxClients)
+				continue
+			}
+			traced, err := tracer.Wrap(conn)
+			if err != nil {
+				log.Printf("Failed to trace client: %v", err)
+				conn.Close()
+				continue
+			}
+			served, err := screencopy.Wrap(traced)
+			if err != nil {
+				log.Printf("Failed to proxy client: %v", err)
+				traced.Close()
+				continue
+			}
+			client := wayland.MakeClient(served)
+			clients.Add(client)
+			renderTicker.Wake()
+			go runClient(clients, client, func(callbackID protocols.ObjectID[protocols.WlCallback]) {
+				framePacer.Queue(client, callbackID)
+				renderTicker.Wake()
+			})
+		}
+	}()
+
+	desktop := wayland.MakeDesktop(wayland.Size{Width: headlessDesktopWidth, Height: headlessDesktopHeight}, false, createIcon())
+	wayland.VirtualMonitorSize = wayland.PixelSize{Width: wayland.Pixels(desktop.Width), Height: wayland.Pixels(desktop.Height)}
+	damage := NewDesktopDamage()
+	surfaces := NewSurfaceSnapshots()
+	visibility := NewVisibilityTracker(desktop.Width, desktop.Height)
+	desktopSource := render.NewDesktopTextureSource()
+
+	for _, command := range launch {
+		pid, err := launchCommand(command, []string{"WAYLAND_DISPLAY=" + listener.WaylandDisplayName})
+		if err != nil {
+			log.Printf("Failed to launch %q: %v", command, err)
+			continue
+		}
+		log.Printf("Launched %q (pid %d)", command, pid)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	log.Println("Starting render loop. Press Ctrl+C to exit.")
+	for {
+		select {
+		case <-sigChan:
+			log.Println("Shutting down...")
+			closeListener(listener)
+			return nil
+		case <-c.stop:
+			log.Println("Shutting down...")
+			closeListener(listener)
+			return nil
+		case <-renderTicker.C:
+		}
+
+		now := time.Now()
+		clients.TakeAdded()
+		for _, c := range clients.Prune() {
+			framePacer.Forget(c)
+			releaseClient(c)
+		}
+		sendGuard.Check(clients.Snapshot(), now)
+
+		placed := surfaces.Collect(clients.Snapshot(), visibility.Bounds)
+		visible, hidden := visibility.Cull(placed)
+		framePacer.SetHiddenClients(hiddenClients(visible, hidden))
+		showIcon := len(visible) == 0 && desktop.IconImg != nil && desktop.AfterOpeningTimeout()
+		if damage.Dirty(visible, showIcon) {
+			renderTicker.Active(now)
+			compositeDesktop(desktop, visible)
+			desktopSource.Publish(desktop)
+		}
+		framePacer.Flush(now, sendGuard)
+		screencopy.Fulfil(desktop.Buffer, desktop.Width, desktop.Height, desktop.Stride)
+
+		for _, sink := range sinks {
+			sink.Stream(desktopSource)
+		}
+		for _, renderer := range c.renderers {
+			renderer.Render(desktopSource, now)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build noweb && (nogl || nosdl)
+
+package compositor
+
+import (
+	"flag"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// headlessViewer is the headless compositor's viewer, which this build
+// leaves out
+type headlessViewer struct{}
+
+func newHeadlessViewer(flags *flag.FlagSet) *headlessViewer {
+	return &headlessViewer{}
+}
+
+// Start does nothing; there is no viewer to serve
+func (v *headlessViewer) Start(guard *SendGuard, writable func() []*wayland.Client, wake func()) error {
+	return nil
+}
+
+// Sinks returns no sinks
+func (v *headlessViewer) Sinks() []OutputSink {
+	return nil
+}
+
+// Stop does nothing
+func (v *headlessViewer) Stop() {}
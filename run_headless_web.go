@@ -0,0 +1,76 @@
+//go:build !noweb && (nogl || nosdl)
+
+package compositor
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// headlessViewer serves the headless compositor's desktop to viewers at
+// -http, as the full compositor does, without the control API
+type headlessViewer struct {
+	addr      *string
+	staticDir *string
+	server    *HTTPServer
+}
+
+func newHeadlessViewer(flags *flag.FlagSet) *headlessViewer {
+	return &headlessViewer{
+		addr:      flags.String("http", defaultHTTPAddr, "HTTP server address, or unix:<path> for a Unix socket; use :8080 to serve other machines"),
+		staticDir: flags.String("static", "", "Static files directory to serve instead of the built-in viewer"),
+	}
+}
+
+// Start serves the viewer, sending viewers' input through guard to the
+// clients writable returns and waking the render loop for it
+func (v *headlessViewer) Start(guard *SendGuard, writable func() []*wayland.Client, wake func()) error {
+	v.server = NewHTTPServer(*v.addr, *v.staticDir)
+	v.server.SetKeyboardHandler(func(keycode uint32, pressed bool) {
+		wake()
+		if keycode != 0 {
+			guard.SendKeyboardKey(writable(), keycode, pressed)
+		}
+	})
+	v.server.SetTextHandler(func(text string) {
+		wake()
+		events, dropped := textKeyEvents(text)
+		if dropped > 0 {
+			log.Printf("Dropped %d typed characters the keymap has no key for", dropped)
+		}
+		clients := writable()
+		for _, e := range events {
+			guard.SendKeyboardKey(clients, e.Keycode, e.Pressed)
+		}
+	})
+	v.server.SetPointerHandler(func(e stream.PointerEvent) {
+		wake()
+		clients := writable()
+		switch e.Type {
+		case stream.InputPointerMotion:
+			guard.SendPointerMotion(clients, e.X, e.Y)
+		case stream.InputPointerButton:
+			guard.SendPointerButton(clients, e.Button, e.Pressed)
+		case stream.InputPointerAxis:
+			guard.SendPointerAxis(clients, e.Axis, e.Value)
+		}
+	})
+	if err := v.server.Start(); err != nil {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
+	return nil
+}
+
+// Sinks returns the viewers' sink, for the render loop to stream to
+func (v *headlessViewer) Sinks() []OutputSink {
+	return []OutputSink{v.server.Broadcaster()}
+}
+
+// Stop stops the HTTP server
+func (v *headlessViewer) Stop() {
+	v.server.Stop()
+}
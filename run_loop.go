@@ -0,0 +1,601 @@
+//go:build !nogl && !nosdl
+
+package compositor
+
+import (
+	"image"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/term.everything/wayland/protocols"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// loop runs the render loop until Stop is called, a signal comes or the
+// preview window is closed
+func (s *runState) loop() error {
+	log.Println("Starting render loop. Press Ctrl+C to exit.")
+	s.notifier.Ready()
+	s.lastLog = time.Now()
+
+	for running := true; running; {
+		s.recoverPreview()
+		running = s.handleEvents()
+
+		select {
+		case <-s.sigChan:
+			s.shutdown()
+			return nil
+		case <-s.c.stop:
+			s.shutdown()
+			return nil
+		case <-s.renderTicker.C:
+			s.frame()
+		}
+	}
+	return nil
+}
+
+// shutdown tells systemd and the viewers the compositor is going away
+func (s *runState) shutdown() {
+	log.Println("Shutting down...")
+	s.notifier.Stopping()
+	s.stopViewers()
+	// Close the listener to stop accepting new connections.
+	s.listener.Close()
+}
+
+// recoverPreview rebuilds a lost window and GL context from the scene
+// snapshot, retrying every second. Streaming carries on meanwhile.
+func (s *runState) recoverPreview() {
+	if s.lostReason != "" && !time.Now().Before(s.retryAt) {
+		if s.preview != nil {
+			s.lostSnapshot = s.preview.Renderer.Snapshot()
+			s.preview.Destroy()
+			s.preview = nil
+		}
+		// Show the model, scene and shaders it had, which may not be
+		// what the files hold any more
+		if s.lostSnapshot.Document != nil {
+			s.previewOptions.Document, s.previewOptions.Scene = s.lostSnapshot.Document, s.lostSnapshot.Scene
+		}
+		preview, err := render.NewPreview(s.previewOptions)
+		if err != nil {
+			log.Printf("Failed to recover preview window: %v", err)
+			s.retryAt = time.Now().Add(time.Second)
+		} else {
+			s.preview = preview
+			s.preview.Restore(s.lostSnapshot)
+			log.Printf("Recovered preview window after %s", s.lostReason)
+			s.events.previewRecovered.emit(PreviewRecoveredEvent{Reason: s.lostReason, Time: time.Now()})
+			s.lostReason = ""
+		}
+	}
+}
+
+// handleEvents forwards the preview window's input to the Wayland
+// clients. It reports false once the window was closed.
+func (s *runState) handleEvents() bool {
+	running := true
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		if reason := render.LostReason(event); reason != "" && s.lostReason == "" {
+			log.Printf("Preview window lost: %s", reason)
+			s.lostReason = reason
+		}
+
+		activeClients := s.sendGuard.Writable(s.clients.Snapshot())
+
+		switch e := event.(type) {
+		case *sdl.QuitEvent:
+			log.Println("SDL2 quit event received...")
+			running = false
+
+		case *sdl.WindowEvent:
+			switch e.Event {
+			case sdl.WINDOWEVENT_MINIMIZED, sdl.WINDOWEVENT_HIDDEN:
+				if !s.previewHidden {
+					log.Println("Preview window hidden, pausing the 3D view")
+				}
+				s.previewHidden = true
+			case sdl.WINDOWEVENT_RESTORED, sdl.WINDOWEVENT_SHOWN, sdl.WINDOWEVENT_EXPOSED:
+				if s.previewHidden && s.preview != nil && s.preview.Compositor != nil {
+					s.preview.Compositor.Forget()
+				}
+				s.previewHidden = false
+			}
+
+		case *sdl.MouseMotionEvent:
+			s.idle.Activity(time.Now())
+			s.kiosk.PointerMoved(time.Now())
+			// In relative mode only XRel and YRel change
+			var x, y float32
+			switch {
+			case s.pointerCaptured:
+				x, y = s.pointerLock.Move(float32(e.XRel), float32(e.YRel))
+			case s.preview != nil && s.preview.Flat != nil:
+				winW, winH := s.preview.Window.GetSize()
+				x, y = s.pointerLock.Warp(render.FlatToDesktop(winW, winH, int32(s.desktop.Width), int32(s.desktop.Height), float32(e.X), float32(e.Y)))
+			default:
+				x, y = s.pointerLock.Warp(float32(e.X), float32(e.Y))
+			}
+			s.layout.SendPointerMotion(s.sendGuard, activeClients, x, y)
+			if s.recorder != nil {
+				s.recorder.Input(pointerMotionMessage(x, y), time.Now())
+			}
+
+		case *sdl.MouseButtonEvent:
+			// Map SDL button to Linux button codes
+			var button uint32
+			switch e.Button {
+			case sdl.BUTTON_LEFT:
+				button = 0x110 // BTN_LEFT
+			case sdl.BUTTON_RIGHT:
+				button = 0x111 // BTN_RIGHT
+			case sdl.BUTTON_MIDDLE:
+				button = 0x112 // BTN_MIDDLE
+			default:
+				button = 0x110
+			}
+			pressed := e.Type == sdl.MOUSEBUTTONDOWN
+			s.idle.Activity(time.Now())
+			if s.xwm != nil && pressed {
+				x, y := s.pointerLock.Position()
+				s.xwm.FocusAt(int(x), int(y))
+			}
+			s.sendGuard.SendPointerButton(activeClients, button, pressed)
+			if s.recorder != nil {
+				s.recorder.Input(pointerButtonMessage(button, pressed), time.Now())
+			}
+
+		case *sdl.MouseWheelEvent:
+			// Scroll amount (positive = up, negative = down)
+			value := float32(e.Y) * -15.0 // Invert and scale
+			s.idle.Activity(time.Now())
+			s.sendGuard.SendPointerAxis(activeClients, protocols.WlPointerAxis_enum_vertical_scroll, value)
+			if s.recorder != nil {
+				s.recorder.Input(pointerAxisMessage(protocols.WlPointerAxis_enum_vertical_scroll, value), time.Now())
+			}
+
+		case *sdl.KeyboardEvent:
+			// Convert SDL scancode to Linux evdev keycode
+			keycode := render.ScancodeToLinux(e.Keysym.Scancode)
+			s.idle.Activity(time.Now())
+			pressed := e.Type == sdl.KEYDOWN
+			if keycode != 0 && !s.shortcuts.Handle(keycode, pressed) {
+				s.sendGuard.SendKeyboardKey(activeClients, keycode, pressed)
+				if s.recorder != nil {
+					s.recorder.Input(keyboardMessage(keycode, pressed), time.Now())
+				}
+			}
+		}
+	}
+	return running
+}
+
+// frame composites, streams and draws a frame
+func (s *runState) frame() {
+	s.notifier.Watchdog(time.Now())
+	s.runControlRequests()
+
+	// After a suspend the GL context and its textures may be gone,
+	// viewers' deltas refer to frames from before it and clients
+	// wait on frame callbacks, so start over on all three
+	if gap, slept := s.suspend.Check(time.Now()); slept {
+		log.Printf("Resumed after about %s asleep", gap.Round(time.Second))
+		s.idle.Activity(time.Now())
+		s.viewers.Resync()
+		s.resyncSessions()
+		s.framePacer.Flush(time.Now(), s.sendGuard)
+		if s.preview != nil && s.lostReason == "" {
+			s.lostReason = "resume from suspend"
+		}
+	}
+
+	if s.governor != nil {
+		if change, ok := s.governor.Poll(); ok {
+			log.Printf("Power governor: %s -> %s, %s", change.Previous, change.Level, change.Reason)
+			s.powerLevel = s.governor.Level()
+			s.renderTicker.SetFPS(s.powerLevel.FPS(s.options.fps))
+			s.viewers.SetQualityFloor(s.powerLevel.StreamFloor())
+			s.events.powerChanged.emit(change)
+		}
+	}
+
+	// Blank the preview window when the output is turned off, and
+	// give viewers a keyframe when it comes back on
+	if change, ok := s.outputPower.Poll(); ok {
+		s.outputOff = !change.On
+		if s.outputOff {
+			log.Println("Output off")
+			if s.preview != nil && s.lostReason == "" {
+				s.preview.Blank()
+			}
+		} else {
+			log.Println("Output on")
+			s.damage.Invalidate()
+			s.viewers.Resync()
+		}
+		s.events.outputPower.emit(change)
+	}
+
+	// Capture the mouse in the preview and the viewers while the
+	// pointer is locked
+	if locked := s.pointerLock.Locked(); locked != s.pointerCaptured {
+		s.pointerCaptured = locked
+		sdl.SetRelativeMouseMode(locked)
+		s.viewers.SetPointerLock(locked)
+	}
+
+	// Skip GL work while the preview is being rebuilt or hidden;
+	// the CPU path keeps the stream going. With the output off
+	// there is nothing to do on either.
+	live := s.preview
+	if s.lostReason != "" || s.previewHidden || s.outputOff {
+		live = nil
+	}
+	var gpuCompositor *render.GLCompositor
+	if live != nil {
+		gpuCompositor = live.Compositor
+	}
+
+	// Drop the disconnected clients, then the ones that stopped
+	// reading their socket. New clients join the list meanwhile
+	// without waiting for the frame.
+	connected := s.clients.TakeAdded()
+	disconnected := s.clients.Prune()
+	s.sendGuard.Check(s.clients.Snapshot(), time.Now())
+	frameClients := s.clients.Snapshot()
+
+	s.mu.Lock()
+	// Render the clients to the desktop buffer, or straight into
+	// the GPU compositor's framebuffer.
+	// Fully occluded or off-desktop surfaces are neither
+	// uploaded nor drawn, and their clients' frames are held.
+	placed := s.surfaces.Collect(frameClients, s.visibility.Bounds)
+	if s.xwm != nil {
+		placed = s.xwm.Arrange(s.xwayland.Client, placed)
+	}
+	placed = s.windowStack.Apply(placed)
+	s.workspaces.Assign(placed)
+	placed = s.layout.Apply(placed)
+	shown, away := s.workspaces.Split(placed)
+	visible, hidden := s.visibility.Cull(shown)
+	hidden = append(hidden, away...)
+	// With the output off every surface is hidden: clients are
+	// asked for the smallest buffers and their frames are held
+	if s.outputOff {
+		visible, hidden = nil, append(hidden, visible...)
+	}
+	// The kiosk hides the cursor while the pointer is still
+	if s.kiosk.CursorHidden(time.Now()) {
+		var cursors []PlacedSurface
+		visible, cursors = splitCursors(visible)
+		hidden = append(hidden, cursors...)
+	}
+	// Windows drawn on the model's other meshes keep their size
+	// and frames wherever they are on the desktop
+	var captured []PlacedSurface
+	if !s.outputOff {
+		captured = s.surfaceCapture.Capture(placed)
+		hidden = withoutSurfaces(hidden, captured)
+	}
+	// A locked pointer stays in the top window, or on the desktop
+	lockBounds := topWindowBounds(shown).Intersect(s.visibility.Bounds)
+	if lockBounds.Empty() {
+		lockBounds = s.visibility.Bounds
+	}
+	s.pointerLock.SetBounds(lockBounds)
+	shownClients := slices.Concat(visible, captured)
+	s.bufferHints.Update(shownClients, hidden)
+	s.framePacer.SetHiddenClients(hiddenClients(shownClients, hidden))
+	toplevelChanges := s.toplevels.Update(placed)
+	// Snapshot the outgoing frame before it is overwritten
+	if kind, switched := s.switches.Detect(shown); switched && live != nil && live.Transition != nil {
+		live.Transition.Start(kind, live.DesktopTexture(), time.Now())
+	}
+	below, above := s.widgetLayer.Place(s.visibility.Bounds, time.Now())
+	below = append(below, s.cellDecorations.Place(s.layout.Cells())...)
+	if overlay, ok := s.statsOverlay.Place(s.visibility.Bounds, len(frameClients), s.viewers.StreamStats(), time.Now()); ok {
+		above = append(above, overlay)
+	}
+	above = append(above, s.notifications.Place(s.visibility.Bounds, time.Now())...)
+	if code, ok := s.connectCode.Place(s.visibility.Bounds, time.Now()); ok {
+		above = append(above, code)
+	}
+	// Tiled windows make room for exclusive widgets from the next frame
+	s.layout.SetExclusive(s.widgetLayer.Exclusive())
+	var sourced []PlacedSurface
+	for _, source := range s.sources {
+		sourced = append(sourced, source.Placed(s.visibility.Bounds)...)
+	}
+	composited := slices.Concat(sourced, below, visible, above)
+	frameDesktop := s.desktop
+	s.mu.Unlock()
+
+	// The window list for viewers' taskbars, sent when it changes
+	s.publishWindows(shown)
+
+	var fallback image.Image
+	if frameDesktop.IconImg != nil && frameDesktop.AfterOpeningTimeout() {
+		fallback = frameDesktop.IconImg
+	}
+	// Composite again only when the desktop changed, or moved
+	// between the GPU and the CPU
+	if gpuCompositor != s.compositedOn {
+		s.damage.Invalidate()
+		s.compositedOn = gpuCompositor
+	}
+	dirty := s.damage.Dirty(composited, len(composited) == 0 && fallback != nil) && !s.outputOff
+	// Keep the full frame rate while the desktop changes or the
+	// preview animates
+	if dirty || live != nil {
+		s.renderTicker.Active(time.Now())
+	}
+	s.frameNumber++
+	if gpuCompositor != nil {
+		if dirty {
+			start := time.Now()
+			gpuCompositor.Composite(composited, fallback)
+			s.statsOverlay.Composited(time.Now(), time.Since(start))
+			s.frameVersion++
+		}
+	} else {
+		// The pipeline composites on its own goroutine; carry on
+		// with the newest frame it has finished
+		if dirty {
+			s.desktopPipeline.Submit(frameDesktop, composited)
+		}
+		if s.desktopPipeline.Take(frameDesktop) {
+			s.statsOverlay.Composited(time.Now(), s.desktopPipeline.CompositeTime())
+			s.frameVersion++
+			s.bufferVersion = s.frameVersion
+			s.desktopSource.Publish(frameDesktop)
+		}
+	}
+
+	// Let clients know the frame they submitted has been used,
+	// and let the sources draw into the frames placed again, once
+	// the pipeline has composited it
+	if gpuCompositor != nil || !s.desktopPipeline.Busy() {
+		s.framePacer.Flush(time.Now(), s.sendGuard)
+		for _, source := range s.sources {
+			source.Reclaim()
+		}
+	}
+
+	// Handlers run outside the desktop lock
+	for _, c := range connected {
+		s.events.clientConnected.emit(ClientConnectedEvent{Client: c})
+	}
+	for _, event := range toplevelChanges.Unmapped {
+		s.events.toplevelUnmapped.emit(event)
+	}
+	for _, c := range disconnected {
+		s.events.clientDisconnected.emit(ClientDisconnectedEvent{Client: c})
+		s.framePacer.Forget(c)
+		s.decorations.Forget(c)
+		releaseClient(c)
+	}
+	for _, event := range toplevelChanges.Mapped {
+		s.events.toplevelMapped.emit(event)
+	}
+	for _, event := range toplevelChanges.Changed {
+		s.events.toplevelChanged.emit(event)
+	}
+	for _, p := range s.damage.Committed() {
+		s.events.surfaceCommitted.emit(SurfaceCommittedEvent{Client: p.Client, SurfaceID: p.SurfaceID, Frame: s.frameNumber})
+	}
+	s.events.frameComposited.emit(FrameCompositedEvent{
+		Frame:   s.frameNumber,
+		Time:    time.Now(),
+		Visible: len(visible),
+		Hidden:  len(hidden),
+	})
+
+	s.publish(live, gpuCompositor)
+	if live != nil {
+		s.drawPreview(live, gpuCompositor)
+	}
+
+	s.frameCount++
+	if time.Since(s.lastLog) >= 5*time.Second {
+		log.Printf("Rendered %d frames. Wayland clients: %d, WebSocket clients: %d",
+			s.frameCount, s.clients.Len(), s.viewers.WebSocketClientCount())
+		s.frameCount = 0
+		s.lastLog = time.Now()
+	}
+}
+
+// publish hands the composited desktop to the viewers, the sinks, the
+// renderers and the recorder, reading it back from the GPU first when
+// they need it on the CPU
+func (s *runState) publish(live *render.Preview, gpuCompositor *render.GLCompositor) {
+	// Streaming and screenshots still need the frame on the CPU
+	screenshotPending := s.viewers.ScreenshotPending()
+	mjpegDue := s.viewers.MJPEGDue(time.Now())
+	screencopyPending := s.screencopy.Pending()
+	needCPU := s.viewers.WebSocketClientCount() > 0 || screenshotPending || mjpegDue || s.recorder != nil || s.screenComposer.Active() || screencopyPending
+	if gpuCompositor != nil && needCPU && s.bufferVersion != s.frameVersion {
+		gpuCompositor.ReadPixels(s.desktop.Buffer)
+		s.bufferVersion = s.frameVersion
+		s.desktopSource.Publish(s.desktop)
+	}
+	if screenshotPending {
+		s.viewers.ServeScreenshots(s.desktop.Buffer, s.desktop.Width, s.desktop.Height, s.desktop.Stride)
+	}
+	if screencopyPending {
+		s.screencopy.Fulfil(s.desktop.Buffer, s.desktop.Width, s.desktop.Height, s.desktop.Stride)
+	}
+
+	// Follow the screen's color whenever the frame is on the CPU,
+	// and read it back for the effects now and then when it is not
+	onCPU := gpuCompositor == nil || needCPU
+	if !onCPU && (s.options.screenGlow > 0 || s.options.screenReact > 0) && s.screenAnalyzer.Due(time.Now()) {
+		if s.bufferVersion != s.frameVersion {
+			gpuCompositor.ReadPixels(s.desktop.Buffer)
+			s.bufferVersion = s.frameVersion
+			s.desktopSource.Publish(s.desktop)
+		}
+		onCPU = true
+	}
+	if onCPU {
+		s.screenAnalyzer.Update(s.desktop.Buffer, s.desktop.Width, s.desktop.Height, s.desktop.Stride, time.Now())
+	}
+
+	isIdle, idleChanged := s.idle.Update(time.Now())
+	if idleChanged && live != nil {
+		if isIdle {
+			log.Println("Session idle, starting screensaver")
+			s.screensaver.Start(live.Renderer)
+		} else {
+			log.Println("Input received, leaving screensaver")
+			s.screensaver.Stop(live.Renderer)
+		}
+	}
+
+	s.setStreamStatus(isIdle)
+
+	// Broadcast desktop buffer to WebSocket and MJPEG clients.
+	// WebSocket viewers keep the last frame, so it is only sent
+	// again to viewers that joined since.
+	if mjpegDue && !isIdle && !s.outputOff {
+		s.viewers.PublishMJPEG(s.desktop.Buffer, s.desktop.Width, s.desktop.Height, s.desktop.Stride, time.Now())
+	}
+	if !isIdle && !s.outputOff {
+		for _, sink := range s.sinks {
+			sink.Stream(s.desktopSource)
+		}
+	}
+	for _, renderer := range s.c.renderers {
+		renderer.Render(s.desktopSource, time.Now())
+	}
+	if s.recorder != nil {
+		s.recorder.Frame(s.desktopSource, time.Now())
+	}
+	s.compositeSessions(time.Now())
+}
+
+// drawPreview draws the model, or the flat desktop, in the preview window
+func (s *runState) drawPreview(live *render.Preview, gpuCompositor *render.GLCompositor) {
+	glbRenderer := live.Renderer
+
+	// Swap in the next playlist model once it is preloaded
+	if s.playlist != nil {
+		if path, doc, ok := s.playlist.Poll(time.Now()); ok {
+			if err := live.SwapModel(doc); err != nil {
+				log.Printf("Playlist: failed to load %s: %v", path, err)
+			} else {
+				log.Printf("Playlist: showing %s (%d meshes)", path, len(glbRenderer.Meshes))
+				s.previewOptions.ModelPath = path
+				if s.hotReload != nil {
+					s.hotReload.SetModel(path)
+				}
+			}
+		}
+	}
+
+	// Pick up edits to the model and shaders with -watch
+	if s.hotReload != nil {
+		path, doc, shadersChanged := s.hotReload.Poll(time.Now())
+		if doc != nil {
+			if err := live.SwapModel(doc); err != nil {
+				log.Printf("Hot reload: failed to load %s: %v", path, err)
+			} else {
+				log.Printf("Hot reload: reloaded %s (%d meshes)", path, len(glbRenderer.Meshes))
+			}
+		}
+		if shadersChanged {
+			if err := glbRenderer.QueueShaderDir(s.options.shaderDir); err != nil {
+				log.Printf("Hot reload: keeping the current shaders: %v", err)
+			} else {
+				log.Printf("Hot reload: compiling the shaders in %s", s.options.shaderDir)
+			}
+		}
+	}
+
+	// Switch scenes on request
+	select {
+	case selector := <-s.sceneRequests:
+		if err := glbRenderer.SetScene(selector); err != nil {
+			log.Printf("Failed to switch scene: %v", err)
+		} else {
+			log.Printf("Showing scene %d (%d meshes)", glbRenderer.Scene, len(glbRenderer.Meshes))
+			s.previewOptions.Scene = selector
+		}
+	default:
+	}
+
+	// Show an expression named on the -mqtt topic
+	select {
+	case name := <-s.expressionRequests:
+		if e, ok := s.options.expressions[name]; ok {
+			glbRenderer.ApplyExpression(e)
+			log.Printf("Expression %q applied", name)
+		} else {
+			log.Printf("MQTT expression %q: no such expression, have %v", name, s.options.expressions.Names())
+		}
+	default:
+	}
+
+	// Update texture with the desktop, or the screen layout it is
+	// in, when it changed; a rebuilt preview's renderer has not
+	// uploaded it yet
+	if s.screenComposer.Active() {
+		s.screenComposer.Compose(s.desktopSource, image.Pt(s.desktop.Width, s.desktop.Height))
+		glbRenderer.UploadSource(s.screenComposer)
+	} else if gpuCompositor == nil {
+		glbRenderer.UploadSource(s.desktopSource)
+	}
+	glbRenderer.SetNodeTextures(s.surfaceCapture.Sources())
+
+	// Rotate the model slowly, faster on a bright screen, or as the sync
+	// leader does, and glow in the screen's color
+	if live.Flat == nil {
+		screen := s.screenAnalyzer.Stats()
+		if s.syncFollower == nil || !s.syncFollower.Apply(glbRenderer, time.Now()) {
+			glbRenderer.Rotation += float32(s.options.rotationSpeed * (1 + s.options.screenReact*float64(screen.Luminance)))
+		}
+		if s.syncLeader != nil {
+			s.syncLeader.Tick(glbRenderer, time.Now())
+		}
+		glbRenderer.Glow = float32(s.options.screenGlow)
+		glbRenderer.GlowColor = screen.Color
+
+		// Pulse to the bass and glow with the music; the audio glow is
+		// white unless the screen colors it
+		if s.options.audioCapture != "" {
+			audio := s.audioAnalyzer.Update(time.Now())
+			glbRenderer.Pulse = float32(s.options.audioPulse) * audio.Bass
+			glbRenderer.Glow += float32(s.options.audioGlow) * audio.Level
+			if s.options.screenGlow <= 0 {
+				glbRenderer.GlowColor = [3]float32{1, 1, 1}
+			}
+		}
+	}
+
+	// The screen layout is drawn into the renderer's own texture; the
+	// power governor may turn post-processing off
+	frame := render.PreviewFrame{
+		DesktopWidth:  int32(s.desktop.Width),
+		DesktopHeight: int32(s.desktop.Height),
+		ScreenShaders: s.screenShaderToggles.Enabled(),
+		Particles:     s.particleSystem.Active(time.Now()),
+		PostProcess:   s.powerLevel.PostProcess(),
+		Backlight:     s.backlight.Level(time.Now()),
+	}
+	if s.screenComposer.Active() {
+		frame.Screen = glbRenderer.TextureID
+	}
+	live.Draw(frame, time.Now())
+
+	for _, e := range glbRenderer.TakePlaybackEvents() {
+		s.events.animationPlayback.emit(e)
+	}
+
+	if reason := live.Lost(); reason != "" {
+		log.Printf("Preview window lost: %s", reason)
+		s.lostReason = reason
+	}
+}
@@ -0,0 +1,75 @@
+//go:build !nogl && !nosdl && noweb
+
+package compositor
+
+import (
+	"flag"
+	"time"
+
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+// webOptions are the web server's settings, which this build leaves out
+type webOptions struct{}
+
+// bind defines no flags
+func (o *webOptions) bind(flags *flag.FlagSet) {}
+
+// runWeb is the web server, which this build leaves out
+type runWeb struct{}
+
+// startWeb serves no viewers; the desktop still goes to the -output sinks
+func (s *runState) startWeb(sockets SystemdSockets) error {
+	s.viewers = noViewers{}
+	return nil
+}
+
+// viewerSinks returns no sinks
+func (s *runState) viewerSinks() []OutputSink {
+	return nil
+}
+
+// viewerInput returns nil: viewer input messages are read by the web
+// server
+func (s *runState) viewerInput() func(message []byte) {
+	return nil
+}
+
+// handleViewerInput does nothing; there are no viewers
+func (s *runState) handleViewerInput() {}
+
+// startControlAPI does nothing; there is no control API to serve
+func (s *runState) startControlAPI() {}
+
+// runControlRequests does nothing
+func (s *runState) runControlRequests() {}
+
+// stopViewers does nothing
+func (s *runState) stopViewers() {}
+
+// resyncSessions does nothing; there are no extra desktops
+func (s *runState) resyncSessions() {}
+
+// compositeSessions does nothing
+func (s *runState) compositeSessions(now time.Time) {}
+
+// publishWindows does nothing
+func (s *runState) publishWindows(shown []PlacedSurface) {}
+
+// setStreamStatus does nothing
+func (s *runState) setStreamStatus(idle bool) {}
+
+// noViewers are the viewers of a build without the web server: there are
+// none, so nothing is wanted of the render loop
+type noViewers struct{}
+
+func (noViewers) Resync()                                                   {}
+func (noViewers) SetQualityFloor(floor int)                                 {}
+func (noViewers) SetPointerLock(locked bool)                                {}
+func (noViewers) StreamStats() []stream.ViewerStreamStats                   { return nil }
+func (noViewers) WebSocketClientCount() int                                 { return 0 }
+func (noViewers) ScreenshotPending() bool                                   { return false }
+func (noViewers) ServeScreenshots(buffer []byte, width, height, stride int) {}
+func (noViewers) MJPEGDue(now time.Time) bool                               { return false }
+func (noViewers) PublishMJPEG(buffer []byte, width, height, stride int, now time.Time) {
+}
@@ -0,0 +1,311 @@
+//go:build !nogl && !nosdl && !noweb
+
+package compositor
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+// webOptions are the full compositor's settings for its web server
+type webOptions struct {
+	httpAddr         string
+	apiToken         string
+	apiTokenFile     string
+	modelDir         string
+	staticDir        string
+	mjpegQuality     int
+	mjpegFPS         int
+	playoutDelay     time.Duration
+	viewerTokensPath string
+	inputControl     string
+	restartRetry     time.Duration
+	connectQR        time.Duration
+	connectURL       string
+}
+
+// bind defines the web server's flags on flags, with their defaults
+func (o *webOptions) bind(flags *flag.FlagSet) {
+	flags.StringVar(&o.httpAddr, "http", defaultHTTPAddr, "HTTP server address, or unix:<path> for a Unix socket; use :8080 to serve other machines")
+	flags.StringVar(&o.apiToken, "api-token", "", "Bearer token /api/v1 requests must carry (default: the one in -api-token-file)")
+	flags.StringVar(&o.apiTokenFile, "api-token-file", defaultAPITokenFile(), "File holding the bearer token /api/v1 requests must carry, created with a random one if missing")
+	flags.StringVar(&o.modelDir, "model-dir", "", "Directory POST /api/v1/model may open model files from by path; without it only builtin models and uploads are taken")
+	flags.StringVar(&o.staticDir, "static", "", "Static files directory to serve instead of the built-in viewer")
+	flags.IntVar(&o.mjpegQuality, "mjpeg-quality", 75, "Default JPEG quality of /stream.mjpeg, 1-100")
+	flags.IntVar(&o.mjpegFPS, "mjpeg-fps", 10, "Default frame rate of /stream.mjpeg, 1-60")
+	flags.DurationVar(&o.playoutDelay, "stream-playout-delay", stream.DefaultPlayoutDelay, "How long after capture viewers opened with ?sync show each frame, so they all show it at once")
+	flags.StringVar(&o.viewerTokensPath, "viewer-tokens", "", "JSON file viewers' reconnect tokens are kept in, so viewers get their role and subscriptions back after a restart")
+	flags.StringVar(&o.inputControl, "input-control", stream.ControlShared, "Whose input viewers send goes to the clients: shared (every controller's) or exclusive (only the viewer holding control, which the others ask for)")
+	flags.DurationVar(&o.restartRetry, "restart-retry", 2*time.Second, "How long viewers are told to wait before reconnecting when the compositor shuts down")
+	flags.DurationVar(&o.connectQR, "connect-qr", 0, "Show a QR code of the viewer URL over the desktop for this long after starting, so phones and tablets can join without typing it")
+	flags.StringVar(&o.connectURL, "connect-url", "", "URL the connect QR code and /connect.png open, e.g. one through a proxy with its access token; defaults to the -http address at this machine's LAN address")
+}
+
+// runWeb is the full compositor's web server: the viewer, the control API
+// and the extra desktops' sessions
+type runWeb struct {
+	httpServer *HTTPServer
+	control    *ControlAPI
+	sessions   *SessionManager
+	// uploadedModel is the file of the model last uploaded through the
+	// control API
+	uploadedModel string
+}
+
+// startWeb starts the HTTP server, on the socket systemd passed or the
+// primary's when taking over from it
+func (s *runState) startWeb(sockets SystemdSockets) error {
+	s.httpServer = NewHTTPServer(s.options.httpAddr, s.options.staticDir)
+	s.viewers = s.httpServer
+	switch {
+	case sockets.HTTP != nil:
+		s.httpServer.SetListener(sockets.HTTP)
+	case s.standby != nil:
+		listener, err := s.standby.Listen(s.options.httpAddr)
+		if err != nil {
+			return fmt.Errorf("failed to take over -http from the primary: %w", err)
+		}
+		s.httpServer.SetListener(listener)
+	}
+	if err := s.httpServer.ConfigureMJPEG(s.options.mjpegQuality, s.options.mjpegFPS); err != nil {
+		return fmt.Errorf("-mjpeg-%w", err)
+	}
+	s.httpServer.SetPlayoutDelay(s.options.playoutDelay)
+	controlToken, generated, err := loadAPIToken(s.options.apiToken, s.options.apiTokenFile)
+	if err != nil {
+		return fmt.Errorf("invalid -api-token-file: %w", err)
+	}
+	s.httpServer.SetAPIToken(controlToken)
+	if generated {
+		fmt.Printf("Control API token: %s\n", controlToken)
+	}
+	if s.options.viewerTokensPath != "" {
+		tokens, err := stream.NewViewerTokens(s.options.viewerTokensPath)
+		if err != nil {
+			return fmt.Errorf("invalid -viewer-tokens: %w", err)
+		}
+		s.httpServer.SetViewerTokens(tokens)
+	}
+	switch s.options.inputControl {
+	case stream.ControlShared:
+	case stream.ControlExclusive:
+		s.httpServer.SetExclusiveControl(true)
+	default:
+		return fmt.Errorf("invalid -input-control %q, want %s or %s", s.options.inputControl, stream.ControlShared, stream.ControlExclusive)
+	}
+	// Phones and tablets join by scanning a QR code of the viewer's URL
+	joinURL := s.options.connectURL
+	if joinURL == "" {
+		joinURL = connectURL(s.httpServer.Addr(), lanAddress())
+	}
+	s.connectCode, err = NewConnectCode(joinURL)
+	if err != nil {
+		return fmt.Errorf("invalid -connect-url: %w", err)
+	}
+	s.httpServer.HandleFunc("GET /connect.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(s.connectCode.PNG())
+	})
+	if s.options.connectQR > 0 {
+		s.connectCode.Show(s.options.connectQR, time.Now())
+		log.Printf("Showing the connect code for %s", joinURL)
+	}
+	if err := s.httpServer.Start(); err != nil {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
+	s.onClose(func() { s.httpServer.Stop() })
+	return nil
+}
+
+// viewerSinks returns the viewers' sink, which takes each frame with the
+// -output sinks
+func (s *runState) viewerSinks() []OutputSink {
+	return []OutputSink{s.httpServer.Broadcaster()}
+}
+
+// viewerInput returns what sends viewer input messages to the clients, for
+// -replay-input and -terminal
+func (s *runState) viewerInput() func(message []byte) {
+	return s.httpServer.Inject
+}
+
+// handleViewerInput sends viewers' keys, text and pointer to the clients,
+// and their joining and taking control to the events and notifications
+func (s *runState) handleViewerInput() {
+	s.httpServer.SetViewerJoinedHandler(func(remoteAddr string, viewers int) {
+		s.events.viewerJoined.emit(ViewerJoinedEvent{RemoteAddr: remoteAddr, Viewers: viewers})
+	})
+	s.httpServer.SetControlChangedHandler(func(holder int) {
+		if holder != 0 {
+			s.notify(fmt.Sprintf("Viewer %d took control", holder))
+		}
+	})
+	if s.recorder != nil {
+		s.httpServer.SetInputObserver(func(message []byte) {
+			s.recorder.Input(message, time.Now())
+		})
+	}
+
+	// Set up keyboard handler for WebSocket input
+	s.httpServer.SetKeyboardHandler(func(keycode uint32, pressed bool) {
+		s.idle.Activity(time.Now())
+		s.renderTicker.Wake()
+		if s.shortcuts.Handle(keycode, pressed) {
+			return
+		}
+		activeClients := s.sendGuard.Writable(s.clients.Snapshot())
+		if keycode != 0 {
+			s.sendGuard.SendKeyboardKey(activeClients, keycode, pressed)
+		}
+	})
+	// Text from a viewer's IME or virtual keyboard is typed as key presses
+	s.httpServer.SetTextHandler(func(text string) {
+		s.idle.Activity(time.Now())
+		s.renderTicker.Wake()
+		events, dropped := textKeyEvents(text)
+		if dropped > 0 {
+			log.Printf("Dropped %d typed characters the keymap has no key for", dropped)
+		}
+		activeClients := s.sendGuard.Writable(s.clients.Snapshot())
+		for _, e := range events {
+			s.sendGuard.SendKeyboardKey(activeClients, e.Keycode, e.Pressed)
+		}
+	})
+
+	// Set up pointer handler for WebSocket input
+	s.httpServer.SetPointerHandler(func(e stream.PointerEvent) {
+		s.idle.Activity(time.Now())
+		s.kiosk.PointerMoved(time.Now())
+		s.renderTicker.Wake()
+		activeClients := s.sendGuard.Writable(s.clients.Snapshot())
+		switch e.Type {
+		case stream.InputPointerMotion:
+			x, y := s.pointerLock.Warp(e.X, e.Y)
+			s.layout.SendPointerMotion(s.sendGuard, activeClients, x, y)
+		case stream.InputPointerRelative:
+			x, y := s.pointerLock.Move(e.X, e.Y)
+			s.layout.SendPointerMotion(s.sendGuard, activeClients, x, y)
+		case stream.InputPen:
+			x, y := s.pointerLock.Warp(e.X, e.Y)
+			s.layout.SendPointerMotion(s.sendGuard, activeClients, x, y)
+			for _, b := range s.pen.Update(e) {
+				if s.xwm != nil && b.Pressed {
+					s.xwm.FocusAt(int(x), int(y))
+				}
+				s.sendGuard.SendPointerButton(activeClients, b.Button, b.Pressed)
+			}
+		case stream.InputPointerButton:
+			if s.xwm != nil && e.Pressed {
+				x, y := s.pointerLock.Position()
+				s.xwm.FocusAt(int(x), int(y))
+			}
+			s.sendGuard.SendPointerButton(activeClients, e.Button, e.Pressed)
+		case stream.InputPointerAxis:
+			s.sendGuard.SendPointerAxis(activeClients, e.Axis, e.Value)
+		}
+	})
+}
+
+// startControlAPI serves the /api/v1 control surface, the playlist and
+// scene endpoints and the extra desktops
+func (s *runState) startControlAPI() {
+	// Let signage controllers skip to the next model
+	if s.playlist != nil {
+		s.httpServer.HandleFunc("/playlist/next", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "use POST", http.StatusMethodNotAllowed)
+				return
+			}
+			s.playlist.Next()
+			w.WriteHeader(http.StatusAccepted)
+		})
+	}
+
+	// Let controllers switch between the scenes of a multi-scene model.
+	// Scenes are switched on the render loop, which owns the GL context.
+	s.httpServer.HandleFunc("/scene", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		select {
+		case s.sceneRequests <- r.URL.Query().Get("scene"):
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.Error(w, "a scene switch is already pending", http.StatusServiceUnavailable)
+		}
+	})
+
+	s.control = NewControlAPI()
+	s.control.Wake = s.renderTicker.Wake
+	s.control.Decorations = s.decorations
+	s.httpServer.HandleFunc("GET /api/v1/events", s.control.ServeEvents(s.events))
+
+	// Extra desktops, each on its own Wayland display and /ws/<name> stream
+	s.sessions = NewSessionManager(http.HandlerFunc(s.httpServer.ServeWebSocket), s.options.clientQueue, s.options.clientTimeout, createIcon())
+	s.sessions.SetPlayoutDelay(s.options.playoutDelay)
+	s.sessions.SetWake(s.renderTicker.Wake)
+	s.sessions.SetMaxClients(s.options.maxClients)
+	s.sessions.SetTracer(s.tracer)
+	s.sessions.SetScreencopy(s.options.screencopy)
+	s.onClose(s.sessions.Close)
+	s.httpServer.HandleFunc("GET /ws/{session}", s.sessions.ServeWebSocket)
+
+	// A model uploaded through the control API is kept while it is shown
+	s.onClose(func() {
+		if s.uploadedModel != "" {
+			os.Remove(s.uploadedModel)
+		}
+	})
+
+	s.handleControlAPI()
+}
+
+// runControlRequests runs the control API requests queued since the last
+// frame
+func (s *runState) runControlRequests() {
+	s.control.RunPending()
+}
+
+// stopViewers tells the viewers to reconnect after -restart-retry
+func (s *runState) stopViewers() {
+	s.httpServer.Restarting(s.options.restartRetry)
+}
+
+// resyncSessions sends the extra desktops' viewers a keyframe
+func (s *runState) resyncSessions() {
+	s.sessions.Resync()
+}
+
+// compositeSessions composites the extra desktops
+func (s *runState) compositeSessions(now time.Time) {
+	s.sessions.Composite(now)
+}
+
+// publishWindows sends the window list to the viewers' taskbars when it
+// changed
+func (s *runState) publishWindows(shown []PlacedSurface) {
+	if list, thumbnails := s.httpServer.WindowsWanted(); list {
+		s.httpServer.PublishWindows(s.windowList.Describe(shown, s.control.ClientID, thumbnails, time.Now()))
+	}
+}
+
+// setStreamStatus tells viewers why the stream stops changing
+func (s *runState) setStreamStatus(idle bool) {
+	switch {
+	case s.outputOff:
+		s.httpServer.SetStreamStatus(stream.StreamOff)
+	case idle:
+		s.httpServer.SetStreamStatus(stream.StreamPaused)
+	case s.lostReason != "" || (s.hotReload != nil && s.hotReload.Parsing()):
+		s.httpServer.SetStreamStatus(stream.StreamReloading)
+	default:
+		s.httpServer.SetStreamStatus(stream.StreamLive)
+	}
+}
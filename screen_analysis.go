@@ -0,0 +1,100 @@
+package compositor
+
+import (
+	"math"
+	"time"
+)
+
+// screenSampleStep samples every 8th pixel of every 8th row, which follows
+// the screen's mood closely enough at 1/64 of the cost
+const screenSampleStep = 8
+
+// ScreenStats summarises the composited desktop, with channels from 0 to 1
+type ScreenStats struct {
+	Color     [3]float32 `json:"color"`
+	Luminance float32    `json:"luminance"`
+}
+
+// analyzeScreen averages every step'th pixel of every step'th row of an
+// RGBA buffer
+func analyzeScreen(buffer []byte, width, height, stride, step int) ScreenStats {
+	var sum [3]uint64
+	var n uint64
+	for y := 0; y < height; y += step {
+		row := y * stride
+		for x := 0; x < width; x += step {
+			i := row + x*4
+			if i+3 > len(buffer) {
+				break
+			}
+			sum[0] += uint64(buffer[i])
+			sum[1] += uint64(buffer[i+1])
+			sum[2] += uint64(buffer[i+2])
+			n++
+		}
+	}
+	var stats ScreenStats
+	if n == 0 {
+		return stats
+	}
+	for c := range sum {
+		stats.Color[c] = float32(sum[c]) / float32(n) / 255
+	}
+	stats.Luminance = luminance(stats.Color)
+	return stats
+}
+
+// luminance weighs the channels by how bright they look (Rec. 709)
+func luminance(c [3]float32) float32 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
+
+// ScreenAnalyzer follows the desktop's average color and brightness,
+// smoothed so effects driven by them fade instead of flickering when
+// windows change
+type ScreenAnalyzer struct {
+	// Smoothing is how long the stats take to follow about 63% of a change
+	Smoothing time.Duration
+	// Interval is how often Due asks for a frame, for when getting the
+	// frame onto the CPU costs a GPU readback
+	Interval time.Duration
+
+	stats   ScreenStats
+	updated time.Time
+}
+
+// NewScreenAnalyzer creates an analyzer that follows changes over a
+// quarter second and asks for readbacks ten times a second
+func NewScreenAnalyzer() *ScreenAnalyzer {
+	return &ScreenAnalyzer{
+		Smoothing: 250 * time.Millisecond,
+		Interval:  100 * time.Millisecond,
+	}
+}
+
+// Due reports whether the analyzer wants a new frame
+func (a *ScreenAnalyzer) Due(now time.Time) bool {
+	return now.Sub(a.updated) >= a.Interval
+}
+
+// Update folds a composited frame into the stats and returns them. The
+// first frame is taken as is.
+func (a *ScreenAnalyzer) Update(buffer []byte, width, height, stride int, now time.Time) ScreenStats {
+	frame := analyzeScreen(buffer, width, height, stride, screenSampleStep)
+	if a.updated.IsZero() || a.Smoothing <= 0 {
+		a.stats = frame
+	} else {
+		k := float32(1 - math.Exp(-float64(now.Sub(a.updated))/float64(a.Smoothing)))
+		for c := range frame.Color {
+			a.stats.Color[c] += (frame.Color[c] - a.stats.Color[c]) * k
+		}
+		a.stats.Luminance += (frame.Luminance - a.stats.Luminance) * k
+	}
+	a.updated = now
+	return a.stats
+}
+
+// Stats returns the current smoothed stats
+func (a *ScreenAnalyzer) Stats() ScreenStats {
+	return a.stats
+}
@@ -0,0 +1,54 @@
+package compositor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func solidBuffer(width, height int, r, g, b byte) []byte {
+	buffer := make([]byte, width*height*4)
+	for i := 0; i < len(buffer); i += 4 {
+		buffer[i], buffer[i+1], buffer[i+2], buffer[i+3] = r, g, b, 255
+	}
+	return buffer
+}
+
+func TestAnalyzeScreen(t *testing.T) {
+	stats := analyzeScreen(solidBuffer(64, 32, 255, 0, 51), 64, 32, 64*4, screenSampleStep)
+	want := [3]float32{1, 0, 0.2}
+	for c := range want {
+		if math.Abs(float64(stats.Color[c]-want[c])) > 1e-5 {
+			t.Errorf("Color = %v, want %v", stats.Color, want)
+			break
+		}
+	}
+	if got := analyzeScreen(solidBuffer(16, 16, 255, 255, 255), 16, 16, 64, 1).Luminance; math.Abs(float64(got-1)) > 1e-5 {
+		t.Errorf("white Luminance = %v, want 1", got)
+	}
+	if got := analyzeScreen(nil, 0, 0, 0, 1); got != (ScreenStats{}) {
+		t.Errorf("empty buffer = %+v, want zero stats", got)
+	}
+}
+
+func TestScreenAnalyzerSmoothing(t *testing.T) {
+	a := NewScreenAnalyzer()
+	now := time.Unix(0, 0)
+	black := solidBuffer(8, 8, 0, 0, 0)
+	white := solidBuffer(8, 8, 255, 255, 255)
+
+	if got := a.Update(white, 8, 8, 32, now).Luminance; math.Abs(float64(got-1)) > 1e-5 {
+		t.Fatalf("first frame Luminance = %v, want it taken as is", got)
+	}
+	now = now.Add(a.Smoothing)
+	got := a.Update(black, 8, 8, 32, now).Luminance
+	if want := float32(math.Exp(-1)); math.Abs(float64(got-want)) > 1e-3 {
+		t.Errorf("after one time constant Luminance = %v, want %v", got, want)
+	}
+	if a.Due(now) {
+		t.Error("Due right after an update")
+	}
+	if !a.Due(now.Add(a.Interval)) {
+		t.Error("not Due after Interval")
+	}
+}
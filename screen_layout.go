@@ -0,0 +1,375 @@
+package compositor
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// ScreenLayoutNode is one box of a -screen-layout: a source, or boxes side
+// by side or stacked, with pictures in picture over it
+type ScreenLayoutNode struct {
+	// Source is "desktop" or a -source spec: testcard, video:<file> or
+	// camera:<device>
+	Source string `json:"source,omitempty"`
+	// Row splits the box into boxes side by side, Column into boxes
+	// stacked top to bottom
+	Row    []ScreenLayoutNode `json:"row,omitempty"`
+	Column []ScreenLayoutNode `json:"column,omitempty"`
+	// Size is the percentage of a row's width or a column's height the box
+	// takes, or of its box's width a picture in picture takes. Boxes in a
+	// row or column without one share what is left.
+	Size float64 `json:"size,omitempty"`
+	// PiP are boxes drawn over this one, in the order given
+	PiP []ScreenLayoutNode `json:"pip,omitempty"`
+	// Anchor is the corner or edge a picture in picture sits in, as for
+	// widgets, and Margin its distance from it in percent of the box's
+	// width
+	Anchor string  `json:"anchor,omitempty"`
+	Margin float64 `json:"margin,omitempty"`
+}
+
+// ScreenLayout is the -screen-layout flag: the boxes the model's screen is
+// composited from, given as JSON. Without one the screen shows the
+// desktop.
+type ScreenLayout struct {
+	Root *ScreenLayoutNode
+}
+
+// String returns the layout as JSON
+func (l *ScreenLayout) String() string {
+	if l == nil || l.Root == nil {
+		return ""
+	}
+	data, _ := json.Marshal(l.Root)
+	return string(data)
+}
+
+// Set parses and validates the layout, replacing the current one
+func (l *ScreenLayout) Set(value string) error {
+	root, err := parseScreenLayout(value)
+	if err != nil {
+		return err
+	}
+	l.Root = root
+	return nil
+}
+
+// parseScreenLayout parses a JSON layout tree, filling in defaults. An
+// empty value is no layout.
+func parseScreenLayout(value string) (*ScreenLayoutNode, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var root ScreenLayoutNode
+	if err := json.Unmarshal([]byte(value), &root); err != nil {
+		return nil, fmt.Errorf("parse screen layout: %w", err)
+	}
+	if err := root.validate("layout", false); err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+// validate checks a node and its children, named by path in errors
+func (n *ScreenLayoutNode) validate(path string, pip bool) error {
+	kinds := 0
+	for _, set := range []bool{n.Source != "", len(n.Row) > 0, len(n.Column) > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("%s: want exactly one of source, row or column", path)
+	}
+	if n.Source != "" && !validScreenSource(n.Source) {
+		return fmt.Errorf("%s: unknown source %q, want desktop, testcard, video:<file> or camera:<device>", path, n.Source)
+	}
+	if n.Size < 0 || n.Size > 100 || math.IsNaN(n.Size) {
+		return fmt.Errorf("%s: size must be between 0 and 100", path)
+	}
+	if pip {
+		if n.Size == 0 {
+			n.Size = 25
+		}
+		if n.Anchor == "" {
+			n.Anchor = "bottom-right"
+		}
+		if _, ok := widgetAnchors[n.Anchor]; !ok {
+			return fmt.Errorf("%s: unknown anchor %q", path, n.Anchor)
+		}
+		if n.Margin < 0 || n.Margin > 25 {
+			return fmt.Errorf("%s: margin must be between 0 and 25", path)
+		}
+	} else if n.Anchor != "" || n.Margin != 0 {
+		return fmt.Errorf("%s: only a picture in picture has an anchor and margin", path)
+	}
+	for _, split := range []struct {
+		name     string
+		children []ScreenLayoutNode
+	}{{"row", n.Row}, {"column", n.Column}} {
+		name, children := split.name, split.children
+		total := 0.0
+		for i := range children {
+			if err := children[i].validate(fmt.Sprintf("%s.%s[%d]", path, name, i), false); err != nil {
+				return err
+			}
+			total += children[i].Size
+		}
+		if total > 100 {
+			return fmt.Errorf("%s.%s: sizes add up to %g%%, over 100", path, name, total)
+		}
+	}
+	for i := range n.PiP {
+		if err := n.PiP[i].validate(fmt.Sprintf("%s.pip[%d]", path, i), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validScreenSource reports whether spec names a source a layout can show
+func validScreenSource(spec string) bool {
+	return spec == "desktop" || spec == "testcard" ||
+		strings.HasPrefix(spec, "video:") || strings.HasPrefix(spec, "camera:")
+}
+
+// opaqueBlack fills the screen around and behind a layout's sources
+var opaqueBlack = color.RGBA{A: 255}
+
+// screenBox is where a layout draws one source
+type screenBox struct {
+	source string
+	rect   image.Rectangle
+}
+
+// boxes lays the node out in rect, returning its sources' boxes in the
+// order they are drawn, bottom first
+func (n *ScreenLayoutNode) boxes(rect image.Rectangle) []screenBox {
+	var boxes []screenBox
+	switch {
+	case n.Source != "":
+		boxes = append(boxes, screenBox{n.Source, rect})
+	case len(n.Row) > 0:
+		for i, r := range splitSpans(n.Row, rect.Min.X, rect.Max.X) {
+			boxes = append(boxes, n.Row[i].boxes(image.Rect(r[0], rect.Min.Y, r[1], rect.Max.Y))...)
+		}
+	case len(n.Column) > 0:
+		for i, r := range splitSpans(n.Column, rect.Min.Y, rect.Max.Y) {
+			boxes = append(boxes, n.Column[i].boxes(image.Rect(rect.Min.X, r[0], rect.Max.X, r[1]))...)
+		}
+	}
+	for _, pip := range n.PiP {
+		w := int(float64(rect.Dx()) * pip.Size / 100)
+		if w <= 0 || rect.Dx() <= 0 {
+			continue
+		}
+		size := image.Pt(w, w*rect.Dy()/rect.Dx())
+		margin := int(float64(rect.Dx()) * pip.Margin / 100)
+		boxes = append(boxes, pip.boxes(anchorRect(pip.Anchor, size, rect.Inset(margin), 0))...)
+	}
+	return boxes
+}
+
+// splitSpans divides from to to between children by their sizes, the
+// unsized ones sharing what is left equally
+func splitSpans(children []ScreenLayoutNode, from, to int) [][2]int {
+	sized, unsized := 0.0, 0
+	for _, c := range children {
+		if c.Size > 0 {
+			sized += c.Size
+		} else {
+			unsized++
+		}
+	}
+	share := 0.0
+	if unsized > 0 {
+		share = max(0, 100-sized) / float64(unsized)
+	}
+	length := float64(to - from)
+	spans := make([][2]int, len(children))
+	at := 0.0
+	for i, c := range children {
+		size := c.Size
+		if size == 0 {
+			size = share
+		}
+		start := from + int(math.Round(at*length/100))
+		at += size
+		spans[i] = [2]int{start, from + int(math.Round(at*length/100))}
+	}
+	return spans
+}
+
+// sources returns the specs of the sources the node and its children
+// show, other than the desktop
+func (n *ScreenLayoutNode) sources() []string {
+	var specs []string
+	if n.Source != "" && n.Source != "desktop" {
+		specs = append(specs, n.Source)
+	}
+	for _, children := range [][]ScreenLayoutNode{n.Row, n.Column, n.PiP} {
+		for i := range children {
+			specs = append(specs, children[i].sources()...)
+		}
+	}
+	return specs
+}
+
+// ScreenComposer composites the sources of a screen layout into one
+// picture for the model's screen, on the CPU, whenever one of them has a
+// new frame. It is used only on the render loop.
+type ScreenComposer struct {
+	layout *ScreenLayoutNode
+	// sources are the layout's sources other than the desktop, by spec
+	sources map[string]DesktopSource
+	image   *image.RGBA
+	// drawn are the damage counts of the frames last drawn, by spec
+	drawn  map[string]uint64
+	damage uint64
+}
+
+// NewScreenComposer creates a composer with no layout
+func NewScreenComposer() *ScreenComposer {
+	return &ScreenComposer{sources: make(map[string]DesktopSource)}
+}
+
+// SetLayout switches to layout, starting the sources it adds and stopping
+// the ones it drops. A nil layout shows the desktop alone. size is the
+// desktop's size, which videos and cameras are decoded at. On error the
+// old layout is kept.
+func (c *ScreenComposer) SetLayout(layout *ScreenLayoutNode, size image.Point) error {
+	sources := make(map[string]DesktopSource)
+	if layout != nil {
+		for _, spec := range layout.sources() {
+			if _, ok := sources[spec]; ok {
+				continue
+			}
+			if source, ok := c.sources[spec]; ok {
+				sources[spec] = source
+				continue
+			}
+			source, err := NewDesktopSource(spec, size)
+			if err != nil {
+				for spec, source := range sources {
+					if _, kept := c.sources[spec]; !kept {
+						source.Close()
+					}
+				}
+				return fmt.Errorf("screen layout source %q: %w", spec, err)
+			}
+			sources[spec] = source
+		}
+	}
+	for spec, source := range c.sources {
+		if _, ok := sources[spec]; !ok {
+			source.Close()
+		}
+	}
+	c.layout, c.sources, c.drawn = layout, sources, nil
+	return nil
+}
+
+// Layout returns the current layout, nil for none
+func (c *ScreenComposer) Layout() *ScreenLayoutNode {
+	return c.layout
+}
+
+// Active reports whether there is a layout to composite
+func (c *ScreenComposer) Active() bool {
+	return c.layout != nil
+}
+
+// Compose draws the layout at size, with desktop as the desktop source,
+// unless no source has a new frame since the last time
+func (c *ScreenComposer) Compose(desktop TextureSource, size image.Point) {
+	if c.layout == nil || size.X <= 0 || size.Y <= 0 {
+		return
+	}
+	resized := c.image == nil || c.image.Rect.Size() != size
+	if resized {
+		c.image = image.NewRGBA(image.Rectangle{Max: size})
+	}
+	boxes := c.layout.boxes(c.image.Rect)
+	// The frames are drawn from before Compose returns
+	defer func() {
+		for _, source := range c.sources {
+			source.Reclaim()
+		}
+	}()
+	frames := make(map[string]TextureSource, len(c.sources)+1)
+	frames["desktop"] = desktop
+	for spec, source := range c.sources {
+		textureSource, ok := source.(TextureSource)
+		if !ok {
+			continue
+		}
+		// Test cards draw a frame each time they are placed
+		for _, box := range boxes {
+			if box.source == spec {
+				source.Placed(box.rect)
+				break
+			}
+		}
+		frames[spec] = textureSource
+	}
+
+	damaged := resized || c.drawn == nil
+	drawn := make(map[string]uint64, len(frames))
+	for spec, source := range frames {
+		drawn[spec] = source.Damage()
+		if previous, ok := c.drawn[spec]; !ok || previous != drawn[spec] {
+			damaged = true
+		}
+	}
+	if !damaged {
+		return
+	}
+	c.drawn = drawn
+
+	fillRect(c.image, c.image.Rect, opaqueBlack)
+	for _, box := range boxes {
+		source, ok := frames[box.source]
+		if !ok {
+			continue
+		}
+		pixels, stride := source.Frame()
+		frameSize := source.Size()
+		if len(pixels) == 0 || frameSize.X <= 0 || frameSize.Y <= 0 {
+			continue
+		}
+		src := &image.RGBA{Pix: pixels, Stride: stride, Rect: image.Rectangle{Max: frameSize}}
+		fillRect(c.image, box.rect, opaqueBlack)
+		drawScaled(c.image, fitRect(frameSize, box.rect), src, src.Rect)
+	}
+	c.damage++
+}
+
+// Frame returns the composited picture
+func (c *ScreenComposer) Frame() ([]byte, int) {
+	if c.image == nil {
+		return nil, 0
+	}
+	return c.image.Pix, c.image.Stride
+}
+
+// Size returns the composited picture's size
+func (c *ScreenComposer) Size() image.Point {
+	if c.image == nil {
+		return image.Point{}
+	}
+	return c.image.Rect.Size()
+}
+
+// Damage counts the pictures composited
+func (c *ScreenComposer) Damage() uint64 {
+	return c.damage
+}
+
+// Close stops the layout's sources
+func (c *ScreenComposer) Close() {
+	c.SetLayout(nil, image.Point{})
+}
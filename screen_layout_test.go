@@ -0,0 +1,126 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestParseScreenLayout(t *testing.T) {
+	root, err := parseScreenLayout(`{"row": [{"source": "desktop", "size": 70}, {"column": [{"source": "testcard"}, {"source": "desktop"}]}], "pip": [{"source": "testcard"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pip := root.PiP[0]; pip.Size != 25 || pip.Anchor != "bottom-right" {
+		t.Errorf("picture in picture defaults to %g%% at %q", pip.Size, pip.Anchor)
+	}
+	if root, err := parseScreenLayout(""); root != nil || err != nil {
+		t.Errorf("empty layout = %v, %v, want none", root, err)
+	}
+	for _, bad := range []string{
+		`{}`,
+		`{"source": "desktop", "row": [{"source": "desktop"}]}`,
+		`{"source": "webcam"}`,
+		`{"row": [{"source": "desktop", "size": 60}, {"source": "testcard", "size": 50}]}`,
+		`{"column": [{"source": "desktop", "size": -1}]}`,
+		`{"source": "desktop", "pip": [{"source": "testcard", "anchor": "middle"}]}`,
+		`{"source": "desktop", "anchor": "top"}`,
+		`{"source": "desktop"`,
+	} {
+		if _, err := parseScreenLayout(bad); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
+
+func TestScreenLayoutBoxes(t *testing.T) {
+	root, err := parseScreenLayout(`{"row": [{"source": "desktop", "size": 75}, {"column": [{"source": "testcard"}, {"source": "video:a.mp4"}]}], "pip": [{"source": "camera:/dev/video0", "size": 10, "anchor": "top-left", "margin": 5}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxes := root.boxes(image.Rect(0, 0, 400, 200))
+	want := []screenBox{
+		{"desktop", image.Rect(0, 0, 300, 200)},
+		{"testcard", image.Rect(300, 0, 400, 100)},
+		{"video:a.mp4", image.Rect(300, 100, 400, 200)},
+		{"camera:/dev/video0", image.Rect(20, 20, 60, 40)},
+	}
+	if len(boxes) != len(want) {
+		t.Fatalf("got boxes %v", boxes)
+	}
+	for i := range want {
+		if boxes[i] != want[i] {
+			t.Errorf("box %d is %v, want %v", i, boxes[i], want[i])
+		}
+	}
+	if sources := root.sources(); len(sources) != 3 || sources[2] != "camera:/dev/video0" {
+		t.Errorf("sources = %v", sources)
+	}
+}
+
+func TestScreenComposer(t *testing.T) {
+	composer := NewScreenComposer()
+	defer composer.Close()
+	root, err := parseScreenLayout(`{"row": [{"source": "desktop"}, {"source": "testcard"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := composer.SetLayout(root, image.Pt(8, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := composer.sources["testcard"].(*TestCard); !ok || !composer.Active() {
+		t.Fatalf("the test card was not started: %v", composer.sources)
+	}
+
+	// A white 4x4 desktop fills the left half of an 8x4 screen
+	desktop := render.NewDesktopTextureSource()
+	white := make([]byte, 4*4*4)
+	for i := range white {
+		white[i] = 255
+	}
+	desktop.Publish(&wayland.Desktop{Width: 4, Height: 4, Stride: 16, Buffer: white})
+	composer.Compose(desktop, image.Pt(8, 4))
+	pixels, stride := composer.Frame()
+	if composer.Size() != image.Pt(8, 4) || stride != 32 {
+		t.Fatalf("composed %v with stride %d", composer.Size(), stride)
+	}
+	if pixels[0] != 255 || pixels[3*4] != 255 || pixels[3*32+3*4] != 255 {
+		t.Error("the desktop is not in the left half")
+	}
+	damage := composer.Damage()
+	composer.Compose(desktop, image.Pt(8, 4))
+	if composer.Damage() == damage {
+		t.Error("a new test card frame should be composed")
+	}
+
+	// Dropping the test card stops it; an unchanged desktop is not
+	// composed again
+	root, _ = parseScreenLayout(`{"source": "desktop"}`)
+	if err := composer.SetLayout(root, image.Pt(8, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if len(composer.sources) != 0 {
+		t.Errorf("sources left running: %v", composer.sources)
+	}
+	composer.Compose(desktop, image.Pt(8, 4))
+	damage = composer.Damage()
+	composer.Compose(desktop, image.Pt(8, 4))
+	if composer.Damage() != damage {
+		t.Error("an unchanged desktop was composed again")
+	}
+
+	// A layout with a source that cannot start keeps the old one
+	root = &ScreenLayoutNode{Source: "video:/nonexistent/clip.mp4"}
+	if err := composer.SetLayout(root, image.Pt(8, 4)); err == nil {
+		t.Error("a missing video was accepted")
+	}
+	if composer.Layout().Source != "desktop" {
+		t.Errorf("layout is %+v after a failed switch", composer.Layout())
+	}
+	composer.SetLayout(nil, image.Point{})
+	if composer.Active() {
+		t.Error("no layout should leave the composer inactive")
+	}
+}
@@ -0,0 +1,536 @@
+package compositor
+
+import (
+	"encoding/binary"
+	"image"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+// screencopyName is the registry name of the zwlr_screencopy_manager_v1
+// global the proxy adds, clear of the wayland package's own names
+const screencopyName = 0xff00100
+
+// screencopyVersion is the version of wlr-screencopy-unstable-v1 offered:
+// copy_with_damage and buffer_done, without linux_dmabuf events
+const screencopyVersion = 3
+
+// Screencopy serves the wlr-screencopy-unstable-v1 protocol, which the
+// wayland package does not, so grim, OBS's wlrobs and other wlroots
+// capture tools can capture the desktop unmodified. The wayland package's
+// globals are fixed, so each client's connection goes through a proxy that
+// adds the screencopy manager to the globals the client is sent and
+// answers the requests made on its objects itself, passing everything else
+// through untouched. Frames come from the render loop, which fulfils
+// captures as it does screenshots.
+type Screencopy struct {
+	frames *stream.Screenshots
+	// Wake, when set, is called when a capture waits for a frame
+	Wake func()
+}
+
+// NewScreencopy creates a screencopy server with no captures waiting
+func NewScreencopy() *Screencopy {
+	return &Screencopy{frames: stream.NewScreenshots()}
+}
+
+// Pending reports whether a capture is waiting for a frame. A nil
+// Screencopy has none.
+func (s *Screencopy) Pending() bool {
+	return s != nil && s.frames.Pending()
+}
+
+// Fulfil gives every waiting capture a copy of the composited desktop. Call
+// it from the render loop with the frame on the CPU.
+func (s *Screencopy) Fulfil(buffer []byte, width, height, stride int) {
+	if s != nil {
+		s.frames.Fulfil(buffer, width, height, stride)
+	}
+}
+
+// Wrap puts a newly accepted client connection behind the screencopy
+// proxy, returning the end the compositor should serve in its place. A nil
+// Screencopy returns conn.
+func (s *Screencopy) Wrap(conn *net.UnixConn) (*net.UnixConn, error) {
+	if s == nil {
+		return conn, nil
+	}
+	inner, server, err := socketPair()
+	if err != nil {
+		return nil, err
+	}
+	p := &screencopyProxy{
+		screencopy: s,
+		client:     conn,
+		server:     server,
+		decoder:    newWireDecoder(),
+		managers:   make(map[uint32]uint32),
+		frames:     make(map[uint32]*screencopyFrame),
+		pools:      make(map[uint32]*shmPool),
+		buffers:    make(map[uint32]shmBuffer),
+	}
+	forgetPeer := proxyPeer(inner, conn)
+	closeBoth := func() {
+		conn.Close()
+		server.Close()
+		forgetPeer()
+	}
+	go func() {
+		defer closeBoth()
+		p.pumpEvents()
+	}()
+	go func() {
+		defer closeBoth()
+		defer p.release()
+		p.pumpRequests()
+	}()
+	return inner, nil
+}
+
+// screencopyProxy is one client's connection through the screencopy
+// proxy
+type screencopyProxy struct {
+	screencopy *Screencopy
+	client     *net.UnixConn
+	server     *net.UnixConn
+	// decoder follows the client's objects, to tell which requests carry
+	// file descriptors and which objects are the proxy's
+	decoder *wireDecoder
+
+	// writeMu keeps the compositor's events and the proxy's own whole on
+	// their way to the client
+	writeMu sync.Mutex
+
+	// mu guards the proxy's objects and the client's shared memory, which
+	// captures finishing on their own goroutines read
+	mu sync.Mutex
+	// managers are the versions of the screencopy managers bound
+	managers map[uint32]uint32
+	frames   map[uint32]*screencopyFrame
+	pools    map[uint32]*shmPool
+	buffers  map[uint32]shmBuffer
+}
+
+// screencopyFrame is a zwlr_screencopy_frame_v1: a frame captured for the
+// client, once it has arrived, and whether it was copied out yet
+type screencopyFrame struct {
+	version uint32
+	region  image.Rectangle
+	image   *image.RGBA
+	used    bool
+}
+
+// shmPool is a wl_shm_pool the client made: a duplicate of its file
+// descriptor, closed once the pool and its buffers are all destroyed
+type shmPool struct {
+	fd        int
+	size      int64
+	destroyed bool
+	buffers   int
+}
+
+// shmBuffer is a wl_buffer made from a pool
+type shmBuffer struct {
+	pool                          *shmPool
+	offset, width, height, stride int
+	format                        uint32
+}
+
+// pumpEvents passes the compositor's events to the client whole, so the
+// proxy's own events can go between them
+func (p *screencopyProxy) pumpEvents() {
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(4*28))
+	var pending []byte
+	var fds []int
+	for {
+		n, oobn, _, _, err := p.server.ReadMsgUnix(buf, oob)
+		if err != nil || n == 0 && oobn == 0 {
+			return
+		}
+		fds = append(fds, parseRights(oob[:oobn])...)
+		pending = append(pending, buf[:n]...)
+		whole := 0
+		for {
+			size := wireMessageSize(pending[whole:])
+			if size < 0 {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			// Followed for the objects events create, such as data offers
+			p.decoder.message(true, pending[whole:whole+size])
+			whole += size
+		}
+		if whole == 0 {
+			continue
+		}
+		if err := p.write(pending[:whole], fds); err != nil {
+			return
+		}
+		fds = nil
+		pending = append(pending[:0], pending[whole:]...)
+	}
+}
+
+// pumpRequests passes the client's requests to the compositor, answering
+// those for the screencopy manager and its frames itself
+func (p *screencopyProxy) pumpRequests() {
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(4*28))
+	var pending, out []byte
+	// queued are duplicates of the file descriptors received that the
+	// requests read so far have not taken, and forward the received ones
+	// not yet passed on
+	var queued, forward []int
+	defer func() {
+		for _, fd := range slices.Concat(queued, forward) {
+			syscall.Close(fd)
+		}
+	}()
+	for {
+		n, oobn, _, _, err := p.client.ReadMsgUnix(buf, oob)
+		if err != nil || n == 0 && oobn == 0 {
+			return
+		}
+		fds := parseRights(oob[:oobn])
+		for _, fd := range fds {
+			if dup, err := syscall.Dup(fd); err == nil {
+				syscall.CloseOnExec(dup)
+				queued = append(queued, dup)
+			}
+		}
+		forward = append(forward, fds...)
+		pending = append(pending, buf[:n]...)
+		whole := 0
+		out = out[:0]
+		for {
+			size := wireMessageSize(pending[whole:])
+			if size < 0 {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			data := pending[whole : whole+size]
+			whole += size
+			m := p.decoder.message(false, data)
+			var taken []int
+			if m.Type != nil {
+				for _, arg := range m.Args {
+					if arg.Kind == 'h' && len(queued) > 0 {
+						taken, queued = append(taken, queued[0]), queued[1:]
+					}
+				}
+			}
+			if p.request(m, taken) {
+				out = append(out, data...)
+			}
+			for _, fd := range taken {
+				if fd >= 0 {
+					syscall.Close(fd)
+				}
+			}
+		}
+		pending = append(pending[:0], pending[whole:]...)
+		if len(out) == 0 {
+			// File descriptors go with the next bytes passed on
+			continue
+		}
+		var rights []byte
+		if len(forward) > 0 {
+			rights = syscall.UnixRights(forward...)
+		}
+		_, _, err = p.server.WriteMsgUnix(out, rights, nil)
+		for _, fd := range forward {
+			syscall.Close(fd)
+		}
+		forward = nil
+		if err != nil {
+			return
+		}
+	}
+}
+
+// request handles a request from the client, reporting whether it is for
+// the compositor. fds are duplicates of the file descriptors it carries;
+// those the proxy keeps are set to -1, and the caller closes the rest.
+func (p *screencopyProxy) request(m wireMessage, fds []int) bool {
+	if m.Type == nil {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	arg := func(i int) int64 {
+		if i < len(m.Args) {
+			return m.Args[i].Int
+		}
+		return 0
+	}
+	switch m.Interface + "." + m.Type.Name {
+	case "wl_display.get_registry":
+		// Sent before the compositor's globals, which the client only
+		// waits for with a roundtrip after this
+		p.send(uint32(arg(0)), 0, wlArgs{}.uint32(screencopyName).string("zwlr_screencopy_manager_v1").uint32(screencopyVersion))
+	case "wl_registry.bind":
+		if arg(0) != screencopyName {
+			return true
+		}
+		p.managers[uint32(arg(3))] = uint32(min(arg(2), screencopyVersion))
+		return false
+	case "wl_shm.create_pool":
+		if len(fds) == 1 {
+			p.pools[uint32(arg(0))] = &shmPool{fd: fds[0], size: arg(2)}
+			fds[0] = -1
+		}
+	case "wl_shm_pool.resize":
+		if pool, ok := p.pools[m.Object]; ok {
+			pool.size = arg(0)
+		}
+	case "wl_shm_pool.destroy":
+		if pool, ok := p.pools[m.Object]; ok {
+			delete(p.pools, m.Object)
+			pool.destroyed = true
+			pool.release()
+		}
+	case "wl_shm_pool.create_buffer":
+		if pool, ok := p.pools[m.Object]; ok {
+			pool.buffers++
+			p.buffers[uint32(arg(0))] = shmBuffer{
+				pool:   pool,
+				offset: int(arg(1)),
+				width:  int(arg(2)),
+				height: int(arg(3)),
+				stride: int(arg(4)),
+				format: uint32(arg(5)),
+			}
+		}
+	case "wl_buffer.destroy":
+		if buffer, ok := p.buffers[m.Object]; ok {
+			delete(p.buffers, m.Object)
+			buffer.pool.buffers--
+			buffer.pool.release()
+		}
+	case "zwlr_screencopy_manager_v1.capture_output":
+		p.capture(uint32(arg(0)), p.managers[m.Object], image.Rectangle{})
+		return false
+	case "zwlr_screencopy_manager_v1.capture_output_region":
+		p.capture(uint32(arg(0)), p.managers[m.Object], image.Rect(int(arg(3)), int(arg(4)), int(arg(3)+arg(5)), int(arg(4)+arg(6))))
+		return false
+	case "zwlr_screencopy_manager_v1.destroy":
+		delete(p.managers, m.Object)
+		p.deleteID(m.Object)
+		return false
+	case "zwlr_screencopy_frame_v1.copy":
+		p.copy(m.Object, uint32(arg(0)), false)
+		return false
+	case "zwlr_screencopy_frame_v1.copy_with_damage":
+		p.copy(m.Object, uint32(arg(0)), true)
+		return false
+	case "zwlr_screencopy_frame_v1.destroy":
+		delete(p.frames, m.Object)
+		p.deleteID(m.Object)
+		return false
+	}
+	return true
+}
+
+// capture starts capturing the desktop, or region of it, into a new
+// frame. The client is told the buffer to copy it into once the render
+// loop has composited the next frame. Must be called with p.mu held.
+func (p *screencopyProxy) capture(id, version uint32, region image.Rectangle) {
+	frame := &screencopyFrame{version: version, region: region}
+	p.frames[id] = frame
+	reply, ok := p.screencopy.frames.Request()
+	if !ok {
+		p.send(id, 3, wlArgs{}) // failed
+		return
+	}
+	if p.screencopy.Wake != nil {
+		p.screencopy.Wake()
+	}
+	go func() {
+		var captured *image.RGBA
+		select {
+		case captured = <-reply:
+		case <-time.After(stream.ScreenshotTimeout):
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.frames[id] != frame {
+			// Destroyed while it waited
+			return
+		}
+		if captured == nil {
+			p.send(id, 3, wlArgs{}) // failed
+			return
+		}
+		bounds := captured.Rect
+		if !frame.region.Empty() {
+			bounds = frame.region.Intersect(bounds)
+		}
+		if bounds.Empty() {
+			p.send(id, 3, wlArgs{}) // failed
+			return
+		}
+		frame.image = captured.SubImage(bounds).(*image.RGBA)
+		width, height := uint32(bounds.Dx()), uint32(bounds.Dy())
+		p.send(id, 0, wlArgs{}.uint32(shmXRGB8888).uint32(width).uint32(height).uint32(width*4)) // buffer
+		if frame.version >= 3 {
+			p.send(id, 6, wlArgs{}) // buffer_done
+		}
+	}()
+}
+
+// copy writes a frame into the client's buffer and tells it the frame is
+// ready, or that it failed if the buffer is not one the frame fits. The
+// damage of copy_with_damage is the whole frame. Must be called with p.mu
+// held.
+func (p *screencopyProxy) copy(id, bufferID uint32, damage bool) {
+	frame, ok := p.frames[id]
+	if !ok {
+		return
+	}
+	buffer, ok := p.buffers[bufferID]
+	if frame.used || frame.image == nil || !ok || !buffer.fits(frame.image.Rect.Size()) {
+		p.send(id, 3, wlArgs{}) // failed
+		return
+	}
+	frame.used = true
+	if err := buffer.write(frame.image); err != nil {
+		log.Printf("Screencopy: %v", err)
+		p.send(id, 3, wlArgs{}) // failed
+		return
+	}
+	size := frame.image.Rect.Size()
+	p.send(id, 1, wlArgs{}.uint32(0)) // flags
+	if damage && frame.version >= 2 {
+		p.send(id, 4, wlArgs{}.uint32(0).uint32(0).uint32(uint32(size.X)).uint32(uint32(size.Y)))
+	}
+	now := monotonicNow()
+	p.send(id, 2, wlArgs{}.uint32(uint32(uint64(now.Sec)>>32)).uint32(uint32(now.Sec)).uint32(uint32(now.Nsec))) // ready
+}
+
+// deleteID tells the client an object of the proxy's is gone, as the
+// compositor does for its own. Must be called with p.mu held.
+func (p *screencopyProxy) deleteID(id uint32) {
+	p.decoder.forget(id)
+	p.send(wlDisplayID, 1, wlArgs{}.uint32(id))
+}
+
+// send sends the client an event of the proxy's own. A client that has
+// gone is found out by the pumps.
+func (p *screencopyProxy) send(object uint32, opcode uint16, args wlArgs) {
+	p.write(wlMessage(object, opcode, args), nil)
+}
+
+// write sends whole messages to the client with the file descriptors that
+// go with them, and closes the file descriptors
+func (p *screencopyProxy) write(data []byte, fds []int) error {
+	var rights []byte
+	if len(fds) > 0 {
+		rights = syscall.UnixRights(fds...)
+	}
+	p.writeMu.Lock()
+	_, _, err := p.client.WriteMsgUnix(data, rights, nil)
+	p.writeMu.Unlock()
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+	return err
+}
+
+// release closes the pools' file descriptors once the client is gone
+func (p *screencopyProxy) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, pool := range p.pools {
+		pool.destroyed, pool.buffers = true, 0
+		pool.release()
+		delete(p.pools, id)
+	}
+	for id, buffer := range p.buffers {
+		buffer.pool.destroyed, buffer.pool.buffers = true, 0
+		buffer.pool.release()
+		delete(p.buffers, id)
+	}
+	p.frames = make(map[uint32]*screencopyFrame)
+}
+
+// release closes the pool's file descriptor once it and its buffers are
+// all destroyed
+func (pool *shmPool) release() {
+	if pool.destroyed && pool.buffers <= 0 && pool.fd >= 0 {
+		syscall.Close(pool.fd)
+		pool.fd = -1
+	}
+}
+
+// fits reports whether a frame of size can be copied into the buffer: one
+// of the formats offered, the same size, and inside its pool
+func (b shmBuffer) fits(size image.Point) bool {
+	return (b.format == shmXRGB8888 || b.format == shmARGB8888) &&
+		b.width == size.X && b.height == size.Y && b.stride >= b.width*4 &&
+		b.offset >= 0 && int64(b.offset+b.stride*b.height) <= b.pool.size && b.pool.fd >= 0
+}
+
+// write copies frame into the buffer as little endian XRGB, whose bytes
+// are blue, green, red and an opaque alpha
+func (b shmBuffer) write(frame *image.RGBA) error {
+	end := b.offset + b.stride*b.height
+	// A file shorter than its pool would fault when written through the
+	// mapping
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(b.pool.fd, &stat); err != nil {
+		return err
+	}
+	if stat.Size < int64(end) {
+		return syscall.EINVAL
+	}
+	memory, err := syscall.Mmap(b.pool.fd, 0, end, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	defer syscall.Munmap(memory)
+	for y := range b.height {
+		row := frame.Pix[y*frame.Stride:]
+		out := memory[b.offset+y*b.stride:]
+		for x := range b.width {
+			p, q := row[x*4:x*4+4], out[x*4:x*4+4]
+			q[0], q[1], q[2], q[3] = p[2], p[1], p[0], 255
+		}
+	}
+	return nil
+}
+
+// wireMessageSize returns the size of the message data starts with, 0 if
+// it is not all there yet, or -1 if the stream is out of step
+func wireMessageSize(data []byte) int {
+	if len(data) < 8 {
+		return 0
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:8]) >> 16)
+	if size < 8 {
+		return -1
+	}
+	if len(data) < size {
+		return 0
+	}
+	return size
+}
+
+// monotonicNow reads CLOCK_MONOTONIC, the clock screencopy timestamps
+// are on
+func monotonicNow() syscall.Timespec {
+	var ts syscall.Timespec
+	syscall.Syscall(syscall.SYS_CLOCK_GETTIME, 1, uintptr(unsafe.Pointer(&ts)), 0)
+	return ts
+}
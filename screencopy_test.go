@@ -0,0 +1,51 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"image"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestScreencopyCapturesSession(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	manager := NewSessionManager(http.NotFoundHandler(), 64, time.Second, nil)
+	t.Cleanup(manager.Close)
+	manager.SetScreencopy(true)
+	session, err := manager.Create("test", 320, 240)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSession{t: t, session: session}
+	c := s.dial()
+	c.openWindow("test.pattern")
+	pattern := testPattern(64, 48)
+	c.draw(64, 48, pattern)
+
+	// The mirror captures with wlr-screencopy, as grim does, through the
+	// proxy in front of the session
+	m, err := NewMirror(session.Display, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// Nobody watches the session, so it is only composited for the capture
+	bounds := image.Rect(0, 0, 320, 240)
+	var placed []PlacedSurface
+	for deadline := time.Now().Add(5 * time.Second); len(placed) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("No frame captured (%v)", m.Err())
+		}
+		s.composite()
+		placed = m.Placed(bounds)
+	}
+	texture := placed[0].Texture
+	if texture.Width != 320 || texture.Height != 240 {
+		t.Fatalf("Expected a 320x240 capture, got %dx%d", texture.Width, texture.Height)
+	}
+	frame := &image.RGBA{Pix: texture.Data, Stride: 320 * 4, Rect: bounds}
+	checkPattern(t, frame, image.Point{}, 64, 48, pattern)
+}
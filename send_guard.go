@@ -0,0 +1,271 @@
+package compositor
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// inputBacklog is how many input events wait for a client's delivery
+// goroutine before later ones are dropped
+const inputBacklog = 256
+
+// inputRoom is how much room a client's outgoing queue must have left for
+// an input event to be queued on it. The delivery goroutine holds the
+// client's lock, which the main loop needs to drain the queue, so it never
+// waits on a full one.
+const inputRoom = 64
+
+// fits reports whether n events can be queued for a client with inputRoom
+// still left. Every sender outside the client's own goroutine checks it
+// with the client's lock held and sends under the same lock, so room one
+// found is not taken by another before it sends.
+func fits(c *wayland.Client, n int) bool {
+	return len(c.OutgoingChannel)+n+inputRoom <= cap(c.OutgoingChannel)
+}
+
+// SendGuard keeps compositor send paths from blocking on clients that stop
+// reading their socket. Every client has a bounded outgoing queue; a client
+// that stays above the limit for longer than the timeout, or fills its queue
+// completely, is disconnected.
+//
+// Input goes to each client through the guard on a goroutine of the
+// client's own, which reads the client's seat bindings under its lock:
+// clients bind pointers and keyboards on their own goroutines under that
+// lock, and a client blocked writing to its socket holds it, which input
+// must not wait on.
+type SendGuard struct {
+	mu           sync.Mutex
+	limit        int
+	timeout      time.Duration
+	stalledSince map[*wayland.Client]time.Time
+	// dropped are the clients disconnected whose main loop has not
+	// noticed yet
+	dropped map[*wayland.Client]bool
+	// inboxes are the input events waiting for each client's delivery
+	// goroutine
+	inboxes map[*wayland.Client]chan func(*wayland.Client)
+}
+
+// NewSendGuard creates a guard that allows at most limit queued events per
+// client and disconnects clients that stay over it for timeout.
+func NewSendGuard(limit int, timeout time.Duration) *SendGuard {
+	return &SendGuard{
+		limit:        limit,
+		timeout:      timeout,
+		stalledSince: make(map[*wayland.Client]time.Time),
+		dropped:      make(map[*wayland.Client]bool),
+		inboxes:      make(map[*wayland.Client]chan func(*wayland.Client)),
+	}
+}
+
+// Writable returns the clients that can accept more events without blocking.
+// Clients over their limit are skipped, so input keeps flowing to the rest.
+func (g *SendGuard) Writable(clients []*wayland.Client) []*wayland.Client {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writable := make([]*wayland.Client, 0, len(clients))
+	for _, c := range clients {
+		if !g.dropped[c] && g.hasRoom(c) {
+			writable = append(writable, c)
+		}
+	}
+	return writable
+}
+
+// Check disconnects clients whose outgoing queue overflowed or has been
+// stalled for longer than the timeout. Call this once per frame with the
+// connected clients; the others are forgotten.
+func (g *SendGuard) Check(clients []*wayland.Client, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for c := range g.stalledSince {
+		if !slices.Contains(clients, c) {
+			delete(g.stalledSince, c)
+		}
+	}
+	for c := range g.dropped {
+		if !slices.Contains(clients, c) {
+			delete(g.dropped, c)
+		}
+	}
+	for c := range g.inboxes {
+		if !slices.Contains(clients, c) {
+			g.stopDelivery(c)
+		}
+	}
+
+	for _, c := range clients {
+		if g.dropped[c] {
+			continue
+		}
+		if g.hasRoom(c) {
+			delete(g.stalledSince, c)
+			continue
+		}
+		since, ok := g.stalledSince[c]
+		if !ok {
+			g.stalledSince[c] = now
+			since = now
+		}
+		if len(c.OutgoingChannel) >= cap(c.OutgoingChannel) || now.Sub(since) >= g.timeout {
+			log.Printf("Disconnecting client: outgoing queue stalled at %d events", len(c.OutgoingChannel))
+			g.disconnect(c)
+		}
+	}
+}
+
+func (g *SendGuard) hasRoom(c *wayland.Client) bool {
+	limit := g.limit
+	if limit <= 0 || limit > cap(c.OutgoingChannel) {
+		limit = cap(c.OutgoingChannel)
+	}
+	return len(c.OutgoingChannel) < limit
+}
+
+// Disconnect closes a client's socket, which ends the client's main loop,
+// and sends it nothing more. Its status is the main loop's to change, on
+// the client's own goroutine, as it unwinds.
+func (g *SendGuard) Disconnect(c *wayland.Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.disconnect(c)
+}
+
+func (g *SendGuard) disconnect(c *wayland.Client) {
+	delete(g.stalledSince, c)
+	g.dropped[c] = true
+	g.stopDelivery(c)
+	if c.UnixConnection != nil {
+		c.UnixConnection.Close()
+	}
+}
+
+// Send calls send with the client's lock held when the client is writable
+// and n events fit in its outgoing queue, and reports whether it did. It
+// is for the main loop, so a client whose lock is held is skipped rather
+// than waited on.
+func (g *SendGuard) Send(c *wayland.Client, n int, send func(*wayland.Client)) bool {
+	g.mu.Lock()
+	writable := !g.dropped[c] && g.hasRoom(c)
+	g.mu.Unlock()
+	if !writable || !c.Access.TryLock() {
+		return false
+	}
+	defer c.Access.Unlock()
+	if !fits(c, n) {
+		return false
+	}
+	send(c)
+	return true
+}
+
+// Deliver hands send to each client's delivery goroutine, which calls it
+// with the client's lock held. Each client gets events in the order they
+// were delivered; one too far behind, or whose outgoing queue is nearly
+// full, misses them.
+func (g *SendGuard) Deliver(clients []*wayland.Client, send func(c *wayland.Client)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, c := range clients {
+		if g.dropped[c] {
+			continue
+		}
+		inbox, ok := g.inboxes[c]
+		if !ok {
+			inbox = make(chan func(*wayland.Client), inputBacklog)
+			g.inboxes[c] = inbox
+			go deliverInput(c, inbox)
+		}
+		select {
+		case inbox <- send:
+		default:
+		}
+	}
+}
+
+// stopDelivery ends a client's delivery goroutine once it has sent what
+// is waiting
+func (g *SendGuard) stopDelivery(c *wayland.Client) {
+	if inbox, ok := g.inboxes[c]; ok {
+		close(inbox)
+		delete(g.inboxes, c)
+	}
+}
+
+// deliverInput sends a client its input events until inbox is closed
+func deliverInput(c *wayland.Client, inbox <-chan func(*wayland.Client)) {
+	for send := range inbox {
+		c.Access.Lock()
+		if fits(c, 0) {
+			send(c)
+		}
+		c.Access.Unlock()
+	}
+}
+
+// SendKeyboardKey sends a key to the keyboards clients bound, as
+// wayland.SendKeyboardKey does
+func (g *SendGuard) SendKeyboardKey(clients []*wayland.Client, key uint32, pressed bool) {
+	timestamp := uint32(time.Now().UnixMilli())
+	serial := wayland.GetNextEventSerial()
+	state := protocols.WlKeyboardKeyState_enum_released
+	if pressed {
+		state = protocols.WlKeyboardKeyState_enum_pressed
+	}
+	g.Deliver(clients, func(c *wayland.Client) {
+		for keyboardID := range protocols.GetGlobalWlKeyboardBinds(c) {
+			protocols.WlKeyboard_key(c, keyboardID, serial, timestamp, key, state)
+		}
+	})
+}
+
+// SendPointerMotion moves the pointer to x, y for clients, as
+// wayland.SendPointerMotion does
+func (g *SendGuard) SendPointerMotion(clients []*wayland.Client, x, y float32) {
+	g.Deliver(clients, pointerMotion(uint32(time.Now().UnixMilli()), x, y))
+	wayland.Pointer.WindowX, wayland.Pointer.WindowY = x, y
+}
+
+// pointerMotion sends the pointers a client bound to x, y
+func pointerMotion(timestamp uint32, x, y float32) func(*wayland.Client) {
+	return func(c *wayland.Client) {
+		for pointerID, version := range protocols.GetGlobalWlPointerBinds(c) {
+			protocols.WlPointer_motion(c, pointerID, timestamp, x, y)
+			protocols.WlPointer_frame(c, uint32(version), pointerID)
+		}
+	}
+}
+
+// SendPointerButton presses or releases a button for clients, as
+// wayland.SendPointerButton does
+func (g *SendGuard) SendPointerButton(clients []*wayland.Client, button uint32, pressed bool) {
+	timestamp := uint32(time.Now().UnixMilli())
+	serial := wayland.GetNextEventSerial()
+	state := protocols.WlPointerButtonState_enum_released
+	if pressed {
+		state = protocols.WlPointerButtonState_enum_pressed
+	}
+	g.Deliver(clients, func(c *wayland.Client) {
+		for pointerID, version := range protocols.GetGlobalWlPointerBinds(c) {
+			protocols.WlPointer_button(c, pointerID, serial, timestamp, button, state)
+			protocols.WlPointer_frame(c, uint32(version), pointerID)
+		}
+	})
+}
+
+// SendPointerAxis scrolls for clients, as wayland.SendPointerAxis does
+func (g *SendGuard) SendPointerAxis(clients []*wayland.Client, axis protocols.WlPointerAxis_enum, value float32) {
+	timestamp := uint32(time.Now().UnixMilli())
+	g.Deliver(clients, func(c *wayland.Client) {
+		for pointerID, version := range protocols.GetGlobalWlPointerBinds(c) {
+			protocols.WlPointer_axis(c, pointerID, timestamp, axis, value)
+			protocols.WlPointer_frame(c, uint32(version), pointerID)
+		}
+	})
+}
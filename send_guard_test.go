@@ -0,0 +1,121 @@
+package compositor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+func makeTestClient(queued, capacity int) *wayland.Client {
+	c := &wayland.Client{
+		Status:          wayland.ClientStatus_Connected,
+		OutgoingChannel: make(chan protocols.OutgoingEvent, capacity),
+	}
+	for i := 0; i < queued; i++ {
+		c.OutgoingChannel <- protocols.OutgoingEvent{}
+	}
+	return c
+}
+
+func TestSendGuardWritable(t *testing.T) {
+	healthy := makeTestClient(0, 8)
+	busy := makeTestClient(4, 8)
+
+	guard := NewSendGuard(4, time.Second)
+	writable := guard.Writable([]*wayland.Client{healthy, busy})
+	if len(writable) != 1 || writable[0] != healthy {
+		t.Errorf("Expected only the healthy client to be writable, got %d clients", len(writable))
+	}
+}
+
+func TestSendGuardCheck(t *testing.T) {
+	stalled := makeTestClient(4, 8)
+	full := makeTestClient(8, 8)
+	healthy := makeTestClient(1, 8)
+	clients := []*wayland.Client{stalled, full, healthy}
+
+	guard := NewSendGuard(4, time.Second)
+	start := time.Now()
+
+	guard.Check(clients, start)
+	if !guard.dropped[full] {
+		t.Error("Client with a full queue should be disconnected immediately")
+	}
+	if guard.dropped[stalled] {
+		t.Error("Stalled client should get until the timeout to recover")
+	}
+
+	guard.Check(clients, start.Add(2*time.Second))
+	if !guard.dropped[stalled] {
+		t.Error("Stalled client should be disconnected after the timeout")
+	}
+	if guard.dropped[healthy] {
+		t.Error("Healthy client should stay connected")
+	}
+
+	// Disconnected clients are sent nothing more, and forgotten once
+	// they leave the list
+	for range 4 {
+		<-stalled.OutgoingChannel
+	}
+	if writable := guard.Writable(clients); len(writable) != 1 || writable[0] != healthy {
+		t.Errorf("Expected only the healthy client to be writable, got %d clients", len(writable))
+	}
+	guard.Check([]*wayland.Client{healthy}, start.Add(3*time.Second))
+	if len(guard.dropped) != 0 {
+		t.Errorf("%d disconnected clients still remembered after leaving the list", len(guard.dropped))
+	}
+}
+
+func TestSendGuardSend(t *testing.T) {
+	healthy := makeTestClient(0, inputRoom+2)
+	dropped := makeTestClient(0, inputRoom+2)
+	guard := NewSendGuard(0, time.Second)
+	guard.Disconnect(dropped)
+
+	sent := 0
+	send := func(*wayland.Client) { sent++ }
+	if !guard.Send(healthy, 2, send) || sent != 1 {
+		t.Error("Expected events that fit with room for input left sent")
+	}
+	if guard.Send(healthy, 3, send) || sent != 1 {
+		t.Error("Expected events that would take the room kept for input held")
+	}
+	if guard.Send(dropped, 1, send) || sent != 1 {
+		t.Error("Expected nothing sent to a disconnected client")
+	}
+}
+
+func TestSendGuardDeliver(t *testing.T) {
+	healthy := makeTestClient(0, inputRoom*2)
+	nearlyFull := makeTestClient(inputRoom+1, inputRoom*2)
+	guard := NewSendGuard(inputRoom*2, time.Second)
+
+	sent := make(chan int, 8)
+	for i := range 3 {
+		guard.Deliver([]*wayland.Client{healthy, nearlyFull}, func(c *wayland.Client) {
+			if c == nearlyFull {
+				t.Error("A client whose queue is nearly full should miss input")
+			}
+			sent <- i
+		})
+	}
+	for want := range 3 {
+		select {
+		case got := <-sent:
+			if got != want {
+				t.Errorf("Expected input in the order delivered, got %d for %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Input was not sent")
+		}
+	}
+
+	// Input stops once the clients leave the list
+	guard.Check(nil, time.Now())
+	if len(guard.inboxes) != 0 {
+		t.Errorf("%d clients still delivered to after leaving the list", len(guard.inboxes))
+	}
+}
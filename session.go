@@ -0,0 +1,447 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// mainSession names the display the process starts with, the one shown in
+// the preview window
+const mainSession = "main"
+
+var sessionNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// Session is an extra Wayland display hosted next to the main one, with
+// its own clients, desktop buffer and stream at /ws/<name>. Sessions are
+// composited on the CPU and have no preview window.
+type Session struct {
+	Name    string
+	Display string
+
+	listener   *wayland.SocketListener
+	stream     Broadcaster
+	sendGuard  *SendGuard
+	framePacer *FramePacer
+	done       chan struct{}
+	clients    *ClientList
+	// damage skips compositing and streaming an unchanged desktop. Like
+	// Resize it is only used on the render loop.
+	damage *DesktopDamage
+	// source holds the last composited frame for the stream
+	source *render.DesktopTextureSource
+	// wake, when set, is called when a client asks for a frame
+	wake func()
+	// maxClients is the most clients connected at once, 0 for no limit
+	maxClients int
+	// tracer traces the session's clients, when set
+	tracer *WireTracer
+	// screencopy, when set, serves wlr-screencopy to the session's clients
+	screencopy *Screencopy
+
+	// mu guards the desktop, which Resize replaces
+	mu         sync.Mutex
+	desktop    *wayland.Desktop
+	surfaces   *SurfaceSnapshots
+	visibility *VisibilityTracker
+}
+
+// SessionInfo describes a session for the control API
+type SessionInfo struct {
+	Name    string `json:"name"`
+	Display string `json:"display"`
+	Stream  string `json:"stream"`
+	Clients int    `json:"clients"`
+	Viewers int    `json:"viewers"`
+}
+
+// SessionManager creates and destroys extra sessions. Create, Destroy,
+// Resize and Composite run on the render loop; ServeWebSocket runs on
+// HTTP goroutines.
+type SessionManager struct {
+	// main serves the main session's stream, which also answers at /ws
+	main          http.Handler
+	clientQueue   int
+	clientTimeout time.Duration
+	icon          []byte
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	// playoutDelay is given to the streams of sessions created after it
+	// is set
+	playoutDelay time.Duration
+	// wake is called when a session's client asks for a frame
+	wake func()
+	// maxClients limits the clients of sessions created after it is set
+	maxClients int
+	// tracer, when set, traces the clients of sessions created after it is
+	// set
+	tracer *WireTracer
+	// screencopy offers wlr-screencopy to the clients of sessions created
+	// after it is set
+	screencopy bool
+}
+
+// NewSessionManager creates a manager with no extra sessions. Sessions get
+// the same client queue limits as the main one.
+func NewSessionManager(main http.Handler, clientQueue int, clientTimeout time.Duration, icon []byte) *SessionManager {
+	return &SessionManager{
+		main:          main,
+		clientQueue:   clientQueue,
+		clientTimeout: clientTimeout,
+		icon:          icon,
+		sessions:      make(map[string]*Session),
+	}
+}
+
+// SetPlayoutDelay sets how long after capture synced viewers of new
+// sessions show a frame
+func (m *SessionManager) SetPlayoutDelay(delay time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.playoutDelay = delay
+}
+
+// SetWake sets a function called, on the client's goroutine, when a
+// client of a session created after it is set asks for a frame
+func (m *SessionManager) SetWake(wake func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.wake = wake
+}
+
+// SetMaxClients limits how many clients each session created after it is
+// set has connected at once, 0 for no limit
+func (m *SessionManager) SetMaxClients(limit int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxClients = limit
+}
+
+// SetTracer traces the clients of sessions created after it is set
+func (m *SessionManager) SetTracer(tracer *WireTracer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tracer = tracer
+}
+
+// SetScreencopy offers wlr-screencopy to the clients of sessions created
+// after it is set, each capturing its own session's desktop
+func (m *SessionManager) SetScreencopy(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.screencopy = enabled
+}
+
+// Create starts a session on the next free Wayland display
+func (m *SessionManager) Create(name string, width, height int) (*Session, error) {
+	if !sessionNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("session names are 1-32 letters, digits, '-' or '_'")
+	}
+	m.mu.Lock()
+	_, exists := m.sessions[name]
+	m.mu.Unlock()
+	if exists || name == mainSession {
+		return nil, fmt.Errorf("session %q already exists", name)
+	}
+
+	listener, err := wayland.MakeSocketListener(&Args{})
+	if err != nil {
+		return nil, fmt.Errorf("create socket listener: %w", err)
+	}
+	s := &Session{
+		Name:       name,
+		Display:    listener.WaylandDisplayName,
+		listener:   listener,
+		stream:     stream.NewWebSocketServer(),
+		sendGuard:  NewSendGuard(m.clientQueue, m.clientTimeout),
+		framePacer: NewFramePacer(),
+		done:       make(chan struct{}),
+		clients:    NewClientList(),
+		damage:     NewDesktopDamage(),
+		source:     render.NewDesktopTextureSource(),
+		desktop:    wayland.MakeDesktop(wayland.Size{Width: uint32(width), Height: uint32(height)}, false, m.icon),
+		surfaces:   NewSurfaceSnapshots(),
+		visibility: NewVisibilityTracker(width, height),
+	}
+	m.mu.Lock()
+	if m.playoutDelay > 0 {
+		s.stream.SetPlayoutDelay(m.playoutDelay)
+	}
+	s.wake = m.wake
+	s.maxClients = m.maxClients
+	s.tracer = m.tracer
+	if m.screencopy {
+		s.screencopy = NewScreencopy()
+		s.screencopy.Wake = m.wake
+	}
+	m.mu.Unlock()
+	s.stream.SetKeyboardHandler(func(keycode uint32, pressed bool) {
+		if keycode != 0 {
+			s.sendGuard.SendKeyboardKey(s.writableClients(), keycode, pressed)
+		}
+	})
+	s.stream.SetTextHandler(func(text string) {
+		events, _ := textKeyEvents(text)
+		clients := s.writableClients()
+		for _, e := range events {
+			s.sendGuard.SendKeyboardKey(clients, e.Keycode, e.Pressed)
+		}
+	})
+	s.stream.SetPointerHandler(func(e stream.PointerEvent) {
+		clients := s.writableClients()
+		switch e.Type {
+		case stream.InputPointerMotion:
+			s.sendGuard.SendPointerMotion(clients, e.X, e.Y)
+		case stream.InputPointerButton:
+			s.sendGuard.SendPointerButton(clients, e.Button, e.Pressed)
+		case stream.InputPointerAxis:
+			s.sendGuard.SendPointerAxis(clients, e.Axis, e.Value)
+		}
+	})
+
+	go func() {
+		if err := acceptClients(listener); err != nil {
+			select {
+			case <-s.done:
+			default:
+				log.Printf("Session %s: listener loop error: %v", name, err)
+			}
+		}
+	}()
+	go s.accept()
+
+	m.mu.Lock()
+	m.sessions[name] = s
+	m.mu.Unlock()
+	log.Printf("Session %s started on %s", name, s.Display)
+	return s, nil
+}
+
+// accept adds the session's new clients until it is destroyed
+func (s *Session) accept() {
+	for {
+		select {
+		case conn := <-s.listener.OnConnection:
+			if s.maxClients > 0 && s.clients.Len() >= s.maxClients {
+				rejectClient(conn, s.maxClients)
+				continue
+			}
+			traced, err := s.tracer.Wrap(conn)
+			if err != nil {
+				log.Printf("Session %s failed to trace client: %v", s.Name, err)
+				conn.Close()
+				continue
+			}
+			served, err := s.screencopy.Wrap(traced)
+			if err != nil {
+				log.Printf("Session %s failed to proxy client: %v", s.Name, err)
+				traced.Close()
+				continue
+			}
+			client := wayland.MakeClient(served)
+			s.clients.Add(client)
+			go runClient(s.clients, client, func(callbackID protocols.ObjectID[protocols.WlCallback]) {
+				s.framePacer.Queue(client, callbackID)
+				if s.wake != nil {
+					s.wake()
+				}
+			})
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// writableClients returns the session's clients that can take more events
+func (s *Session) writableClients() []*wayland.Client {
+	return s.sendGuard.Writable(s.clients.Snapshot())
+}
+
+// Destroy stops a session, disconnecting its clients and viewers
+func (m *SessionManager) Destroy(name string) bool {
+	m.mu.Lock()
+	s, ok := m.sessions[name]
+	delete(m.sessions, name)
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	s.close()
+	log.Printf("Session %s on %s destroyed", name, s.Display)
+	return true
+}
+
+// Close destroys every session, for shutdown
+func (m *SessionManager) Close() {
+	m.mu.Lock()
+	sessions := m.sessions
+	m.sessions = make(map[string]*Session)
+	m.mu.Unlock()
+	for _, s := range sessions {
+		s.close()
+	}
+}
+
+func (s *Session) close() {
+	close(s.done)
+	closeListener(s.listener)
+	for _, c := range s.clients.Clear() {
+		s.sendGuard.Disconnect(c)
+		releaseClient(c)
+	}
+	s.stream.CloseAll()
+}
+
+// Get returns a session by name, or nil
+func (m *SessionManager) Get(name string) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[name]
+}
+
+// List describes the extra sessions, sorted by name
+func (m *SessionManager) List() []SessionInfo {
+	m.mu.Lock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.Unlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Name < sessions[j].Name })
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, s.Info())
+	}
+	return infos
+}
+
+// Info describes the session
+func (s *Session) Info() SessionInfo {
+	return SessionInfo{
+		Name:    s.Name,
+		Display: s.Display,
+		Stream:  "/ws/" + s.Name,
+		Clients: s.clients.Len(),
+		Viewers: s.stream.ClientCount(),
+	}
+}
+
+// Resize gives every session a new desktop and tells its toplevels to fill
+// it. The wayland package's monitor size is global, so sessions always
+// share the main session's resolution.
+func (m *SessionManager) Resize(width, height int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sessions {
+		s.mu.Lock()
+		s.desktop = wayland.MakeDesktop(wayland.Size{Width: uint32(width), Height: uint32(height)}, false, m.icon)
+		s.visibility.Bounds = image.Rect(0, 0, width, height)
+		s.damage.Invalidate()
+		for _, c := range s.clients.Snapshot() {
+			for id, alive := range c.TopLevelSurfaces() {
+				if alive {
+					if err := configureToplevel(c, id, width, height, true); err != nil {
+						log.Printf("Session %s: failed to resize toplevel %d: %v", s.Name, id, err)
+					}
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Resync sends the viewers of every session a keyframe next, for after
+// the host slept
+func (m *SessionManager) Resync() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sessions {
+		s.stream.Resync()
+	}
+}
+
+// Composite draws and streams a frame of every session. Call it from the
+// render loop each frame.
+func (m *SessionManager) Composite(now time.Time) {
+	m.mu.Lock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.Unlock()
+	for _, s := range sessions {
+		s.composite(now)
+	}
+}
+
+// composite draws the session's desktop, skipping the drawing while nobody
+// watches or nothing changed, and releases its clients' frame callbacks
+func (s *Session) composite(now time.Time) {
+	streaming := s.stream.ClientCount() > 0
+	capturing := s.screencopy.Pending()
+
+	// Only the main session reports client events
+	s.clients.TakeAdded()
+	for _, c := range s.clients.Prune() {
+		s.framePacer.Forget(c)
+		releaseClient(c)
+	}
+	s.sendGuard.Check(s.clients.Snapshot(), now)
+
+	s.mu.Lock()
+	placed := s.surfaces.Collect(s.clients.Snapshot(), s.visibility.Bounds)
+	visible, hidden := s.visibility.Cull(placed)
+	s.framePacer.SetHiddenClients(hiddenClients(visible, hidden))
+	desktop := s.desktop
+	s.mu.Unlock()
+
+	// Resize swaps in a new desktop rather than changing this one
+	changed := false
+	if streaming || capturing {
+		showIcon := len(visible) == 0 && desktop.IconImg != nil && desktop.AfterOpeningTimeout()
+		if s.damage.Dirty(visible, showIcon) {
+			compositeDesktop(desktop, visible)
+			changed = true
+		}
+	}
+
+	s.framePacer.Flush(now, s.sendGuard)
+	if capturing {
+		s.screencopy.Fulfil(desktop.Buffer, desktop.Width, desktop.Height, desktop.Stride)
+	}
+	if changed {
+		s.source.Publish(desktop)
+	}
+	if streaming {
+		s.stream.Stream(s.source)
+	}
+}
+
+// ServeWebSocket serves /ws/{session}; /ws/main is the main session
+func (m *SessionManager) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("session")
+	if name == mainSession {
+		m.main.ServeHTTP(w, r)
+		return
+	}
+	s := m.Get(name)
+	if s == nil {
+		http.Error(w, fmt.Sprintf("no session %q", name), http.StatusNotFound)
+		return
+	}
+	s.stream.HandleWebSocket(w, r)
+}
@@ -0,0 +1,46 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionManagerCreateRejectsBadNames(t *testing.T) {
+	m := NewSessionManager(http.NotFoundHandler(), 64, time.Second, nil)
+	for _, name := range []string{"", "main", "has space", "a/b", "0123456789012345678901234567890123"} {
+		if _, err := m.Create(name, 640, 480); err == nil {
+			t.Errorf("Create(%q) succeeded, want an error", name)
+		}
+	}
+	if got := m.List(); len(got) != 0 {
+		t.Errorf("List() = %v, want no sessions", got)
+	}
+	if m.Destroy("missing") {
+		t.Error("Destroy of an unknown session reported success")
+	}
+}
+
+func TestSessionManagerServeWebSocket(t *testing.T) {
+	mainCalled := false
+	m := NewSessionManager(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mainCalled = true
+	}), 64, time.Second, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws/{session}", m.ServeWebSocket)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws/main", nil))
+	if !mainCalled {
+		t.Error("/ws/main did not reach the main stream")
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws/kiosk", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/ws/kiosk status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,238 @@
+package compositor
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Linux input keycodes of the compositor shortcuts
+const (
+	keyLeftMeta   = 125
+	keyRightMeta  = 126
+	keyLeftCtrl   = 29
+	keyRightCtrl  = 97
+	keyLeftShift  = 42
+	keyRightShift = 54
+	keySpace      = 57
+	key1          = 2
+	keyLeft       = 105
+	keyRight      = 106
+	keyG          = 34
+	keyS          = 31
+	keyQ          = 16
+	keyF          = 33
+	keyR          = 19
+)
+
+// Shortcuts turns key chords into compositor actions by their
+// KeyBindings, the defaults being:
+//
+//   - Super+Space picks the next layout, Super+1 to Super+5 a layout by
+//     its place in layoutModes
+//   - Super+Ctrl+Left/Right switches to the previous or next workspace
+//   - Super+Shift+Left/Right moves the top window there and follows it
+//   - Super+G locks the pointer to the top window, or releases it
+//   - Super+S shows or hides the stats overlay
+//   - Super+Q closes the top window and Super+F makes it fullscreen, or
+//     puts it back
+//   - Super+R pauses or resumes the -record recording
+//
+// The shortcut keys are kept from clients; the modifiers are passed on.
+// An action whose part of the compositor is missing is not a shortcut.
+// In kiosk mode the kiosk's escape chord is the only shortcut.
+type Shortcuts struct {
+	Layout       *LayoutEngine
+	Workspaces   *Workspaces
+	PointerLock  *PointerLock
+	StatsOverlay *StatsOverlay
+	Recorder     *Recorder
+	Kiosk        *Kiosk
+	// Notify, if set, is told what a shortcut did, for an on-screen
+	// notification
+	Notify func(text string)
+
+	mu        sync.Mutex
+	bindings  KeyBindings
+	meta      [2]bool
+	ctrl      [2]bool
+	shift     [2]bool
+	alt       [2]bool
+	swallowed map[uint32]bool
+}
+
+// SetBindings replaces the key bindings
+func (s *Shortcuts) SetBindings(bindings KeyBindings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bindings = bindings
+}
+
+// Bindings returns the key bindings
+func (s *Shortcuts) Bindings() KeyBindings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bindings == nil {
+		s.bindings = DefaultKeyBindings()
+	}
+	return s.bindings
+}
+
+// Handle reports whether a key event was a shortcut and must not reach
+// clients
+func (s *Shortcuts) Handle(keycode uint32, pressed bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch keycode {
+	case keyLeftMeta, keyRightMeta:
+		s.meta[keycode-keyLeftMeta] = pressed
+		return false
+	case keyLeftCtrl:
+		s.ctrl[0] = pressed
+		return false
+	case keyRightCtrl:
+		s.ctrl[1] = pressed
+		return false
+	case keyLeftShift:
+		s.shift[0] = pressed
+		return false
+	case keyRightShift:
+		s.shift[1] = pressed
+		return false
+	case keyLeftAlt:
+		s.alt[0] = pressed
+		return false
+	case keyRightAlt:
+		s.alt[1] = pressed
+		return false
+	}
+	if !pressed {
+		if s.swallowed[keycode] {
+			delete(s.swallowed, keycode)
+			return true
+		}
+		return false
+	}
+
+	chord := KeyChord{key: keycode}
+	for _, held := range []struct {
+		keys [2]bool
+		mod  keyMods
+	}{{s.meta, modSuper}, {s.ctrl, modCtrl}, {s.shift, modShift}, {s.alt, modAlt}} {
+		if held.keys[0] || held.keys[1] {
+			chord.mods |= held.mod
+		}
+	}
+	if s.bindings == nil {
+		s.bindings = DefaultKeyBindings()
+	}
+	bindings := s.bindings
+	if s.Kiosk.Active() {
+		bindings = KeyBindings{s.Kiosk.Escape: actionKioskExit}
+	}
+	action, ok := bindings[chord]
+	if !ok || !s.run(action) {
+		return false
+	}
+	if s.swallowed == nil {
+		s.swallowed = make(map[uint32]bool)
+	}
+	s.swallowed[keycode] = true
+	return true
+}
+
+// run runs an action, reporting false when this compositor has nothing to
+// run it on
+func (s *Shortcuts) run(action string) bool {
+	if mode, ok := strings.CutPrefix(action, actionLayout); ok {
+		s.Layout.SetMode(LayoutMode(mode))
+		log.Printf("Layout: %s", mode)
+		return true
+	}
+	if n, ok := strings.CutPrefix(action, actionWorkspace); ok {
+		if s.Workspaces == nil {
+			return false
+		}
+		workspace, _ := strconv.Atoi(n)
+		if err := s.Workspaces.Switch(workspace); err != nil {
+			log.Printf("Workspace: %v", err)
+			return true
+		}
+		log.Printf("Workspace: %d", workspace)
+		return true
+	}
+
+	switch action {
+	case actionNextLayout:
+		log.Printf("Layout: %s", s.Layout.Cycle())
+	case actionWorkspaceNext, actionWorkspacePrev, actionMoveNext, actionMovePrev:
+		if s.Workspaces == nil {
+			return false
+		}
+		delta := 1
+		if action == actionWorkspacePrev || action == actionMovePrev {
+			delta = -1
+		}
+		if action == actionMoveNext || action == actionMovePrev {
+			s.Workspaces.MoveTop(delta)
+		} else {
+			s.Workspaces.Step(delta)
+		}
+		log.Printf("Workspace: %d", s.Workspaces.Current())
+	case actionPointerLock:
+		if s.PointerLock == nil {
+			return false
+		}
+		log.Printf("Pointer locked: %v", s.PointerLock.Toggle())
+	case actionStatsOverlay:
+		if s.StatsOverlay == nil {
+			return false
+		}
+		log.Printf("Stats overlay: %v", s.StatsOverlay.Toggle())
+	case actionClose, actionFullscreen:
+		if s.Workspaces == nil {
+			return false
+		}
+		top, ok := s.Workspaces.Top()
+		if !ok {
+			// Nothing to act on; the key still does nothing else
+			return true
+		}
+		if action == actionClose {
+			if err := s.Layout.CloseWindow(top); err != nil {
+				log.Printf("Close: %v", err)
+			}
+			return true
+		}
+		fullscreen, err := s.Layout.ToggleFullscreen(top)
+		if err != nil {
+			log.Printf("Fullscreen: %v", err)
+			return true
+		}
+		log.Printf("Fullscreen: %v", fullscreen)
+	case actionKioskExit:
+		s.Kiosk.Leave()
+		log.Printf("Kiosk: left kiosk mode")
+		if s.Notify != nil {
+			s.Notify("Left kiosk mode")
+		}
+	case actionRecord:
+		if s.Recorder == nil {
+			return false
+		}
+		recording := s.Recorder.Toggle(time.Now())
+		log.Printf("Recording: %v", recording)
+		if s.Notify != nil {
+			if recording {
+				s.Notify("Recording started")
+			} else {
+				s.Notify("Recording paused")
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+)
+
+func TestLayoutShortcuts(t *testing.T) {
+	l := layoutWithWindows(LayoutFullscreen, 0)
+	s := &Shortcuts{Layout: l}
+
+	if s.Handle(keySpace, true) || s.Handle(keySpace, false) {
+		t.Error("Space without Super was taken as a shortcut")
+	}
+	if s.Handle(keyLeftMeta, true) {
+		t.Error("Super was kept from clients")
+	}
+	if !s.Handle(keySpace, true) || l.Mode() != LayoutFloating {
+		t.Errorf("Super+Space: mode %s, want %s", l.Mode(), LayoutFloating)
+	}
+	if !s.Handle(key1+3, true) || l.Mode() != LayoutGrid {
+		t.Errorf("Super+4: mode %s, want %s", l.Mode(), LayoutGrid)
+	}
+	s.Handle(keyLeftMeta, false)
+	// Releases of swallowed keys are swallowed too, even after Super
+	if !s.Handle(keySpace, false) || !s.Handle(key1+3, false) {
+		t.Error("release of a shortcut key reached clients")
+	}
+	if s.Handle(key1, true) {
+		t.Error("1 without Super was taken as a shortcut")
+	}
+}
+
+func TestWorkspaceShortcuts(t *testing.T) {
+	ws, _ := NewWorkspaces(3, nil)
+	s := &Shortcuts{Layout: layoutWithWindows(LayoutFullscreen, 0), Workspaces: ws}
+
+	s.Handle(keyRightMeta, true)
+	if s.Handle(keyLeft, true) {
+		t.Error("Super+Left was taken as a shortcut")
+	}
+	s.Handle(keyLeftCtrl, true)
+	if !s.Handle(keyLeft, true) || ws.Current() != 3 {
+		t.Errorf("Super+Ctrl+Left from 1: workspace %d, want 3", ws.Current())
+	}
+	s.Handle(keyLeftCtrl, false)
+	s.Handle(keyRightShift, true)
+	if !s.Handle(keyRight, true) || ws.Current() != 1 {
+		t.Errorf("Super+Shift+Right from 3: workspace %d, want 1", ws.Current())
+	}
+}
+
+func TestPointerLockShortcut(t *testing.T) {
+	lock := NewPointerLock(800, 600)
+	s := &Shortcuts{Layout: layoutWithWindows(LayoutFloating, 0), PointerLock: lock}
+
+	s.Handle(keyLeftMeta, true)
+	if !s.Handle(keyG, true) || !lock.Locked() {
+		t.Error("Super+G did not lock the pointer")
+	}
+	if !s.Handle(keyG, false) {
+		t.Error("release of G reached clients")
+	}
+	s.Handle(keyG, true)
+	if lock.Locked() {
+		t.Error("second Super+G did not release the pointer")
+	}
+}
+
+func TestKeyBindings(t *testing.T) {
+	bindings, err := parseKeyBindings(`{"ctrl+alt+return": "layout:grid", "Super+Space": "", "F12": "stats-overlay"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay := NewStatsOverlay(false)
+	l := layoutWithWindows(LayoutFullscreen, 0)
+	s := &Shortcuts{Layout: l, StatsOverlay: overlay}
+	s.SetBindings(bindings)
+
+	if !s.Handle(88, true) || !overlay.Enabled() {
+		t.Error("F12 did not show the stats overlay")
+	}
+	s.Handle(keyLeftMeta, true)
+	if s.Handle(keySpace, true) || l.Mode() != LayoutFullscreen {
+		t.Error("Super+Space is unbound but still switched layouts")
+	}
+	s.Handle(keyLeftMeta, false)
+	s.Handle(keyRightCtrl, true)
+	s.Handle(keyLeftAlt, true)
+	if !s.Handle(keyEnter, true) || l.Mode() != LayoutGrid {
+		t.Errorf("Ctrl+Alt+Return: mode %s, want %s", l.Mode(), LayoutGrid)
+	}
+	// Super+R has no recording to pause, so it goes to the clients
+	s.Handle(keyLeftMeta, true)
+	s.Handle(keyRightCtrl, false)
+	s.Handle(keyLeftAlt, false)
+	if s.Handle(keyR, true) {
+		t.Error("Super+R was taken without a recording")
+	}
+
+	if got := (KeyChord{modSuper | modShift, keyLeft}).String(); got != "Super+Shift+Left" {
+		t.Errorf("Expected Super+Shift+Left, got %q", got)
+	}
+	for _, bad := range []string{
+		`{"Hyper+Q": "close"}`,
+		`{"Super+Nope": "close"}`,
+		`{"Super+Q": "explode"}`,
+		`{"Super+Q": "layout:tabbed"}`,
+		`{"Super+Q": "workspace:0"}`,
+	} {
+		if _, err := parseKeyBindings(bad); err == nil {
+			t.Errorf("Expected an error for %s", bad)
+		}
+	}
+}
+
+func TestFullscreenShortcut(t *testing.T) {
+	ws, _ := NewWorkspaces(1, nil)
+	placed := workspaceToplevels(2)
+	ws.Assign(placed)
+	ws.Split(placed)
+	l := layoutWithWindows(LayoutColumns, 2)
+	l.windows[0].key, l.windows[1].key = surfaceKey{id: 1}, surfaceKey{id: 2}
+	l.Workspaces = ws
+	s := &Shortcuts{Layout: l, Workspaces: ws}
+
+	s.Handle(keyLeftMeta, true)
+	if !s.Handle(keyF, true) || !l.windows[1].fullscreen {
+		t.Fatal("Super+F did not make the top window fullscreen")
+	}
+	if got := l.arrange(); got[0] != image.Rect(0, 0, 1200, 800) || got[1] != image.Rect(0, 0, 1200, 800) {
+		t.Errorf("Expected the fullscreen window over the desktop and the other tiled alone, got %v", got)
+	}
+	s.Handle(keyF, false)
+	s.Handle(keyF, true)
+	if l.windows[1].fullscreen {
+		t.Error("Second Super+F did not put the window back")
+	}
+}
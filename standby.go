@@ -0,0 +1,112 @@
+package compositor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A hot spare checks the primary's /health every standbyInterval and takes
+// over after standbyFailures checks in a row go unanswered. A primary that
+// hangs rather than exits may still hold the HTTP port; the spare waits
+// standbyTakeoverTimeout for it.
+const (
+	standbyInterval        = time.Second
+	standbyFailures        = 3
+	standbyTakeoverTimeout = 30 * time.Second
+)
+
+// Standby is a hot spare's watch on the primary compositor. Run with the
+// primary's -display, -http and -launch, it takes over the Wayland socket
+// and the port once the primary stops answering, and starts the apps
+// again, so signage is only dark for a few seconds.
+type Standby struct {
+	Client *http.Client
+	// Health is the primary's health check URL
+	Health   string
+	Interval time.Duration
+	Failures int
+}
+
+// NewStandby watches the compositor whose HTTP server is at primary,
+// e.g. http://kiosk-a:8080
+func NewStandby(primary string) (*Standby, error) {
+	u, err := url.Parse(primary)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("want an http:// or https:// URL, got %q", primary)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/health"
+	return &Standby{
+		Client:   &http.Client{Timeout: standbyInterval},
+		Health:   u.String(),
+		Interval: standbyInterval,
+		Failures: standbyFailures,
+	}, nil
+}
+
+// Wait checks the primary until it fails Failures checks in a row,
+// reporting true, or until stop is closed or a signal comes, reporting
+// false
+func (s *Standby) Wait(stop <-chan struct{}, signals <-chan os.Signal) bool {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	failed := 0
+	seen := false
+	for {
+		if err := s.check(); err != nil {
+			failed++
+			log.Printf("Standby: primary health check %d of %d failed: %v", failed, s.Failures, err)
+			if failed >= s.Failures {
+				log.Printf("Standby: taking over from the primary")
+				return true
+			}
+		} else if failed > 0 || !seen {
+			log.Printf("Standby: primary at %s is healthy", s.Health)
+			failed, seen = 0, true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return false
+		case <-signals:
+			return false
+		}
+	}
+}
+
+// check asks the primary for its health once
+func (s *Standby) check() error {
+	resp, err := s.Client.Get(s.Health)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// Listen listens on addr, waiting up to standbyTakeoverTimeout for a hung
+// primary on this host to let go of it
+func (s *Standby) Listen(addr string) (net.Listener, error) {
+	deadline := time.Now().Add(standbyTakeoverTimeout)
+	for {
+		listener, err := listenHTTP(addr)
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) || time.Now().After(deadline) {
+			return listener, err
+		}
+		time.Sleep(s.Interval)
+	}
+}
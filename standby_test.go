@@ -0,0 +1,79 @@
+package compositor
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStandbyTakesOver(t *testing.T) {
+	var down atomic.Bool
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer primary.Close()
+
+	standby, err := NewStandby(primary.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby.Interval = 10 * time.Millisecond
+	done := make(chan bool)
+	go func() {
+		done <- standby.Wait(nil, nil)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Took over from a healthy primary")
+	case <-time.After(100 * time.Millisecond):
+	}
+	down.Store(true)
+	select {
+	case failed := <-done:
+		if !failed {
+			t.Error("Expected the primary to have failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Never took over")
+	}
+}
+
+func TestStandbyStops(t *testing.T) {
+	standby, err := NewStandby("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby.Interval = time.Hour
+	standby.Failures = 2
+	signals := make(chan os.Signal, 1)
+	signals <- os.Interrupt
+	if standby.Wait(nil, signals) {
+		t.Error("Took over after a signal")
+	}
+
+	if _, err := NewStandby("kiosk-a:8080"); err == nil {
+		t.Error("Accepted a URL without a scheme")
+	}
+}
+
+func TestStandbyListen(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby := &Standby{Interval: 10 * time.Millisecond}
+	time.AfterFunc(50*time.Millisecond, func() { held.Close() })
+	listener, err := standby.Listen(held.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected the port once it was let go, got %v", err)
+	}
+	listener.Close()
+}
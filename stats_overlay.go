@@ -0,0 +1,174 @@
+package compositor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+	"time"
+
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// The stats overlay stamps each frame with the time it was composited, in
+// milliseconds since the epoch, as a strip of square blocks along its top
+// edge: a white and a black block to find it by, then statsStampBits
+// bits, most significant first, white for 1. The blocks are big enough to
+// be read back from the stream's downscaled quality tiers.
+const (
+	statsStampBits  = 48
+	statsStampBlock = 8
+)
+
+// statsOverlayScale is the size of the overlay's font pixels
+const statsOverlayScale = 2
+
+var (
+	statsOverlayText       = color.RGBA{R: 0x80, G: 0xff, B: 0x80, A: 0xff}
+	statsOverlayBackground = color.RGBA{A: 0xc0}
+)
+
+// StatsOverlay draws the render loop's frame rate and composite time, the
+// clients and, per viewer, the encode time and bandwidth in the top left
+// corner of the desktop, over the windows, so they show in the stream.
+// Each frame is stamped with its composite time for viewers to measure
+// latency end to end. While it is shown the desktop changes every frame.
+type StatsOverlay struct {
+	mu      sync.Mutex
+	enabled bool
+	// composited are the times of the frames composited in the last
+	// second, and composite how long the newest took
+	composited []time.Time
+	composite  time.Duration
+	// sent and sampled are each viewer's bytes sent when the bandwidth
+	// was last worked out, and bandwidth the bytes a second since
+	sent      map[int]int64
+	sampled   time.Time
+	bandwidth map[int]float64
+	surface   *wayland.WlSurface
+}
+
+// NewStatsOverlay creates an overlay, shown if enabled
+func NewStatsOverlay(enabled bool) *StatsOverlay {
+	return &StatsOverlay{enabled: enabled, surface: &wayland.WlSurface{}}
+}
+
+// Enabled reports whether the overlay is shown
+func (o *StatsOverlay) Enabled() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.enabled
+}
+
+// SetEnabled shows or hides the overlay
+func (o *StatsOverlay) SetEnabled(enabled bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.enabled = enabled
+}
+
+// Toggle shows the overlay if it is hidden and hides it if it is shown,
+// returning whether it is now shown
+func (o *StatsOverlay) Toggle() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.enabled = !o.enabled
+	return o.enabled
+}
+
+// Composited records a frame composited at now and how long it took
+func (o *StatsOverlay) Composited(now time.Time, took time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.composited = append(o.composited, now)
+	for len(o.composited) > 0 && now.Sub(o.composited[0]) > time.Second {
+		o.composited = o.composited[1:]
+	}
+	o.composite = took
+}
+
+// Place returns the overlay as a surface in the top left corner of
+// bounds, stamped with now, reporting false while it is hidden
+func (o *StatsOverlay) Place(bounds image.Rectangle, clients int, viewers []stream.ViewerStreamStats, now time.Time) (PlacedSurface, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.enabled {
+		return PlacedSurface{}, false
+	}
+	o.sampleBandwidth(viewers, now)
+
+	lines := []string{
+		fmt.Sprintf("FPS %d  composite %.1fms", len(o.composited), float64(o.composite)/float64(time.Millisecond)),
+		fmt.Sprintf("clients %d  viewers %d", clients, len(viewers)),
+	}
+	for _, v := range viewers {
+		lines = append(lines, fmt.Sprintf("viewer %d  encode %.1fms  %.0f KB/s", v.ID, float64(v.EncodeTime)/float64(time.Millisecond), o.bandwidth[v.ID]/1024))
+	}
+	lines = append(lines, now.Format("15:04:05.000"))
+
+	img := renderStatsOverlay(lines, now.UnixMilli())
+	size := img.Rect.Size()
+	p := PlacedSurface{
+		SurfaceID: 1,
+		Surface:   o.surface,
+		Texture:   &wayland.Texture{Stride: uint32(img.Stride), Width: uint32(size.X), Height: uint32(size.Y), Data: img.Pix},
+		X:         bounds.Min.X,
+		Y:         bounds.Min.Y,
+		Source:    img.Rect,
+		Size:      size,
+	}
+	p.Root = protocols.ObjectID[protocols.WlSurface](p.SurfaceID)
+	return p, true
+}
+
+// sampleBandwidth works out each viewer's bandwidth once a second
+func (o *StatsOverlay) sampleBandwidth(viewers []stream.ViewerStreamStats, now time.Time) {
+	elapsed := now.Sub(o.sampled)
+	if elapsed < time.Second {
+		return
+	}
+	bandwidth := make(map[int]float64, len(viewers))
+	sent := make(map[int]int64, len(viewers))
+	for _, v := range viewers {
+		if before, ok := o.sent[v.ID]; ok {
+			bandwidth[v.ID] = float64(v.SentBytes-before) / elapsed.Seconds()
+		}
+		sent[v.ID] = v.SentBytes
+	}
+	o.sent, o.bandwidth, o.sampled = sent, bandwidth, now
+}
+
+// renderStatsOverlay draws the stamp strip for stamp and the lines under
+// it
+func renderStatsOverlay(lines []string, stamp int64) *image.RGBA {
+	pad := 2 * statsOverlayScale
+	width := (2 + statsStampBits) * statsStampBlock
+	for _, line := range lines {
+		width = max(width, textSize(line, statsOverlayScale).X+2*pad)
+	}
+	height := statsStampBlock + pad + len(lines)*cellHeight*statsOverlayScale + pad
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, img.Rect, statsOverlayBackground)
+
+	white := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	black := color.RGBA{A: 0xff}
+	block := func(i int, c color.RGBA) {
+		fillRect(img, image.Rect(i*statsStampBlock, 0, (i+1)*statsStampBlock, statsStampBlock), c)
+	}
+	block(0, white)
+	block(1, black)
+	for bit := range statsStampBits {
+		c := black
+		if stamp>>(statsStampBits-1-bit)&1 != 0 {
+			c = white
+		}
+		block(2+bit, c)
+	}
+
+	for i, line := range lines {
+		drawText(img, image.Pt(pad, statsStampBlock+pad+i*cellHeight*statsOverlayScale), line, statsOverlayScale, statsOverlayText)
+	}
+	return img
+}
@@ -0,0 +1,61 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/mmulet/pupapppupps/stream"
+)
+
+func TestStatsOverlayStamp(t *testing.T) {
+	stamp := int64(0x123456789ab)
+	img := renderStatsOverlay([]string{"FPS 60"}, stamp)
+	// white reports whether the block at i along the strip is white
+	white := func(i int) bool {
+		at := img.RGBAAt(i*statsStampBlock+statsStampBlock/2, statsStampBlock/2)
+		return at.R == 0xff && at.G == 0xff && at.B == 0xff
+	}
+	if !white(0) || white(1) {
+		t.Fatal("Expected the strip to start with a white and a black block")
+	}
+	var read int64
+	for bit := range statsStampBits {
+		read <<= 1
+		if white(2 + bit) {
+			read |= 1
+		}
+	}
+	if read != stamp {
+		t.Errorf("Expected stamp %#x, read %#x", stamp, read)
+	}
+}
+
+func TestStatsOverlay(t *testing.T) {
+	o := NewStatsOverlay(false)
+	bounds := image.Rect(10, 20, 810, 620)
+	now := time.Unix(1000, 0)
+	if _, ok := o.Place(bounds, 0, nil, now); ok {
+		t.Fatal("Placed the overlay while it is hidden")
+	}
+	if !o.Toggle() || !o.Enabled() {
+		t.Fatal("Toggle did not show the overlay")
+	}
+
+	for i := range 3 {
+		o.Composited(now.Add(time.Duration(i)*600*time.Millisecond), time.Millisecond)
+	}
+	if len(o.composited) != 2 {
+		t.Errorf("Expected 2 frames in the last second, got %d", len(o.composited))
+	}
+	viewers := []stream.ViewerStreamStats{{ID: 1, SentBytes: 1000}}
+	o.Place(bounds, 1, viewers, now)
+	viewers[0].SentBytes = 3048
+	p, ok := o.Place(bounds, 1, viewers, now.Add(2*time.Second))
+	if !ok || p.X != 10 || p.Y != 20 || p.Texture == nil {
+		t.Fatalf("Expected the overlay in the top left corner, got %+v", p)
+	}
+	if o.bandwidth[1] != 1024 {
+		t.Errorf("Expected 1024 bytes a second, got %v", o.bandwidth[1])
+	}
+}
@@ -0,0 +1,184 @@
+package stream
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+)
+
+// streamCodecs are the frame compressions the server offers, in order of
+// preference. "deflate" is raw DEFLATE (RFC 1951), which browsers decode
+// with DecompressionStream("deflate-raw").
+var streamCodecs = []string{"deflate"}
+
+// Frame flags, the byte after the header of a negotiated frame
+const (
+	frameCompressed = 1 << 0
+	// frameDelta frames are XORed with the previous frame sent to the
+	// viewer, so unchanged pixels are zero
+	frameDelta = 1 << 1
+	// frameTimestamped frames carry the wall-clock time the desktop was
+	// captured, as Unix microseconds, right after the flags
+	frameTimestamped = 1 << 2
+)
+
+// streamHello is the text message a viewer sends to negotiate the frame
+// format, and the server's answer. Viewers list the codecs they can decode;
+// the server answers with the one it picked, or none.
+type streamHello struct {
+	Type        string   `json:"type"`
+	Compression []string `json:"compression,omitempty"`
+	Delta       bool     `json:"delta,omitempty"`
+	Timestamps  bool     `json:"timestamps,omitempty"`
+	// Token resumes the role and subscriptions of an earlier connection,
+	// and Role asks a new viewer to be a controller or a spectator
+	Token string `json:"token,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+// streamSettings is the frame format agreed with a viewer. Viewers that
+// asked for timestamps are told how long after capture to show a frame, so
+// every viewer of the stream shows it at the same moment.
+type streamSettings struct {
+	Codec          string `json:"compression,omitempty"`
+	Delta          bool   `json:"delta"`
+	Timestamps     bool   `json:"timestamps,omitempty"`
+	PlayoutDelayMS int    `json:"playout_delay_ms,omitempty"`
+}
+
+// negotiateStream picks the settings for a viewer's hello: the first codec
+// the server prefers that the viewer offered
+func negotiateStream(hello streamHello) streamSettings {
+	settings := streamSettings{Delta: hello.Delta, Timestamps: hello.Timestamps}
+	for _, codec := range streamCodecs {
+		if slices.Contains(hello.Compression, codec) {
+			settings.Codec = codec
+			break
+		}
+	}
+	return settings
+}
+
+// FrameEncoder builds negotiated frame messages for one stream, keeping
+// its header, delta and output buffers and its compressor from frame to
+// frame so a steady stream allocates nothing. A message it returns is
+// only valid until the next encode.
+type FrameEncoder struct {
+	header []byte
+	delta  []byte
+	out    bytes.Buffer
+	flate  *flate.Writer
+	level  int
+}
+
+// Encode builds a negotiated frame message:
+// [width:uint32][height:uint32][stride:uint32][flags:uint8][captured:int64][payload].
+// captured, the Unix microseconds the frame was captured, is only there
+// when the captured time is not zero. With a previous frame of the same
+// size the payload is the XOR delta against it; the payload is then
+// compressed with codec, if any, at level. An uncompressed message comes
+// as the header and the payload, so the frame is not copied behind it.
+func (e *FrameEncoder) Encode(buffer, previous []byte, width, height, stride int, codec string, level int, captured time.Time) (net.Buffers, error) {
+	header := e.header[:0]
+	header = binary.LittleEndian.AppendUint32(header, uint32(width))
+	header = binary.LittleEndian.AppendUint32(header, uint32(height))
+	header = binary.LittleEndian.AppendUint32(header, uint32(stride))
+	header = append(header, 0)
+	if !captured.IsZero() {
+		header[12] |= frameTimestamped
+		header = binary.LittleEndian.AppendUint64(header, uint64(captured.UnixMicro()))
+	}
+	e.header = header
+
+	payload := buffer
+	if previous != nil && len(previous) == len(buffer) {
+		header[12] |= frameDelta
+		e.delta = slices.Grow(e.delta[:0], len(buffer))[:len(buffer)]
+		payload = e.delta
+		xorBytes(payload, buffer, previous)
+	}
+
+	switch codec {
+	case "":
+		return net.Buffers{header, payload}, nil
+	case "deflate":
+		header[12] |= frameCompressed
+		e.out.Reset()
+		e.out.Write(header)
+		if e.flate == nil || e.level != level {
+			w, err := flate.NewWriter(&e.out, level)
+			if err != nil {
+				return nil, err
+			}
+			e.flate, e.level = w, level
+		} else {
+			e.flate.Reset(&e.out)
+		}
+		if _, err := e.flate.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := e.flate.Close(); err != nil {
+			return nil, err
+		}
+		return net.Buffers{e.out.Bytes()}, nil
+	default:
+		return nil, fmt.Errorf("unknown stream codec %q", codec)
+	}
+}
+
+// encodeFrame builds a negotiated frame message in one buffer of its
+// own, see FrameEncoder.Encode
+func encodeFrame(buffer, previous []byte, width, height, stride int, codec string, level int, captured time.Time) ([]byte, error) {
+	var e FrameEncoder
+	message, err := e.Encode(buffer, previous, width, height, stride, codec, level, captured)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join(message, nil), nil
+}
+
+// DecodeFrame reverses encodeFrame, as a viewer would, given the
+// previous frame's pixels for a delta frame
+func DecodeFrame(message, previous []byte) (width, height, stride int, pixels []byte, err error) {
+	if len(message) < 13 {
+		return 0, 0, 0, nil, fmt.Errorf("frame too short: %d bytes", len(message))
+	}
+	width = int(binary.LittleEndian.Uint32(message[0:4]))
+	height = int(binary.LittleEndian.Uint32(message[4:8]))
+	stride = int(binary.LittleEndian.Uint32(message[8:12]))
+	flags := message[12]
+	pixels = message[13:]
+	if flags&frameTimestamped != 0 {
+		if len(message) < 21 {
+			return 0, 0, 0, nil, fmt.Errorf("timestamped frame too short: %d bytes", len(message))
+		}
+		pixels = message[21:]
+	}
+	if flags&frameCompressed != 0 {
+		pixels, err = io.ReadAll(flate.NewReader(bytes.NewReader(pixels)))
+		if err != nil {
+			return 0, 0, 0, nil, fmt.Errorf("inflate frame: %w", err)
+		}
+	} else {
+		pixels = bytes.Clone(pixels)
+	}
+	if flags&frameDelta != 0 {
+		if len(previous) != len(pixels) {
+			return 0, 0, 0, nil, fmt.Errorf("delta of %d bytes against %d", len(pixels), len(previous))
+		}
+		xorBytes(pixels, pixels, previous)
+	}
+	return width, height, stride, pixels, nil
+}
+
+// xorBytes sets dst[i] = a[i] ^ b[i]
+func xorBytes(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
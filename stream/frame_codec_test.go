@@ -0,0 +1,310 @@
+//go:build !noweb
+
+package stream
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// decodeFrame reverses encodeFrame, as a viewer would
+func decodeFrame(t *testing.T, message, previous []byte) (width, height int, pixels []byte) {
+	t.Helper()
+	width, height, _, pixels, err := DecodeFrame(message, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return width, height, pixels
+}
+
+func TestNegotiateStream(t *testing.T) {
+	got := negotiateStream(streamHello{Type: "hello", Compression: []string{"zstd", "deflate"}, Delta: true})
+	if got != (streamSettings{Codec: "deflate", Delta: true}) {
+		t.Errorf("Expected deflate with delta, got %+v", got)
+	}
+	got = negotiateStream(streamHello{Type: "hello", Compression: []string{"lz4"}})
+	if got != (streamSettings{}) {
+		t.Errorf("Expected no codec, got %+v", got)
+	}
+	got = negotiateStream(streamHello{Type: "hello", Timestamps: true})
+	if !got.Timestamps {
+		t.Errorf("Expected timestamps, got %+v", got)
+	}
+}
+
+func TestEncodeFrameRoundTrip(t *testing.T) {
+	// A noisy frame, so only the delta compresses well
+	first := make([]byte, 64*32*4)
+	for i := range first {
+		first[i] = byte(i * 7919 >> 3)
+	}
+	second := bytes.Clone(first)
+	copy(second[400:], []byte{1, 2, 3, 4, 5, 6, 7, 8})
+
+	for _, codec := range []string{"", "deflate"} {
+		key, err := encodeFrame(first, nil, 64, 32, 256, codec, flate.BestSpeed, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, h, pixels := decodeFrame(t, key, nil); w != 64 || h != 32 || !bytes.Equal(pixels, first) {
+			t.Errorf("%q keyframe did not round trip", codec)
+		}
+
+		delta, err := encodeFrame(second, first, 64, 32, 256, codec, flate.BestSpeed, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delta[12]&frameDelta == 0 {
+			t.Errorf("%q: expected a delta frame", codec)
+		}
+		if _, _, pixels := decodeFrame(t, delta, first); !bytes.Equal(pixels, second) {
+			t.Errorf("%q delta frame did not round trip", codec)
+		}
+		if codec == "deflate" && len(delta) >= len(key) {
+			t.Errorf("Expected the delta (%d bytes) to compress below the keyframe (%d)", len(delta), len(key))
+		}
+	}
+
+	if _, err := encodeFrame(first, nil, 64, 32, 256, "zstd", flate.BestSpeed, time.Time{}); err == nil {
+		t.Error("Expected an error for an unknown codec")
+	}
+
+	captured := time.UnixMicro(1_700_000_000_123_456)
+	stamped, err := encodeFrame(second, first, 64, 32, 256, "deflate", flate.BestSpeed, captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stamped[12] != frameCompressed|frameDelta|frameTimestamped {
+		t.Errorf("Expected a timestamped delta, got flags %d", stamped[12])
+	}
+	if got := int64(binary.LittleEndian.Uint64(stamped[13:21])); got != captured.UnixMicro() {
+		t.Errorf("Timestamp %d, want %d", got, captured.UnixMicro())
+	}
+	if _, _, pixels := decodeFrame(t, stamped, first); !bytes.Equal(pixels, second) {
+		t.Error("Timestamped frame did not round trip")
+	}
+}
+
+func TestFrameEncoderReuse(t *testing.T) {
+	var e FrameEncoder
+	previous := make([]byte, 32*16*4)
+	for i := range 4 {
+		frame := bytes.Clone(previous)
+		frame[i*97] ^= 0xff
+		// Alternate the level so the compressor is both reset and replaced
+		level := flate.BestSpeed + i/2
+		message, err := e.Encode(frame, previous, 32, 16, 128, "deflate", level, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, pixels := decodeFrame(t, bytes.Join(message, nil), previous); !bytes.Equal(pixels, frame) {
+			t.Fatalf("Frame %d did not round trip", i)
+		}
+		previous = frame
+	}
+
+	message, err := e.Encode(previous, nil, 32, 16, 128, "", flate.BestSpeed, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message) != 2 || &message[1][0] != &previous[0] {
+		t.Error("Expected an uncompressed keyframe to send the frame as it is")
+	}
+}
+
+func TestWSFrameRelease(t *testing.T) {
+	frame := newWSFrame(getFrameBuffer(16), 2, 2, 8, time.Time{})
+	frame.hold()
+	frame.release()
+	if frame.buffer == nil {
+		t.Fatal("Released the buffer while the frame was still held")
+	}
+	frame.release()
+	if frame.buffer != nil {
+		t.Error("Expected the buffer back in the pool after the last release")
+	}
+	var none *wsFrame
+	none.release()
+}
+
+func TestBroadcastNegotiatedFrames(t *testing.T) {
+	s := NewWebSocketServer()
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for s.ClientCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	frame := bytes.Repeat([]byte{1, 2, 3, 4}, 16)
+	s.BroadcastDesktopBuffer(frame, 4, 4, 16)
+	if _, message, err := conn.ReadMessage(); err != nil || len(message) != 12+len(frame) {
+		t.Fatalf("Expected a plain frame before the hello, got %d bytes (%v)", len(message), err)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello","compression":["deflate"],"delta":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.RLock()
+		negotiated := false
+		for _, client := range s.clients {
+			negotiated = client.negotiated
+		}
+		s.mu.RUnlock()
+		if negotiated {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Hello was not handled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	next := bytes.Clone(frame)
+	next[5] = 99
+	s.BroadcastDesktopBuffer(frame, 4, 4, 16)
+
+	kind, reply, err := conn.ReadMessage()
+	if err != nil || kind != websocket.TextMessage || !strings.Contains(string(reply), `"compression":"deflate"`) {
+		t.Fatalf("Expected the hello reply, got %q (%v)", reply, err)
+	}
+	_, key, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key[12] != frameCompressed {
+		t.Errorf("Expected a compressed keyframe after the hello, got flags %d", key[12])
+	}
+	_, _, previous := decodeFrame(t, key, nil)
+	s.BroadcastDesktopBuffer(next, 4, 4, 16)
+	_, delta, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delta[12] != frameCompressed|frameDelta {
+		t.Errorf("Expected a compressed delta, got flags %d", delta[12])
+	}
+	if _, _, pixels := decodeFrame(t, delta, previous); !bytes.Equal(pixels, next) {
+		t.Error("Delta frame did not decode to the broadcast buffer")
+	}
+}
+
+func TestClockReply(t *testing.T) {
+	s := NewWebSocketServer()
+	s.SetPlayoutDelay(80 * time.Millisecond)
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Clock replies go out at once, without waiting for a frame
+	before := time.Now()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"clock","client_time":1234.5}`)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clock streamClock
+	if err := json.Unmarshal(message, &clock); err != nil || clock.Type != "clock" {
+		t.Fatalf("Expected a clock reply, got %q (%v)", message, err)
+	}
+	if clock.ClientTime != 1234.5 {
+		t.Errorf("Client time %v was not echoed", clock.ClientTime)
+	}
+	if clock.ReceivedUS < before.UnixMicro() || clock.SentUS < clock.ReceivedUS || clock.SentUS > time.Now().UnixMicro() {
+		t.Errorf("Received at %d and sent at %d, want both in order since %d", clock.ReceivedUS, clock.SentUS, before.UnixMicro())
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello","timestamps":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.RLock()
+		negotiated := false
+		for _, client := range s.clients {
+			negotiated = client.negotiated
+		}
+		s.mu.RUnlock()
+		if negotiated {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Hello was not handled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.BroadcastDesktopBuffer(bytes.Repeat([]byte{1, 2, 3, 4}, 4), 2, 2, 8)
+	if _, reply, err := conn.ReadMessage(); err != nil || !strings.Contains(string(reply), `"playout_delay_ms":80`) {
+		t.Fatalf("Expected the playout delay in the hello reply, got %q (%v)", reply, err)
+	}
+	_, frame, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame[12]&frameTimestamped == 0 {
+		t.Fatalf("Expected a timestamped frame, got flags %d", frame[12])
+	}
+	if captured := time.UnixMicro(int64(binary.LittleEndian.Uint64(frame[13:21]))); captured.Before(before) || captured.After(time.Now()) {
+		t.Errorf("Frame captured at %v, want since %v", captured, before)
+	}
+}
+
+func TestFrameWantedByNewViewers(t *testing.T) {
+	s := NewWebSocketServer()
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer server.Close()
+
+	frame := bytes.Repeat([]byte{1, 2, 3, 4}, 4)
+	s.BroadcastDesktopBuffer(frame, 2, 2, 8)
+	if s.FrameWanted() {
+		t.Error("No viewer is waiting for a frame")
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for s.ClientCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if !s.FrameWanted() {
+		t.Fatal("A new viewer should want the unchanged desktop")
+	}
+	s.BroadcastDesktopBuffer(frame, 2, 2, 8)
+	if s.FrameWanted() {
+		t.Error("The viewer was sent the desktop")
+	}
+	if _, message, err := conn.ReadMessage(); err != nil || len(message) != 12+len(frame) {
+		t.Fatalf("Expected the desktop, got %d bytes (%v)", len(message), err)
+	}
+
+	s.Resync()
+	if !s.FrameWanted() {
+		t.Error("A resync should send the desktop again")
+	}
+}
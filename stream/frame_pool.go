@@ -0,0 +1,60 @@
+package stream
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// framePool keeps the buffers of frames no writer needs any more, so a
+// steady stream reuses a handful of desktop sized buffers instead of
+// allocating one a tick
+var framePool sync.Pool
+
+// getFrameBuffer returns a buffer of n bytes, reused if one is free
+func getFrameBuffer(n int) []byte {
+	if b, ok := framePool.Get().(*[]byte); ok && cap(*b) >= n {
+		return (*b)[:n]
+	}
+	return make([]byte, n)
+}
+
+// putFrameBuffer gives b back for getFrameBuffer to reuse
+func putFrameBuffer(b []byte) {
+	framePool.Put(&b)
+}
+
+// wsFrame is a broadcast desktop buffer, or a viewer's downscaled copy of
+// one, shared by the writers. Its buffer comes from framePool and goes
+// back once every holder has released it.
+type wsFrame struct {
+	buffer                []byte
+	width, height, stride int
+	// captured is when the frame was broadcast, for timestamped frames
+	captured time.Time
+	refs     atomic.Int32
+}
+
+// newWSFrame wraps a pooled buffer in a frame with one holder
+func newWSFrame(buffer []byte, width, height, stride int, captured time.Time) *wsFrame {
+	frame := &wsFrame{buffer: buffer, width: width, height: height, stride: stride, captured: captured}
+	frame.refs.Store(1)
+	return frame
+}
+
+// hold adds a holder
+func (f *wsFrame) hold() {
+	f.refs.Add(1)
+}
+
+// release drops a holder, giving the buffer back after the last. A nil
+// frame is ignored.
+func (f *wsFrame) release() {
+	if f == nil {
+		return
+	}
+	if f.refs.Add(-1) == 0 {
+		putFrameBuffer(f.buffer)
+		f.buffer = nil
+	}
+}
@@ -0,0 +1,223 @@
+//go:build !noweb
+
+package stream
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// Input control modes: with shared control every controller's input goes
+// to the clients; with exclusive control only the one viewer holding
+// control's does, and the others ask for it
+const (
+	ControlShared    = "shared"
+	ControlExclusive = "exclusive"
+)
+
+// ControlInfo is who holds input control, for the control API
+type ControlInfo struct {
+	Mode string `json:"mode"`
+	// Holder is the viewer holding control, 0 for nobody
+	Holder int `json:"holder"`
+	// Requests are the viewers asking for control, in the order they asked
+	Requests []int `json:"requests"`
+}
+
+// SetExclusiveControl switches between shared and exclusive input control
+func (s *WebSocketServer) SetExclusiveControl(exclusive bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exclusiveControl = exclusive
+	if !exclusive {
+		s.controlHolder, s.controlRequests = nil, nil
+	}
+	s.announceControl()
+}
+
+// mayControl reports whether a viewer's input and window actions go to
+// the clients
+func (s *WebSocketServer) mayControl(client *wsClient) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return client.role != RoleSpectator && (!s.exclusiveControl || s.controlHolder == client)
+}
+
+// handleControl handles a viewer asking for control,
+// {"type":"request_control"}, the holder handing it to a viewer,
+// {"type":"grant_control","viewer":3}, or giving it up,
+// {"type":"release_control"}. With shared control they are ignored.
+func (s *WebSocketServer) handleControl(client *wsClient, kind string, message []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.exclusiveControl || client.role == RoleSpectator {
+		return
+	}
+	switch kind {
+	case "request_control":
+		switch {
+		case s.controlHolder == nil:
+			s.giveControl(client)
+		case s.controlHolder != client && !slices.Contains(s.controlRequests, client):
+			s.controlRequests = append(s.controlRequests, client)
+			s.announceControl()
+		}
+	case "grant_control":
+		var request struct {
+			Viewer int `json:"viewer"`
+		}
+		if s.controlHolder != client || json.Unmarshal(message, &request) != nil {
+			return
+		}
+		if target := s.controller(request.Viewer); target != nil {
+			s.giveControl(target)
+		}
+	case "release_control":
+		if s.controlHolder == client {
+			s.passControl()
+		}
+	}
+}
+
+// controller returns the connected viewer with id that may hold control,
+// or nil. Call it with s.mu held.
+func (s *WebSocketServer) controller(id int) *wsClient {
+	for _, c := range s.clients {
+		if c.id == id && c.role != RoleSpectator {
+			return c
+		}
+	}
+	return nil
+}
+
+// giveControl makes client, or nobody if it is nil, the holder and tells
+// every viewer. Call it with s.mu held.
+func (s *WebSocketServer) giveControl(client *wsClient) {
+	changed := s.controlHolder != client
+	s.controlHolder = client
+	if changed && s.controlHandler != nil {
+		holder := 0
+		if client != nil {
+			holder = client.id
+		}
+		s.controlHandler(holder)
+	}
+	s.controlRequests = slices.DeleteFunc(s.controlRequests, func(c *wsClient) bool { return c == client })
+	s.announceControl()
+}
+
+// passControl gives control to the viewer that asked first, or nobody.
+// Call it with s.mu held.
+func (s *WebSocketServer) passControl() {
+	var next *wsClient
+	if len(s.controlRequests) > 0 {
+		next = s.controlRequests[0]
+	}
+	s.giveControl(next)
+}
+
+// joinControl gives a viewer that said hello control if it may hold it
+// and nobody does, or takes it away if it may no longer. Call it with s.mu
+// held.
+func (s *WebSocketServer) joinControl(client *wsClient) {
+	if !s.exclusiveControl {
+		return
+	}
+	switch {
+	case client.role == RoleSpectator && s.controlHolder == client:
+		s.passControl()
+	case client.role != RoleSpectator && s.controlHolder == nil:
+		s.giveControl(client)
+	default:
+		// The viewer learns who holds control
+		s.announceControl()
+	}
+}
+
+// leaveControl passes control on from a viewer that disconnected and
+// forgets its request. Call it with s.mu held.
+func (s *WebSocketServer) leaveControl(client *wsClient) {
+	if !s.exclusiveControl {
+		return
+	}
+	s.controlRequests = slices.DeleteFunc(s.controlRequests, func(c *wsClient) bool { return c == client })
+	if s.controlHolder == client {
+		s.passControl()
+	} else {
+		s.announceControl()
+	}
+}
+
+// announceControl tells every viewer that said hello who holds control;
+// the holder also hears who is asking for it. Call it with s.mu held.
+func (s *WebSocketServer) announceControl() {
+	info := s.controlInfo()
+	for _, client := range s.clients {
+		if !client.negotiated {
+			continue
+		}
+		notice := struct {
+			Type     string `json:"type"`
+			Mode     string `json:"mode"`
+			Holder   int    `json:"holder"`
+			Yours    bool   `json:"yours"`
+			Requests []int  `json:"requests,omitempty"`
+		}{Type: "control", Mode: info.Mode, Holder: info.Holder, Yours: s.controlHolder == client}
+		if notice.Yours {
+			notice.Requests = info.Requests
+		}
+		client.controlNotice, _ = json.Marshal(notice)
+		wakeWriter(client)
+	}
+}
+
+// controlInfo describes input control. Call it with s.mu held.
+func (s *WebSocketServer) controlInfo() ControlInfo {
+	info := ControlInfo{Mode: ControlShared, Requests: []int{}}
+	if !s.exclusiveControl {
+		return info
+	}
+	info.Mode = ControlExclusive
+	if s.controlHolder != nil {
+		info.Holder = s.controlHolder.id
+	}
+	for _, c := range s.controlRequests {
+		info.Requests = append(info.Requests, c.id)
+	}
+	return info
+}
+
+// Control returns who holds input control
+func (s *WebSocketServer) Control() ControlInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.controlInfo()
+}
+
+// GrantControl gives input control to the viewer with id, reporting
+// whether there is one that may hold it
+func (s *WebSocketServer) GrantControl(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target := s.controller(id)
+	if target == nil {
+		return false
+	}
+	s.giveControl(target)
+	return true
+}
+
+// RevokeControl takes input control from its holder and drops the
+// requests for it, leaving nobody holding it until a viewer asks again,
+// and reports the viewer it was taken from, 0 for nobody
+func (s *WebSocketServer) RevokeControl() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := 0
+	if s.controlHolder != nil {
+		id = s.controlHolder.id
+	}
+	s.controlRequests = nil
+	s.giveControl(nil)
+	return id
+}
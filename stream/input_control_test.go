@@ -0,0 +1,112 @@
+//go:build !noweb
+
+package stream
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestExclusiveControl(t *testing.T) {
+	s := NewWebSocketServer()
+	s.SetExclusiveControl(true)
+	keys := make(chan uint32, 4)
+	s.SetKeyboardHandler(func(keycode uint32, pressed bool) {
+		keys <- keycode
+	})
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer server.Close()
+
+	type notice struct {
+		Type     string `json:"type"`
+		Holder   int    `json:"holder"`
+		Yours    bool   `json:"yours"`
+		Requests []int  `json:"requests"`
+	}
+	// readControl skips to the next control notice
+	readControl := func(conn *websocket.Conn) notice {
+		for {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var n notice
+			if json.Unmarshal(data, &n) == nil && n.Type == "control" {
+				return n
+			}
+		}
+	}
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello"}`))
+		return conn
+	}
+	press := func(conn *websocket.Conn, keycode byte) {
+		conn.WriteMessage(websocket.BinaryMessage, []byte{InputKeyboard, keycode, 0, 0, 0, 1})
+	}
+	expectKey := func(want uint32) {
+		select {
+		case key := <-keys:
+			if key != want {
+				t.Errorf("Expected key %d, got %d", want, key)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Key %d never arrived", want)
+		}
+	}
+
+	// The first viewer gets control, the second watches
+	first := dial()
+	defer first.Close()
+	if n := readControl(first); !n.Yours || n.Holder != 1 {
+		t.Fatalf("Expected the first viewer to hold control, got %+v", n)
+	}
+	second := dial()
+	defer second.Close()
+	if n := readControl(second); n.Yours || n.Holder != 1 {
+		t.Fatalf("Expected the second viewer to watch, got %+v", n)
+	}
+	press(second, 30)
+	press(first, 31)
+	expectKey(31)
+
+	// The second asks, and the first hands control over
+	second.WriteMessage(websocket.TextMessage, []byte(`{"type":"request_control"}`))
+	for n := readControl(first); len(n.Requests) == 0; n = readControl(first) {
+	}
+	first.WriteMessage(websocket.TextMessage, []byte(`{"type":"grant_control","viewer":2}`))
+	for n := readControl(second); !n.Yours; n = readControl(second) {
+	}
+	press(first, 32)
+	press(second, 33)
+	expectKey(33)
+	if info := s.Control(); info.Holder != 2 || len(info.Requests) != 0 {
+		t.Errorf("Expected viewer 2 to hold control, got %+v", info)
+	}
+
+	// The admin takes it away
+	if id := s.RevokeControl(); id != 2 {
+		t.Errorf("Expected control taken from viewer 2, got %d", id)
+	}
+	for n := readControl(second); n.Yours; n = readControl(second) {
+	}
+	press(second, 34)
+	select {
+	case key := <-keys:
+		t.Errorf("Key %d arrived after control was revoked", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if s.GrantControl(7) {
+		t.Error("Granted control to a viewer that does not exist")
+	}
+}
@@ -0,0 +1,194 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// mjpegWriteTimeout drops MJPEG viewers that stop reading
+const mjpegWriteTimeout = 10 * time.Second
+
+// The bounds of the JPEG quality and frame rate, for the defaults and for
+// ?quality= and ?fps= alike
+const (
+	mjpegMinQuality, mjpegMaxQuality = 1, 100
+	mjpegMinFPS, mjpegMaxFPS         = 1, 60
+)
+
+// MJPEGStreams serves the desktop as multipart/x-mixed-replace JPEG
+// streams, for viewers that cannot use WebSockets. Each viewer gets frames
+// at its own rate; frames are encoded on the viewer's goroutine.
+type MJPEGStreams struct {
+	mu sync.Mutex
+	// quality and fps are the defaults for viewers that don't ask for
+	// ?quality= or ?fps=
+	quality int
+	fps     int
+	viewers map[*mjpegViewer]bool
+}
+
+type mjpegViewer struct {
+	interval time.Duration
+	next     time.Time
+	frames   chan *image.RGBA
+}
+
+// NewMJPEGStreams creates a stream source with no viewers
+func NewMJPEGStreams(quality, fps int) *MJPEGStreams {
+	return &MJPEGStreams{
+		quality: quality,
+		fps:     fps,
+		viewers: make(map[*mjpegViewer]bool),
+	}
+}
+
+// SetDefaults sets the quality and frame rate of viewers that don't ask
+// for their own, reporting either out of bounds
+func (m *MJPEGStreams) SetDefaults(quality, fps int) error {
+	if err := checkRange("quality", quality, mjpegMinQuality, mjpegMaxQuality); err != nil {
+		return err
+	}
+	if err := checkRange("fps", fps, mjpegMinFPS, mjpegMaxFPS); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quality, m.fps = quality, fps
+	return nil
+}
+
+// Due reports whether a viewer is waiting for its next frame
+func (m *MJPEGStreams) Due(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for v := range m.viewers {
+		if !now.Before(v.next) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish hands a copy of the desktop buffer to the viewers whose next
+// frame is due. It never blocks the render loop; a viewer still encoding
+// the previous frame skips this one.
+func (m *MJPEGStreams) Publish(buffer []byte, width, height, stride int, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var frame *image.RGBA
+	for v := range m.viewers {
+		if now.Before(v.next) {
+			continue
+		}
+		if frame == nil {
+			frame = image.NewRGBA(image.Rect(0, 0, width, height))
+			for y := 0; y < height && (y+1)*stride <= len(buffer); y++ {
+				copy(frame.Pix[y*frame.Stride:(y+1)*frame.Stride], buffer[y*stride:])
+			}
+		}
+		select {
+		case v.frames <- frame:
+			v.next = now.Add(v.interval)
+		default:
+		}
+	}
+}
+
+// ServeHTTP streams frames until the viewer goes away. ?quality= (1-100)
+// and ?fps= (1-60) override the defaults.
+func (m *MJPEGStreams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defaultQuality, defaultFPS := m.quality, m.fps
+	m.mu.Unlock()
+	quality, err := queryInt(r, "quality", defaultQuality, mjpegMinQuality, mjpegMaxQuality)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fps, err := queryInt(r, "fps", defaultFPS, mjpegMinFPS, mjpegMaxFPS)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	viewer := &mjpegViewer{
+		interval: time.Second / time.Duration(fps),
+		frames:   make(chan *image.RGBA, 1),
+	}
+	m.mu.Lock()
+	m.viewers[viewer] = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.viewers, viewer)
+		m.mu.Unlock()
+	}()
+
+	const boundary = "frame"
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// The server's write timeout would end the stream, so each frame
+	// gets its own deadline instead
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	for {
+		var frame *image.RGBA
+		select {
+		case frame = <-viewer.frames:
+		case <-r.Context().Done():
+			return
+		}
+
+		buf.Reset()
+		if err := jpeg.Encode(&buf, frame, &jpeg.Options{Quality: quality}); err != nil {
+			return
+		}
+		rc.SetWriteDeadline(time.Now().Add(mjpegWriteTimeout))
+		if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", boundary, buf.Len()); err != nil {
+			return
+		}
+		if _, err := w.Write(append(buf.Bytes(), '\r', '\n')); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// queryInt reads an integer query parameter within [lo, hi], or def when
+// it is absent
+func queryInt(r *http.Request, name string, def, lo, hi int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, lo, hi)
+	}
+	if err := checkRange(name, v, lo, hi); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// checkRange reports v outside [lo, hi]
+func checkRange(name string, v, lo, hi int) error {
+	if v < lo || v > hi {
+		return fmt.Errorf("%s must be between %d and %d", name, lo, hi)
+	}
+	return nil
+}
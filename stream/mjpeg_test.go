@@ -0,0 +1,88 @@
+package stream
+
+import (
+	"image/jpeg"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMJPEGStream(t *testing.T) {
+	streams := NewMJPEGStreams(75, 10)
+	server := httptest.NewServer(streams)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?fps=60&quality=50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	// Publish frames until the viewer has read two
+	buffer := make([]byte, 4*3*12)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				if streams.Due(time.Now()) {
+					streams.Publish(buffer, 3, 4, 12, time.Now())
+				}
+			}
+		}
+	}()
+
+	parts := multipart.NewReader(resp.Body, params["boundary"])
+	for i := 0; i < 2; i++ {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatalf("Frame %d: %v", i, err)
+		}
+		img, err := jpeg.Decode(part)
+		if err != nil {
+			t.Fatalf("Frame %d is not a JPEG: %v", i, err)
+		}
+		if size := img.Bounds().Size(); size.X != 3 || size.Y != 4 {
+			t.Errorf("Expected a 3x4 frame, got %v", size)
+		}
+	}
+}
+
+func TestMJPEGRejectsBadQuery(t *testing.T) {
+	streams := NewMJPEGStreams(75, 10)
+	for _, query := range []string{"?quality=0", "?fps=abc", "?fps=1000"} {
+		rec := httptest.NewRecorder()
+		streams.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream.mjpeg"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+	if streams.Due(time.Now()) {
+		t.Error("Rejected requests should not leave viewers behind")
+	}
+}
+
+func TestMJPEGSetDefaults(t *testing.T) {
+	streams := NewMJPEGStreams(75, 10)
+	for _, bad := range [][2]int{{0, 10}, {101, 10}, {75, 0}, {75, 61}} {
+		if err := streams.SetDefaults(bad[0], bad[1]); err == nil {
+			t.Errorf("Expected quality %d, fps %d rejected", bad[0], bad[1])
+		}
+	}
+	if streams.quality != 75 || streams.fps != 10 {
+		t.Errorf("Rejected defaults were kept: quality %d, fps %d", streams.quality, streams.fps)
+	}
+	if err := streams.SetDefaults(50, 60); err != nil || streams.quality != 50 || streams.fps != 60 {
+		t.Errorf("Expected quality 50, fps 60, got %d, %d: %v", streams.quality, streams.fps, err)
+	}
+}
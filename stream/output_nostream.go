@@ -0,0 +1,10 @@
+//go:build nostream
+
+package stream
+
+import "fmt"
+
+// NewOutputSink fails: the -output sinks are left out of this build
+func NewOutputSink(spec string, fps int) (OutputSink, error) {
+	return nil, fmt.Errorf("output %q: this build has no -output sinks (built with nostream)", spec)
+}
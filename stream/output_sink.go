@@ -0,0 +1,164 @@
+// Package stream sends the compositor's desktop out: to the browser viewer
+// over WebSockets, as MJPEG and screenshots, and to the -output sinks. The
+// WebSocket server is left out of builds with the noweb tag, and the
+// -output sinks of builds with nostream.
+package stream
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+)
+
+// OutputSink is somewhere the composited desktop goes: the WebSocket
+// viewers, a live stream, a virtual webcam or a pipe. Stream is called on
+// the render loop every frame the session is not idle and must not block;
+// sinks that do slow work do it on their own goroutine, so several sinks
+// run side by side without holding each other up.
+type OutputSink interface {
+	Stream(desktop render.TextureSource)
+	Close() error
+}
+
+// sinkKeepalive is how often the ffmpeg sinks send the last frame again
+// while the desktop does not change, so live streams and webcam readers
+// do not time out
+const sinkKeepalive = time.Second
+
+// sinkCloseTimeout is how long Close waits for a sink's writer to finish,
+// which a named pipe nobody opened would otherwise block forever
+const sinkCloseTimeout = 5 * time.Second
+
+// ParseOutputSinks creates the sinks of a comma separated -output list
+func ParseOutputSinks(specs string, fps int) ([]OutputSink, error) {
+	var sinks []OutputSink
+	for spec := range strings.SplitSeq(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		sink, err := NewOutputSink(spec, fps)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// SinkFrame is a copy of a frame waiting for a sink's writer, with the
+// rows packed, and the source's damage count and time it was handed over
+type SinkFrame struct {
+	Pixels   []byte
+	Size     image.Point
+	Sequence uint64
+	Time     time.Time
+}
+
+// FrameWriter writes a FrameSink's frames, on the sink's goroutine
+type FrameWriter interface {
+	WriteFrame(frame SinkFrame) error
+	Close() error
+}
+
+// FrameSink hands frames from the render loop to a writer on its own
+// goroutine. A frame that arrives while the writer is still busy with the
+// one before replaces it, so a slow sink costs frames, not the render
+// loop's time.
+type FrameSink struct {
+	name   string
+	writer FrameWriter
+	frames chan SinkFrame
+	done   chan struct{}
+	// keepalive, if set, is how often an unchanged frame is sent again
+	keepalive time.Duration
+	// source, damage and sent are the frame last handed over and when,
+	// touched only by Stream on the render loop
+	source render.TextureSource
+	damage uint64
+	sent   time.Time
+}
+
+func NewFrameSink(name string, writer FrameWriter, keepalive time.Duration) *FrameSink {
+	s := &FrameSink{
+		name:      name,
+		writer:    writer,
+		frames:    make(chan SinkFrame, 1),
+		done:      make(chan struct{}),
+		keepalive: keepalive,
+	}
+	go s.writeLoop()
+	return s
+}
+
+// Stream hands the writer source's frame, unless it was the last one
+// handed over and the keepalive is not due
+func (s *FrameSink) Stream(source render.TextureSource) {
+	now := time.Now()
+	damage := source.Damage()
+	if source == s.source && damage == s.damage && (s.keepalive == 0 || now.Sub(s.sent) < s.keepalive) {
+		return
+	}
+	pixels, stride := source.Frame()
+	if len(pixels) == 0 {
+		return
+	}
+	s.source, s.damage, s.sent = source, damage, now
+	size := source.Size()
+	frame := SinkFrame{packRows(pixels, size.X, size.Y, stride), size, damage, now}
+	select {
+	case s.frames <- frame:
+		return
+	default:
+	}
+	select {
+	case <-s.frames:
+	default:
+	}
+	select {
+	case s.frames <- frame:
+	default:
+	}
+}
+
+// packRows copies the rows of an RGBA frame without the padding past
+// each row
+func packRows(pixels []byte, width, height, stride int) []byte {
+	rowBytes := width * 4
+	packed := make([]byte, rowBytes*height)
+	for y := range height {
+		copy(packed[y*rowBytes:(y+1)*rowBytes], pixels[y*stride:])
+	}
+	return packed
+}
+
+// writeLoop writes frames until Close
+func (s *FrameSink) writeLoop() {
+	defer close(s.done)
+	failed := false
+	for frame := range s.frames {
+		err := s.writer.WriteFrame(frame)
+		if err != nil && !failed {
+			log.Printf("Output %s: %v", s.name, err)
+		}
+		failed = err != nil
+	}
+}
+
+// Close writes the last frame and stops the writer
+func (s *FrameSink) Close() error {
+	close(s.frames)
+	select {
+	case <-s.done:
+	case <-time.After(sinkCloseTimeout):
+		return fmt.Errorf("output %s did not finish writing", s.name)
+	}
+	return s.writer.Close()
+}
@@ -0,0 +1,50 @@
+package stream
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// recordingWriter keeps the frames a FrameSink writes
+type recordingWriter struct {
+	frames chan SinkFrame
+}
+
+func (w *recordingWriter) WriteFrame(frame SinkFrame) error {
+	w.frames <- frame
+	return nil
+}
+
+func (w *recordingWriter) Close() error {
+	return nil
+}
+
+func TestFrameSinkKeepalive(t *testing.T) {
+	writer := &recordingWriter{frames: make(chan SinkFrame, 4)}
+	sink := NewFrameSink("test", writer, 20*time.Millisecond)
+	defer sink.Close()
+
+	source := render.NewDesktopTextureSource()
+	source.Publish(&wayland.Desktop{Width: 1, Height: 1, Stride: 4, Buffer: []byte{1, 2, 3, 4}})
+	sink.Stream(source)
+	if frame := <-writer.frames; frame.Size != image.Pt(1, 1) {
+		t.Errorf("Expected a 1x1 frame, got %v", frame.Size)
+	}
+	sink.Stream(source)
+	select {
+	case <-writer.frames:
+		t.Error("The unchanged frame was sent again before the keepalive")
+	case <-time.After(10 * time.Millisecond):
+	}
+	time.Sleep(20 * time.Millisecond)
+	sink.Stream(source)
+	select {
+	case <-writer.frames:
+	case <-time.After(5 * time.Second):
+		t.Error("The keepalive frame never came")
+	}
+}
@@ -0,0 +1,148 @@
+//go:build !nostream
+
+package stream
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sinkRestartDelay is how long an ffmpeg sink waits before starting
+// ffmpeg again after it stopped
+const sinkRestartDelay = time.Second
+
+// NewOutputSink creates a sink from a -output spec: raw:<file> writes raw
+// RGBA frames to a file or named pipe (- for stdout), rtmp:// and rtmps://
+// URLs are pushed to as a live stream, and v4l2:<device> writes to a
+// v4l2loopback device so the desktop shows up as a webcam. The ffmpeg
+// sinks are encoded at fps.
+func NewOutputSink(spec string, fps int) (OutputSink, error) {
+	switch {
+	case strings.HasPrefix(spec, "raw:"):
+		path := strings.TrimPrefix(spec, "raw:")
+		if path == "" {
+			return nil, fmt.Errorf("raw: needs a file")
+		}
+		return NewFrameSink(spec, &rawWriter{path: path}, 0), nil
+	case strings.HasPrefix(spec, "rtmp://"), strings.HasPrefix(spec, "rtmps://"):
+		return NewFrameSink(spec, &ffmpegWriter{fps: fps, output: []string{
+			"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency",
+			"-pix_fmt", "yuv420p", "-g", strconv.Itoa(2 * fps), "-f", "flv", spec,
+		}}, sinkKeepalive), nil
+	case strings.HasPrefix(spec, "v4l2:"):
+		device := strings.TrimPrefix(spec, "v4l2:")
+		if device == "" {
+			return nil, fmt.Errorf("v4l2: needs a device, e.g. v4l2:/dev/video10")
+		}
+		return NewFrameSink(spec, &ffmpegWriter{fps: fps, output: []string{
+			"-pix_fmt", "yuv420p", "-f", "v4l2", device,
+		}}, sinkKeepalive), nil
+	default:
+		return nil, fmt.Errorf("unknown output %q, want raw:<file>, rtmp://..., or v4l2:<device>", spec)
+	}
+}
+
+// rawWriter writes packed RGBA frames back to back to a file or named
+// pipe, opened with the first frame so a pipe's reader can start later.
+// After a write error nothing more is written.
+type rawWriter struct {
+	path string
+	out  io.WriteCloser
+	err  error
+}
+
+func (w *rawWriter) WriteFrame(frame SinkFrame) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.out == nil {
+		if w.path == "-" {
+			w.out = os.Stdout
+		} else if w.out, w.err = os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644); w.err != nil {
+			w.out = nil
+			return w.err
+		}
+	}
+	_, w.err = w.out.Write(frame.Pixels)
+	return w.err
+}
+
+func (w *rawWriter) Close() error {
+	if w.out == nil || w.out == os.Stdout {
+		return nil
+	}
+	return w.out.Close()
+}
+
+// ffmpegWriter feeds frames to ffmpeg as raw RGBA, which encodes them with
+// output, its output options. ffmpeg is started again when the desktop
+// changes size or, after sinkRestartDelay, when it stopped.
+type ffmpegWriter struct {
+	fps    int
+	output []string
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	size    image.Point
+	started time.Time
+}
+
+func (w *ffmpegWriter) WriteFrame(frame SinkFrame) error {
+	if w.cmd != nil && frame.Size != w.size {
+		w.Close()
+		w.started = time.Time{}
+	}
+	if w.cmd == nil {
+		if time.Since(w.started) < sinkRestartDelay {
+			return nil
+		}
+		if err := w.start(frame.Size); err != nil {
+			return err
+		}
+	}
+	if _, err := w.stdin.Write(frame.Pixels); err != nil {
+		w.Close()
+		return fmt.Errorf("ffmpeg stopped: %w", err)
+	}
+	return nil
+}
+
+// start runs ffmpeg for frames of size, stamped with the time they arrive,
+// since frames only come when the desktop changes
+func (w *ffmpegWriter) start(size image.Point) error {
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-f", "rawvideo", "-pix_fmt", "rgba", "-s", fmt.Sprintf("%dx%d", size.X, size.Y),
+		"-use_wallclock_as_timestamps", "1", "-i", "-",
+		"-r", strconv.Itoa(w.fps),
+	}
+	cmd := exec.Command("ffmpeg", append(args, w.output...)...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	w.started = time.Now()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start ffmpeg: %w", err)
+	}
+	w.cmd, w.stdin, w.size = cmd, stdin, size
+	return nil
+}
+
+// Close ends ffmpeg's input and waits for it to finish the stream
+func (w *ffmpegWriter) Close() error {
+	if w.cmd == nil {
+		return nil
+	}
+	w.stdin.Close()
+	err := w.cmd.Wait()
+	w.cmd, w.stdin = nil, nil
+	return err
+}
@@ -0,0 +1,52 @@
+//go:build !nostream
+
+package stream
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestParseOutputSinks(t *testing.T) {
+	for _, spec := range []string{"raw:", "v4l2:", "srt://host:9000", "file.raw"} {
+		if _, err := ParseOutputSinks(spec, 30); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "frames.raw")
+	sinks, err := ParseOutputSinks(" raw:"+path+", ", 30)
+	if err != nil || len(sinks) != 1 {
+		t.Fatalf("Expected one sink, got %d (%v)", len(sinks), err)
+	}
+	sinks[0].Close()
+}
+
+func TestRawOutputSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frames.raw")
+	sink, err := NewOutputSink("raw:"+path, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows are written without their padding, and each frame once
+	source := render.NewDesktopTextureSource()
+	desktop := &wayland.Desktop{Width: 1, Height: 2, Stride: 8, Buffer: []byte{1, 2, 3, 4, 0, 0, 0, 0, 5, 6, 7, 8, 0, 0, 0, 0}}
+	source.Publish(desktop)
+	sink.Stream(source)
+	sink.Stream(source)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 3, 4, 5, 6, 7, 8}; !bytes.Equal(written, want) {
+		t.Errorf("Expected %v, got %v", want, written)
+	}
+}
@@ -0,0 +1,149 @@
+package stream
+
+import (
+	"compress/flate"
+	"time"
+)
+
+// qualityTier is how much of the stream a viewer gets. Scale and the
+// compression level only apply to viewers that negotiated the frame format
+// (see streamHello); the others keep full frames at a lower rate.
+type qualityTier struct {
+	Name string
+	// Scale divides the frame's width and height
+	Scale int
+	// FrameDivisor sends every Nth frame
+	FrameDivisor int
+	// Level is the DEFLATE level for viewers using "deflate"
+	Level int
+}
+
+// QualityTierCount is how many quality tiers there are, the worst being
+// QualityTierCount()-1
+func QualityTierCount() int {
+	return len(qualityTiers)
+}
+
+// qualityTiers run from best to worst
+var qualityTiers = []qualityTier{
+	{Name: "full", Scale: 1, FrameDivisor: 1, Level: flate.BestSpeed},
+	{Name: "reduced", Scale: 1, FrameDivisor: 2, Level: flate.DefaultCompression},
+	{Name: "low", Scale: 2, FrameDivisor: 2, Level: flate.BestCompression},
+	{Name: "minimal", Scale: 2, FrameDivisor: 4, Level: flate.BestCompression},
+}
+
+const (
+	// qualityWindow is how often a viewer's tier is reconsidered
+	qualityWindow = time.Second
+	// A window averaging slower writes than slowWrite, or dropping a frame,
+	// moves the viewer down a tier
+	slowWrite = 40 * time.Millisecond
+	// goodWindows in a row averaging under fastWrite move it back up
+	fastWrite   = 10 * time.Millisecond
+	goodWindows = 5
+)
+
+// qualityMonitor picks a viewer's tier from how long its writes take and
+// how many frames it drops because the previous one was still being sent
+type qualityMonitor struct {
+	tier        int
+	windowStart time.Time
+	writes      int
+	latency     time.Duration
+	dropped     int
+	good        int
+
+	// Floor keeps the tier at least this far down the list, whatever the
+	// writes look like
+	Floor int
+
+	// Results of the last closed window
+	AverageLatency time.Duration
+	Dropped        int
+}
+
+// newQualityMonitor starts a viewer at the best tier
+func newQualityMonitor(now time.Time) *qualityMonitor {
+	return &qualityMonitor{windowStart: now}
+}
+
+// Tier returns the viewer's current tier
+func (q *qualityMonitor) Tier() qualityTier {
+	return qualityTiers[min(max(q.tier, q.Floor), len(qualityTiers)-1)]
+}
+
+// Observe records one frame write and the frames dropped before it
+func (q *qualityMonitor) Observe(latency time.Duration, dropped int) {
+	q.writes++
+	q.latency += latency
+	q.dropped += dropped
+}
+
+// Evaluate closes the window once qualityWindow has passed and moves the
+// tier. It reports whether a window closed.
+func (q *qualityMonitor) Evaluate(now time.Time) bool {
+	if now.Sub(q.windowStart) < qualityWindow || q.writes == 0 {
+		return false
+	}
+	q.AverageLatency = q.latency / time.Duration(q.writes)
+	q.Dropped = q.dropped
+
+	switch {
+	case q.dropped > 0 || q.AverageLatency > slowWrite:
+		q.tier = min(q.tier+1, len(qualityTiers)-1)
+		q.good = 0
+	case q.AverageLatency < fastWrite:
+		q.good++
+		if q.good >= goodWindows {
+			q.tier = max(q.tier-1, 0)
+			q.good = 0
+		}
+	default:
+		q.good = 0
+	}
+
+	q.windowStart = now
+	q.writes, q.latency, q.dropped = 0, 0, 0
+	return true
+}
+
+// downscale shrinks an RGBA buffer by factor in each direction, averaging
+// each factor x factor block. The result has no row padding.
+func downscale(buffer []byte, width, height, stride, factor int) (out []byte, outWidth, outHeight int) {
+	outWidth, outHeight = width/factor, height/factor
+	out = make([]byte, outWidth*outHeight*4)
+	downscaleInto(out, buffer, width, height, stride, factor)
+	return out, outWidth, outHeight
+}
+
+// downscaleFrame is downscale into a pooled frame of its own
+func downscaleFrame(frame *wsFrame, factor int) *wsFrame {
+	w, h := frame.width/factor, frame.height/factor
+	scaled := newWSFrame(getFrameBuffer(w*h*4), w, h, w*4, frame.captured)
+	downscaleInto(scaled.buffer, frame.buffer, frame.width, frame.height, frame.stride, factor)
+	return scaled
+}
+
+// downscaleInto is downscale into out, which must fit the result
+func downscaleInto(out, buffer []byte, width, height, stride, factor int) {
+	outWidth, outHeight := width/factor, height/factor
+	area := factor * factor
+	for y := range outHeight {
+		for x := range outWidth {
+			var sum [4]int
+			for dy := range factor {
+				row := (y*factor + dy) * stride
+				for dx := range factor {
+					p := row + (x*factor+dx)*4
+					for c := range 4 {
+						sum[c] += int(buffer[p+c])
+					}
+				}
+			}
+			o := (y*outWidth + x) * 4
+			for c := range 4 {
+				out[o+c] = byte(sum[c] / area)
+			}
+		}
+	}
+}
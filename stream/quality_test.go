@@ -0,0 +1,79 @@
+package stream
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestQualityMonitor(t *testing.T) {
+	now := time.Unix(0, 0)
+	q := newQualityMonitor(now)
+
+	q.Observe(5*time.Millisecond, 0)
+	if q.Evaluate(now.Add(qualityWindow / 2)) {
+		t.Error("Window closed early")
+	}
+
+	// A dropped frame moves down a tier, slow writes another
+	q.Observe(5*time.Millisecond, 1)
+	now = now.Add(qualityWindow)
+	if !q.Evaluate(now) || q.Tier().Name != "reduced" {
+		t.Fatalf("Expected reduced after a drop, got %s", q.Tier().Name)
+	}
+	q.Observe(100*time.Millisecond, 0)
+	now = now.Add(qualityWindow)
+	q.Evaluate(now)
+	if q.Tier().Name != "low" || q.AverageLatency != 100*time.Millisecond {
+		t.Fatalf("Expected low after slow writes, got %s (%v)", q.Tier().Name, q.AverageLatency)
+	}
+
+	// Recovery takes goodWindows fast windows in a row
+	for i := range goodWindows {
+		if q.Tier().Name != "low" {
+			t.Fatalf("Recovered after %d windows", i)
+		}
+		q.Observe(time.Millisecond, 0)
+		now = now.Add(qualityWindow)
+		q.Evaluate(now)
+	}
+	if q.Tier().Name != "reduced" {
+		t.Errorf("Expected reduced after recovering, got %s", q.Tier().Name)
+	}
+
+	// Never worse than the last tier
+	for range 2 * len(qualityTiers) {
+		q.Observe(time.Second, 3)
+		now = now.Add(qualityWindow)
+		q.Evaluate(now)
+	}
+	if q.Tier() != qualityTiers[len(qualityTiers)-1] {
+		t.Errorf("Expected the last tier, got %s", q.Tier().Name)
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	// 4x2 with a padded stride of 20 bytes; each 2x2 block averages to
+	// its mean
+	buffer := []byte{
+		0, 0, 0, 255, 10, 20, 30, 255, 100, 100, 100, 255, 200, 200, 200, 255, 9, 9, 9, 9,
+		20, 40, 60, 255, 30, 60, 90, 255, 100, 100, 100, 255, 0, 0, 0, 255, 9, 9, 9, 9,
+	}
+	out, w, h := downscale(buffer, 4, 2, 20, 2)
+	want := []byte{15, 30, 45, 255, 100, 100, 100, 255}
+	if w != 2 || h != 1 || !bytes.Equal(out, want) {
+		t.Errorf("Expected 2x1 %v, got %dx%d %v", want, w, h, out)
+	}
+}
+
+func TestQualityFloor(t *testing.T) {
+	q := newQualityMonitor(time.Now())
+	q.Floor = 2
+	if q.Tier() != qualityTiers[2] {
+		t.Errorf("Expected the floor tier, got %s", q.Tier().Name)
+	}
+	q.Floor = len(qualityTiers) + 3
+	if q.Tier() != qualityTiers[len(qualityTiers)-1] {
+		t.Errorf("Expected the last tier, got %s", q.Tier().Name)
+	}
+}
@@ -0,0 +1,88 @@
+package stream
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ScreenshotTimeout bounds how long a screenshot request waits for the
+// render loop
+const ScreenshotTimeout = 2 * time.Second
+
+// Screenshots hands composited frames from the render loop to HTTP
+// requests, so tools outside the Wayland session can capture the desktop
+type Screenshots struct {
+	requests chan chan *image.RGBA
+}
+
+// NewScreenshots creates an empty request queue
+func NewScreenshots() *Screenshots {
+	return &Screenshots{requests: make(chan chan *image.RGBA, 16)}
+}
+
+// Pending reports whether a request is waiting for a frame
+func (s *Screenshots) Pending() bool {
+	return len(s.requests) > 0
+}
+
+// Fulfil answers every waiting request with a copy of the desktop buffer.
+// It never blocks the render loop.
+func (s *Screenshots) Fulfil(buffer []byte, width, height, stride int) {
+	for {
+		select {
+		case reply := <-s.requests:
+			frame := image.NewRGBA(image.Rect(0, 0, width, height))
+			for y := 0; y < height && (y+1)*stride <= len(buffer); y++ {
+				copy(frame.Pix[y*frame.Stride:(y+1)*frame.Stride], buffer[y*stride:])
+			}
+			reply <- frame
+		default:
+			return
+		}
+	}
+}
+
+// Request asks for a copy of the next composited frame, which is sent on
+// the returned channel. It returns false when too many requests are
+// already waiting.
+func (s *Screenshots) Request() (<-chan *image.RGBA, bool) {
+	reply := make(chan *image.RGBA, 1)
+	select {
+	case s.requests <- reply:
+		return reply, true
+	default:
+		return nil, false
+	}
+}
+
+// ServeHTTP responds with the next composited frame as a PNG
+func (s *Screenshots) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply, ok := s.Request()
+	if !ok {
+		http.Error(w, "too many screenshot requests", http.StatusServiceUnavailable)
+		return
+	}
+
+	var frame *image.RGBA
+	select {
+	case frame = <-reply:
+	case <-time.After(ScreenshotTimeout):
+		http.Error(w, "timed out waiting for a frame", http.StatusGatewayTimeout)
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, frame); err != nil {
+		log.Printf("Screenshot encode error: %v", err)
+		http.Error(w, "failed to encode screenshot", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,42 @@
+package stream
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScreenshotsServeHTTP(t *testing.T) {
+	screenshots := NewScreenshots()
+	recorder := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		screenshots.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/screenshot.png", nil))
+		close(done)
+	}()
+
+	// Padded rows, like a desktop buffer with a larger stride
+	buffer := make([]byte, 3*12)
+	buffer[12+4+3] = 0xff // alpha of pixel (1, 1)
+	for !screenshots.Pending() {
+		time.Sleep(time.Millisecond)
+	}
+	screenshots.Fulfil(buffer, 2, 3, 12)
+	<-done
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", recorder.Code)
+	}
+	img, err := png.Decode(recorder.Body)
+	if err != nil {
+		t.Fatalf("Response is not a PNG: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 2 || size.Y != 3 {
+		t.Errorf("Expected a 2x3 image, got %v", size)
+	}
+	if _, _, _, a := img.At(1, 1).RGBA(); a != 0xffff {
+		t.Errorf("Pixel (1, 1) should be opaque, got alpha %d", a)
+	}
+}
@@ -0,0 +1,1133 @@
+//go:build !noweb
+
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// WebSocketServer manages WebSocket connections for streaming the desktop buffer
+type WebSocketServer struct {
+	clients         map[*websocket.Conn]*wsClient
+	mu              sync.RWMutex
+	upgrader        websocket.Upgrader
+	broadcast       chan []byte
+	keyboardHandler KeyboardEventHandler
+	pointerHandler  PointerEventHandler
+	textHandler     TextInputHandler
+	viewerHandler   ViewerJoinedHandler
+	windowHandler   WindowActionHandler
+	controlHandler  ControlChangedHandler
+	inputObserver   InputObserver
+	nextViewerID    int
+	pointerLocked   bool
+	// qualityFloor is the best quality tier any viewer gets
+	qualityFloor atomic.Int32
+	// playoutDelay is how long after capture viewers that sync their
+	// clocks show a frame
+	playoutDelay atomic.Int64
+	// frameWanted asks for a broadcast of the desktop even though it has
+	// not changed, for viewers that joined since the last one
+	frameWanted atomic.Bool
+	// streamedSource and streamedDamage are the TextureSource frame last
+	// streamed, touched only by Stream on the render loop
+	streamedSource render.TextureSource
+	streamedDamage uint64
+	// windowList and windowThumbnails are the last window list published,
+	// without and with thumbnails
+	windowList, windowThumbnails []byte
+	// tokens lets viewers resume their role and subscriptions
+	tokens *ViewerTokens
+	// status and statusNotice are the stream status last announced
+	status       string
+	statusNotice []byte
+	// exclusiveControl lets only controlHolder's input through; the
+	// viewers in controlRequests have asked for control, in order
+	exclusiveControl bool
+	controlHolder    *wsClient
+	controlRequests  []*wsClient
+}
+
+// DefaultPlayoutDelay leaves time for a frame to be encoded, sent and
+// decoded by every viewer before any of them shows it
+const DefaultPlayoutDelay = 150 * time.Millisecond
+
+// wsClient is a connected viewer. Frames are encoded and written on the
+// viewer's own goroutine, so a slow viewer cannot hold up the render loop
+// or the other viewers.
+type wsClient struct {
+	conn *websocket.Conn
+	// id, address and connected identify the viewer in the control API
+	id        int
+	address   string
+	connected time.Time
+	// frames holds the newest frame the writer has not taken yet
+	frames chan *wsFrame
+	done   chan struct{}
+	// dropped counts frames replaced in frames before the writer took them
+	dropped atomic.Int64
+	// sentBytes counts the bytes of frames sent, and encodeTime is how
+	// long the last one took to encode, for the stats overlay
+	sentBytes  atomic.Int64
+	encodeTime atomic.Int64
+	// resync asks the writer for a keyframe and a fresh quality tier
+	resync atomic.Bool
+	// clock holds answers to clock messages, sent ahead of frames
+	clock chan streamClock
+
+	// Set from the viewer's hello, under WebSocketServer.mu. Until it
+	// arrives the viewer gets the plain 12 byte header format.
+	negotiated bool
+	settings   streamSettings
+	// reply is the answer to the hello, for the writer to send
+	reply []byte
+	// lockNotice tells the viewer the pointer was locked or unlocked,
+	// statusNotice the stream status, preferencesNotice its preferences
+	// and controlNotice who holds input control, for the writer to send
+	lockNotice, statusNotice, preferencesNotice, controlNotice []byte
+	// token and role are set by the hello; viewers that never sent one
+	// are controllers
+	token, role string
+	// preferences are the viewer's own settings, and qualityCap the index
+	// of the best quality tier they allow
+	preferences ViewerPreferences
+	qualityCap  atomic.Int32
+	// windows is set once the viewer asks for the window list, and
+	// thumbnails when it wants them in it
+	windows, thumbnails bool
+	// windowList is the window list for the writer to send
+	windowList []byte
+	// wake tells the writer there is a message to send between frames
+	wake chan struct{}
+	// region is the part of the desktop the viewer asked for, nil until it
+	// asks
+	region atomic.Pointer[ViewerRegion]
+}
+
+// streamStats tells a negotiated viewer its quality tier, once per
+// qualityWindow
+type streamStats struct {
+	Type         string  `json:"type"`
+	Tier         string  `json:"tier"`
+	Scale        int     `json:"scale"`
+	FrameDivisor int     `json:"frame_divisor"`
+	LatencyMS    float64 `json:"latency_ms"`
+	Dropped      int     `json:"dropped"`
+}
+
+// NewWebSocketServer creates a new WebSocket server instance
+func NewWebSocketServer() *WebSocketServer {
+	s := &WebSocketServer{
+		clients:         make(map[*websocket.Conn]*wsClient),
+		broadcast:       make(chan []byte, 10),
+		keyboardHandler: nil,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024 * 1024, // Large buffer for image data
+			CheckOrigin: func(r *http.Request) bool {
+				return true // Allow all origins for development
+			},
+		},
+	}
+	s.playoutDelay.Store(int64(DefaultPlayoutDelay))
+	s.tokens, _ = NewViewerTokens("")
+	s.status = StreamLive
+	return s
+}
+
+// SetViewerTokens sets the store viewers' reconnect tokens are kept in
+func (s *WebSocketServer) SetViewerTokens(tokens *ViewerTokens) {
+	s.tokens = tokens
+}
+
+// SetKeyboardHandler sets the callback for keyboard events
+func (s *WebSocketServer) SetKeyboardHandler(handler KeyboardEventHandler) {
+	s.keyboardHandler = handler
+}
+
+// SetTextHandler sets the callback for committed text
+func (s *WebSocketServer) SetTextHandler(handler TextInputHandler) {
+	s.textHandler = handler
+}
+
+// SetPointerHandler sets the callback for pointer events
+func (s *WebSocketServer) SetPointerHandler(handler PointerEventHandler) {
+	s.pointerHandler = handler
+}
+
+// SetViewerJoinedHandler sets the callback for new WebSocket clients
+func (s *WebSocketServer) SetViewerJoinedHandler(handler ViewerJoinedHandler) {
+	s.viewerHandler = handler
+}
+
+// SetControlChangedHandler sets the callback for input control passing
+// between viewers
+func (s *WebSocketServer) SetControlChangedHandler(handler ControlChangedHandler) {
+	s.controlHandler = handler
+}
+
+// SetWindowActionHandler sets the callback for viewers focusing and
+// closing windows
+func (s *WebSocketServer) SetWindowActionHandler(handler WindowActionHandler) {
+	s.windowHandler = handler
+}
+
+// SetInputObserver sets the callback that sees every input message
+func (s *WebSocketServer) SetInputObserver(observer InputObserver) {
+	s.inputObserver = observer
+}
+
+// HandleWebSocket handles incoming WebSocket connections
+func (s *WebSocketServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+
+	client := &wsClient{
+		conn:      conn,
+		address:   r.RemoteAddr,
+		connected: time.Now(),
+		frames:    make(chan *wsFrame, 1),
+		clock:     make(chan streamClock, 4),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.nextViewerID++
+	client.id = s.nextViewerID
+	if s.pointerLocked {
+		client.lockNotice = pointerLockNotice(true)
+	}
+	s.clients[conn] = client
+	s.frameWanted.Store(true)
+	viewers := len(s.clients)
+	s.mu.Unlock()
+	go s.writeLoop(client)
+
+	log.Printf("New WebSocket client connected. Total clients: %d", viewers)
+	if s.viewerHandler != nil {
+		s.viewerHandler(r.RemoteAddr, viewers)
+	}
+
+	// Keep connection alive and handle disconnects and incoming messages
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.clients, conn)
+			s.leaveControl(client)
+			viewers := len(s.clients)
+			s.mu.Unlock()
+			close(client.done)
+			conn.Close()
+			if client.token != "" {
+				s.tokens.Release(client.token, time.Now())
+			}
+			log.Printf("WebSocket client disconnected. Total clients: %d", viewers)
+		}()
+
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+
+			switch messageType {
+			case websocket.BinaryMessage:
+				if s.mayControl(client) {
+					s.HandleInput(s.mapKeys(client, message))
+				}
+			case websocket.TextMessage:
+				s.handleText(client, message, time.Now())
+			}
+		}
+	}()
+}
+
+// mapKeys applies the viewer's key mapping to a keyboard message
+func (s *WebSocketServer) mapKeys(client *wsClient, message []byte) []byte {
+	if len(message) < 6 || message[0] != InputKeyboard {
+		return message
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keycode := binary.LittleEndian.Uint32(message[1:5])
+	if mapped := client.preferences.mapKey(keycode); mapped != keycode {
+		message = bytes.Clone(message)
+		binary.LittleEndian.PutUint32(message[1:5], mapped)
+	}
+	return message
+}
+
+// HandleInput dispatches an input message to the keyboard or pointer
+// handler. Short and unknown messages are ignored.
+func (s *WebSocketServer) HandleInput(message []byte) {
+	if len(message) == 0 {
+		return
+	}
+	if s.inputObserver != nil {
+		s.inputObserver(message)
+	}
+	switch message[0] {
+	case InputKeyboard:
+		if len(message) >= 6 && s.keyboardHandler != nil {
+			keycode := binary.LittleEndian.Uint32(message[1:5])
+			pressed := message[5] != 0
+			s.keyboardHandler(keycode, pressed)
+		}
+	case InputPointerMotion:
+		if len(message) >= 9 && s.pointerHandler != nil {
+			s.pointerHandler(PointerEvent{
+				Type: InputPointerMotion,
+				X:    math.Float32frombits(binary.LittleEndian.Uint32(message[1:5])),
+				Y:    math.Float32frombits(binary.LittleEndian.Uint32(message[5:9])),
+			})
+		}
+	case InputPointerRelative:
+		if len(message) >= 9 && s.pointerHandler != nil {
+			s.pointerHandler(PointerEvent{
+				Type: InputPointerRelative,
+				X:    math.Float32frombits(binary.LittleEndian.Uint32(message[1:5])),
+				Y:    math.Float32frombits(binary.LittleEndian.Uint32(message[5:9])),
+			})
+		}
+	case InputPen:
+		if len(message) >= 22 && s.pointerHandler != nil {
+			f := func(at int) float32 {
+				return math.Float32frombits(binary.LittleEndian.Uint32(message[at : at+4]))
+			}
+			s.pointerHandler(PointerEvent{
+				Type:       InputPen,
+				X:          f(1),
+				Y:          f(5),
+				Pressure:   f(9),
+				Tilt:       [2]float32{f(13), f(17)},
+				PenButtons: message[21],
+			})
+		}
+	case InputText:
+		if len(message) > 1 && utf8.Valid(message[1:]) && s.textHandler != nil {
+			s.textHandler(string(message[1:]))
+		}
+	case InputPointerButton:
+		if len(message) >= 6 && s.pointerHandler != nil {
+			s.pointerHandler(PointerEvent{
+				Type:    InputPointerButton,
+				Button:  binary.LittleEndian.Uint32(message[1:5]),
+				Pressed: message[5] != 0,
+			})
+		}
+	case InputPointerAxis:
+		if len(message) >= 6 && s.pointerHandler != nil {
+			axis := protocols.WlPointerAxis_enum_vertical_scroll
+			if message[1] == 1 {
+				axis = protocols.WlPointerAxis_enum_horizontal_scroll
+			}
+			s.pointerHandler(PointerEvent{
+				Type:  InputPointerAxis,
+				Axis:  axis,
+				Value: math.Float32frombits(binary.LittleEndian.Uint32(message[2:6])),
+			})
+		}
+	}
+}
+
+// handleText dispatches a viewer's text message, received at received, by
+// its type. Unknown messages are ignored.
+func (s *WebSocketServer) handleText(client *wsClient, message []byte, received time.Time) {
+	var kind struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(message, &kind); err != nil {
+		return
+	}
+	switch kind.Type {
+	case "hello":
+		s.handleHello(client, message)
+	case "clock":
+		s.handleClock(client, message, received)
+	case "windows":
+		s.handleWindows(client, message)
+	case "preferences":
+		s.handlePreferences(client, message)
+	case "region":
+		s.handleRegion(client, message)
+	case "request_control", "grant_control", "release_control":
+		s.handleControl(client, kind.Type, message)
+	case "focus", "close":
+		var request struct {
+			Client   int    `json:"client"`
+			Toplevel uint32 `json:"toplevel"`
+		}
+		if err := json.Unmarshal(message, &request); err == nil && s.windowHandler != nil && s.mayControl(client) {
+			s.windowHandler(kind.Type, request.Client, request.Toplevel)
+		}
+	}
+}
+
+// handleWindows subscribes a viewer to the window list,
+// {"type":"windows","thumbnails":true} with thumbnails. The last list is
+// sent straight away.
+func (s *WebSocketServer) handleWindows(client *wsClient, message []byte) {
+	var request struct {
+		Thumbnails bool `json:"thumbnails"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.subscribeWindows(client, request.Thumbnails)
+	s.mu.Unlock()
+	s.saveState(client)
+}
+
+// handleRegion sets the part of the desktop a viewer is sent,
+// {"type":"region","x":0,"y":0,"width":640,"height":360,"zoom":2}, or the
+// whole desktop again without a width and height. It takes effect on the
+// next frame, which is sent straight away. Invalid regions are ignored.
+func (s *WebSocketServer) handleRegion(client *wsClient, message []byte) {
+	var region ViewerRegion
+	if err := json.Unmarshal(message, &region); err != nil || region.Validate() != nil {
+		return
+	}
+	client.region.Store(&region)
+	s.frameWanted.Store(true)
+}
+
+// saveState keeps a viewer's role, subscriptions and preferences under
+// its token
+func (s *WebSocketServer) saveState(client *wsClient) {
+	s.mu.RLock()
+	token := client.token
+	state := ViewerState{
+		Role:        client.role,
+		Windows:     client.windows,
+		Thumbnails:  client.thumbnails,
+		Preferences: client.preferences,
+	}
+	s.mu.RUnlock()
+	if token != "" {
+		s.tokens.Update(token, state, time.Now())
+	}
+}
+
+// handlePreferences replaces a viewer's preferences,
+// {"type":"preferences","preferences":{...}}, and echoes them back.
+// Invalid preferences are ignored.
+func (s *WebSocketServer) handlePreferences(client *wsClient, message []byte) {
+	var request struct {
+		Preferences ViewerPreferences `json:"preferences"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil || request.Preferences.Validate() != nil {
+		return
+	}
+	s.mu.Lock()
+	s.setPreferences(client, request.Preferences)
+	s.mu.Unlock()
+	s.saveState(client)
+}
+
+// setPreferences applies a viewer's preferences and queues them for the
+// writer to send. Call with s.mu held.
+func (s *WebSocketServer) setPreferences(client *wsClient, preferences ViewerPreferences) {
+	client.preferences = preferences
+	client.qualityCap.Store(int32(preferences.qualityFloor()))
+	client.preferencesNotice = preferencesNotice(preferences)
+	wakeWriter(client)
+}
+
+// preferencesNotice is the text message telling a viewer its preferences
+func preferencesNotice(preferences ViewerPreferences) []byte {
+	notice, _ := json.Marshal(struct {
+		Type        string            `json:"type"`
+		Preferences ViewerPreferences `json:"preferences"`
+	}{"preferences", preferences})
+	return notice
+}
+
+// subscribeWindows subscribes a viewer to the window list and queues the
+// last one for it. Call with s.mu held.
+func (s *WebSocketServer) subscribeWindows(client *wsClient, thumbnails bool) {
+	client.windows, client.thumbnails = true, thumbnails
+	client.windowList = s.windowList
+	if thumbnails {
+		client.windowList = s.windowThumbnails
+	}
+	if client.windowList != nil {
+		wakeWriter(client)
+	}
+}
+
+// WindowsWanted reports whether any viewer wants the window list, and
+// whether any wants thumbnails in it
+func (s *WebSocketServer) WindowsWanted() (list, thumbnails bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, client := range s.clients {
+		list = list || client.windows
+		thumbnails = thumbnails || client.thumbnails
+	}
+	return list, thumbnails
+}
+
+// PublishWindows sends the window list to the viewers that asked for it,
+// {"type":"windows","windows":[...]}, if it changed since the last one.
+// Thumbnails only go to viewers that asked for them.
+func (s *WebSocketServer) PublishWindows(windows []WindowInfo) {
+	type windowList struct {
+		Type    string       `json:"type"`
+		Windows []WindowInfo `json:"windows"`
+	}
+	withThumbnails, err := json.Marshal(windowList{"windows", windows})
+	if err != nil {
+		return
+	}
+	plain := make([]WindowInfo, len(windows))
+	for i, w := range windows {
+		w.Thumbnail = ""
+		plain[i] = w
+	}
+	withoutThumbnails, err := json.Marshal(windowList{"windows", plain})
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	listChanged := !bytes.Equal(withoutThumbnails, s.windowList)
+	thumbnailsChanged := !bytes.Equal(withThumbnails, s.windowThumbnails)
+	s.windowList, s.windowThumbnails = withoutThumbnails, withThumbnails
+	for _, client := range s.clients {
+		switch {
+		case client.thumbnails && thumbnailsChanged:
+			client.windowList = withThumbnails
+		case client.windows && !client.thumbnails && listChanged:
+			client.windowList = withoutThumbnails
+		default:
+			continue
+		}
+		wakeWriter(client)
+	}
+}
+
+// wakeWriter tells a viewer's writer it has a message to send
+func wakeWriter(client *wsClient) {
+	select {
+	case client.wake <- struct{}{}:
+	default:
+	}
+}
+
+// handleClock queues the answer to a clock message for the writer, which
+// stamps it as it is sent. A viewer that floods clock messages has the
+// extra ones ignored.
+func (s *WebSocketServer) handleClock(client *wsClient, message []byte, received time.Time) {
+	var request struct {
+		ClientTime float64 `json:"client_time"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil {
+		return
+	}
+	status := readClockStatus()
+	clock := streamClock{
+		Type:       "clock",
+		ClientTime: request.ClientTime,
+		ReceivedUS: received.UnixMicro(),
+		Synced:     status.Synced,
+		ErrorUS:    status.EstError.Microseconds(),
+	}
+	select {
+	case client.clock <- clock:
+	default:
+	}
+}
+
+// handleHello negotiates a viewer's frame format and gives it a role. A
+// viewer that sends the token of an earlier connection gets that
+// connection's role and subscriptions back; any other is given a new
+// token. Messages that are not a hello are ignored.
+func (s *WebSocketServer) handleHello(client *wsClient, message []byte) {
+	var hello streamHello
+	if err := json.Unmarshal(message, &hello); err != nil || hello.Type != "hello" {
+		return
+	}
+	settings := negotiateStream(hello)
+	if settings.Timestamps {
+		settings.PlayoutDelayMS = int(time.Duration(s.playoutDelay.Load()).Milliseconds())
+	}
+
+	now := time.Now()
+	token := hello.Token
+	if token == "" {
+		token = client.token
+	}
+	state, resumed := s.tokens.Resume(token, now)
+	if !resumed {
+		state = ViewerState{Role: RoleController}
+		if hello.Role == RoleSpectator {
+			state.Role = RoleSpectator
+		}
+		token = s.tokens.Issue(state, now)
+	}
+	if client.token != "" && client.token != token {
+		s.tokens.Release(client.token, now)
+	}
+
+	reply, err := json.Marshal(struct {
+		Type string `json:"type"`
+		streamSettings
+		Token       string            `json:"token"`
+		Role        string            `json:"role"`
+		Resumed     bool              `json:"resumed,omitempty"`
+		Preferences ViewerPreferences `json:"preferences,omitzero"`
+	}{"hello", settings, token, state.Role, resumed, state.Preferences})
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client.negotiated = true
+	client.settings = settings
+	client.reply = reply
+	client.token, client.role = token, state.Role
+	client.preferences = state.Preferences
+	client.qualityCap.Store(int32(max(state.Preferences.qualityFloor(), 0)))
+	if resumed && state.Windows {
+		s.subscribeWindows(client, state.Thumbnails)
+	}
+	if s.status != StreamLive {
+		client.statusNotice = s.statusNotice
+	}
+	s.joinControl(client)
+	// The reply goes out straight away, so a viewer that joins while the
+	// stream is paused still learns its token and the status
+	wakeWriter(client)
+}
+
+// BroadcastDesktopBuffer hands a copy of the desktop buffer to every
+// viewer's writer. A writer still busy with the previous frame has it
+// replaced, which counts against the viewer's quality tier. It need only
+// be called when the desktop changed or FrameWanted.
+func (s *WebSocketServer) BroadcastDesktopBuffer(buffer []byte, width, height, stride int) {
+	if len(buffer) == 0 {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.frameWanted.Store(false)
+	if len(s.clients) == 0 {
+		return
+	}
+	frame := newWSFrame(getFrameBuffer(len(buffer)), width, height, stride, time.Now())
+	copy(frame.buffer, buffer)
+	// Each writer holds the frame until it is done with it
+	defer frame.release()
+	for _, client := range s.clients {
+		frame.hold()
+		select {
+		case client.frames <- frame:
+			continue
+		default:
+		}
+		select {
+		case old := <-client.frames:
+			old.release()
+			client.dropped.Add(1)
+		default:
+		}
+		select {
+		case client.frames <- frame:
+		default:
+			frame.release()
+		}
+	}
+}
+
+// Stream broadcasts source's frame if it is not the one last streamed, or
+// a viewer is waiting for the desktop. Call it from the render loop.
+func (s *WebSocketServer) Stream(source render.TextureSource) {
+	damage := source.Damage()
+	if source == s.streamedSource && damage == s.streamedDamage && !s.FrameWanted() {
+		return
+	}
+	pixels, stride := source.Frame()
+	if len(pixels) == 0 {
+		return
+	}
+	size := source.Size()
+	s.BroadcastDesktopBuffer(pixels, size.X, size.Y, stride)
+	s.streamedSource, s.streamedDamage = source, damage
+}
+
+// writeLoop encodes and sends frames to one viewer until it disconnects.
+// Viewers that have not sent a hello get
+// [width:4bytes][height:4bytes][stride:4bytes][rgba_data]; the others get
+// the format they negotiated (see encodeFrame), cropped to the region they
+// asked for, scaled and compressed for their quality tier, and a
+// streamStats message each qualityWindow.
+func (s *WebSocketServer) writeLoop(client *wsClient) {
+	quality := newQualityMonitor(time.Now())
+	// previous is the last frame sent as the viewer saw it, for deltas,
+	// held until the next one replaces it
+	var previous *wsFrame
+	defer func() { previous.release() }()
+	// encoder keeps its scratch buffers from frame to frame
+	var encoder FrameEncoder
+	var taken int
+	// shown is the region the viewer was last told its frames show
+	var shown ViewerRegion
+
+	for {
+		var frame *wsFrame
+		select {
+		case frame = <-client.frames:
+		case clock := <-client.clock:
+			clock.SentUS = time.Now().UnixMicro()
+			reply, err := json.Marshal(clock)
+			if err == nil && !s.write(client, websocket.TextMessage, reply) {
+				return
+			}
+			continue
+		case <-client.wake:
+			if _, _, reply := s.takeReply(client); reply != nil {
+				previous.release()
+				previous = nil
+				if !s.write(client, websocket.TextMessage, reply) {
+					return
+				}
+			}
+			if !s.sendNotices(client) {
+				return
+			}
+			continue
+		case <-client.done:
+			return
+		}
+
+		negotiated, settings, reply := s.takeReply(client)
+		if reply != nil {
+			// The first frame after the hello is a keyframe
+			previous.release()
+			previous = nil
+			if !s.write(client, websocket.TextMessage, reply) {
+				frame.release()
+				return
+			}
+		}
+		if !s.sendNotices(client) {
+			frame.release()
+			return
+		}
+
+		if client.resync.Swap(false) {
+			previous.release()
+			previous = nil
+			quality = newQualityMonitor(time.Now())
+		}
+
+		quality.Floor = max(int(s.qualityFloor.Load()), int(client.qualityCap.Load()))
+		tier := quality.Tier()
+		taken++
+		if taken%tier.FrameDivisor != 0 {
+			frame.release()
+			continue
+		}
+
+		// sent is the frame as the viewer sees it, held until it is sent,
+		// or until the next one replaces it when it is the base for deltas
+		sent := frame
+		var message net.Buffers
+		encodeStart := time.Now()
+		if negotiated {
+			if requested := client.region.Load(); requested != nil {
+				region := requested.clamp(frame.width, frame.height)
+				if region != shown {
+					// Deltas against another part of the desktop are
+					// meaningless, so the first frame of a region is whole
+					shown = region
+					previous.release()
+					previous = nil
+					if !s.write(client, websocket.TextMessage, regionNotice(region)) {
+						frame.release()
+						return
+					}
+				}
+				if !region.whole(frame.width, frame.height) {
+					sent = cropFrame(frame, region)
+					frame.release()
+				}
+			}
+			if tier.Scale > 1 {
+				scaled := downscaleFrame(sent, tier.Scale)
+				sent.release()
+				sent = scaled
+			}
+			var base []byte
+			if settings.Delta && previous != nil && previous.width == sent.width &&
+				previous.height == sent.height && previous.stride == sent.stride {
+				base = previous.buffer
+			}
+			var err error
+			var captured time.Time
+			if settings.Timestamps {
+				captured = sent.captured
+			}
+			message, err = encoder.Encode(sent.buffer, base, sent.width, sent.height, sent.stride, settings.Codec, tier.Level, captured)
+			if err != nil {
+				log.Printf("Error encoding frame: %v", err)
+				sent.release()
+				continue
+			}
+		} else {
+			header := encoder.header[:0]
+			header = binary.LittleEndian.AppendUint32(header, uint32(frame.width))
+			header = binary.LittleEndian.AppendUint32(header, uint32(frame.height))
+			header = binary.LittleEndian.AppendUint32(header, uint32(frame.stride))
+			encoder.header = header
+			message = net.Buffers{header, frame.buffer}
+		}
+
+		start := time.Now()
+		client.encodeTime.Store(int64(start.Sub(encodeStart)))
+		ok := s.writeBuffers(client, message)
+		if negotiated && settings.Delta {
+			previous.release()
+			previous = sent
+		} else {
+			sent.release()
+		}
+		if !ok {
+			return
+		}
+		for _, part := range message {
+			client.sentBytes.Add(int64(len(part)))
+		}
+		now := time.Now()
+		quality.Observe(now.Sub(start), int(client.dropped.Swap(0)))
+
+		if quality.Evaluate(now) && negotiated {
+			tier := quality.Tier()
+			stats, err := json.Marshal(streamStats{
+				Type:         "stats",
+				Tier:         tier.Name,
+				Scale:        tier.Scale,
+				FrameDivisor: tier.FrameDivisor,
+				LatencyMS:    float64(quality.AverageLatency) / float64(time.Millisecond),
+				Dropped:      quality.Dropped,
+			})
+			if err == nil && !s.write(client, websocket.TextMessage, stats) {
+				return
+			}
+		}
+	}
+}
+
+// takeReply returns a viewer's frame format along with the hello reply
+// still to be sent, if any, so frames are never encoded in a format the
+// viewer has not been told about yet
+func (s *WebSocketServer) takeReply(client *wsClient) (negotiated bool, settings streamSettings, reply []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply, client.reply = client.reply, nil
+	return client.negotiated, client.settings, reply
+}
+
+// sendNotices sends the viewer's pending status, pointer lock,
+// preferences and input control notices and window list, reporting false if the connection failed. Viewers
+// without a hello only understand frames, so they get no notices.
+func (s *WebSocketServer) sendNotices(client *wsClient) bool {
+	s.mu.Lock()
+	var lockNotice, statusNotice, preferencesNotice, controlNotice []byte
+	if client.negotiated {
+		lockNotice, client.lockNotice = client.lockNotice, nil
+		statusNotice, client.statusNotice = client.statusNotice, nil
+		preferencesNotice, client.preferencesNotice = client.preferencesNotice, nil
+		controlNotice, client.controlNotice = client.controlNotice, nil
+	}
+	windowList := client.windowList
+	client.windowList = nil
+	s.mu.Unlock()
+
+	for _, notice := range [][]byte{statusNotice, lockNotice, preferencesNotice, controlNotice, windowList} {
+		if notice != nil && !s.write(client, websocket.TextMessage, notice) {
+			return false
+		}
+	}
+	return true
+}
+
+// write sends one message, closing the connection on failure so the
+// reader cleans the viewer up
+func (s *WebSocketServer) write(client *wsClient, messageType int, data []byte) bool {
+	if err := client.conn.WriteMessage(messageType, data); err != nil {
+		log.Printf("Error sending to client: %v", err)
+		client.conn.Close()
+		return false
+	}
+	return true
+}
+
+// writeBuffers sends the parts of one binary message as they are, without
+// joining them first, closing the connection on failure like write
+func (s *WebSocketServer) writeBuffers(client *wsClient, message net.Buffers) bool {
+	w, err := client.conn.NextWriter(websocket.BinaryMessage)
+	if err == nil {
+		for _, part := range message {
+			if _, err = w.Write(part); err != nil {
+				break
+			}
+		}
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("Error sending to client: %v", err)
+		client.conn.Close()
+		return false
+	}
+	return true
+}
+
+// Resync sends every viewer a keyframe next and forgets how it kept up so
+// far, for after the host slept
+func (s *WebSocketServer) Resync() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, client := range s.clients {
+		client.resync.Store(true)
+	}
+	s.frameWanted.Store(true)
+}
+
+// FrameWanted reports whether a viewer is waiting for the desktop, which
+// should then be broadcast even though it has not changed
+func (s *WebSocketServer) FrameWanted() bool {
+	return s.frameWanted.Load()
+}
+
+// SetQualityFloor keeps every viewer at or below the quality tier at
+// index floor of qualityTiers, 0 for no limit
+func (s *WebSocketServer) SetQualityFloor(floor int) {
+	s.qualityFloor.Store(int32(floor))
+}
+
+// SetPlayoutDelay sets how long after capture viewers that sync their
+// clocks show a frame. Viewers pick it up with their next hello.
+func (s *WebSocketServer) SetPlayoutDelay(delay time.Duration) {
+	s.playoutDelay.Store(int64(delay))
+}
+
+// CloseAll disconnects every viewer
+func (s *WebSocketServer) CloseAll() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+}
+
+// Close disconnects every viewer, so the server can be an OutputSink
+func (s *WebSocketServer) Close() error {
+	s.CloseAll()
+	return nil
+}
+
+// pointerLockNotice is the text message telling viewers the pointer was
+// locked or unlocked
+func pointerLockNotice(locked bool) []byte {
+	notice, _ := json.Marshal(struct {
+		Type   string `json:"type"`
+		Locked bool   `json:"locked"`
+	}{"pointer_lock", locked})
+	return notice
+}
+
+// SetPointerLock tells viewers to capture the mouse and send relative
+// motion, or to stop
+func (s *WebSocketServer) SetPointerLock(locked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pointerLocked == locked {
+		return
+	}
+	s.pointerLocked = locked
+	notice := pointerLockNotice(locked)
+	for _, client := range s.clients {
+		client.lockNotice = notice
+		wakeWriter(client)
+	}
+}
+
+// Stream statuses announced to viewers that sent a hello. While the
+// stream is paused or reloading viewers keep their last frame, and while
+// the output is off they should show black; when the compositor is
+// restarting they should reconnect with their token.
+const (
+	StreamLive       = "live"
+	StreamPaused     = "paused"
+	StreamReloading  = "reloading"
+	StreamOff        = "off"
+	StreamRestarting = "restarting"
+)
+
+// streamStatusNotice is the text message announcing a stream status,
+// with how long to wait before reconnecting after a restart
+func streamStatusNotice(status string, retry time.Duration) []byte {
+	notice, _ := json.Marshal(struct {
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+		RetryMS int64  `json:"retry_ms,omitempty"`
+	}{"status", status, retry.Milliseconds()})
+	return notice
+}
+
+// SetStreamStatus announces the stream status to viewers when it changes.
+// Viewers joining later are told it after their hello, unless it is live.
+func (s *WebSocketServer) SetStreamStatus(status string) {
+	s.setStreamStatus(status, 0)
+}
+
+func (s *WebSocketServer) setStreamStatus(status string, retry time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == status {
+		return
+	}
+	s.status = status
+	s.statusNotice = streamStatusNotice(status, retry)
+	for _, client := range s.clients {
+		client.statusNotice = s.statusNotice
+		wakeWriter(client)
+	}
+}
+
+// restartNoticeTimeout is how long Restarting waits for writers to send
+// the notice before closing the connections
+const restartNoticeTimeout = time.Second
+
+// Restarting tells viewers the compositor is restarting and to reconnect
+// after retry, then closes every connection with the service restart
+// close code
+func (s *WebSocketServer) Restarting(retry time.Duration) {
+	s.setStreamStatus(StreamRestarting, retry)
+	for deadline := time.Now().Add(restartNoticeTimeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.mu.RLock()
+		pending := false
+		for _, client := range s.clients {
+			pending = pending || (client.negotiated && client.statusNotice != nil)
+		}
+		s.mu.RUnlock()
+		if !pending {
+			break
+		}
+	}
+
+	closing := websocket.FormatCloseMessage(websocket.CloseServiceRestart, StreamRestarting)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for conn := range s.clients {
+		conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(restartNoticeTimeout))
+		conn.Close()
+	}
+}
+
+// Viewers lists the connected viewers, oldest first
+func (s *WebSocketServer) Viewers() []ViewerInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	viewers := make([]ViewerInfo, 0, len(s.clients))
+	for _, c := range s.clients {
+		role := c.role
+		if role == "" {
+			role = RoleController
+		}
+		viewers = append(viewers, ViewerInfo{ID: c.id, Address: c.address, Connected: c.connected, Role: role, Control: s.controlHolder == c, Region: c.region.Load()})
+	}
+	sort.Slice(viewers, func(i, j int) bool { return viewers[i].ID < viewers[j].ID })
+	return viewers
+}
+
+// StreamStats returns what streaming costs for each connected viewer,
+// oldest first
+func (s *WebSocketServer) StreamStats() []ViewerStreamStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := make([]ViewerStreamStats, 0, len(s.clients))
+	for _, c := range s.clients {
+		stats = append(stats, ViewerStreamStats{ID: c.id, SentBytes: c.sentBytes.Load(), EncodeTime: time.Duration(c.encodeTime.Load())})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// Preferences returns the preferences of the viewer with id, reporting
+// whether there is one
+func (s *WebSocketServer) Preferences(id int) (ViewerPreferences, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, c := range s.clients {
+		if c.id == id {
+			return c.preferences, true
+		}
+	}
+	return ViewerPreferences{}, false
+}
+
+// SetPreferences replaces the preferences of the viewer with id, tells
+// the viewer and keeps them under its token, reporting whether there is
+// one. Viewers without a hello hear of them once they send one.
+func (s *WebSocketServer) SetPreferences(id int, preferences ViewerPreferences) bool {
+	s.mu.Lock()
+	var found *wsClient
+	for _, c := range s.clients {
+		if c.id == id {
+			found = c
+			s.setPreferences(c, preferences)
+		}
+	}
+	s.mu.Unlock()
+	if found == nil {
+		return false
+	}
+	s.saveState(found)
+	return true
+}
+
+// Kick disconnects the viewer with id, reporting whether there was one
+func (s *WebSocketServer) Kick(id int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for conn, c := range s.clients {
+		if c.id == id {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// ClientCount returns the number of connected clients
+func (s *WebSocketServer) ClientCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients)
+}
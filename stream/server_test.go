@@ -0,0 +1,65 @@
+//go:build !noweb
+
+package stream
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+func TestHandleInput(t *testing.T) {
+	s := NewWebSocketServer()
+	var keys []uint32
+	var pointer []PointerEvent
+	var texts []string
+	s.SetKeyboardHandler(func(keycode uint32, pressed bool) {
+		keys = append(keys, keycode)
+	})
+	s.SetPointerHandler(func(e PointerEvent) {
+		pointer = append(pointer, e)
+	})
+	s.SetTextHandler(func(text string) {
+		texts = append(texts, text)
+	})
+
+	f32 := func(v float32) []byte {
+		return binary.LittleEndian.AppendUint32(nil, math.Float32bits(v))
+	}
+	motion := append(append([]byte{InputPointerMotion}, f32(12.5)...), f32(40)...)
+	relative := append(append([]byte{InputPointerRelative}, f32(-3)...), f32(2)...)
+	pen := append(slices.Concat([]byte{InputPen}, f32(1), f32(2), f32(0.5), f32(30), f32(-10)), PenTip)
+	button := append(binary.LittleEndian.AppendUint32([]byte{InputPointerButton}, 0x110), 1)
+	axis := append([]byte{InputPointerAxis, 1}, f32(-15)...)
+	key := append(binary.LittleEndian.AppendUint32([]byte{InputKeyboard}, 30), 1)
+	text := append([]byte{InputText}, "héllo"...)
+
+	for _, message := range [][]byte{motion, relative, pen, button, axis, key, text, {InputText}, {InputText, 0xff}, {InputPointerMotion, 1, 2}, {9}, nil} {
+		s.HandleInput(message)
+	}
+
+	if len(keys) != 1 || keys[0] != 30 {
+		t.Errorf("Expected key 30, got %v", keys)
+	}
+	if !slices.Equal(texts, []string{"héllo"}) {
+		t.Errorf("Expected one text message, got %q", texts)
+	}
+	want := []PointerEvent{
+		{Type: InputPointerMotion, X: 12.5, Y: 40},
+		{Type: InputPointerRelative, X: -3, Y: 2},
+		{Type: InputPen, X: 1, Y: 2, Pressure: 0.5, Tilt: [2]float32{30, -10}, PenButtons: PenTip},
+		{Type: InputPointerButton, Button: 0x110, Pressed: true},
+		{Type: InputPointerAxis, Axis: protocols.WlPointerAxis_enum_horizontal_scroll, Value: -15},
+	}
+	if len(pointer) != len(want) {
+		t.Fatalf("Expected %d pointer events, got %v", len(want), pointer)
+	}
+	for i := range want {
+		if pointer[i] != want[i] {
+			t.Errorf("Event %d: expected %+v, got %+v", i, want[i], pointer[i])
+		}
+	}
+}
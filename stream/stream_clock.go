@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"syscall"
+	"time"
+)
+
+// staUnsync is the kernel's STA_UNSYNC status bit, set while no time
+// daemon keeps the system clock disciplined
+const staUnsync = 0x40
+
+// clockStatus is how far the system clock can be trusted: whether NTP (or
+// PTP) keeps it synchronised, and the kernel's estimate of its error
+type clockStatus struct {
+	Synced   bool
+	EstError time.Duration
+}
+
+// readClockStatus asks the kernel about the system clock without changing
+// it. A clock that cannot be asked about is reported unsynced.
+func readClockStatus() clockStatus {
+	var tx syscall.Timex
+	if _, err := syscall.Adjtimex(&tx); err != nil {
+		return clockStatus{}
+	}
+	return clockStatus{
+		Synced:   tx.Status&staUnsync == 0,
+		EstError: time.Duration(tx.Esterror) * time.Microsecond,
+	}
+}
+
+// streamClock is the answer to a viewer's clock message. The viewer sends
+// {"type":"clock","client_time":<its clock>}; the reply carries that back
+// with the server's wall clock, in Unix microseconds, when the message was
+// received and when the reply was sent, as in an NTP exchange, so the
+// viewer can work out its clock's offset from the server's.
+type streamClock struct {
+	Type       string  `json:"type"`
+	ClientTime float64 `json:"client_time"`
+	ReceivedUS int64   `json:"received_us"`
+	SentUS     int64   `json:"sent_us"`
+	// Synced and ErrorUS say how far the server's clock itself is from
+	// true time, so viewers of different servers know how far to trust it
+	Synced  bool  `json:"synced"`
+	ErrorUS int64 `json:"error_us"`
+}
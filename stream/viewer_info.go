@@ -0,0 +1,23 @@
+package stream
+
+import "time"
+
+// ViewerInfo describes a connected viewer for the control API
+type ViewerInfo struct {
+	ID        int       `json:"id"`
+	Address   string    `json:"address"`
+	Connected time.Time `json:"connected"`
+	Role      string    `json:"role"`
+	// Control is set for the viewer holding exclusive input control
+	Control bool `json:"control,omitempty"`
+	// Region is the part of the desktop the viewer asked for, if any
+	Region *ViewerRegion `json:"region,omitempty"`
+}
+
+// ViewerStreamStats is what streaming costs for one viewer: the bytes of
+// frames sent to it so far and how long its last frame took to encode
+type ViewerStreamStats struct {
+	ID         int
+	SentBytes  int64
+	EncodeTime time.Duration
+}
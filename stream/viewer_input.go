@@ -0,0 +1,72 @@
+package stream
+
+import (
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// KeyboardEventHandler is a callback for handling keyboard events from WebSocket clients
+type KeyboardEventHandler func(keycode uint32, pressed bool)
+
+// TextInputHandler is a callback for text committed by a WebSocket
+// client's IME or virtual keyboard
+type TextInputHandler func(text string)
+
+// ViewerJoinedHandler is a callback for new WebSocket clients
+type ViewerJoinedHandler func(remoteAddr string, viewers int)
+
+// ControlChangedHandler is a callback for input control passing to the
+// viewer with holder, 0 for nobody
+type ControlChangedHandler func(holder int)
+
+// PointerEventHandler is a callback for pointer events from WebSocket clients
+type PointerEventHandler func(event PointerEvent)
+
+// InputObserver is a callback for every input message a viewer sends,
+// before it is dispatched
+type InputObserver func(message []byte)
+
+// WindowActionHandler is a callback for a viewer asking to focus or close
+// a window, named by its control API client and toplevel ids
+type WindowActionHandler func(action string, client int, toplevel uint32)
+
+// Input message types sent by viewers over /ws. All fields are little
+// endian:
+//
+//	keyboard:       [1][keycode:uint32][pressed:uint8]  Linux evdev keycode
+//	pointer motion: [2][x:float32][y:float32]           desktop pixels
+//	pointer button: [3][button:uint32][pressed:uint8]   Linux BTN_* code
+//	pointer axis:   [4][axis:uint8][value:float32]      0 vertical, 1 horizontal
+//	pointer move:   [5][dx:float32][dy:float32]         desktop pixels, while locked
+//	pen:            [6][x:float32][y:float32][pressure:float32][tilt x:float32][tilt y:float32][buttons:uint8]
+//	                pressure 0 to 1, tilt in degrees, buttons bit 0 the tip and bit 1 the barrel button
+//	text:           [7][text:utf-8]                     committed text, typed as key presses
+const (
+	InputKeyboard        = 1
+	InputPointerMotion   = 2
+	InputPointerButton   = 3
+	InputPointerAxis     = 4
+	InputPointerRelative = 5
+	InputPen             = 6
+	InputText            = 7
+)
+
+// Pen buttons in a pen message
+const (
+	PenTip    = 1 << 0
+	PenBarrel = 1 << 1
+)
+
+// PointerEvent is a pointer message from a viewer. Which fields are set
+// depends on Type.
+type PointerEvent struct {
+	Type    uint8
+	X, Y    float32
+	Button  uint32
+	Pressed bool
+	Axis    protocols.WlPointerAxis_enum
+	Value   float32
+	// Pressure, Tilt and PenButtons are set for pen messages
+	Pressure   float32
+	Tilt       [2]float32
+	PenButtons uint8
+}
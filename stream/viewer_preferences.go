@@ -0,0 +1,59 @@
+package stream
+
+import (
+	"fmt"
+	"slices"
+)
+
+// maxViewerScale is the most a viewer may magnify the desktop
+const maxViewerScale = 8
+
+// ViewerPreferences are a viewer's own settings. They are kept under its
+// reconnect token, so a viewer that comes back gets them with its hello
+// reply before the first frame.
+type ViewerPreferences struct {
+	// Quality is the best quality tier the viewer wants, by name; the
+	// server still moves it further down when it falls behind
+	Quality string `json:"quality,omitempty"`
+	// Scale is how much the viewer magnifies the desktop, 0 to fit it to
+	// the window
+	Scale float64 `json:"scale,omitempty"`
+	// Keys maps the Linux keycodes the viewer sends to the ones the
+	// clients get, e.g. {"58": 29} to turn Caps Lock into Control
+	Keys map[uint32]uint32 `json:"keys,omitempty"`
+	// Overlays shows or hides the viewer's overlays by name, such as
+	// "stats" and "taskbar"
+	Overlays map[string]bool `json:"overlays,omitempty"`
+}
+
+// Validate reports preferences the server cannot honor
+func (p ViewerPreferences) Validate() error {
+	if p.Quality != "" && p.qualityFloor() < 0 {
+		names := make([]string, len(qualityTiers))
+		for i, tier := range qualityTiers {
+			names[i] = tier.Name
+		}
+		return fmt.Errorf("unknown quality %q, have %v", p.Quality, names)
+	}
+	if p.Scale < 0 || p.Scale > maxViewerScale {
+		return fmt.Errorf("scale %g is not from 0 to %d", p.Scale, maxViewerScale)
+	}
+	return nil
+}
+
+// qualityFloor is the index in qualityTiers of the preferred quality, 0
+// without one and -1 for an unknown one
+func (p ViewerPreferences) qualityFloor() int {
+	if p.Quality == "" {
+		return 0
+	}
+	return slices.IndexFunc(qualityTiers, func(tier qualityTier) bool { return tier.Name == p.Quality })
+}
+
+// mapKey returns the keycode the clients get for the viewer's keycode
+func (p ViewerPreferences) mapKey(keycode uint32) uint32 {
+	if mapped, ok := p.Keys[keycode]; ok {
+		return mapped
+	}
+	return keycode
+}
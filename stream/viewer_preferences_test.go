@@ -0,0 +1,120 @@
+//go:build !noweb
+
+package stream
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestViewerPreferencesValidate(t *testing.T) {
+	for _, tt := range []struct {
+		preferences ViewerPreferences
+		valid       bool
+		floor       int
+	}{
+		{ViewerPreferences{}, true, 0},
+		{ViewerPreferences{Quality: "low", Scale: 2}, true, 2},
+		{ViewerPreferences{Quality: "ultra"}, false, -1},
+		{ViewerPreferences{Scale: -1}, false, 0},
+		{ViewerPreferences{Scale: maxViewerScale + 1}, false, 0},
+	} {
+		if err := tt.preferences.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got %v", tt.preferences, tt.valid, err)
+		}
+		if floor := tt.preferences.qualityFloor(); floor != tt.floor {
+			t.Errorf("%+v: expected floor %d, got %d", tt.preferences, tt.floor, floor)
+		}
+	}
+
+	capsAsControl := ViewerPreferences{Keys: map[uint32]uint32{58: 29}}
+	if capsAsControl.mapKey(58) != 29 || capsAsControl.mapKey(30) != 30 {
+		t.Error("Key mapping not applied")
+	}
+}
+
+func TestViewerPreferencesResume(t *testing.T) {
+	s := NewWebSocketServer()
+	keys := make(chan uint32, 4)
+	s.SetKeyboardHandler(func(keycode uint32, pressed bool) {
+		keys <- keycode
+	})
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer server.Close()
+
+	type message struct {
+		Type        string            `json:"type"`
+		Token       string            `json:"token"`
+		Preferences ViewerPreferences `json:"preferences"`
+	}
+	read := func(conn *websocket.Conn) message {
+		var m message
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	dial := func(hello string) (*websocket.Conn, message) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(hello)); err != nil {
+			t.Fatal(err)
+		}
+		return conn, read(conn)
+	}
+
+	first, hello := dial(`{"type":"hello"}`)
+	first.WriteMessage(websocket.TextMessage, []byte(`{"type":"preferences","preferences":{"quality":"ultra"}}`))
+	first.WriteMessage(websocket.TextMessage, []byte(`{"type":"preferences","preferences":{"quality":"low","keys":{"58":29},"overlays":{"stats":false}}}`))
+	if echo := read(first); echo.Type != "preferences" || echo.Preferences.Quality != "low" {
+		t.Fatalf("Expected the valid preferences echoed, got %+v", echo)
+	}
+	first.WriteMessage(websocket.BinaryMessage, []byte{InputKeyboard, 58, 0, 0, 0, 1})
+	select {
+	case key := <-keys:
+		if key != 29 {
+			t.Errorf("Expected Caps Lock mapped to 29, got %d", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The key never arrived")
+	}
+	first.Close()
+	for s.ClientCount() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The preferences come back with the hello reply
+	second, resumed := dial(`{"type":"hello","token":"` + hello.Token + `"}`)
+	defer second.Close()
+	if resumed.Preferences.Quality != "low" || resumed.Preferences.Overlays["stats"] {
+		t.Errorf("Expected the preferences back, got %+v", resumed.Preferences)
+	}
+	id := s.Viewers()[0].ID
+	if got, ok := s.Preferences(id); !ok || got.Keys[58] != 29 {
+		t.Errorf("Expected the key mapping back, got %+v", got)
+	}
+
+	// The control API replaces them and the viewer is told
+	if !s.SetPreferences(id, ViewerPreferences{Scale: 2}) {
+		t.Fatal("Viewer not found")
+	}
+	if pushed := read(second); pushed.Type != "preferences" || pushed.Preferences.Scale != 2 || pushed.Preferences.Quality != "" {
+		t.Errorf("Expected the new preferences pushed, got %+v", pushed)
+	}
+	if s.SetPreferences(id+1, ViewerPreferences{}) {
+		t.Error("Set preferences of a viewer that does not exist")
+	}
+}
@@ -0,0 +1,85 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// ViewerRegion is the part of the desktop a viewer asked to be sent, in
+// desktop pixels, and how much it is scaled by on the server first. No
+// width or height means the whole desktop.
+type ViewerRegion struct {
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Zoom   float64 `json:"zoom,omitempty"`
+}
+
+// Validate reports regions the server cannot send
+func (r ViewerRegion) Validate() error {
+	if r.X < 0 || r.Y < 0 || r.Width < 0 || r.Height < 0 {
+		return fmt.Errorf("region %+v is negative", r)
+	}
+	if r.Zoom != 0 && (r.Zoom < 1.0/maxViewerScale || r.Zoom > maxViewerScale) {
+		return fmt.Errorf("zoom %g is not from 1/%d to %d", r.Zoom, maxViewerScale, maxViewerScale)
+	}
+	return nil
+}
+
+// clamp is the region as sent for a width by height desktop: inside it,
+// at least a pixel across, and with its zoom filled in
+func (r ViewerRegion) clamp(width, height int) ViewerRegion {
+	if r.Zoom == 0 {
+		r.Zoom = 1
+	}
+	if r.Width == 0 || r.Height == 0 {
+		r.X, r.Y, r.Width, r.Height = 0, 0, width, height
+		return r
+	}
+	r.X, r.Y = min(r.X, width-1), min(r.Y, height-1)
+	r.Width, r.Height = min(r.Width, width-r.X), min(r.Height, height-r.Y)
+	return r
+}
+
+// whole reports whether a clamped region is a width by height desktop as
+// it is
+func (r ViewerRegion) whole(width, height int) bool {
+	return r == ViewerRegion{Width: width, Height: height, Zoom: 1}
+}
+
+// size is how big a clamped region is once zoomed
+func (r ViewerRegion) size() (width, height int) {
+	return max(int(math.Round(float64(r.Width)*r.Zoom)), 1), max(int(math.Round(float64(r.Height)*r.Zoom)), 1)
+}
+
+// regionNotice is the text message telling a viewer the region its frames
+// now show
+func regionNotice(region ViewerRegion) []byte {
+	notice, _ := json.Marshal(struct {
+		Type string `json:"type"`
+		ViewerRegion
+	}{"region", region})
+	return notice
+}
+
+// cropFrame copies a clamped region of frame into a pooled frame of its
+// own, scaled by its zoom to the nearest pixel
+func cropFrame(frame *wsFrame, region ViewerRegion) *wsFrame {
+	w, h := region.size()
+	cropped := newWSFrame(getFrameBuffer(w*h*4), w, h, w*4, frame.captured)
+	for y := range h {
+		row := (region.Y + y*region.Height/h) * frame.stride
+		out := cropped.buffer[y*w*4 : (y+1)*w*4]
+		if w == region.Width {
+			copy(out, frame.buffer[row+region.X*4:])
+			continue
+		}
+		for x := range w {
+			p := row + (region.X+x*region.Width/w)*4
+			copy(out[x*4:x*4+4], frame.buffer[p:p+4])
+		}
+	}
+	return cropped
+}
@@ -0,0 +1,72 @@
+package stream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestViewerRegionValidate(t *testing.T) {
+	for _, tt := range []struct {
+		region ViewerRegion
+		valid  bool
+	}{
+		{ViewerRegion{}, true},
+		{ViewerRegion{X: 10, Y: 20, Width: 100, Height: 50, Zoom: 2}, true},
+		{ViewerRegion{X: -1, Width: 100, Height: 50}, false},
+		{ViewerRegion{Width: 100, Height: 50, Zoom: maxViewerScale + 1}, false},
+		{ViewerRegion{Width: 100, Height: 50, Zoom: 0.01}, false},
+	} {
+		if err := tt.region.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got %v", tt.region, tt.valid, err)
+		}
+	}
+}
+
+func TestViewerRegionClamp(t *testing.T) {
+	for _, tt := range []struct {
+		region, want ViewerRegion
+	}{
+		{ViewerRegion{}, ViewerRegion{Width: 800, Height: 600, Zoom: 1}},
+		{ViewerRegion{X: 100, Y: 50, Width: 200, Height: 100, Zoom: 2}, ViewerRegion{X: 100, Y: 50, Width: 200, Height: 100, Zoom: 2}},
+		{ViewerRegion{X: 700, Y: 550, Width: 200, Height: 100}, ViewerRegion{X: 700, Y: 550, Width: 100, Height: 50, Zoom: 1}},
+		{ViewerRegion{X: 900, Y: 900, Width: 10, Height: 10}, ViewerRegion{X: 799, Y: 599, Width: 1, Height: 1, Zoom: 1}},
+	} {
+		if got := tt.region.clamp(800, 600); got != tt.want {
+			t.Errorf("%+v: expected %+v, got %+v", tt.region, tt.want, got)
+		}
+	}
+	if !(ViewerRegion{}).clamp(800, 600).whole(800, 600) {
+		t.Error("Expected no region to be the whole desktop")
+	}
+}
+
+func TestCropFrame(t *testing.T) {
+	// Each pixel holds its own coordinates, in rows padded to 20 bytes
+	frame := newWSFrame(make([]byte, 20*3), 4, 3, 20, time.Now())
+	for y := range 3 {
+		for x := range 4 {
+			copy(frame.buffer[y*20+x*4:], []byte{byte(x), byte(y), 0, 0xff})
+		}
+	}
+	pixel := func(f *wsFrame, x, y int) [2]byte {
+		return [2]byte{f.buffer[y*f.stride+x*4], f.buffer[y*f.stride+x*4+1]}
+	}
+
+	cropped := cropFrame(frame, ViewerRegion{X: 1, Y: 1, Width: 2, Height: 2, Zoom: 1})
+	if cropped.width != 2 || cropped.height != 2 || cropped.stride != 8 {
+		t.Fatalf("Expected a 2x2 frame, got %dx%d stride %d", cropped.width, cropped.height, cropped.stride)
+	}
+	if pixel(cropped, 0, 0) != [2]byte{1, 1} || pixel(cropped, 1, 1) != [2]byte{2, 2} {
+		t.Errorf("Expected the middle of the frame, got %v", cropped.buffer)
+	}
+
+	zoomed := cropFrame(frame, ViewerRegion{X: 2, Y: 0, Width: 2, Height: 1, Zoom: 2})
+	if zoomed.width != 4 || zoomed.height != 2 {
+		t.Fatalf("Expected a 4x2 frame, got %dx%d", zoomed.width, zoomed.height)
+	}
+	for x, want := range []byte{2, 2, 3, 3} {
+		if got := pixel(zoomed, x, 1); got != [2]byte{want, 0} {
+			t.Errorf("Pixel %d: expected column %d, got %v", x, want, got)
+		}
+	}
+}
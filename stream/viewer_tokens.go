@@ -0,0 +1,165 @@
+package stream
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Viewer roles: controllers' input goes to the clients, spectators only
+// watch
+const (
+	RoleController = "controller"
+	RoleSpectator  = "spectator"
+)
+
+// viewerTokenTTL is how long after a viewer disconnects its token still
+// resumes it
+const viewerTokenTTL = 10 * time.Minute
+
+// ViewerState is what a reconnect token restores: the viewer's role, what
+// it subscribed to and its preferences
+type ViewerState struct {
+	Role       string `json:"role"`
+	Windows    bool   `json:"windows,omitempty"`
+	Thumbnails bool   `json:"thumbnails,omitempty"`
+	// Preferences are the viewer's own settings
+	Preferences ViewerPreferences `json:"preferences,omitzero"`
+	// Seen is when the viewer was last connected
+	Seen time.Time `json:"seen"`
+}
+
+// ViewerTokens hands viewers reconnect tokens in the hello reply and
+// remembers their state under them, so a viewer that comes back after a
+// dropped connection or a restart with its token gets the same role and
+// subscriptions. With a path the tokens are kept there across restarts.
+type ViewerTokens struct {
+	Path string
+
+	mu     sync.Mutex
+	tokens map[string]ViewerState
+	// connected are the tokens of connected viewers, which do not expire
+	connected map[string]bool
+}
+
+// NewViewerTokens creates a token store, loading the tokens kept at path
+// if it exists. An empty path keeps them in memory only.
+func NewViewerTokens(path string) (*ViewerTokens, error) {
+	t := &ViewerTokens{Path: path, tokens: make(map[string]ViewerState), connected: make(map[string]bool)}
+	if path == "" {
+		return t, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read viewer tokens: %w", err)
+	}
+	if err := json.Unmarshal(data, &t.tokens); err != nil {
+		return nil, fmt.Errorf("parse viewer tokens %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Issue makes a token for a new viewer in state
+func (t *ViewerTokens) Issue(state ViewerState, now time.Time) string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	token := hex.EncodeToString(random)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state.Seen = now
+	t.tokens[token] = state
+	t.connected[token] = true
+	t.changed(now)
+	return token
+}
+
+// Resume returns the state of a returning viewer's token, reporting false
+// for unknown and expired tokens
+func (t *ViewerTokens) Resume(token string, now time.Time) (ViewerState, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.tokens[token]
+	if !ok || (!t.connected[token] && now.Sub(state.Seen) > viewerTokenTTL) {
+		return ViewerState{}, false
+	}
+	state.Seen = now
+	t.tokens[token] = state
+	t.connected[token] = true
+	t.changed(now)
+	return state, true
+}
+
+// Update replaces the state kept under a connected viewer's token
+func (t *ViewerTokens) Update(token string, state ViewerState, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.tokens[token]; !ok {
+		return
+	}
+	state.Seen = now
+	t.tokens[token] = state
+	t.changed(now)
+}
+
+// Release notes that a viewer disconnected; its token expires
+// viewerTokenTTL later
+func (t *ViewerTokens) Release(token string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.tokens[token]
+	if !ok {
+		return
+	}
+	delete(t.connected, token)
+	state.Seen = now
+	t.tokens[token] = state
+	t.changed(now)
+}
+
+// changed drops expired tokens and saves the rest. Call with t.mu held.
+func (t *ViewerTokens) changed(now time.Time) {
+	for token, state := range t.tokens {
+		if !t.connected[token] && now.Sub(state.Seen) > viewerTokenTTL {
+			delete(t.tokens, token)
+		}
+	}
+	if t.Path == "" {
+		return
+	}
+	if err := t.save(); err != nil {
+		log.Printf("Viewer tokens: failed to save: %v", err)
+	}
+}
+
+// save writes the tokens to Path through a temporary file, so a crash
+// never leaves it half written
+func (t *ViewerTokens) save() error {
+	data, err := json.MarshalIndent(t.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.Path), ".viewer-tokens-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.Path)
+}
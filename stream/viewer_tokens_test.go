@@ -0,0 +1,148 @@
+//go:build !noweb
+
+package stream
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestViewerTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	tokens, err := NewViewerTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	spectator := tokens.Issue(ViewerState{Role: RoleSpectator}, now)
+	controller := tokens.Issue(ViewerState{Role: RoleController}, now)
+	if spectator == controller || len(spectator) != 32 {
+		t.Fatalf("Expected two distinct tokens, got %q and %q", spectator, controller)
+	}
+	tokens.Update(spectator, ViewerState{Role: RoleSpectator, Windows: true, Thumbnails: true}, now)
+	tokens.Release(spectator, now)
+	tokens.Release(controller, now.Add(-viewerTokenTTL))
+
+	// A restart loads the tokens back; the controller's expired
+	restarted, err := NewViewerTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := now.Add(time.Second)
+	state, ok := restarted.Resume(spectator, later)
+	if !ok || state.Role != RoleSpectator || !state.Windows || !state.Thumbnails {
+		t.Errorf("Expected the spectator's subscriptions back, got %+v (%v)", state, ok)
+	}
+	if _, ok := restarted.Resume(controller, later); ok {
+		t.Error("Expired token was resumed")
+	}
+	if _, ok := restarted.Resume("unknown", later); ok {
+		t.Error("Unknown token was resumed")
+	}
+
+	// Connected viewers' tokens never expire
+	if _, ok := restarted.Resume(spectator, later.Add(2*viewerTokenTTL)); !ok {
+		t.Error("Connected viewer's token expired")
+	}
+}
+
+func TestViewerResumeAndStatus(t *testing.T) {
+	s := NewWebSocketServer()
+	keys := make(chan uint32, 4)
+	s.SetKeyboardHandler(func(keycode uint32, pressed bool) {
+		keys <- keycode
+	})
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer server.Close()
+
+	type reply struct {
+		Type    string `json:"type"`
+		Token   string `json:"token"`
+		Role    string `json:"role"`
+		Resumed bool   `json:"resumed"`
+		Status  string `json:"status"`
+		RetryMS int    `json:"retry_ms"`
+	}
+	read := func(conn *websocket.Conn) reply {
+		var message reply
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		kind, data, err := conn.ReadMessage()
+		if err != nil || kind != websocket.TextMessage {
+			t.Fatalf("Expected a text message, got %q (%v)", data, err)
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	dial := func(hello string) (*websocket.Conn, reply) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(hello)); err != nil {
+			t.Fatal(err)
+		}
+		return conn, read(conn)
+	}
+
+	// The hello reply comes without waiting for a frame
+	first, hello := dial(`{"type":"hello","role":"spectator"}`)
+	if hello.Type != "hello" || hello.Role != RoleSpectator || hello.Resumed || hello.Token == "" {
+		t.Fatalf("Expected a spectator's hello reply, got %+v", hello)
+	}
+	if err := first.WriteMessage(websocket.TextMessage, []byte(`{"type":"windows","thumbnails":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	// Spectators' input is dropped
+	first.WriteMessage(websocket.BinaryMessage, []byte{InputKeyboard, 30, 0, 0, 0, 1})
+	for {
+		if _, thumbnails := s.WindowsWanted(); thumbnails {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	first.Close()
+	for s.ClientCount() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case key := <-keys:
+		t.Errorf("Spectator's key %d reached the clients", key)
+	default:
+	}
+
+	s.SetStreamStatus(StreamPaused)
+	second, resumed := dial(`{"type":"hello","token":"` + hello.Token + `"}`)
+	defer second.Close()
+	if !resumed.Resumed || resumed.Role != RoleSpectator || resumed.Token != hello.Token {
+		t.Errorf("Expected the spectator back, got %+v", resumed)
+	}
+	if status := read(second); status.Type != "status" || status.Status != StreamPaused {
+		t.Errorf("Expected the paused status after the hello, got %+v", status)
+	}
+	if _, thumbnails := s.WindowsWanted(); !thumbnails {
+		t.Error("The window list subscription was not restored")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Restarting(3 * time.Second)
+		close(done)
+	}()
+	if status := read(second); status.Status != StreamRestarting || status.RetryMS != 3000 {
+		t.Errorf("Expected a restart notice, got %+v", status)
+	}
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := second.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("Expected a service restart close, got %v", err)
+	}
+	<-done
+}
@@ -0,0 +1,20 @@
+package stream
+
+// WindowInfo is one window in the list sent to viewers that ask for it,
+// named by the same client and toplevel ids as the control API. X, Y,
+// Width and Height are where it is on the desktop.
+type WindowInfo struct {
+	Client   int    `json:"client"`
+	Toplevel uint32 `json:"toplevel"`
+	AppID    string `json:"app_id,omitempty"`
+	Title    string `json:"title,omitempty"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	// Focused is set on the top window
+	Focused bool `json:"focused"`
+	// Thumbnail is a data: URL of a small JPEG of the window, for viewers
+	// that asked for thumbnails
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
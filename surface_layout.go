@@ -0,0 +1,317 @@
+package compositor
+
+import (
+	"image"
+	"sort"
+
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// maxSurfaceDepth bounds how deep surface trees are walked, guarding
+// against cycles in client-provided parent links
+const maxSurfaceDepth = 32
+
+// surfaceKey identifies a client's surface
+type surfaceKey struct {
+	client *wayland.Client
+	id     protocols.ObjectID[protocols.WlSurface]
+}
+
+// PlacedSurface is a drawable client surface with its absolute position on
+// the desktop, see render.PlacedSurface
+type PlacedSurface = render.PlacedSurface
+
+// surfaceTree is one root surface (a toplevel, cursor...) together with the
+// subsurfaces and popups stacked on it, bottom first
+type surfaceTree struct {
+	rootID protocols.ObjectID[protocols.WlSurface]
+	z      int32
+	placed []PlacedSurface
+}
+
+// clientLayout resolves parent links and positions for one client's
+// surfaces
+type clientLayout struct {
+	client *wayland.Client
+	bounds image.Rectangle
+
+	// parents maps a child surface to the surface whose draw order lists it
+	parents map[protocols.ObjectID[protocols.WlSurface]]protocols.ObjectID[protocols.WlSurface]
+	// popups maps a surface to the xdg_popups opened on it
+	popups map[protocols.ObjectID[protocols.WlSurface]][]protocols.ObjectID[protocols.WlSurface]
+}
+
+// Collect gathers every drawable surface of the given clients and
+// resolves its absolute position. Root surfaces are ordered like
+// wayland.Desktop.DrawClients does; subsurfaces are stacked according to
+// their parent's draw order (wl_subsurface.place_above/place_below) and
+// positioned from their current wl_subsurface.set_position. Popups are
+// drawn above their parent at the position their xdg_positioner solves to
+// inside bounds.
+func (s *SurfaceSnapshots) Collect(clients []*wayland.Client, bounds image.Rectangle) []PlacedSurface {
+	var trees []surfaceTree
+	read := make(map[*wayland.Client][]surfaceTree, len(clients))
+	busy := make(map[*wayland.Client]bool)
+	for _, c := range clients {
+		if c == nil {
+			continue
+		}
+		clientTrees := s.trees[c]
+		if s.lock(c) {
+			clientTrees = s.readClient(c, bounds)
+			c.Access.Unlock()
+		} else {
+			busy[c] = true
+		}
+		read[c] = clientTrees
+		trees = append(trees, clientTrees...)
+	}
+	s.trees, s.busy = read, busy
+
+	sort.SliceStable(trees, func(i, j int) bool {
+		if trees[i].z == trees[j].z {
+			return trees[i].rootID < trees[j].rootID
+		}
+		return trees[i].z < trees[j].z
+	})
+
+	placed := make([]PlacedSurface, 0, 64)
+	for _, tree := range trees {
+		placed = append(placed, tree.placed...)
+	}
+	s.prune(placed)
+	return placed
+}
+
+// readClient reads a client's surface trees, holding its lock
+func (s *SurfaceSnapshots) readClient(c *wayland.Client, bounds image.Rectangle) []surfaceTree {
+	layout := newClientLayout(c, bounds)
+	roots := make(map[protocols.ObjectID[protocols.WlSurface]]bool)
+	for surfaceID := range c.DrawableSurfaces() {
+		roots[layout.root(surfaceID)] = true
+	}
+	trees := make([]surfaceTree, 0, len(roots))
+	for rootID := range roots {
+		tree := surfaceTree{rootID: rootID}
+		if root := wayland.GetWlSurfaceObject(c, rootID); root != nil {
+			tree.z = root.Position.Z
+		}
+		visited := make(map[protocols.ObjectID[protocols.WlSurface]]bool)
+		tree.placed = layout.appendTree(nil, visited, rootID, 0)
+		for i := range tree.placed {
+			p := &tree.placed[i]
+			p.Root = rootID
+			p.Texture = s.texture(surfaceKey{client: c, id: p.SurfaceID}, p.Texture)
+		}
+		trees = append(trees, tree)
+	}
+	return trees
+}
+
+func newClientLayout(c *wayland.Client, bounds image.Rectangle) *clientLayout {
+	l := &clientLayout{
+		client:  c,
+		bounds:  bounds,
+		parents: make(map[protocols.ObjectID[protocols.WlSurface]]protocols.ObjectID[protocols.WlSurface]),
+		popups:  make(map[protocols.ObjectID[protocols.WlSurface]][]protocols.ObjectID[protocols.WlSurface]),
+	}
+	for surfaceID := range c.DrawableSurfaces() {
+		surface := wayland.GetWlSurfaceObject(c, surfaceID)
+		if surface == nil {
+			continue
+		}
+		for _, child := range surface.ChildrenInDrawOrder {
+			if child != nil {
+				l.parents[*child] = surfaceID
+			}
+		}
+		if parentID, _, ok := l.popupParent(surface); ok {
+			l.popups[parentID] = append(l.popups[parentID], surfaceID)
+		}
+	}
+	for _, popups := range l.popups {
+		sort.Slice(popups, func(i, j int) bool { return popups[i] < popups[j] })
+	}
+	return l
+}
+
+// appendTree appends a surface, its subsurfaces, and its popups in draw
+// order. A nil entry in ChildrenInDrawOrder stands for the surface itself.
+func (l *clientLayout) appendTree(
+	placed []PlacedSurface,
+	visited map[protocols.ObjectID[protocols.WlSurface]]bool,
+	surfaceID protocols.ObjectID[protocols.WlSurface],
+	depth int,
+) []PlacedSurface {
+	if visited[surfaceID] || depth > maxSurfaceDepth {
+		return placed
+	}
+	visited[surfaceID] = true
+
+	surface := wayland.GetWlSurfaceObject(l.client, surfaceID)
+	if surface == nil {
+		return placed
+	}
+
+	drewSelf := false
+	drawSelf := func() {
+		drewSelf = true
+		if !l.client.DrawableSurfaces()[surfaceID] || surface.Texture.AsRGBA() == nil {
+			return
+		}
+		x, y := l.position(surfaceID)
+		source, size := placedViewport(surface)
+		placed = append(placed, PlacedSurface{
+			Client:    l.client,
+			SurfaceID: surfaceID,
+			Surface:   surface,
+			Texture:   surface.Texture,
+			X:         x,
+			Y:         y,
+			Source:    source,
+			Size:      size,
+		})
+	}
+
+	for _, child := range surface.ChildrenInDrawOrder {
+		if child == nil {
+			drawSelf()
+			continue
+		}
+		placed = l.appendTree(placed, visited, *child, depth+1)
+	}
+	if !drewSelf {
+		drawSelf()
+	}
+
+	for _, popupID := range l.popups[surfaceID] {
+		placed = l.appendTree(placed, visited, popupID, depth+1)
+	}
+	return placed
+}
+
+// parent returns the parent of a surface and the surface's offset relative
+// to the parent's origin. Subsurfaces use their live wl_subsurface
+// position; popups use their solved xdg_positioner position.
+func (l *clientLayout) parent(surfaceID protocols.ObjectID[protocols.WlSurface], depth int) (parentID protocols.ObjectID[protocols.WlSurface], x, y int, ok bool) {
+	surface := wayland.GetWlSurfaceObject(l.client, surfaceID)
+	if surface == nil {
+		return 0, 0, 0, false
+	}
+	switch role := surface.Role.(type) {
+	case *wayland.SurfaceRoleSubSurface:
+		if role.Data != nil {
+			if sub := wayland.GetWlSubsurfaceObject(l.client, *role.Data); sub != nil {
+				return sub.Parent, int(sub.Position.X), int(sub.Position.Y), true
+			}
+		}
+	case *wayland.SurfaceRoleXdgPopup:
+		if parentID, popup, ok := l.popupParent(surface); ok {
+			x, y := l.popupOffset(surfaceID, popup, parentID, depth)
+			return parentID, x, y, true
+		}
+	}
+	parentID, ok = l.parents[surfaceID]
+	return parentID, int(surface.Position.X), int(surface.Position.Y), ok
+}
+
+// popupParent returns the wl_surface a popup was opened on
+func (l *clientLayout) popupParent(surface *wayland.WlSurface) (protocols.ObjectID[protocols.WlSurface], *wayland.XdgPopup, bool) {
+	role, ok := surface.Role.(*wayland.SurfaceRoleXdgPopup)
+	if !ok || role.Data == nil {
+		return 0, nil, false
+	}
+	popup := getXdgPopup(l.client, *role.Data)
+	if popup == nil || popup.Parent == nil {
+		return 0, nil, false
+	}
+	parentID := l.client.GetSurfaceIDFromRole(protocols.AnyObjectID(*popup.Parent))
+	if parentID == nil {
+		return 0, nil, false
+	}
+	return *parentID, popup, true
+}
+
+// popupOffset returns the popup surface origin relative to its parent
+// surface origin. Positioner coordinates are relative to the window
+// geometry of both surfaces.
+func (l *clientLayout) popupOffset(
+	surfaceID protocols.ObjectID[protocols.WlSurface],
+	popup *wayland.XdgPopup,
+	parentID protocols.ObjectID[protocols.WlSurface],
+	depth int,
+) (int, int) {
+	parentGeometry := l.windowGeometryOrigin(parentID)
+	parentX, parentY := l.positionAt(parentID, depth+1)
+	origin := image.Pt(parentX, parentY).Add(parentGeometry)
+
+	rect := positionPopup(popup.State, l.bounds.Sub(origin))
+	offset := rect.Min.Add(parentGeometry).Sub(l.windowGeometryOrigin(surfaceID))
+	return offset.X, offset.Y
+}
+
+// windowGeometryOrigin returns the xdg_surface.set_window_geometry offset
+// of a surface, or zero for surfaces without one
+func (l *clientLayout) windowGeometryOrigin(surfaceID protocols.ObjectID[protocols.WlSurface]) image.Point {
+	surface := wayland.GetWlSurfaceObject(l.client, surfaceID)
+	if surface == nil || surface.XdgSurfaceState == nil {
+		return image.Point{}
+	}
+	xdgSurface := wayland.GetXdgSurfaceObject(l.client, *surface.XdgSurfaceState)
+	if xdgSurface == nil {
+		return image.Point{}
+	}
+	return image.Pt(int(xdgSurface.WindowGeometry.X), int(xdgSurface.WindowGeometry.Y))
+}
+
+// root walks up the surface tree to the surface with no parent
+func (l *clientLayout) root(surfaceID protocols.ObjectID[protocols.WlSurface]) protocols.ObjectID[protocols.WlSurface] {
+	for depth := 0; depth < maxSurfaceDepth; depth++ {
+		parentID, ok := l.parentID(surfaceID)
+		if !ok {
+			break
+		}
+		surfaceID = parentID
+	}
+	return surfaceID
+}
+
+// parentID is parent without resolving offsets
+func (l *clientLayout) parentID(surfaceID protocols.ObjectID[protocols.WlSurface]) (protocols.ObjectID[protocols.WlSurface], bool) {
+	surface := wayland.GetWlSurfaceObject(l.client, surfaceID)
+	if surface == nil {
+		return 0, false
+	}
+	if parentID, _, ok := l.popupParent(surface); ok {
+		return parentID, true
+	}
+	if role, isSub := surface.Role.(*wayland.SurfaceRoleSubSurface); isSub && role.Data != nil {
+		if sub := wayland.GetWlSubsurfaceObject(l.client, *role.Data); sub != nil {
+			return sub.Parent, true
+		}
+	}
+	parentID, ok := l.parents[surfaceID]
+	return parentID, ok
+}
+
+// position returns the absolute desktop position of a surface
+func (l *clientLayout) position(surfaceID protocols.ObjectID[protocols.WlSurface]) (int, int) {
+	return l.positionAt(surfaceID, 0)
+}
+
+// positionAt adds up the offsets of a surface and all its ancestors
+func (l *clientLayout) positionAt(surfaceID protocols.ObjectID[protocols.WlSurface], depth int) (int, int) {
+	x, y := 0, 0
+	for ; depth < maxSurfaceDepth; depth++ {
+		parentID, dx, dy, ok := l.parent(surfaceID, depth)
+		x += dx
+		y += dy
+		if !ok {
+			break
+		}
+		surfaceID = parentID
+	}
+	return x, y
+}
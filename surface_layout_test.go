@@ -0,0 +1,77 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// addTestSurface registers a drawable surface with a w x h buffer on client
+func addTestSurface(c *wayland.Client, id protocols.ObjectID[protocols.WlSurface], x, y, z int32, w, h uint32) *wayland.WlSurface {
+	surface := &wayland.WlSurface{
+		Texture: &wayland.Texture{
+			Width:  w,
+			Height: h,
+			Stride: w * 4,
+			Data:   make([]byte, w*h*4),
+		},
+	}
+	surface.Position.X = x
+	surface.Position.Y = y
+	surface.Position.Z = z
+	c.Objects[protocols.AnyObjectID(id)] = &protocols.WlSurface{Delegate: surface}
+	c.DrawableSurfaces()[id] = true
+	return surface
+}
+
+func TestCollectSurfacesOrderAndPosition(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	parent := addTestSurface(c, 10, 100, 50, 0, 200, 200)
+	addTestSurface(c, 11, 5, 5, 1, 20, 20)
+	addTestSurface(c, 12, 0, 0, 2, 10, 10)
+	child := protocols.ObjectID[protocols.WlSurface](11)
+	parent.ChildrenInDrawOrder = []*protocols.ObjectID[protocols.WlSurface]{nil, &child}
+
+	placed := NewSurfaceSnapshots().Collect([]*wayland.Client{c}, image.Rect(0, 0, 800, 600))
+	if len(placed) != 3 {
+		t.Fatalf("Expected 3 surfaces, got %d", len(placed))
+	}
+	for i, want := range []protocols.ObjectID[protocols.WlSurface]{10, 11, 12} {
+		if placed[i].SurfaceID != want {
+			t.Errorf("Position %d: expected surface %d, got %d", i, want, placed[i].SurfaceID)
+		}
+	}
+	if placed[1].X != 105 || placed[1].Y != 55 {
+		t.Errorf("Child should be offset by its parent, got (%d, %d)", placed[1].X, placed[1].Y)
+	}
+}
+
+func TestCollectSurfacesSubsurfaceBelowParent(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	parent := addTestSurface(c, 20, 30, 40, 0, 100, 100)
+	child := addTestSurface(c, 21, 0, 0, 1, 10, 10)
+
+	// The library only refreshes a subsurface's Position when it commits,
+	// so the live wl_subsurface position must win.
+	subID := protocols.ObjectID[protocols.WlSubsurface](22)
+	child.Role = &wayland.SurfaceRoleSubSurface{Data: &subID}
+	c.Objects[protocols.AnyObjectID(subID)] = &protocols.WlSubsurface{
+		Delegate: &wayland.WlSubsurface{Parent: 20, Position: wayland.Point{X: 7, Y: 8}},
+	}
+	childID := protocols.ObjectID[protocols.WlSurface](21)
+	parent.ChildrenInDrawOrder = []*protocols.ObjectID[protocols.WlSurface]{&childID, nil}
+
+	placed := NewSurfaceSnapshots().Collect([]*wayland.Client{c}, image.Rect(0, 0, 800, 600))
+	if len(placed) != 2 {
+		t.Fatalf("Expected 2 surfaces, got %d", len(placed))
+	}
+	if placed[0].SurfaceID != 21 || placed[1].SurfaceID != 20 {
+		t.Errorf("Subsurface placed below should be drawn first, got %d then %d",
+			placed[0].SurfaceID, placed[1].SurfaceID)
+	}
+	if placed[0].X != 37 || placed[0].Y != 48 {
+		t.Errorf("Expected subsurface at (37, 48), got (%d, %d)", placed[0].X, placed[0].Y)
+	}
+}
@@ -0,0 +1,92 @@
+package compositor
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// clientLockWait is how long Collect waits for a client busy dispatching
+// requests before placing the surfaces it read of the client last time.
+// A client blocked writing to a socket nobody reads holds its lock for as
+// long as that lasts, so a client that was busy last time is not waited
+// for again until its lock has been free.
+const clientLockWait = 10 * time.Millisecond
+
+// SurfaceSnapshots is what the render loop last read of each client's
+// surfaces. Clients change their surfaces, and write their buffers in
+// place, on their own goroutines under their lock, so Collect reads each
+// client under that lock and places copies of the buffers that are never
+// written to: a frame can be culled, composited and uploaded after its
+// clients have moved on, on any goroutine.
+type SurfaceSnapshots struct {
+	// trees are each client's surface trees as last read
+	trees map[*wayland.Client][]surfaceTree
+	// busy are the clients whose lock was not free last time
+	busy map[*wayland.Client]bool
+	// textures are the copies of the buffers last placed, by surface
+	textures map[surfaceKey]textureSnapshot
+}
+
+// textureSnapshot is a copy of a client's buffer
+type textureSnapshot struct {
+	live *wayland.Texture
+	copy *wayland.Texture
+}
+
+// NewSurfaceSnapshots creates a reader that has read no clients yet
+func NewSurfaceSnapshots() *SurfaceSnapshots {
+	return &SurfaceSnapshots{
+		trees:    make(map[*wayland.Client][]surfaceTree),
+		busy:     make(map[*wayland.Client]bool),
+		textures: make(map[surfaceKey]textureSnapshot),
+	}
+}
+
+// lock takes a client's lock, reporting false when it is not free within
+// clientLockWait
+func (s *SurfaceSnapshots) lock(c *wayland.Client) bool {
+	if c.Access.TryLock() {
+		return true
+	}
+	if s.busy[c] {
+		return false
+	}
+	for deadline := time.Now().Add(clientLockWait); time.Now().Before(deadline); {
+		time.Sleep(100 * time.Microsecond)
+		if c.Access.TryLock() {
+			return true
+		}
+	}
+	return false
+}
+
+// texture returns a copy of a surface's buffer, the same copy as last
+// time while the client has not changed it. Call it holding the client's
+// lock.
+func (s *SurfaceSnapshots) texture(key surfaceKey, live *wayland.Texture) *wayland.Texture {
+	if live == nil {
+		return nil
+	}
+	if last, ok := s.textures[key]; ok && last.live == live && last.copy.Stride == live.Stride &&
+		last.copy.Width == live.Width && last.copy.Height == live.Height && bytes.Equal(last.copy.Data, live.Data) {
+		return last.copy
+	}
+	copied := &wayland.Texture{Stride: live.Stride, Width: live.Width, Height: live.Height, Data: bytes.Clone(live.Data)}
+	s.textures[key] = textureSnapshot{live: live, copy: copied}
+	return copied
+}
+
+// prune forgets the copies of buffers no longer placed
+func (s *SurfaceSnapshots) prune(placed []PlacedSurface) {
+	seen := make(map[surfaceKey]bool, len(placed))
+	for _, p := range placed {
+		seen[surfaceKey{client: p.Client, id: p.SurfaceID}] = true
+	}
+	for key := range s.textures {
+		if !seen[key] {
+			delete(s.textures, key)
+		}
+	}
+}
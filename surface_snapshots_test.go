@@ -0,0 +1,58 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestSurfaceSnapshotsCopyBuffers(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	surface := addTestSurface(c, 10, 0, 0, 0, 2, 2)
+	snapshots := NewSurfaceSnapshots()
+	bounds := image.Rect(0, 0, 800, 600)
+
+	first := snapshots.Collect([]*wayland.Client{c}, bounds)[0].Texture
+	if first == surface.Texture || &first.Data[0] == &surface.Texture.Data[0] {
+		t.Fatal("Expected the buffer placed to be a copy")
+	}
+	if again := snapshots.Collect([]*wayland.Client{c}, bounds)[0].Texture; again != first {
+		t.Error("Expected the same copy while the buffer is unchanged")
+	}
+
+	// Clients write their buffers in place
+	surface.Texture.Data[0] = 0xff
+	changed := snapshots.Collect([]*wayland.Client{c}, bounds)[0].Texture
+	if changed == first || changed.Data[0] != 0xff {
+		t.Error("Expected a new copy once the buffer changed")
+	}
+	if first.Data[0] != 0 {
+		t.Error("A copy already placed was written to")
+	}
+}
+
+func TestSurfaceSnapshotsKeepBusyClients(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	surface := addTestSurface(c, 10, 0, 0, 0, 2, 2)
+	snapshots := NewSurfaceSnapshots()
+	bounds := image.Rect(0, 0, 800, 600)
+	snapshots.Collect([]*wayland.Client{c}, bounds)
+
+	// A client dispatching requests holds its lock
+	c.Access.Lock()
+	surface.Position.X = 50
+	placed := snapshots.Collect([]*wayland.Client{c}, bounds)
+	if len(placed) != 1 || placed[0].X != 0 {
+		t.Fatalf("Expected the surface as last read while the client is busy, got %+v", placed)
+	}
+	if !snapshots.busy[c] {
+		t.Error("Expected the client remembered as busy, so it is not waited for again")
+	}
+	c.Access.Unlock()
+
+	placed = snapshots.Collect([]*wayland.Client{c}, bounds)
+	if len(placed) != 1 || placed[0].X != 50 {
+		t.Errorf("Expected the surface read again once the client is free, got %+v", placed)
+	}
+}
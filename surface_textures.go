@@ -0,0 +1,227 @@
+package compositor
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"image"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// SurfaceTextures is the -surface-textures flag: windows drawn on the
+// model's other meshes instead of the desktop, as a JSON object of glTF
+// node name to app id, e.g. {"TV": "mpv"}
+type SurfaceTextures map[string]string
+
+// String returns the flag's JSON
+func (s SurfaceTextures) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	text, _ := json.Marshal(map[string]string(s))
+	return string(text)
+}
+
+// Set parses the flag's JSON
+func (s *SurfaceTextures) Set(value string) error {
+	var bindings SurfaceTextures
+	if err := json.Unmarshal([]byte(value), &bindings); err != nil {
+		return fmt.Errorf("invalid surface textures: %w", err)
+	}
+	if err := bindings.validate(); err != nil {
+		return err
+	}
+	*s = bindings
+	return nil
+}
+
+// validate reports bindings without a node name or an app id
+func (s SurfaceTextures) validate() error {
+	for node, appID := range s {
+		if node == "" || appID == "" {
+			return fmt.Errorf("invalid surface texture %q: %q, want a node name and an app id", node, appID)
+		}
+	}
+	return nil
+}
+
+// SurfaceCapture takes the windows of the -surface-textures apps off the
+// desktop, each as a TextureSource for its node. The render loop captures
+// them every frame; a captured window keeps its full size and frame
+// callbacks wherever it is on the desktop, even on another workspace.
+type SurfaceCapture struct {
+	mu       sync.Mutex
+	bindings SurfaceTextures
+	seed     maphash.Seed
+	// surfaces are the captured windows by node, kept while the node is
+	// bound so the renderer sees the same source from frame to frame
+	surfaces map[string]*capturedSurface
+}
+
+// NewSurfaceCapture creates a capture of bindings' windows
+func NewSurfaceCapture(bindings SurfaceTextures) *SurfaceCapture {
+	c := &SurfaceCapture{seed: maphash.MakeSeed(), surfaces: make(map[string]*capturedSurface)}
+	c.SetBindings(bindings)
+	return c
+}
+
+// SetBindings replaces the windows captured, by node
+func (c *SurfaceCapture) SetBindings(bindings SurfaceTextures) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bindings = maps.Clone(bindings)
+	for node := range c.surfaces {
+		if _, ok := c.bindings[node]; !ok {
+			delete(c.surfaces, node)
+		}
+	}
+	for node := range c.bindings {
+		if c.surfaces[node] == nil {
+			c.surfaces[node] = &capturedSurface{}
+		}
+	}
+}
+
+// Bindings returns the app ids captured, by node
+func (c *SurfaceCapture) Bindings() SurfaceTextures {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.bindings)
+}
+
+// Capture takes the topmost toplevel of each bound app in placed, bottom
+// first, as its node's frame, and returns the surfaces captured. A nil
+// capture takes nothing.
+func (c *SurfaceCapture) Capture(placed []PlacedSurface) []PlacedSurface {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var captured []PlacedSurface
+	for node, appID := range c.bindings {
+		top := -1
+		for i, p := range placed {
+			if p.Texture != nil && toplevelAppID(p) == appID {
+				top = i
+			}
+		}
+		surface := c.surfaces[node]
+		if top < 0 {
+			surface.texture = nil
+			continue
+		}
+		surface.update(placed[top].Texture, c.seed)
+		captured = append(captured, placed[top])
+	}
+	return captured
+}
+
+// Sources returns each bound node's window, nil for a node whose app has
+// no window
+func (c *SurfaceCapture) Sources() map[string]TextureSource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sources := make(map[string]TextureSource, len(c.surfaces))
+	for node, surface := range c.surfaces {
+		if surface.texture == nil {
+			sources[node] = nil
+			continue
+		}
+		sources[node] = surface
+	}
+	return sources
+}
+
+// SurfaceTextureInfo is a bound node for the control API
+type SurfaceTextureInfo struct {
+	Node   string `json:"node"`
+	AppID  string `json:"app_id"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	// Captured is whether the app has a window to show
+	Captured bool `json:"captured"`
+}
+
+// Info returns the bound nodes in name order
+func (c *SurfaceCapture) Info() []SurfaceTextureInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info := make([]SurfaceTextureInfo, 0, len(c.bindings))
+	for _, node := range slices.Sorted(maps.Keys(c.bindings)) {
+		entry := SurfaceTextureInfo{Node: node, AppID: c.bindings[node]}
+		if texture := c.surfaces[node].texture; texture != nil {
+			entry.Width, entry.Height, entry.Captured = int(texture.Width), int(texture.Height), true
+		}
+		info = append(info, entry)
+	}
+	return info
+}
+
+// withoutSurfaces returns placed without the surfaces in drop
+func withoutSurfaces(placed, drop []PlacedSurface) []PlacedSurface {
+	if len(drop) == 0 {
+		return placed
+	}
+	return slices.DeleteFunc(placed, func(p PlacedSurface) bool {
+		return slices.ContainsFunc(drop, func(d PlacedSurface) bool {
+			return d.Client == p.Client && d.SurfaceID == p.SurfaceID
+		})
+	})
+}
+
+// toplevelAppID is the app id of a toplevel's root surface, empty for
+// other surfaces
+func toplevelAppID(p PlacedSurface) string {
+	if !isToplevelRoot(p) {
+		return ""
+	}
+	role := p.Surface.Role.(*wayland.SurfaceRoleXdgToplevel)
+	if toplevel := wayland.GetXdgToplevelObject(p.Client, *role.Data); toplevel != nil {
+		return toplevel.AppID
+	}
+	return ""
+}
+
+// capturedSurface is a captured window as a TextureSource. Clients write
+// their buffers in place, so new frames are told by the pixels'
+// fingerprint as DesktopDamage does.
+type capturedSurface struct {
+	texture *wayland.Texture
+	sum     uint64
+	damage  uint64
+}
+
+// update takes texture as the window's frame
+func (s *capturedSurface) update(texture *wayland.Texture, seed maphash.Seed) {
+	sum := maphash.Bytes(seed, texture.Data)
+	if texture != s.texture || sum != s.sum {
+		s.damage++
+	}
+	s.texture, s.sum = texture, sum
+}
+
+// Frame returns the window's buffer
+func (s *capturedSurface) Frame() ([]byte, int) {
+	if s.texture == nil {
+		return nil, 0
+	}
+	return s.texture.Data, int(s.texture.Stride)
+}
+
+// Size returns the window's buffer size
+func (s *capturedSurface) Size() image.Point {
+	if s.texture == nil {
+		return image.Point{}
+	}
+	return image.Pt(int(s.texture.Width), int(s.texture.Height))
+}
+
+// Damage counts the window's new frames
+func (s *capturedSurface) Damage() uint64 {
+	return s.damage
+}
@@ -0,0 +1,80 @@
+package compositor
+
+import (
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+func TestSurfaceTexturesFlag(t *testing.T) {
+	var s SurfaceTextures
+	if err := s.Set(`{"TV": "mpv"}`); err != nil || s["TV"] != "mpv" {
+		t.Fatalf("Expected TV bound to mpv, got %v, %v", s, err)
+	}
+	if got := s.String(); got != `{"TV":"mpv"}` {
+		t.Errorf("Expected the flag's JSON back, got %s", got)
+	}
+	for _, bad := range []string{`["TV"]`, `{"TV": ""}`, `{"": "mpv"}`} {
+		if err := s.Set(bad); err == nil {
+			t.Errorf("Expected %s to be refused", bad)
+		}
+	}
+}
+
+// appWindow places a toplevel of c with app id appID
+func appWindow(c *wayland.Client, surfaceID, toplevelID uint32, appID string) PlacedSurface {
+	toplevel := protocols.ObjectID[protocols.XdgToplevel](toplevelID)
+	wayland.AddObject(c, toplevel, &protocols.XdgToplevel{Delegate: &wayland.XdgToplevel{AppID: appID}})
+	id := protocols.ObjectID[protocols.WlSurface](surfaceID)
+	return PlacedSurface{
+		Client:    c,
+		SurfaceID: id,
+		Root:      id,
+		Surface:   &wayland.WlSurface{Role: &wayland.SurfaceRoleXdgToplevel{Data: &toplevel}},
+		Texture:   &wayland.Texture{Width: 2, Height: 2, Stride: 8, Data: make([]byte, 2*8)},
+	}
+}
+
+func TestSurfaceCapture(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	browser := appWindow(c, 10, 20, "firefox")
+	video := appWindow(c, 11, 21, "mpv")
+	popup := appWindow(c, 12, 22, "mpv")
+	placed := []PlacedSurface{browser, video, popup}
+
+	capture := NewSurfaceCapture(SurfaceTextures{"TV": "mpv", "Radio": "rhythmbox"})
+	captured := capture.Capture(placed)
+	if len(captured) != 1 || captured[0].SurfaceID != popup.SurfaceID {
+		t.Fatalf("Expected mpv's top window captured, got %+v", captured)
+	}
+	sources := capture.Sources()
+	if sources["Radio"] != nil {
+		t.Error("Expected no source for an app without a window")
+	}
+	tv := sources["TV"]
+	if tv == nil || tv.Size().X != 2 {
+		t.Fatalf("Expected mpv's window as the TV's source, got %v", tv)
+	}
+	damage := tv.Damage()
+	capture.Capture(placed)
+	if tv.Damage() != damage {
+		t.Error("Expected no new frame while the window's pixels hold still")
+	}
+	popup.Texture.Data[0] = 255
+	capture.Capture(placed)
+	if tv.Damage() == damage {
+		t.Error("Expected a new frame when the window drew")
+	}
+
+	// Captured windows are not hidden
+	hidden := withoutSurfaces([]PlacedSurface{browser, popup}, captured)
+	if len(hidden) != 1 || hidden[0].SurfaceID != browser.SurfaceID {
+		t.Errorf("Expected the captured window out of the hidden ones, got %+v", hidden)
+	}
+
+	capture.SetBindings(nil)
+	if len(capture.Capture(placed)) != 0 || len(capture.Sources()) != 0 {
+		t.Error("Expected nothing captured without bindings")
+	}
+}
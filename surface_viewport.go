@@ -0,0 +1,42 @@
+package compositor
+
+import (
+	"image"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// surfaceViewport works out which part of a surface's buffer is shown and
+// how big the surface is on the desktop, following wl_surface.set_buffer_scale
+// and wp_viewport rules. crop is the wp_viewport source rectangle in
+// surface-local coordinates and size its destination size; either may be
+// empty when unset. Buffer transforms other than normal are not applied.
+func surfaceViewport(bufferSize image.Point, scale int32, crop image.Rectangle, size image.Point) (source image.Rectangle, dst image.Point) {
+	if scale < 1 {
+		scale = 1
+	}
+	s := int(scale)
+
+	source = image.Rectangle{Max: bufferSize}
+	if !crop.Empty() {
+		source = image.Rect(crop.Min.X*s, crop.Min.Y*s, crop.Max.X*s, crop.Max.Y*s).Intersect(source)
+	}
+
+	switch {
+	case size.X > 0 && size.Y > 0:
+		dst = size
+	case !crop.Empty():
+		dst = crop.Size()
+	default:
+		dst = image.Pt(max(bufferSize.X/s, 1), max(bufferSize.Y/s, 1))
+	}
+	return source, dst
+}
+
+// placedViewport returns the viewport of a surface's current buffer. The
+// wayland package does not expose wp_viewporter yet, so only the buffer
+// scale is taken into account.
+func placedViewport(surface *wayland.WlSurface) (image.Rectangle, image.Point) {
+	bufferSize := image.Pt(int(surface.Texture.Width), int(surface.Texture.Height))
+	return surfaceViewport(bufferSize, surface.BufferScale, image.Rectangle{}, image.Point{})
+}
@@ -0,0 +1,65 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestSurfaceViewport(t *testing.T) {
+	buffer := image.Pt(200, 100)
+	tests := []struct {
+		name   string
+		scale  int32
+		crop   image.Rectangle
+		size   image.Point
+		source image.Rectangle
+		dst    image.Point
+	}{
+		{"plain", 1, image.Rectangle{}, image.Point{}, image.Rect(0, 0, 200, 100), image.Pt(200, 100)},
+		{"hidpi", 2, image.Rectangle{}, image.Point{}, image.Rect(0, 0, 200, 100), image.Pt(100, 50)},
+		{"unset scale", 0, image.Rectangle{}, image.Point{}, image.Rect(0, 0, 200, 100), image.Pt(200, 100)},
+		{"crop", 2, image.Rect(10, 10, 50, 30), image.Point{}, image.Rect(20, 20, 100, 60), image.Pt(40, 20)},
+		{"destination", 1, image.Rectangle{}, image.Pt(400, 300), image.Rect(0, 0, 200, 100), image.Pt(400, 300)},
+		{"crop past buffer", 1, image.Rect(150, 0, 300, 100), image.Pt(50, 50), image.Rect(150, 0, 200, 100), image.Pt(50, 50)},
+	}
+	for _, tt := range tests {
+		source, dst := surfaceViewport(buffer, tt.scale, tt.crop, tt.size)
+		if source != tt.source || dst != tt.dst {
+			t.Errorf("%s: expected %v -> %v, got %v -> %v", tt.name, tt.source, tt.dst, source, dst)
+		}
+	}
+}
+
+func TestCollectSurfacesBufferScale(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	addTestSurface(c, 1, 0, 0, 0, 200, 100).BufferScale = 2
+
+	placed := NewSurfaceSnapshots().Collect([]*wayland.Client{c}, image.Rect(0, 0, 800, 600))
+	if len(placed) != 1 {
+		t.Fatalf("Expected 1 surface, got %d", len(placed))
+	}
+	if placed[0].Width() != 100 || placed[0].Height() != 50 || !placed[0].Scaled() {
+		t.Errorf("Scale 2 buffer should be drawn at half size, got %dx%d", placed[0].Width(), placed[0].Height())
+	}
+}
+
+func TestDrawScaled(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.Pix[src.PixOffset(1, 1)+3] = 0xff
+	dst := image.NewRGBA(image.Rect(0, 0, 4, 4))
+
+	drawScaled(dst, dst.Bounds(), src, src.Bounds())
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			want := uint8(0)
+			if x >= 2 && y >= 2 {
+				want = 0xff
+			}
+			if got := dst.Pix[dst.PixOffset(x, y)+3]; got != want {
+				t.Errorf("Alpha at (%d, %d): expected %d, got %d", x, y, want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package compositor
+
+import "time"
+
+// suspendThreshold is how far the wall clock has to run ahead of the
+// monotonic clock between two checks to count as a suspend
+const suspendThreshold = 2 * time.Second
+
+// SuspendDetector notices that the host was suspended. There is no D-Bus
+// package among the dependencies for logind's PrepareForSleep, and SDL
+// reports no sleep on desktops, but Go's monotonic clock stops while the
+// host sleeps and the wall clock does not, so the gap shows up after the
+// wake. Setting the clock forward looks the same.
+type SuspendDetector struct {
+	start time.Time
+	// wall and monotonic are the clocks at the last check
+	wall      time.Time
+	monotonic time.Duration
+	checked   bool
+}
+
+// NewSuspendDetector starts measuring from now
+func NewSuspendDetector(now time.Time) *SuspendDetector {
+	return &SuspendDetector{start: now}
+}
+
+// Check reports how long the host slept since the last check, if it did.
+// Call it every tick.
+func (d *SuspendDetector) Check(now time.Time) (time.Duration, bool) {
+	return d.Observe(now.Round(0), now.Sub(d.start))
+}
+
+// Observe takes the wall clock and the monotonic time since start and
+// reports how much further the wall clock moved since the last call
+func (d *SuspendDetector) Observe(wall time.Time, monotonic time.Duration) (time.Duration, bool) {
+	defer func() {
+		d.wall, d.monotonic, d.checked = wall, monotonic, true
+	}()
+	if !d.checked {
+		return 0, false
+	}
+	gap := wall.Sub(d.wall) - (monotonic - d.monotonic)
+	return gap, gap > suspendThreshold
+}
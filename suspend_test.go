@@ -0,0 +1,32 @@
+package compositor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSuspendDetector(t *testing.T) {
+	wall := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var d SuspendDetector
+	steps := []struct {
+		wall, monotonic time.Duration
+		slept           bool
+	}{
+		{0, 0, false},
+		{time.Second, time.Second, false},
+		// NTP nudging the clock is not a suspend
+		{2*time.Second + 300*time.Millisecond, 2 * time.Second, false},
+		// An hour asleep, then the first tick after waking
+		{time.Hour + 3*time.Second, 3 * time.Second, true},
+		{time.Hour + 4*time.Second, 4 * time.Second, false},
+	}
+	for i, step := range steps {
+		gap, slept := d.Observe(wall.Add(step.wall), step.monotonic)
+		if slept != step.slept {
+			t.Errorf("step %d: slept %v (gap %v), want %v", i, slept, gap, step.slept)
+		}
+		if slept && gap.Round(time.Second) != time.Hour {
+			t.Errorf("step %d: gap %v", i, gap)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package compositor
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+	"time"
+)
+
+// Opcodes of the requests and events testClient uses
+const (
+	wlRegistryBind          = 0
+	wlRegistryGlobal        = 0
+	wlCompositorCreate      = 0
+	wlShmCreatePool         = 0
+	wlShmPoolCreateBuffer   = 0
+	wlShmPoolDestroy        = 1
+	wlSurfaceAttach         = 1
+	wlSurfaceFrame          = 3
+	wlSurfaceCommit         = 6
+	wlSurfaceDamageBuffer   = 9
+	wlSeatGetKeyboard       = 1
+	wlKeyboardKey           = 3
+	wlCallbackDone          = 0
+	xdgWmBaseGetXdgSurface  = 2
+	xdgWmBasePong           = 3
+	xdgWmBasePing           = 0
+	xdgSurfaceGetToplevel   = 1
+	xdgSurfaceAckConfigure  = 4
+	xdgSurfaceConfigure     = 0
+	xdgToplevelSetAppID     = 3
+	zxdgGetToplevelDecor    = 1
+	zxdgDecorationSetMode   = 1
+	zxdgDecorationConfigure = 0
+	wlShmFormatARGB8888     = 0
+	testClientEventsTimeout = 5 * time.Second
+)
+
+// testClient is a synthetic Wayland client for integration tests. Like a
+// real app it binds the globals, opens a toplevel, draws into shared
+// memory and commits, and it keeps the events tests look at: frame
+// callbacks that are done and keys its keyboard got.
+type testClient struct {
+	t *testing.T
+	*wlConn
+	// globals are the registry's globals by interface, name then version
+	globals  map[string][2]uint32
+	registry uint32
+
+	compositor, shm, wmBase, seat uint32
+	surface, xdgSurface, toplevel uint32
+	keyboard                      uint32
+	configured                    bool
+	// decoration is the toplevel's xdg-decoration object and
+	// decorationMode the mode it was last configured with
+	decoration, decorationMode uint32
+
+	done map[uint32]bool
+	keys []testKey
+}
+
+// testKey is a key a testClient's keyboard got
+type testKey struct {
+	Keycode uint32
+	Pressed bool
+}
+
+// dialTestClient connects a testClient to display and binds the globals
+// it uses
+func dialTestClient(t *testing.T, display string) *testClient {
+	t.Helper()
+	conn, err := dialWayland(display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, wlConn: conn, globals: make(map[string][2]uint32), done: make(map[uint32]bool)}
+	t.Cleanup(func() { c.Close() })
+
+	c.registry = c.newID()
+	c.send(wlDisplayID, 1, wlArgs{}.uint32(c.registry))
+	c.sync(func(e wlEvent) {
+		if e.Object == c.registry && e.Opcode == wlRegistryGlobal {
+			name, iface, version := e.Args.uint32(), e.Args.string(), e.Args.uint32()
+			c.globals[iface] = [2]uint32{name, version}
+		}
+	})
+	c.compositor = c.bind("wl_compositor", 4)
+	c.shm = c.bind("wl_shm", 1)
+	c.wmBase = c.bind("xdg_wm_base", 1)
+	c.seat = c.bind("wl_seat", 5)
+	c.sync(nil)
+	return c
+}
+
+// bind binds a global at up to version, returning its object
+func (c *testClient) bind(iface string, version uint32) uint32 {
+	c.t.Helper()
+	global, ok := c.globals[iface]
+	if !ok {
+		c.t.Fatalf("The compositor has no %s global", iface)
+	}
+	id := c.newID()
+	c.send(c.registry, wlRegistryBind, wlArgs{}.uint32(global[0]).string(iface).uint32(min(version, global[1])).uint32(id))
+	return id
+}
+
+// send sends a request, failing the test if it cannot
+func (c *testClient) send(object uint32, opcode uint16, args wlArgs, fds ...int) {
+	c.t.Helper()
+	if err := c.request(object, opcode, args, fds...); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// sync waits for the compositor to handle every request sent so far,
+// passing events testClient does not handle itself to handle
+func (c *testClient) sync(handle func(wlEvent)) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(testClientEventsTimeout))
+	if err := c.roundtrip(func(e wlEvent) {
+		if !c.dispatch(e) && handle != nil {
+			handle(e)
+		}
+	}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// dispatch handles the events every client has to answer or tests look
+// at, reporting whether e was one of them
+func (c *testClient) dispatch(e wlEvent) bool {
+	switch {
+	case e.Object == c.wmBase && e.Opcode == xdgWmBasePing:
+		c.request(c.wmBase, xdgWmBasePong, wlArgs{}.uint32(e.Args.uint32()))
+	case e.Object == c.xdgSurface && e.Opcode == xdgSurfaceConfigure:
+		c.request(c.xdgSurface, xdgSurfaceAckConfigure, wlArgs{}.uint32(e.Args.uint32()))
+		c.configured = true
+	case e.Object == c.decoration && e.Opcode == zxdgDecorationConfigure:
+		c.decorationMode = e.Args.uint32()
+	case e.Object == c.keyboard && e.Opcode == wlKeyboardKey:
+		e.Args.uint32()
+		e.Args.uint32()
+		key, state := e.Args.uint32(), e.Args.uint32()
+		c.keys = append(c.keys, testKey{Keycode: key, Pressed: state == 1})
+	default:
+		if _, ok := c.done[e.Object]; ok && e.Opcode == wlCallbackDone {
+			c.done[e.Object] = true
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// openWindow creates an xdg_toplevel with appID and waits for its first
+// configure
+func (c *testClient) openWindow(appID string) {
+	c.t.Helper()
+	c.surface = c.newID()
+	c.send(c.compositor, wlCompositorCreate, wlArgs{}.uint32(c.surface))
+	c.xdgSurface = c.newID()
+	c.send(c.wmBase, xdgWmBaseGetXdgSurface, wlArgs{}.uint32(c.xdgSurface).uint32(c.surface))
+	c.toplevel = c.newID()
+	c.send(c.xdgSurface, xdgSurfaceGetToplevel, wlArgs{}.uint32(c.toplevel))
+	c.send(c.toplevel, xdgToplevelSetAppID, wlArgs{}.string(appID))
+	c.send(c.surface, wlSurfaceCommit, nil)
+	for !c.configured {
+		c.sync(nil)
+	}
+}
+
+// draw fills a width by height buffer with pattern and commits it to the
+// window
+func (c *testClient) draw(width, height int, pattern func(x, y int) color.RGBA) {
+	c.t.Helper()
+	stride := width * 4
+	pixels := make([]byte, stride*height)
+	for y := range height {
+		for x := range width {
+			// ARGB8888 is little endian, so blue comes first
+			p := pattern(x, y)
+			copy(pixels[y*stride+x*4:], []byte{p.B, p.G, p.R, p.A})
+		}
+	}
+	file, err := os.CreateTemp(c.t.TempDir(), "shm")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(pixels); err != nil {
+		c.t.Fatal(err)
+	}
+
+	pool := c.newID()
+	c.send(c.shm, wlShmCreatePool, wlArgs{}.uint32(pool).int32(int32(len(pixels))), int(file.Fd()))
+	buffer := c.newID()
+	c.send(pool, wlShmPoolCreateBuffer, wlArgs{}.uint32(buffer).int32(0).int32(int32(width)).int32(int32(height)).int32(int32(stride)).uint32(wlShmFormatARGB8888))
+	c.send(pool, wlShmPoolDestroy, nil)
+	c.send(c.surface, wlSurfaceAttach, wlArgs{}.uint32(buffer).int32(0).int32(0))
+	c.send(c.surface, wlSurfaceDamageBuffer, wlArgs{}.int32(0).int32(0).int32(int32(width)).int32(int32(height)))
+	c.send(c.surface, wlSurfaceCommit, nil)
+	c.sync(nil)
+}
+
+// requestFrame asks for a frame callback on the next commit, returning the
+// callback
+func (c *testClient) requestFrame() uint32 {
+	c.t.Helper()
+	callback := c.newID()
+	c.done[callback] = false
+	c.send(c.surface, wlSurfaceFrame, wlArgs{}.uint32(callback))
+	return callback
+}
+
+// waitFrame waits up to timeout for callback to be done, reporting
+// whether it was
+func (c *testClient) waitFrame(callback uint32, timeout time.Duration) bool {
+	c.t.Helper()
+	return c.waitFor(timeout, func() bool { return c.done[callback] })
+}
+
+// decorate gets the window's xdg-decoration object, returning the mode
+// the compositor first configures
+func (c *testClient) decorate() uint32 {
+	c.t.Helper()
+	manager := c.bind("zxdg_decoration_manager_v1", 1)
+	c.decoration = c.newID()
+	c.send(manager, zxdgGetToplevelDecor, wlArgs{}.uint32(c.decoration).uint32(c.toplevel))
+	c.sync(nil)
+	return c.decorationMode
+}
+
+// setDecorationMode asks for a decoration mode, returning the one the
+// compositor configures
+func (c *testClient) setDecorationMode(mode uint32) uint32 {
+	c.t.Helper()
+	c.send(c.decoration, zxdgDecorationSetMode, wlArgs{}.uint32(mode))
+	c.sync(nil)
+	return c.decorationMode
+}
+
+// getKeyboard binds the seat's keyboard so the client gets keys
+func (c *testClient) getKeyboard() {
+	c.t.Helper()
+	c.keyboard = c.newID()
+	c.send(c.seat, wlSeatGetKeyboard, wlArgs{}.uint32(c.keyboard))
+	c.sync(nil)
+}
+
+// waitFor reads events for up to timeout until ok holds, reporting whether
+// it did
+func (c *testClient) waitFor(timeout time.Duration, ok func() bool) bool {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	for !ok() {
+		e, err := c.next()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return false
+		}
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		c.dispatch(e)
+	}
+	return true
+}
+
+// testPattern is a pattern that tells where each pixel came from: red
+// across, green down and blue in the top left quarter
+func testPattern(width, height int) func(x, y int) color.RGBA {
+	return func(x, y int) color.RGBA {
+		p := color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), A: 0xff}
+		if x < width/2 && y < height/2 {
+			p.B = 0xff
+		}
+		return p
+	}
+}
+
+// checkPattern fails the test where img, at offset, differs from the
+// pattern as the compositor draws it. Client buffers are composited byte
+// for byte, so an ARGB8888 pixel lands on the RGBA desktop with its red
+// and blue swapped.
+func checkPattern(t *testing.T, img *image.RGBA, at image.Point, width, height int, pattern func(x, y int) color.RGBA) {
+	t.Helper()
+	for y := range height {
+		for x := range width {
+			p := pattern(x, y)
+			if got, want := img.RGBAAt(at.X+x, at.Y+y), (color.RGBA{R: p.B, G: p.G, B: p.R, A: p.A}); got != want {
+				t.Fatalf("Pixel %d,%d: expected %v, got %v", x, y, want, got)
+			}
+		}
+	}
+}
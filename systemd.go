@@ -0,0 +1,185 @@
+package compositor
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// listenFDsStart is the first file descriptor systemd passes sockets in
+const listenFDsStart = 3
+
+// SystemdSockets are the sockets systemd passed for socket activation.
+// Socket units name them with FileDescriptorName=http and =wayland;
+// otherwise the first is HTTP and the second Wayland.
+type SystemdSockets struct {
+	HTTP    net.Listener
+	Wayland *net.UnixListener
+}
+
+// systemdSockets takes the sockets systemd passed in LISTEN_FDS, if they
+// are for this process, and clears the variables so the apps we launch do
+// not take them too. It returns no sockets when not socket activated.
+func systemdSockets() (SystemdSockets, error) {
+	var sockets SystemdSockets
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return sockets, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return sockets, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := range count {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		// Sockets not named http or wayland, which systemd names after
+		// their unit, go in order
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		if name != "http" && name != "wayland" {
+			switch i {
+			case 0:
+				name = "http"
+			case 1:
+				name = "wayland"
+			}
+		}
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return sockets, fmt.Errorf("socket %d (%s): %w", fd, name, err)
+		}
+		switch name {
+		case "http":
+			sockets.HTTP = listener
+		case "wayland":
+			unix, ok := listener.(*net.UnixListener)
+			if !ok {
+				listener.Close()
+				return sockets, fmt.Errorf("the wayland socket is %s, not a unix socket", listener.Addr().Network())
+			}
+			sockets.Wayland = unix
+		default:
+			log.Printf("Ignoring socket %d (%q) from systemd", fd, name)
+			listener.Close()
+		}
+	}
+	return sockets, nil
+}
+
+// systemdWaylandListener wraps a Wayland socket systemd passed. Its file is
+// left in place when we exit, as the socket unit owns it.
+func systemdWaylandListener(listener *net.UnixListener) *wayland.SocketListener {
+	path := listener.Addr().String()
+	display := path
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && filepath.Dir(path) == filepath.Clean(dir) {
+		display = filepath.Base(path)
+	}
+	return &wayland.SocketListener{
+		WaylandDisplayName: display,
+		Listener:           listener,
+		OnConnection:       make(chan *net.UnixConn, 32),
+	}
+}
+
+// SystemdNotifier tells systemd when the compositor is serving, is
+// stopping, and that its render loop is still running, for Type=notify
+// units with WatchdogSec=. Without NOTIFY_SOCKET it does nothing.
+type SystemdNotifier struct {
+	conn *net.UnixConn
+	// watchdog is how often to ping, half systemd's timeout, or zero for
+	// no watchdog
+	watchdog time.Duration
+	pinged   time.Time
+}
+
+// NewSystemdNotifier connects to NOTIFY_SOCKET, and clears it and the
+// watchdog variables so the apps we launch do not notify in our name
+func NewSystemdNotifier() *SystemdNotifier {
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	n := &SystemdNotifier{}
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return n
+	}
+	// An abstract socket starts with @
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		log.Printf("Failed to connect to systemd's notify socket: %v", err)
+		return n
+	}
+	n.conn = conn
+	n.watchdog = watchdogInterval(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID"), os.Getpid())
+	return n
+}
+
+// watchdogInterval is how often to ping a watchdog of usec microseconds,
+// or zero when there is none for pid
+func watchdogInterval(usec, watchdogPID string, pid int) time.Duration {
+	if watchdogPID != "" {
+		if p, err := strconv.Atoi(watchdogPID); err != nil || p != pid {
+			return 0
+		}
+	}
+	timeout, err := strconv.ParseUint(usec, 10, 63)
+	if err != nil || timeout == 0 {
+		return 0
+	}
+	return time.Duration(timeout) * time.Microsecond / 2
+}
+
+// Ready reports that the compositor is serving
+func (n *SystemdNotifier) Ready() {
+	n.notify("READY=1")
+}
+
+// Stopping reports that the compositor is shutting down
+func (n *SystemdNotifier) Stopping() {
+	n.notify("STOPPING=1")
+}
+
+// Watchdog pings systemd's watchdog when it is due. Call it from the
+// render loop, so a stuck loop gets the service restarted.
+func (n *SystemdNotifier) Watchdog(now time.Time) {
+	if n.watchdog == 0 || now.Sub(n.pinged) < n.watchdog {
+		return
+	}
+	n.pinged = now
+	n.notify("WATCHDOG=1")
+}
+
+// Close disconnects from the notify socket
+func (n *SystemdNotifier) Close() {
+	if n.conn != nil {
+		n.conn.Close()
+	}
+}
+
+func (n *SystemdNotifier) notify(state string) {
+	if n.conn == nil {
+		return
+	}
+	if _, err := n.conn.Write([]byte(state)); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package compositor
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSystemdSocketsForAnotherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "2")
+	sockets, err := systemdSockets()
+	if err != nil || sockets.HTTP != nil || sockets.Wayland != nil {
+		t.Errorf("sockets for another process = %+v, %v, want none", sockets, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("LISTEN_FDS should be cleared for the apps we launch")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	for _, c := range []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"10000000", "", 5 * time.Second},
+		{"10000000", "42", 5 * time.Second},
+		{"10000000", "43", 0},
+		{"", "", 0},
+		{"0", "", 0},
+		{"soon", "", 0},
+	} {
+		if got := watchdogInterval(c.usec, c.pid, 42); got != c.want {
+			t.Errorf("watchdogInterval(%q, %q) = %v, want %v", c.usec, c.pid, got, c.want)
+		}
+	}
+}
+
+func TestSystemdNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify")
+	socket, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	notifier := NewSystemdNotifier()
+	defer notifier.Close()
+	if os.Getenv("NOTIFY_SOCKET") != "" {
+		t.Error("NOTIFY_SOCKET should be cleared for the apps we launch")
+	}
+	now := time.Now()
+	notifier.Ready()
+	notifier.Watchdog(now)
+	notifier.Watchdog(now.Add(500 * time.Millisecond))
+	notifier.Watchdog(now.Add(time.Second))
+	notifier.Stopping()
+
+	socket.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 64)
+	for _, want := range []string{"READY=1", "WATCHDOG=1", "WATCHDOG=1", "STOPPING=1"} {
+		n, err := socket.Read(buffer)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", want, err)
+		}
+		if got := string(buffer[:n]); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSystemdNotifierWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	notifier := NewSystemdNotifier()
+	notifier.Ready()
+	notifier.Watchdog(time.Now())
+	notifier.Close()
+}
+
+func TestSystemdWaylandListener(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	socket, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "wayland-7"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	if listener := systemdWaylandListener(socket); listener.WaylandDisplayName != "wayland-7" {
+		t.Errorf("display in the runtime dir is %q, want wayland-7", listener.WaylandDisplayName)
+	}
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	if listener := systemdWaylandListener(socket); listener.WaylandDisplayName != socket.Addr().String() {
+		t.Errorf("display elsewhere is %q, want its path", listener.WaylandDisplayName)
+	}
+}
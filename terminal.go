@@ -0,0 +1,519 @@
+package compositor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// terminalResizeCheck is how often the terminal sink draws the last frame
+// again, which is when it notices the terminal was resized
+const terminalResizeCheck = 250 * time.Millisecond
+
+// terminalScroll is how far one wheel notch in the terminal scrolls, the
+// same as the preview window's
+const terminalScroll = 15
+
+// Linux input keycodes of the keys a terminal sends as escape sequences
+const (
+	keyEsc       = 1
+	keyBackspace = 14
+	keyLeftAlt   = 56
+	keyHome      = 102
+	keyUp        = 103
+	keyPageUp    = 104
+	keyEnd       = 107
+	keyDown      = 108
+	keyPageDown  = 109
+	keyInsert    = 110
+	keyDelete    = 111
+)
+
+// terminalFinalKeys are the keys of CSI and SS3 sequences by their final
+// byte, e.g. ESC [ A for Up or ESC O P for F1
+var terminalFinalKeys = map[byte]uint32{
+	'A': keyUp, 'B': keyDown, 'C': keyRight, 'D': keyLeft,
+	'H': keyHome, 'F': keyEnd,
+	'P': 59, 'Q': 60, 'R': 61, 'S': 62,
+}
+
+// terminalTildeKeys are the keys of ESC [ <n> ~ sequences by n
+var terminalTildeKeys = map[int]uint32{
+	1: keyHome, 2: keyInsert, 3: keyDelete, 4: keyEnd, 5: keyPageUp, 6: keyPageDown,
+	7: keyHome, 8: keyEnd,
+	11: 59, 12: 60, 13: 61, 14: 62,
+	15: 63, 17: 64, 18: 65, 19: 66, 20: 67, 21: 68, 23: 87, 24: 88,
+}
+
+// TerminalSink draws the desktop into the terminal the compositor runs in,
+// two pixels per character cell with the upper half block in 24-bit
+// color, and forwards the terminal's keys and mouse to the clients, so the
+// desktop can be used over SSH. Only the cells that changed are drawn
+// again.
+type TerminalSink struct {
+	*stream.FrameSink
+	writer *terminalWriter
+	saved  syscall.Termios
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewTerminalSink switches the terminal on stdin and stdout to raw mode
+// and the alternate screen, and sends what is typed and clicked in it to
+// inject as viewer input messages. Ctrl+C still quits. Close puts the
+// terminal back.
+func NewTerminalSink(inject func(message []byte)) (*TerminalSink, error) {
+	stdin := int(os.Stdin.Fd())
+	var saved syscall.Termios
+	if err := ioctl(stdin, syscall.TCGETS, unsafe.Pointer(&saved)); err != nil {
+		return nil, fmt.Errorf("stdin is not a terminal: %w", err)
+	}
+	raw := saved
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON | syscall.INLCR | syscall.ISTRIP
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.IEXTEN
+	// Reads return after a tenth of a second without input, so the reader
+	// notices Close and a lone Escape is not kept waiting for the rest of
+	// a sequence
+	raw.Cc[syscall.VMIN] = 0
+	raw.Cc[syscall.VTIME] = 1
+	if err := ioctl(stdin, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, fmt.Errorf("set raw mode: %w", err)
+	}
+
+	writer := &terminalWriter{out: bufio.NewWriter(os.Stdout), size: terminalSize}
+	s := &TerminalSink{
+		FrameSink: stream.NewFrameSink("terminal", writer, terminalResizeCheck),
+		writer:    writer,
+		saved:     saved,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	// Alternate screen, no cursor, and mouse presses, drags and moves
+	// reported with SGR coordinates
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l\x1b[?1003h\x1b[?1006h\x1b[2J")
+	go s.readInput(inject)
+	return s, nil
+}
+
+// readInput turns what the terminal sends into input messages until Close
+func (s *TerminalSink) readInput(inject func(message []byte)) {
+	defer close(s.done)
+	buf := make([]byte, 4096)
+	var pending []byte
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		n, err := os.Stdin.Read(buf)
+		if err != nil && err != io.EOF {
+			return
+		}
+		pending = append(pending, buf[:n]...)
+		var messages [][]byte
+		messages, pending = parseTerminalInput(pending, s.writer.Layout(), n == 0)
+		for _, message := range messages {
+			inject(message)
+		}
+	}
+}
+
+// Close stops drawing and reading input and puts the terminal back the
+// way it was
+func (s *TerminalSink) Close() error {
+	err := s.FrameSink.Close()
+	close(s.stop)
+	<-s.done
+	os.Stdout.WriteString("\x1b[?1006l\x1b[?1003l\x1b[0m\x1b[?25h\x1b[?1049l")
+	if restoreErr := ioctl(int(os.Stdin.Fd()), syscall.TCSETS, unsafe.Pointer(&s.saved)); err == nil {
+		err = restoreErr
+	}
+	return err
+}
+
+// ioctl runs an ioctl request on fd with arg
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// terminalSize returns the size of the terminal on stdout in cells
+func terminalSize() (cols, rows int) {
+	var ws struct{ Row, Col, Xpixel, Ypixel uint16 }
+	if err := ioctl(int(os.Stdout.Fd()), syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 80, 24
+	}
+	return int(ws.Col), int(ws.Row)
+}
+
+// terminalLayout is where the desktop is drawn in the terminal. The
+// desktop is fitted, keeping its aspect ratio, into a grid of cols by
+// rows*2 pixels, one per half cell.
+type terminalLayout struct {
+	cols, rows int
+	desktop    image.Point
+	// origin and size are the drawn desktop's place in half cell pixels
+	origin, size image.Point
+}
+
+// fitTerminal lays out a desktop of the given size in a terminal of cols
+// by rows cells
+func fitTerminal(desktop image.Point, cols, rows int) terminalLayout {
+	l := terminalLayout{cols: cols, rows: rows, desktop: desktop}
+	if desktop.X <= 0 || desktop.Y <= 0 || cols <= 0 || rows <= 0 {
+		return l
+	}
+	scale := min(float64(cols)/float64(desktop.X), float64(rows*2)/float64(desktop.Y))
+	l.size = image.Pt(max(1, int(float64(desktop.X)*scale)), max(1, int(float64(desktop.Y)*scale)))
+	l.origin = image.Pt((cols-l.size.X)/2, (rows*2-l.size.Y)/2)
+	return l
+}
+
+// desktopPoint returns the desktop position under the middle of a cell,
+// counted from 0, clamped to the desktop
+func (l terminalLayout) desktopPoint(col, row int) (x, y float32) {
+	if l.size.X == 0 || l.size.Y == 0 {
+		return 0, 0
+	}
+	x = (float32(col-l.origin.X) + 0.5) * float32(l.desktop.X) / float32(l.size.X)
+	y = (float32(row*2-l.origin.Y) + 1) * float32(l.desktop.Y) / float32(l.size.Y)
+	return min(max(x, 0), float32(l.desktop.X-1)), min(max(y, 0), float32(l.desktop.Y-1))
+}
+
+// terminalCell is the color of a cell's upper and lower half
+type terminalCell struct {
+	top, bottom [3]byte
+}
+
+// terminalWriter draws frames as half block cells, writing escape
+// sequences only for the cells that differ from the last frame drawn
+type terminalWriter struct {
+	out  *bufio.Writer
+	size func() (cols, rows int)
+
+	mu     sync.Mutex
+	layout terminalLayout
+	cells  []terminalCell
+}
+
+// Layout returns where the last frame was drawn
+func (w *terminalWriter) Layout() terminalLayout {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.layout
+}
+
+func (w *terminalWriter) WriteFrame(frame stream.SinkFrame) error {
+	cols, rows := w.size()
+	layout := fitTerminal(frame.Size, cols, rows)
+	cells := terminalCells(frame, layout)
+
+	w.mu.Lock()
+	redraw := layout != w.layout
+	previous := w.cells
+	w.layout, w.cells = layout, cells
+	w.mu.Unlock()
+
+	if redraw {
+		w.out.WriteString("\x1b[0m\x1b[2J")
+	}
+	// Colors and the cursor are tracked so each changed cell costs only
+	// what differs from the one drawn before it
+	var fg, bg [3]byte
+	colorsSet, cursorAt := false, -1
+	for i, cell := range cells {
+		if !redraw && cell == previous[i] {
+			continue
+		}
+		row, col := i/cols, i%cols
+		if cursorAt != i {
+			fmt.Fprintf(w.out, "\x1b[%d;%dH", row+1, col+1)
+		}
+		if !colorsSet || cell.top != fg {
+			fmt.Fprintf(w.out, "\x1b[38;2;%d;%d;%dm", cell.top[0], cell.top[1], cell.top[2])
+		}
+		if !colorsSet || cell.bottom != bg {
+			fmt.Fprintf(w.out, "\x1b[48;2;%d;%d;%dm", cell.bottom[0], cell.bottom[1], cell.bottom[2])
+		}
+		fg, bg, colorsSet = cell.top, cell.bottom, true
+		w.out.WriteString("▀")
+		// The cursor stays on the last column instead of wrapping
+		cursorAt = i + 1
+		if col == cols-1 {
+			cursorAt = -1
+		}
+	}
+	return w.out.Flush()
+}
+
+func (w *terminalWriter) Close() error {
+	return nil
+}
+
+// terminalCells averages the frame's pixels under each half cell of the
+// layout; the cells around the desktop are black
+func terminalCells(frame stream.SinkFrame, l terminalLayout) []terminalCell {
+	cells := make([]terminalCell, l.cols*l.rows)
+	if l.size.X == 0 || l.size.Y == 0 {
+		return cells
+	}
+	for row := range l.rows {
+		for half := range 2 {
+			py := row*2 + half - l.origin.Y
+			if py < 0 || py >= l.size.Y {
+				continue
+			}
+			y0 := py * frame.Size.Y / l.size.Y
+			y1 := max(y0+1, (py+1)*frame.Size.Y/l.size.Y)
+			for col := range l.cols {
+				px := col - l.origin.X
+				if px < 0 || px >= l.size.X {
+					continue
+				}
+				x0 := px * frame.Size.X / l.size.X
+				x1 := max(x0+1, (px+1)*frame.Size.X/l.size.X)
+				color := averageRGB(frame, x0, y0, x1, y1)
+				if half == 0 {
+					cells[row*l.cols+col].top = color
+				} else {
+					cells[row*l.cols+col].bottom = color
+				}
+			}
+		}
+	}
+	return cells
+}
+
+// averageRGB is the average color of the frame's pixels from x0, y0 up to
+// x1, y1
+func averageRGB(frame stream.SinkFrame, x0, y0, x1, y1 int) [3]byte {
+	var sum [3]int
+	for y := y0; y < y1; y++ {
+		row := frame.Pixels[(y*frame.Size.X)*4:]
+		for x := x0; x < x1; x++ {
+			sum[0] += int(row[x*4])
+			sum[1] += int(row[x*4+1])
+			sum[2] += int(row[x*4+2])
+		}
+	}
+	n := (x1 - x0) * (y1 - y0)
+	return [3]byte{byte(sum[0] / n), byte(sum[1] / n), byte(sum[2] / n)}
+}
+
+// parseTerminalInput turns bytes a terminal in raw mode sent into viewer
+// input messages, with mouse positions mapped through layout. An escape
+// sequence or character cut off at the end is returned in rest to be
+// parsed with the next read, unless flush is set because no more input
+// came, when a lone ESC is the Escape key.
+func parseTerminalInput(buf []byte, layout terminalLayout, flush bool) (messages [][]byte, rest []byte) {
+	var text []byte
+	emit := func(m ...[]byte) {
+		if len(text) > 0 {
+			messages = append(messages, append([]byte{stream.InputText}, text...))
+			text = nil
+		}
+		messages = append(messages, m...)
+	}
+	for len(buf) > 0 {
+		b := buf[0]
+		switch {
+		case b == 0x1b:
+			n, m, complete := parseTerminalEscape(buf, layout)
+			if !complete {
+				if !flush {
+					emit()
+					return messages, buf
+				}
+				n, m = 1, keyTap(keyEsc)
+			}
+			emit(m...)
+			buf = buf[n:]
+			continue
+		case b == '\r' || b == '\n':
+			emit(keyTap(keyEnter)...)
+		case b == '\t':
+			emit(keyTap(keyTab)...)
+		case b == 0x7f || b == 0x08:
+			emit(keyTap(keyBackspace)...)
+		case b == 0:
+			emit(keyTap(keySpace, keyLeftCtrl)...)
+		case b >= 1 && b <= 26:
+			emit(keyTap(usKeys[rune('a'+b-1)].keycode, keyLeftCtrl)...)
+		case b < 0x20:
+		case b < utf8.RuneSelf:
+			text = append(text, b)
+		default:
+			if !utf8.FullRune(buf) && !flush {
+				emit()
+				return messages, buf
+			}
+			r, n := utf8.DecodeRune(buf)
+			if r != utf8.RuneError {
+				text = append(text, buf[:n]...)
+			}
+			buf = buf[n:]
+			continue
+		}
+		buf = buf[1:]
+	}
+	emit()
+	return messages, nil
+}
+
+// parseTerminalEscape parses the escape sequence buf starts with,
+// returning how long it is and its messages, or that it is cut off.
+// Sequences it does not know are skipped.
+func parseTerminalEscape(buf []byte, layout terminalLayout) (n int, messages [][]byte, complete bool) {
+	if len(buf) < 2 {
+		return 0, nil, false
+	}
+	switch buf[1] {
+	case '[':
+		// CSI: parameter bytes, then intermediate bytes, then a final byte
+		end := 2
+		for end < len(buf) && buf[end] >= 0x20 && buf[end] <= 0x3f {
+			end++
+		}
+		if end == len(buf) {
+			return 0, nil, false
+		}
+		params, final := buf[2:end], buf[end]
+		n = end + 1
+		if len(params) > 0 && params[0] == '<' && (final == 'M' || final == 'm') {
+			return n, terminalMouse(params[1:], final == 'M', layout), true
+		}
+		fields := bytes.Split(params, []byte{';'})
+		number := func(i int) int {
+			if i >= len(fields) {
+				return 0
+			}
+			v, _ := strconv.Atoi(string(fields[i]))
+			return v
+		}
+		// The second parameter, if there is one, is 1 plus the modifier
+		// bits: 1 Shift, 2 Alt, 4 Control
+		modifiers := terminalModifiers(max(number(1)-1, 0))
+		switch final {
+		case '~':
+			if key, ok := terminalTildeKeys[number(0)]; ok {
+				return n, keyTap(key, modifiers...), true
+			}
+		case 'Z':
+			return n, keyTap(keyTab, keyLeftShift), true
+		default:
+			if key, ok := terminalFinalKeys[final]; ok {
+				return n, keyTap(key, modifiers...), true
+			}
+		}
+		return n, nil, true
+	case 'O':
+		// SS3: F1 to F4, and the arrows in application cursor mode
+		if len(buf) < 3 {
+			return 0, nil, false
+		}
+		if key, ok := terminalFinalKeys[buf[2]]; ok {
+			return 3, keyTap(key), true
+		}
+		return 3, nil, true
+	case 0x1b:
+		return 1, keyTap(keyEsc), true
+	}
+	// ESC before a character is that character typed with Alt held
+	if !utf8.FullRune(buf[1:]) {
+		return 0, nil, false
+	}
+	r, size := utf8.DecodeRune(buf[1:])
+	key, ok := usKeys[r]
+	if !ok {
+		return 1 + size, nil, true
+	}
+	modifiers := []uint32{keyLeftAlt}
+	if key.shift {
+		modifiers = append(modifiers, keyLeftShift)
+	}
+	return 1 + size, keyTap(key.keycode, modifiers...), true
+}
+
+// terminalModifiers returns the modifier keys of an xterm modifier mask
+func terminalModifiers(mask int) []uint32 {
+	var keys []uint32
+	if mask&1 != 0 {
+		keys = append(keys, keyLeftShift)
+	}
+	if mask&2 != 0 {
+		keys = append(keys, keyLeftAlt)
+	}
+	if mask&4 != 0 {
+		keys = append(keys, keyLeftCtrl)
+	}
+	return keys
+}
+
+// terminalMouse turns the parameters of an SGR mouse report, button;col;row,
+// into a pointer motion to the cell and the press, release or scroll
+func terminalMouse(params []byte, pressed bool, layout terminalLayout) [][]byte {
+	fields := bytes.Split(params, []byte{';'})
+	if len(fields) != 3 {
+		return nil
+	}
+	var values [3]int
+	for i, field := range fields {
+		v, err := strconv.Atoi(string(field))
+		if err != nil {
+			return nil
+		}
+		values[i] = v
+	}
+	button, col, row := values[0], values[1]-1, values[2]-1
+	messages := [][]byte{pointerMotionMessage(layout.desktopPoint(col, row))}
+	switch {
+	case button&64 != 0:
+		if !pressed {
+			break
+		}
+		axis, value := protocols.WlPointerAxis_enum_vertical_scroll, float32(terminalScroll)
+		if button&2 != 0 {
+			axis = protocols.WlPointerAxis_enum_horizontal_scroll
+		}
+		if button&1 == 0 {
+			value = -value
+		}
+		messages = append(messages, pointerAxisMessage(axis, value))
+	case button&32 != 0:
+		// Motion, with or without a button held
+	default:
+		code, ok := map[int]uint32{0: 0x110, 1: 0x112, 2: 0x111}[button&3]
+		if ok {
+			messages = append(messages, pointerButtonMessage(code, pressed))
+		}
+	}
+	return messages
+}
+
+// keyTap presses key with modifiers held and releases them again
+func keyTap(key uint32, modifiers ...uint32) [][]byte {
+	var messages [][]byte
+	for _, m := range modifiers {
+		messages = append(messages, keyboardMessage(m, true))
+	}
+	messages = append(messages, keyboardMessage(key, true), keyboardMessage(key, false))
+	for i := len(modifiers) - 1; i >= 0; i-- {
+		messages = append(messages, keyboardMessage(modifiers[i], false))
+	}
+	return messages
+}
@@ -0,0 +1,96 @@
+package compositor
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+func TestFitTerminal(t *testing.T) {
+	// A 4:3 desktop in an 80x24 terminal is 64x48 half cells, centered
+	l := fitTerminal(image.Pt(800, 600), 80, 24)
+	if l.size != image.Pt(64, 48) || l.origin != image.Pt(8, 0) {
+		t.Fatalf("Expected 64x48 at 8,0, got %v at %v", l.size, l.origin)
+	}
+	if x, y := l.desktopPoint(8, 0); x != 6.25 || y != 12.5 {
+		t.Errorf("Expected the first cell at 6.25,12.5, got %v,%v", x, y)
+	}
+	if x, y := l.desktopPoint(0, 30); x != 0 || y != 599 {
+		t.Errorf("Expected a cell outside the desktop clamped, got %v,%v", x, y)
+	}
+}
+
+func TestParseTerminalInput(t *testing.T) {
+	layout := fitTerminal(image.Pt(800, 600), 80, 24)
+	for _, tt := range []struct {
+		input string
+		want  [][]byte
+		rest  string
+	}{
+		{"hi", [][]byte{append([]byte{stream.InputText}, "hi"...)}, ""},
+		{"a\r", slices.Concat([][]byte{{stream.InputText, 'a'}}, keyTap(keyEnter)), ""},
+		{"\x01", keyTap(30, keyLeftCtrl), ""},
+		{"\x7f", keyTap(keyBackspace), ""},
+		{"\x1b[A", keyTap(keyUp), ""},
+		{"\x1b[1;5C", keyTap(keyRight, keyLeftCtrl), ""},
+		{"\x1b[6~", keyTap(keyPageDown), ""},
+		{"\x1bOP", keyTap(59), ""},
+		{"\x1b[24~", keyTap(88), ""},
+		{"\x1bX", keyTap(45, keyLeftAlt, keyLeftShift), ""},
+		{"\x1b[Z", keyTap(keyTab, keyLeftShift), ""},
+		{"\x1b[<0;9;1M", [][]byte{pointerMotionMessage(6.25, 12.5), pointerButtonMessage(0x110, true)}, ""},
+		{"\x1b[<2;9;1m", [][]byte{pointerMotionMessage(6.25, 12.5), pointerButtonMessage(0x111, false)}, ""},
+		{"\x1b[<35;9;1M", [][]byte{pointerMotionMessage(6.25, 12.5)}, ""},
+		{"\x1b[<65;9;1M", [][]byte{pointerMotionMessage(6.25, 12.5), pointerAxisMessage(protocols.WlPointerAxis_enum_vertical_scroll, terminalScroll)}, ""},
+		// Cut off sequences and characters wait for the next read
+		{"x\x1b[1;", [][]byte{{stream.InputText, 'x'}}, "\x1b[1;"},
+		{"\x1b", nil, "\x1b"},
+		{"\xc3", nil, "\xc3"},
+	} {
+		messages, rest := parseTerminalInput([]byte(tt.input), layout, false)
+		if !slices.EqualFunc(messages, tt.want, bytes.Equal) || string(rest) != tt.rest {
+			t.Errorf("%q: expected %v rest %q, got %v rest %q", tt.input, tt.want, tt.rest, messages, rest)
+		}
+	}
+
+	// Without more input a lone ESC is the Escape key
+	if messages, rest := parseTerminalInput([]byte("\x1b"), layout, true); !slices.EqualFunc(messages, keyTap(keyEsc), bytes.Equal) || rest != nil {
+		t.Errorf("Expected Escape, got %v rest %q", messages, rest)
+	}
+}
+
+func TestTerminalWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &terminalWriter{out: bufio.NewWriter(&out), size: func() (int, int) { return 2, 1 }}
+
+	// A red over blue desktop fills both cells
+	frame := stream.SinkFrame{Pixels: []byte{255, 0, 0, 255, 0, 0, 255, 255}, Size: image.Pt(1, 2)}
+	if err := w.WriteFrame(frame); err != nil {
+		t.Fatal(err)
+	}
+	if w.Layout().size != image.Pt(1, 2) {
+		t.Fatalf("Expected the desktop drawn 1x2, got %v", w.Layout().size)
+	}
+	drawn := out.String()
+	if !strings.HasPrefix(drawn, "\x1b[0m\x1b[2J") || !strings.Contains(drawn, "\x1b[38;2;255;0;0m\x1b[48;2;0;0;255m▀") {
+		t.Errorf("Expected a cleared screen and a red over blue cell, got %q", drawn)
+	}
+
+	// An unchanged frame draws nothing, a changed one only its cell
+	out.Reset()
+	w.WriteFrame(frame)
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing drawn again, got %q", out.String())
+	}
+	frame.Pixels = []byte{0, 255, 0, 255, 0, 0, 255, 255}
+	w.WriteFrame(frame)
+	if want := "\x1b[1;1H\x1b[38;2;0;255;0m\x1b[48;2;0;0;255m▀"; out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
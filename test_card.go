@@ -0,0 +1,101 @@
+package compositor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+)
+
+// testCardBars are the 75% color bars, left to right
+var testCardBars = []color.RGBA{
+	{191, 191, 191, 255}, {191, 191, 0, 255}, {0, 191, 191, 255}, {0, 191, 0, 255},
+	{191, 0, 191, 255}, {191, 0, 0, 255}, {0, 0, 191, 255},
+}
+
+// TestCard is a test pattern for calibrating the model's screen and
+// benchmarking the render and stream pipeline without clients: color bars,
+// a gray ramp, a border and crosshair to show where the desktop is cropped,
+// and a block and frame counter that change every frame, so every frame is
+// composited, uploaded and streamed
+type TestCard struct {
+	*sourceFrames
+	// card is the still part of the pattern, drawn once per desktop size
+	card  *image.RGBA
+	frame int
+	start time.Time
+}
+
+// NewTestCard creates a test card; it is drawn at the desktop's size
+func NewTestCard() *TestCard {
+	return &TestCard{sourceFrames: newSourceFrames(sourceSurfaceID), start: time.Now()}
+}
+
+// Placed draws the next frame at the size of bounds and returns it
+func (t *TestCard) Placed(bounds image.Rectangle) []PlacedSurface {
+	size := bounds.Size()
+	if size.X <= 0 || size.Y <= 0 {
+		return nil
+	}
+	if t.card == nil || t.card.Rect.Size() != size {
+		t.card = drawTestCard(size)
+	}
+	texture := t.back(uint32(size.X), uint32(size.Y))
+	copy(texture.Data, t.card.Pix)
+	img := &image.RGBA{Pix: texture.Data, Stride: int(texture.Stride), Rect: image.Rectangle{Max: size}}
+	t.drawMoving(img)
+	t.frame++
+	t.publish(texture)
+	return t.sourceFrames.Placed(bounds)
+}
+
+// Close does nothing; the card is drawn on the render loop
+func (t *TestCard) Close() {}
+
+// drawMoving draws the parts of the card that change every frame: a block
+// stepping across the bottom band and the frame number and running time
+func (t *TestCard) drawMoving(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	band := image.Rect(0, h*5/6, w, h)
+	block := max(band.Dy(), 1)
+	x := (t.frame * max(w/120, 1)) % max(w-block, 1)
+	fillRect(img, image.Rect(x, band.Min.Y, x+block, band.Max.Y), color.RGBA{255, 255, 255, 255})
+
+	scale := max(h/200, 1)
+	text := fmt.Sprintf("FRAME %d  %s", t.frame, time.Since(t.start).Truncate(time.Second))
+	at := image.Pt(w/2-textSize(text, scale).X/2, h*2/3-glyphHeight*scale-scale)
+	fillRect(img, image.Rectangle{Min: at, Max: at.Add(textSize(text, scale))}.Inset(-scale), color.RGBA{0, 0, 0, 255})
+	drawText(img, at, text, scale, color.RGBA{255, 255, 255, 255})
+}
+
+// drawTestCard draws the still part of the test card at size
+func drawTestCard(size image.Point) *image.RGBA {
+	img := image.NewRGBA(image.Rectangle{Max: size})
+	w, h := size.X, size.Y
+	black := color.RGBA{0, 0, 0, 255}
+	white := color.RGBA{255, 255, 255, 255}
+	fillRect(img, img.Rect, black)
+
+	// Color bars over the top two thirds
+	for i, c := range testCardBars {
+		fillRect(img, image.Rect(i*w/len(testCardBars), 0, (i+1)*w/len(testCardBars), h*2/3), c)
+	}
+	// A gray ramp from black to white under them
+	for x := range w {
+		v := uint8(x * 255 / max(w-1, 1))
+		fillRect(img, image.Rect(x, h*2/3, x+1, h*5/6), color.RGBA{v, v, v, 255})
+	}
+	// A one pixel border, which shows if the edge of the desktop is cut
+	// off, and a crosshair on the centre
+	fillRect(img, image.Rect(0, 0, w, 1), white)
+	fillRect(img, image.Rect(0, h-1, w, h), white)
+	fillRect(img, image.Rect(0, 0, 1, h), white)
+	fillRect(img, image.Rect(w-1, 0, w, h), white)
+	fillRect(img, image.Rect(w/2, h/2-h/10, w/2+1, h/2+h/10), white)
+	fillRect(img, image.Rect(w/2-h/10, h/2, w/2+h/10, h/2+1), white)
+
+	scale := max(h/200, 1)
+	label := fmt.Sprintf("%dx%d", w, h)
+	drawText(img, image.Pt(4*scale, 4*scale), label, scale, black)
+	return img
+}
@@ -0,0 +1,73 @@
+package compositor
+
+// keyEvent is a key press or release on the keyboard clients see
+type keyEvent struct {
+	Keycode uint32
+	Pressed bool
+}
+
+const (
+	keyEnter = 28
+	keyTab   = 15
+)
+
+// usKeyRows are the characters of the US keymap the wayland package gives
+// clients, by evdev keycode, unshifted then shifted
+var usKeyRows = []struct {
+	first            uint32
+	plain, withShift string
+}{
+	{2, "1234567890-=", "!@#$%^&*()_+"},
+	{16, "qwertyuiop[]", "QWERTYUIOP{}"},
+	{30, "asdfghjkl;'`", "ASDFGHJKL:\"~"},
+	{43, "\\zxcvbnm,./", "|ZXCVBNM<>?"},
+	{keySpace, " ", ""},
+	{keyTab, "\t", ""},
+	{keyEnter, "\n", ""},
+}
+
+// textKey is the key that types a character, and whether shift has to be
+// held for it
+type textKey struct {
+	keycode uint32
+	shift   bool
+}
+
+// usKeys maps each character the keymap can type to its key
+var usKeys = func() map[rune]textKey {
+	keys := map[rune]textKey{}
+	for _, row := range usKeyRows {
+		for i, r := range []rune(row.plain) {
+			keys[r] = textKey{row.first + uint32(i), false}
+		}
+		for i, r := range []rune(row.withShift) {
+			keys[r] = textKey{row.first + uint32(i), true}
+		}
+	}
+	return keys
+}()
+
+// textKeyEvents turns text committed by a viewer's IME or virtual keyboard
+// into the key presses that type it. Without zwp_text_input_v3 text can
+// only reach clients as keys, so characters the keymap has no key for are
+// left out and counted in dropped.
+func textKeyEvents(text string) (events []keyEvent, dropped int) {
+	for _, r := range text {
+		if r == '\r' {
+			continue
+		}
+		key, ok := usKeys[r]
+		if !ok {
+			dropped++
+			continue
+		}
+		if key.shift {
+			events = append(events, keyEvent{keyLeftShift, true})
+		}
+		events = append(events, keyEvent{key.keycode, true}, keyEvent{key.keycode, false})
+		if key.shift {
+			events = append(events, keyEvent{keyLeftShift, false})
+		}
+	}
+	return events, dropped
+}
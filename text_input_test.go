@@ -0,0 +1,38 @@
+package compositor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTextKeyEvents(t *testing.T) {
+	events, dropped := textKeyEvents("aB 1!\r\n日本")
+	want := []keyEvent{
+		{30, true}, {30, false},
+		{keyLeftShift, true}, {48, true}, {48, false}, {keyLeftShift, false},
+		{keySpace, true}, {keySpace, false},
+		{2, true}, {2, false},
+		{keyLeftShift, true}, {2, true}, {2, false}, {keyLeftShift, false},
+		{keyEnter, true}, {keyEnter, false},
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("events %v, want %v", events, want)
+	}
+	if dropped != 2 {
+		t.Errorf("dropped %d, want 2", dropped)
+	}
+}
+
+func TestUSKeysCoverPrintableASCII(t *testing.T) {
+	for r := rune(' '); r <= '~'; r++ {
+		if _, ok := usKeys[r]; !ok {
+			t.Errorf("no key for %q", r)
+		}
+	}
+	if k := usKeys['/']; k.keycode != 53 || k.shift {
+		t.Errorf("'/' is %+v, want key 53", k)
+	}
+	if k := usKeys['~']; k.keycode != 41 || !k.shift {
+		t.Errorf("'~' is %+v, want shifted key 41", k)
+	}
+}
@@ -0,0 +1,70 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mmulet/pupapppupps/render"
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+func TestTestCardIsATextureSource(t *testing.T) {
+	var source TextureSource = NewTestCard()
+	if pixels, _ := source.Frame(); pixels != nil {
+		t.Error("The card has no frame before it is drawn")
+	}
+	card := source.(*TestCard)
+	card.Placed(image.Rect(0, 0, 8, 6))
+	damage := source.Damage()
+	if pixels, stride := source.Frame(); len(pixels) != 8*6*4 || stride != 32 || source.Size() != image.Pt(8, 6) {
+		t.Errorf("Frame is %d bytes, stride %d, size %v", len(pixels), stride, source.Size())
+	}
+	card.Placed(image.Rect(0, 0, 8, 6))
+	if source.Damage() == damage {
+		t.Error("A new card frame should damage the source")
+	}
+}
+
+func TestStreamSkipsUndamagedFrames(t *testing.T) {
+	s := stream.NewWebSocketServer()
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for s.ClientCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	source := render.NewDesktopTextureSource()
+	desktop := &wayland.Desktop{Width: 1, Height: 1, Stride: 4, Buffer: []byte{1, 2, 3, 4}}
+	source.Publish(desktop)
+	s.Stream(source)
+	if s.FrameWanted() {
+		t.Error("The new viewer was not sent the frame")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, message, err := conn.ReadMessage(); err != nil || len(message) != 12+4 {
+		t.Fatalf("Expected the frame, got %d bytes (%v)", len(message), err)
+	}
+
+	// The same frame is not sent again; the next one is
+	s.Stream(source)
+	desktop.Buffer = []byte{5, 6, 7, 8}
+	source.Publish(desktop)
+	s.Stream(source)
+	_, message, err := conn.ReadMessage()
+	if err != nil || len(message) != 12+4 || message[12] != 5 {
+		t.Fatalf("Expected the second frame next, got %v (%v)", message, err)
+	}
+}
@@ -0,0 +1,22 @@
+package compositor
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// viewerFiles is the built-in browser viewer for the /ws stream
+//
+//go:embed viewer
+var viewerFiles embed.FS
+
+// viewerHandler serves the built-in viewer
+func viewerHandler() http.Handler {
+	files, err := fs.Sub(viewerFiles, "viewer")
+	if err != nil {
+		// Only fails if the embed directive above is wrong
+		panic(err)
+	}
+	return http.FileServerFS(files)
+}
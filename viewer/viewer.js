@@ -0,0 +1,905 @@
+// Built-in viewer for the compositor's /ws desktop stream.
+//
+// Frames arrive as binary messages: a 12 byte little-endian header
+// [width:uint32][height:uint32][stride:uint32] followed by the RGBA rows.
+// After the hello exchange a flags byte follows the header and the rows may
+// be deflated and XORed with the previous frame, see frame_codec.go. Input
+// goes back as binary messages, see the input message types in server.go.
+//
+// Opened with ?sync the viewer asks for timestamped frames, keeps its clock
+// in step with the server's with NTP-style clock messages and shows each
+// frame the playout delay after it was captured, so several viewers of the
+// same compositor, as in a video wall, show every frame at the same moment.
+//
+// The taskbar lists the desktop's windows with thumbnails, from the windows
+// messages the viewer subscribes to; clicking one focuses it and its x
+// closes it.
+//
+// The hello reply carries a reconnect token, kept in sessionStorage, that
+// the next hello sends back so the viewer keeps its role after a dropped
+// connection or a compositor restart. Opened with ?spectate the viewer
+// asks to only watch. Status messages say why the stream stopped changing.
+// The preferences kept under the token, its magnification and which
+// overlays show, are applied as they arrive.
+//
+// Opened with ?region=x,y,width,height or ?region=x,y,width,height,zoom the
+// viewer asks to be sent only that part of the desktop, scaled by zoom on
+// the server, so small text can be read on a phone.
+(() => {
+    const INPUT_KEYBOARD = 1;
+    const INPUT_POINTER_MOTION = 2;
+    const INPUT_POINTER_BUTTON = 3;
+    const INPUT_POINTER_AXIS = 4;
+    const INPUT_POINTER_RELATIVE = 5;
+    const INPUT_PEN = 6;
+    const INPUT_TEXT = 7;
+
+    // Linux keycodes the text field sends as keys rather than text
+    const KEY_BACKSPACE = 14;
+    const KEY_ENTER = 28;
+
+    // Pen buttons in a pen message
+    const PEN_TIP = 1;
+    const PEN_BARREL = 2;
+
+    const FRAME_COMPRESSED = 1;
+    const FRAME_DELTA = 2;
+    const FRAME_TIMESTAMPED = 4;
+
+    const syncPlayout = new URLSearchParams(window.location.search).has('sync');
+    const spectate = new URLSearchParams(window.location.search).has('spectate');
+    const regionParam = new URLSearchParams(window.location.search).get('region');
+    const TOKEN_KEY = 'pupapppupps-viewer-token';
+    // Reconnect delay, unless the server says how long a restart takes
+    const RECONNECT_DELAY = 2000;
+    // Close code the server uses when it is restarting
+    const CLOSE_SERVICE_RESTART = 1012;
+    // Clock messages: a burst on connecting, then one every CLOCK_INTERVAL
+    const CLOCK_BURST = 8;
+    const CLOCK_INTERVAL = 10000;
+    // How many of the latest clock samples the offset is picked from
+    const CLOCK_SAMPLES = 16;
+
+    // Linux BTN_* codes for DOM mouse buttons 0, 1 and 2
+    const BTN_LEFT = 0x110;
+    const BTN_RIGHT = 0x111;
+    const BTN_MIDDLE = 0x112;
+    const mouseButtons = [BTN_LEFT, BTN_MIDDLE, BTN_RIGHT];
+
+    const canvas = document.getElementById('desktop-canvas');
+    const ctx = canvas.getContext('2d');
+    const screenEl = document.getElementById('screen');
+    const statusEl = document.getElementById('status');
+    const statsEl = document.getElementById('stats');
+    const fullscreenButton = document.getElementById('fullscreen');
+    const controlButton = document.getElementById('control');
+    const textInput = document.getElementById('text-input');
+    const taskbar = document.getElementById('taskbar');
+
+    // Map KeyboardEvent.code to Linux evdev keycodes
+    const keyCodeToLinux = {
+        'Escape': 1,
+        'Digit1': 2, 'Digit2': 3, 'Digit3': 4, 'Digit4': 5, 'Digit5': 6,
+        'Digit6': 7, 'Digit7': 8, 'Digit8': 9, 'Digit9': 10, 'Digit0': 11,
+        'Minus': 12, 'Equal': 13, 'Backspace': 14, 'Tab': 15,
+        'KeyQ': 16, 'KeyW': 17, 'KeyE': 18, 'KeyR': 19, 'KeyT': 20,
+        'KeyY': 21, 'KeyU': 22, 'KeyI': 23, 'KeyO': 24, 'KeyP': 25,
+        'BracketLeft': 26, 'BracketRight': 27, 'Enter': 28, 'ControlLeft': 29,
+        'KeyA': 30, 'KeyS': 31, 'KeyD': 32, 'KeyF': 33, 'KeyG': 34,
+        'KeyH': 35, 'KeyJ': 36, 'KeyK': 37, 'KeyL': 38,
+        'Semicolon': 39, 'Quote': 40, 'Backquote': 41, 'ShiftLeft': 42,
+        'Backslash': 43,
+        'KeyZ': 44, 'KeyX': 45, 'KeyC': 46, 'KeyV': 47, 'KeyB': 48,
+        'KeyN': 49, 'KeyM': 50, 'Comma': 51, 'Period': 52, 'Slash': 53,
+        'ShiftRight': 54, 'AltLeft': 56, 'Space': 57, 'CapsLock': 58,
+        'F1': 59, 'F2': 60, 'F3': 61, 'F4': 62, 'F5': 63,
+        'F6': 64, 'F7': 65, 'F8': 66, 'F9': 67, 'F10': 68,
+        'F11': 87, 'F12': 88,
+        'ControlRight': 97, 'AltRight': 100,
+        'Home': 102, 'ArrowUp': 103, 'PageUp': 104,
+        'ArrowLeft': 105, 'ArrowRight': 106,
+        'End': 107, 'ArrowDown': 108, 'PageDown': 109,
+        'Insert': 110, 'Delete': 111, 'MetaLeft': 125, 'MetaRight': 126
+    };
+
+    let ws = null;
+    let imageData = null;
+    // Frame format agreed in the hello exchange, or null for plain frames
+    let stream = null;
+    // Raw rows of the last frame, for delta frames
+    let previous = null;
+    // Last stats message: the quality tier the server picked for us
+    let quality = {};
+    // Last region message: the part of the desktop frames show, null for
+    // the whole of it
+    let region = null;
+    // Frames are decoded in order, one after another
+    let decoding = Promise.resolve();
+    // Whether the compositor has locked the pointer to a window; the mouse
+    // is captured on the next click, as browsers require
+    let pointerLocked = false;
+    // While a pen is over the canvas, the mouse events browsers make up
+    // for it are ignored
+    let penInRange = false;
+    // Clock samples from the server, newest last; the one with the
+    // shortest round trip gives the best offset
+    let clockSamples = [];
+    // Whether the server's clock is kept in step by NTP, and its error
+    let serverClock = { synced: false, errorUs: 0 };
+    // Role the server gave us, and the last stream status it announced
+    let role = 'controller';
+    let streamStatus = 'live';
+    let reconnectDelay = RECONNECT_DELAY;
+    // Preferences the server keeps for us under our token
+    let preferences = {};
+    // Last input control notice, null while control is shared, and
+    // whether we asked for control
+    let control = null;
+    let controlRequested = false;
+    const stats = {
+        frames: 0,
+        bytes: 0,
+        fps: 0,
+        kbps: 0,
+        width: 0,
+        height: 0,
+        connectedAt: 0,
+        reconnects: 0,
+        windowStart: performance.now(),
+        late: 0,
+        // End-to-end latency read from the stats overlay's frame stamps,
+        // null while there is no overlay
+        latency: null
+    };
+
+    function send(buffer) {
+        if (ws && ws.readyState === WebSocket.OPEN) {
+            ws.send(buffer);
+        }
+    }
+
+    function sendKeyboard(keycode, pressed) {
+        const view = new DataView(new ArrayBuffer(6));
+        view.setUint8(0, INPUT_KEYBOARD);
+        view.setUint32(1, keycode, true);
+        view.setUint8(5, pressed ? 1 : 0);
+        send(view.buffer);
+    }
+
+    function sendText(text) {
+        const bytes = new TextEncoder().encode(text);
+        const message = new Uint8Array(bytes.length + 1);
+        message[0] = INPUT_TEXT;
+        message.set(bytes, 1);
+        send(message.buffer);
+    }
+
+    function sendMotion(x, y) {
+        const view = new DataView(new ArrayBuffer(9));
+        view.setUint8(0, INPUT_POINTER_MOTION);
+        view.setFloat32(1, x, true);
+        view.setFloat32(5, y, true);
+        send(view.buffer);
+    }
+
+    function sendRelative(dx, dy) {
+        const view = new DataView(new ArrayBuffer(9));
+        view.setUint8(0, INPUT_POINTER_RELATIVE);
+        view.setFloat32(1, dx, true);
+        view.setFloat32(5, dy, true);
+        send(view.buffer);
+    }
+
+    // DOM buttons: 1 is the tip (or contact), 2 the barrel button
+    function sendPen(event, buttons) {
+        const view = new DataView(new ArrayBuffer(22));
+        const [x, y] = desktopPosition(event.clientX, event.clientY);
+        view.setUint8(0, INPUT_PEN);
+        view.setFloat32(1, x, true);
+        view.setFloat32(5, y, true);
+        view.setFloat32(9, event.pressure, true);
+        view.setFloat32(13, event.tiltX, true);
+        view.setFloat32(17, event.tiltY, true);
+        view.setUint8(21, ((buttons & 1) ? PEN_TIP : 0) | ((buttons & 2) ? PEN_BARREL : 0));
+        send(view.buffer);
+    }
+
+    function sendButton(button, pressed) {
+        const view = new DataView(new ArrayBuffer(6));
+        view.setUint8(0, INPUT_POINTER_BUTTON);
+        view.setUint32(1, button, true);
+        view.setUint8(5, pressed ? 1 : 0);
+        send(view.buffer);
+    }
+
+    function sendAxis(horizontal, value) {
+        const view = new DataView(new ArrayBuffer(6));
+        view.setUint8(0, INPUT_POINTER_AXIS);
+        view.setUint8(1, horizontal ? 1 : 0);
+        view.setFloat32(2, value, true);
+        send(view.buffer);
+    }
+
+    // Convert page coordinates to desktop pixels; the canvas is scaled by
+    // CSS, frames may be downscaled by the quality tier, and may show a
+    // zoomed region of the desktop
+    function desktopPosition(clientX, clientY) {
+        const rect = canvas.getBoundingClientRect();
+        const scale = (quality.scale || 1) / (region ? region.zoom : 1);
+        const x = (clientX - rect.left) * canvas.width / rect.width;
+        const y = (clientY - rect.top) * canvas.height / rect.height;
+        return [
+            Math.min(Math.max(x, 0), canvas.width - 1) * scale + (region ? region.x : 0),
+            Math.min(Math.max(y, 0), canvas.height - 1) * scale + (region ? region.y : 0)
+        ];
+    }
+
+    // Keyboard, while the canvas has focus
+    function handleKey(event, pressed) {
+        const keycode = keyCodeToLinux[event.code];
+        if (keycode !== undefined) {
+            event.preventDefault();
+            sendKeyboard(keycode, pressed);
+        }
+    }
+    canvas.addEventListener('keydown', (e) => handleKey(e, true));
+    canvas.addEventListener('keyup', (e) => handleKey(e, false));
+
+    // Text field for IMEs and virtual keyboards: what they commit is sent
+    // as text, while a composition in progress stays in the field
+    textInput.addEventListener('compositionend', (e) => {
+        if (e.data) {
+            sendText(e.data);
+        }
+        textInput.value = '';
+    });
+    textInput.addEventListener('input', (e) => {
+        if (e.isComposing) {
+            return;
+        }
+        if (e.inputType === 'insertText' && e.data) {
+            sendText(e.data);
+        }
+        textInput.value = '';
+    });
+    textInput.addEventListener('keydown', (e) => {
+        if (e.isComposing || (e.key !== 'Enter' && e.key !== 'Backspace')) {
+            return;
+        }
+        e.preventDefault();
+        const keycode = e.key === 'Enter' ? KEY_ENTER : KEY_BACKSPACE;
+        sendKeyboard(keycode, true);
+        sendKeyboard(keycode, false);
+    });
+
+    function captured() {
+        return document.pointerLockElement === canvas;
+    }
+
+    // Pen, with its pressure and tilt
+    const handlePen = (e) => {
+        if (e.pointerType !== 'pen') {
+            return;
+        }
+        e.preventDefault();
+        penInRange = e.type !== 'pointerleave';
+        if (e.type === 'pointerdown') {
+            canvas.focus();
+        }
+        sendPen(e, penInRange ? e.buttons : 0);
+    };
+    for (const type of ['pointerdown', 'pointermove', 'pointerup', 'pointerleave']) {
+        canvas.addEventListener(type, handlePen);
+    }
+
+    // Mouse. While captured, motion is sent as relative desktop pixels.
+    canvas.addEventListener('mousemove', (e) => {
+        if (penInRange) {
+            return;
+        }
+        if (captured()) {
+            const rect = canvas.getBoundingClientRect();
+            const scale = (quality.scale || 1) / (region ? region.zoom : 1);
+            sendRelative(e.movementX * canvas.width / rect.width * scale,
+                e.movementY * canvas.height / rect.height * scale);
+        } else {
+            sendMotion(...desktopPosition(e.clientX, e.clientY));
+        }
+    });
+    canvas.addEventListener('mousedown', (e) => {
+        if (penInRange) {
+            return;
+        }
+        canvas.focus();
+        if (pointerLocked && !captured()) {
+            canvas.requestPointerLock();
+        }
+        const button = mouseButtons[e.button];
+        if (button !== undefined) {
+            e.preventDefault();
+            if (!captured()) {
+                sendMotion(...desktopPosition(e.clientX, e.clientY));
+            }
+            sendButton(button, true);
+        }
+    });
+    canvas.addEventListener('mouseup', (e) => {
+        if (penInRange) {
+            return;
+        }
+        const button = mouseButtons[e.button];
+        if (button !== undefined) {
+            e.preventDefault();
+            sendButton(button, false);
+        }
+    });
+    canvas.addEventListener('contextmenu', (e) => e.preventDefault());
+    canvas.addEventListener('wheel', (e) => {
+        e.preventDefault();
+        // Lines and pages are scaled to roughly the pixels a desktop scrolls
+        const scale = e.deltaMode === WheelEvent.DOM_DELTA_LINE ? 15 :
+            e.deltaMode === WheelEvent.DOM_DELTA_PAGE ? canvas.height : 1;
+        if (e.deltaY) {
+            sendAxis(false, e.deltaY * scale);
+        }
+        if (e.deltaX) {
+            sendAxis(true, e.deltaX * scale);
+        }
+    }, { passive: false });
+
+    // Touch: the first finger acts as the left mouse button
+    let touchId = null;
+    canvas.addEventListener('touchstart', (e) => {
+        e.preventDefault();
+        canvas.focus();
+        if (touchId !== null) {
+            return;
+        }
+        const touch = e.changedTouches[0];
+        touchId = touch.identifier;
+        sendMotion(...desktopPosition(touch.clientX, touch.clientY));
+        sendButton(BTN_LEFT, true);
+    }, { passive: false });
+    canvas.addEventListener('touchmove', (e) => {
+        e.preventDefault();
+        for (const touch of e.changedTouches) {
+            if (touch.identifier === touchId) {
+                sendMotion(...desktopPosition(touch.clientX, touch.clientY));
+            }
+        }
+    }, { passive: false });
+    const endTouch = (e) => {
+        e.preventDefault();
+        for (const touch of e.changedTouches) {
+            if (touch.identifier === touchId) {
+                touchId = null;
+                sendButton(BTN_LEFT, false);
+            }
+        }
+    };
+    canvas.addEventListener('touchend', endTouch, { passive: false });
+    canvas.addEventListener('touchcancel', endTouch, { passive: false });
+
+    // Fullscreen
+    fullscreenButton.addEventListener('click', () => {
+        if (document.fullscreenElement) {
+            document.exitFullscreen();
+        } else {
+            screenEl.requestFullscreen().then(() => canvas.focus()).catch((err) => {
+                console.error('Fullscreen failed:', err);
+            });
+        }
+    });
+    document.addEventListener('fullscreenchange', () => {
+        fullscreenButton.textContent = document.fullscreenElement ? 'Exit fullscreen' : 'Fullscreen';
+    });
+
+    async function inflate(bytes) {
+        const stream = new Blob([bytes]).stream().pipeThrough(new DecompressionStream('deflate-raw'));
+        return new Uint8Array(await new Response(stream).arrayBuffer());
+    }
+
+    // Wall-clock milliseconds, finer than Date.now()
+    function wallNow() {
+        return performance.timeOrigin + performance.now();
+    }
+
+    function sendClock() {
+        if (ws && ws.readyState === WebSocket.OPEN) {
+            ws.send(JSON.stringify({ type: 'clock', client_time: wallNow() }));
+        }
+    }
+
+    // Work out the offset of the server's clock from ours from a clock
+    // reply, as NTP does: the server's time is ours plus the offset
+    function handleClock(message) {
+        const t0 = message.client_time;
+        const t3 = wallNow();
+        const t1 = message.received_us / 1000;
+        const t2 = message.sent_us / 1000;
+        clockSamples.push({
+            offset: ((t1 - t0) + (t2 - t3)) / 2,
+            rtt: (t3 - t0) - (t2 - t1)
+        });
+        if (clockSamples.length > CLOCK_SAMPLES) {
+            clockSamples.shift();
+        }
+        serverClock = { synced: message.synced, errorUs: message.error_us };
+    }
+
+    // The clock sample with the shortest round trip, or null before any
+    function bestClock() {
+        let best = null;
+        for (const sample of clockSamples) {
+            if (!best || sample.rtt < best.rtt) {
+                best = sample;
+            }
+        }
+        return best;
+    }
+
+    function startClockSync() {
+        for (let i = 0; i < CLOCK_BURST; i++) {
+            setTimeout(sendClock, i * 100);
+        }
+    }
+    if (syncPlayout) {
+        setInterval(sendClock, CLOCK_INTERVAL);
+    }
+
+    // Wait until a timestamped frame is due: the playout delay after the
+    // server captured it, in our clock. Late frames are shown at once.
+    function waitForPlayout(frame) {
+        const clock = bestClock();
+        if (!syncPlayout || frame.captured === undefined || !clock) {
+            return Promise.resolve();
+        }
+        const due = frame.captured + (stream.playout_delay_ms || 0) - clock.offset;
+        const wait = due - wallNow();
+        if (wait <= 0) {
+            stats.late++;
+            return Promise.resolve();
+        }
+        return new Promise((resolve) => setTimeout(resolve, wait));
+    }
+
+    // Decode a frame message into its size and raw rows
+    async function decodeFrame(buffer) {
+        let headerSize = 12;
+        let flags = 0;
+        if (stream) {
+            if (buffer.byteLength < 13) {
+                return null;
+            }
+            flags = new DataView(buffer).getUint8(12);
+            headerSize = flags & FRAME_TIMESTAMPED ? 21 : 13;
+        }
+        if (buffer.byteLength < headerSize) {
+            return null;
+        }
+        const header = new DataView(buffer, 0, headerSize);
+        const frame = {
+            width: header.getUint32(0, true),
+            height: header.getUint32(4, true),
+            stride: header.getUint32(8, true),
+            rows: new Uint8Array(buffer, headerSize)
+        };
+        if (!stream) {
+            return frame;
+        }
+        if (flags & FRAME_TIMESTAMPED) {
+            // Unix microseconds on the server's clock
+            frame.captured = Number(header.getBigInt64(13, true)) / 1000;
+        }
+        if (flags & FRAME_COMPRESSED) {
+            frame.rows = await inflate(frame.rows);
+        }
+        if (flags & FRAME_DELTA) {
+            if (!previous || previous.length !== frame.rows.length) {
+                throw new Error('delta frame without a matching previous frame');
+            }
+            const rows = new Uint8Array(frame.rows);
+            for (let i = 0; i < rows.length; i++) {
+                rows[i] ^= previous[i];
+            }
+            frame.rows = rows;
+        }
+        previous = frame.rows;
+        return frame;
+    }
+
+    async function drawFrame(buffer) {
+        const frame = await decodeFrame(buffer);
+        if (!frame) {
+            return;
+        }
+        const { width, height, stride, rows } = frame;
+        if (width === 0 || height === 0 || rows.length < stride * height) {
+            return;
+        }
+        await waitForPlayout(frame);
+
+        if (canvas.width !== width || canvas.height !== height) {
+            canvas.width = width;
+            canvas.height = height;
+            applyScale();
+        }
+        if (!imageData || imageData.width !== width || imageData.height !== height) {
+            imageData = new ImageData(width, height);
+        }
+
+        // Copy row by row when rows are padded past width * 4
+        const rowBytes = width * 4;
+        if (stride === rowBytes) {
+            imageData.data.set(rows.subarray(0, rowBytes * height));
+        } else {
+            for (let y = 0; y < height; y++) {
+                imageData.data.set(rows.subarray(y * stride, y * stride + rowBytes), y * rowBytes);
+            }
+        }
+        ctx.putImageData(imageData, 0, 0);
+        readStamp(imageData);
+
+        stats.frames++;
+        stats.bytes += buffer.byteLength;
+        stats.width = width;
+        stats.height = height;
+    }
+
+    // Read the composite time the stats overlay stamps along the top of
+    // the frame: a white and a black block, then 48 bits, most significant
+    // first. The latency is how long ago that was in the server's clock,
+    // ours without ?sync.
+    const STAMP_BITS = 48;
+    const STAMP_BLOCK = 8;
+    function readStamp(image) {
+        if (region && (region.x > 0 || region.y > 0)) {
+            stats.latency = null;
+            return;
+        }
+        const block = STAMP_BLOCK * (region ? region.zoom : 1) / (quality.scale || 1);
+        const y = Math.floor(block / 2);
+        const white = (i) => {
+            const offset = (y * image.width + Math.floor((i + 0.5) * block)) * 4;
+            const data = image.data;
+            return data[offset] > 128 && data[offset + 1] > 128 && data[offset + 2] > 128;
+        };
+        if ((2 + STAMP_BITS) * block > image.width || !white(0) || white(1)) {
+            stats.latency = null;
+            return;
+        }
+        let stamp = 0;
+        for (let bit = 0; bit < STAMP_BITS; bit++) {
+            stamp = stamp * 2 + (white(2 + bit) ? 1 : 0);
+        }
+        const latency = wallNow() + (bestClock()?.offset || 0) - stamp;
+        // A desktop that happens to start white then black is no stamp
+        stats.latency = Math.abs(latency) < 60000 ? latency : null;
+    }
+
+    // Ask for deflated delta frames when the browser can inflate them, and
+    // timestamped ones to sync to
+    function sendHello() {
+        const hello = { type: 'hello', delta: true, compression: [], timestamps: syncPlayout };
+        if (typeof DecompressionStream !== 'undefined') {
+            hello.compression.push('deflate');
+        }
+        const token = sessionStorage.getItem(TOKEN_KEY);
+        if (token) {
+            hello.token = token;
+        }
+        if (spectate) {
+            hello.role = 'spectator';
+        }
+        ws.send(JSON.stringify(hello));
+    }
+
+    // Ask for the part of the desktop ?region names
+    function sendRegion() {
+        const [x, y, width, height, zoom] = regionParam.split(',').map(Number);
+        ws.send(JSON.stringify({ type: 'region', x, y, width, height, zoom: zoom || 1 }));
+    }
+
+    // Ask for the window list, with thumbnails, for the taskbar
+    function sendWindows() {
+        ws.send(JSON.stringify({ type: 'windows', thumbnails: true }));
+    }
+
+    // With exclusive input control the control button asks for control,
+    // hands it to the first viewer asking for it, or gives it up
+    function updateControl() {
+        if (!control || control.mode !== 'exclusive' || role === 'spectator') {
+            controlButton.hidden = true;
+            return;
+        }
+        controlButton.hidden = false;
+        controlButton.disabled = false;
+        if (!control.yours) {
+            controlButton.textContent = controlRequested ? 'Control requested' : 'Request control';
+            controlButton.disabled = controlRequested;
+        } else if (control.requests && control.requests.length > 0) {
+            controlButton.textContent = `Give control to viewer ${control.requests[0]}`;
+        } else {
+            controlButton.textContent = 'Release control';
+        }
+    }
+
+    controlButton.addEventListener('click', () => {
+        if (!control || !ws || ws.readyState !== WebSocket.OPEN) {
+            return;
+        }
+        if (!control.yours) {
+            controlRequested = true;
+            ws.send(JSON.stringify({ type: 'request_control' }));
+        } else if (control.requests && control.requests.length > 0) {
+            ws.send(JSON.stringify({ type: 'grant_control', viewer: control.requests[0] }));
+        } else {
+            ws.send(JSON.stringify({ type: 'release_control' }));
+        }
+        updateControl();
+    });
+
+    function sendWindowAction(action, win) {
+        if (ws && ws.readyState === WebSocket.OPEN) {
+            ws.send(JSON.stringify({ type: action, client: win.client, toplevel: win.toplevel }));
+        }
+    }
+
+    function updateTaskbar(windows) {
+        taskbar.replaceChildren(...windows.map((win) => {
+            const item = document.createElement('button');
+            item.type = 'button';
+            item.className = win.focused ? 'window focused' : 'window';
+            item.title = win.title || win.app_id || 'Window';
+            if (win.thumbnail) {
+                const thumbnail = document.createElement('img');
+                thumbnail.src = win.thumbnail;
+                thumbnail.alt = '';
+                item.appendChild(thumbnail);
+            }
+            const label = document.createElement('span');
+            label.textContent = win.title || win.app_id || 'Window';
+            item.appendChild(label);
+            const close = document.createElement('span');
+            close.className = 'close';
+            close.textContent = '\u00d7';
+            close.addEventListener('click', (e) => {
+                e.stopPropagation();
+                sendWindowAction('close', win);
+            });
+            item.appendChild(close);
+            item.addEventListener('click', () => {
+                sendWindowAction('focus', win);
+                canvas.focus();
+            });
+            return item;
+        }));
+    }
+
+    // Magnify the desktop by the preferred scale, or fit it to the window
+    function applyScale() {
+        if (preferences.scale > 0) {
+            const desktopWidth = canvas.width * (quality.scale || 1);
+            canvas.style.width = `${desktopWidth * preferences.scale}px`;
+            canvas.style.maxWidth = 'none';
+            canvas.style.maxHeight = 'none';
+        } else {
+            canvas.style.width = '';
+            canvas.style.maxWidth = '';
+            canvas.style.maxHeight = '';
+        }
+    }
+
+    function applyPreferences(message) {
+        preferences = message || {};
+        const overlays = preferences.overlays || {};
+        statsEl.hidden = overlays.stats === false;
+        taskbar.hidden = overlays.taskbar === false;
+        applyScale();
+    }
+
+    function updateStatus(status, text) {
+        statusEl.className = status;
+        statusEl.textContent = text;
+    }
+
+    // Status text for a connected viewer, from its role, the stream status
+    // and the pointer lock
+    function connectedText() {
+        if (streamStatus === 'paused') {
+            return 'Stream paused';
+        }
+        if (streamStatus === 'off') {
+            return 'Display off';
+        }
+        if (streamStatus === 'reloading') {
+            return 'Model reloading...';
+        }
+        if (streamStatus === 'restarting') {
+            return 'Compositor restarting...';
+        }
+        if (role === 'spectator') {
+            return 'Spectating';
+        }
+        if (control && control.mode === 'exclusive' && !control.yours) {
+            return control.holder ? `Watching - viewer ${control.holder} has control` : 'Watching - nobody has control';
+        }
+        if (pointerLocked) {
+            return 'Pointer locked - click the desktop to capture the mouse';
+        }
+        return 'Connected';
+    }
+
+    function formatDuration(ms) {
+        const seconds = Math.floor(ms / 1000);
+        const m = Math.floor(seconds / 60);
+        const s = seconds % 60;
+        return `${m}:${s.toString().padStart(2, '0')}`;
+    }
+
+    function formatStream() {
+        if (!stream) {
+            return 'raw';
+        }
+        const parts = [stream.compression || 'raw'];
+        if (stream.delta) {
+            parts.push('delta');
+        }
+        return parts.join('+');
+    }
+
+    function updateStats() {
+        const now = performance.now();
+        const elapsed = (now - stats.windowStart) / 1000;
+        stats.fps = Math.round(stats.frames / elapsed);
+        stats.kbps = Math.round(stats.bytes / 1024 / elapsed);
+        stats.frames = 0;
+        stats.bytes = 0;
+        stats.windowStart = now;
+
+        if (!ws || ws.readyState !== WebSocket.OPEN) {
+            return;
+        }
+        const parts = [
+            `Resolution: ${stats.width}x${stats.height}`,
+            `FPS: ${stats.fps}`,
+            `Bandwidth: ${stats.kbps} KB/s`,
+            `Encoding: ${formatStream()}`,
+            `Quality: ${quality.tier || 'full'}`,
+            `Connected: ${formatDuration(now - stats.connectedAt)}`
+        ];
+        if (stats.reconnects > 0) {
+            parts.push(`Reconnects: ${stats.reconnects}`);
+        }
+        if (stats.latency !== null) {
+            parts.push(`Latency: ${Math.round(stats.latency)}ms`);
+        }
+        const clock = bestClock();
+        if (syncPlayout && clock) {
+            // Half the round trip bounds our error; the server's own error
+            // against true time only matters across servers
+            const server = serverClock.synced ? `server ±${(serverClock.errorUs / 1000).toFixed(1)}ms` : 'server unsynced';
+            parts.push(`Clock: ${clock.offset.toFixed(1)}ms ±${(clock.rtt / 2).toFixed(1)}ms, ${server}`);
+            parts.push(`Late: ${stats.late}`);
+        }
+        statsEl.textContent = parts.join(' | ');
+    }
+    setInterval(updateStats, 1000);
+
+    function connect() {
+        const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+        updateStatus('connecting', 'Connecting...');
+
+        ws = new WebSocket(`${protocol}//${window.location.host}/ws`);
+        ws.binaryType = 'arraybuffer';
+
+        ws.onopen = () => {
+            decoding = decoding.then(() => {
+                stream = null;
+                previous = null;
+                quality = {};
+                region = null;
+            });
+            // A locked pointer and the stream status are announced again
+            // after the hello
+            pointerLocked = false;
+            streamStatus = 'live';
+            control = null;
+            controlRequested = false;
+            updateControl();
+            reconnectDelay = RECONNECT_DELAY;
+            sendHello();
+            sendWindows();
+            if (regionParam) {
+                sendRegion();
+            }
+            if (syncPlayout) {
+                clockSamples = [];
+                startClockSync();
+            }
+            stats.connectedAt = performance.now();
+            updateStatus('connected', 'Connected');
+        };
+        ws.onclose = (event) => {
+            updateTaskbar([]);
+            if (event.code === CLOSE_SERVICE_RESTART || streamStatus === 'restarting') {
+                updateStatus('disconnected', 'Compositor restarting - reconnecting...');
+            } else {
+                updateStatus('disconnected', 'Disconnected - reconnecting...');
+            }
+            stats.reconnects++;
+            setTimeout(connect, reconnectDelay);
+        };
+        ws.onerror = (error) => {
+            console.error('WebSocket error:', error);
+        };
+        ws.onmessage = (event) => {
+            if (event.data instanceof ArrayBuffer) {
+                const buffer = event.data;
+                const socket = ws;
+                decoding = decoding.then(() => drawFrame(buffer)).catch((err) => {
+                    // Reconnect for a fresh keyframe
+                    console.error('Frame decode failed:', err);
+                    socket.close();
+                });
+            } else if (typeof event.data === 'string') {
+                const message = JSON.parse(event.data);
+                // Frames queued before these were sent in the old format
+                // or at the old tier
+                if (message.type === 'hello') {
+                    decoding = decoding.then(() => {
+                        stream = message;
+                    });
+                    sessionStorage.setItem(TOKEN_KEY, message.token);
+                    role = message.role;
+                    applyPreferences(message.preferences);
+                    updateStatus('connected', connectedText());
+                } else if (message.type === 'control') {
+                    control = message;
+                    // Our request is answered once we hold control, or
+                    // dropped when it is revoked
+                    if (control.yours || !control.holder) {
+                        controlRequested = false;
+                    }
+                    updateControl();
+                    updateStatus('connected', connectedText());
+                } else if (message.type === 'preferences') {
+                    applyPreferences(message.preferences);
+                } else if (message.type === 'status') {
+                    streamStatus = message.status;
+                    if (message.retry_ms) {
+                        reconnectDelay = message.retry_ms;
+                    }
+                    // The output is off until a keyframe turns it back on
+                    if (streamStatus === 'off') {
+                        decoding = decoding.then(() => {
+                            ctx.fillStyle = '#000';
+                            ctx.fillRect(0, 0, canvas.width, canvas.height);
+                        });
+                    }
+                    updateStatus('connected', connectedText());
+                } else if (message.type === 'region') {
+                    decoding = decoding.then(() => {
+                        region = message;
+                    });
+                } else if (message.type === 'stats') {
+                    decoding = decoding.then(() => {
+                        quality = message;
+                    });
+                } else if (message.type === 'windows') {
+                    updateTaskbar(message.windows);
+                } else if (message.type === 'clock') {
+                    handleClock(message);
+                } else if (message.type === 'pointer_lock') {
+                    pointerLocked = message.locked;
+                    updateStatus('connected', connectedText());
+                    if (!pointerLocked && captured()) {
+                        document.exitPointerLock();
+                    }
+                }
+            }
+        };
+    }
+
+    connect();
+})();
@@ -0,0 +1,189 @@
+package compositor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// wlConn is a minimal Wayland client connection to another compositor:
+// enough of the wire protocol to bind globals, share memory and capture
+// outputs. Requests and events are untyped; callers know the interfaces.
+type wlConn struct {
+	conn   *net.UnixConn
+	nextID uint32
+	// pending holds bytes read but not yet parsed into an event
+	pending []byte
+}
+
+// wlEvent is one event: the object it is for, its opcode and arguments
+type wlEvent struct {
+	Object uint32
+	Opcode uint16
+	Args   wlReader
+}
+
+// wlDisplayID is the wl_display, the one object every connection has
+const wlDisplayID = 1
+
+// dialWayland connects to display, a socket name in $XDG_RUNTIME_DIR or
+// an absolute path
+func dialWayland(display string) (*wlConn, error) {
+	path := display
+	if !filepath.IsAbs(path) {
+		runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+		if runtimeDir == "" {
+			return nil, fmt.Errorf("XDG_RUNTIME_DIR is not set")
+		}
+		path = filepath.Join(runtimeDir, display)
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", path, err)
+	}
+	return &wlConn{conn: conn, nextID: wlDisplayID}, nil
+}
+
+// newID allocates a client object id
+func (c *wlConn) newID() uint32 {
+	c.nextID++
+	return c.nextID
+}
+
+// request sends a request to object, passing fds alongside it
+func (c *wlConn) request(object uint32, opcode uint16, args wlArgs, fds ...int) error {
+	message := wlMessage(object, opcode, args)
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	if _, _, err := c.conn.WriteMsgUnix(message, oob, nil); err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	return nil
+}
+
+// next reads the next event. wl_display errors are returned as errors.
+func (c *wlConn) next() (wlEvent, error) {
+	for {
+		if len(c.pending) >= 8 {
+			size := int(binary.LittleEndian.Uint32(c.pending[4:8]) >> 16)
+			if size < 8 {
+				return wlEvent{}, fmt.Errorf("bad event size %d", size)
+			}
+			if len(c.pending) >= size {
+				event := wlEvent{
+					Object: binary.LittleEndian.Uint32(c.pending[0:4]),
+					Opcode: uint16(binary.LittleEndian.Uint32(c.pending[4:8])),
+					Args:   wlReader(append([]byte(nil), c.pending[8:size]...)),
+				}
+				c.pending = c.pending[size:]
+				if event.Object == wlDisplayID && event.Opcode == 0 {
+					object, code, message := event.Args.uint32(), event.Args.uint32(), event.Args.string()
+					return wlEvent{}, fmt.Errorf("protocol error on object %d, code %d: %s", object, code, message)
+				}
+				return event, nil
+			}
+		}
+		buf := make([]byte, 4096)
+		oob := make([]byte, syscall.CmsgSpace(4*28))
+		n, oobn, _, _, err := c.conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return wlEvent{}, err
+		}
+		// No interface bound here sends fds; close any that arrive
+		if messages, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+			for _, m := range messages {
+				fds, _ := syscall.ParseUnixRights(&m)
+				for _, fd := range fds {
+					syscall.Close(fd)
+				}
+			}
+		}
+		c.pending = append(c.pending, buf[:n]...)
+	}
+}
+
+// roundtrip waits until the compositor has handled every request sent so
+// far, passing the events that arrive meanwhile to handle
+func (c *wlConn) roundtrip(handle func(wlEvent)) error {
+	callback := c.newID()
+	if err := c.request(wlDisplayID, 0, wlArgs{}.uint32(callback)); err != nil {
+		return err
+	}
+	for {
+		event, err := c.next()
+		if err != nil {
+			return err
+		}
+		if event.Object == callback {
+			return nil
+		}
+		handle(event)
+	}
+}
+
+// Close disconnects
+func (c *wlConn) Close() error {
+	return c.conn.Close()
+}
+
+// wlMessage frames a request or event for the wire: the object, then the
+// size and opcode, then the arguments
+func wlMessage(object uint32, opcode uint16, args wlArgs) []byte {
+	message := make([]byte, 8, 8+len(args))
+	binary.LittleEndian.PutUint32(message[0:4], object)
+	binary.LittleEndian.PutUint32(message[4:8], uint32(8+len(args))<<16|uint32(opcode))
+	return append(message, args...)
+}
+
+// wlArgs builds request arguments
+type wlArgs []byte
+
+func (a wlArgs) uint32(v uint32) wlArgs {
+	return binary.LittleEndian.AppendUint32(a, v)
+}
+
+func (a wlArgs) int32(v int32) wlArgs {
+	return a.uint32(uint32(v))
+}
+
+// string appends s NUL terminated and padded to four bytes
+func (a wlArgs) string(s string) wlArgs {
+	a = a.uint32(uint32(len(s) + 1))
+	a = append(a, s...)
+	a = append(a, 0)
+	for len(a)%4 != 0 {
+		a = append(a, 0)
+	}
+	return a
+}
+
+// wlReader reads event arguments in order. Reading past the end gives
+// zeros.
+type wlReader []byte
+
+func (r *wlReader) uint32() uint32 {
+	if len(*r) < 4 {
+		*r = nil
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(*r)
+	*r = (*r)[4:]
+	return v
+}
+
+func (r *wlReader) string() string {
+	size := int(r.uint32())
+	padded := (size + 3) &^ 3
+	if size == 0 || len(*r) < padded {
+		*r = nil
+		return ""
+	}
+	s := string((*r)[:size-1])
+	*r = (*r)[padded:]
+	return s
+}
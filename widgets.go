@@ -0,0 +1,488 @@
+package compositor
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// WidgetKind picks what a widget shows
+type WidgetKind string
+
+const (
+	// WidgetClock shows the time
+	WidgetClock WidgetKind = "clock"
+	// WidgetDate shows the date
+	WidgetDate WidgetKind = "date"
+	// WidgetRSS cycles through the headlines of an RSS or Atom feed
+	WidgetRSS WidgetKind = "rss"
+	// WidgetWeather shows the first line of a plain text weather report,
+	// from wttr.in unless a URL is given
+	WidgetWeather WidgetKind = "weather"
+)
+
+// WidgetLayerName is which side of the windows a widget is drawn on
+type WidgetLayerName string
+
+const (
+	// WidgetBackground widgets are drawn under every window
+	WidgetBackground WidgetLayerName = "background"
+	// WidgetOverlay widgets are drawn over every window
+	WidgetOverlay WidgetLayerName = "overlay"
+)
+
+// widgetAnchors are the places a widget can be anchored to, as the
+// fraction of the free space to its left and above it
+var widgetAnchors = map[string][2]int{
+	"top-left": {0, 0}, "top": {1, 0}, "top-right": {2, 0},
+	"left": {0, 1}, "center": {1, 1}, "right": {2, 1},
+	"bottom-left": {0, 2}, "bottom": {1, 2}, "bottom-right": {2, 2},
+}
+
+// widgetEdges are the anchors on one edge, by the index of that edge in
+// an exclusive zone: top, right, bottom, left
+var widgetEdges = map[string]int{"top": 0, "right": 1, "bottom": 2, "left": 3}
+
+// Widget is one configured widget. Zero fields take the kind's defaults.
+type Widget struct {
+	Kind WidgetKind `json:"kind"`
+	// Format is the Go time layout of a clock or date
+	Format string `json:"format,omitempty"`
+	// URL is the feed, or the weather report in place of wttr.in
+	URL string `json:"url,omitempty"`
+	// Location is the wttr.in weather location; empty guesses from the IP
+	Location string `json:"location,omitempty"`
+	// Interval is how often a feed or weather report is fetched
+	Interval string `json:"interval,omitempty"`
+	// Rotate is how long each headline of a feed shows
+	Rotate string `json:"rotate,omitempty"`
+	// Anchor is the edge or corner the widget sits in, e.g. "top-right";
+	// widgets sharing one are stacked away from the edge by name
+	Anchor string          `json:"anchor,omitempty"`
+	Margin int             `json:"margin,omitempty"`
+	Layer  WidgetLayerName `json:"layer,omitempty"`
+	// Exclusive keeps tiled windows off the widget's edge, as for a panel;
+	// the anchor must be top, bottom, left or right
+	Exclusive bool `json:"exclusive,omitempty"`
+	// Scale is the size of a font pixel in desktop pixels
+	Scale      int         `json:"scale,omitempty"`
+	Color      *[4]float32 `json:"color,omitempty"`
+	Background *[4]float32 `json:"background,omitempty"`
+
+	interval time.Duration
+	rotate   time.Duration
+}
+
+// widgetDefaults fill in a widget's unset fields by kind
+var widgetDefaults = map[WidgetKind]Widget{
+	WidgetClock:   {Format: "15:04", Anchor: "top-right"},
+	WidgetDate:    {Format: "Mon 2 Jan 2006", Anchor: "top-right"},
+	WidgetRSS:     {Anchor: "bottom", interval: 10 * time.Minute, rotate: 8 * time.Second},
+	WidgetWeather: {Anchor: "top-left", interval: 30 * time.Minute},
+}
+
+// fetched reports whether the widget shows something fetched over HTTP
+func (w Widget) fetched() bool {
+	return w.Kind == WidgetRSS || w.Kind == WidgetWeather
+}
+
+// source is the URL a fetched widget reads
+func (w Widget) source() string {
+	if w.URL != "" || w.Kind != WidgetWeather {
+		return w.URL
+	}
+	return "https://wttr.in/" + url.PathEscape(w.Location) + "?format=%l:+%C+%t"
+}
+
+// Widgets is the -widgets flag: widgets by name, given as a JSON object
+type Widgets map[string]Widget
+
+// String returns the widgets as JSON
+func (w *Widgets) String() string {
+	if w == nil || len(*w) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(*w)
+	return string(data)
+}
+
+// Set parses and validates the widgets, replacing the current ones
+func (w *Widgets) Set(value string) error {
+	widgets, err := parseWidgets(value)
+	if err != nil {
+		return err
+	}
+	*w = widgets
+	return nil
+}
+
+// Names returns the widget names, sorted
+func (w Widgets) Names() []string {
+	names := make([]string, 0, len(w))
+	for name := range w {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseWidgets parses a JSON object of widgets, filling in defaults
+func parseWidgets(value string) (Widgets, error) {
+	widgets := Widgets{}
+	if value == "" {
+		return widgets, nil
+	}
+	if err := json.Unmarshal([]byte(value), &widgets); err != nil {
+		return nil, fmt.Errorf("parse widgets: %w", err)
+	}
+	for name, w := range widgets {
+		defaults, ok := widgetDefaults[w.Kind]
+		if !ok {
+			return nil, fmt.Errorf("widget %q: unknown kind %q, want clock, date, rss or weather", name, w.Kind)
+		}
+		if w.Format == "" {
+			w.Format = defaults.Format
+		}
+		if w.Kind == WidgetRSS && w.URL == "" {
+			return nil, fmt.Errorf("widget %q: an rss widget needs a url", name)
+		}
+		if w.URL != "" {
+			if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return nil, fmt.Errorf("widget %q: invalid url %q", name, w.URL)
+			}
+		}
+		var err error
+		if w.interval, err = widgetDuration(w.Interval, defaults.interval); err != nil {
+			return nil, fmt.Errorf("widget %q: invalid interval %q", name, w.Interval)
+		}
+		if w.rotate, err = widgetDuration(w.Rotate, defaults.rotate); err != nil {
+			return nil, fmt.Errorf("widget %q: invalid rotate %q", name, w.Rotate)
+		}
+		if w.Anchor == "" {
+			w.Anchor = defaults.Anchor
+		}
+		if _, ok := widgetAnchors[w.Anchor]; !ok {
+			return nil, fmt.Errorf("widget %q: unknown anchor %q", name, w.Anchor)
+		}
+		if _, ok := widgetEdges[w.Anchor]; w.Exclusive && !ok {
+			return nil, fmt.Errorf("widget %q: only widgets anchored to an edge can be exclusive", name)
+		}
+		if w.Margin == 0 {
+			w.Margin = 16
+		}
+		if w.Layer == "" {
+			w.Layer = WidgetBackground
+		}
+		if w.Layer != WidgetBackground && w.Layer != WidgetOverlay {
+			return nil, fmt.Errorf("widget %q: unknown layer %q, want background or overlay", name, w.Layer)
+		}
+		if w.Scale == 0 {
+			w.Scale = 4
+		}
+		if w.Scale < 1 || w.Scale > 32 {
+			return nil, fmt.Errorf("widget %q: scale must be between 1 and 32", name)
+		}
+		if w.Color == nil {
+			w.Color = &[4]float32{1, 1, 1, 1}
+		}
+		if w.Background == nil {
+			w.Background = &[4]float32{0, 0, 0, 0.5}
+		}
+		widgets[name] = w
+	}
+	return widgets, nil
+}
+
+func widgetDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// widgetFeed is the latest lines fetched for a widget
+type widgetFeed struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (f *widgetFeed) get() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lines
+}
+
+func (f *widgetFeed) set(lines []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lines = lines
+}
+
+// renderedWidget caches a widget's texture until its text changes
+type renderedWidget struct {
+	text    string
+	texture *wayland.Texture
+}
+
+// WidgetLayer draws the widgets into the desktop like surfaces of their
+// own: background widgets under the windows, overlay widgets over them.
+// Feeds are fetched in the background; Place is called from the render
+// loop.
+type WidgetLayer struct {
+	Client *http.Client
+
+	mu       sync.Mutex
+	widgets  Widgets
+	feeds    map[string]*widgetFeed
+	stop     chan struct{}
+	rendered map[string]*renderedWidget
+	surface  *wayland.WlSurface
+	// exclusive is the space exclusive widgets took at the last Place
+	exclusive [4]int
+}
+
+// NewWidgetLayer creates a layer showing widgets and starts fetching
+// their feeds
+func NewWidgetLayer(widgets Widgets) *WidgetLayer {
+	l := &WidgetLayer{
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		rendered: make(map[string]*renderedWidget),
+		surface:  &wayland.WlSurface{},
+	}
+	l.SetWidgets(widgets)
+	return l
+}
+
+// SetWidgets replaces the widgets, restarting the feed fetches
+func (l *WidgetLayer) SetWidgets(widgets Widgets) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stop != nil {
+		close(l.stop)
+	}
+	l.widgets = widgets
+	l.feeds = make(map[string]*widgetFeed)
+	l.stop = make(chan struct{})
+	l.rendered = make(map[string]*renderedWidget)
+	for name, w := range widgets {
+		if w.fetched() {
+			feed := &widgetFeed{}
+			l.feeds[name] = feed
+			go l.fetchLoop(name, w, feed, l.stop)
+		}
+	}
+}
+
+// Close stops fetching feeds
+func (l *WidgetLayer) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+}
+
+func (l *WidgetLayer) fetchLoop(name string, w Widget, feed *widgetFeed, stop chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		lines, err := l.fetch(w)
+		if err != nil {
+			log.Printf("Widget %q: %v", name, err)
+		} else {
+			feed.set(lines)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch reads the lines a fetched widget shows
+func (l *WidgetLayer) fetch(w Widget) ([]string, error) {
+	resp, err := l.Client.Get(w.source())
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", w.source(), resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	if w.Kind == WidgetRSS {
+		return parseFeedTitles(data)
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	return []string{strings.TrimSpace(line)}, nil
+}
+
+// parseFeedTitles returns the item titles of an RSS feed or the entry
+// titles of an Atom feed
+func parseFeedTitles(data []byte) ([]string, error) {
+	var feed struct {
+		Items []struct {
+			Title string `xml:"title"`
+		} `xml:"channel>item"`
+		Entries []struct {
+			Title string `xml:"title"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		return nil, fmt.Errorf("parse feed: %w", err)
+	}
+	var titles []string
+	for _, item := range append(feed.Items, feed.Entries...) {
+		if title := strings.Join(strings.Fields(item.Title), " "); title != "" {
+			titles = append(titles, title)
+		}
+	}
+	if len(titles) == 0 {
+		return nil, fmt.Errorf("feed has no titles")
+	}
+	return titles, nil
+}
+
+// Texts returns what each widget shows at now
+func (l *WidgetLayer) Texts(now time.Time) map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	texts := make(map[string]string, len(l.widgets))
+	for name, w := range l.widgets {
+		texts[name] = l.text(name, w, now)
+	}
+	return texts
+}
+
+func (l *WidgetLayer) text(name string, w Widget, now time.Time) string {
+	switch w.Kind {
+	case WidgetClock, WidgetDate:
+		return now.Format(w.Format)
+	}
+	lines := l.feeds[name].get()
+	if len(lines) == 0 {
+		return "..."
+	}
+	if w.Kind == WidgetRSS {
+		return lines[int(now.UnixNano()/int64(w.rotate))%len(lines)]
+	}
+	return lines[0]
+}
+
+// Place returns the widgets as placed surfaces on a desktop of bounds,
+// the background ones to go under the windows and the overlay ones over
+// them
+func (l *WidgetLayer) Place(bounds image.Rectangle, now time.Time) (below, above []PlacedSurface) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// How far from the edge the next widget at each anchor goes
+	stacked := make(map[string]int)
+	l.exclusive = [4]int{}
+	for i, name := range l.widgets.Names() {
+		w := l.widgets[name]
+		texture := l.render(name, w, now)
+		size := image.Pt(int(texture.Width), int(texture.Height))
+		rect := anchorRect(w.Anchor, size, bounds.Inset(w.Margin), stacked[w.Anchor])
+		stacked[w.Anchor] += size.Y + w.Margin/2
+		if edge, ok := widgetEdges[w.Anchor]; ok && w.Exclusive {
+			zone := []int{
+				rect.Max.Y + w.Margin - bounds.Min.Y, bounds.Max.X - rect.Min.X + w.Margin,
+				bounds.Max.Y - rect.Min.Y + w.Margin, rect.Max.X + w.Margin - bounds.Min.X,
+			}[edge]
+			l.exclusive[edge] = max(l.exclusive[edge], zone)
+		}
+
+		p := PlacedSurface{
+			SurfaceID: protocols.ObjectID[protocols.WlSurface](i + 1),
+			Surface:   l.surface,
+			Texture:   texture,
+			X:         rect.Min.X,
+			Y:         rect.Min.Y,
+			Source:    image.Rect(0, 0, size.X, size.Y),
+			Size:      size,
+		}
+		p.Root = p.SurfaceID
+		if w.Layer == WidgetOverlay {
+			above = append(above, p)
+		} else {
+			below = append(below, p)
+		}
+	}
+	return below, above
+}
+
+// Exclusive returns the space exclusive widgets keep at the top, right,
+// bottom and left edges, as of the last Place
+func (l *WidgetLayer) Exclusive() [4]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.exclusive
+}
+
+// render returns a widget's texture, drawing it again when its text has
+// changed
+func (l *WidgetLayer) render(name string, w Widget, now time.Time) *wayland.Texture {
+	text := l.text(name, w, now)
+	if r, ok := l.rendered[name]; ok && r.text == text {
+		return r.texture
+	}
+	img := renderWidget(text, w.Scale, premultiplied(*w.Color), premultiplied(*w.Background))
+	texture := &wayland.Texture{Stride: uint32(img.Stride), Width: uint32(img.Rect.Dx()), Height: uint32(img.Rect.Dy()), Data: img.Pix}
+	l.rendered[name] = &renderedWidget{text: text, texture: texture}
+	return texture
+}
+
+// renderWidget draws text on its background, padded by two font pixels
+func renderWidget(text string, scale int, fg, bg color.RGBA) *image.RGBA {
+	pad := 2 * scale
+	size := textSize(text, scale)
+	img := image.NewRGBA(image.Rect(0, 0, size.X+2*pad, size.Y+2*pad))
+	fillRect(img, img.Rect, bg)
+	drawText(img, image.Pt(pad, pad), text, scale, fg)
+	return img
+}
+
+// anchorRect places a widget of size at anchor within bounds, offset
+// from the edge it is anchored to by stacked
+func anchorRect(anchor string, size image.Point, bounds image.Rectangle, stacked int) image.Rectangle {
+	a := widgetAnchors[anchor]
+	x := bounds.Min.X + (bounds.Dx()-size.X)*a[0]/2
+	y := bounds.Min.Y + (bounds.Dy()-size.Y)*a[1]/2
+	if a[1] == 2 {
+		y -= stacked
+	} else {
+		y += stacked
+	}
+	return image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x, y).Add(size)}
+}
+
+// premultiplied converts an RGBA color from 0-1 floats
+func premultiplied(c [4]float32) color.RGBA {
+	channel := func(v float32) uint8 {
+		return uint8(min(1, max(0, v)) * 255)
+	}
+	a := min(1, max(0, c[3]))
+	return color.RGBA{R: channel(c[0] * a), G: channel(c[1] * a), B: channel(c[2] * a), A: channel(a)}
+}
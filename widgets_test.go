@@ -0,0 +1,131 @@
+package compositor
+
+import (
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseWidgets(t *testing.T) {
+	widgets, err := parseWidgets(`{"clock": {"kind": "clock"}, "news": {"kind": "rss", "url": "https://example.com/feed", "layer": "overlay"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := widgets["clock"]
+	if clock.Format != "15:04" || clock.Anchor != "top-right" || clock.Layer != WidgetBackground || clock.Scale != 4 {
+		t.Errorf("clock defaults = %+v", clock)
+	}
+	if news := widgets["news"]; news.interval != 10*time.Minute || news.rotate != 8*time.Second || news.Layer != WidgetOverlay {
+		t.Errorf("news = %+v", news)
+	}
+
+	for _, bad := range []string{
+		`{"a": {"kind": "stocks"}}`,
+		`{"a": {"kind": "rss"}}`,
+		`{"a": {"kind": "weather", "url": "ftp://example.com"}}`,
+		`{"a": {"kind": "clock", "anchor": "middle"}}`,
+		`{"a": {"kind": "clock", "layer": "top"}}`,
+		`{"a": {"kind": "rss", "url": "http://x", "interval": "-1s"}}`,
+	} {
+		if _, err := parseWidgets(bad); err == nil {
+			t.Errorf("parseWidgets(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestParseFeedTitles(t *testing.T) {
+	rss := `<rss><channel><title>Feed</title><item><title> First
+	headline </title></item><item><title>Second</title></item></channel></rss>`
+	if got, err := parseFeedTitles([]byte(rss)); err != nil || len(got) != 2 || got[0] != "First headline" {
+		t.Errorf("RSS titles = %q, %v", got, err)
+	}
+	atom := `<feed xmlns="http://www.w3.org/2005/Atom"><title>Feed</title><entry><title>Only</title></entry></feed>`
+	if got, err := parseFeedTitles([]byte(atom)); err != nil || len(got) != 1 || got[0] != "Only" {
+		t.Errorf("Atom titles = %q, %v", got, err)
+	}
+	if _, err := parseFeedTitles([]byte(`<rss></rss>`)); err == nil {
+		t.Error("empty feed parsed")
+	}
+}
+
+func TestWidgetLayerPlace(t *testing.T) {
+	weather := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Oslo: Clear +3°C\n"))
+	}))
+	defer weather.Close()
+
+	widgets, err := parseWidgets(`{
+		"a": {"kind": "clock", "anchor": "bottom-right", "margin": 10, "scale": 1},
+		"b": {"kind": "date", "anchor": "bottom-right", "margin": 10, "scale": 1, "format": "2006"},
+		"c": {"kind": "weather", "url": "` + weather.URL + `", "layer": "overlay"}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewWidgetLayer(widgets)
+	defer l.Close()
+
+	now := time.Date(2026, 3, 1, 9, 5, 0, 0, time.UTC)
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Texts(now)["c"] == "..." && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	texts := l.Texts(now)
+	if texts["a"] != "09:05" || texts["b"] != "2026" || texts["c"] != "Oslo: Clear +3°C" {
+		t.Errorf("texts = %q", texts)
+	}
+
+	below, above := l.Place(image.Rect(0, 0, 800, 600), now)
+	if len(below) != 2 || len(above) != 1 {
+		t.Fatalf("%d widgets below and %d above, want 2 and 1", len(below), len(above))
+	}
+	// "09:05" is 29x7 font pixels, plus 2 of padding all round
+	if got, want := image.Pt(below[0].X, below[0].Y), image.Pt(800-10-33, 600-10-11); got != want {
+		t.Errorf("clock at %v, want %v", got, want)
+	}
+	// The date stacks above the clock in the same corner
+	if below[1].Y >= below[0].Y {
+		t.Errorf("date at y %d, not above the clock at %d", below[1].Y, below[0].Y)
+	}
+	if again, _ := l.Place(image.Rect(0, 0, 800, 600), now); again[0].Texture != below[0].Texture {
+		t.Error("an unchanged widget was drawn again")
+	}
+}
+
+func TestDrawText(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 12, 8))
+	white := color.RGBA{255, 255, 255, 255}
+	drawText(img, image.Pt(0, 0), "I", 1, white)
+	// I's middle column is solid
+	for y := range glyphHeight {
+		if img.RGBAAt(2, y) != white {
+			t.Errorf("pixel 2,%d is not set", y)
+		}
+	}
+	if img.RGBAAt(0, 3) == white {
+		t.Error("pixel 0,3 is set")
+	}
+	if got := textSize("ab", 2); got != image.Pt(22, 14) {
+		t.Errorf("textSize = %v", got)
+	}
+}
+
+func TestWidgetExclusive(t *testing.T) {
+	if _, err := parseWidgets(`{"a": {"kind": "clock", "anchor": "top-left", "exclusive": true}}`); err == nil {
+		t.Error("a corner widget was made exclusive")
+	}
+	widgets, err := parseWidgets(`{"bar": {"kind": "clock", "anchor": "top", "margin": 4, "scale": 1, "exclusive": true}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewWidgetLayer(widgets)
+	defer l.Close()
+	l.Place(image.Rect(0, 0, 800, 600), time.Now())
+	// 11 pixels tall with padding, 4 from the edge and 4 below
+	if got, want := l.Exclusive(), [4]int{19, 0, 0, 0}; got != want {
+		t.Errorf("Exclusive = %v, want %v", got, want)
+	}
+}
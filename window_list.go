@@ -0,0 +1,122 @@
+package compositor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"time"
+
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+)
+
+const (
+	// windowThumbnailSize bounds the longer side of a window thumbnail
+	windowThumbnailSize = 160
+	// windowThumbnailInterval is how often thumbnails are made again
+	windowThumbnailInterval = time.Second
+	// windowThumbnailQuality is the JPEG quality of thumbnails
+	windowThumbnailQuality = 70
+)
+
+// WindowList describes the windows on the desktop for viewers, keeping
+// thumbnails between the times they are made again
+type WindowList struct {
+	thumbnails map[surfaceKey]string
+	refreshed  time.Time
+}
+
+// NewWindowList creates a window list with no thumbnails made yet
+func NewWindowList() *WindowList {
+	return &WindowList{thumbnails: make(map[surfaceKey]string)}
+}
+
+// Describe lists the toplevels in shown, bottom first, with the top one
+// focused. With thumbnails they are made again every
+// windowThumbnailInterval; without, the old ones are forgotten.
+func (l *WindowList) Describe(shown []PlacedSurface, clientID func(*wayland.Client) int, thumbnails bool, now time.Time) []stream.WindowInfo {
+	refresh := thumbnails && now.Sub(l.refreshed) >= windowThumbnailInterval
+	if refresh {
+		l.refreshed = now
+	}
+	if !thumbnails {
+		clear(l.thumbnails)
+		l.refreshed = time.Time{}
+	}
+
+	windows := []stream.WindowInfo{}
+	present := make(map[surfaceKey]bool)
+	for _, p := range shown {
+		role, ok := p.Surface.Role.(*wayland.SurfaceRoleXdgToplevel)
+		if !ok || role.Data == nil {
+			continue
+		}
+		window := stream.WindowInfo{
+			Client:   clientID(p.Client),
+			Toplevel: uint32(*role.Data),
+			X:        p.X,
+			Y:        p.Y,
+			Width:    p.Size.X,
+			Height:   p.Size.Y,
+		}
+		if toplevel := wayland.GetXdgToplevelObject(p.Client, *role.Data); toplevel != nil {
+			window.AppID = toplevel.AppID
+			if toplevel.Title != nil {
+				window.Title = *toplevel.Title
+			}
+		}
+		key := surfaceKey{client: p.Client, id: p.SurfaceID}
+		present[key] = true
+		if thumbnails {
+			if _, made := l.thumbnails[key]; refresh || !made {
+				l.thumbnails[key] = windowThumbnail(p)
+			}
+			window.Thumbnail = l.thumbnails[key]
+		}
+		windows = append(windows, window)
+	}
+	if len(windows) > 0 {
+		windows[len(windows)-1].Focused = true
+	}
+	for key := range l.thumbnails {
+		if !present[key] {
+			delete(l.thumbnails, key)
+		}
+	}
+	return windows
+}
+
+// windowThumbnail shrinks the shown part of a window's buffer to fit in
+// windowThumbnailSize and encodes it as a JPEG data: URL. Windows with no
+// buffer have none.
+func windowThumbnail(p PlacedSurface) string {
+	if p.Texture == nil || p.Source.Empty() {
+		return ""
+	}
+	fit := fitRect(p.Source.Size(), image.Rect(0, 0, windowThumbnailSize, windowThumbnailSize)).Size()
+	if fit.X <= 0 || fit.Y <= 0 {
+		return ""
+	}
+	thumb := image.NewRGBA(image.Rectangle{Max: fit})
+	stride := int(p.Texture.Stride)
+	for y := range fit.Y {
+		sy := p.Source.Min.Y + y*p.Source.Dy()/fit.Y
+		for x := range fit.X {
+			sx := p.Source.Min.X + x*p.Source.Dx()/fit.X
+			from := sy*stride + sx*4
+			if from+4 > len(p.Texture.Data) {
+				continue
+			}
+			to := y*thumb.Stride + x*4
+			copy(thumb.Pix[to:to+4], p.Texture.Data[from:from+4])
+			// JPEG has no alpha; show translucent windows solid
+			thumb.Pix[to+3] = 255
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: windowThumbnailQuality}); err != nil {
+		return ""
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,142 @@
+//go:build !noweb
+
+package compositor
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mmulet/pupapppupps/stream"
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+func placedToplevel(c *wayland.Client, surfaceID, toplevelID uint32, x int) PlacedSurface {
+	toplevel := protocols.ObjectID[protocols.XdgToplevel](toplevelID)
+	texture := &wayland.Texture{Width: 40, Height: 20, Stride: 160, Data: make([]byte, 40*20*4)}
+	return PlacedSurface{
+		Client:    c,
+		SurfaceID: protocols.ObjectID[protocols.WlSurface](surfaceID),
+		Surface:   &wayland.WlSurface{Role: &wayland.SurfaceRoleXdgToplevel{Data: &toplevel}},
+		Texture:   texture,
+		X:         x,
+		Source:    image.Rect(0, 0, 40, 20),
+		Size:      image.Pt(40, 20),
+	}
+}
+
+func TestWindowListDescribe(t *testing.T) {
+	c := wayland.MakeClient(nil)
+	cursor := PlacedSurface{Client: c, SurfaceID: 9, Surface: &wayland.WlSurface{}}
+	shown := []PlacedSurface{placedToplevel(c, 7, 3, 0), cursor, placedToplevel(c, 8, 4, 50)}
+	clientID := func(*wayland.Client) int { return 2 }
+	l := NewWindowList()
+	now := time.Now()
+
+	windows := l.Describe(shown, clientID, false, now)
+	if len(windows) != 2 || windows[0].Toplevel != 3 || windows[1].Toplevel != 4 || windows[1].X != 50 {
+		t.Fatalf("Expected toplevels 3 and 4 bottom first, got %+v", windows)
+	}
+	if windows[0].Focused || !windows[1].Focused || windows[0].Client != 2 {
+		t.Errorf("Expected the top window focused, got %+v", windows)
+	}
+	if windows[0].Thumbnail != "" || windows[0].Width != 40 || windows[0].Height != 20 {
+		t.Errorf("Expected a 40x20 window without a thumbnail, got %+v", windows[0])
+	}
+
+	windows = l.Describe(shown, clientID, true, now)
+	thumbnail := windows[0].Thumbnail
+	if !strings.HasPrefix(thumbnail, "data:image/jpeg;base64,") {
+		t.Fatalf("Expected a JPEG thumbnail, got %q", thumbnail)
+	}
+	// Thumbnails are kept until it is time to make them again
+	shown[0].Texture.Data[0] = 255
+	if again := l.Describe(shown, clientID, true, now.Add(time.Millisecond)); again[0].Thumbnail != thumbnail {
+		t.Error("Thumbnail was made again too soon")
+	}
+	if later := l.Describe(shown, clientID, true, now.Add(windowThumbnailInterval)); later[0].Thumbnail == thumbnail {
+		t.Error("Thumbnail was not made again")
+	}
+}
+
+func TestWindowListOverWebSocket(t *testing.T) {
+	s := stream.NewWebSocketServer()
+	actions := make(chan string, 1)
+	s.SetWindowActionHandler(func(action string, client int, toplevel uint32) {
+		actions <- fmt.Sprintf("%s %d %d", action, client, toplevel)
+	})
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if list, _ := s.WindowsWanted(); list {
+		t.Error("No viewer asked for the window list")
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"windows"}`)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list, thumbnails := s.WindowsWanted()
+		if list && !thumbnails {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The viewer's subscription never arrived")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The list goes out without a frame, without thumbnails, and only
+	// when it changed
+	readList := func() stream.WindowInfo {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Type    string              `json:"type"`
+			Windows []stream.WindowInfo `json:"windows"`
+		}
+		if err := json.Unmarshal(message, &got); err != nil || got.Type != "windows" || len(got.Windows) != 1 {
+			t.Fatalf("Expected a window list, got %q (%v)", message, err)
+		}
+		return got.Windows[0]
+	}
+	windows := []stream.WindowInfo{{Client: 1, Toplevel: 3, AppID: "foot", Thumbnail: "data:"}}
+	s.PublishWindows(windows)
+	if got := readList(); got.AppID != "foot" || got.Thumbnail != "" {
+		t.Errorf("Expected foot without a thumbnail, got %+v", got)
+	}
+	s.PublishWindows(windows)
+	windows[0].Title = "~"
+	s.PublishWindows(windows)
+	if got := readList(); got.Title != "~" {
+		t.Errorf("Expected the renamed window next, got %+v", got)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"focus","client":1,"toplevel":3}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case action := <-actions:
+		if action != "focus 1 3" {
+			t.Errorf("Expected focus 1 3, got %q", action)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The focus request never arrived")
+	}
+}
@@ -0,0 +1,193 @@
+package compositor
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/mmulet/term.everything/wayland"
+)
+
+// WindowActions are what a window rule does to the windows it matches
+// when they first appear
+type WindowActions struct {
+	// Geometry is where the window floats, taking the place of the
+	// cascade for an app with no saved geometry
+	Geometry *WindowGeometry `json:"geometry,omitempty"`
+	// Workspace is the workspace the window opens on, 0 for the current
+	// one
+	Workspace int `json:"workspace,omitempty"`
+	// Floating keeps the window at its floating geometry in every layout,
+	// or when false fills its area in the floating layout too
+	Floating *bool `json:"floating,omitempty"`
+	// Decorations, when false, leaves the window out of the grid and auto
+	// layouts' cell labels and borders
+	Decorations *bool `json:"decorations,omitempty"`
+	// AlwaysOnTop stacks the window above the others
+	AlwaysOnTop bool `json:"always_on_top,omitempty"`
+	// DecorationMode is the xdg-decoration mode the window gets whatever
+	// it asks for
+	DecorationMode DecorationMode `json:"decoration_mode,omitempty"`
+}
+
+// merge lays the actions set in other over a
+func (a WindowActions) merge(other WindowActions) WindowActions {
+	if other.Geometry != nil {
+		a.Geometry = other.Geometry
+	}
+	if other.Workspace != 0 {
+		a.Workspace = other.Workspace
+	}
+	if other.Floating != nil {
+		a.Floating = other.Floating
+	}
+	if other.Decorations != nil {
+		a.Decorations = other.Decorations
+	}
+	a.AlwaysOnTop = a.AlwaysOnTop || other.AlwaysOnTop
+	if other.DecorationMode != "" {
+		a.DecorationMode = other.DecorationMode
+	}
+	return a
+}
+
+// WindowRule applies actions to the Wayland toplevels whose app id and
+// title match its regular expressions; one left out matches anything
+type WindowRule struct {
+	AppID string `json:"app_id,omitempty"`
+	Title string `json:"title,omitempty"`
+	WindowActions
+
+	appID *regexp.Regexp
+	title *regexp.Regexp
+}
+
+// matches reports whether the rule applies to a window
+func (r *WindowRule) matches(appID, title string) bool {
+	return (r.appID == nil || r.appID.MatchString(appID)) &&
+		(r.title == nil || r.title.MatchString(title))
+}
+
+// WindowRules is the -window-rules flag: rules in the order they are
+// applied, given as a JSON array, later rules winning where they disagree
+type WindowRules []WindowRule
+
+// String returns the rules as JSON
+func (r *WindowRules) String() string {
+	if r == nil || len(*r) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(*r)
+	return string(data)
+}
+
+// Set parses the rules, replacing the current ones
+func (r *WindowRules) Set(value string) error {
+	rules, err := parseWindowRules(value)
+	if err != nil {
+		return err
+	}
+	*r = rules
+	return nil
+}
+
+// parseWindowRules parses a JSON array of rules and compiles their
+// regular expressions
+func parseWindowRules(value string) (WindowRules, error) {
+	rules := WindowRules{}
+	if value == "" {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("parse window rules: %w", err)
+	}
+	for i := range rules {
+		r := &rules[i]
+		var err error
+		if r.AppID != "" {
+			if r.appID, err = regexp.Compile(r.AppID); err != nil {
+				return nil, fmt.Errorf("window rule %d: app_id: %w", i+1, err)
+			}
+		}
+		if r.Title != "" {
+			if r.title, err = regexp.Compile(r.Title); err != nil {
+				return nil, fmt.Errorf("window rule %d: title: %w", i+1, err)
+			}
+		}
+		if r.Workspace < 0 || r.Workspace > maxWorkspaces {
+			return nil, fmt.Errorf("window rule %d: workspace must be between 1 and %d", i+1, maxWorkspaces)
+		}
+		if g := r.Geometry; g != nil && (g.Width < 1 || g.Height < 1) {
+			return nil, fmt.Errorf("window rule %d: geometry needs a width and height", i+1)
+		}
+		if _, err := parseDecorationMode(string(r.DecorationMode)); err != nil {
+			return nil, fmt.Errorf("window rule %d: %w", i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+// WindowRuleSet holds the rules the layout, workspaces and window stack
+// consult as windows appear. A nil set matches nothing.
+type WindowRuleSet struct {
+	mu    sync.Mutex
+	rules WindowRules
+}
+
+// NewWindowRuleSet creates a set of rules
+func NewWindowRuleSet(rules WindowRules) *WindowRuleSet {
+	return &WindowRuleSet{rules: rules}
+}
+
+// SetRules replaces the rules; windows already open keep what the old
+// ones did
+func (s *WindowRuleSet) SetRules(rules WindowRules) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+}
+
+// Rules returns the rules
+func (s *WindowRuleSet) Rules() WindowRules {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rules
+}
+
+// Match returns the actions of every rule matching a window, merged in
+// order
+func (s *WindowRuleSet) Match(appID, title string) WindowActions {
+	var actions WindowActions
+	if s == nil {
+		return actions
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.rules {
+		if s.rules[i].matches(appID, title) {
+			actions = actions.merge(s.rules[i].WindowActions)
+		}
+	}
+	return actions
+}
+
+// MatchToplevel returns the actions for a placed toplevel root by its app
+// id and title
+func (s *WindowRuleSet) MatchToplevel(p PlacedSurface) WindowActions {
+	if s == nil {
+		return WindowActions{}
+	}
+	role, ok := p.Surface.Role.(*wayland.SurfaceRoleXdgToplevel)
+	if !ok || role.Data == nil {
+		return WindowActions{}
+	}
+	var appID, title string
+	if toplevel := wayland.GetXdgToplevelObject(p.Client, *role.Data); toplevel != nil {
+		appID = toplevel.AppID
+		if toplevel.Title != nil {
+			title = *toplevel.Title
+		}
+	}
+	return s.Match(appID, title)
+}
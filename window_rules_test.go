@@ -0,0 +1,48 @@
+package compositor
+
+import "testing"
+
+func TestParseWindowRules(t *testing.T) {
+	rules, err := parseWindowRules(`[
+		{"app_id": "^mpv$", "geometry": {"x": 10, "y": 20, "width": 640, "height": 360}, "floating": true},
+		{"title": "Picture-in-Picture", "always_on_top": true, "decorations": false},
+		{"app_id": "mpv", "workspace": 2, "decoration_mode": "client"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := NewWindowRuleSet(rules)
+
+	got := set.Match("mpv", "Picture-in-Picture")
+	if got.Geometry == nil || *got.Geometry != (WindowGeometry{X: 10, Y: 20, Width: 640, Height: 360}) {
+		t.Errorf("Expected the first rule's geometry, got %+v", got.Geometry)
+	}
+	if got.Floating == nil || !*got.Floating || !got.AlwaysOnTop || got.Workspace != 2 {
+		t.Errorf("Expected every matching rule applied, got %+v", got)
+	}
+	if got.Decorations == nil || *got.Decorations || got.DecorationMode != DecorationClient {
+		t.Errorf("Expected decorations off and drawn by the client, got %v, %q", got.Decorations, got.DecorationMode)
+	}
+	if got := set.Match("mpv-helper", ""); got.Geometry != nil || got.Workspace != 2 {
+		t.Errorf("Expected only the unanchored rule to match mpv-helper, got %+v", got)
+	}
+	if got := set.Match("chromium", "News"); got != (WindowActions{}) {
+		t.Errorf("Expected no actions for chromium, got %+v", got)
+	}
+	if got := (*WindowRuleSet)(nil).Match("mpv", ""); got != (WindowActions{}) {
+		t.Errorf("Expected a nil set to match nothing, got %+v", got)
+	}
+
+	for _, bad := range []string{
+		`{"app_id": "mpv"}`,
+		`[{"app_id": "("}]`,
+		`[{"title": "[a-"}]`,
+		`[{"workspace": -1}]`,
+		`[{"geometry": {"x": 1, "y": 1}}]`,
+		`[{"decoration_mode": "none"}]`,
+	} {
+		if _, err := parseWindowRules(bad); err == nil {
+			t.Errorf("Expected an error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package compositor
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// WindowStack lets windows be raised above the stacking order
+// SurfaceSnapshots.Collect produces. Raised windows stay above the others, most
+// recently raised on top, and windows whose rules keep them on top stay
+// above those; cursors stay above everything.
+type WindowStack struct {
+	// Rules, when set, may keep windows on top
+	Rules *WindowRuleSet
+
+	mu     sync.Mutex
+	next   uint64
+	raised map[surfaceKey]uint64
+	// onTop is whether each toplevel's rules keep it on top, matched when
+	// it first appears
+	onTop map[surfaceKey]bool
+}
+
+// NewWindowStack creates a stack with nothing raised
+func NewWindowStack() *WindowStack {
+	return &WindowStack{raised: make(map[surfaceKey]uint64), onTop: make(map[surfaceKey]bool)}
+}
+
+// Raise puts the window rooted at surfaceID on top
+func (s *WindowStack) Raise(c *wayland.Client, surfaceID protocols.ObjectID[protocols.WlSurface]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	s.raised[surfaceKey{client: c, id: surfaceID}] = s.next
+}
+
+// Apply reorders placed surfaces (bottom first) by their root's place in
+// the stack, keeping each window's surfaces together and in order. Raised
+// windows that are gone are forgotten.
+func (s *WindowStack) Apply(placed []PlacedSurface) []PlacedSurface {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.raised) == 0 && s.Rules == nil {
+		return placed
+	}
+
+	if s.Rules != nil {
+		for _, p := range placed {
+			key := surfaceKey{client: p.Client, id: p.SurfaceID}
+			if _, ok := s.onTop[key]; !ok && isToplevelRoot(p) {
+				s.onTop[key] = s.Rules.MatchToplevel(p).AlwaysOnTop
+			}
+		}
+	}
+
+	present := make(map[surfaceKey]bool)
+	rank := func(p PlacedSurface) uint64 {
+		key := surfaceKey{client: p.Client, id: p.Root}
+		present[key] = true
+		if root := wayland.GetWlSurfaceObject(p.Client, p.Root); root != nil {
+			if _, isCursor := root.Role.(*wayland.SurfaceRoleCursor); isCursor {
+				return ^uint64(0)
+			}
+		}
+		if s.onTop[key] {
+			return 1<<63 | s.raised[key]
+		}
+		return s.raised[key]
+	}
+
+	ranks := make([]uint64, len(placed))
+	for i, p := range placed {
+		ranks[i] = rank(p)
+	}
+	order := make([]int, len(placed))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return ranks[order[i]] < ranks[order[j]] })
+
+	stacked := make([]PlacedSurface, len(placed))
+	for i, from := range order {
+		stacked[i] = placed[from]
+	}
+
+	for key := range s.raised {
+		if !present[key] {
+			delete(s.raised, key)
+		}
+	}
+	for key := range s.onTop {
+		if !present[key] {
+			delete(s.onTop, key)
+		}
+	}
+	return stacked
+}
@@ -0,0 +1,232 @@
+package compositor
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxWorkspaces caps -workspaces; the shortcuts can reach any of them
+const maxWorkspaces = 16
+
+// workspaceRegion is the part of the desktop a workspace is drawn in: x,
+// y, width and height as fractions of the desktop
+type workspaceRegion [4]float64
+
+// parseWorkspaceRegions parses "x,y,w,h;x,y,w,h;..." with one region per
+// workspace
+func parseWorkspaceRegions(value string) ([]workspaceRegion, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var regions []workspaceRegion
+	for i, part := range strings.Split(value, ";") {
+		fields := strings.Split(part, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("workspace region %d: want x,y,width,height, got %q", i+1, part)
+		}
+		var r workspaceRegion
+		for j, field := range fields {
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil || v < 0 || v > 1 {
+				return nil, fmt.Errorf("workspace region %d: %q is not a fraction between 0 and 1", i+1, field)
+			}
+			r[j] = v
+		}
+		if r[2] == 0 || r[3] == 0 || r[0]+r[2] > 1 || r[1]+r[3] > 1 {
+			return nil, fmt.Errorf("workspace region %d: %q does not fit on the desktop", i+1, part)
+		}
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
+
+// Workspaces splits the Wayland toplevels into numbered sets, only one of
+// which is shown at a time. With regions every workspace is shown at
+// once, each in its own part of the desktop, so it lands on whichever
+// part of the model that part of the texture is mapped to. Workspaces
+// are numbered from 1.
+type Workspaces struct {
+	// Rules, when set, may open windows on another workspace
+	Rules *WindowRuleSet
+
+	mu       sync.Mutex
+	count    int
+	current  int
+	regions  []workspaceRegion
+	assigned map[surfaceKey]int
+	// top is the topmost toplevel of the current workspace at the last
+	// Split
+	top surfaceKey
+}
+
+// NewWorkspaces creates count workspaces, showing the first. regions is
+// empty or has one region per workspace.
+func NewWorkspaces(count int, regions []workspaceRegion) (*Workspaces, error) {
+	if count < 1 || count > maxWorkspaces {
+		return nil, fmt.Errorf("workspace count must be between 1 and %d", maxWorkspaces)
+	}
+	if len(regions) != 0 && len(regions) != count {
+		return nil, fmt.Errorf("got %d workspace regions for %d workspaces", len(regions), count)
+	}
+	return &Workspaces{count: count, current: 1, regions: regions, assigned: make(map[surfaceKey]int)}, nil
+}
+
+// Count returns the number of workspaces
+func (w *Workspaces) Count() int {
+	return w.count
+}
+
+// Current returns the workspace shown, or new windows open on with regions
+func (w *Workspaces) Current() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Regions returns the workspace regions, empty when there are none
+func (w *Workspaces) Regions() []workspaceRegion {
+	return w.regions
+}
+
+// Switch shows workspace n
+func (w *Workspaces) Switch(n int) error {
+	if n < 1 || n > w.count {
+		return fmt.Errorf("no workspace %d, there are %d", n, w.count)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current = n
+	return nil
+}
+
+// Step switches delta workspaces on, wrapping around, and returns the
+// workspace now shown
+func (w *Workspaces) Step(delta int) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current = w.wrap(w.current + delta)
+	return w.current
+}
+
+func (w *Workspaces) wrap(n int) int {
+	return ((n-1)%w.count+w.count)%w.count + 1
+}
+
+// Move puts a toplevel on workspace n
+func (w *Workspaces) Move(key surfaceKey, n int) error {
+	if n < 1 || n > w.count {
+		return fmt.Errorf("no workspace %d, there are %d", n, w.count)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.assigned[key]; !ok {
+		return fmt.Errorf("toplevel surface %d has not been shown yet", key.id)
+	}
+	w.assigned[key] = n
+	return nil
+}
+
+// MoveTop moves the topmost window of the current workspace delta
+// workspaces on and follows it there
+func (w *Workspaces) MoveTop(delta int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := w.wrap(w.current + delta)
+	if _, ok := w.assigned[w.top]; ok {
+		w.assigned[w.top] = n
+	}
+	w.current = n
+}
+
+// Top returns the topmost toplevel of the current workspace, as of the
+// last Split
+func (w *Workspaces) Top() (surfaceKey, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.assigned[w.top]
+	return w.top, ok
+}
+
+// Assign puts toplevels not seen before on the workspace their rules
+// give, or the current one, and forgets closed ones. Call it from the
+// render loop.
+func (w *Workspaces) Assign(placed []PlacedSurface) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	open := make(map[surfaceKey]bool)
+	for _, p := range placed {
+		if !isToplevelRoot(p) {
+			continue
+		}
+		key := surfaceKey{client: p.Client, id: p.SurfaceID}
+		open[key] = true
+		if _, ok := w.assigned[key]; !ok {
+			n := w.Rules.MatchToplevel(p).Workspace
+			if n < 1 || n > w.count {
+				n = w.current
+			}
+			w.assigned[key] = n
+		}
+	}
+	for key := range w.assigned {
+		if !open[key] {
+			delete(w.assigned, key)
+		}
+	}
+}
+
+// Of returns the workspace a toplevel is on
+func (w *Workspaces) Of(key surfaceKey) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n, ok := w.assigned[key]; ok {
+		return n
+	}
+	return w.current
+}
+
+// Shown reports whether a toplevel is on screen
+func (w *Workspaces) Shown(key surfaceKey) bool {
+	if len(w.regions) != 0 {
+		return true
+	}
+	return w.Of(key) == w.Current()
+}
+
+// Bounds returns the part of desktop workspace n is laid out in
+func (w *Workspaces) Bounds(n int, desktop image.Rectangle) image.Rectangle {
+	if len(w.regions) == 0 || n < 1 || n > len(w.regions) {
+		return desktop
+	}
+	r := w.regions[n-1]
+	dx, dy := float64(desktop.Dx()), float64(desktop.Dy())
+	return image.Rect(
+		desktop.Min.X+int(r[0]*dx), desktop.Min.Y+int(r[1]*dy),
+		desktop.Min.X+int((r[0]+r[2])*dx), desktop.Min.Y+int((r[1]+r[3])*dy),
+	)
+}
+
+// Split divides placed (bottom first) into the surfaces on screen and
+// those on other workspaces. Surfaces that are not part of a Wayland
+// toplevel, such as X11 windows, are always shown.
+func (w *Workspaces) Split(placed []PlacedSurface) (shown, away []PlacedSurface) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.top = surfaceKey{}
+	for _, p := range placed {
+		key := surfaceKey{client: p.Client, id: p.Root}
+		n, ok := w.assigned[key]
+		if ok && len(w.regions) == 0 && n != w.current {
+			away = append(away, p)
+			continue
+		}
+		if ok && n == w.current {
+			w.top = key
+		}
+		shown = append(shown, p)
+	}
+	return shown, away
+}
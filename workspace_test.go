@@ -0,0 +1,81 @@
+package compositor
+
+import (
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+func TestParseWorkspaceRegions(t *testing.T) {
+	regions, err := parseWorkspaceRegions("0,0,0.5,1; 0.5,0,0.5,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []workspaceRegion{{0, 0, 0.5, 1}, {0.5, 0, 0.5, 1}}
+	if len(regions) != len(want) || regions[0] != want[0] || regions[1] != want[1] {
+		t.Errorf("regions = %v, want %v", regions, want)
+	}
+	for _, bad := range []string{"0,0,1", "0,0,1,x", "0.5,0,0.6,1", "0,0,0,1"} {
+		if _, err := parseWorkspaceRegions(bad); err == nil {
+			t.Errorf("parseWorkspaceRegions(%q) succeeded", bad)
+		}
+	}
+	if _, err := NewWorkspaces(3, want); err == nil {
+		t.Error("NewWorkspaces accepted 2 regions for 3 workspaces")
+	}
+}
+
+func TestWorkspaceBounds(t *testing.T) {
+	ws, _ := NewWorkspaces(2, []workspaceRegion{{0, 0, 0.5, 1}, {0.5, 0.5, 0.5, 0.5}})
+	desktop := image.Rect(0, 0, 1200, 800)
+	if got, want := ws.Bounds(2, desktop), image.Rect(600, 400, 1200, 800); got != want {
+		t.Errorf("Bounds(2) = %v, want %v", got, want)
+	}
+	plain, _ := NewWorkspaces(2, nil)
+	if got := plain.Bounds(2, desktop); got != desktop {
+		t.Errorf("Bounds(2) without regions = %v, want the desktop", got)
+	}
+}
+
+// workspaceToplevels returns n placed toplevel roots, bottom first
+func workspaceToplevels(n int) []PlacedSurface {
+	id := protocols.ObjectID[protocols.XdgToplevel](1)
+	placed := make([]PlacedSurface, n)
+	for i := range placed {
+		placed[i].SurfaceID = protocols.ObjectID[protocols.WlSurface](i + 1)
+		placed[i].Root = placed[i].SurfaceID
+		placed[i].Surface = &wayland.WlSurface{Role: &wayland.SurfaceRoleXdgToplevel{Data: &id}}
+	}
+	return placed
+}
+
+func TestWorkspaceSplit(t *testing.T) {
+	ws, _ := NewWorkspaces(2, nil)
+	placed := workspaceToplevels(2)
+	ws.Assign(placed[:1])
+	ws.Switch(2)
+	ws.Assign(placed)
+
+	shown, away := ws.Split(placed)
+	if len(shown) != 1 || shown[0].SurfaceID != 2 || len(away) != 1 {
+		t.Fatalf("on workspace 2: shown %v, away %v", shown, away)
+	}
+	// The top window follows to workspace 1
+	ws.MoveTop(-1)
+	if ws.Current() != 1 || ws.Of(surfaceKey{id: 2}) != 1 {
+		t.Errorf("after MoveTop: current %d, window on %d", ws.Current(), ws.Of(surfaceKey{id: 2}))
+	}
+	if shown, away = ws.Split(placed); len(shown) != 2 || len(away) != 0 {
+		t.Errorf("on workspace 1: %d shown, %d away", len(shown), len(away))
+	}
+	if err := ws.Move(surfaceKey{id: 9}, 2); err == nil {
+		t.Error("Move of an unknown window succeeded")
+	}
+
+	ws.Assign(placed[1:])
+	if err := ws.Move(surfaceKey{id: 1}, 2); err == nil {
+		t.Error("Move of a closed window succeeded")
+	}
+}
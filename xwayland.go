@@ -0,0 +1,257 @@
+package compositor
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// xwaylandStartTimeout bounds how long Xwayland may take to report its
+// display number
+const xwaylandStartTimeout = 10 * time.Second
+
+// Xwayland is a rootless Xwayland server. It talks Wayland to the
+// compositor over a private socket pair, so its client is known up front,
+// and its window manager connection is handed over with -wm.
+type Xwayland struct {
+	// Display is the X11 DISPLAY name, set once the server is ready
+	Display string
+	// Client is Xwayland's own Wayland connection
+	Client *wayland.Client
+	// WM is the window manager, set once the server is ready
+	WM *XWM
+
+	cmd       *exec.Cmd
+	wmConn    net.Conn
+	displayFD *os.File
+}
+
+// StartXwayland spawns Xwayland. The caller must start serving the
+// returned Client before calling Ready, since Xwayland only reports its
+// display after its Wayland setup completes.
+func StartXwayland(binary string) (*Xwayland, error) {
+	wayland.Global_XwaylandShellV1.Delegate = &xwaylandShell{}
+
+	waylandFDs, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("wayland socket pair: %w", err)
+	}
+	wmFDs, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		syscall.Close(waylandFDs[0])
+		syscall.Close(waylandFDs[1])
+		return nil, fmt.Errorf("wm socket pair: %w", err)
+	}
+	displayR, displayW, err := os.Pipe()
+	if err != nil {
+		for _, fd := range append(waylandFDs[:], wmFDs[:]...) {
+			syscall.Close(fd)
+		}
+		return nil, fmt.Errorf("display pipe: %w", err)
+	}
+
+	waylandChild := os.NewFile(uintptr(waylandFDs[1]), "xwayland-wayland")
+	wmChild := os.NewFile(uintptr(wmFDs[1]), "xwayland-wm")
+	defer waylandChild.Close()
+	defer wmChild.Close()
+	defer displayW.Close()
+
+	waylandConn, err := fileConn(waylandFDs[0], "xwayland-wayland")
+	if err != nil {
+		syscall.Close(wmFDs[0])
+		displayR.Close()
+		return nil, err
+	}
+	wmConn, err := fileConn(wmFDs[0], "xwayland-wm")
+	if err != nil {
+		waylandConn.Close()
+		displayR.Close()
+		return nil, err
+	}
+
+	// ExtraFiles start at fd 3
+	cmd := exec.Command(binary, "-rootless", "-wm", "4", "-displayfd", "5")
+	cmd.ExtraFiles = []*os.File{waylandChild, wmChild, displayW}
+	cmd.Env = append(clientEnviron(), "WAYLAND_SOCKET=3")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		waylandConn.Close()
+		wmConn.Close()
+		displayR.Close()
+		return nil, fmt.Errorf("start %s: %w", binary, err)
+	}
+
+	return &Xwayland{
+		Client:    wayland.MakeClient(waylandConn.(*net.UnixConn)),
+		cmd:       cmd,
+		wmConn:    wmConn,
+		displayFD: displayR,
+	}, nil
+}
+
+// Ready waits for Xwayland to report its display number and attaches the
+// window manager
+func (x *Xwayland) Ready() error {
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := bufio.NewReader(x.displayFD).ReadString('\n')
+		done <- result{line, err}
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(xwaylandStartTimeout):
+		x.displayFD.Close()
+		return fmt.Errorf("timed out waiting for Xwayland")
+	}
+	x.displayFD.Close()
+	if r.err != nil {
+		return fmt.Errorf("read display: %w", r.err)
+	}
+	x.Display = ":" + strings.TrimSpace(r.line)
+
+	wm, err := NewXWM(x.wmConn)
+	if err != nil {
+		return fmt.Errorf("window manager: %w", err)
+	}
+	x.WM = wm
+	go func() {
+		if err := wm.Run(); err != nil {
+			log.Printf("Xwayland window manager stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops the window manager and the Xwayland server
+func (x *Xwayland) Close() {
+	x.wmConn.Close()
+	if x.cmd.Process != nil {
+		x.cmd.Process.Kill()
+		x.cmd.Wait()
+	}
+}
+
+// fileConn wraps one end of a socket pair in a net.Conn
+func fileConn(fd int, name string) (net.Conn, error) {
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return conn, nil
+}
+
+// xwaylandShell replaces the wayland package's xwayland_shell_v1, whose
+// set_serial is a stub, so Xwayland surfaces record the serial the window
+// manager matches against WL_SURFACE_SERIAL
+type xwaylandShell struct{}
+
+func (x *xwaylandShell) XwaylandShellV1_destroy(
+	s protocols.ClientState,
+	object_id protocols.ObjectID[protocols.XwaylandShellV1],
+) bool {
+	return true
+}
+
+func (x *xwaylandShell) XwaylandShellV1_get_xwayland_surface(
+	s protocols.ClientState,
+	object_id protocols.ObjectID[protocols.XwaylandShellV1],
+	id protocols.ObjectID[protocols.XwaylandSurfaceV1],
+	surface_id protocols.ObjectID[protocols.WlSurface],
+) {
+	surface := wayland.GetWlSurfaceObject(s, surface_id)
+	if surface == nil {
+		wayland.SendError(s, object_id, protocols.XwaylandShellV1Error_enum_role, "surface not found")
+		return
+	}
+	if surface.Role == nil {
+		surface.Role = &wayland.SurfaceRoleXWaylandSurface{}
+	}
+	role, ok := surface.Role.(*wayland.SurfaceRoleXWaylandSurface)
+	if !ok || role.Data != nil {
+		wayland.SendError(s, object_id, protocols.XwaylandShellV1Error_enum_role, "surface already has a role")
+		return
+	}
+	s.RegisterRoleToSurface(protocols.AnyObjectID(id), surface_id)
+	wayland.AddObject(s, id, &protocols.XwaylandSurfaceV1{Delegate: &xwaylandSurface{}})
+}
+
+func (x *xwaylandShell) OnBind(
+	_ protocols.ClientState,
+	_ protocols.AnyObjectID,
+	_ string,
+	_ protocols.AnyObjectID,
+	_ uint32,
+) {
+}
+
+// xwaylandSurface queues the serial as pending surface state; the wayland
+// package moves it onto the role data on the next commit
+type xwaylandSurface struct{}
+
+func (x *xwaylandSurface) XwaylandSurfaceV1_set_serial(
+	s protocols.ClientState,
+	object_id protocols.ObjectID[protocols.XwaylandSurfaceV1],
+	serial_lo uint32,
+	serial_hi uint32,
+) {
+	surfaceID := s.GetSurfaceIDFromRole(protocols.AnyObjectID(object_id))
+	if surfaceID == nil {
+		return
+	}
+	surface := wayland.GetWlSurfaceObject(s, *surfaceID)
+	if surface == nil {
+		return
+	}
+	if role, ok := surface.Role.(*wayland.SurfaceRoleXWaylandSurface); ok && role.Data != nil && role.Data.Serial != nil {
+		wayland.SendError(s, object_id, protocols.XwaylandSurfaceV1Error_enum_already_associated, "surface already has a serial")
+		return
+	}
+	surface.PendingUpdate.XwaylandSurfarfaceV1Serial = &wayland.XWaylandSurfaceV1Serial{Low: serial_lo, Hi: serial_hi}
+}
+
+func (x *xwaylandSurface) XwaylandSurfaceV1_destroy(
+	s protocols.ClientState,
+	object_id protocols.ObjectID[protocols.XwaylandSurfaceV1],
+) bool {
+	s.UnregisterRoleToSurface(protocols.AnyObjectID(object_id))
+	return true
+}
+
+func (x *xwaylandSurface) OnBind(
+	_ protocols.ClientState,
+	_ protocols.AnyObjectID,
+	_ string,
+	_ protocols.AnyObjectID,
+	_ uint32,
+) {
+}
+
+// xwaylandSerial returns the serial an Xwayland surface was associated with
+func xwaylandSerial(surface *wayland.WlSurface) (uint64, bool) {
+	if surface == nil {
+		return 0, false
+	}
+	role, ok := surface.Role.(*wayland.SurfaceRoleXWaylandSurface)
+	if !ok || role.Data == nil || role.Data.Serial == nil {
+		return 0, false
+	}
+	return uint64(role.Data.Serial.Hi)<<32 | uint64(role.Data.Serial.Low), true
+}
@@ -0,0 +1,426 @@
+package compositor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// X11 core protocol requests used by the window manager
+const (
+	x11ChangeWindowAttributes = 2
+	x11MapWindow              = 8
+	x11ConfigureWindow        = 12
+	x11InternAtom             = 16
+	x11SetInputFocus          = 42
+)
+
+// X11 core protocol events handled by the window manager
+const (
+	x11Error            = 0
+	x11Reply            = 1
+	x11CreateNotify     = 16
+	x11DestroyNotify    = 17
+	x11UnmapNotify      = 18
+	x11MapNotify        = 19
+	x11MapRequest       = 20
+	x11ConfigureNotify  = 22
+	x11ConfigureRequest = 23
+	x11ClientMessage    = 33
+	x11GenericEvent     = 35
+)
+
+const (
+	x11CWEventMask                = 1 << 11
+	x11SubstructureNotifyMask     = 1 << 19
+	x11SubstructureRedirectMask   = 1 << 20
+	x11ConfigWindowStackMode      = 1 << 6
+	x11StackModeAbove             = 0
+	x11RevertToPointerRoot        = 1
+	x11ConfigureRequestValueCount = 7
+)
+
+// xwmWindow is a top-level X11 window as the window manager sees it
+type xwmWindow struct {
+	id               uint32
+	bounds           image.Rectangle
+	overrideRedirect bool
+	mapped           bool
+
+	// serial comes from WL_SURFACE_SERIAL (xwayland-shell)
+	serial    uint64
+	hasSerial bool
+	// surfaceID comes from WL_SURFACE_ID, sent by Xwaylands without
+	// xwayland-shell
+	surfaceID protocols.ObjectID[protocols.WlSurface]
+}
+
+// XWM is a minimal X11 window manager for rootless Xwayland. It maps
+// windows when asked, grants their configure requests, keeps the stacking
+// order, focuses the window that was mapped or clicked last, and pairs
+// windows with their Wayland surfaces so they can be composited.
+type XWM struct {
+	conn io.ReadWriteCloser
+	root uint32
+
+	atomSurfaceID     uint32
+	atomSurfaceSerial uint32
+
+	mu      sync.Mutex
+	windows map[uint32]*xwmWindow
+	// stack lists mapped windows, bottom first
+	stack   []uint32
+	focused uint32
+}
+
+// NewXWM performs the X11 connection setup on conn and becomes the window
+// manager of the first screen
+func NewXWM(conn io.ReadWriteCloser) (*XWM, error) {
+	wm := &XWM{conn: conn, windows: make(map[uint32]*xwmWindow)}
+
+	// Little endian, protocol 11.0, no authorization: the -wm socket is
+	// already trusted
+	setup := []byte{'l', 0, 11, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if _, err := conn.Write(setup); err != nil {
+		return nil, fmt.Errorf("send setup: %w", err)
+	}
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("read setup: %w", err)
+	}
+	extra := make([]byte, int(binary.LittleEndian.Uint16(header[6:]))*4)
+	if _, err := io.ReadFull(conn, extra); err != nil {
+		return nil, fmt.Errorf("read setup: %w", err)
+	}
+	if header[0] != 1 {
+		return nil, fmt.Errorf("setup refused")
+	}
+	root, err := parseSetupRoot(extra)
+	if err != nil {
+		return nil, err
+	}
+	wm.root = root
+
+	if wm.atomSurfaceID, err = wm.internAtom("WL_SURFACE_ID"); err != nil {
+		return nil, err
+	}
+	if wm.atomSurfaceSerial, err = wm.internAtom("WL_SURFACE_SERIAL"); err != nil {
+		return nil, err
+	}
+
+	// Only one client may redirect the root window; an error event
+	// follows if another window manager already does
+	wm.send(x11Request(x11ChangeWindowAttributes, 0, wm.root, x11CWEventMask,
+		x11SubstructureRedirectMask|x11SubstructureNotifyMask))
+	return wm, nil
+}
+
+// parseSetupRoot returns the root window of the first screen from the
+// connection setup reply, minus its 8 byte header
+func parseSetupRoot(data []byte) (uint32, error) {
+	if len(data) < 32 {
+		return 0, fmt.Errorf("short setup reply")
+	}
+	vendorLen := int(binary.LittleEndian.Uint16(data[16:]))
+	screens := data[20]
+	formats := int(data[21])
+	offset := 32 + pad4(vendorLen) + formats*8
+	if screens == 0 || len(data) < offset+4 {
+		return 0, fmt.Errorf("setup reply has no screen")
+	}
+	return binary.LittleEndian.Uint32(data[offset:]), nil
+}
+
+// internAtom looks up an atom, creating it if needed. Only used during
+// setup, before any events are selected.
+func (wm *XWM) internAtom(name string) (uint32, error) {
+	req := []byte{x11InternAtom, 0, 0, 0}
+	req = binary.LittleEndian.AppendUint16(req, uint16(len(name)))
+	req = append(req, 0, 0)
+	req = append(req, name...)
+	req = append(req, make([]byte, pad4(len(name))-len(name))...)
+	binary.LittleEndian.PutUint16(req[2:], uint16(len(req)/4))
+	if _, err := wm.conn.Write(req); err != nil {
+		return 0, fmt.Errorf("intern %s: %w", name, err)
+	}
+	reply := make([]byte, 32)
+	if _, err := io.ReadFull(wm.conn, reply); err != nil {
+		return 0, fmt.Errorf("intern %s: %w", name, err)
+	}
+	if reply[0] != x11Reply {
+		return 0, fmt.Errorf("intern %s: X error %d", name, reply[1])
+	}
+	return binary.LittleEndian.Uint32(reply[8:]), nil
+}
+
+// Run handles X events until the connection closes
+func (wm *XWM) Run() error {
+	event := make([]byte, 32)
+	for {
+		if _, err := io.ReadFull(wm.conn, event); err != nil {
+			return err
+		}
+		// Replies and generic events carry extra data after the first
+		// 32 bytes
+		if code := event[0] & 0x7f; code == x11Reply || code == x11GenericEvent {
+			extra := int64(binary.LittleEndian.Uint32(event[4:])) * 4
+			if _, err := io.CopyN(io.Discard, wm.conn, extra); err != nil {
+				return err
+			}
+			continue
+		}
+		wm.mu.Lock()
+		wm.handleEvent(event)
+		wm.mu.Unlock()
+	}
+}
+
+// Close drops the X connection, which ends Run
+func (wm *XWM) Close() error {
+	return wm.conn.Close()
+}
+
+func (wm *XWM) handleEvent(event []byte) {
+	u16 := func(offset int) uint16 { return binary.LittleEndian.Uint16(event[offset:]) }
+	i16 := func(offset int) int { return int(int16(u16(offset))) }
+	u32 := func(offset int) uint32 { return binary.LittleEndian.Uint32(event[offset:]) }
+
+	switch event[0] & 0x7f {
+	case x11Error:
+		log.Printf("Xwayland window manager: X error %d on request %d", event[1], event[10])
+
+	case x11CreateNotify:
+		id := u32(8)
+		wm.windows[id] = &xwmWindow{
+			id:               id,
+			bounds:           image.Rect(i16(12), i16(14), i16(12)+int(u16(16)), i16(14)+int(u16(18))),
+			overrideRedirect: event[22] != 0,
+		}
+
+	case x11DestroyNotify:
+		id := u32(8)
+		wm.unmapped(id)
+		delete(wm.windows, id)
+
+	case x11UnmapNotify:
+		wm.unmapped(u32(8))
+
+	case x11MapRequest:
+		id := u32(8)
+		wm.send(x11Request(x11MapWindow, 0, id))
+		wm.activate(id)
+
+	case x11MapNotify:
+		id := u32(8)
+		window := wm.windows[id]
+		if window == nil {
+			return
+		}
+		window.mapped = true
+		window.overrideRedirect = event[12] != 0
+		wm.stack = append(removeWindow(wm.stack, id), id)
+
+	case x11ConfigureRequest:
+		// Grant the request as asked, values follow the mask order
+		id, mask := u32(8), u16(26)
+		values := []uint32{uint32(int32(i16(16))), uint32(int32(i16(18))), uint32(u16(20)), uint32(u16(22)), uint32(u16(24)), u32(12), uint32(event[1])}
+		req := []byte{x11ConfigureWindow, 0, 0, 0}
+		req = binary.LittleEndian.AppendUint32(req, id)
+		req = binary.LittleEndian.AppendUint16(req, mask)
+		req = append(req, 0, 0)
+		for bit := 0; bit < x11ConfigureRequestValueCount; bit++ {
+			if mask&(1<<bit) != 0 {
+				req = binary.LittleEndian.AppendUint32(req, values[bit])
+			}
+		}
+		binary.LittleEndian.PutUint16(req[2:], uint16(len(req)/4))
+		wm.send(req)
+
+	case x11ConfigureNotify:
+		id := u32(8)
+		window := wm.windows[id]
+		if window == nil {
+			return
+		}
+		window.bounds = image.Rect(i16(16), i16(18), i16(16)+int(u16(20)), i16(18)+int(u16(22)))
+		window.overrideRedirect = event[26] != 0
+		if window.mapped {
+			wm.restack(id, u32(12))
+		}
+
+	case x11ClientMessage:
+		window := wm.windows[u32(4)]
+		if window == nil || event[1] != 32 {
+			return
+		}
+		switch u32(8) {
+		case wm.atomSurfaceSerial:
+			window.serial = uint64(u32(16))<<32 | uint64(u32(12))
+			window.hasSerial = true
+		case wm.atomSurfaceID:
+			window.surfaceID = protocols.ObjectID[protocols.WlSurface](u32(12))
+		}
+	}
+}
+
+// unmapped takes a window off the stack and passes focus on
+func (wm *XWM) unmapped(id uint32) {
+	if window := wm.windows[id]; window != nil {
+		window.mapped = false
+	}
+	wm.stack = removeWindow(wm.stack, id)
+	if wm.focused != id {
+		return
+	}
+	wm.focused = 0
+	for i := len(wm.stack) - 1; i >= 0; i-- {
+		if window := wm.windows[wm.stack[i]]; window != nil && !window.overrideRedirect {
+			wm.activate(window.id)
+			return
+		}
+	}
+}
+
+// restack moves a window directly above sibling, to the bottom when
+// sibling is 0, or to the top when sibling is not mapped
+func (wm *XWM) restack(id, sibling uint32) {
+	wm.stack = removeWindow(wm.stack, id)
+	at := len(wm.stack)
+	if sibling == 0 {
+		at = 0
+	}
+	for i, other := range wm.stack {
+		if other == sibling {
+			at = i + 1
+			break
+		}
+	}
+	wm.stack = append(wm.stack[:at], append([]uint32{id}, wm.stack[at:]...)...)
+}
+
+// activate raises a window and gives it the keyboard focus. The stack is
+// updated right away; the ConfigureNotify that follows agrees with it.
+func (wm *XWM) activate(id uint32) {
+	wm.send(x11Request(x11ConfigureWindow, 0, id, x11ConfigWindowStackMode, x11StackModeAbove))
+	wm.send(x11Request(x11SetInputFocus, x11RevertToPointerRoot, id, 0))
+	wm.focused = id
+	if window := wm.windows[id]; window != nil && window.mapped {
+		wm.stack = append(removeWindow(wm.stack, id), id)
+	}
+}
+
+// FocusAt raises and focuses the topmost managed window under a desktop
+// position, as a click would
+func (wm *XWM) FocusAt(x, y int) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	pt := image.Pt(x, y)
+	for i := len(wm.stack) - 1; i >= 0; i-- {
+		window := wm.windows[wm.stack[i]]
+		if window == nil || !pt.In(window.bounds) {
+			continue
+		}
+		if !window.overrideRedirect && window.id != wm.focused {
+			wm.activate(window.id)
+		}
+		return
+	}
+}
+
+// Arrange positions the Xwayland client's surfaces at their X window
+// positions and orders them by the X stacking order, keeping the slots
+// they already had among other clients' surfaces. Surfaces not yet paired
+// with a mapped window are left out.
+func (wm *XWM) Arrange(client *wayland.Client, placed []PlacedSurface) []PlacedSurface {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	depth := make(map[uint32]int, len(wm.stack))
+	for i, id := range wm.stack {
+		depth[id] = i
+	}
+
+	type stacked struct {
+		surface PlacedSurface
+		depth   int
+	}
+	var shown []stacked
+	for _, p := range placed {
+		if p.Client != client {
+			continue
+		}
+		window := wm.windowFor(p)
+		if window == nil || !window.mapped {
+			continue
+		}
+		p.X, p.Y = window.bounds.Min.X, window.bounds.Min.Y
+		shown = append(shown, stacked{p, depth[window.id]})
+	}
+	sort.SliceStable(shown, func(i, j int) bool { return shown[i].depth < shown[j].depth })
+
+	arranged := make([]PlacedSurface, 0, len(placed))
+	next := 0
+	for _, p := range placed {
+		if p.Client != client {
+			arranged = append(arranged, p)
+		} else if next < len(shown) {
+			arranged = append(arranged, shown[next].surface)
+			next++
+		}
+	}
+	return arranged
+}
+
+// windowFor finds the X window a surface belongs to
+func (wm *XWM) windowFor(p PlacedSurface) *xwmWindow {
+	serial, hasSerial := xwaylandSerial(p.Surface)
+	for _, window := range wm.windows {
+		if hasSerial && window.hasSerial && window.serial == serial {
+			return window
+		}
+		if window.surfaceID != 0 && window.surfaceID == p.SurfaceID {
+			return window
+		}
+	}
+	return nil
+}
+
+// send writes a request, logging failures; the event loop notices a dead
+// connection on its own
+func (wm *XWM) send(req []byte) {
+	if _, err := wm.conn.Write(req); err != nil {
+		log.Printf("Xwayland window manager: %v", err)
+	}
+}
+
+// x11Request encodes a request made of 32 bit values after the header
+func x11Request(opcode, data byte, values ...uint32) []byte {
+	req := []byte{opcode, data, 0, 0}
+	binary.LittleEndian.PutUint16(req[2:], uint16(1+len(values)))
+	for _, v := range values {
+		req = binary.LittleEndian.AppendUint32(req, v)
+	}
+	return req
+}
+
+// removeWindow returns ids without id
+func removeWindow(ids []uint32, id uint32) []uint32 {
+	for i, other := range ids {
+		if other == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
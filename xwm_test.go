@@ -0,0 +1,139 @@
+package compositor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/mmulet/term.everything/wayland"
+	"github.com/mmulet/term.everything/wayland/protocols"
+)
+
+// recordingConn collects the requests the window manager writes
+type recordingConn struct {
+	bytes.Buffer
+}
+
+func (c *recordingConn) Close() error { return nil }
+
+func newTestXWM() (*XWM, *recordingConn) {
+	conn := &recordingConn{}
+	return &XWM{
+		conn:              conn,
+		windows:           make(map[uint32]*xwmWindow),
+		atomSurfaceID:     100,
+		atomSurfaceSerial: 101,
+	}, conn
+}
+
+// x11Event builds a 32 byte event with little endian fields at offsets
+func x11Event(code byte, fields map[int]uint32) []byte {
+	event := make([]byte, 32)
+	event[0] = code
+	for offset, v := range fields {
+		binary.LittleEndian.PutUint32(event[offset:], v)
+	}
+	return event
+}
+
+// mapTestWindow creates and maps a window the way Xwayland reports it
+func mapTestWindow(wm *XWM, id uint32, x, y, w, h int, serial uint64) {
+	wm.handleEvent(x11Event(x11CreateNotify, map[int]uint32{
+		8: id, 12: uint32(uint16(x)) | uint32(uint16(y))<<16, 16: uint32(w) | uint32(h)<<16,
+	}))
+	message := x11Event(x11ClientMessage, map[int]uint32{
+		4: id, 8: wm.atomSurfaceSerial, 12: uint32(serial), 16: uint32(serial >> 32),
+	})
+	message[1] = 32 // format
+	wm.handleEvent(message)
+	wm.windows[id].mapped = true
+	wm.stack = append(wm.stack, id)
+}
+
+func addXwaylandSurface(c *wayland.Client, id protocols.ObjectID[protocols.WlSurface], serial uint64) {
+	surface := addTestSurface(c, id, 0, 0, 0, 10, 10)
+	surface.Role = &wayland.SurfaceRoleXWaylandSurface{
+		Data: &wayland.SurfaceRoleWaylandSurfaceData{
+			Serial: &wayland.XWaylandSurfaceV1Serial{Low: uint32(serial), Hi: uint32(serial >> 32)},
+		},
+	}
+}
+
+func TestXWMMapRequestMapsAndFocuses(t *testing.T) {
+	wm, conn := newTestXWM()
+	wm.handleEvent(x11Event(x11MapRequest, map[int]uint32{8: 7}))
+
+	want := append(x11Request(x11MapWindow, 0, 7), x11Request(x11ConfigureWindow, 0, 7, x11ConfigWindowStackMode, x11StackModeAbove)...)
+	want = append(want, x11Request(x11SetInputFocus, x11RevertToPointerRoot, 7, 0)...)
+	if !bytes.Equal(conn.Bytes(), want) {
+		t.Errorf("Expected map, raise and focus requests, got % x", conn.Bytes())
+	}
+	if wm.focused != 7 {
+		t.Errorf("Expected window 7 focused, got %d", wm.focused)
+	}
+}
+
+func TestXWMArrangeFollowsWindows(t *testing.T) {
+	wm, _ := newTestXWM()
+	mapTestWindow(wm, 1, 40, 30, 10, 10, 1<<32|5)
+	mapTestWindow(wm, 2, 200, 100, 10, 10, 6)
+
+	xwayland := wayland.MakeClient(nil)
+	other := wayland.MakeClient(nil)
+	addXwaylandSurface(xwayland, 20, 6)
+	addXwaylandSurface(xwayland, 21, 1<<32|5)
+	addXwaylandSurface(xwayland, 22, 9) // no window yet
+	addTestSurface(other, 30, 0, 0, 0, 10, 10)
+
+	placed := NewSurfaceSnapshots().Collect([]*wayland.Client{xwayland, other}, image.Rect(0, 0, 800, 600))
+	arranged := wm.Arrange(xwayland, placed)
+	if len(arranged) != 3 {
+		t.Fatalf("Expected the unpaired surface to be dropped, got %d surfaces", len(arranged))
+	}
+	if arranged[0].SurfaceID != 21 || arranged[1].SurfaceID != 20 {
+		t.Errorf("Expected surfaces in X stacking order 21, 20, got %d, %d", arranged[0].SurfaceID, arranged[1].SurfaceID)
+	}
+	if arranged[0].X != 40 || arranged[0].Y != 30 || arranged[1].X != 200 || arranged[1].Y != 100 {
+		t.Errorf("Expected surfaces at their window positions, got (%d, %d) and (%d, %d)",
+			arranged[0].X, arranged[0].Y, arranged[1].X, arranged[1].Y)
+	}
+	if arranged[2].Client != other {
+		t.Errorf("Expected the other client's surface to keep its slot")
+	}
+
+	// Clicking the lower window raises it
+	wm.FocusAt(45, 35)
+	arranged = wm.Arrange(xwayland, placed)
+	if arranged[1].SurfaceID != 21 {
+		t.Errorf("Expected the clicked window on top after FocusAt")
+	}
+}
+
+func TestXWMUnmapPassesFocus(t *testing.T) {
+	wm, _ := newTestXWM()
+	mapTestWindow(wm, 1, 0, 0, 10, 10, 1)
+	mapTestWindow(wm, 2, 0, 0, 10, 10, 2)
+	wm.focused = 2
+
+	wm.handleEvent(x11Event(x11UnmapNotify, map[int]uint32{8: 2}))
+	if wm.focused != 1 {
+		t.Errorf("Expected focus to pass to window 1, got %d", wm.focused)
+	}
+	if len(wm.stack) != 1 || wm.stack[0] != 1 {
+		t.Errorf("Expected only window 1 stacked, got %v", wm.stack)
+	}
+}
+
+func TestParseSetupRoot(t *testing.T) {
+	data := make([]byte, 32+8+8+40)
+	binary.LittleEndian.PutUint16(data[16:], 5) // vendor, padded to 8
+	data[20] = 1                                // screens
+	data[21] = 1                                // formats
+	binary.LittleEndian.PutUint32(data[32+8+8:], 0x2a)
+
+	root, err := parseSetupRoot(data)
+	if err != nil || root != 0x2a {
+		t.Errorf("Expected root 0x2a, got %#x (%v)", root, err)
+	}
+}